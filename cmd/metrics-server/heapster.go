@@ -19,42 +19,63 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
 
 	"github.com/kubernetes-incubator/metrics-server/common/flags"
 	kube_config "github.com/kubernetes-incubator/metrics-server/common/kubernetes"
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/canary"
 	"github.com/kubernetes-incubator/metrics-server/metrics/cmd/heapster-apiserver/app"
+	"github.com/kubernetes-incubator/metrics-server/metrics/conformance"
 	"github.com/kubernetes-incubator/metrics-server/metrics/core"
 	"github.com/kubernetes-incubator/metrics-server/metrics/manager"
+	"github.com/kubernetes-incubator/metrics-server/metrics/operator"
 	"github.com/kubernetes-incubator/metrics-server/metrics/options"
 	"github.com/kubernetes-incubator/metrics-server/metrics/processors"
+	"github.com/kubernetes-incubator/metrics-server/metrics/replay"
+	"github.com/kubernetes-incubator/metrics-server/metrics/simulation"
 	"github.com/kubernetes-incubator/metrics-server/metrics/sinks"
 	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/snapshot"
 	"github.com/kubernetes-incubator/metrics-server/metrics/sources"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/summary"
 	"github.com/kubernetes-incubator/metrics-server/metrics/util"
 	"github.com/kubernetes-incubator/metrics-server/version"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apiserver/pkg/server/healthz"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	"k8s.io/apiserver/pkg/util/flag"
 	"k8s.io/apiserver/pkg/util/logs"
 	kube_client "k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1beta2"
 	v1listers "k8s.io/client-go/listers/core/v1"
+	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/transport"
 )
 
 func main() {
 	opt := options.NewHeapsterRunOptions()
 	opt.AddFlags(pflag.CommandLine)
+	utilfeature.DefaultFeatureGate.AddFlag(pflag.CommandLine)
 
 	flag.InitFlags()
 
@@ -66,64 +87,468 @@ func main() {
 	logs.InitLogs()
 	defer logs.FlushLogs()
 
+	// Set once the sources are created, before the config watcher is run.
+	var collectionModeSetter summary.CollectionModeSetter
+	var configWatcher *operator.ConfigWatcher
+	if opt.ConfigResource != "" {
+		configWatcher = applyConfigResourceOrDie(opt, pflag.CommandLine, func() error {
+			if collectionModeSetter == nil {
+				return nil
+			}
+			return collectionModeSetter.SetCollectionMode(opt.CollectionMode)
+		})
+	}
+
 	setLabelSeperator(opt)
 	setMaxProcs(opt)
 	glog.Infof(strings.Join(os.Args, " "))
 	glog.Infof("Metrics Server version %v", version.MetricsServerVersion)
-	if err := validateFlags(opt); err != nil {
+	if err := opt.Validate(); err != nil {
+		glog.Fatal(err)
+	}
+	setKubeletAddressFamily(opt)
+	setBindAddressFamily(opt, pflag.CommandLine)
+	if opt.KubeletStreamingInterval > 0 {
+		glog.Infof("Streaming the kubelet summaries every %s", opt.KubeletStreamingInterval)
+	}
+	if opt.PushMaxAge > 0 {
+		summary.SetPushMaxAge(opt.PushMaxAge)
+		glog.Infof("Accepting pushed summaries on %s for %s", summary.PushPath, opt.PushMaxAge)
+	}
+	if err := util.SetControlPlaneMode(opt.ControlPlaneNodes); err != nil {
 		glog.Fatal(err)
 	}
+	if args := pflag.Args(); len(args) > 0 {
+		runCommandOrDie(opt, args)
+		return
+	}
 
 	kubernetesUrl, err := getKubernetesAddress(opt.Sources)
 	if err != nil {
 		glog.Fatalf("Failed to get kubernetes address: %v", err)
 	}
-	sourceManager := createSourceManagerOrDie(opt.Sources)
-	sinkManager, metricSink := createAndInitSinksOrDie(opt.Sinks)
+	eventBus := bus.NewBus(bus.DefaultSubscriberBufferSize)
+	sinkManager, metricSink := createAndInitSinksOrDie(opt.Sinks, remoteWriteConfig(opt), eventBus)
+	prometheus.MustRegister(metricsink.NewContainerMetricsCollector(metricSink))
+
+	podLister, nodeLister, replicaSetLister, canListPods := getListersOrDie(opt, kubernetesUrl)
+	setStoreMemoryBudget(opt, metricSink)
+	metricSink.SetMaxDelay(opt.GetMaxMetricsDelay())
+	setRetentionPolicyOrDie(opt, metricSink, podLister, nodeLister)
+	if opt.StatusResource != "" {
+		createStatusPublisherOrDie(opt, kubernetesUrl, nodeLister).Subscribe(eventBus)
+	}
+	if opt.EventPod != "" {
+		createDegradationReporterOrDie(opt, kubernetesUrl, nodeLister).Subscribe(eventBus)
+	}
+	if opt.APIServiceService != "" {
+		go createAPIServiceCheckerOrDie(opt, kubernetesUrl).Run(operator.DefaultAPIServiceCheckInterval, wait.NeverStop)
+	}
+	if opt.RestoreSnapshotMaxAge > 0 {
+		// Only served, the batch is not exported again by the other sinks.
+		if restored, err := snapshot.Restore(opt.SnapshotFile, metricSink, opt.RestoreSnapshotMaxAge, opt.SnapshotMaxSize); err != nil {
+			glog.Warningf("Failed to restore snapshot %s: %v", opt.SnapshotFile, err)
+		} else if restored {
+			glog.Infof("Serving metrics restored from snapshot %s until the first scrape", opt.SnapshotFile)
+		}
+	}
+	if opt.SnapshotFile != "" {
+		snapshot.NewWriter(opt.SnapshotFile).Subscribe(eventBus)
+	}
 
-	podLister, nodeLister := getListersOrDie(kubernetesUrl)
-	dataProcessors := createDataProcessorsOrDie(kubernetesUrl, podLister)
+	// Failed scrapes are only classified by the summary source.
+	var scrapeFailures func() summary.ScrapeFailures
+	if opt.SnapshotSource != "" {
+		glog.Infof("Serving metrics from snapshot %s", opt.SnapshotSource)
+		go createSnapshotReaderOrDie(opt).Run(sinkManager, snapshot.DefaultPollInterval, wait.NeverStop)
+	} else {
+		if opt.CanaryPrimary != "" {
+			startCanaryOrDie(opt, eventBus)
+		}
+		if opt.ShardCount > 1 {
+			glog.Infof("Scraping shard %d of %d of the nodes", opt.ShardIndex, opt.ShardCount)
+		}
+		sourceManager, sourceProvider := createSourceManagerOrDie(opt, eventBus, podLister)
+		if reporter, ok := sourceProvider.(summary.ScrapeFailureReporter); ok {
+			scrapeFailures = reporter.GetScrapeFailures
+		}
+		if setter, ok := sourceProvider.(summary.CollectionModeSetter); ok {
+			collectionModeSetter = setter
+			glog.Infof("Collecting %s metrics from the kubelet summaries", opt.CollectionMode)
+		}
+		processorsUrl := util.WithNodeSelector(kube_config.WithRateLimits(kubernetesUrl, opt.InformerAPIQPS, opt.InformerAPIBurst), opt.NodeSelector)
+		dataProcessors := createDataProcessorsOrDie(processorsUrl, podLister, opt.TenantTemplate)
+		man, err := manager.NewManager(sourceManager, dataProcessors, sinkManager,
+			opt.MetricResolution, manager.DefaultScrapeOffset, manager.DefaultMaxParallelism)
+		if err != nil {
+			glog.Fatalf("Failed to create main manager: %v", err)
+		}
+		man.Start()
+	}
+	if configWatcher != nil {
+		go configWatcher.Run(operator.DefaultConfigWatchInterval, wait.NeverStop)
+	}
 
-	man, err := manager.NewManager(sourceManager, dataProcessors, sinkManager,
-		opt.MetricResolution, manager.DefaultScrapeOffset, manager.DefaultMaxParallelism)
-	if err != nil {
-		glog.Fatalf("Failed to create main manager: %v", err)
+	if opt.APIServiceScrapeCondition {
+		if scrapeFailures != nil {
+			kubeClient := createKubeClientOrDie(kubernetesUrl, opt.KubeAPIQPS, opt.KubeAPIBurst)
+			operator.NewScrapeConditionPublisher(kubeClient.Discovery().RESTClient(), scrapeFailures).Subscribe(eventBus)
+		} else {
+			glog.Warningf("Not publishing the scrape condition of the APIService, the source doesn't report failed scrapes")
+		}
+	}
+
+	if opt.CanaryPrimary != "" {
+		glog.Fatal(serveCanary(opt, metricSink))
 	}
-	man.Start()
 
 	// Run API server
-	server, err := app.NewHeapsterApiServer(opt, metricSink, nodeLister, podLister)
+	servedPodLister := podLister
+	if !canListPods {
+		servedPodLister = nil
+	}
+	server, err := app.NewHeapsterApiServer(opt, metricSink, eventBus, nodeLister, servedPodLister, replicaSetLister, scrapeFailures)
 	if err != nil {
 		glog.Fatalf("Could not create the API server: %v", err)
 	}
 	server.AddHealthzChecks(healthzChecker(metricSink))
+	// The latest batch only holds the nodes of the shard.
+	shard := util.Shard{Index: opt.ShardIndex, Count: opt.ShardCount}
+	readinessChecks := []healthz.HealthzChecker{}
+	if opt.ReadyThreshold > 0 {
+		readinessChecks = append(readinessChecks, operator.NewFreshnessCheck(metricSink.GetLatestDataBatch, nodeLister, shard, opt.ReadyThreshold, 2*opt.MetricResolution))
+	}
+	server.Handler.NonGoRestfulMux.Handle(operator.ReadinessPath, operator.NewReadinessHandler(readinessChecks...))
+	go operator.NewSLITracker(metricSink.GetLatestDataBatch, nodeLister, shard, 2*opt.MetricResolution).Run(opt.MetricResolution, wait.NeverStop)
+	if !canListPods {
+		// Listed as passing by /healthz?verbose, so probes tell the reduced mode apart.
+		server.AddHealthzChecks(healthz.NamedCheck("node-metrics-only", func(r *http.Request) error { return nil }))
+	}
+	server.InstallConfigHandler(pflag.CommandLine)
 
 	glog.Infof("Starting Heapster API server...")
 	glog.Fatal(server.RunServer())
 }
 
-func createSourceManagerOrDie(src flags.Uris) core.MetricsSource {
-	if len(src) != 1 {
+// runCommandOrDie runs the command given as arguments instead of the server. "config export"
+// prints a profile of the configuration and the cluster for reproducing issues, which
+// "config replay" simulates.
+func runCommandOrDie(opt *options.HeapsterRunOptions, args []string) {
+	switch command := strings.Join(args, " "); command {
+	case "config export":
+	case "generate-alerts":
+		if err := operator.WriteAlertRules(os.Stdout, opt); err != nil {
+			glog.Fatalf("Failed to write alert rules: %v", err)
+		}
+		return
+	case "conformance":
+		runConformanceOrDie(opt)
+		return
+	case "config replay":
+		runProfileReplayOrDie(opt)
+		return
+	case "replay":
+		runReplayOrDie(opt)
+		return
+	default:
+		glog.Fatalf("Unknown command %q, only \"config export\", \"config replay\", \"generate-alerts\", \"conformance\" and \"replay\" are supported", command)
+	}
+	kubernetesUrl, err := getKubernetesAddress(opt.Sources)
+	if err != nil {
+		glog.Fatalf("Failed to get kubernetes address: %v", err)
+	}
+	kubeClient := createKubeClientOrDie(kubernetesUrl, opt.KubeAPIQPS, opt.KubeAPIBurst)
+	nodes, err := kubeClient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Fatalf("Failed to list nodes: %v", err)
+	}
+	pods, err := kubeClient.CoreV1().Pods(corev1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		glog.Fatalf("Failed to list pods: %v", err)
+	}
+	if err := app.WriteProfile(os.Stdout, pflag.CommandLine, nodes.Items, pods.Items); err != nil {
+		glog.Fatalf("Failed to write profile: %v", err)
+	}
+}
+
+// runConformanceOrDie checks the Metrics API served in the cluster and prints the report.
+// Usage older than three metric resolutions counts as stale, which leaves time for a
+// scrape to be retried.
+func runConformanceOrDie(opt *options.HeapsterRunOptions) {
+	kubernetesUrl, err := getKubernetesAddress(opt.Sources)
+	if err != nil {
+		glog.Fatalf("Failed to get kubernetes address: %v", err)
+	}
+	kubeClient := createKubeClientOrDie(kubernetesUrl, opt.KubeAPIQPS, opt.KubeAPIBurst)
+	nodes, err := kubeClient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Fatalf("Failed to list nodes: %v", err)
+	}
+	pods, err := kubeClient.CoreV1().Pods(corev1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		glog.Fatalf("Failed to list pods: %v", err)
+	}
+	report := conformance.NewChecker(kubeClient.Discovery().RESTClient(), nodes.Items, pods.Items, 3*opt.MetricResolution).Run()
+	if err := report.Write(os.Stdout); err != nil {
+		glog.Fatalf("Failed to write conformance report: %v", err)
+	}
+	if failed := report.Failed(); failed > 0 {
+		glog.Fatalf("%d conformance checks failed", failed)
+	}
+}
+
+// runReplayOrDie sends the Metrics API requests of the --replay_from log to the cluster and
+// prints how they were served.
+func runReplayOrDie(opt *options.HeapsterRunOptions) {
+	if opt.ReplayFrom == "" {
+		glog.Fatalf("The replay command requires --replay_from")
+	}
+	file, err := os.Open(opt.ReplayFrom)
+	if err != nil {
+		glog.Fatalf("Failed to open the replayed log: %v", err)
+	}
+	requests, err := replay.ParseLog(file)
+	file.Close()
+	if err != nil {
+		glog.Fatalf("Failed to read the replayed log: %v", err)
+	}
+	kubernetesUrl, err := getKubernetesAddress(opt.Sources)
+	if err != nil {
+		glog.Fatalf("Failed to get kubernetes address: %v", err)
+	}
+	kubeConfig := createKubeConfigOrDie(kubernetesUrl, 0, 0)
+	if opt.ReplayTarget != "" {
+		// Test instances serve self-signed certificates.
+		kubeConfig.Host = opt.ReplayTarget
+		kubeConfig.TLSClientConfig.Insecure = true
+		kubeConfig.TLSClientConfig.CAFile = ""
+		kubeConfig.TLSClientConfig.CAData = nil
+	}
+	client, err := replay.NewClient(kubeConfig, requests, opt.ReplaySpeed, opt.ReplayConcurrency)
+	if err != nil {
+		glog.Fatalf("Failed to create the replay client: %v", err)
+	}
+	glog.Infof("Replaying %d requests at %vx speed to %s", len(requests), opt.ReplaySpeed, kubeConfig.Host)
+	summary := replay.NewReplayer(client, opt.ReplaySpeed, opt.ReplayConcurrency).Run(requests)
+	if err := summary.Write(os.Stdout); err != nil {
+		glog.Fatalf("Failed to write replay summary: %v", err)
+	}
+}
+
+// runProfileReplayOrDie runs the configuration of the --replay_profile against a generated
+// cluster of its shape, and prints how long the scrape cycles took. Only the processors and
+// the sink which don't need the API server run.
+func runProfileReplayOrDie(opt *options.HeapsterRunOptions) {
+	if opt.ReplayProfile == "" {
+		glog.Fatalf("The config replay command requires --replay_profile")
+	}
+	file, err := os.Open(opt.ReplayProfile)
+	if err != nil {
+		glog.Fatalf("Failed to open the replayed profile: %v", err)
+	}
+	profile, err := simulation.ReadProfile(file)
+	file.Close()
+	if err != nil {
+		glog.Fatalf("Failed to read the replayed profile: %v", err)
+	}
+	skipped, err := profile.Apply(pflag.CommandLine)
+	if err != nil {
+		glog.Fatalf("Failed to apply the replayed profile: %v", err)
+	}
+	if len(skipped) > 0 {
+		glog.Infof("Not replaying the flags %s of the profile", strings.Join(skipped, ", "))
+	}
+	if err := opt.Validate(); err != nil {
+		glog.Fatal(err)
+	}
+	setLabelSeperator(opt)
+	setMaxProcs(opt)
+
+	cluster := simulation.NewCluster(profile.Cluster)
+	cluster.ResourcesOnly = opt.CollectionMode == util.CollectionResources
+	sourceManager, err := sources.NewLimitedSourceManager(cluster, sources.DefaultMetricsScrapeTimeout,
+		opt.MaxScrapeInFlight, opt.ScrapeSpread, bus.NewBus(bus.DefaultSubscriberBufferSize))
+	if err != nil {
+		glog.Fatalf("Failed to create source manager: %v", err)
+	}
+	podLister, nodeLister := cluster.PodLister(), cluster.NodeLister()
+	podBasedEnricher, err := processors.NewPodBasedEnricher(podLister)
+	if err != nil {
+		glog.Fatalf("Failed to create PodBasedEnricher: %v", err)
+	}
+	dataProcessors := append([]core.DataProcessor{
+		processors.NewTimestampRegressionGuard(processors.DefaultMaxRegressionHold),
+		processors.NewRateCalculator(core.RateMetricsMapping),
+		podBasedEnricher,
+	}, createAggregators()...)
+	metricSink, _ := sinks.NewSinkFactory().BuildAll(nil, sinks.RemoteWriteConfig{})
+	setStoreMemoryBudget(opt, metricSink)
+	metricSink.SetMaxDelay(opt.GetMaxMetricsDelay())
+	setRetentionPolicyOrDie(opt, metricSink, podLister, nodeLister)
+
+	glog.Infof("Replaying %d scrape cycles of %d nodes, %d pods and %d containers", opt.SimulationCycles,
+		profile.Cluster.Nodes, profile.Cluster.Pods, profile.Cluster.Containers)
+	report := simulation.NewSimulation(sourceManager, dataProcessors, metricSink).Run(opt.SimulationCycles, opt.MetricResolution)
+	if err := report.Write(os.Stdout); err != nil {
+		glog.Fatalf("Failed to write replay report: %v", err)
+	}
+}
+
+// applyConfigResourceOrDie applies the MetricsServerConfig and returns a watcher applying
+// later changes of the runtime flags, which apply puts into effect.
+func applyConfigResourceOrDie(opt *options.HeapsterRunOptions, fs *pflag.FlagSet, apply func() error) *operator.ConfigWatcher {
+	namespace, name, err := operator.ParseResourceName(opt.ConfigResource)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	kubernetesUrl, err := getKubernetesAddress(opt.Sources)
+	if err != nil {
+		glog.Fatalf("Failed to get kubernetes address: %v", err)
+	}
+	kubeClient := createKubeClientOrDie(kubernetesUrl, opt.KubeAPIQPS, opt.KubeAPIBurst)
+	// Created first, so that it can tell the flags given on the command line apart.
+	watcher := operator.NewConfigWatcher(kubeClient.Discovery().RESTClient(), namespace, name, fs, apply)
+	config, err := operator.GetConfig(kubeClient.Discovery().RESTClient(), namespace, name)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if err := operator.ApplyConfig(config, fs); err != nil {
+		glog.Fatalf("Invalid %s %s: %v", operator.Kind, opt.ConfigResource, err)
+	}
+	glog.Infof("Applied settings from %s %s", operator.Kind, opt.ConfigResource)
+	return watcher
+}
+
+func createStatusPublisherOrDie(opt *options.HeapsterRunOptions, kubernetesUrl *url.URL, nodeLister v1listers.NodeLister) *operator.StatusPublisher {
+	namespace, name, err := operator.ParseResourceName(opt.StatusResource)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	kubeClient := createKubeClientOrDie(kubernetesUrl, opt.KubeAPIQPS, opt.KubeAPIBurst)
+	return operator.NewStatusPublisher(kubeClient.Discovery().RESTClient(), namespace, name, nodeLister)
+}
+
+func createDegradationReporterOrDie(opt *options.HeapsterRunOptions, kubernetesUrl *url.URL, nodeLister v1listers.NodeLister) *operator.DegradationReporter {
+	namespace, name, err := operator.ParseResourceName(opt.EventPod)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	kubeClient := createKubeClientOrDie(kubernetesUrl, opt.KubeAPIQPS, opt.KubeAPIBurst)
+	// Batches normally reach the store within the scrape offset and timeout, well within
+	// half of the resolution.
+	return operator.NewDegradationReporter(kubeClient.Discovery().RESTClient(), namespace, name, nodeLister,
+		opt.CompletenessThreshold, opt.MetricResolution/2)
+}
+
+func createAPIServiceCheckerOrDie(opt *options.HeapsterRunOptions, kubernetesUrl *url.URL) *operator.APIServiceChecker {
+	namespace, name, err := operator.ParseResourceName(opt.APIServiceService)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	var caBundle []byte
+	if opt.APIServiceCAFile != "" {
+		caBundle, err = ioutil.ReadFile(opt.APIServiceCAFile)
+		if err != nil {
+			glog.Fatalf("Failed to read the APIService CA bundle: %v", err)
+		}
+	}
+	kubeClient := createKubeClientOrDie(kubernetesUrl, opt.KubeAPIQPS, opt.KubeAPIBurst)
+	return operator.NewAPIServiceChecker(kubeClient.Discovery().RESTClient(), namespace, name, caBundle, opt.ReconcileAPIService)
+}
+
+func createSnapshotReaderOrDie(opt *options.HeapsterRunOptions) *snapshot.Reader {
+	return createSnapshotReaderForOrDie(opt.SnapshotSource, opt.SnapshotSourceCAFile, opt.SnapshotMaxSize)
+}
+
+func createSnapshotReaderForOrDie(source, caFile string, maxSize int64) *snapshot.Reader {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return snapshot.NewReader(source, maxSize)
+	}
+	client, err := snapshot.NewClient(caFile)
+	if err != nil {
+		glog.Fatalf("Failed to create snapshot client: %v", err)
+	}
+	return snapshot.NewRemoteReader(source, client, maxSize)
+}
+
+// startCanaryOrDie compares the processed batches with those of the primary instance. The
+// sources only scrape the canary share of the nodes.
+func startCanaryOrDie(opt *options.HeapsterRunOptions, eventBus *bus.Bus) {
+	reader := createSnapshotReaderForOrDie(opt.CanaryPrimary, opt.SnapshotSourceCAFile, opt.SnapshotMaxSize)
+	canary.NewComparer(reader, opt.CanaryNodeShare).Subscribe(eventBus)
+	glog.Infof("Running as canary of %s, scraping %v of the nodes", opt.CanaryPrimary, opt.CanaryNodeShare)
+}
+
+// serveCanary serves the metrics and health of a canary, which doesn't serve the Metrics API.
+func serveCanary(opt *options.HeapsterRunOptions, metricSink *metricsink.MetricSink) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.Handler())
+	healthz.InstallHandler(mux, healthzChecker(metricSink))
+	addr := net.JoinHostPort(opt.Ip, strconv.Itoa(opt.Port))
+	glog.Infof("Serving canary metrics on %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// createSourceManagerOrDie returns the source manager scraping the sources of the provider
+// it also returns. The sources share the pod lister rather than watching pods themselves.
+func createSourceManagerOrDie(opt *options.HeapsterRunOptions, eventBus *bus.Bus, podLister v1listers.PodLister) (core.MetricsSource, core.MetricsSourceProvider) {
+	if len(opt.Sources) != 1 {
 		glog.Fatal("Wrong number of sources specified")
 	}
-	sourceFactory := sources.NewSourceFactory()
+	// The sources list and watch nodes and pods with the informer rate limits, stream
+	// the summaries with at most as many requests in flight as the scrapes, only scrape the
+	// selected nodes, and the share of them of a canary, collect the metrics of the
+	// collection mode from the kubelet metrics endpoint, and scrape dual-stack nodes at their
+	// addresses of the preferred family.
+	uri := kube_config.WithRateLimits(&opt.Sources[0].Val, opt.InformerAPIQPS, opt.InformerAPIBurst)
+	uri = summary.WithStreaming(uri, opt.KubeletStreamingInterval, opt.MaxScrapeInFlight)
+	uri = summary.WithShard(uri, util.Shard{Index: opt.ShardIndex, Count: opt.ShardCount})
+	uri = util.WithNodeSelector(uri, opt.NodeSelector)
+	if opt.CanaryPrimary != "" {
+		uri = summary.WithNodeShare(uri, opt.CanaryNodeShare)
+	}
+	uri = summary.WithCollectionMode(uri, opt.CollectionMode)
+	uri = summary.WithKubeletMetricsEndpoint(uri, opt.KubeletMetricsEndpoint)
+	if opt.KubeletMetricsEndpoint == util.KubeletEndpointResource {
+		glog.Infof("Scraping the kubelets on their resource metrics endpoint")
+	}
+	uri = kubelet.WithPreferredAddressFamily(uri, opt.KubeletPreferredAddressFamily)
+	src := flags.Uris{{Key: opt.Sources[0].Key, Val: *uri}}
+	sourceFactory := sources.NewSourceFactory(podLister)
 	sourceProvider, err := sourceFactory.BuildAll(src)
 	if err != nil {
 		glog.Fatalf("Failed to create source provide: %v", err)
 	}
-	sourceManager, err := sources.NewSourceManager(sourceProvider, sources.DefaultMetricsScrapeTimeout)
+	sourceManager, err := sources.NewLimitedSourceManager(sourceProvider, sources.DefaultMetricsScrapeTimeout,
+		opt.MaxScrapeInFlight, opt.ScrapeSpread, eventBus)
 	if err != nil {
 		glog.Fatalf("Failed to create source manager: %v", err)
 	}
-	return sourceManager
+	return sourceManager, sourceProvider
 }
 
-func createAndInitSinksOrDie(sinkAddresses flags.Uris) (core.DataSink, *metricsink.MetricSink) {
+func remoteWriteConfig(opt *options.HeapsterRunOptions) sinks.RemoteWriteConfig {
+	return sinks.RemoteWriteConfig{
+		URL: opt.RemoteWriteURL,
+		TLS: transport.TLSConfig{
+			CAFile:   opt.RemoteWriteCAFile,
+			CertFile: opt.RemoteWriteCertFile,
+			KeyFile:  opt.RemoteWriteKeyFile,
+			Insecure: opt.RemoteWriteInsecure,
+		},
+		BearerTokenFile:  opt.RemoteWriteBearerTokenFile,
+		Timeout:          opt.RemoteWriteTimeout,
+		AnonymizeKeyFile: opt.RemoteWriteAnonymizeKeyFile,
+	}
+}
+
+func createAndInitSinksOrDie(sinkAddresses flags.Uris, remoteWrite sinks.RemoteWriteConfig, eventBus *bus.Bus) (core.DataSink, *metricsink.MetricSink) {
 	sinksFactory := sinks.NewSinkFactory()
-	metricSink, sinkList := sinksFactory.BuildAll(sinkAddresses)
+	metricSink, sinkList := sinksFactory.BuildAll(sinkAddresses, remoteWrite)
 	if metricSink == nil {
 		glog.Fatal("Failed to create metric sink")
 	}
+	sinkList = append(sinkList, bus.NewSink(eventBus))
 	for _, sink := range sinkList {
 		glog.Infof("Starting with %s", sink.Name())
 	}
@@ -134,30 +559,82 @@ func createAndInitSinksOrDie(sinkAddresses flags.Uris) (core.DataSink, *metricsi
 	return sinkManager, metricSink
 }
 
-func getListersOrDie(kubernetesUrl *url.URL) (v1listers.PodLister, v1listers.NodeLister) {
-	kubeClient := createKubeClientOrDie(kubernetesUrl)
-
-	podLister, err := getPodLister(kubeClient)
-	if err != nil {
-		glog.Fatalf("Failed to create podLister: %v", err)
+// getListersOrDie returns the listers, and whether pods can be listed. If they can't, the
+// pod lister is always empty and only NodeMetrics can be served.
+func getListersOrDie(opt *options.HeapsterRunOptions, kubernetesUrl *url.URL) (v1listers.PodLister, v1listers.NodeLister, appslisters.ReplicaSetLister, bool) {
+	kubeConfig := createKubeConfigOrDie(kubernetesUrl, opt.InformerAPIQPS, opt.InformerAPIBurst)
+	kubeClient := kube_client.NewForConfigOrDie(kubeConfig)
+
+	canListPods := checkPermissions(kubeClient)
+	var podLister v1listers.PodLister
+	if canListPods {
+		var err error
+		podLister, err = getPodLister(kubeConfig)
+		if err != nil {
+			glog.Fatalf("Failed to create podLister: %v", err)
+		}
+	} else {
+		podLister = v1listers.NewPodLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}))
 	}
-	nodeLister, _, err := util.GetNodeLister(kubeClient)
+	// Validated with the options.
+	nodeSelector, _ := labels.Parse(opt.NodeSelector)
+	nodeLister, _, err := util.GetNodeLister(kubeClient, nodeSelector)
 	if err != nil {
 		glog.Fatalf("Failed to create nodeLister: %v", err)
 	}
-	return podLister, nodeLister
+	replicaSetLister, err := getReplicaSetLister(kubeClient)
+	if err != nil {
+		glog.Fatalf("Failed to create replicaSetLister: %v", err)
+	}
+	return podLister, nodeLister, replicaSetLister, canListPods
 }
 
-func createKubeClientOrDie(kubernetesUrl *url.URL) *kube_client.Clientset {
+// checkPermissions logs the missing RBAC permissions and returns whether pods can be listed.
+// Without them, only NodeMetrics are served, e.g. for clusters which only want
+// kubectl top node.
+func checkPermissions(kubeClient *kube_client.Clientset) bool {
+	missing, err := operator.MissingPermissions(kubeClient.AuthorizationV1().SelfSubjectAccessReviews(), operator.RequiredPermissions)
+	if err != nil {
+		// The check is best effort, the listers report their own errors.
+		glog.Warningf("Failed to check RBAC permissions: %v", err)
+		return true
+	}
+	for _, permission := range missing {
+		glog.Warningf("Missing RBAC permission to %s", permission)
+	}
+	canListPods := operator.CanListPods(missing)
+	if !canListPods {
+		glog.Warningf("Not allowed to list and watch pods, serving only NodeMetrics")
+	}
+	operator.SetNodeMetricsOnly(!canListPods)
+	return canListPods
+}
+
+// createKubeClientOrDie creates a client limited to the QPS and burst, unless they're zero.
+func createKubeClientOrDie(kubernetesUrl *url.URL, qps float32, burst int) *kube_client.Clientset {
+	return kube_client.NewForConfigOrDie(createKubeConfigOrDie(kubernetesUrl, qps, burst))
+}
+
+// createKubeConfigOrDie creates the config of a client limited to the QPS and burst, unless
+// they're zero.
+func createKubeConfigOrDie(kubernetesUrl *url.URL, qps float32, burst int) *restclient.Config {
 	kubeConfig, err := kube_config.GetKubeClientConfig(kubernetesUrl)
 	if err != nil {
 		glog.Fatalf("Failed to get client config: %v", err)
 	}
-	return kube_client.NewForConfigOrDie(kubeConfig)
+	if qps > 0 {
+		kubeConfig.QPS = qps
+	}
+	if burst > 0 {
+		kubeConfig.Burst = burst
+	}
+	return kubeConfig
 }
 
-func createDataProcessorsOrDie(kubernetesUrl *url.URL, podLister v1listers.PodLister) []core.DataProcessor {
+func createDataProcessorsOrDie(kubernetesUrl *url.URL, podLister v1listers.PodLister, tenantTemplate string) []core.DataProcessor {
 	dataProcessors := []core.DataProcessor{
+		// Keep newer samples if a node returns older ones
+		processors.NewTimestampRegressionGuard(processors.DefaultMaxRegressionHold),
 		// Convert cumulative to rate
 		processors.NewRateCalculator(core.RateMetricsMapping),
 	}
@@ -168,13 +645,22 @@ func createDataProcessorsOrDie(kubernetesUrl *url.URL, podLister v1listers.PodLi
 	}
 	dataProcessors = append(dataProcessors, podBasedEnricher)
 
-	namespaceBasedEnricher, err := processors.NewNamespaceBasedEnricher(kubernetesUrl)
+	namespaceBasedEnricher, err := processors.NewNamespaceBasedEnricher(kubernetesUrl, tenantTemplate)
 	if err != nil {
 		glog.Fatalf("Failed to create NamespaceBasedEnricher: %v", err)
 	}
 	dataProcessors = append(dataProcessors, namespaceBasedEnricher)
+	dataProcessors = append(dataProcessors, createAggregators()...)
 
-	// aggregators
+	nodeAutoscalingEnricher, err := processors.NewNodeAutoscalingEnricher(kubernetesUrl)
+	if err != nil {
+		glog.Fatalf("Failed to create NodeAutoscalingEnricher: %v", err)
+	}
+	dataProcessors = append(dataProcessors, nodeAutoscalingEnricher)
+	return dataProcessors
+}
+
+func createAggregators() []core.DataProcessor {
 	metricsToAggregate := []string{
 		core.MetricCpuUsageRate.Name,
 		core.MetricMemoryUsage.Name,
@@ -191,7 +677,7 @@ func createDataProcessorsOrDie(kubernetesUrl *url.URL, podLister v1listers.PodLi
 		core.MetricMemoryLimit.Name,
 	}
 
-	dataProcessors = append(dataProcessors,
+	return []core.DataProcessor{
 		processors.NewPodAggregator(),
 		&processors.NamespaceAggregator{
 			MetricsToAggregate: metricsToAggregate,
@@ -201,20 +687,11 @@ func createDataProcessorsOrDie(kubernetesUrl *url.URL, podLister v1listers.PodLi
 		},
 		&processors.ClusterAggregator{
 			MetricsToAggregate: metricsToAggregate,
-		})
-
-	nodeAutoscalingEnricher, err := processors.NewNodeAutoscalingEnricher(kubernetesUrl)
-	if err != nil {
-		glog.Fatalf("Failed to create NodeAutoscalingEnricher: %v", err)
+		},
 	}
-	dataProcessors = append(dataProcessors, nodeAutoscalingEnricher)
-	return dataProcessors
 }
 
-const (
-	minMetricsCount = 1
-	maxMetricsDelay = 3 * time.Minute
-)
+const minMetricsCount = 1
 
 func healthzChecker(metricSink *metricsink.MetricSink) healthz.HealthzChecker {
 	return healthz.NamedCheck("healthz", func(r *http.Request) error {
@@ -222,7 +699,7 @@ func healthzChecker(metricSink *metricsink.MetricSink) healthz.HealthzChecker {
 		if batch == nil {
 			return errors.New("could not get the latest data batch")
 		}
-		if time.Since(batch.Timestamp) > maxMetricsDelay {
+		if time.Since(batch.Timestamp) > metricSink.MaxDelay() {
 			message := fmt.Sprintf("No current data batch available (latest: %s).", batch.Timestamp.String())
 			glog.Warningf(message)
 			return errors.New(message)
@@ -247,8 +724,11 @@ func getKubernetesAddress(args flags.Uris) (*url.URL, error) {
 	return nil, fmt.Errorf("No kubernetes source found.")
 }
 
-func getPodLister(kubeClient *kube_client.Clientset) (v1listers.PodLister, error) {
-	lw := cache.NewListWatchFromClient(kubeClient.Core().RESTClient(), "pods", corev1.NamespaceAll, fields.Everything())
+func getPodLister(kubeConfig *restclient.Config) (v1listers.PodLister, error) {
+	lw, err := util.NewPodListWatch(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
 	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
 	podLister := v1listers.NewPodLister(store)
 	reflector := cache.NewReflector(lw, &corev1.Pod{}, store, time.Hour)
@@ -256,11 +736,13 @@ func getPodLister(kubeClient *kube_client.Clientset) (v1listers.PodLister, error
 	return podLister, nil
 }
 
-func validateFlags(opt *options.HeapsterRunOptions) error {
-	if opt.MetricResolution < 5*time.Second {
-		return fmt.Errorf("metric resolution needs to be greater than 5 seconds - %d", opt.MetricResolution)
-	}
-	return nil
+func getReplicaSetLister(kubeClient *kube_client.Clientset) (appslisters.ReplicaSetLister, error) {
+	lw := cache.NewListWatchFromClient(kubeClient.AppsV1beta2().RESTClient(), "replicasets", corev1.NamespaceAll, fields.Everything())
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	replicaSetLister := appslisters.NewReplicaSetLister(store)
+	reflector := cache.NewReflector(lw, &appsv1beta2.ReplicaSet{}, store, time.Hour)
+	go reflector.Run(wait.NeverStop)
+	return replicaSetLister, nil
 }
 
 func setMaxProcs(opt *options.HeapsterRunOptions) {
@@ -283,3 +765,58 @@ func setMaxProcs(opt *options.HeapsterRunOptions) {
 func setLabelSeperator(opt *options.HeapsterRunOptions) {
 	util.SetLabelSeperator(opt.LabelSeperator)
 }
+
+// Share of the container memory limit the long-term metric store may use by default.
+const defaultStoreMemoryShare = 4
+
+func setRetentionPolicyOrDie(opt *options.HeapsterRunOptions, metricSink *metricsink.MetricSink, podLister v1listers.PodLister, nodeLister v1listers.NodeLister) {
+	namespaceHistory, err := options.ParseNamespaceHistory(opt.NamespaceHistory)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	metricSink.SetRetentionPolicy(metricsink.NewRetentionPolicy(opt.DeletedPodRetention, opt.NotReadyNodeRetention,
+		opt.FilteredNamespaceRetention, opt.FilteredNamespaces, namespaceHistory, podLister, nodeLister))
+}
+
+func setStoreMemoryBudget(opt *options.HeapsterRunOptions, metricSink *metricsink.MetricSink) {
+	budget := opt.StoreMemoryBudget
+	if budget == 0 {
+		limit, err := util.GetMemoryLimit()
+		if err != nil {
+			glog.Warningf("Unable to read the container memory limit, not limiting the metric store: %v", err)
+			return
+		}
+		budget = limit / defaultStoreMemoryShare
+	}
+	if budget <= 0 {
+		return
+	}
+	glog.Infof("Limiting the long-term metric store to an estimated %d bytes", budget)
+	metricSink.SetLongStoreBudget(budget)
+}
+
+// setBindAddressFamily serves on all addresses of the --address_family, unless a
+// --bind-address is set.
+func setBindAddressFamily(opt *options.HeapsterRunOptions, fs *pflag.FlagSet) {
+	if opt.AddressFamily == "" {
+		return
+	}
+	if f := fs.Lookup("bind-address"); f != nil && f.Changed {
+		return
+	}
+	opt.SecureServing.BindAddress = util.UnspecifiedAddress(opt.AddressFamily)
+}
+
+// Environment variable with the IP of the metrics-server pod, set from status.podIP.
+const podIPEnv = "POD_IP"
+
+// setKubeletAddressFamily defaults the family of the addresses the kubelets of dual-stack
+// nodes are scraped at to the family of the pod IP, which the kubelets can be reached from.
+func setKubeletAddressFamily(opt *options.HeapsterRunOptions) {
+	if opt.KubeletPreferredAddressFamily == "" {
+		opt.KubeletPreferredAddressFamily = util.AddressFamilyOf(os.Getenv(podIPEnv))
+	}
+	if opt.KubeletPreferredAddressFamily != "" {
+		glog.Infof("Scraping dual-stack nodes at their %s addresses", opt.KubeletPreferredAddressFamily)
+	}
+}
@@ -20,16 +20,20 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
 	"github.com/kubernetes-incubator/metrics-server/metrics/cmd/heapster-apiserver/app"
 	"github.com/kubernetes-incubator/metrics-server/metrics/options"
 	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1beta2"
 	v1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
 )
@@ -44,6 +48,7 @@ var metricsGroupVersionForDiscovery = metav1.GroupVersionForDiscovery{
 var emptyMetricSink = &metricsink.MetricSink{}
 var emptyNodeLister = v1listers.NewNodeLister(nil)
 var emptyPodLister = v1listers.NewPodLister(nil)
+var emptyReplicaSetLister = appslisters.NewReplicaSetLister(nil)
 
 var testSecurePort = 6443
 
@@ -56,7 +61,7 @@ func TestRunSecureServer(t *testing.T) {
 		opt.SecureServing.ServerCert.CertDirectory = "/tmp"
 		opt.DisableAuthForTesting = true
 
-		server, err := app.NewHeapsterApiServer(opt, emptyMetricSink, emptyNodeLister, emptyPodLister)
+		server, err := app.NewHeapsterApiServer(opt, emptyMetricSink, bus.NewBus(bus.DefaultSubscriberBufferSize), emptyNodeLister, emptyPodLister, emptyReplicaSetLister, nil)
 		if err != nil {
 			t.Fatalf("Could not create the API server: %v", err)
 		}
@@ -173,11 +178,64 @@ func testAPIResourceList(t *testing.T, serverIP string) {
 	}
 	assert.Equal(t, "v1", apiResourceList.APIVersion)
 	assert.Equal(t, metricsGroupVersion.String(), apiResourceList.GroupVersion)
-	assert.Equal(t, 2, len(apiResourceList.APIResources))
+	// pods/resize is only served in builds with history, like in the server.
+	expected := 3
+	if metricsink.LongStoreDuration > 0 {
+		expected = 4
+	}
+	if !assert.Equal(t, expected, len(apiResourceList.APIResources)) {
+		return
+	}
 	assert.Equal(t, "nodes", apiResourceList.APIResources[0].Name)
 	assert.False(t, apiResourceList.APIResources[0].Namespaced)
 	assert.Equal(t, "NodeMetrics", apiResourceList.APIResources[0].Kind)
-	assert.Equal(t, "pods", apiResourceList.APIResources[1].Name)
-	assert.True(t, apiResourceList.APIResources[1].Namespaced)
-	assert.Equal(t, "PodMetrics", apiResourceList.APIResources[1].Kind)
+	assert.Equal(t, "nodes/extended", apiResourceList.APIResources[1].Name)
+	assert.False(t, apiResourceList.APIResources[1].Namespaced)
+	assert.Equal(t, "pods", apiResourceList.APIResources[2].Name)
+	assert.True(t, apiResourceList.APIResources[2].Namespaced)
+	assert.Equal(t, "PodMetrics", apiResourceList.APIResources[2].Kind)
+	if metricsink.LongStoreDuration > 0 {
+		assert.Equal(t, "pods/resize", apiResourceList.APIResources[3].Name)
+		assert.True(t, apiResourceList.APIResources[3].Namespaced)
+	}
+}
+
+func TestSetBindAddressFamily(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{"--address_family=ipv6"}, expected: "::"},
+		{args: []string{"--address_family=ipv4"}, expected: "0.0.0.0"},
+		{args: []string{}, expected: "0.0.0.0"},
+		{args: []string{"--address_family=ipv6", "--bind-address=0.0.0.0"}, expected: "0.0.0.0"},
+		{args: []string{"--bind-address=::"}, expected: "::"},
+	} {
+		opt := options.NewHeapsterRunOptions()
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		opt.AddFlags(fs)
+		assert.NoError(t, fs.Parse(tc.args))
+		setBindAddressFamily(opt, fs)
+		assert.Equal(t, tc.expected, opt.SecureServing.BindAddress.String(), "%v", tc.args)
+	}
+}
+
+func TestSetKubeletAddressFamily(t *testing.T) {
+	defer os.Unsetenv(podIPEnv)
+	for _, tc := range []struct {
+		podIP    string
+		flag     string
+		expected string
+	}{
+		{podIP: "fd00::5", expected: "ipv6"},
+		{podIP: "10.0.0.5", expected: "ipv4"},
+		{podIP: "fd00::5", flag: "ipv4", expected: "ipv4"},
+		{expected: ""},
+	} {
+		os.Setenv(podIPEnv, tc.podIP)
+		opt := options.NewHeapsterRunOptions()
+		opt.KubeletPreferredAddressFamily = tc.flag
+		setKubeletAddressFamily(opt)
+		assert.Equal(t, tc.expected, opt.KubeletPreferredAddressFamily, tc.podIP)
+	}
 }
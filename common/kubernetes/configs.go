@@ -36,6 +36,21 @@ const (
 	defaultInClusterConfig    = true
 )
 
+// WithRateLimits returns a copy of the URI setting the apiQPS and apiBurst options to the
+// QPS and burst, unless they're zero or the URI already sets the option.
+func WithRateLimits(uri *url.URL, qps float32, burst int) *url.URL {
+	result := *uri
+	opts := result.Query()
+	if qps > 0 && len(opts["apiQPS"]) == 0 {
+		opts.Set("apiQPS", strconv.FormatFloat(float64(qps), 'f', -1, 32))
+	}
+	if burst > 0 && len(opts["apiBurst"]) == 0 {
+		opts.Set("apiBurst", strconv.Itoa(burst))
+	}
+	result.RawQuery = opts.Encode()
+	return &result
+}
+
 func getConfigOverrides(uri *url.URL) (*kubeClientCmd.ConfigOverrides, error) {
 	kubeConfigOverride := kubeClientCmd.ConfigOverrides{
 		ClusterInfo: kubeClientCmdApi.Cluster{},
@@ -146,6 +161,21 @@ func GetKubeClientConfig(uri *url.URL) (*kube_rest.Config, error) {
 		}
 	}
 
+	if len(opts["apiQPS"]) >= 1 {
+		qps, err := strconv.ParseFloat(opts["apiQPS"][0], 32)
+		if err != nil || qps < 0 {
+			return nil, fmt.Errorf("invalid apiQPS %q", opts["apiQPS"][0])
+		}
+		kubeConfig.QPS = float32(qps)
+	}
+	if len(opts["apiBurst"]) >= 1 {
+		burst, err := strconv.Atoi(opts["apiBurst"][0])
+		if err != nil || burst < 0 {
+			return nil, fmt.Errorf("invalid apiBurst %q", opts["apiBurst"][0])
+		}
+		kubeConfig.Burst = burst
+	}
+
 	kubeConfig.ContentType = "application/vnd.kubernetes.protobuf"
 
 	return kubeConfig, nil
@@ -0,0 +1,53 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetKubeClientConfigRateLimits(t *testing.T) {
+	uri, err := url.Parse("https://kubernetes.default?inClusterConfig=false")
+	require.NoError(t, err)
+	config, err := GetKubeClientConfig(uri)
+	require.NoError(t, err)
+	assert.Equal(t, float32(0), config.QPS, "client-go defaults")
+	assert.Equal(t, 0, config.Burst)
+
+	config, err = GetKubeClientConfig(WithRateLimits(uri, 50, 100))
+	require.NoError(t, err)
+	assert.Equal(t, float32(50), config.QPS)
+	assert.Equal(t, 100, config.Burst)
+
+	uri, err = url.Parse("https://kubernetes.default?inClusterConfig=false&apiQPS=2.5")
+	require.NoError(t, err)
+	limited := WithRateLimits(uri, 50, 0)
+	assert.Equal(t, "apiQPS=2.5&inClusterConfig=false", limited.RawQuery, "options of the URI take precedence")
+	assert.Equal(t, "inClusterConfig=false&apiQPS=2.5", uri.RawQuery, "the URI isn't modified")
+	config, err = GetKubeClientConfig(limited)
+	require.NoError(t, err)
+	assert.Equal(t, float32(2.5), config.QPS)
+
+	for _, query := range []string{"apiQPS=fast", "apiQPS=-1", "apiBurst=1.5"} {
+		uri, err = url.Parse("https://kubernetes.default?inClusterConfig=false&" + query)
+		require.NoError(t, err)
+		_, err = GetKubeClientConfig(uri)
+		assert.Error(t, err, query)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bus implements a small in-process publish/subscribe bus, which
+// decouples the scraper from the components consuming its results.
+package bus
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Number of events buffered for each subscriber before new ones are dropped.
+	DefaultSubscriberBufferSize = 100
+)
+
+type Topic string
+
+const (
+	// Published by the source manager for every source that responded in time.
+	TopicSourceBatch Topic = "source_batch"
+	// Published with every processed DataBatch exported to the sinks.
+	TopicDataBatch Topic = "data_batch"
+)
+
+var (
+	droppedEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "bus",
+			Name:      "dropped_events_total",
+			Help:      "Number of events dropped because the subscriber was not keeping up.",
+		},
+		[]string{"subscriber"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(droppedEvents)
+}
+
+type Event struct {
+	Topic Topic
+	// Name of the source which produced the batch, empty for TopicDataBatch.
+	Source string
+	Batch  *core.DataBatch
+}
+
+type Handler func(*Event)
+
+type subscriber struct {
+	name   string
+	events chan *Event
+}
+
+// Bus delivers published events to all subscribers of the topic. Every
+// subscriber gets its own goroutine and buffer, so a slow one doesn't hold
+// back the publisher or the other subscribers.
+type Bus struct {
+	lock        sync.RWMutex
+	bufferSize  int
+	subscribers map[Topic][]*subscriber
+}
+
+func NewBus(bufferSize int) *Bus {
+	return &Bus{
+		bufferSize:  bufferSize,
+		subscribers: make(map[Topic][]*subscriber),
+	}
+}
+
+// Subscribe registers the handler for all events published on the topic after this call.
+func (this *Bus) Subscribe(name string, topic Topic, handler Handler) {
+	s := &subscriber{
+		name:   name,
+		events: make(chan *Event, this.bufferSize),
+	}
+	go func() {
+		for event := range s.events {
+			handler(event)
+		}
+	}()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.subscribers[topic] = append(this.subscribers[topic], s)
+}
+
+// Publish hands the event to all subscribers of its topic without blocking.
+func (this *Bus) Publish(event *Event) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	for _, s := range this.subscribers[event.Topic] {
+		select {
+		case s.events <- event:
+		default:
+			glog.Warningf("Dropping %s event for subscriber %s", event.Topic, s.name)
+			droppedEvents.WithLabelValues(s.name).Inc()
+		}
+	}
+}
+
+// busSink publishes exported data batches on the bus.
+type busSink struct {
+	bus *Bus
+}
+
+func NewSink(bus *Bus) core.DataSink {
+	return &busSink{bus: bus}
+}
+
+func (this *busSink) Name() string {
+	return "Bus Sink"
+}
+
+func (this *busSink) ExportData(batch *core.DataBatch) {
+	this.bus.Publish(&Event{Topic: TopicDataBatch, Batch: batch})
+}
+
+func (this *busSink) Stop() {
+	// Do nothing.
+}
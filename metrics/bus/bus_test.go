@@ -0,0 +1,71 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	b := NewBus(DefaultSubscriberBufferSize)
+	sourceEvents := make(chan *Event, 10)
+	dataEvents := make(chan *Event, 10)
+	b.Subscribe("source", TopicSourceBatch, func(e *Event) { sourceEvents <- e })
+	b.Subscribe("data", TopicDataBatch, func(e *Event) { dataEvents <- e })
+
+	batch := &core.DataBatch{Timestamp: time.Now()}
+	b.Publish(&Event{Topic: TopicSourceBatch, Source: "node1", Batch: batch})
+	NewSink(b).ExportData(batch)
+
+	select {
+	case e := <-sourceEvents:
+		assert.Equal(t, "node1", e.Source)
+		assert.Equal(t, batch, e.Batch)
+	case <-time.After(time.Second):
+		t.Fatal("source event not delivered")
+	}
+	select {
+	case e := <-dataEvents:
+		assert.Equal(t, TopicDataBatch, e.Topic)
+		assert.Equal(t, batch, e.Batch)
+	case <-time.After(time.Second):
+		t.Fatal("data event not delivered")
+	}
+}
+
+func TestSlowSubscriberDoesNotBlock(t *testing.T) {
+	b := NewBus(1)
+	release := make(chan struct{})
+	b.Subscribe("slow", TopicDataBatch, func(e *Event) { <-release })
+	defer close(release)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			b.Publish(&Event{Topic: TopicDataBatch})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishing blocked on a slow subscriber")
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package canary compares the batches of a canary instance, e.g. of a new version during
+// an upgrade, with the batches of the primary instance serving the Metrics API. The
+// divergence is published as metrics, so the APIService can be cut over with confidence.
+package canary
+
+import (
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/snapshot"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+)
+
+// Kinds of the compared objects.
+const (
+	KindNode      = "node"
+	KindContainer = "container"
+)
+
+var (
+	batchOffset = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "canary",
+			Name:      "batch_offset_seconds",
+			Help:      "Age of the latest batch of the primary instance when it was compared with the canary batch.",
+		},
+	)
+	comparedObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "canary",
+			Name:      "compared_objects",
+			Help:      "Number of objects in both the canary and the primary batch in the latest comparison, by kind.",
+		},
+		[]string{"kind"},
+	)
+	missingObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "canary",
+			Name:      "missing_objects",
+			Help:      "Number of objects on the canary nodes only one of the instances has metrics of, by kind and by instance missing them.",
+		},
+		[]string{"kind", "instance"},
+	)
+	usageDivergence = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "canary",
+			Name:      "usage_divergence_ratio",
+			Help:      "Mean difference of the usage reported by the canary and the primary instance relative to the primary usage, by kind and resource.",
+		},
+		[]string{"kind", "resource"},
+	)
+	primaryReadErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "canary",
+			Name:      "primary_read_errors_total",
+			Help:      "Number of failed reads of the batches of the primary instance.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(batchOffset)
+	prometheus.MustRegister(comparedObjects)
+	prometheus.MustRegister(missingObjects)
+	prometheus.MustRegister(usageDivergence)
+	prometheus.MustRegister(primaryReadErrors)
+}
+
+// KindDivergence is how the canary and primary metrics of one kind of objects differ.
+type KindDivergence struct {
+	Compared         int
+	MissingInCanary  int
+	MissingInPrimary int
+	// Mean relative difference of the usage of the compared objects.
+	CPU    float64
+	Memory float64
+}
+
+// Divergence is how a canary batch differs from the primary batch.
+type Divergence struct {
+	// How much older the primary batch is than the canary batch.
+	Offset time.Duration
+	Kinds  map[string]*KindDivergence
+}
+
+// Comparer compares every batch processed by the canary with the latest batch of the primary.
+type Comparer struct {
+	reader    *snapshot.Reader
+	nodeShare float64
+	// The latest batch read from the primary.
+	primary *core.DataBatch
+}
+
+// NewComparer creates a comparer of a canary scraping the share of the nodes with the
+// batches the reader returns.
+func NewComparer(reader *snapshot.Reader, nodeShare float64) *Comparer {
+	return &Comparer{
+		reader:    reader,
+		nodeShare: nodeShare,
+	}
+}
+
+// Subscribe registers the comparer for processed batches on the bus.
+func (this *Comparer) Subscribe(eventBus *bus.Bus) {
+	eventBus.Subscribe("canary_comparer", bus.TopicDataBatch, this.handle)
+}
+
+func (this *Comparer) handle(event *bus.Event) {
+	primary, err := this.reader.Read()
+	if err != nil {
+		glog.Errorf("Failed to read the batch of the primary instance: %v", err)
+		primaryReadErrors.Inc()
+	} else if primary != nil {
+		this.primary = primary
+	}
+	if this.primary == nil {
+		return
+	}
+	divergence := Compare(event.Batch, this.primary, this.nodeShare)
+	glog.V(2).Infof("Canary batch diverges from the primary batch: %s offset, %+v nodes, %+v containers",
+		divergence.Offset, *divergence.Kinds[KindNode], *divergence.Kinds[KindContainer])
+	divergence.publish()
+}
+
+func (this *Divergence) publish() {
+	batchOffset.Set(this.Offset.Seconds())
+	for kind, d := range this.Kinds {
+		comparedObjects.WithLabelValues(kind).Set(float64(d.Compared))
+		missingObjects.WithLabelValues(kind, "canary").Set(float64(d.MissingInCanary))
+		missingObjects.WithLabelValues(kind, "primary").Set(float64(d.MissingInPrimary))
+		usageDivergence.WithLabelValues(kind, "cpu").Set(d.CPU)
+		usageDivergence.WithLabelValues(kind, "memory").Set(d.Memory)
+	}
+}
+
+// Compare compares the node and container metrics of the canary batch with those of the
+// primary batch on the nodes in the share scraped by the canary.
+func Compare(canary, primary *core.DataBatch, nodeShare float64) *Divergence {
+	result := &Divergence{
+		Offset: canary.Timestamp.Sub(primary.Timestamp),
+		Kinds: map[string]*KindDivergence{
+			KindNode:      {},
+			KindContainer: {},
+		},
+	}
+	for key, ms := range primary.MetricSets {
+		kind, found := getKind(ms)
+		if !found || !util.InShare(ms.Labels[core.LabelNodename.Key], nodeShare) {
+			continue
+		}
+		d := result.Kinds[kind]
+		canaryMs, found := canary.MetricSets[key]
+		if !found {
+			d.MissingInCanary++
+			continue
+		}
+		d.Compared++
+		d.CPU += relativeDifference(canaryMs, ms, core.MetricCpuUsageRate.Name)
+		d.Memory += relativeDifference(canaryMs, ms, core.MetricMemoryWorkingSet.Name)
+	}
+	for key, ms := range canary.MetricSets {
+		if kind, found := getKind(ms); found {
+			if _, found := primary.MetricSets[key]; !found {
+				result.Kinds[kind].MissingInPrimary++
+			}
+		}
+	}
+	for _, d := range result.Kinds {
+		if d.Compared > 0 {
+			d.CPU /= float64(d.Compared)
+			d.Memory /= float64(d.Compared)
+		}
+	}
+	return result
+}
+
+func getKind(ms *core.MetricSet) (string, bool) {
+	switch ms.Labels[core.LabelMetricSetType.Key] {
+	case core.MetricSetTypeNode:
+		return KindNode, true
+	case core.MetricSetTypePodContainer:
+		return KindContainer, true
+	default:
+		return "", false
+	}
+}
+
+// relativeDifference returns the difference of the canary and primary values of the
+// metric relative to the primary value, 1 if only one of them is non-zero.
+func relativeDifference(canary, primary *core.MetricSet, metric string) float64 {
+	c := float64(canary.MetricValues[metric].IntValue)
+	p := float64(primary.MetricValues[metric].IntValue)
+	switch {
+	case c == p:
+		return 0
+	case p == 0:
+		return 1
+	default:
+		return math.Abs(c-p) / p
+	}
+}
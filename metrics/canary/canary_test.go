@@ -0,0 +1,108 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package canary
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/snapshot"
+)
+
+func metricSet(setType, node string, cpu, mem int64) *core.MetricSet {
+	return &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: setType,
+			core.LabelNodename.Key:      node,
+		},
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: mem},
+		},
+	}
+}
+
+func TestCompare(t *testing.T) {
+	now := time.Now()
+	primary := &core.DataBatch{
+		Timestamp: now.Add(-10 * time.Second),
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("n1"):                      metricSet(core.MetricSetTypeNode, "n1", 100, 1000),
+			core.PodContainerKey("ns", "p1", "c1"):  metricSet(core.MetricSetTypePodContainer, "n1", 10, 100),
+			core.PodContainerKey("ns", "p2", "c1"):  metricSet(core.MetricSetTypePodContainer, "n1", 0, 100),
+			core.PodContainerKey("ns", "old", "c1"): metricSet(core.MetricSetTypePodContainer, "n1", 1, 1),
+			core.PodKey("ns", "p1"):                 metricSet(core.MetricSetTypePod, "n1", 10, 100),
+		},
+	}
+	canary := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("n1"):                      metricSet(core.MetricSetTypeNode, "n1", 110, 1000),
+			core.PodContainerKey("ns", "p1", "c1"):  metricSet(core.MetricSetTypePodContainer, "n1", 10, 50),
+			core.PodContainerKey("ns", "p2", "c1"):  metricSet(core.MetricSetTypePodContainer, "n1", 5, 100),
+			core.PodContainerKey("ns", "new", "c1"): metricSet(core.MetricSetTypePodContainer, "n1", 1, 1),
+		},
+	}
+
+	d := Compare(canary, primary, 1)
+	assert.Equal(t, 10*time.Second, d.Offset)
+	assert.Equal(t, KindDivergence{Compared: 1, CPU: 0.1}, *d.Kinds[KindNode])
+	containers := d.Kinds[KindContainer]
+	assert.Equal(t, 2, containers.Compared)
+	assert.Equal(t, 1, containers.MissingInCanary)
+	assert.Equal(t, 1, containers.MissingInPrimary)
+	assert.InDelta(t, 0.5, containers.CPU, 1e-9, "only the canary has cpu usage of p2")
+	assert.InDelta(t, 0.25, containers.Memory, 1e-9)
+}
+
+func TestCompareNodeShare(t *testing.T) {
+	primary := &core.DataBatch{MetricSets: map[string]*core.MetricSet{}}
+	for _, node := range []string{"n1", "n2", "n3", "n4", "n5", "n6", "n7", "n8"} {
+		primary.MetricSets[core.NodeKey(node)] = metricSet(core.MetricSetTypeNode, node, 1, 1)
+	}
+	d := Compare(&core.DataBatch{MetricSets: map[string]*core.MetricSet{}}, primary, 0.5)
+	missing := d.Kinds[KindNode].MissingInCanary
+	assert.True(t, missing > 0 && missing < len(primary.MetricSets), "only nodes in the share are expected, got %d", missing)
+}
+
+func TestComparerReadsPrimarySnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "canary")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	comparer := NewComparer(snapshot.NewReader(path, 1<<20), 1)
+	canary := &core.DataBatch{
+		Timestamp:  time.Now(),
+		MetricSets: map[string]*core.MetricSet{core.NodeKey("n1"): metricSet(core.MetricSetTypeNode, "n1", 1, 1)},
+	}
+	comparer.handle(&bus.Event{Batch: canary})
+	assert.Nil(t, comparer.primary, "no primary snapshot yet")
+
+	primary := &core.DataBatch{Timestamp: canary.Timestamp.Add(-time.Second), MetricSets: canary.MetricSets}
+	require.NoError(t, snapshot.Write(path, primary))
+	comparer.handle(&bus.Event{Batch: canary})
+	require.NotNil(t, comparer.primary)
+	comparer.handle(&bus.Event{Batch: canary})
+	assert.NotNil(t, comparer.primary, "unchanged snapshot keeps the primary batch")
+}
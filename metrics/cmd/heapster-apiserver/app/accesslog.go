@@ -0,0 +1,194 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/grpcapi"
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// accessLogEntry is a line of the access log. Calls of the gRPC service are logged with the
+// method as path and the HTTP status matching their code.
+type accessLogEntry struct {
+	Time          time.Time `json:"time"`
+	Verb          string    `json:"verb"`
+	Path          string    `json:"path"`
+	RequestURI    string    `json:"requestURI"`
+	Resource      string    `json:"resource,omitempty"`
+	Namespace     string    `json:"namespace,omitempty"`
+	Name          string    `json:"name,omitempty"`
+	User          string    `json:"user,omitempty"`
+	UserAgent     string    `json:"userAgent,omitempty"`
+	Status        int       `json:"status"`
+	LatencyMs     float64   `json:"latencyMs"`
+	ResponseBytes int       `json:"responseBytes"`
+	GRPCCode      string    `json:"grpcCode,omitempty"`
+	Items         int       `json:"items,omitempty"`
+}
+
+// accessLog writes the entries as JSON lines.
+type accessLog struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+func newAccessLog(out io.Writer) *accessLog {
+	return &accessLog{encoder: json.NewEncoder(out)}
+}
+
+func (this *accessLog) write(entry *accessLogEntry) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err := this.encoder.Encode(entry); err != nil {
+		glog.Errorf("Failed to write access log: %v", err)
+	}
+}
+
+// logGRPCCall writes the entry of a call of the gRPC service.
+func (this *accessLog) logGRPCCall(call *grpcapi.Call) {
+	this.write(&accessLogEntry{
+		Time:       time.Now().Add(-call.Latency),
+		Verb:       "list",
+		Path:       call.Method,
+		RequestURI: call.Method,
+		Resource:   call.Resource,
+		Namespace:  call.Namespace,
+		User:       call.User,
+		Status:     grpcStatus(call.Code),
+		LatencyMs:  float64(call.Latency) / float64(time.Millisecond),
+		GRPCCode:   call.Code.String(),
+		Items:      call.Items,
+	})
+}
+
+// grpcStatus returns the HTTP status matching the gRPC code.
+func grpcStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.Canceled:
+		return 499
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// accessLogResponseWriter records the status and size of the response.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// newAccessLogWriter returns the file the access log is written to, rotated by size.
+func newAccessLogWriter(s *options.HeapsterRunOptions) io.Writer {
+	return &lumberjack.Logger{
+		Filename:   s.AccessLogFile,
+		MaxSize:    s.AccessLogMaxSize,
+		MaxBackups: s.AccessLogMaxBackups,
+		MaxAge:     s.AccessLogMaxAge,
+	}
+}
+
+// withAccessLog writes a JSON line for every Metrics API request to the writer, with the
+// requesting user, the latency and the size of the response, so that the clients putting
+// load on the API can be found. The API server audit log doesn't cover aggregated APIs.
+// It wraps the whole handler chain so that requests rejected by the authentication,
+// authorization and in-flight filters are logged too. The request context is set up here
+// rather than in the chain, so that it's still there once the chain returned.
+func withAccessLog(handler http.Handler, mapper genericapirequest.RequestContextMapper, log *accessLog) http.Handler {
+	return genericapirequest.WithRequestContext(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, metricsAPIPrefix) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		start := time.Now()
+		recorder := &accessLogResponseWriter{ResponseWriter: w}
+		handler.ServeHTTP(recorder, req)
+		if recorder.status == 0 {
+			// Nothing was written, net/http responds with 200.
+			recorder.status = http.StatusOK
+		}
+
+		entry := accessLogEntry{
+			Time:          start,
+			Verb:          req.Method,
+			Path:          req.URL.Path,
+			RequestURI:    req.RequestURI,
+			UserAgent:     req.UserAgent(),
+			Status:        recorder.status,
+			LatencyMs:     float64(time.Since(start)) / float64(time.Millisecond),
+			ResponseBytes: recorder.size,
+		}
+		if ctx, ok := mapper.Get(req); ok {
+			if info, found := genericapirequest.RequestInfoFrom(ctx); found {
+				entry.Verb = info.Verb
+				entry.Resource = info.Resource
+				entry.Namespace = info.Namespace
+				entry.Name = info.Name
+			}
+			if user, found := genericapirequest.UserFrom(ctx); found {
+				entry.User = user.GetName()
+			}
+		}
+		log.write(&entry)
+	}), mapper)
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/grpcapi"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+)
+
+// newTestAccessLog returns the handler chain of the API around the handler, with the access
+// log written to out. Requests are authenticated as the user named in their X-Remote-User
+// header, and only user dev is authorized.
+func newTestAccessLog(handler http.Handler, maxInFlight int, out *bytes.Buffer) http.Handler {
+	config := genericapiserver.NewConfig(Codecs)
+	config.MaxRequestsInFlight = maxInFlight
+	config.Authenticator = authenticator.RequestFunc(func(req *http.Request) (user.Info, bool, error) {
+		name := req.Header.Get("X-Remote-User")
+		return &user.DefaultInfo{Name: name}, name != "", nil
+	})
+	config.Authorizer = authorizer.AuthorizerFunc(func(a authorizer.Attributes) (bool, string, error) {
+		return a.GetUser().GetName() == "dev", "", nil
+	})
+	return withAccessLog(genericapiserver.DefaultBuildHandlerChain(handler, config), config.RequestContextMapper, newAccessLog(out))
+}
+
+func decodeAccessLog(t *testing.T, out *bytes.Buffer) []accessLogEntry {
+	entries := []accessLogEntry{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := accessLogEntry{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAccessLog(t *testing.T) {
+	out := &bytes.Buffer{}
+	handler := newTestAccessLog(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}), 10, out)
+
+	for _, userName := range []string{"dev", "", "other"} {
+		req := httptest.NewRequest(http.MethodGet, metricsAPIPrefix+"v1beta1/namespaces/ns/pods?labelSelector=app%3Dweb", nil)
+		req.Header.Set("X-Remote-User", userName)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	entries := decodeAccessLog(t, out)
+	require.Len(t, entries, 3, "only Metrics API requests are logged")
+	assert.Equal(t, http.StatusOK, entries[0].Status)
+	assert.Equal(t, "list", entries[0].Verb)
+	assert.Equal(t, "pods", entries[0].Resource)
+	assert.Equal(t, "ns", entries[0].Namespace)
+	assert.Equal(t, "dev", entries[0].User)
+	assert.Equal(t, 2, entries[0].ResponseBytes)
+	assert.Equal(t, metricsAPIPrefix+"v1beta1/namespaces/ns/pods", entries[0].Path)
+	assert.Equal(t, metricsAPIPrefix+"v1beta1/namespaces/ns/pods?labelSelector=app%3Dweb", entries[0].RequestURI)
+	assert.Equal(t, http.StatusUnauthorized, entries[1].Status, "unauthenticated requests are logged")
+	assert.Empty(t, entries[1].User)
+	assert.Equal(t, http.StatusForbidden, entries[2].Status, "unauthorized requests are logged")
+	assert.Equal(t, "other", entries[2].User)
+}
+
+func TestAccessLogThrottled(t *testing.T) {
+	out := &bytes.Buffer{}
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := newTestAccessLog(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	}), 1, out)
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, metricsAPIPrefix+"v1beta1/nodes", nil)
+		req.Header.Set("X-Remote-User", "dev")
+		return req
+	}
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest())
+		close(done)
+	}()
+	<-started
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest())
+	close(release)
+	<-done
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	entries := decodeAccessLog(t, out)
+	require.Len(t, entries, 2)
+	assert.Equal(t, http.StatusTooManyRequests, entries[0].Status, "throttled requests are logged")
+	assert.Equal(t, "nodes", entries[0].Resource)
+	assert.Equal(t, http.StatusOK, entries[1].Status)
+}
+
+func TestAccessLogGRPCCall(t *testing.T) {
+	out := &bytes.Buffer{}
+	newAccessLog(out).logGRPCCall(&grpcapi.Call{
+		Method:    grpcapi.ListPodMetricsMethod,
+		User:      "viewer",
+		Resource:  "pods",
+		Namespace: "ns1",
+		Code:      codes.PermissionDenied,
+	})
+	entries := decodeAccessLog(t, out)
+	require.Len(t, entries, 1)
+	assert.Equal(t, grpcapi.ListPodMetricsMethod, entries[0].Path)
+	assert.Equal(t, "list", entries[0].Verb)
+	assert.Equal(t, "pods", entries[0].Resource)
+	assert.Equal(t, "ns1", entries[0].Namespace)
+	assert.Equal(t, "viewer", entries[0].User)
+	assert.Equal(t, http.StatusForbidden, entries[0].Status)
+	assert.Equal(t, "PermissionDenied", entries[0].GRPCCode)
+}
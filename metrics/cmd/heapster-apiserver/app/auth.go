@@ -0,0 +1,95 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Rate limits the generic API server sets on its TokenReview and SubjectAccessReview clients.
+const (
+	defaultAuthAPIQPS   = 200
+	defaultAuthAPIBurst = 400
+)
+
+// applyDelegatedAuth sets up the authentication and authorization delegated to the core
+// API server. The generic options hard code the rate limits of their review clients, so
+// if --auth_api_qps or --auth_api_burst is set, the authenticator and authorizer are
+// created again with clients of those limits.
+func applyDelegatedAuth(c *genericapiserver.Config, s *options.HeapsterRunOptions) error {
+	if err := s.Authentication.ApplyTo(c); err != nil {
+		return err
+	}
+	if err := s.Authorization.ApplyTo(c); err != nil {
+		return err
+	}
+	if s.AuthAPIQPS == 0 && s.AuthAPIBurst == 0 {
+		return nil
+	}
+
+	authnConfig, err := s.Authentication.ToAuthenticationConfig()
+	if err != nil {
+		return err
+	}
+	authnClient, err := newAuthClient(s.Authentication.RemoteKubeConfigFile, s.AuthAPIQPS, s.AuthAPIBurst)
+	if err != nil {
+		return err
+	}
+	authnConfig.TokenAccessReviewClient = authnClient.AuthenticationV1beta1().TokenReviews()
+	if c.Authenticator, _, err = authnConfig.New(); err != nil {
+		return err
+	}
+
+	authzConfig, err := s.Authorization.ToAuthorizationConfig()
+	if err != nil {
+		return err
+	}
+	authzClient, err := newAuthClient(s.Authorization.RemoteKubeConfigFile, s.AuthAPIQPS, s.AuthAPIBurst)
+	if err != nil {
+		return err
+	}
+	authzConfig.SubjectAccessReviewClient = authzClient.AuthorizationV1beta1().SubjectAccessReviews()
+	c.Authorizer, err = authzConfig.New()
+	return err
+}
+
+// newAuthClient creates a client from the kubeconfig file, or the in-cluster config if
+// it's empty, like the generic options do, but with its own rate limits.
+func newAuthClient(kubeconfig string, qps float32, burst int) (*kube_client.Clientset, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}, &clientcmd.ConfigOverrides{})
+		config, err = loader.ClientConfig()
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.QPS, config.Burst = defaultAuthAPIQPS, defaultAuthAPIBurst
+	if qps > 0 {
+		config.QPS = qps
+	}
+	if burst > 0 {
+		config.Burst = burst
+	}
+	return kube_client.NewForConfig(config)
+}
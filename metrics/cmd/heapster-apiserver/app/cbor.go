@@ -0,0 +1,221 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nocbor
+// +build !nocbor
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+
+	"github.com/ugorji/go/codec"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+)
+
+// CBORServing serves the metrics API as application/cbor to clients asking for it, which
+// is smaller and cheaper to parse than JSON for large lists.
+const CBORServing utilfeature.Feature = "CBORServing"
+
+const ContentTypeCBOR = "application/cbor"
+
+// Deepest nesting of arrays and maps accepted by Decode. Metrics API objects are nested
+// a few levels deep, the limit keeps crafted input from exhausting the stack.
+const maxCBORDepth = 32
+
+// cborHandle encodes map keys in sorted order, so that equal objects are encoded alike,
+// and decodes maps with string keys and integers as int64, like JSON objects.
+var cborHandle = func() *codec.CborHandle {
+	h := &codec.CborHandle{}
+	h.Canonical = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.SignedInteger = true
+	return h
+}()
+
+func init() {
+	registerSerializer(CBORServing, func(json runtime.Serializer) runtime.SerializerInfo {
+		return runtime.SerializerInfo{
+			MediaType:  ContentTypeCBOR,
+			Serializer: &cborSerializer{json: json},
+		}
+	})
+}
+
+// cborSerializer converts objects between the JSON serializer and CBOR, so they're
+// encoded with the same field names and conversions as in JSON.
+type cborSerializer struct {
+	json runtime.Serializer
+}
+
+func (this *cborSerializer) Encode(obj runtime.Object, w io.Writer) error {
+	buf := &bytes.Buffer{}
+	if err := this.json.Encode(obj, buf); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(buf)
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	value, err := fromJSONNumbers(value)
+	if err != nil {
+		return err
+	}
+	out := &bytes.Buffer{}
+	if err := codec.NewEncoder(out, cborHandle).Encode(value); err != nil {
+		return err
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+func (this *cborSerializer) Decode(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	size, err := checkCBORItem(data, maxCBORDepth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if size < len(data) {
+		return nil, nil, fmt.Errorf("unexpected %d bytes after CBOR value", len(data)-size)
+	}
+	var value interface{}
+	if err := codec.NewDecoderBytes(data, cborHandle).Decode(&value); err != nil {
+		return nil, nil, err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return this.json.Decode(encoded, defaults, into)
+}
+
+// fromJSONNumbers replaces the json.Number values in the value decoded from JSON by int64,
+// or float64 if they're not integers, so that they're encoded as CBOR numbers.
+func fromJSONNumbers(value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(typed), 10, 64); err == nil {
+			return i, nil
+		}
+		return typed.Float64()
+	case []interface{}:
+		for i, item := range typed {
+			converted, err := fromJSONNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			typed[i] = converted
+		}
+	case map[string]interface{}:
+		for key, item := range typed {
+			converted, err := fromJSONNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			typed[key] = converted
+		}
+	}
+	return value, nil
+}
+
+// CBOR major types and the additional info of indefinite lengths, see RFC 7049.
+const (
+	cborBytes      byte = 2 << 5
+	cborString     byte = 3 << 5
+	cborArray      byte = 4 << 5
+	cborMap        byte = 5 << 5
+	cborTag        byte = 6 << 5
+	cborIndefinite byte = 31
+)
+
+// checkCBORItem returns the size of the CBOR item at the start of the data, or an error if
+// it's truncated, uses indefinite lengths or nests arrays and maps deeper than maxDepth.
+// Only the heads of the items are read, without recursion, the codec decodes the values.
+func checkCBORItem(data []byte, maxDepth int) (int, error) {
+	// Items left to read in each of the arrays and maps currently open, innermost last.
+	open := []uint64{}
+	pos := 0
+	for {
+		if pos >= len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		major, info := data[pos]&0xe0, data[pos]&0x1f
+		pos++
+		if info == cborIndefinite {
+			return 0, fmt.Errorf("indefinite length CBOR items are not supported")
+		}
+		n := uint64(info)
+		if info >= 24 {
+			if info > 27 {
+				return 0, fmt.Errorf("unsupported CBOR additional info %d", info)
+			}
+			size := 1 << (info - 24)
+			if len(data)-pos < size {
+				return 0, io.ErrUnexpectedEOF
+			}
+			n = 0
+			for _, b := range data[pos : pos+size] {
+				n = n<<8 | uint64(b)
+			}
+			pos += size
+		}
+
+		switch major {
+		case cborTag:
+			// The tagged item follows.
+			continue
+		case cborBytes, cborString:
+			if uint64(len(data)-pos) < n {
+				return 0, io.ErrUnexpectedEOF
+			}
+			pos += int(n)
+		case cborArray, cborMap:
+			if major == cborMap {
+				n *= 2
+			}
+			if n > uint64(len(data)-pos) {
+				// Every item takes at least a byte.
+				return 0, io.ErrUnexpectedEOF
+			}
+			if n > 0 {
+				if len(open) == maxDepth {
+					return 0, fmt.Errorf("CBOR value nested deeper than %d levels", maxDepth)
+				}
+				open = append(open, n)
+				continue
+			}
+		}
+
+		// An item is complete, and with it all the arrays and maps it completes.
+		for len(open) > 0 {
+			open[len(open)-1]--
+			if open[len(open)-1] > 0 {
+				break
+			}
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			return pos, nil
+		}
+	}
+}
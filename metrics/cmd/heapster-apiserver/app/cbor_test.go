@@ -0,0 +1,138 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nocbor
+// +build !nocbor
+
+package app
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "write the golden files of the tests")
+
+func newTestCBORSerializer(t *testing.T) *cborSerializer {
+	info, found := runtime.SerializerInfoForMediaType(Codecs.SupportedMediaTypes(), runtime.ContentTypeJSON)
+	require.True(t, found)
+	return &cborSerializer{json: info.Serializer}
+}
+
+func testNodeMetricsList() *v1beta1.NodeMetricsList {
+	timestamp := metav1.NewTime(time.Date(2017, 8, 1, 12, 0, 0, 0, time.UTC).Local())
+	return &v1beta1.NodeMetricsList{
+		TypeMeta: metav1.TypeMeta{Kind: "NodeMetricsList", APIVersion: v1beta1.SchemeGroupVersion.String()},
+		ListMeta: metav1.ListMeta{ResourceVersion: "1501588800000000000"},
+		Items: []v1beta1.NodeMetrics{{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", CreationTimestamp: timestamp},
+			Timestamp:  timestamp,
+			Window:     metav1.Duration{Duration: time.Minute},
+			Usage: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("250m"),
+				v1.ResourceMemory: resource.MustParse("1Gi"),
+			},
+		}},
+	}
+}
+
+func TestCBOREncodeGolden(t *testing.T) {
+	serializer := newTestCBORSerializer(t)
+	buf := &bytes.Buffer{}
+	require.NoError(t, serializer.Encode(testNodeMetricsList(), buf))
+	encoded := hex.EncodeToString(buf.Bytes())
+
+	golden := filepath.Join("testdata", "nodemetricslist.cbor.hex")
+	if *updateGolden {
+		require.NoError(t, ioutil.WriteFile(golden, []byte(encoded+"\n"), 0644))
+	}
+	expected, err := ioutil.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimSpace(string(expected)), encoded)
+
+	again := &bytes.Buffer{}
+	require.NoError(t, serializer.Encode(testNodeMetricsList(), again))
+	assert.Equal(t, buf.Bytes(), again.Bytes(), "encoding is deterministic")
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	serializer := newTestCBORSerializer(t)
+	buf := &bytes.Buffer{}
+	require.NoError(t, serializer.Encode(testNodeMetricsList(), buf))
+
+	decoded := &v1beta1.NodeMetricsList{}
+	_, _, err := serializer.Decode(buf.Bytes(), nil, decoded)
+	require.NoError(t, err)
+	assert.Equal(t, testNodeMetricsList(), decoded)
+
+	_, _, err = serializer.Decode(append(buf.Bytes(), 0), nil, &v1beta1.NodeMetricsList{})
+	assert.Error(t, err, "trailing bytes")
+	_, _, err = serializer.Decode(buf.Bytes()[:buf.Len()-1], nil, &v1beta1.NodeMetricsList{})
+	assert.Error(t, err, "truncated")
+}
+
+func TestCheckCBORItem(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		size int
+		err  string
+	}{
+		{name: "unsigned", data: "1903e8", size: 3},
+		{name: "negative", data: "20", size: 1},
+		{name: "float", data: "fb3ff8000000000000", size: 9},
+		{name: "string", data: "63616263", size: 4},
+		{name: "empty array", data: "80", size: 1},
+		{name: "nested", data: "a2616182010261628180", size: 10},
+		{name: "tagged", data: "c11a514b67b0", size: 6},
+		{name: "trailing", data: "0102", size: 1},
+		{name: "truncated string", data: "636162", err: "unexpected EOF"},
+		{name: "truncated array", data: "8301", err: "unexpected EOF"},
+		{name: "huge array", data: "9bffffffffffffffff", err: "unexpected EOF"},
+		{name: "indefinite", data: "9f01ff", err: "indefinite"},
+		{name: "too deep", data: strings.Repeat("81", 33) + "01", err: "nested deeper than 32 levels"},
+	} {
+		data, err := hex.DecodeString(tc.data)
+		require.NoError(t, err, tc.name)
+		size, err := checkCBORItem(data, maxCBORDepth)
+		if tc.err != "" {
+			if assert.Error(t, err, tc.name) {
+				assert.Contains(t, err.Error(), tc.err, tc.name)
+			}
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.size, size, tc.name)
+	}
+
+	// Deep nesting is rejected before it reaches the codec.
+	data := append(bytes.Repeat([]byte{0x81}, 100000), 0x01)
+	_, _, err := newTestCBORSerializer(t).Decode(data, nil, &v1beta1.NodeMetricsList{})
+	assert.Error(t, err)
+}
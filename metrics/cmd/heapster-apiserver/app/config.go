@@ -0,0 +1,141 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/spf13/pflag"
+
+	"github.com/kubernetes-incubator/metrics-server/common/flags"
+	"github.com/kubernetes-incubator/metrics-server/metrics/simulation"
+	"github.com/kubernetes-incubator/metrics-server/version"
+)
+
+const (
+	configPath = "/debug/config"
+	// Profiles are replayed without the redacted flags.
+	redactedValue = simulation.RedactedValue
+)
+
+// Flags which point at credentials, redacted as a whole.
+var sensitiveFlags = map[string]bool{
+	"remote-write-bearer-token-file":  true,
+	"remote-write-key-file":           true,
+	"remote-write-anonymize-key-file": true,
+	"tls-private-key-file":            true,
+}
+
+// Flags holding URLs which may embed credentials, as userinfo or query values. Only those
+// parts are redacted.
+var urlFlags = map[string]bool{
+	"source":           true,
+	"sink":             true,
+	"remote-write-url": true,
+	"snapshot_source":  true,
+	"canary_primary":   true,
+	"replay_target":    true,
+}
+
+type configSnapshot struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	// Effective value of every flag, including defaults.
+	Flags map[string]string `json:"flags"`
+	// Names of the flags which were explicitly set.
+	SetFlags []string `json:"setFlags"`
+}
+
+// InstallConfigHandler exposes the effective configuration of the server on /debug/config.
+func (h *HeapsterAPIServer) InstallConfigHandler(fs *pflag.FlagSet) {
+	h.Handler.NonGoRestfulMux.Handle(configPath, newConfigHandler(fs))
+}
+
+func newConfigHandler(fs *pflag.FlagSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := yaml.Marshal(getConfigSnapshot(fs))
+		if err != nil {
+			glog.Errorf("Error while encoding configuration: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+	})
+}
+
+func getConfigSnapshot(fs *pflag.FlagSet) *configSnapshot {
+	snapshot := &configSnapshot{
+		Version:  version.MetricsServerVersion,
+		Commit:   version.GitCommit,
+		Flags:    map[string]string{},
+		SetFlags: []string{},
+	}
+	fs.VisitAll(func(f *pflag.Flag) {
+		value := f.Value.String()
+		switch {
+		case sensitiveFlags[f.Name] && value != "":
+			value = redactedValue
+		case urlFlags[f.Name]:
+			value = redactURLFlag(f.Value)
+		}
+		snapshot.Flags[f.Name] = value
+		if f.Changed {
+			snapshot.SetFlags = append(snapshot.SetFlags, f.Name)
+		}
+	})
+	return snapshot
+}
+
+// redactURLFlag returns the value of the flag with the credentials of its URLs redacted.
+// Values which don't parse as URLs are redacted as a whole.
+func redactURLFlag(value pflag.Value) string {
+	if uris, ok := value.(*flags.Uris); ok {
+		result := make(flags.Uris, 0, len(*uris))
+		for _, uri := range *uris {
+			uri.Val = *redactURL(&uri.Val)
+			result = append(result, uri)
+		}
+		return result.String()
+	}
+	raw := value.String()
+	if raw == "" {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redactedValue
+	}
+	return redactURL(u).String()
+}
+
+// redactURL returns a copy of the URL with its userinfo and query values redacted.
+func redactURL(u *url.URL) *url.URL {
+	result := *u
+	if result.User != nil {
+		result.User = url.User(redactedValue)
+	}
+	if result.RawQuery != "" {
+		query := result.Query()
+		for key := range query {
+			query[key] = []string{redactedValue}
+		}
+		result.RawQuery = query.Encode()
+	}
+	return &result
+}
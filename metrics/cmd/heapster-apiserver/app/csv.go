@@ -0,0 +1,267 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+const csvContentType = "text/csv"
+
+var (
+	nodeMetricsCSVHeader = []string{"node", "timestamp", "window_seconds", "cpu_millicores", "memory_bytes"}
+	podMetricsCSVHeader  = []string{"namespace", "pod", "container", "timestamp", "window_seconds", "cpu_millicores", "memory_bytes"}
+)
+
+// pipedResponse passes the response of the API handler on as it's written, so that it
+// can be converted while it's being encoded.
+type pipedResponse struct {
+	header http.Header
+	status int
+	body   *io.PipeWriter
+	// Closed once the status is known.
+	started chan struct{}
+	once    sync.Once
+}
+
+func newPipedResponse(body *io.PipeWriter) *pipedResponse {
+	return &pipedResponse{
+		header:  http.Header{},
+		body:    body,
+		started: make(chan struct{}),
+	}
+}
+
+func (r *pipedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *pipedResponse) WriteHeader(status int) {
+	r.once.Do(func() {
+		r.status = status
+		close(r.started)
+	})
+}
+
+func (r *pipedResponse) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(data)
+}
+
+// withCSVOutput serves NodeMetrics and PodMetrics LIST responses as CSV, with one row per
+// node or container, to Metrics API requests accepting text/csv. The rows of every item are
+// written and flushed as soon as the item is encoded. Other responses, e.g. errors and
+// single objects, are served as JSON.
+func withCSVOutput(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, metricsAPIPrefix) || !strings.Contains(req.Header.Get("Accept"), csvContentType) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		req.Header.Set("Accept", "application/json")
+		body, pipe := io.Pipe()
+		resp := newPipedResponse(pipe)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					glog.Errorf("Panic while serving %s: %v", req.URL.Path, r)
+					resp.WriteHeader(http.StatusInternalServerError)
+					pipe.CloseWithError(fmt.Errorf("panic while serving the request"))
+					return
+				}
+				resp.WriteHeader(http.StatusOK)
+				pipe.Close()
+			}()
+			handler.ServeHTTP(resp, req)
+		}()
+		<-resp.started
+		// Drained, so that the handler always completes.
+		defer io.Copy(ioutil.Discard, body)
+
+		if resp.status != http.StatusOK {
+			copyResponse(w, resp, body)
+			return
+		}
+		// The list kind is the first field of lists, but is read ahead of the conversion, so
+		// the response can still be served as it is.
+		head := &bytes.Buffer{}
+		decoder := json.NewDecoder(io.TeeReader(body, head))
+		kind, err := decodeListKind(decoder)
+		if err != nil || (kind != "NodeMetricsList" && kind != "PodMetricsList") {
+			copyResponse(w, resp, io.MultiReader(head, body))
+			return
+		}
+		writeCSV(w, decoder, kind)
+	})
+}
+
+func copyResponse(w http.ResponseWriter, resp *pipedResponse, body io.Reader) {
+	for name, values := range resp.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.status)
+	io.Copy(w, body)
+}
+
+// decodeListKind decodes the opening of an object and returns its kind, if it's the first
+// field of the object.
+func decodeListKind(decoder *json.Decoder) (string, error) {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return "", err
+	}
+	key, err := decoder.Token()
+	if err != nil {
+		return "", err
+	}
+	if key != "kind" {
+		return "", fmt.Errorf("expected the kind first, got %v", key)
+	}
+	var kind string
+	err = decoder.Decode(&kind)
+	return kind, err
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// writeCSV writes the header and the rows of the items of the list being decoded, and
+// flushes them after every item. Errors can only be logged once the header is written.
+func writeCSV(w http.ResponseWriter, decoder *json.Decoder, kind string) {
+	header, rows := podMetricsCSVHeader, podMetricsCSVRows
+	if kind == "NodeMetricsList" {
+		header, rows = nodeMetricsCSVHeader, nodeMetricsCSVRows
+	}
+	w.Header().Set("Content-Type", csvContentType)
+	w.WriteHeader(http.StatusOK)
+	out := csv.NewWriter(w)
+	flush := func() error {
+		out.Flush()
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return out.Error()
+	}
+	if err := out.Write(header); err != nil {
+		glog.Errorf("Error while writing CSV: %v", err)
+		return
+	}
+	if err := writeCSVItems(decoder, out, rows, flush); err != nil {
+		glog.Errorf("Error while writing CSV: %v", err)
+		return
+	}
+	if err := flush(); err != nil {
+		glog.Errorf("Error while writing CSV: %v", err)
+	}
+}
+
+// writeCSVItems skips the fields of the list up to its items, and writes the rows of every
+// item as it's decoded.
+func writeCSVItems(decoder *json.Decoder, out *csv.Writer, rows func(*json.Decoder, *csv.Writer) error, flush func() error) error {
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key != "items" {
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := expectDelim(decoder, '['); err != nil {
+			return err
+		}
+		for decoder.More() {
+			if err := rows(decoder, out); err != nil {
+				return err
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nodeMetricsCSVRows(decoder *json.Decoder, out *csv.Writer) error {
+	item := &v1beta1.NodeMetrics{}
+	if err := decoder.Decode(item); err != nil {
+		return err
+	}
+	row := []string{item.Name}
+	row = append(row, usageCSVFields(item.Timestamp, item.Window, item.Usage)...)
+	return out.Write(row)
+}
+
+func podMetricsCSVRows(decoder *json.Decoder, out *csv.Writer) error {
+	item := &v1beta1.PodMetrics{}
+	if err := decoder.Decode(item); err != nil {
+		return err
+	}
+	for _, container := range item.Containers {
+		row := []string{item.Namespace, item.Name, container.Name}
+		row = append(row, usageCSVFields(item.Timestamp, item.Window, container.Usage)...)
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usageCSVFields returns the timestamp, window and usage columns. Resources left out of
+// the response, e.g. by the fields query parameter, have empty columns.
+func usageCSVFields(timestamp metav1.Time, window metav1.Duration, usage corev1.ResourceList) []string {
+	fields := []string{
+		timestamp.UTC().Format(time.RFC3339),
+		strconv.FormatFloat(window.Duration.Seconds(), 'f', -1, 64),
+		"",
+		"",
+	}
+	if cpu, found := usage[corev1.ResourceCPU]; found {
+		fields[2] = strconv.FormatInt(cpu.MilliValue(), 10)
+	}
+	if memory, found := usage[corev1.ResourceMemory]; found {
+		fields[3] = strconv.FormatInt(memory.Value(), 10)
+	}
+	return fields
+}
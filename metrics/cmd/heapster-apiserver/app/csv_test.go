@@ -0,0 +1,145 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testNodeMetricsCSVItem = `{"metadata":{"name":"n1"},"timestamp":"2018-01-01T00:00:00Z","window":"1m0s","usage":{"cpu":"250m","memory":"1Ki"}}`
+	testNodeMetricsCSVList = `{"kind":"NodeMetricsList","apiVersion":"metrics.k8s.io/v1beta1","metadata":{"selfLink":"/apis/metrics.k8s.io/v1beta1/nodes"},"items":[` +
+		testNodeMetricsCSVItem + `,{"metadata":{"name":"n2"},"timestamp":"2018-01-01T00:00:00Z","window":"30s","usage":{"memory":"2Ki"}}]}`
+	testPodMetricsCSVList = `{"kind":"PodMetricsList","apiVersion":"metrics.k8s.io/v1beta1","metadata":{},"items":[` +
+		`{"metadata":{"name":"p1","namespace":"ns"},"timestamp":"2018-01-01T00:00:00Z","window":"1m0s","containers":[` +
+		`{"name":"c1","usage":{"cpu":"1","memory":"0"}},{"name":"c2","usage":{"cpu":"5m","memory":"1Mi"}}]}]}`
+)
+
+func serveCSV(t *testing.T, path string, status int, body string) *httptest.ResponseRecorder {
+	handler := withCSVOutput(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(path, metricsAPIPrefix) {
+			assert.Equal(t, "application/json", req.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Accept", csvContentType)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCSVOutput(t *testing.T) {
+	rec := serveCSV(t, "/apis/metrics.k8s.io/v1beta1/nodes", http.StatusOK, testNodeMetricsCSVList)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, csvContentType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, "node,timestamp,window_seconds,cpu_millicores,memory_bytes\n"+
+		"n1,2018-01-01T00:00:00Z,60,250,1024\n"+
+		"n2,2018-01-01T00:00:00Z,30,,2048\n", rec.Body.String())
+	assert.True(t, rec.Flushed)
+
+	rec = serveCSV(t, "/apis/metrics.k8s.io/v1beta1/pods", http.StatusOK, testPodMetricsCSVList)
+	assert.Equal(t, "namespace,pod,container,timestamp,window_seconds,cpu_millicores,memory_bytes\n"+
+		"ns,p1,c1,2018-01-01T00:00:00Z,60,1000,0\n"+
+		"ns,p1,c2,2018-01-01T00:00:00Z,60,5,1048576\n", rec.Body.String())
+}
+
+func TestCSVOutputServesOtherResponses(t *testing.T) {
+	for name, test := range map[string]struct {
+		path   string
+		status int
+		body   string
+	}{
+		"error":       {"/apis/metrics.k8s.io/v1beta1/nodes", http.StatusForbidden, `{"kind":"Status","code":403}`},
+		"object":      {"/apis/metrics.k8s.io/v1beta1/nodes/n1", http.StatusOK, `{"kind":"NodeMetrics","metadata":{"name":"n1"}}`},
+		"kind last":   {"/apis/metrics.k8s.io/v1beta1/nodes", http.StatusOK, `{"items":[],"kind":"NodeMetricsList"}`},
+		"not json":    {"/apis/metrics.k8s.io/v1beta1/nodes", http.StatusOK, `nodes`},
+		"other path":  {"/api/v1/nodes", http.StatusOK, testNodeMetricsCSVList},
+		"empty error": {"/apis/metrics.k8s.io/v1beta1/nodes", http.StatusNotFound, ""},
+	} {
+		rec := serveCSV(t, test.path, test.status, test.body)
+		assert.Equal(t, test.status, rec.Code, name)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"), name)
+		assert.Equal(t, test.body, rec.Body.String(), name)
+	}
+}
+
+// flushRecorder signals every flush of the rows written so far.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	lock    sync.Mutex
+	flushed chan string
+}
+
+func (r *flushRecorder) Write(data []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.ResponseRecorder.Write(data)
+}
+
+func (r *flushRecorder) Flush() {
+	r.lock.Lock()
+	body := r.Body.String()
+	r.lock.Unlock()
+	r.flushed <- body
+}
+
+func TestCSVOutputStreamsItems(t *testing.T) {
+	proceed := make(chan struct{})
+	handler := withCSVOutput(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, `{"kind":"NodeMetricsList","metadata":{},"items":[`+testNodeMetricsCSVItem)
+		<-proceed
+		io.WriteString(w, `]}`)
+	}))
+	req := httptest.NewRequest("GET", "/apis/metrics.k8s.io/v1beta1/nodes", nil)
+	req.Header.Set("Accept", csvContentType)
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan string, 10)}
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	select {
+	case body := <-rec.flushed:
+		assert.Equal(t, "node,timestamp,window_seconds,cpu_millicores,memory_bytes\nn1,2018-01-01T00:00:00Z,60,250,1024\n", body,
+			"written before the list is complete")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the first item wasn't flushed")
+	}
+	close(proceed)
+	<-done
+	assert.Equal(t, 2, bytes.Count(rec.Body.Bytes(), []byte("\n")))
+}
+
+func TestCSVOutputTruncatedList(t *testing.T) {
+	rec := serveCSV(t, "/apis/metrics.k8s.io/v1beta1/nodes", http.StatusOK, testNodeMetricsCSVList[:len(testNodeMetricsCSVList)-20])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "\n")
+	assert.Equal(t, "node,timestamp,window_seconds,cpu_millicores,memory_bytes\nn1,2018-01-01T00:00:00Z,60,250,1024\n", rec.Body.String(),
+		"the complete items are served")
+}
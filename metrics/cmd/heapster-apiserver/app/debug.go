@@ -0,0 +1,45 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nodebug
+// +build !nodebug
+
+package app
+
+import (
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/summary"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/integrity"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/storagedump"
+)
+
+// The debug endpoints describing the stored batches and the latest scrapes.
+func init() {
+	registerHandlers(func(c *pluginContext) error {
+		c.mux.Handle(integrity.Path, integrity.NewHandler(c.metricSink))
+		c.mux.Handle(summary.DecodeReportPath, summary.NewDecodeReportHandler())
+		c.mux.Handle(summary.ScrapeTargetsPath, summary.NewScrapeTargetsHandler())
+		if c.scrapeFailures != nil {
+			c.mux.Handle(summary.ScrapeFailuresPath, summary.NewScrapeFailuresHandler(c.scrapeFailures))
+		}
+		if c.options.EnableDebugHandlers {
+			var pods storagedump.PodFilter
+			if storage, found := c.resources["pods"]; found {
+				pods = storage.(storagedump.PodFilter)
+			}
+			c.mux.Handle(storagedump.Path, storagedump.NewHandler(c.metricSink, c.options.MetricResolution,
+				c.resources["nodes"].(storagedump.NodeFilter), pods))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// Name of the encoder of the serializer the candidates are verified against.
+const baseEncoder = "reflect"
+
+var (
+	encoderSelected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "apiserver",
+			Name:      "encoder_selected",
+			Help:      "1 for the encoder selected on startup for a media type, 0 for the others.",
+		},
+		[]string{"media_type", "encoder"},
+	)
+	encoderEncodes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "apiserver",
+			Name:      "encodes_total",
+			Help:      "Number of objects encoded by media type and encoder, including the objects the selected encoder left to the reflect encoder.",
+		},
+		[]string{"media_type", "encoder"},
+	)
+	encoderFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "apiserver",
+			Name:      "encoder_failures_total",
+			Help:      "Number of objects the selected encoder failed to encode by media type and encoder, which were encoded by the reflect encoder instead.",
+		},
+		[]string{"media_type", "encoder"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(encoderSelected)
+	prometheus.MustRegister(encoderEncodes)
+	prometheus.MustRegister(encoderFailures)
+}
+
+// encoderCandidate encodes the objects it supports, and returns false without writing
+// anything for the others.
+type encoderCandidate struct {
+	name   string
+	encode func(obj runtime.Object, w io.Writer) (bool, error)
+}
+
+// selectingSerializer encodes with the first candidate whose output matched the base
+// serializer's on startup, and leaves the objects the selected candidate doesn't support
+// or fails to encode to the base serializer.
+type selectingSerializer struct {
+	mediaType string
+	base      runtime.Serializer
+	// Nil if no candidate was selected.
+	selected *encoderCandidate
+}
+
+// newSelectingSerializer verifies the candidates, in order, against the base serializer
+// on a sample list. The first one encoding it like the base serializer is selected, so
+// that all instances of a build encode alike. Candidates whose output differs or which
+// fail are not selected, so that a broken encoder falls back to the base serializer.
+func newSelectingSerializer(mediaType string, base runtime.Serializer, candidates []encoderCandidate) *selectingSerializer {
+	sample := encoderSample()
+	result := &selectingSerializer{mediaType: mediaType, base: base}
+	var reference bytes.Buffer
+	if err := base.Encode(sample, &reference); err != nil {
+		glog.Warningf("Not selecting %s encoders, the %s encoder failed on the sample: %v", mediaType, baseEncoder, err)
+		candidates = nil
+	}
+	for i := range candidates {
+		candidate := &candidates[i]
+		if err := verifyCandidate(candidate, sample, reference.Bytes()); err != nil {
+			glog.Warningf("Not selecting the %s %s encoder: %v", candidate.name, mediaType, err)
+			continue
+		}
+		result.selected = candidate
+		break
+	}
+	encoderSelected.WithLabelValues(mediaType, baseEncoder).Set(0)
+	for _, candidate := range candidates {
+		encoderSelected.WithLabelValues(mediaType, candidate.name).Set(0)
+	}
+	encoderSelected.WithLabelValues(mediaType, result.selectedName()).Set(1)
+	glog.Infof("Encoding %s with the %s encoder", mediaType, result.selectedName())
+	return result
+}
+
+// verifyCandidate checks that the candidate encodes the sample like the base serializer.
+func verifyCandidate(candidate *encoderCandidate, sample runtime.Object, reference []byte) error {
+	var output bytes.Buffer
+	encoded, err := candidate.encode(sample, &output)
+	if err != nil {
+		return err
+	}
+	if !encoded {
+		return fmt.Errorf("sample not supported")
+	}
+	if !bytes.Equal(output.Bytes(), reference) {
+		return fmt.Errorf("output differs from the %s encoder", baseEncoder)
+	}
+	return nil
+}
+
+// encoderSample returns a pod metrics list like the ones served for a cluster of a few
+// hundred pods.
+func encoderSample() runtime.Object {
+	timestamp := metav1.NewTime(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	list := &v1beta1.PodMetricsList{
+		TypeMeta: metav1.TypeMeta{Kind: "PodMetricsList", APIVersion: v1beta1.SchemeGroupVersion.String()},
+		ListMeta: metav1.ListMeta{SelfLink: "/apis/metrics.k8s.io/v1beta1/pods", ResourceVersion: "1514764800000000000"},
+	}
+	for i := 0; i < 300; i++ {
+		name := fmt.Sprintf("pod-%d", i)
+		pod := v1beta1.PodMetrics{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				SelfLink:          "/apis/metrics.k8s.io/v1beta1/namespaces/default/pods/" + name,
+				CreationTimestamp: timestamp,
+				ResourceVersion:   "1514764800000000000",
+			},
+			Timestamp: timestamp,
+			Window:    metav1.Duration{Duration: time.Minute},
+		}
+		for _, container := range []string{"app", "sidecar"} {
+			pod.Containers = append(pod.Containers, v1beta1.ContainerMetrics{
+				Name: container,
+				Usage: corev1.ResourceList{
+					corev1.ResourceCPU:    *resource.NewMilliQuantity(int64(i%1000), resource.DecimalSI),
+					corev1.ResourceMemory: *resource.NewQuantity(int64(i)<<20, resource.BinarySI),
+				},
+			})
+		}
+		list.Items = append(list.Items, pod)
+	}
+	return list
+}
+
+func (this *selectingSerializer) selectedName() string {
+	if this.selected == nil {
+		return baseEncoder
+	}
+	return this.selected.name
+}
+
+// Encode encodes the object with the selected candidate into a buffer, so that nothing is
+// written if it fails and the base serializer encodes the object instead.
+func (this *selectingSerializer) Encode(obj runtime.Object, w io.Writer) error {
+	if this.selected != nil {
+		var buf bytes.Buffer
+		encoded, err := this.selected.encode(obj, &buf)
+		if encoded && err == nil {
+			encoderEncodes.WithLabelValues(this.mediaType, this.selected.name).Inc()
+			_, err = w.Write(buf.Bytes())
+			return err
+		}
+		if err != nil {
+			encoderFailures.WithLabelValues(this.mediaType, this.selected.name).Inc()
+			glog.Errorf("The %s %s encoder failed on %T, falling back to the %s encoder: %v", this.selected.name, this.mediaType, obj, baseEncoder, err)
+		}
+	}
+	encoderEncodes.WithLabelValues(this.mediaType, baseEncoder).Inc()
+	return this.base.Encode(obj, w)
+}
+
+func (this *selectingSerializer) Decode(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	return this.base.Decode(data, defaults, into)
+}
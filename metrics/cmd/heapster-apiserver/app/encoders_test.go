@@ -0,0 +1,100 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+func newTestJSONSerializer(t *testing.T) runtime.Serializer {
+	info, found := runtime.SerializerInfoForMediaType(Codecs.SupportedMediaTypes(), runtime.ContentTypeJSON)
+	require.True(t, found)
+	return info.Serializer
+}
+
+// copyingCandidate encodes like the base serializer.
+func copyingCandidate(name string, base runtime.Serializer) encoderCandidate {
+	return encoderCandidate{name: name, encode: func(obj runtime.Object, w io.Writer) (bool, error) {
+		return true, base.Encode(obj, w)
+	}}
+}
+
+// failingCandidate writes part of an object before failing.
+func failingCandidate(name string) encoderCandidate {
+	return encoderCandidate{name: name, encode: func(obj runtime.Object, w io.Writer) (bool, error) {
+		w.Write([]byte("{\"kind\":"))
+		return true, fmt.Errorf("broken")
+	}}
+}
+
+func TestSelectingSerializerSelectsFirstMatching(t *testing.T) {
+	base := newTestJSONSerializer(t)
+	differing := encoderCandidate{name: "differing", encode: func(obj runtime.Object, w io.Writer) (bool, error) {
+		w.Write([]byte("{}"))
+		return true, nil
+	}}
+	unsupported := encoderCandidate{name: "unsupported", encode: func(obj runtime.Object, w io.Writer) (bool, error) {
+		return false, nil
+	}}
+	candidates := []encoderCandidate{failingCandidate("failing"), differing, unsupported, copyingCandidate("first", base), copyingCandidate("second", base)}
+
+	// The selection doesn't depend on timing, so every run selects the same candidate.
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "first", newSelectingSerializer("test/selects", base, candidates).selectedName())
+	}
+	assert.Equal(t, baseEncoder, newSelectingSerializer("test/none", base, candidates[:3]).selectedName())
+	assert.Equal(t, baseEncoder, newSelectingSerializer("test/empty", base, nil).selectedName())
+}
+
+func TestSelectingSerializerFallsBack(t *testing.T) {
+	base := newTestJSONSerializer(t)
+	var expected bytes.Buffer
+	require.NoError(t, base.Encode(encoderSample(), &expected))
+
+	// Fails on the objects it's given after being selected.
+	var fail bool
+	flaky := encoderCandidate{name: "flaky", encode: func(obj runtime.Object, w io.Writer) (bool, error) {
+		if fail {
+			return failingCandidate("").encode(obj, w)
+		}
+		return true, base.Encode(obj, w)
+	}}
+	// Leaves pod metrics to the base serializer after being selected.
+	nodesOnly := encoderCandidate{name: "nodes", encode: func(obj runtime.Object, w io.Writer) (bool, error) {
+		if _, ok := obj.(*v1beta1.PodMetricsList); ok && fail {
+			return false, nil
+		}
+		return true, base.Encode(obj, w)
+	}}
+
+	for _, candidate := range []encoderCandidate{flaky, nodesOnly} {
+		serializer := newSelectingSerializer("test/"+candidate.name, base, []encoderCandidate{candidate})
+		require.Equal(t, candidate.name, serializer.selectedName())
+		fail = true
+		var output bytes.Buffer
+		require.NoError(t, serializer.Encode(encoderSample(), &output), candidate.name)
+		assert.Equal(t, expected.String(), output.String(), candidate.name)
+		fail = false
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nofastjson
+// +build !nofastjson
+
+package app
+
+import (
+	"github.com/kubernetes-incubator/metrics-server/metrics/metricsjson"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+)
+
+// FastJSONEncoding encodes Metrics API objects as JSON without reflection, which takes a
+// fraction of the CPU for large lists. The output is the same. The generated encoder is
+// verified against reflection on startup and only used if its output matches. Objects it
+// fails to encode are encoded with reflection.
+const FastJSONEncoding utilfeature.Feature = "FastJSONEncoding"
+
+func init() {
+	registerSerializer(FastJSONEncoding, func(json runtime.Serializer) runtime.SerializerInfo {
+		return runtime.SerializerInfo{
+			MediaType:     runtime.ContentTypeJSON,
+			EncodesAsText: true,
+			Serializer: newSelectingSerializer(runtime.ContentTypeJSON, json, []encoderCandidate{
+				{name: "generated", encode: metricsjson.Encode},
+			}),
+		}
+	})
+}
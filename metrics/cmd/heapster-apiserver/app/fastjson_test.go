@@ -0,0 +1,43 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nofastjson
+// +build !nofastjson
+
+package app
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFastJSONEncodingSelectsGenerated(t *testing.T) {
+	base := newTestJSONSerializer(t)
+	var serializer *selectingSerializer
+	for _, plugin := range serializerPlugins {
+		if plugin.feature == FastJSONEncoding {
+			serializer = plugin.factory(base).Serializer.(*selectingSerializer)
+		}
+	}
+	require.NotNil(t, serializer)
+	assert.Equal(t, "generated", serializer.selectedName())
+
+	var expected, output bytes.Buffer
+	require.NoError(t, base.Encode(encoderSample(), &expected))
+	require.NoError(t, serializer.Encode(encoderSample(), &output))
+	assert.Equal(t, expected.String(), output.String())
+}
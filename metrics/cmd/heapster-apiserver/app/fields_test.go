@@ -0,0 +1,71 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	"github.com/stretchr/testify/assert"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// newTestFieldSelection returns a handler selecting the fields of requests for resource,
+// recording the selection in selected.
+func newTestFieldSelection(resource string, selected **util.FieldSelection) http.Handler {
+	mapper := genericapirequest.NewRequestContextMapper()
+	inner := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, _ := mapper.Get(req)
+		*selected = util.FieldSelectionFrom(ctx)
+	})
+	withInfo := func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx, _ := mapper.Get(req)
+			info := &genericapirequest.RequestInfo{IsResourceRequest: true, Verb: "get", Resource: resource}
+			mapper.Update(req, genericapirequest.WithRequestInfo(ctx, info))
+			handler.ServeHTTP(w, req)
+		})
+	}
+	return genericapirequest.WithRequestContext(withInfo(withFieldSelection(inner, mapper)), mapper)
+}
+
+func TestWithFieldSelection(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		resource string
+		path     string
+		fields   string
+		code     int
+		selected bool
+	}{
+		{name: "pod usage", resource: "pods", path: "v1beta1/namespaces/ns1/pods/pod1", fields: "containers[*].usage.cpu", code: http.StatusOK, selected: true},
+		{name: "node usage", resource: "nodes", path: "v1beta1/nodes/node1", fields: "usage.cpu", code: http.StatusOK, selected: true},
+		{name: "node usage of a pods node", resource: "nodes", path: "v1beta1/nodes/pods-1", fields: "usage.cpu", code: http.StatusOK, selected: true},
+		{name: "container usage of a pods node", resource: "nodes", path: "v1beta1/nodes/pods-1", fields: "containers[*].usage.cpu", code: http.StatusBadRequest},
+		{name: "node usage of pods", resource: "pods", path: "v1beta1/namespaces/ns1/pods/pod1", fields: "usage.cpu", code: http.StatusBadRequest},
+		{name: "unknown field", resource: "nodes", path: "v1beta1/nodes/node1", fields: "spec", code: http.StatusBadRequest},
+		{name: "no fields", resource: "nodes", path: "v1beta1/nodes/node1", code: http.StatusOK},
+	} {
+		var selected *util.FieldSelection
+		handler := newTestFieldSelection(tc.resource, &selected)
+		req := httptest.NewRequest("GET", metricsAPIPrefix+tc.path+"?"+util.FieldsParam+"="+tc.fields, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.name)
+		assert.Equal(t, tc.selected, selected != nil, tc.name)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	"github.com/stretchr/testify/assert"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+func TestWithGroupBy(t *testing.T) {
+	for _, tc := range []struct {
+		label string
+		code  int
+	}{
+		{label: "topology.kubernetes.io/zone", code: http.StatusOK},
+		{label: "zone", code: http.StatusOK},
+		{label: "-zone", code: http.StatusBadRequest},
+		{label: "", code: http.StatusOK},
+	} {
+		mapper := genericapirequest.NewRequestContextMapper()
+		selected := ""
+		inner := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx, _ := mapper.Get(req)
+			selected = util.GroupByFrom(ctx)
+		})
+		handler := genericapirequest.WithRequestContext(withGroupBy(inner, mapper), mapper)
+		req := httptest.NewRequest("GET", metricsAPIPrefix+"v1beta1/nodes?"+util.GroupByParam+"="+tc.label, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.label)
+		if tc.code == http.StatusOK {
+			assert.Equal(t, tc.label, selected, tc.label)
+		}
+	}
+}
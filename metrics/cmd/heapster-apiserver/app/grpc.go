@@ -0,0 +1,65 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/grpcapi"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+)
+
+// serveGRPC serves the Metrics gRPC service at the address, with the serving certificate
+// of the API. Client certificates are requested so the service authenticates clients like
+// the API does.
+func serveGRPC(address string, service *grpcapi.Server, serving *genericapiserver.SecureServingInfo, stopCh <-chan struct{}) error {
+	var opts []grpc.ServerOption
+	if serving != nil && serving.Cert != nil {
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{*serving.Cert},
+			MinVersion:   serving.MinTLSVersion,
+			CipherSuites: serving.CipherSuites,
+		}
+		if serving.ClientCA != nil {
+			tlsConfig.ClientCAs = serving.ClientCA
+			tlsConfig.ClientAuth = tls.RequestClientCert
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("unable to listen on grpc address %s: %v", address, err)
+	}
+
+	server := grpc.NewServer(opts...)
+	service.Register(server)
+	go func() {
+		<-stopCh
+		server.Stop()
+	}()
+	go func() {
+		glog.Infof("Serving gRPC on %s", address)
+		if err := server.Serve(listener); err != nil {
+			glog.Errorf("Error while serving gRPC on %s: %v", address, err)
+		}
+	}()
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
 	nodemetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/nodemetrics"
 	podmetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/podmetrics"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
 	"k8s.io/apimachinery/pkg/apimachinery/announced"
 	"k8s.io/apimachinery/pkg/apimachinery/registered"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -42,8 +43,26 @@ var (
 	Codecs               = serializer.NewCodecFactory(Scheme)
 )
 
-func installMetricsAPIs(s *options.HeapsterRunOptions, g *genericapiserver.GenericAPIServer,
-	metricSink *metricsink.MetricSink, nodeLister v1listers.NodeLister, podLister v1listers.PodLister) {
+// newMetricsStorages returns the storages of the Metrics API by resource. They only serve
+// the nodes of the shard of the instance.
+func newMetricsStorages(s *options.HeapsterRunOptions, metricSink *metricsink.MetricSink,
+	nodeLister v1listers.NodeLister, podLister v1listers.PodLister) map[string]rest.Storage {
+	shard := metricsutil.Shard{Index: s.ShardIndex, Count: s.ShardCount}
+	nodemetricsStorage := nodemetricsstorage.NewStorage(metrics.Resource("nodemetrics"), metricSink, nodeLister,
+		s.ListUnschedulableNodes, s.MetricResolution, shard)
+	heapsterResources := map[string]rest.Storage{
+		"nodes": nodemetricsStorage,
+	}
+	if podLister != nil {
+		heapsterResources["pods"] = podmetricsstorage.NewStorage(metrics.Resource("podmetrics"), metricSink, podLister,
+			s.PlaceholderPodMetrics, s.ListExcludedPriorityClasses, s.ListCompletedPods, s.MetricResolution, s.PodMetricsMinAge,
+			s.EphemeralContainerMetrics, shard)
+	}
+	return heapsterResources
+}
+
+// installMetricsAPIs installs the Metrics API group serving the storages.
+func installMetricsAPIs(g *genericapiserver.GenericAPIServer, heapsterResources map[string]rest.Storage) {
 	install.Install(groupFactoryRegistry, registry, Scheme)
 
 	// we need to add the options to empty v1
@@ -60,13 +79,7 @@ func installMetricsAPIs(s *options.HeapsterRunOptions, g *genericapiserver.Gener
 
 	apiGroupInfo := genericapiserver.NewDefaultAPIGroupInfo(metrics.GroupName, registry, Scheme, metav1.ParameterCodec, Codecs)
 	apiGroupInfo.GroupMeta.GroupVersion = v1beta1.SchemeGroupVersion
-
-	nodemetricsStorage := nodemetricsstorage.NewStorage(metrics.Resource("nodemetrics"), metricSink, nodeLister)
-	podmetricsStorage := podmetricsstorage.NewStorage(metrics.Resource("podmetrics"), metricSink, podLister)
-	heapsterResources := map[string]rest.Storage{
-		"nodes": nodemetricsStorage,
-		"pods":  podmetricsStorage,
-	}
+	apiGroupInfo.NegotiatedSerializer = newNegotiatedSerializer(Codecs)
 	apiGroupInfo.VersionedResourcesStorageMap[v1beta1.SchemeGroupVersion.Version] = heapsterResources
 
 	if err := g.InstallAPIGroup(&apiGroupInfo); err != nil {
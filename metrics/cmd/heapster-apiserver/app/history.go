@@ -0,0 +1,42 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nohistory
+// +build !nohistory
+
+package app
+
+import (
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/history"
+)
+
+// The history subresources of NodeMetrics and PodMetrics, served from a buffer of the last
+// points per node and container. Series without points for the span of a full buffer
+// are dropped, their points would have been overwritten by then.
+func init() {
+	registerHandlers(func(c *pluginContext) error {
+		if c.options.HistoryPoints == 0 {
+			return nil
+		}
+		buffer := history.NewBuffer(c.options.HistoryPoints, time.Duration(c.options.HistoryPoints)*c.options.MetricResolution)
+		buffer.Subscribe(c.eventBus)
+		c.resources["nodes/"+history.Subresource] = history.NewNodeStorage(buffer)
+		if _, found := c.resources["pods"]; found {
+			c.resources["pods/"+history.Subresource] = history.NewPodStorage(buffer)
+		}
+		return nil
+	})
+}
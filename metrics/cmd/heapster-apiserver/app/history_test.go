@@ -0,0 +1,69 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nohistory
+// +build !nohistory
+
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/authorization/authorizerfactory"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	restclient "k8s.io/client-go/rest"
+)
+
+func TestHistorySubresources(t *testing.T) {
+	config := genericapiserver.NewConfig(Codecs)
+	config.AdmissionControl = admission.NewChainHandler()
+	config.Authorizer = authorizerfactory.NewAlwaysAllowAuthorizer()
+	config.LoopbackClientConfig = &restclient.Config{}
+	server, err := config.Complete().New("test", genericapiserver.EmptyDelegate)
+	require.NoError(t, err)
+
+	s := options.NewHeapsterRunOptions()
+	s.HistoryPoints = 3
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, nil)
+	storages := newMetricsStorages(s, metricSink, nil, nil)
+	plugins := &pluginContext{
+		options:    s,
+		mux:        server.Handler.NonGoRestfulMux,
+		resources:  storages,
+		metricSink: metricSink,
+		eventBus:   bus.NewBus(bus.DefaultSubscriberBufferSize),
+	}
+	for _, install := range handlerPlugins {
+		require.NoError(t, install(plugins))
+	}
+	assert.Contains(t, storages, "nodes/history")
+	assert.NotContains(t, storages, "pods/history", "pods aren't served without a pod lister")
+	installMetricsAPIs(server, storages)
+
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, metricsAPIPrefix+"v1beta1/nodes/n1/history", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), `"kind":"nodes/history"`), rec.Body.String())
+}
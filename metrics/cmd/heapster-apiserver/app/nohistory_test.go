@@ -0,0 +1,48 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build nohistory
+// +build nohistory
+
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	genericmux "k8s.io/apiserver/pkg/server/mux"
+)
+
+func TestNoHistory(t *testing.T) {
+	assert.Zero(t, metricsink.LongStoreDuration, "no long store is kept")
+
+	s := options.NewHeapsterRunOptions()
+	s.HistoryPoints = 3
+	plugins := &pluginContext{
+		options:    s,
+		mux:        genericmux.NewPathRecorderMux("test"),
+		metricSink: metricsink.NewMetricSink(time.Minute, metricsink.LongStoreDuration, nil),
+	}
+	var errs []error
+	for _, install := range handlerPlugins {
+		if err := install(plugins); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	assert.Len(t, errs, 1, "history points are refused")
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"io"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+
+	"github.com/kubernetes-incubator/metrics-server/common/flags"
+	"github.com/kubernetes-incubator/metrics-server/metrics/simulation"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Host put in place of the hosts of source and sink URIs in profiles.
+const redactedHost = "redacted"
+
+// profile is the effective configuration together with the shape of the cluster, for
+// reproducing reported issues without access to the cluster with config replay. It carries
+// no names.
+type profile struct {
+	*configSnapshot
+	Cluster simulation.ClusterShape `json:"cluster"`
+}
+
+// WriteProfile writes the effective configuration and the anonymized shape of the cluster
+// with the given nodes and pods as YAML. Credentials and the hosts of source and sink URIs
+// are redacted.
+func WriteProfile(w io.Writer, fs *pflag.FlagSet, nodes []corev1.Node, pods []corev1.Pod) error {
+	p := &profile{
+		configSnapshot: getConfigSnapshot(fs),
+		Cluster:        simulation.GetClusterShape(nodes, pods),
+	}
+	fs.VisitAll(func(f *pflag.Flag) {
+		if uris, ok := f.Value.(*flags.Uris); ok {
+			p.Flags[f.Name] = anonymizeUris(*uris).String()
+		}
+	})
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func anonymizeUris(uris flags.Uris) *flags.Uris {
+	result := make(flags.Uris, 0, len(uris))
+	for _, uri := range uris {
+		uri.Val = *redactURL(&uri.Val)
+		uri.Val.User = nil
+		if uri.Val.Host != "" {
+			uri.Val.Host = redactedHost
+		}
+		result = append(result, uri)
+	}
+	return &result
+}
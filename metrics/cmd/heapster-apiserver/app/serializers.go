@@ -0,0 +1,98 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+)
+
+// SerializerFactory creates a serializer on top of the JSON serializer of the codec factory.
+type SerializerFactory func(json runtime.Serializer) runtime.SerializerInfo
+
+type serializerPlugin struct {
+	feature utilfeature.Feature
+	factory SerializerFactory
+}
+
+// Serializers served in addition to the ones of the codec factory. Each is registered by
+// a file which can be left out of the build with a tag.
+var serializerPlugins []serializerPlugin
+
+// registerSerializer offers the serializer to clients while the feature is enabled. The
+// feature is added to the default feature gate as alpha. It's meant to be called from
+// init functions.
+func registerSerializer(feature utilfeature.Feature, factory SerializerFactory) {
+	err := utilfeature.DefaultFeatureGate.Add(map[utilfeature.Feature]utilfeature.FeatureSpec{
+		feature: {Default: false, PreRelease: utilfeature.Alpha},
+	})
+	if err != nil {
+		panic(err)
+	}
+	serializerPlugins = append(serializerPlugins, serializerPlugin{feature: feature, factory: factory})
+}
+
+// negotiatedSerializer extends the media types of the codec factory by extra serializers.
+// An extra serializer of a media type the codec factory already serves replaces its
+// serializer, keeping the pretty and stream serializers.
+type negotiatedSerializer struct {
+	runtime.NegotiatedSerializer
+	extra []runtime.SerializerInfo
+}
+
+func (this *negotiatedSerializer) SupportedMediaTypes() []runtime.SerializerInfo {
+	base := this.NegotiatedSerializer.SupportedMediaTypes()
+	result := make([]runtime.SerializerInfo, 0, len(base)+len(this.extra))
+	result = append(result, base...)
+	for _, extra := range this.extra {
+		replaced := false
+		for i := range result {
+			if result[i].MediaType == extra.MediaType {
+				result[i].Serializer = extra.Serializer
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, extra)
+		}
+	}
+	return result
+}
+
+// newNegotiatedSerializer returns the codec factory with the registered serializers whose
+// features are enabled.
+func newNegotiatedSerializer(codecs serializer.CodecFactory) runtime.NegotiatedSerializer {
+	info, found := runtime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), runtime.ContentTypeJSON)
+	if !found {
+		glog.Warningf("No JSON serializer, serving only the default media types")
+		return codecs
+	}
+	var extra []runtime.SerializerInfo
+	for _, plugin := range serializerPlugins {
+		if !utilfeature.DefaultFeatureGate.Enabled(plugin.feature) {
+			continue
+		}
+		serializerInfo := plugin.factory(info.Serializer)
+		glog.Infof("Serving %s, enabled by feature %s", serializerInfo.MediaType, plugin.feature)
+		extra = append(extra, serializerInfo)
+	}
+	if len(extra) == 0 {
+		return codecs
+	}
+	return &negotiatedSerializer{NegotiatedSerializer: codecs, extra: extra}
+}
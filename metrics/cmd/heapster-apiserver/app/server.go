@@ -20,11 +20,28 @@ package app
 import (
 	"fmt"
 	"net"
+	"net/http"
 
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/grpcapi"
+	"github.com/kubernetes-incubator/metrics-server/metrics/operator"
 	"github.com/kubernetes-incubator/metrics-server/metrics/options"
 	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/snapshot"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/summary"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/batchdiff"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/extendedmetrics"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/resizemetrics"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/systemmetrics"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/tenantmetrics"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/workloadmetrics"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/registry/rest"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	genericmux "k8s.io/apiserver/pkg/server/mux"
+	appslisters "k8s.io/client-go/listers/apps/v1beta2"
 	v1listers "k8s.io/client-go/listers/core/v1"
 )
 
@@ -32,58 +49,238 @@ const (
 	msName = "Metrics Server"
 )
 
+// What the optional endpoints of the server are installed with.
+type pluginContext struct {
+	options *options.HeapsterRunOptions
+	mux     *genericmux.PathRecorderMux
+	// Storages of the Metrics API by resource, which subresources can be added to before
+	// the API is installed. Without a pod lister there are no pods.
+	resources  map[string]rest.Storage
+	metricSink *metricsink.MetricSink
+	eventBus   *bus.Bus
+	// Failed scrapes of the latest cycle, nil when the source doesn't classify them.
+	scrapeFailures func() summary.ScrapeFailures
+}
+
+// Installs optional endpoints on the server, or fails if the options ask for one which
+// was left out of the build.
+type handlerPlugin func(c *pluginContext) error
+
+// Optional endpoints of the server. Each is registered by a file which can be left out of
+// the build with a tag.
+var handlerPlugins []handlerPlugin
+
+// registerHandlers adds the plugin to the ones run when the server is created. It's meant
+// to be called from init functions.
+func registerHandlers(plugin handlerPlugin) {
+	handlerPlugins = append(handlerPlugins, plugin)
+}
+
 type HeapsterAPIServer struct {
 	*genericapiserver.GenericAPIServer
 	options    *options.HeapsterRunOptions
 	metricSink *metricsink.MetricSink
 	nodeLister v1listers.NodeLister
+	// Nil unless metrics are also served over gRPC.
+	grpcService   *grpcapi.Server
+	secureServing *genericapiserver.SecureServingInfo
 }
 
 // Run runs the specified APIServer. This should never exit.
 func (h *HeapsterAPIServer) RunServer() error {
-	return h.PrepareRun().Run(wait.NeverStop)
+	prepared := h.PrepareRun()
+	if h.options.UnixSocket != "" {
+		if err := serveUnixSocket(h.options.UnixSocket, h.Handler, wait.NeverStop); err != nil {
+			return err
+		}
+	}
+	if h.grpcService != nil {
+		if err := serveGRPC(h.options.GRPCAddress, h.grpcService, h.secureServing, wait.NeverStop); err != nil {
+			return err
+		}
+	}
+	return prepared.Run(wait.NeverStop)
 }
 
-func NewHeapsterApiServer(s *options.HeapsterRunOptions, metricSink *metricsink.MetricSink,
-	nodeLister v1listers.NodeLister, podLister v1listers.PodLister) (*HeapsterAPIServer, error) {
+// NewHeapsterApiServer creates the server of the Metrics API. Without a pod lister only
+// NodeMetrics are served, and without scrapeFailures the failed scrapes aren't.
+func NewHeapsterApiServer(s *options.HeapsterRunOptions, metricSink *metricsink.MetricSink, eventBus *bus.Bus,
+	nodeLister v1listers.NodeLister, podLister v1listers.PodLister, replicaSetLister appslisters.ReplicaSetLister,
+	scrapeFailures func() summary.ScrapeFailures) (*HeapsterAPIServer, error) {
 
-	server, err := newAPIServer(s)
+	// The requests of the API and the calls of the gRPC service share the access log.
+	var accessLog *accessLog
+	if s.AccessLogFile != "" {
+		accessLog = newAccessLog(newAccessLogWriter(s))
+	}
+	server, serverConfig, err := newAPIServer(s, metricSink, nodeLister, accessLog)
 	if err != nil {
 		return &HeapsterAPIServer{}, err
 	}
 
-	installMetricsAPIs(s, server, metricSink, nodeLister, podLister)
+	storages := newMetricsStorages(s, metricSink, nodeLister, podLister)
+	// Only serves the nodes of this shard.
+	storages["nodes/"+extendedmetrics.Subresource] = extendedmetrics.NewNodeStorage(storages["nodes"].(extendedmetrics.NodeGetter))
+	// Resize recommendations are computed from the long store.
+	if podLister != nil && metricsink.LongStoreDuration > 0 {
+		storages["pods/"+resizemetrics.Subresource] = resizemetrics.NewStorage(metricSink, podLister, options.MaxSlowWindow)
+	}
+	plugins := &pluginContext{
+		options:        s,
+		mux:            server.Handler.NonGoRestfulMux,
+		resources:      storages,
+		metricSink:     metricSink,
+		eventBus:       eventBus,
+		scrapeFailures: scrapeFailures,
+	}
+	for _, install := range handlerPlugins {
+		if err := install(plugins); err != nil {
+			return &HeapsterAPIServer{}, err
+		}
+	}
+	// The API fans out to the peer shards, the other endpoints only serve this one.
+	apiStorages := storages
+	if len(s.ShardPeers) > 0 {
+		if apiStorages, err = withShardPeers(s, storages, podLister); err != nil {
+			return &HeapsterAPIServer{}, err
+		}
+	}
+	installMetricsAPIs(server, apiStorages)
+	var pods rest.Lister
+	if podLister != nil {
+		pods = storages["pods"].(rest.Lister)
+	}
+	var grpcService *grpcapi.Server
+	if s.GRPCAddress != "" {
+		var podVisitor grpcapi.PodMetricsVisitor
+		if podLister != nil {
+			podVisitor = storages["pods"].(grpcapi.PodMetricsVisitor)
+		}
+		var logCall func(*grpcapi.Call)
+		if accessLog != nil {
+			logCall = accessLog.logGRPCCall
+		}
+		grpcService = grpcapi.NewServer(storages["nodes"].(grpcapi.NodeMetricsVisitor), podVisitor, Scheme,
+			serverConfig.Authenticator, serverConfig.Authorizer, logCall)
+	}
+	if podLister != nil {
+		server.Handler.NonGoRestfulMux.Handle(workloadmetrics.Path,
+			workloadmetrics.NewHandler(metricSink, podLister, replicaSetLister))
+	}
+	server.Handler.NonGoRestfulMux.Handle(systemmetrics.Path, systemmetrics.NewHandler(metricSink))
+	server.Handler.NonGoRestfulMux.Handle(batchdiff.Path, batchdiff.NewHandler(metricSink,
+		storages["nodes"].(rest.Lister), pods, serverConfig.Authorizer, server.RequestContextMapper()))
+	if s.PushMaxAge > 0 {
+		server.Handler.NonGoRestfulMux.Handle(summary.PushPath, summary.NewPushHandler(server.RequestContextMapper(), podLister))
+	}
+	if s.TenantTemplate != "" {
+		server.Handler.NonGoRestfulMux.Handle(tenantmetrics.Path, tenantmetrics.NewHandler(metricSink,
+			serverConfig.Authorizer, server.RequestContextMapper()))
+	}
+	if s.ServeSnapshot {
+		server.Handler.NonGoRestfulMux.Handle(snapshot.Path, snapshot.NewHandler(metricSink))
+	}
 
 	return &HeapsterAPIServer{
 		GenericAPIServer: server,
 		options:          s,
 		metricSink:       metricSink,
 		nodeLister:       nodeLister,
+		grpcService:      grpcService,
+		secureServing:    serverConfig.SecureServingInfo,
 	}, nil
 }
 
-func newAPIServer(s *options.HeapsterRunOptions) (*genericapiserver.GenericAPIServer, error) {
-	if err := s.SecureServing.MaybeDefaultWithSelfSignedCerts("localhost", nil, []net.IP{net.ParseIP("127.0.0.1")}); err != nil {
-		return nil, fmt.Errorf("error creating self-signed certificates: %v", err)
+// newAPIServer creates the generic API server, and returns it with the config it was
+// created from. Requests are written to the access log unless it's nil.
+func newAPIServer(s *options.HeapsterRunOptions, metricSink *metricsink.MetricSink,
+	nodeLister v1listers.NodeLister, accessLog *accessLog) (*genericapiserver.GenericAPIServer, *genericapiserver.Config, error) {
+	advertiseAddress, err := chooseAdvertiseAddress(s.SecureServing.BindAddress, s.AddressFamily)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	alternateIPs := []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback}
+	if advertiseAddress != nil {
+		alternateIPs = append(alternateIPs, advertiseAddress)
+	}
+	if err := s.SecureServing.MaybeDefaultWithSelfSignedCerts("localhost", nil, alternateIPs); err != nil {
+		return nil, nil, fmt.Errorf("error creating self-signed certificates: %v", err)
 	}
 
 	serverConfig := genericapiserver.NewConfig(Codecs)
 	serverConfig.EnableMetrics = true
+	serverConfig.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+		apiHandler = withTimeSelection(apiHandler, c.RequestContextMapper, metricSink)
+		slowWindow := s.SlowWindow
+		if metricsink.LongStoreDuration == 0 {
+			slowWindow = 0
+		}
+		apiHandler = withWindowSelection(apiHandler, c.RequestContextMapper, slowWindow)
+		apiHandler = withGroupBy(apiHandler, c.RequestContextMapper)
+		apiHandler = withIncludeUnschedulable(apiHandler, c.RequestContextMapper)
+		apiHandler = withFieldSelection(apiHandler, c.RequestContextMapper)
+		apiHandler = withCSVOutput(apiHandler)
+		shard := util.Shard{Index: s.ShardIndex, Count: s.ShardCount}
+		apiHandler = withRequestTimeout(apiHandler, c.RequestContextMapper, c.RequestTimeout, metricSink, nodeLister, shard, s.MetricResolution)
+		handler := genericapiserver.DefaultBuildHandlerChain(withMetricsWarnings(apiHandler, metricSink, nodeLister, shard, s.MetricResolution), c)
+		if s.ConfigResource != "" {
+			// The API server calls admission webhooks without credentials.
+			handler = withUnauthenticatedHandler(handler, operator.WebhookPath, operator.NewValidatingWebhook())
+		}
+		if accessLog != nil {
+			handler = withAccessLog(handler, c.RequestContextMapper, accessLog)
+		}
+		return withSLIRecording(handler, c.RequestContextMapper)
+	}
 
 	if err := s.SecureServing.ApplyTo(serverConfig); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	serverConfig.PublicAddress = advertiseAddress
 
 	if !s.DisableAuthForTesting {
-		if err := s.Authentication.ApplyTo(serverConfig); err != nil {
-			return nil, err
-		}
-		if err := s.Authorization.ApplyTo(serverConfig); err != nil {
-			return nil, err
+		if err := applyDelegatedAuth(serverConfig, s); err != nil {
+			return nil, nil, err
 		}
 	}
 
 	serverConfig.SwaggerConfig = genericapiserver.DefaultSwaggerConfig()
+	// Nothing is admitted, but the connect handlers of the subresources expect a chain.
+	serverConfig.AdmissionControl = admission.NewChainHandler()
+
+	server, err := serverConfig.Complete().New(msName, genericapiserver.EmptyDelegate)
+	return server, serverConfig, err
+}
 
-	return serverConfig.Complete().New(msName, genericapiserver.EmptyDelegate)
+// chooseAdvertiseAddress returns the address published to the clients of the API.
+// An explicit bind address is used as is, otherwise an address of the selected family
+// is picked from the host interfaces. Returns nil to fall back to the server default.
+func chooseAdvertiseAddress(bindAddress net.IP, family string) (net.IP, error) {
+	if bindAddress != nil && !bindAddress.IsUnspecified() {
+		if !util.IsAddressFamily(bindAddress, family) {
+			return nil, fmt.Errorf("bind address %s does not belong to address family %s", bindAddress, family)
+		}
+		return bindAddress, nil
+	}
+	if family == "" {
+		return nil, nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list interface addresses: %v", err)
+	}
+	return util.ChooseHostAddress(addrs, family)
+}
+
+// withUnauthenticatedHandler serves the path from the given handler, bypassing the
+// authentication and authorization of the handler chain.
+func withUnauthenticatedHandler(chain http.Handler, path string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == path {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		chain.ServeHTTP(w, req)
+	})
 }
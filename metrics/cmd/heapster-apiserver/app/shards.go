@@ -0,0 +1,73 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/grpcapi"
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+	nodemetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/nodemetrics"
+	podmetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/podmetrics"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/shards"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/apiserver/pkg/registry/rest"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Token of the service account the calls of the peer shards are authenticated with.
+const serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// withShardPeers returns a copy of the storages whose node and pod storages fan out the
+// requests for the nodes of other shards to the peers of the options, over gRPC.
+func withShardPeers(s *options.HeapsterRunOptions, storages map[string]rest.Storage, podLister v1listers.PodLister) (map[string]rest.Storage, error) {
+	tlsConfig := &tls.Config{}
+	if s.SnapshotSourceCAFile != "" {
+		pool, err := certutil.NewPool(s.SnapshotSourceCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the CA of the shard peers: %v", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	peers := make([]shards.Peer, len(s.ShardPeers))
+	for shard, address := range s.ShardPeers {
+		if shard == s.ShardIndex {
+			continue
+		}
+		conn, err := grpc.Dial(address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+			grpc.WithPerRPCCredentials(grpcapi.NewTokenFileCredentials(serviceAccountTokenFile)))
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to shard %d at %s: %v", shard, address, err)
+		}
+		peers[shard] = grpcapi.NewClient(conn)
+	}
+	shardPeers := shards.NewPeers(metricsutil.Shard{Index: s.ShardIndex, Count: s.ShardCount}, peers, Scheme, shards.DefaultTimeout)
+
+	result := make(map[string]rest.Storage, len(storages))
+	for resource, storage := range storages {
+		result[resource] = storage
+	}
+	result["nodes"] = shards.NewNodeStorage(metrics.Resource("nodemetrics"), storages["nodes"].(*nodemetricsstorage.MetricStorage), shardPeers)
+	if podLister != nil {
+		result["pods"] = shards.NewPodStorage(metrics.Resource("podmetrics"), storages["pods"].(*podmetricsstorage.MetricStorage), podLister, shardPeers)
+	}
+	return result, nil
+}
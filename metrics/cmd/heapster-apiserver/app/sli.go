@@ -0,0 +1,61 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/operator"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// withSLIRecording records the status and latency of every Metrics API request for the
+// API SLIs. Like withAccessLog, it wraps the whole handler chain, so that requests rejected
+// by its filters and panics are counted too.
+func withSLIRecording(handler http.Handler, mapper genericapirequest.RequestContextMapper) http.Handler {
+	return genericapirequest.WithRequestContext(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, metricsAPIPrefix) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		start := time.Now()
+		recorder := &accessLogResponseWriter{ResponseWriter: w}
+		record := func(status int) {
+			verb := strings.ToLower(req.Method)
+			if ctx, ok := mapper.Get(req); ok {
+				if info, found := genericapirequest.RequestInfoFrom(ctx); found {
+					verb = info.Verb
+				}
+			}
+			operator.RecordAPIRequest(verb, status, time.Since(start))
+		}
+		defer func() {
+			// The panic filter of the chain responds with a server error and panics again.
+			if err := recover(); err != nil {
+				record(http.StatusInternalServerError)
+				panic(err)
+			}
+		}()
+		handler.ServeHTTP(recorder, req)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		record(status)
+	}), mapper)
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizerfactory"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	genericfilters "k8s.io/apiserver/pkg/server/filters"
+)
+
+// sliRequests returns the total and good Metrics API requests of the verb recorded so far.
+func sliRequests(t *testing.T, verb string) (total, good float64) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if len(metric.GetLabel()) != 1 || metric.GetLabel()[0].GetValue() != verb {
+				continue
+			}
+			switch family.GetName() {
+			case "heapster_sli_api_requests_total":
+				total = metric.GetCounter().GetValue()
+			case "heapster_sli_api_good_requests_total":
+				good = metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return total, good
+}
+
+func TestSLIRecording(t *testing.T) {
+	config := genericapiserver.NewConfig(Codecs)
+	config.Authenticator = authenticator.RequestFunc(func(req *http.Request) (user.Info, bool, error) {
+		name := req.Header.Get("X-Remote-User")
+		return &user.DefaultInfo{Name: name}, name != "", nil
+	})
+	config.Authorizer = authorizerfactory.NewAlwaysAllowAuthorizer()
+	handler := withSLIRecording(genericapiserver.DefaultBuildHandlerChain(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}), config), config.RequestContextMapper)
+	// Panics of the handlers run by the timeout filter crash the server, only the filters
+	// around it panic back to withSLIRecording.
+	panicking := withSLIRecording(genericfilters.WithPanicRecovery(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("broken")
+	})), config.RequestContextMapper)
+
+	serve := func(handler http.Handler, userName string) {
+		req := httptest.NewRequest(http.MethodGet, metricsAPIPrefix+"v1beta1/nodes/node1", nil)
+		req.Header.Set("X-Remote-User", userName)
+		defer func() { recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	total, good := sliRequests(t, "get")
+	serve(handler, "dev")
+	serve(handler, "")
+	serve(panicking, "dev")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	newTotal, newGood := sliRequests(t, "get")
+	assert.Equal(t, total+3, newTotal, "rejected requests and panics are counted")
+	assert.Equal(t, good+2, newGood, "the panic is a server error")
+}
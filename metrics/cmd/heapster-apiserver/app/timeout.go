@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	genericfilters "k8s.io/apiserver/pkg/server/filters"
+	v1listers "k8s.io/client-go/listers/core/v1"
+)
+
+// Query parameter of Metrics API GET and LIST requests giving the time in seconds the
+// client waits for the response.
+const timeoutSecondsParam = "timeoutSeconds"
+
+// Type of the timeout status causes telling that the served metrics are partial.
+const partialMetricsCause metav1.CauseType = "PartialMetrics"
+
+// withRequestTimeout ends Metrics API GET and LIST requests which take longer than the
+// timeoutSeconds query parameter with a timeout status, like the server does after
+// maxTimeout for all requests. Timeouts above maxTimeout are capped to it. The status
+// lists the metrics warnings as causes, so that clients can tell a slow server from
+// nodes failing to report.
+func withRequestTimeout(handler http.Handler, mapper genericapirequest.RequestContextMapper, maxTimeout time.Duration,
+	metricSink *metricsink.MetricSink, nodeLister v1listers.NodeLister, shard util.Shard, resolution time.Duration) http.Handler {
+	timeoutHandler := genericfilters.WithTimeout(handler, func(req *http.Request) (<-chan time.Time, func(), *apierrors.StatusError) {
+		timeout := requestTimeout(req, maxTimeout)
+		err := apierrors.NewTimeoutError(fmt.Sprintf("request did not complete within %s", timeout), 0)
+		return time.After(timeout), func() {
+			glog.V(2).Infof("Request %s %s timed out after %s", req.Method, req.URL.Path, timeout)
+			for _, warning := range getMetricsWarnings(metricSink, nodeLister, shard, resolution) {
+				err.ErrStatus.Details.Causes = append(err.ErrStatus.Details.Causes, metav1.StatusCause{Type: partialMetricsCause, Message: warning})
+			}
+		}, err
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		value := req.URL.Query().Get(timeoutSecondsParam)
+		if value == "" || !strings.HasPrefix(req.URL.Path, metricsAPIPrefix) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds <= 0 {
+			http.Error(w, fmt.Sprintf("invalid %s %q, expected a positive number of seconds", timeoutSecondsParam, value), http.StatusBadRequest)
+			return
+		}
+		ctx, ok := mapper.Get(req)
+		if !ok {
+			http.Error(w, "no context found for request", http.StatusInternalServerError)
+			return
+		}
+		if info, found := genericapirequest.RequestInfoFrom(ctx); !found || (info.Verb != "get" && info.Verb != "list") {
+			// Watches handle timeoutSeconds themselves.
+			handler.ServeHTTP(w, req)
+			return
+		}
+		timeoutHandler.ServeHTTP(w, req)
+	})
+}
+
+// requestTimeout returns the timeout of a request with a valid timeoutSeconds parameter,
+// at most maxTimeout.
+func requestTimeout(req *http.Request, maxTimeout time.Duration) time.Duration {
+	seconds, _ := strconv.ParseInt(req.URL.Query().Get(timeoutSecondsParam), 10, 64)
+	if maxTimeout > 0 && seconds > int64(maxTimeout/time.Second) {
+		return maxTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
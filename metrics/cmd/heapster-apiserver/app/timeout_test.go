@@ -0,0 +1,115 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newTestRequestTimeout returns a handler timing out requests with the verb, served by
+// inner, with metrics for node1 out of node1 and node2.
+func newTestRequestTimeout(t *testing.T, verb string, inner http.Handler) http.Handler {
+	now := time.Now()
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Hour, nil)
+	metricSink.ExportData(&core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{
+		core.NodeKey("node1"): {ScrapeTime: now},
+	}})
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, nodes.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}))
+	require.NoError(t, nodes.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}))
+
+	mapper := genericapirequest.NewRequestContextMapper()
+	withInfo := func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx, _ := mapper.Get(req)
+			mapper.Update(req, genericapirequest.WithRequestInfo(ctx, &genericapirequest.RequestInfo{Verb: verb}))
+			handler.ServeHTTP(w, req)
+		})
+	}
+	handler := withRequestTimeout(inner, mapper, time.Minute, metricSink, v1listers.NewNodeLister(nodes), util.Shard{}, time.Minute)
+	return genericapirequest.WithRequestContext(withInfo(handler), mapper)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "5", expected: 5 * time.Second},
+		{value: "60", expected: time.Minute},
+		{value: "3600", expected: time.Minute},
+	} {
+		req := httptest.NewRequest("GET", metricsAPIPrefix+"v1beta1/nodes?"+timeoutSecondsParam+"="+tc.value, nil)
+		assert.Equal(t, tc.expected, requestTimeout(req, time.Minute), tc.value)
+	}
+	req := httptest.NewRequest("GET", metricsAPIPrefix+"v1beta1/nodes?"+timeoutSecondsParam+"=3600", nil)
+	assert.Equal(t, time.Hour, requestTimeout(req, 0), "no maximum")
+}
+
+func TestWithRequestTimeout(t *testing.T) {
+	fast := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, tc := range []struct {
+		name  string
+		verb  string
+		query string
+		code  int
+	}{
+		{name: "no timeout", verb: "list", code: http.StatusOK},
+		{name: "within timeout", verb: "list", query: "?" + timeoutSecondsParam + "=10", code: http.StatusOK},
+		{name: "watch", verb: "watch", query: "?" + timeoutSecondsParam + "=10", code: http.StatusOK},
+		{name: "zero", verb: "get", query: "?" + timeoutSecondsParam + "=0", code: http.StatusBadRequest},
+		{name: "not a number", verb: "get", query: "?" + timeoutSecondsParam + "=1m", code: http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("GET", metricsAPIPrefix+"v1beta1/nodes"+tc.query, nil)
+		w := httptest.NewRecorder()
+		newTestRequestTimeout(t, tc.verb, fast).ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.name)
+	}
+}
+
+func TestWithRequestTimeoutReportsPartialMetrics(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	})
+	req := httptest.NewRequest("GET", metricsAPIPrefix+"v1beta1/nodes?"+timeoutSecondsParam+"=1", nil)
+	w := httptest.NewRecorder()
+	newTestRequestTimeout(t, "list", slow).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+	status := metav1.Status{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, metav1.StatusReasonTimeout, status.Reason)
+	require.NotNil(t, status.Details)
+	assert.Equal(t, []metav1.StatusCause{{Type: partialMetricsCause, Message: "metrics unavailable for 1 of 2 nodes"}}, status.Details.Causes)
+}
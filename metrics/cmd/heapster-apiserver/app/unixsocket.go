@@ -0,0 +1,80 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+)
+
+// Permissions of the Unix socket, which is only meant for sidecars sharing the directory.
+const unixSocketMode = 0660
+
+// serveUnixSocket serves the handler, including authentication and authorization, on a
+// Unix socket at the path, replacing a socket left behind by an earlier run. Clients
+// authenticate with a bearer token, as TLS isn't used on the socket.
+func serveUnixSocket(path string, handler http.Handler, stopCh <-chan struct{}) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove stale unix socket %s: %v", path, err)
+	}
+	listener, err := listenUnixSocket(path)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		<-stopCh
+		server.Close()
+	}()
+	go func() {
+		glog.Infof("Serving on unix socket %s", path)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("Error while serving on unix socket %s: %v", path, err)
+		}
+	}()
+	return nil
+}
+
+// listenUnixSocket listens on a Unix socket at the path with unixSocketMode. The socket is
+// created in a private directory and only moved to the path once its permissions are set,
+// so that other users can't connect in between.
+func listenUnixSocket(path string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".unix-socket")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create directory for unix socket %s: %v", path, err)
+	}
+	defer os.RemoveAll(dir)
+	privatePath := filepath.Join(dir, filepath.Base(path))
+	listener, err := net.Listen("unix", privatePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on unix socket %s: %v", path, err)
+	}
+	if err := os.Chmod(privatePath, unixSocketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to set permissions of unix socket %s: %v", path, err)
+	}
+	if err := os.Rename(privatePath, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to move unix socket to %s: %v", path, err)
+	}
+	return listener, nil
+}
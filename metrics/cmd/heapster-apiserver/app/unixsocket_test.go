@@ -0,0 +1,69 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unixsocket")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.sock")
+	require.NoError(t, ioutil.WriteFile(path, nil, 0600), "stale socket")
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	require.NoError(t, serveUnixSocket(path, handler, stopCh))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket, info.Mode()&os.ModeType)
+	assert.Equal(t, os.FileMode(unixSocketMode), info.Mode().Perm())
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "private directory left behind")
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestServeUnixSocketMissingDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unixsocket")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	err = serveUnixSocket(filepath.Join(dir, "missing", "metrics.sock"), http.NotFoundHandler(), nil)
+	assert.Error(t, err)
+}
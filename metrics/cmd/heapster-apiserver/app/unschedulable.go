@@ -0,0 +1,52 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// withIncludeUnschedulable records the includeUnschedulable query parameter of Metrics API
+// requests in the request context, where the NodeMetrics storage picks it up.
+func withIncludeUnschedulable(handler http.Handler, mapper genericapirequest.RequestContextMapper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		value := req.URL.Query().Get(util.IncludeUnschedulableParam)
+		if value == "" || !strings.HasPrefix(req.URL.Path, metricsAPIPrefix) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		include, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s %q", util.IncludeUnschedulableParam, value), http.StatusBadRequest)
+			return
+		}
+		ctx, ok := mapper.Get(req)
+		if !ok {
+			http.Error(w, "no context found for request", http.StatusInternalServerError)
+			return
+		}
+		if err := mapper.Update(req, util.WithIncludeUnschedulable(ctx, include)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/apimachinery/pkg/labels"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+var metricsAPIPrefix = "/apis/" + metrics.GroupName + "/"
+
+// withMetricsWarnings adds Warning headers to Metrics API responses when the
+// served data is stale or doesn't cover all nodes, so that clients can tell
+// partial results from empty ones, and when the latest samples of nodes were
+// measured over windows deviating from the resolution, which makes them noisy. Only the
+// nodes of the shard are expected in the batch.
+func withMetricsWarnings(handler http.Handler, metricSink *metricsink.MetricSink, nodeLister v1listers.NodeLister,
+	shard metricsutil.Shard, resolution time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, metricsAPIPrefix) {
+			for _, warning := range getMetricsWarnings(metricSink, nodeLister, shard, resolution) {
+				w.Header().Add("Warning", fmt.Sprintf("299 - %q", warning))
+			}
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+func getMetricsWarnings(metricSink *metricsink.MetricSink, nodeLister v1listers.NodeLister, shard metricsutil.Shard,
+	resolution time.Duration) []string {
+	batch := metricSink.GetLatestDataBatch()
+	if util.IsStale(metricSink, batch) {
+		return []string{util.MetricsStaleMessage(batch)}
+	}
+
+	nodes, err := nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	warnings := []string{}
+	missing, deviating := 0, 0
+	total := 0
+	for _, node := range nodes {
+		if metricsutil.IsNodeSkipped(node) || !shard.Owns(node.Name) {
+			continue
+		}
+		total++
+		key := core.NodeKey(node.Name)
+		if _, found := batch.MetricSets[key]; !found {
+			missing++
+		} else if _, found := util.GetDeviatingWindow(batch, []string{key}, resolution); found {
+			deviating++
+		}
+	}
+	if missing > 0 {
+		warnings = append(warnings, fmt.Sprintf("metrics unavailable for %d of %d nodes", missing, total))
+	}
+	if deviating > 0 {
+		warnings = append(warnings, fmt.Sprintf("latest usage of %d of %d nodes was measured over a window deviating from the %s resolution, see the %s annotation",
+			deviating, total, resolution, util.EffectiveWindowAnnotation))
+	}
+	return warnings
+}
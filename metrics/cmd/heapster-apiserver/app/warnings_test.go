@@ -0,0 +1,109 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestWarningsNodeLister(t *testing.T, names ...string) v1listers.NodeLister {
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range names {
+		require.NoError(t, nodes.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+	skipped := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "skipped", Annotations: map[string]string{metricsutil.SkipNodeAnnotation: "true"}}}
+	require.NoError(t, nodes.Add(skipped))
+	return v1listers.NewNodeLister(nodes)
+}
+
+func TestGetMetricsWarnings(t *testing.T) {
+	now := time.Now()
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Hour, nil)
+	assert.Equal(t, []string{"no metrics have been collected yet"},
+		getMetricsWarnings(metricSink, newTestWarningsNodeLister(t), metricsutil.Shard{}, time.Minute))
+
+	metricSink.ExportData(&core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{
+		core.NodeKey("node1"): {ScrapeTime: now},
+	}})
+	nodeLister := newTestWarningsNodeLister(t, "node1", "node2")
+	assert.Equal(t, []string{"metrics unavailable for 1 of 2 nodes"}, getMetricsWarnings(metricSink, nodeLister, metricsutil.Shard{}, time.Minute))
+
+	nodeLister = newTestWarningsNodeLister(t, "node1")
+	assert.Empty(t, getMetricsWarnings(metricSink, nodeLister, metricsutil.Shard{}, time.Minute))
+}
+
+func TestGetMetricsWarningsSharded(t *testing.T) {
+	now := time.Now()
+	names := []string{"node1", "node2", "node3", "node4", "node5", "node6"}
+	shard := metricsutil.Shard{Index: 0, Count: 2}
+	owned := []string{}
+	for _, name := range names {
+		if shard.Owns(name) {
+			owned = append(owned, name)
+		}
+	}
+	require.True(t, len(owned) > 1 && len(owned) < len(names), "nodes in both shards")
+	nodeLister := newTestWarningsNodeLister(t, names...)
+
+	// The shard only scrapes its own nodes, and missed the first one.
+	metricSets := map[string]*core.MetricSet{}
+	for _, name := range owned[1:] {
+		metricSets[core.NodeKey(name)] = &core.MetricSet{ScrapeTime: now}
+	}
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Hour, nil)
+	metricSink.ExportData(&core.DataBatch{Timestamp: now, MetricSets: metricSets})
+	assert.Equal(t, []string{fmt.Sprintf("metrics unavailable for 1 of %d nodes", len(owned))},
+		getMetricsWarnings(metricSink, nodeLister, shard, time.Minute), "nodes of other shards are not expected")
+	assert.Equal(t, []string{fmt.Sprintf("metrics unavailable for %d of %d nodes", len(names)-len(owned)+1, len(names))},
+		getMetricsWarnings(metricSink, nodeLister, metricsutil.Shard{}, time.Minute))
+
+	metricSets = map[string]*core.MetricSet{}
+	for _, name := range owned {
+		metricSets[core.NodeKey(name)] = &core.MetricSet{ScrapeTime: now}
+	}
+	metricSink.ExportData(&core.DataBatch{Timestamp: now.Add(time.Second), MetricSets: metricSets})
+	assert.Empty(t, getMetricsWarnings(metricSink, nodeLister, shard, time.Minute))
+}
+
+func TestWithMetricsWarnings(t *testing.T) {
+	now := time.Now()
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Hour, nil)
+	metricSink.ExportData(&core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{}})
+	inner := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	handler := withMetricsWarnings(inner, metricSink, newTestWarningsNodeLister(t, "node1"), metricsutil.Shard{}, time.Minute)
+
+	for path, expected := range map[string][]string{
+		metricsAPIPrefix + "v1beta1/nodes": {`299 - "metrics unavailable for 1 of 1 nodes"`},
+		"/healthz":                         nil,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, expected, w.Header()["Warning"], path)
+	}
+}
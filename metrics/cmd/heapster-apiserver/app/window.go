@@ -0,0 +1,103 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// withWindowSelection records the window selected with the window query parameter of
+// Metrics API requests in the request context, where the storages pick it up. Without a
+// slow window, in builds which keep no long store, only the latest samples are served.
+func withWindowSelection(handler http.Handler, mapper genericapirequest.RequestContextMapper, slowWindow time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, metricsAPIPrefix) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		switch window := req.URL.Query().Get(util.WindowParam); window {
+		case "", util.WindowFast:
+		case util.WindowSlow:
+			if slowWindow == 0 {
+				http.Error(w, fmt.Sprintf("%s %q is not available, the binary was built without history", util.WindowParam, window), http.StatusBadRequest)
+				return
+			}
+			ctx, ok := mapper.Get(req)
+			if !ok {
+				http.Error(w, "no context found for request", http.StatusInternalServerError)
+				return
+			}
+			if err := mapper.Update(req, util.WithWindow(ctx, slowWindow)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, fmt.Sprintf("invalid %s %q, expected %q or %q", util.WindowParam, window, util.WindowFast, util.WindowSlow), http.StatusBadRequest)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// withTimeSelection records the time selected with the time query parameter of Metrics API
+// GET requests in the request context, so the retained batch closest to it is served. Times
+// before the oldest retained batch are not found, and times in the future are rejected.
+func withTimeSelection(handler http.Handler, mapper genericapirequest.RequestContextMapper, metricSink *metricsink.MetricSink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		value := req.URL.Query().Get(util.TimeParam)
+		if value == "" || !strings.HasPrefix(req.URL.Path, metricsAPIPrefix) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		timestamp, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s %q, expected an RFC3339 time", util.TimeParam, value), http.StatusBadRequest)
+			return
+		}
+		ctx, ok := mapper.Get(req)
+		if !ok {
+			http.Error(w, "no context found for request", http.StatusInternalServerError)
+			return
+		}
+		if info, found := genericapirequest.RequestInfoFrom(ctx); !found || info.Verb != "get" {
+			http.Error(w, fmt.Sprintf("%s is only supported for GET requests of single objects", util.TimeParam), http.StatusBadRequest)
+			return
+		}
+		if util.WindowFrom(ctx) > 0 {
+			http.Error(w, fmt.Sprintf("%s can't be combined with %s=%s", util.TimeParam, util.WindowParam, util.WindowSlow), http.StatusBadRequest)
+			return
+		}
+		if timestamp.After(time.Now()) {
+			http.Error(w, fmt.Sprintf("%s %q is in the future", util.TimeParam, value), http.StatusBadRequest)
+			return
+		}
+		if oldest := metricSink.GetOldestTimestamp(); oldest.IsZero() || timestamp.Before(oldest) {
+			http.Error(w, fmt.Sprintf("no metrics retained at %s %q", util.TimeParam, value), http.StatusNotFound)
+			return
+		}
+		if err := mapper.Update(req, util.WithTime(ctx, timestamp)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
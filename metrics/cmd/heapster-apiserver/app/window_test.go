@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	"github.com/stretchr/testify/assert"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// newTestTimeSelection returns a handler selecting the time of GET requests, recording the
+// selected time in selected.
+func newTestTimeSelection(metricSink *metricsink.MetricSink, selected *time.Time) http.Handler {
+	mapper := genericapirequest.NewRequestContextMapper()
+	inner := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, _ := mapper.Get(req)
+		*selected = util.TimeFrom(ctx)
+	})
+	withInfo := func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx, _ := mapper.Get(req)
+			mapper.Update(req, genericapirequest.WithRequestInfo(ctx, &genericapirequest.RequestInfo{Verb: "get"}))
+			handler.ServeHTTP(w, req)
+		})
+	}
+	return genericapirequest.WithRequestContext(withInfo(withTimeSelection(inner, mapper, metricSink)), mapper)
+}
+
+func TestWithTimeSelection(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Hour, []string{"m1"})
+	metricSink.ExportData(&core.DataBatch{Timestamp: now.Add(-10 * time.Minute), MetricSets: map[string]*core.MetricSet{}})
+	metricSink.ExportData(&core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{}})
+
+	for _, tc := range []struct {
+		name     string
+		time     time.Time
+		code     int
+		selected bool
+	}{
+		{name: "latest", time: now, code: http.StatusOK, selected: true},
+		{name: "long store", time: now.Add(-5 * time.Minute), code: http.StatusOK, selected: true},
+		{name: "oldest retained", time: now.Add(-10 * time.Minute), code: http.StatusOK, selected: true},
+		{name: "before retention", time: now.Add(-11 * time.Minute), code: http.StatusNotFound},
+		{name: "future", time: now.Add(time.Hour), code: http.StatusBadRequest},
+	} {
+		var selected time.Time
+		handler := newTestTimeSelection(metricSink, &selected)
+		req := httptest.NewRequest("GET", metricsAPIPrefix+"v1beta1/nodes/node1?"+util.TimeParam+"="+tc.time.Format(time.RFC3339), nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.name)
+		if tc.selected {
+			assert.True(t, tc.time.Equal(selected), tc.name)
+		} else {
+			assert.True(t, selected.IsZero(), tc.name)
+		}
+	}
+}
+
+func TestWithTimeSelectionNoBatches(t *testing.T) {
+	var selected time.Time
+	handler := newTestTimeSelection(metricsink.NewMetricSink(time.Minute, time.Hour, nil), &selected)
+	req := httptest.NewRequest("GET", metricsAPIPrefix+"v1beta1/nodes/node1?"+util.TimeParam+"="+time.Now().Add(-time.Minute).Format(time.RFC3339), nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWithWindowSelection(t *testing.T) {
+	for _, tc := range []struct {
+		slowWindow time.Duration
+		query      string
+		code       int
+		selected   time.Duration
+	}{
+		{slowWindow: 5 * time.Minute, query: "", code: http.StatusOK},
+		{slowWindow: 5 * time.Minute, query: "?window=fast", code: http.StatusOK},
+		{slowWindow: 5 * time.Minute, query: "?window=slow", code: http.StatusOK, selected: 5 * time.Minute},
+		{slowWindow: 5 * time.Minute, query: "?window=hour", code: http.StatusBadRequest},
+		{slowWindow: 0, query: "", code: http.StatusOK},
+		{slowWindow: 0, query: "?window=slow", code: http.StatusBadRequest},
+	} {
+		mapper := genericapirequest.NewRequestContextMapper()
+		var selected time.Duration
+		inner := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx, _ := mapper.Get(req)
+			selected = util.WindowFrom(ctx)
+		})
+		handler := genericapirequest.WithRequestContext(withWindowSelection(inner, mapper, tc.slowWindow), mapper)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, metricsAPIPrefix+"v1beta1/nodes"+tc.query, nil))
+		assert.Equal(t, tc.code, rec.Code, "%s with slow window %s", tc.query, tc.slowWindow)
+		assert.Equal(t, tc.selected, selected, "%s with slow window %s", tc.query, tc.slowWindow)
+	}
+}
@@ -0,0 +1,438 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conformance checks that a running Metrics API serves what its clients rely on,
+// for validating builds and configurations before they are rolled out.
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// Path of the served Metrics API version.
+const apiPath = "/apis/metrics.k8s.io/v1beta1"
+
+// Label selecting no object, for checking that selectors are applied.
+const noMatchSelector = "conformance.metrics-server.k8s.io/no-match=true"
+
+// Name of no object, for checking that missing objects and field selectors are handled.
+const missingName = "conformance-missing-object"
+
+// Result of one check.
+type Result struct {
+	Name string
+	Err  error
+	// Why the check was not run, e.g. because the cluster has no pods.
+	Skipped string
+}
+
+// Report lists the results of all checks in the order they were run.
+type Report struct {
+	Results []Result
+}
+
+// Failed returns the number of failed checks.
+func (this *Report) Failed() int {
+	failed := 0
+	for _, result := range this.Results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Write prints one line per check.
+func (this *Report) Write(w io.Writer) error {
+	for _, result := range this.Results {
+		var err error
+		switch {
+		case result.Err != nil:
+			_, err = fmt.Fprintf(w, "FAIL %s: %v\n", result.Name, result.Err)
+		case result.Skipped != "":
+			_, err = fmt.Fprintf(w, "SKIP %s: %s\n", result.Name, result.Skipped)
+		default:
+			_, err = fmt.Fprintf(w, "PASS %s\n", result.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d of %d checks failed\n", this.Failed(), len(this.Results))
+	return err
+}
+
+// Checker runs the checks against the Metrics API reached through the client.
+type Checker struct {
+	client rest.Interface
+	// Nodes and pods of the cluster, for picking the objects to get and select.
+	nodes []corev1.Node
+	pods  []corev1.Pod
+	// Max age of the served usage, older usage is reported as stale.
+	maxAge time.Duration
+	now    func() time.Time
+	report *Report
+}
+
+func NewChecker(client rest.Interface, nodes []corev1.Node, pods []corev1.Pod, maxAge time.Duration) *Checker {
+	return &Checker{
+		client: client,
+		nodes:  nodes,
+		pods:   pods,
+		maxAge: maxAge,
+		now:    time.Now,
+	}
+}
+
+// Run runs all checks and returns their results.
+func (this *Checker) Run() *Report {
+	this.report = &Report{}
+	this.check("discovery", this.checkDiscovery)
+
+	nodes := &v1beta1.NodeMetricsList{}
+	this.check("nodes/list", func() (string, error) {
+		if err := this.get(nodes, nil, "nodes"); err != nil {
+			return "", err
+		}
+		if len(nodes.Items) == 0 && len(this.nodes) > 0 {
+			return "", fmt.Errorf("no node metrics served for %d nodes", len(this.nodes))
+		}
+		for i := range nodes.Items {
+			if err := this.checkUsage("node "+nodes.Items[i].Name, nodes.Items[i].Timestamp, nodes.Items[i].Window, nodes.Items[i].Usage); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	})
+	node := findNode(nodes, this.nodes)
+	this.check("nodes/get", func() (string, error) {
+		if node == nil {
+			return "no served node", nil
+		}
+		item := &v1beta1.NodeMetrics{}
+		if err := this.get(item, nil, "nodes", node.Name); err != nil {
+			return "", err
+		}
+		if item.Name != node.Name {
+			return "", fmt.Errorf("got node %q instead of %q", item.Name, node.Name)
+		}
+		return "", this.checkUsage("node "+item.Name, item.Timestamp, item.Window, item.Usage)
+	})
+	this.check("nodes/get-missing", func() (string, error) {
+		return "", this.checkNotFound("nodes", missingName)
+	})
+	this.check("nodes/label-selector", func() (string, error) {
+		if node == nil {
+			return "no served node", nil
+		}
+		return "", this.checkLabelSelector(node.Labels, node.Name, "nodes")
+	})
+	this.check("nodes/field-selector", func() (string, error) {
+		if node == nil {
+			return "no served node", nil
+		}
+		return "", this.checkFieldSelector(node.Name, "nodes")
+	})
+
+	pods := &v1beta1.PodMetricsList{}
+	this.check("pods/list", func() (string, error) {
+		if err := this.get(pods, nil, "pods"); err != nil {
+			return "", err
+		}
+		for i := range pods.Items {
+			if err := this.checkPodMetrics(&pods.Items[i]); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	})
+	pod := findPod(pods, this.pods)
+	this.check("pods/list-namespace", func() (string, error) {
+		if pod == nil {
+			return "no served pod", nil
+		}
+		list := &v1beta1.PodMetricsList{}
+		if err := this.get(list, nil, "namespaces", pod.Namespace, "pods"); err != nil {
+			return "", err
+		}
+		found := false
+		for _, item := range list.Items {
+			if item.Namespace != pod.Namespace {
+				return "", fmt.Errorf("pod %s/%s listed in namespace %s", item.Namespace, item.Name, pod.Namespace)
+			}
+			found = found || item.Name == pod.Name
+		}
+		if !found {
+			return "", fmt.Errorf("pod %s/%s not listed in its namespace", pod.Namespace, pod.Name)
+		}
+		return "", nil
+	})
+	this.check("pods/get", func() (string, error) {
+		if pod == nil {
+			return "no served pod", nil
+		}
+		item := &v1beta1.PodMetrics{}
+		if err := this.get(item, nil, "namespaces", pod.Namespace, "pods", pod.Name); err != nil {
+			return "", err
+		}
+		if item.Namespace != pod.Namespace || item.Name != pod.Name {
+			return "", fmt.Errorf("got pod %s/%s instead of %s/%s", item.Namespace, item.Name, pod.Namespace, pod.Name)
+		}
+		return "", this.checkPodMetrics(item)
+	})
+	this.check("pods/get-missing", func() (string, error) {
+		return "", this.checkNotFound("namespaces", "default", "pods", missingName)
+	})
+	this.check("pods/label-selector", func() (string, error) {
+		if pod == nil {
+			return "no served pod", nil
+		}
+		return "", this.checkLabelSelector(pod.Labels, pod.Name, "namespaces", pod.Namespace, "pods")
+	})
+	this.check("pods/field-selector", func() (string, error) {
+		if pod == nil {
+			return "no served pod", nil
+		}
+		return "", this.checkFieldSelector(pod.Name, "namespaces", pod.Namespace, "pods")
+	})
+	return this.report
+}
+
+// check runs the check and records its result. The check returns why it was skipped, if so.
+func (this *Checker) check(name string, check func() (string, error)) {
+	skipped, err := check()
+	this.report.Results = append(this.report.Results, Result{Name: name, Err: err, Skipped: skipped})
+}
+
+func (this *Checker) checkDiscovery() (string, error) {
+	resources := &metav1.APIResourceList{}
+	if err := this.get(resources, nil); err != nil {
+		return "", err
+	}
+	expected := map[string]bool{"nodes": false, "pods": true}
+	for _, resource := range resources.APIResources {
+		namespaced, found := expected[resource.Name]
+		if !found {
+			continue
+		}
+		if resource.Namespaced != namespaced {
+			return "", fmt.Errorf("resource %s has namespaced %v, expected %v", resource.Name, resource.Namespaced, namespaced)
+		}
+		verbs := map[string]bool{}
+		for _, verb := range resource.Verbs {
+			verbs[verb] = true
+		}
+		if !verbs["get"] || !verbs["list"] {
+			return "", fmt.Errorf("resource %s has verbs %v, expected get and list", resource.Name, resource.Verbs)
+		}
+		delete(expected, resource.Name)
+	}
+	if len(expected) > 0 {
+		missing := make([]string, 0, len(expected))
+		for name := range expected {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return "", fmt.Errorf("resources %v not discovered", missing)
+	}
+	return "", nil
+}
+
+// checkUsage verifies that the usage has cpu and memory, and that it was measured recently
+// over a positive window.
+func (this *Checker) checkUsage(object string, timestamp metav1.Time, window metav1.Duration, usage corev1.ResourceList) error {
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		quantity, found := usage[name]
+		if !found {
+			return fmt.Errorf("%s has no %s usage", object, name)
+		}
+		if quantity.Sign() < 0 {
+			return fmt.Errorf("%s has negative %s usage %s", object, name, quantity.String())
+		}
+	}
+	if window.Duration <= 0 {
+		return fmt.Errorf("%s has window %s", object, window.Duration)
+	}
+	if age := this.now().Sub(timestamp.Time); age > this.maxAge {
+		return fmt.Errorf("%s is stale: usage of %s is older than %s", object, timestamp.Time, this.maxAge)
+	} else if age < -this.maxAge {
+		return fmt.Errorf("%s has usage from the future: %s", object, timestamp.Time)
+	}
+	return nil
+}
+
+func (this *Checker) checkPodMetrics(item *v1beta1.PodMetrics) error {
+	object := "pod " + item.Namespace + "/" + item.Name
+	if len(item.Containers) == 0 {
+		return fmt.Errorf("%s has no containers", object)
+	}
+	for _, container := range item.Containers {
+		if err := this.checkUsage(object+" container "+container.Name, item.Timestamp, item.Window, container.Usage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Checker) checkNotFound(path ...string) error {
+	_, err := this.request(nil, path...)
+	if err == nil {
+		return fmt.Errorf("%v served for a missing object", path)
+	}
+	if !errors.IsNotFound(err) {
+		return fmt.Errorf("expected not found for a missing object, got: %v", err)
+	}
+	return nil
+}
+
+// checkLabelSelector lists the objects with a label of the named object, which has to be
+// listed, and with a label no object has, which has to list nothing.
+func (this *Checker) checkLabelSelector(labels map[string]string, name string, path ...string) error {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	selector := keys[0] + "=" + labels[keys[0]]
+
+	names, err := this.listNames(url.Values{"labelSelector": {selector}}, path...)
+	if err != nil {
+		return err
+	}
+	if !names[name] {
+		return fmt.Errorf("%s not listed with selector %s", name, selector)
+	}
+	names, err = this.listNames(url.Values{"labelSelector": {noMatchSelector}}, path...)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("%d objects listed with selector %s matching no object", len(names), noMatchSelector)
+	}
+	return nil
+}
+
+// checkFieldSelector lists the objects with the name of the named object, which has to be
+// the only one listed, and with a name no object has, which has to list nothing.
+func (this *Checker) checkFieldSelector(name string, path ...string) error {
+	selector := "metadata.name=" + name
+	names, err := this.listNames(url.Values{"fieldSelector": {selector}}, path...)
+	if err != nil {
+		return err
+	}
+	if !names[name] {
+		return fmt.Errorf("%s not listed with field selector %s", name, selector)
+	}
+	if len(names) > 1 {
+		return fmt.Errorf("%d objects listed with field selector %s", len(names), selector)
+	}
+	selector = "metadata.name=" + missingName
+	names, err = this.listNames(url.Values{"fieldSelector": {selector}}, path...)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("%d objects listed with field selector %s matching no object", len(names), selector)
+	}
+	return nil
+}
+
+func (this *Checker) listNames(params url.Values, path ...string) (map[string]bool, error) {
+	list := &struct {
+		Items []struct {
+			metav1.ObjectMeta `json:"metadata"`
+		} `json:"items"`
+	}{}
+	if err := this.get(list, params, path...); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(list.Items))
+	for _, item := range list.Items {
+		names[item.Name] = true
+	}
+	return names, nil
+}
+
+func (this *Checker) get(into interface{}, params url.Values, path ...string) error {
+	body, err := this.request(params, path...)
+	if err != nil {
+		// The client does not decode error bodies, so look for a stale status in the body itself.
+		status := metav1.Status{}
+		if len(body) > 0 && json.Unmarshal(body, &status) == nil && status.Reason == util.StatusReasonMetricsStale {
+			// The server knows its metrics are stale, which is as bad for clients as serving them.
+			return fmt.Errorf("metrics are stale: %s", status.Message)
+		}
+		return err
+	}
+	if err := json.Unmarshal(body, into); err != nil {
+		return fmt.Errorf("unable to decode %s: %v", body, err)
+	}
+	return nil
+}
+
+func (this *Checker) request(params url.Values, path ...string) ([]byte, error) {
+	req := this.client.Get().AbsPath(append([]string{apiPath}, path...)...)
+	for key, values := range params {
+		for _, value := range values {
+			req = req.Param(key, value)
+		}
+	}
+	return req.DoRaw()
+}
+
+// findNode returns the first cluster node, by name, whose metrics are served.
+func findNode(served *v1beta1.NodeMetricsList, nodes []corev1.Node) *corev1.Node {
+	names := make(map[string]bool, len(served.Items))
+	for _, item := range served.Items {
+		names[item.Name] = true
+	}
+	var found *corev1.Node
+	for i := range nodes {
+		if names[nodes[i].Name] && (found == nil || nodes[i].Name < found.Name) {
+			found = &nodes[i]
+		}
+	}
+	return found
+}
+
+// findPod returns the first cluster pod, by namespace and name, whose metrics are served.
+func findPod(served *v1beta1.PodMetricsList, pods []corev1.Pod) *corev1.Pod {
+	names := make(map[string]bool, len(served.Items))
+	for _, item := range served.Items {
+		names[item.Namespace+"/"+item.Name] = true
+	}
+	var found *corev1.Pod
+	for i := range pods {
+		key := pods[i].Namespace + "/" + pods[i].Name
+		if names[key] && (found == nil || key < found.Namespace+"/"+found.Name) {
+			found = &pods[i]
+		}
+	}
+	return found
+}
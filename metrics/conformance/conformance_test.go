@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// fakeMetricsAPI serves the metrics of one node and one pod.
+type fakeMetricsAPI struct {
+	timestamp time.Time
+	// Whether label and field selectors are ignored.
+	ignoreSelectors bool
+	// Whether metrics are reported as stale rather than served.
+	stale bool
+}
+
+func (this *fakeMetricsAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	usage := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("10Mi"),
+	}
+	node := v1beta1.NodeMetrics{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Timestamp:  metav1.NewTime(this.timestamp),
+		Window:     metav1.Duration{Duration: time.Minute},
+		Usage:      usage,
+	}
+	pod := v1beta1.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1"},
+		Timestamp:  metav1.NewTime(this.timestamp),
+		Window:     metav1.Duration{Duration: time.Minute},
+		Containers: []v1beta1.ContainerMetrics{{Name: "c1", Usage: usage}},
+	}
+	query := req.URL.Query()
+	selected := this.ignoreSelectors || !strings.Contains(query.Get("labelSelector"), "no-match")
+	if fieldSelector := query.Get("fieldSelector"); fieldSelector != "" && !this.ignoreSelectors {
+		selected = selected && (fieldSelector == "metadata.name=node1" || fieldSelector == "metadata.name=pod1")
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, apiPath)
+	if this.stale && path != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusFailure,
+			Reason:   util.StatusReasonMetricsStale,
+			Message:  "latest metrics are from 10m0s ago",
+			Code:     http.StatusServiceUnavailable,
+		})
+		return
+	}
+	var body interface{}
+	switch path {
+	case "":
+		body = metav1.APIResourceList{APIResources: []metav1.APIResource{
+			{Name: "nodes", Namespaced: false, Verbs: []string{"get", "list"}},
+			{Name: "pods", Namespaced: true, Verbs: []string{"get", "list"}},
+		}}
+	case "/nodes":
+		list := v1beta1.NodeMetricsList{}
+		if selected {
+			list.Items = append(list.Items, node)
+		}
+		body = list
+	case "/nodes/node1":
+		body = node
+	case "/pods", "/namespaces/ns1/pods":
+		list := v1beta1.PodMetricsList{}
+		if selected {
+			list.Items = append(list.Items, pod)
+		}
+		body = list
+	case "/namespaces/ns1/pods/pod1":
+		body = pod
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func runChecker(t *testing.T, api *fakeMetricsAPI) *Report {
+	server := httptest.NewServer(api)
+	defer server.Close()
+	client := kube_client.NewForConfigOrDie(&rest.Config{Host: server.URL}).Discovery().RESTClient()
+	nodes := []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"zone": "a"}}}}
+	pods := []corev1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1", Labels: map[string]string{"app": "web"}}}}
+	return NewChecker(client, nodes, pods, 3*time.Minute).Run()
+}
+
+func TestConformingAPI(t *testing.T) {
+	report := runChecker(t, &fakeMetricsAPI{timestamp: time.Now()})
+	for _, result := range report.Results {
+		assert.NoError(t, result.Err, result.Name)
+		assert.Empty(t, result.Skipped, result.Name)
+	}
+	assert.Equal(t, 0, report.Failed())
+
+	out := &strings.Builder{}
+	require.NoError(t, report.Write(out))
+	assert.Contains(t, out.String(), "PASS nodes/get\n")
+	assert.Contains(t, out.String(), "0 of 12 checks failed\n")
+}
+
+func TestNonConformingAPI(t *testing.T) {
+	report := runChecker(t, &fakeMetricsAPI{timestamp: time.Now().Add(-time.Hour), ignoreSelectors: true})
+	failed := map[string]bool{}
+	for _, result := range report.Results {
+		if result.Err != nil {
+			failed[result.Name] = true
+		}
+	}
+	assert.Equal(t, map[string]bool{
+		"nodes/list":           true,
+		"nodes/get":            true,
+		"nodes/label-selector": true,
+		"nodes/field-selector": true,
+		"pods/list":            true,
+		"pods/get":             true,
+		"pods/label-selector":  true,
+		"pods/field-selector":  true,
+	}, failed)
+
+	out := &strings.Builder{}
+	require.NoError(t, report.Write(out))
+	assert.Contains(t, out.String(), "FAIL nodes/get: node node1 is stale")
+}
+
+func TestStaleAPI(t *testing.T) {
+	report := runChecker(t, &fakeMetricsAPI{timestamp: time.Now(), stale: true})
+	failed := map[string]bool{}
+	for _, result := range report.Results {
+		if result.Err != nil {
+			failed[result.Name] = true
+		}
+	}
+	// Without served metrics only the lists and the missing objects can be checked, and even
+	// a missing object is reported as stale rather than as not found.
+	assert.Equal(t, map[string]bool{
+		"nodes/list":        true,
+		"nodes/get-missing": true,
+		"pods/list":         true,
+		"pods/get-missing":  true,
+	}, failed)
+
+	out := &strings.Builder{}
+	require.NoError(t, report.Write(out))
+	assert.Contains(t, out.String(), "FAIL nodes/list: metrics are stale: latest metrics are from 10m0s ago\n")
+	assert.Contains(t, out.String(), "PASS discovery\n")
+}
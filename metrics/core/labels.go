@@ -44,6 +44,10 @@ var (
 		Key:         "namespace_id",
 		Description: "The UID of namespace of the pod",
 	}
+	LabelTenant = LabelDescriptor{
+		Key:         "tenant",
+		Description: "Tenant the namespace of the pod belongs to, derived from the namespace labels",
+	}
 	LabelContainerName = LabelDescriptor{
 		Key:         "container_name",
 		Description: "User-provided name of the container or full container name for system containers",
@@ -110,6 +114,7 @@ var podLabels = []LabelDescriptor{
 	LabelPodName,
 	LabelPodId,
 	LabelPodNamespaceUID,
+	LabelTenant,
 	LabelLabels,
 }
 
@@ -28,6 +28,7 @@ const (
 var StandardMetrics = []Metric{
 	MetricUptime,
 	MetricCpuUsage,
+	MetricCpuThrottledTime,
 	MetricMemoryUsage,
 	MetricMemoryRSS,
 	MetricMemoryCache,
@@ -44,7 +45,8 @@ var AdditionalMetrics = []Metric{
 	MetricCpuRequest,
 	MetricCpuLimit,
 	MetricMemoryRequest,
-	MetricMemoryLimit}
+	MetricMemoryLimit,
+	MetricRestartCount}
 
 // Computed based on corresponding StandardMetrics.
 var RateMetrics = []Metric{
@@ -89,6 +91,7 @@ var CpuMetrics = []Metric{
 	MetricCpuRequest,
 	MetricCpuUsage,
 	MetricCpuUsageRate,
+	MetricCpuThrottledTime,
 	MetricNodeCpuAllocatable,
 	MetricNodeCpuCapacity,
 	MetricNodeCpuReservation,
@@ -198,6 +201,25 @@ var MetricCpuUsage = Metric{
 	},
 }
 
+var MetricCpuThrottledTime = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "cpu/throttled_time",
+		Description: "Cumulative time the container was throttled by the CFS quota",
+		Type:        MetricCumulative,
+		ValueType:   ValueInt64,
+		Units:       UnitsNanoseconds,
+	},
+	HasValue: func(spec *cadvisor.ContainerSpec) bool {
+		return spec.HasCpu
+	},
+	GetValue: func(spec *cadvisor.ContainerSpec, stat *cadvisor.ContainerStats) MetricValue {
+		return MetricValue{
+			ValueType:  ValueInt64,
+			MetricType: MetricCumulative,
+			IntValue:   int64(stat.Cpu.CFS.ThrottledTime)}
+	},
+}
+
 var MetricMemoryUsage = Metric{
 	MetricDescriptor: MetricDescriptor{
 		Name:        "memory/usage",
@@ -429,6 +451,59 @@ var MetricMemoryLimit = Metric{
 	},
 }
 
+var MetricRestartCount = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "restart_count",
+		Description: "Number of container restarts observed at scrape time.",
+		Type:        MetricCumulative,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+	},
+}
+
+// Usage of the pod cgroup reported by the kubelet, which includes the pause container and
+// the pod overhead, unlike the sum of the containers. Only set on pod metric sets.
+var MetricPodCgroupCpuUsageRate = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "cpu/pod_cgroup_usage_rate",
+		Description: "CPU usage of the pod cgroup on all cores in millicores",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsMillicores,
+	},
+}
+
+var MetricPodCgroupMemoryWorkingSet = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "memory/pod_cgroup_working_set",
+		Description: "Working set memory of the pod cgroup in bytes",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsBytes,
+	},
+}
+
+// Swap usage reported by kubelets with swap enabled on the node.
+var MetricMemorySwapUsage = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "memory/swap_usage",
+		Description: "Swap usage in bytes",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsBytes,
+	},
+}
+
+var MetricMemorySwapAvailable = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "memory/swap_available",
+		Description: "Swap available in bytes",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsBytes,
+	},
+}
+
 // Definition of Rate Metrics.
 var MetricCpuUsageRate = Metric{
 	MetricDescriptor: MetricDescriptor{
@@ -440,6 +515,18 @@ var MetricCpuUsageRate = Metric{
 	},
 }
 
+// Interval between the samples the CPU usage rate was computed from. Not exported to sinks,
+// it tells consumers when scrapes were missed.
+var MetricCpuUsageRateWindow = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "cpu/usage_rate_window",
+		Description: "Interval between the samples the CPU usage rate was computed from",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsNanoseconds,
+	},
+}
+
 var MetricMemoryPageFaultsRate = Metric{
 	MetricDescriptor: MetricDescriptor{
 		Name:        "memory/page_faults_rate",
@@ -46,3 +46,30 @@ func NodeContainerKey(node, container string) string {
 func ClusterKey() string {
 	return "cluster"
 }
+
+// SeriesKey returns the key relating the MetricSet stored under key to the ones of earlier
+// batches. Pods and their containers are related by pod UID when known, as batches key
+// them by name, so that a pod recreated under the same name starts a new series instead
+// of continuing the series of the deleted pod.
+func SeriesKey(key string, ms *MetricSet) string {
+	uid := ms.Labels[LabelPodId.Key]
+	if uid == "" {
+		return key
+	}
+	switch ms.Labels[LabelMetricSetType.Key] {
+	case MetricSetTypePod:
+		return fmt.Sprintf("pod_id:%s", uid)
+	case MetricSetTypePodContainer:
+		return fmt.Sprintf("pod_id:%s/container:%s", uid, ms.Labels[LabelContainerName.Key])
+	}
+	return key
+}
+
+// SeriesIndex returns the MetricSets of the batch by series key.
+func SeriesIndex(batch *DataBatch) map[string]*MetricSet {
+	result := make(map[string]*MetricSet, len(batch.MetricSets))
+	for key, ms := range batch.MetricSets {
+		result[SeriesKey(key, ms)] = ms
+	}
+	return result
+}
@@ -123,6 +123,37 @@ type MetricSet struct {
 	MetricValues   map[string]MetricValue
 	Labels         map[string]string
 	LabeledMetrics []LabeledMetric
+	// Whether the metric set is served again from an earlier scrape, e.g. of a node with its
+	// own scrape interval, with the scrape time of that scrape.
+	Cached bool
+}
+
+// Copy returns a deep copy of the metric set, for keeping it while processors modify the
+// original.
+func (this *MetricSet) Copy() *MetricSet {
+	result := &MetricSet{
+		CreateTime:     this.CreateTime,
+		ScrapeTime:     this.ScrapeTime,
+		MetricValues:   make(map[string]MetricValue, len(this.MetricValues)),
+		Labels:         make(map[string]string, len(this.Labels)),
+		LabeledMetrics: make([]LabeledMetric, 0, len(this.LabeledMetrics)),
+		Cached:         this.Cached,
+	}
+	for name, value := range this.MetricValues {
+		result.MetricValues[name] = value
+	}
+	for name, value := range this.Labels {
+		result.Labels[name] = value
+	}
+	for _, metric := range this.LabeledMetrics {
+		labels := make(map[string]string, len(metric.Labels))
+		for name, value := range metric.Labels {
+			labels[name] = value
+		}
+		metric.Labels = labels
+		result.LabeledMetrics = append(result.LabeledMetrics, metric)
+	}
+	return result
 }
 
 type DataBatch struct {
@@ -137,11 +168,23 @@ type MetricsSource interface {
 	ScrapeMetrics(start, end time.Time) *DataBatch
 }
 
+// A source which may ask to be scraped ahead of the other sources in each cycle.
+type PrioritizedMetricsSource interface {
+	MetricsSource
+	IsPrioritized() bool
+}
+
 // Provider of list of sources to be scaped.
 type MetricsSourceProvider interface {
 	GetMetricsSources() []MetricsSource
 }
 
+// ScrapeCycleObserver is implemented by providers keeping track of every scrape cycle. The
+// source manager calls CompleteScrapeCycle once the sources it got were scraped or timed out.
+type ScrapeCycleObserver interface {
+	CompleteScrapeCycle(now time.Time)
+}
+
 type DataSink interface {
 	Name() string
 
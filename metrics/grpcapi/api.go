@@ -0,0 +1,77 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcapi
+
+import (
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// The Metrics service streams the items of the Metrics API as the v1beta1 protobuf
+// messages of k8s.io/metrics, one message per node or pod, sparing clients listing
+// large clusters the encoding and decoding of a whole JSON list.
+
+// Full name of the service, in the protobuf package of the v1beta1 types.
+const ServiceName = "k8s.io.metrics.pkg.apis.metrics.v1beta1.Metrics"
+
+// Full names of the methods.
+const (
+	ListNodeMetricsMethod = "/" + ServiceName + "/ListNodeMetrics"
+	ListPodMetricsMethod  = "/" + ServiceName + "/ListPodMetrics"
+)
+
+// ListMetricsRequest selects the streamed items, like the query of a list request.
+type ListMetricsRequest struct {
+	// Namespace of the pods, all namespaces if empty. Ignored when listing nodes.
+	Namespace     string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	LabelSelector string `protobuf:"bytes,2,opt,name=label_selector,json=labelSelector,proto3" json:"label_selector,omitempty"`
+	// Name of the only item sent, like a get request, all items if empty.
+	Name string `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	// Node label NodeMetrics are aggregated by, like the groupBy parameter of the API.
+	GroupBy string `protobuf:"bytes,4,opt,name=group_by,json=groupBy,proto3" json:"group_by,omitempty"`
+	// Window in nanoseconds the usage is averaged over, 0 for the latest samples.
+	WindowNanos int64 `protobuf:"varint,5,opt,name=window_nanos,json=windowNanos,proto3" json:"window_nanos,omitempty"`
+	// Time in Unix nanoseconds of the retained batch served, 0 for the latest one.
+	TimeNanos int64 `protobuf:"varint,6,opt,name=time_nanos,json=timeNanos,proto3" json:"time_nanos,omitempty"`
+	// Whether unschedulable nodes are listed, "true" or "false", or the server's default if empty.
+	IncludeUnschedulable string `protobuf:"bytes,7,opt,name=include_unschedulable,json=includeUnschedulable,proto3" json:"include_unschedulable,omitempty"`
+}
+
+func (m *ListMetricsRequest) Reset()         { *m = ListMetricsRequest{} }
+func (m *ListMetricsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListMetricsRequest) ProtoMessage()    {}
+
+// NodeMetrics and PodMetrics of k8s.io/metrics/pkg/apis/metrics/v1beta1 are the streamed
+// responses.
+
+func serviceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "ListNodeMetrics",
+				Handler:       func(srv interface{}, stream grpc.ServerStream) error { return srv.(*Server).listNodeMetrics(stream) },
+				ServerStreams: true,
+			},
+			{
+				StreamName:    "ListPodMetrics",
+				Handler:       func(srv interface{}, stream grpc.ServerStream) error { return srv.(*Server).listPodMetrics(stream) },
+				ServerStreams: true,
+			},
+		},
+		Metadata: "k8s.io/metrics/pkg/apis/metrics/v1beta1/generated.proto",
+	}
+}
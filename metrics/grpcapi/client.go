@@ -0,0 +1,115 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcapi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// ListNodeMetrics streams the node metrics from the server of the connection, calling fn
+// with every item as it is received. Streaming stops at the first error of fn.
+func ListNodeMetrics(ctx context.Context, conn *grpc.ClientConn, request *ListMetricsRequest, fn func(*v1beta1.NodeMetrics) error, opts ...grpc.CallOption) error {
+	return list(ctx, conn, 0, ListNodeMetricsMethod, request, func() interface{} { return &v1beta1.NodeMetrics{} }, func(item interface{}) error {
+		return fn(item.(*v1beta1.NodeMetrics))
+	}, opts)
+}
+
+// ListPodMetrics streams the pod metrics from the server of the connection, calling fn
+// with every item as it is received. Streaming stops at the first error of fn.
+func ListPodMetrics(ctx context.Context, conn *grpc.ClientConn, request *ListMetricsRequest, fn func(*v1beta1.PodMetrics) error, opts ...grpc.CallOption) error {
+	return list(ctx, conn, 1, ListPodMetricsMethod, request, func() interface{} { return &v1beta1.PodMetrics{} }, func(item interface{}) error {
+		return fn(item.(*v1beta1.PodMetrics))
+	}, opts)
+}
+
+// Client calls the Metrics service of a connection with the same options on every call.
+type Client struct {
+	conn *grpc.ClientConn
+	opts []grpc.CallOption
+}
+
+func NewClient(conn *grpc.ClientConn, opts ...grpc.CallOption) *Client {
+	return &Client{conn: conn, opts: opts}
+}
+
+// ListNodeMetrics streams the node metrics, see ListNodeMetrics.
+func (this *Client) ListNodeMetrics(ctx context.Context, request *ListMetricsRequest, fn func(*v1beta1.NodeMetrics) error) error {
+	return ListNodeMetrics(ctx, this.conn, request, fn, this.opts...)
+}
+
+// ListPodMetrics streams the pod metrics, see ListPodMetrics.
+func (this *Client) ListPodMetrics(ctx context.Context, request *ListMetricsRequest, fn func(*v1beta1.PodMetrics) error) error {
+	return ListPodMetrics(ctx, this.conn, request, fn, this.opts...)
+}
+
+// tokenFileCredentials authenticates calls with the bearer token of a file, read again for
+// every call so that rotated tokens, like those of service accounts, are picked up.
+type tokenFileCredentials struct {
+	path string
+}
+
+// NewTokenFileCredentials returns the credentials of the bearer token in the file, which
+// are only sent over secure connections.
+func NewTokenFileCredentials(path string) credentials.PerRPCCredentials {
+	return &tokenFileCredentials{path: path}
+}
+
+func (this *tokenFileCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := ioutil.ReadFile(this.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the bearer token: %v", err)
+	}
+	return map[string]string{"authorization": "Bearer " + string(bytes.TrimSpace(token))}, nil
+}
+
+func (this *tokenFileCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+func list(ctx context.Context, conn *grpc.ClientConn, streamIndex int, method string, request *ListMetricsRequest,
+	newItem func() interface{}, fn func(interface{}) error, opts []grpc.CallOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := grpc.NewClientStream(ctx, &serviceDesc().Streams[streamIndex], conn, method, opts...)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(request); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		item := newItem()
+		if err := stream.RecvMsg(item); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/metrics/pkg/apis/metrics"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// NodeMetricsVisitor visits the items of node metrics lists as they're built, like the node
+// metrics storage of the Metrics API.
+type NodeMetricsVisitor interface {
+	Visit(ctx genericapirequest.Context, options *metainternalversion.ListOptions, fn func(*metrics.NodeMetrics) error) (string, error)
+}
+
+// PodMetricsVisitor visits the items of pod metrics lists as they're built, like the pod
+// metrics storage of the Metrics API.
+type PodMetricsVisitor interface {
+	Visit(ctx genericapirequest.Context, options *metainternalversion.ListOptions, fn func(*metrics.PodMetrics) error) (string, error)
+}
+
+// Call is a served call of the Metrics service, for logging calls like the requests of the API.
+type Call struct {
+	Method    string
+	User      string
+	Resource  string
+	Namespace string
+	// Label selector of the request.
+	LabelSelector string
+	Code          codes.Code
+	// Number of items sent.
+	Items   int
+	Latency time.Duration
+}
+
+// Server serves the Metrics service from the storages of the Metrics API, so items are
+// the same as in the responses of list requests. Items are sent as soon as the storage
+// built them.
+type Server struct {
+	nodes NodeMetricsVisitor
+	// Nil if pod metrics aren't served.
+	pods PodMetricsVisitor
+	// Converts the items of the storages to v1beta1.
+	convertor runtime.ObjectConvertor
+	// Nil to serve unauthenticated requests, e.g. if authentication is disabled for testing.
+	authenticator authenticator.Request
+	authorizer    authorizer.Authorizer
+	// Called with every served call, nil to not log them.
+	logCall func(*Call)
+}
+
+// NewServer returns a server streaming the metrics from the storages. Clients are
+// authenticated and authorized like requests listing the resources of the Metrics API:
+// with the bearer token of the authorization metadata or their TLS client certificate.
+// Calls are passed to logCall once served, unless it's nil.
+func NewServer(nodes NodeMetricsVisitor, pods PodMetricsVisitor, convertor runtime.ObjectConvertor, authn authenticator.Request, authz authorizer.Authorizer, logCall func(*Call)) *Server {
+	return &Server{
+		nodes:         nodes,
+		pods:          pods,
+		convertor:     convertor,
+		authenticator: authn,
+		authorizer:    authz,
+		logCall:       logCall,
+	}
+}
+
+// Register adds the Metrics service to the gRPC server.
+func (this *Server) Register(server *grpc.Server) {
+	server.RegisterService(serviceDesc(), this)
+}
+
+func (this *Server) listNodeMetrics(stream grpc.ServerStream) (err error) {
+	call := &Call{Method: ListNodeMetricsMethod, Resource: "nodes"}
+	defer this.log(call, time.Now(), &err)
+	ctx, options, name, err := this.receiveRequest(stream, call, false)
+	if err != nil {
+		return err
+	}
+	send := func(item *metrics.NodeMetrics) error {
+		out := &v1beta1.NodeMetrics{}
+		if err := this.convertor.Convert(item, out, nil); err != nil {
+			return status.Errorf(codes.Internal, "unable to convert node metrics: %v", err)
+		}
+		call.Items++
+		return stream.SendMsg(out)
+	}
+	if name != "" {
+		item, err := this.get(this.nodes, ctx, name)
+		if err != nil {
+			return err
+		}
+		return send(item.(*metrics.NodeMetrics))
+	}
+	_, err = this.nodes.Visit(ctx, options, send)
+	return toStatusError(err)
+}
+
+func (this *Server) listPodMetrics(stream grpc.ServerStream) (err error) {
+	call := &Call{Method: ListPodMetricsMethod, Resource: "pods"}
+	defer this.log(call, time.Now(), &err)
+	if this.pods == nil {
+		return status.Error(codes.Unimplemented, "pod metrics are not served")
+	}
+	ctx, options, name, err := this.receiveRequest(stream, call, true)
+	if err != nil {
+		return err
+	}
+	send := func(item *metrics.PodMetrics) error {
+		out := &v1beta1.PodMetrics{}
+		if err := this.convertor.Convert(item, out, nil); err != nil {
+			return status.Errorf(codes.Internal, "unable to convert pod metrics: %v", err)
+		}
+		call.Items++
+		return stream.SendMsg(out)
+	}
+	if name != "" {
+		item, err := this.get(this.pods, ctx, name)
+		if err != nil {
+			return err
+		}
+		return send(item.(*metrics.PodMetrics))
+	}
+	_, err = this.pods.Visit(ctx, options, send)
+	return toStatusError(err)
+}
+
+// get returns the named item of the storage, which needs to be a getter like the storages
+// of the Metrics API.
+func (this *Server) get(storage interface{}, ctx genericapirequest.Context, name string) (runtime.Object, error) {
+	getter, ok := storage.(rest.Getter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "getting single items is not served")
+	}
+	item, err := getter.Get(ctx, name, &metav1.GetOptions{})
+	return item, toStatusError(err)
+}
+
+// log passes the call to logCall, with the code of the error it returned.
+func (this *Server) log(call *Call, start time.Time, err *error) {
+	if this.logCall == nil {
+		return
+	}
+	call.Code = grpc.Code(*err)
+	call.Latency = time.Since(start)
+	this.logCall(call)
+}
+
+// receiveRequest reads the request of the stream into the call, authorizes listing the
+// resource, and returns the context and options the storage is listed with, and the name
+// of the only item requested, if any. Getting an item is authorized like listing.
+func (this *Server) receiveRequest(stream grpc.ServerStream, call *Call, namespaced bool) (genericapirequest.Context, *metainternalversion.ListOptions, string, error) {
+	request := &ListMetricsRequest{}
+	if err := stream.RecvMsg(request); err != nil {
+		return nil, nil, "", err
+	}
+	call.LabelSelector = request.LabelSelector
+	selector, err := labels.Parse(request.LabelSelector)
+	if err != nil {
+		return nil, nil, "", status.Errorf(codes.InvalidArgument, "invalid label selector %q: %v", request.LabelSelector, err)
+	}
+	namespace := ""
+	if namespaced {
+		namespace = request.Namespace
+	}
+	call.Namespace = namespace
+
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), namespace)
+	if ctx, err = withRequestOptions(ctx, request); err != nil {
+		return nil, nil, "", err
+	}
+	if this.authenticator != nil {
+		userInfo, err := this.authenticate(stream.Context())
+		if err != nil {
+			return nil, nil, "", err
+		}
+		call.User = userInfo.GetName()
+		if err := this.authorize(userInfo, call.Resource, namespace); err != nil {
+			return nil, nil, "", err
+		}
+		ctx = genericapirequest.WithUser(ctx, userInfo)
+	}
+	return ctx, &metainternalversion.ListOptions{LabelSelector: selector}, request.Name, nil
+}
+
+// withRequestOptions returns the context with the options of the request which the API
+// takes as query parameters.
+func withRequestOptions(ctx genericapirequest.Context, request *ListMetricsRequest) (genericapirequest.Context, error) {
+	if request.GroupBy != "" {
+		ctx = util.WithGroupBy(ctx, request.GroupBy)
+	}
+	if request.WindowNanos > 0 {
+		ctx = util.WithWindow(ctx, time.Duration(request.WindowNanos))
+	}
+	if request.TimeNanos > 0 {
+		ctx = util.WithTime(ctx, time.Unix(0, request.TimeNanos))
+	}
+	if request.IncludeUnschedulable != "" {
+		include, err := strconv.ParseBool(request.IncludeUnschedulable)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid include_unschedulable %q", request.IncludeUnschedulable)
+		}
+		ctx = util.WithIncludeUnschedulable(ctx, include)
+	}
+	return ctx, nil
+}
+
+// authenticate passes the credentials of the stream to the authenticator of the API as
+// the ones of an HTTP request.
+func (this *Server) authenticate(ctx context.Context) (user.Info, error) {
+	req := &http.Request{Header: http.Header{}}
+	if md, found := metadata.FromIncomingContext(ctx); found {
+		for _, value := range md["authorization"] {
+			req.Header.Add("Authorization", value)
+		}
+	}
+	if p, found := peer.FromContext(ctx); found {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.TLS = &info.State
+		}
+	}
+	userInfo, ok, err := this.authenticator.AuthenticateRequest(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "unable to authenticate: %v", err)
+	}
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no credentials found")
+	}
+	return userInfo, nil
+}
+
+func (this *Server) authorize(userInfo user.Info, resource, namespace string) error {
+	authorized, err := util.CanList(this.authorizer, userInfo, resource, namespace)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to authorize: %v", err)
+	}
+	if !authorized {
+		return status.Errorf(codes.PermissionDenied, "%s cannot list %s.%s", userInfo.GetName(), resource, v1beta1.SchemeGroupVersion.Group)
+	}
+	return nil
+}
+
+// toStatusError maps the API errors of the storages to gRPC status codes, and keeps the
+// other status errors.
+func toStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	apiStatus, ok := err.(errors.APIStatus)
+	if !ok {
+		return status.Error(codes.Unknown, err.Error())
+	}
+	switch apiStatus.Status().Code {
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, err.Error())
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
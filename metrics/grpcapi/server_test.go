@@ -0,0 +1,333 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcapi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// Storage visiting the items of the list, and recording the namespace and selector it was
+// listed with and how many items were visited.
+type fakeStorage struct {
+	nodes     []metrics.NodeMetrics
+	pods      []metrics.PodMetrics
+	err       error
+	namespace string
+	selector  string
+	visited   int32
+	// Context of the latest call.
+	ctx genericapirequest.Context
+}
+
+func (this *fakeStorage) record(ctx genericapirequest.Context, options *metainternalversion.ListOptions) {
+	this.namespace = genericapirequest.NamespaceValue(ctx)
+	this.selector = options.LabelSelector.String()
+	this.ctx = ctx
+	atomic.StoreInt32(&this.visited, 0)
+}
+
+type fakeNodeStorage struct{ fakeStorage }
+
+func (this *fakeNodeStorage) Visit(ctx genericapirequest.Context, options *metainternalversion.ListOptions, fn func(*metrics.NodeMetrics) error) (string, error) {
+	this.record(ctx, options)
+	if this.err != nil {
+		return "", this.err
+	}
+	for i := range this.nodes {
+		atomic.AddInt32(&this.visited, 1)
+		if err := fn(&this.nodes[i]); err != nil {
+			return "", err
+		}
+	}
+	return "1", nil
+}
+
+func (this *fakeNodeStorage) Get(ctx genericapirequest.Context, name string, opts *metav1.GetOptions) (runtime.Object, error) {
+	this.ctx = ctx
+	for i := range this.nodes {
+		if this.nodes[i].Name == name {
+			return &this.nodes[i], nil
+		}
+	}
+	return nil, errors.NewNotFound(metrics.Resource("nodemetrics"), name)
+}
+
+type fakePodStorage struct{ fakeStorage }
+
+func (this *fakePodStorage) Visit(ctx genericapirequest.Context, options *metainternalversion.ListOptions, fn func(*metrics.PodMetrics) error) (string, error) {
+	this.record(ctx, options)
+	if this.err != nil {
+		return "", this.err
+	}
+	for i := range this.pods {
+		atomic.AddInt32(&this.visited, 1)
+		if err := fn(&this.pods[i]); err != nil {
+			return "", err
+		}
+	}
+	return "1", nil
+}
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	require.NoError(t, metrics.AddToScheme(scheme))
+	require.NoError(t, v1beta1.AddToScheme(scheme))
+	return scheme
+}
+
+func serve(t *testing.T, service *Server) (*grpc.ClientConn, func()) {
+	server := grpc.NewServer()
+	service.Register(server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	return conn, func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestListMetrics(t *testing.T) {
+	nodes := &fakeNodeStorage{fakeStorage{nodes: []metrics.NodeMetrics{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}, Usage: metrics.ResourceList{"cpu": resource.MustParse("100m")}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}, Usage: metrics.ResourceList{"cpu": resource.MustParse("200m")}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3"}, Usage: metrics.ResourceList{"cpu": resource.MustParse("300m")}},
+	}}}
+	pods := &fakePodStorage{fakeStorage{pods: []metrics.PodMetrics{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "pod1"},
+		Containers: []metrics.ContainerMetrics{{Name: "app", Usage: metrics.ResourceList{"memory": resource.MustParse("1Mi")}}},
+	}}}}
+	var calls []*Call
+	conn, stop := serve(t, NewServer(nodes, pods, newScheme(t), nil, nil, func(call *Call) { calls = append(calls, call) }))
+	defer stop()
+
+	var names []string
+	err := ListNodeMetrics(context.Background(), conn, &ListMetricsRequest{Namespace: "ns1", LabelSelector: "role=worker"}, func(item *v1beta1.NodeMetrics) error {
+		names = append(names, item.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node1", "node2", "node3"}, names)
+	assert.Equal(t, "", nodes.namespace, "nodes aren't namespaced")
+	assert.Equal(t, "role=worker", nodes.selector)
+
+	var items []*v1beta1.PodMetrics
+	err = ListPodMetrics(context.Background(), conn, &ListMetricsRequest{Namespace: "ns1"}, func(item *v1beta1.PodMetrics) error {
+		items = append(items, item)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "pod1", items[0].Name)
+	assert.Equal(t, "ns1", pods.namespace)
+	memory := items[0].Containers[0].Usage["memory"]
+	assert.Equal(t, int64(1<<20), memory.Value())
+
+	err = ListNodeMetrics(context.Background(), conn, &ListMetricsRequest{LabelSelector: "a in ("}, func(*v1beta1.NodeMetrics) error { return nil })
+	assert.Equal(t, codes.InvalidArgument, grpc.Code(err))
+
+	nodes.err = errors.NewServiceUnavailable("metrics are stale")
+	err = ListNodeMetrics(context.Background(), conn, &ListMetricsRequest{}, func(*v1beta1.NodeMetrics) error { return nil })
+	assert.Equal(t, codes.Unavailable, grpc.Code(err))
+
+	require.Len(t, calls, 4, "calls are logged once served")
+	assert.Equal(t, &Call{Method: ListNodeMetricsMethod, Resource: "nodes", LabelSelector: "role=worker", Code: codes.OK, Items: 3, Latency: calls[0].Latency}, calls[0])
+	assert.Equal(t, &Call{Method: ListPodMetricsMethod, Resource: "pods", Namespace: "ns1", Code: codes.OK, Items: 1, Latency: calls[1].Latency}, calls[1])
+	assert.Equal(t, codes.InvalidArgument, calls[2].Code)
+	assert.Equal(t, codes.Unavailable, calls[3].Code)
+
+	stopErr := fmt.Errorf("enough")
+	nodes.err = nil
+	count := 0
+	err = ListNodeMetrics(context.Background(), conn, &ListMetricsRequest{}, func(*v1beta1.NodeMetrics) error {
+		count++
+		return stopErr
+	})
+	assert.Equal(t, stopErr, err)
+	assert.Equal(t, 1, count, "streaming stops at the first error")
+}
+
+func TestListMetricsStreamsItems(t *testing.T) {
+	// More than the flow control windows of the connection buffer.
+	nodes := &fakeNodeStorage{fakeStorage{nodes: make([]metrics.NodeMetrics, 1000)}}
+	for i := range nodes.nodes {
+		nodes.nodes[i].Name = fmt.Sprintf("node%d", i)
+		nodes.nodes[i].Labels = map[string]string{"padding": strings.Repeat("x", 4096)}
+	}
+	conn, stop := serve(t, NewServer(nodes, nil, newScheme(t), nil, nil, nil))
+	defer stop()
+
+	received := make(chan string)
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- ListNodeMetrics(ctx, conn, &ListMetricsRequest{}, func(item *v1beta1.NodeMetrics) error {
+			received <- item.Name
+			return nil
+		})
+	}()
+	assert.Equal(t, "node0", <-received)
+	cancel()
+	for {
+		select {
+		case <-received:
+			continue
+		case <-done:
+		}
+		break
+	}
+	visited := atomic.LoadInt32(&nodes.visited)
+	assert.True(t, int(visited) < len(nodes.nodes), "items are built as they're sent, %d visited", visited)
+}
+
+func TestListMetricsWithoutPods(t *testing.T) {
+	conn, stop := serve(t, NewServer(&fakeNodeStorage{}, nil, newScheme(t), nil, nil, nil))
+	defer stop()
+
+	err := ListPodMetrics(context.Background(), conn, &ListMetricsRequest{}, func(*v1beta1.PodMetrics) error { return nil })
+	assert.Equal(t, codes.Unimplemented, grpc.Code(err))
+}
+
+func TestListMetricsAuth(t *testing.T) {
+	authn := authenticator.RequestFunc(func(req *http.Request) (user.Info, bool, error) {
+		switch req.Header.Get("Authorization") {
+		case "":
+			return nil, false, nil
+		case "Bearer autoscaler", "Bearer viewer":
+			return &user.DefaultInfo{Name: req.Header.Get("Authorization")[len("Bearer "):]}, true, nil
+		default:
+			return nil, false, fmt.Errorf("invalid token")
+		}
+	})
+	var attributes authorizer.Attributes
+	authz := authorizer.AuthorizerFunc(func(a authorizer.Attributes) (bool, string, error) {
+		attributes = a
+		return a.GetUser().GetName() == "autoscaler", "only the autoscaler", nil
+	})
+	var calls []*Call
+	conn, stop := serve(t, NewServer(&fakeNodeStorage{}, &fakePodStorage{}, newScheme(t), authn, authz, func(call *Call) { calls = append(calls, call) }))
+	defer stop()
+	list := func(token string) error {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+		}
+		return ListPodMetrics(ctx, conn, &ListMetricsRequest{Namespace: "ns1"}, func(*v1beta1.PodMetrics) error { return nil })
+	}
+
+	assert.Equal(t, codes.Unauthenticated, grpc.Code(list("")))
+	assert.Equal(t, codes.Unauthenticated, grpc.Code(list("unknown")))
+	assert.Equal(t, codes.PermissionDenied, grpc.Code(list("viewer")))
+	require.NoError(t, list("autoscaler"))
+	assert.Equal(t, "list", attributes.GetVerb())
+	assert.Equal(t, "ns1", attributes.GetNamespace())
+	assert.Equal(t, schema.GroupResource{Group: "metrics.k8s.io", Resource: "pods"}, schema.GroupResource{Group: attributes.GetAPIGroup(), Resource: attributes.GetResource()})
+	require.Len(t, calls, 4)
+	assert.Equal(t, "", calls[0].User)
+	assert.Equal(t, codes.PermissionDenied, calls[2].Code)
+	assert.Equal(t, "viewer", calls[2].User, "denied calls are logged with the user")
+	assert.Equal(t, "autoscaler", calls[3].User)
+}
+
+func TestListMetricsWithOptions(t *testing.T) {
+	nodes := &fakeNodeStorage{fakeStorage{nodes: []metrics.NodeMetrics{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+	}}}
+	conn, stop := serve(t, NewServer(nodes, &fakePodStorage{}, newScheme(t), nil, nil, nil))
+	defer stop()
+	list := func(request *ListMetricsRequest) ([]string, error) {
+		var names []string
+		err := ListNodeMetrics(context.Background(), conn, request, func(item *v1beta1.NodeMetrics) error {
+			names = append(names, item.Name)
+			return nil
+		})
+		return names, err
+	}
+
+	at := time.Unix(1500000000, 0)
+	_, err := list(&ListMetricsRequest{GroupBy: "zone", WindowNanos: int64(5 * time.Minute), TimeNanos: at.UnixNano(), IncludeUnschedulable: "false"})
+	require.NoError(t, err)
+	assert.Equal(t, "zone", util.GroupByFrom(nodes.ctx))
+	assert.Equal(t, 5*time.Minute, util.WindowFrom(nodes.ctx))
+	assert.True(t, at.Equal(util.TimeFrom(nodes.ctx)))
+	include, found := util.IncludeUnschedulableFrom(nodes.ctx)
+	assert.True(t, found)
+	assert.False(t, include)
+	_, err = list(&ListMetricsRequest{IncludeUnschedulable: "maybe"})
+	assert.Equal(t, codes.InvalidArgument, grpc.Code(err))
+
+	names, err := list(&ListMetricsRequest{Name: "node2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node2"}, names, "only the named item is sent")
+	_, err = list(&ListMetricsRequest{Name: "node3"})
+	assert.Equal(t, codes.NotFound, grpc.Code(err))
+
+	err = ListPodMetrics(context.Background(), conn, &ListMetricsRequest{Namespace: "ns1", Name: "pod1"}, func(*v1beta1.PodMetrics) error { return nil })
+	assert.Equal(t, codes.Unimplemented, grpc.Code(err), "the storage doesn't get single items")
+}
+
+func TestTokenFileCredentials(t *testing.T) {
+	file, err := ioutil.TempFile("", "token")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	creds := NewTokenFileCredentials(file.Name())
+	assert.True(t, creds.RequireTransportSecurity())
+
+	require.NoError(t, ioutil.WriteFile(file.Name(), []byte("first\n"), 0600))
+	md, err := creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer first"}, md)
+
+	require.NoError(t, ioutil.WriteFile(file.Name(), []byte("rotated"), 0600))
+	md, err = creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer rotated", md["authorization"], "the token is read again for every call")
+
+	os.Remove(file.Name())
+	_, err = creds.GetRequestMetadata(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,299 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metricsjson encodes Metrics API objects as JSON without reflection. Encoding
+// full-cluster lists dominates the CPU usage of the server when they're polled often.
+package metricsjson
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+var encoders = sync.Pool{New: func() interface{} { return &encoder{} }}
+
+// Encode writes the object as encoding/json would, followed by a newline like
+// json.Encoder. It returns false without writing anything if the object isn't a
+// NodeMetrics, PodMetrics or list of them, or has metadata the Metrics API doesn't set.
+func Encode(obj runtime.Object, w io.Writer) (bool, error) {
+	e := encoders.Get().(*encoder)
+	defer encoders.Put(e)
+	e.buf = e.buf[:0]
+
+	switch obj := obj.(type) {
+	case *v1beta1.NodeMetricsList:
+		for i := range obj.Items {
+			if !isSupported(&obj.Items[i].ObjectMeta) {
+				return false, nil
+			}
+		}
+		e.nodeMetricsList(obj)
+	case *v1beta1.PodMetricsList:
+		for i := range obj.Items {
+			if !isSupported(&obj.Items[i].ObjectMeta) {
+				return false, nil
+			}
+		}
+		e.podMetricsList(obj)
+	case *v1beta1.NodeMetrics:
+		if !isSupported(&obj.ObjectMeta) {
+			return false, nil
+		}
+		e.nodeMetrics(obj)
+	case *v1beta1.PodMetrics:
+		if !isSupported(&obj.ObjectMeta) {
+			return false, nil
+		}
+		e.podMetrics(obj)
+	default:
+		return false, nil
+	}
+	e.buf = append(e.buf, '\n')
+	_, err := w.Write(e.buf)
+	return true, err
+}
+
+// isSupported checks that only the metadata fields set by the Metrics API storages are set.
+func isSupported(meta *metav1.ObjectMeta) bool {
+	return meta.GenerateName == "" && meta.Generation == 0 && meta.DeletionTimestamp == nil &&
+		meta.DeletionGracePeriodSeconds == nil && len(meta.OwnerReferences) == 0 &&
+		meta.Initializers == nil && len(meta.Finalizers) == 0 && meta.ClusterName == ""
+}
+
+type encoder struct {
+	buf []byte
+	// Sorted keys of the map being encoded.
+	keys []string
+}
+
+func (e *encoder) nodeMetricsList(list *v1beta1.NodeMetricsList) {
+	e.buf = append(e.buf, '{')
+	e.typeMeta(&list.TypeMeta)
+	e.listMeta(&list.ListMeta)
+	e.buf = append(e.buf, `,"items":`...)
+	if list.Items == nil {
+		e.buf = append(e.buf, "null}"...)
+		return
+	}
+	e.buf = append(e.buf, '[')
+	for i := range list.Items {
+		if i > 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.nodeMetrics(&list.Items[i])
+	}
+	e.buf = append(e.buf, "]}"...)
+}
+
+func (e *encoder) podMetricsList(list *v1beta1.PodMetricsList) {
+	e.buf = append(e.buf, '{')
+	e.typeMeta(&list.TypeMeta)
+	e.listMeta(&list.ListMeta)
+	e.buf = append(e.buf, `,"items":`...)
+	if list.Items == nil {
+		e.buf = append(e.buf, "null}"...)
+		return
+	}
+	e.buf = append(e.buf, '[')
+	for i := range list.Items {
+		if i > 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.podMetrics(&list.Items[i])
+	}
+	e.buf = append(e.buf, "]}"...)
+}
+
+func (e *encoder) nodeMetrics(m *v1beta1.NodeMetrics) {
+	e.buf = append(e.buf, '{')
+	e.typeMeta(&m.TypeMeta)
+	e.objectMeta(&m.ObjectMeta)
+	e.buf = append(e.buf, `,"timestamp":`...)
+	e.time(m.Timestamp)
+	e.buf = append(e.buf, `,"window":`...)
+	e.duration(m.Window.Duration)
+	e.buf = append(e.buf, `,"usage":`...)
+	e.resourceList(m.Usage)
+	e.buf = append(e.buf, '}')
+}
+
+func (e *encoder) podMetrics(m *v1beta1.PodMetrics) {
+	e.buf = append(e.buf, '{')
+	e.typeMeta(&m.TypeMeta)
+	e.objectMeta(&m.ObjectMeta)
+	e.buf = append(e.buf, `,"timestamp":`...)
+	e.time(m.Timestamp)
+	e.buf = append(e.buf, `,"window":`...)
+	e.duration(m.Window.Duration)
+	e.buf = append(e.buf, `,"containers":`...)
+	if m.Containers == nil {
+		e.buf = append(e.buf, "null}"...)
+		return
+	}
+	e.buf = append(e.buf, '[')
+	for i := range m.Containers {
+		if i > 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.buf = append(e.buf, `{"name":`...)
+		e.string(m.Containers[i].Name)
+		e.buf = append(e.buf, `,"usage":`...)
+		e.resourceList(m.Containers[i].Usage)
+		e.buf = append(e.buf, '}')
+	}
+	e.buf = append(e.buf, "]}"...)
+}
+
+// typeMeta writes the inlined type fields, each followed by a comma.
+func (e *encoder) typeMeta(meta *metav1.TypeMeta) {
+	if meta.Kind != "" {
+		e.buf = append(e.buf, `"kind":`...)
+		e.string(meta.Kind)
+		e.buf = append(e.buf, ',')
+	}
+	if meta.APIVersion != "" {
+		e.buf = append(e.buf, `"apiVersion":`...)
+		e.string(meta.APIVersion)
+		e.buf = append(e.buf, ',')
+	}
+}
+
+func (e *encoder) listMeta(meta *metav1.ListMeta) {
+	e.buf = append(e.buf, `"metadata":{`...)
+	first := true
+	e.optionalString(&first, "selfLink", meta.SelfLink)
+	e.optionalString(&first, "resourceVersion", meta.ResourceVersion)
+	e.buf = append(e.buf, '}')
+}
+
+func (e *encoder) objectMeta(meta *metav1.ObjectMeta) {
+	e.buf = append(e.buf, `"metadata":{`...)
+	first := true
+	e.optionalString(&first, "name", meta.Name)
+	e.optionalString(&first, "namespace", meta.Namespace)
+	e.optionalString(&first, "selfLink", meta.SelfLink)
+	e.optionalString(&first, "uid", string(meta.UID))
+	e.optionalString(&first, "resourceVersion", meta.ResourceVersion)
+	if !first {
+		e.buf = append(e.buf, ',')
+	}
+	e.buf = append(e.buf, `"creationTimestamp":`...)
+	e.time(meta.CreationTimestamp)
+	e.stringMap("labels", meta.Labels)
+	e.stringMap("annotations", meta.Annotations)
+	e.buf = append(e.buf, '}')
+}
+
+func (e *encoder) optionalString(first *bool, name, value string) {
+	if value == "" {
+		return
+	}
+	if !*first {
+		e.buf = append(e.buf, ',')
+	}
+	*first = false
+	e.buf = append(e.buf, '"')
+	e.buf = append(e.buf, name...)
+	e.buf = append(e.buf, `":`...)
+	e.string(value)
+}
+
+// stringMap writes a non-empty map with its keys sorted, preceded by a comma.
+func (e *encoder) stringMap(name string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+	e.keys = e.keys[:0]
+	for key := range values {
+		e.keys = append(e.keys, key)
+	}
+	sort.Strings(e.keys)
+	e.buf = append(e.buf, `,"`...)
+	e.buf = append(e.buf, name...)
+	e.buf = append(e.buf, `":{`...)
+	for i, key := range e.keys {
+		if i > 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.string(key)
+		e.buf = append(e.buf, ':')
+		e.string(values[key])
+	}
+	e.buf = append(e.buf, '}')
+}
+
+func (e *encoder) resourceList(resources corev1.ResourceList) {
+	if resources == nil {
+		e.buf = append(e.buf, "null"...)
+		return
+	}
+	e.keys = e.keys[:0]
+	for name := range resources {
+		e.keys = append(e.keys, string(name))
+	}
+	sort.Strings(e.keys)
+	e.buf = append(e.buf, '{')
+	for i, name := range e.keys {
+		if i > 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.string(name)
+		quantity := resources[corev1.ResourceName(name)]
+		e.buf = append(e.buf, `:"`...)
+		number, suffix := quantity.CanonicalizeBytes(e.buf[len(e.buf):])
+		e.buf = append(e.buf, number...)
+		e.buf = append(e.buf, suffix...)
+		e.buf = append(e.buf, '"')
+	}
+	e.buf = append(e.buf, '}')
+}
+
+func (e *encoder) time(t metav1.Time) {
+	if t.IsZero() {
+		e.buf = append(e.buf, "null"...)
+		return
+	}
+	e.buf = append(e.buf, '"')
+	e.buf = t.UTC().AppendFormat(e.buf, time.RFC3339)
+	e.buf = append(e.buf, '"')
+}
+
+func (e *encoder) duration(d time.Duration) {
+	e.buf = append(e.buf, '"')
+	e.buf = append(e.buf, d.String()...)
+	e.buf = append(e.buf, '"')
+}
+
+// string writes a quoted string. Strings which need escaping are left to encoding/json.
+func (e *encoder) string(s string) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= utf8.RuneSelf || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			quoted, _ := json.Marshal(s)
+			e.buf = append(e.buf, quoted...)
+			return
+		}
+	}
+	e.buf = append(e.buf, '"')
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, '"')
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+var now = metav1.NewTime(time.Date(2017, 9, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600)))
+
+func usage(millicores, bytes int64) corev1.ResourceList {
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    *resource.NewMilliQuantity(millicores, resource.DecimalSI),
+		corev1.ResourceMemory: *resource.NewQuantity(bytes, resource.BinarySI),
+	}
+}
+
+func newNodeMetricsList(nodes int) *v1beta1.NodeMetricsList {
+	list := &v1beta1.NodeMetricsList{
+		TypeMeta: metav1.TypeMeta{Kind: "NodeMetricsList", APIVersion: "metrics.k8s.io/v1beta1"},
+		ListMeta: metav1.ListMeta{SelfLink: "/apis/metrics.k8s.io/v1beta1/nodes", ResourceVersion: "42"},
+	}
+	for i := 0; i < nodes; i++ {
+		list.Items = append(list.Items, v1beta1.NodeMetrics{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              fmt.Sprintf("node-%d", i),
+				SelfLink:          fmt.Sprintf("/apis/metrics.k8s.io/v1beta1/nodes/node-%d", i),
+				CreationTimestamp: now,
+			},
+			Timestamp: now,
+			Window:    metav1.Duration{Duration: time.Minute},
+			Usage:     usage(int64(i*10+1), int64(i)<<20),
+		})
+	}
+	return list
+}
+
+func newPodMetricsList(pods int) *v1beta1.PodMetricsList {
+	list := &v1beta1.PodMetricsList{
+		TypeMeta: metav1.TypeMeta{Kind: "PodMetricsList", APIVersion: "metrics.k8s.io/v1beta1"},
+		ListMeta: metav1.ListMeta{SelfLink: "/apis/metrics.k8s.io/v1beta1/pods"},
+	}
+	for i := 0; i < pods; i++ {
+		list.Items = append(list.Items, v1beta1.PodMetrics{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              fmt.Sprintf("pod-%d", i),
+				Namespace:         fmt.Sprintf("ns-%d", i%10),
+				CreationTimestamp: now,
+				Labels:            map[string]string{"app": "web", "tier": "frontend"},
+			},
+			Timestamp: now,
+			Window:    metav1.Duration{Duration: 30 * time.Second},
+			Containers: []v1beta1.ContainerMetrics{
+				{Name: "app", Usage: usage(int64(i), 100<<20)},
+				{Name: "sidecar", Usage: usage(5, 10<<20)},
+			},
+		})
+	}
+	return list
+}
+
+func assertEncodesAsJSON(t *testing.T, obj runtime.Object) {
+	expected := &bytes.Buffer{}
+	require.NoError(t, json.NewEncoder(expected).Encode(obj))
+	actual := &bytes.Buffer{}
+	supported, err := Encode(obj, actual)
+	require.NoError(t, err)
+	require.True(t, supported)
+	assert.Equal(t, expected.String(), actual.String())
+}
+
+func TestEncode(t *testing.T) {
+	assertEncodesAsJSON(t, newNodeMetricsList(3))
+	assertEncodesAsJSON(t, newPodMetricsList(3))
+	assertEncodesAsJSON(t, &v1beta1.NodeMetricsList{})
+	assertEncodesAsJSON(t, &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{{}}})
+	assertEncodesAsJSON(t, &v1beta1.NodeMetrics{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "node-1",
+			UID:         "1234",
+			Annotations: map[string]string{"metrics.k8s.io/usage-trend": `{"cpu":"<5m>"}`, "a": "ü \x01"},
+		},
+		Usage: corev1.ResourceList{},
+	})
+	assertEncodesAsJSON(t, &v1beta1.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default", ResourceVersion: "7"},
+		Timestamp:  now,
+		Containers: []v1beta1.ContainerMetrics{{Name: "app"}},
+	})
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	buf := &bytes.Buffer{}
+	supported, err := Encode(&corev1.Pod{}, buf)
+	require.NoError(t, err)
+	assert.False(t, supported)
+
+	list := newPodMetricsList(2)
+	list.Items[1].OwnerReferences = []metav1.OwnerReference{{Name: "owner"}}
+	supported, err = Encode(list, buf)
+	require.NoError(t, err)
+	assert.False(t, supported, "metadata not set by the Metrics API")
+	assert.Empty(t, buf.String())
+}
+
+func BenchmarkEncodeNodeMetricsList(b *testing.B) {
+	list := newNodeMetricsList(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Encode(list, ioutil.Discard)
+	}
+}
+
+func BenchmarkJSONNodeMetricsList(b *testing.B) {
+	list := newNodeMetricsList(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		json.NewEncoder(ioutil.Discard).Encode(list)
+	}
+}
+
+func BenchmarkEncodePodMetricsList(b *testing.B) {
+	list := newPodMetricsList(5000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Encode(list, ioutil.Discard)
+	}
+}
+
+func BenchmarkJSONPodMetricsList(b *testing.B) {
+	list := newPodMetricsList(5000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		json.NewEncoder(ioutil.Discard).Encode(list)
+	}
+}
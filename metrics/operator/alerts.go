@@ -0,0 +1,187 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Prometheus job the alert expressions select the metrics-server targets by.
+	AlertsJob = "metrics-server"
+
+	alertRulesName = "metrics-server"
+	// Scrapes or exports this many resolutions apart are reported as stalled.
+	stalledResolutions = 3
+)
+
+// PrometheusRule is the prometheus-operator resource carrying alerting rules.
+type PrometheusRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PrometheusRuleSpec `json:"spec"`
+}
+
+type PrometheusRuleSpec struct {
+	Groups []RuleGroup `json:"groups"`
+}
+
+type RuleGroup struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+type Rule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// NewAlertRules returns alerts on the self-metrics of a metrics-server running with the
+// options. Thresholds follow the metric resolution, and alerts on features which are
+// disabled are left out.
+func NewAlertRules(opt *options.HeapsterRunOptions) *PrometheusRule {
+	resolution := opt.MetricResolution
+	stalled := stalledResolutions * resolution
+	rules := []Rule{
+		{
+			Alert:  "MetricsServerScrapesStalled",
+			Expr:   fmt.Sprintf(`time() - max(heapster_scraper_last_time_seconds{job=%q}) > %s`, AlertsJob, seconds(stalled)),
+			For:    promDuration(resolution),
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("metrics-server didn't scrape the nodes for more than %s, the Metrics API serves stale usage.", stalled),
+			},
+		},
+		{
+			Alert:  "MetricsServerExportsStalled",
+			Expr:   fmt.Sprintf(`time() - max(heapster_exporter_last_time_seconds{job=%q}) > %s`, AlertsJob, seconds(stalled)),
+			For:    promDuration(resolution),
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("metrics-server didn't store scraped metrics for more than %s.", stalled),
+			},
+		},
+		{
+			Alert: "MetricsServerScrapesSlow",
+			Expr: fmt.Sprintf(`max(heapster_scraper_duration_microseconds{job=%q,quantile="0.99"}) > %d`,
+				AlertsJob, int64(resolution/2/time.Microsecond)),
+			For:    promDuration(5 * resolution),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("Scraping nodes takes more than half the metric resolution of %s.", resolution),
+			},
+		},
+		{
+			Alert: "MetricsServerSamplesOld",
+			Expr: fmt.Sprintf(`histogram_quantile(0.9, sum(rate(heapster_kubelet_summary_sample_age_seconds_bucket{job=%q}[5m])) by (le)) > %s`,
+				AlertsJob, seconds(resolution)),
+			For:    promDuration(5 * resolution),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("Kubelet stats are older than the metric resolution of %s when scraped.", resolution),
+			},
+		},
+		{
+			Alert:  "MetricsServerDroppingEvents",
+			Expr:   fmt.Sprintf(`increase(heapster_bus_dropped_events_total{job=%q}[10m]) > 0`, AlertsJob),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary": "Subscribers of scraped batches can't keep up, e.g. status publishing or snapshot writing.",
+			},
+		},
+	}
+	if opt.StoreMemoryBudget >= 0 {
+		rules = append(rules, Rule{
+			Alert:  "MetricsServerStoreOverBudget",
+			Expr:   fmt.Sprintf(`increase(heapster_metric_sink_long_store_budget_evictions_total{job=%q}[1h]) > 0`, AlertsJob),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary": "The long-term metric store evicts metrics to stay within its memory budget.",
+			},
+		})
+	}
+	if opt.APIServiceService != "" {
+		rules = append(rules, Rule{
+			Alert:  "MetricsServerAPIServiceStale",
+			Expr:   fmt.Sprintf(`max(heapster_operator_apiservice_stale{job=%q}) == 1`, AlertsJob),
+			For:    promDuration(2 * DefaultAPIServiceCheckInterval),
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("The Metrics API APIService doesn't point at %s.", opt.APIServiceService),
+			},
+		})
+	}
+	if hasNodePools(opt) {
+		rules = append(rules, Rule{
+			Alert:  "MetricsServerNodePoolSaturated",
+			Expr:   fmt.Sprintf(`increase(heapster_kubelet_summary_pool_wait_timeouts_total{job=%q}[15m]) > 0`, AlertsJob),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary": "Nodes of {{ $labels.pool }} are not scraped because the pool concurrency limit is reached.",
+			},
+		})
+	}
+
+	return &PrometheusRule{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "monitoring.coreos.com/v1", Kind: "PrometheusRule"},
+		ObjectMeta: metav1.ObjectMeta{Name: alertRulesName},
+		Spec: PrometheusRuleSpec{Groups: []RuleGroup{
+			{Name: alertRulesName, Rules: rules},
+		}},
+	}
+}
+
+// WriteAlertRules writes the alerts for the options as a PrometheusRule in YAML.
+func WriteAlertRules(w io.Writer, opt *options.HeapsterRunOptions) error {
+	data, err := yaml.Marshal(NewAlertRules(opt))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func hasNodePools(opt *options.HeapsterRunOptions) bool {
+	for _, uri := range opt.Sources {
+		if uri.Val.Query().Get("nodePoolLabel") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// seconds formats the duration as a number of seconds for comparisons in expressions.
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%g", d.Seconds())
+}
+
+// promDuration formats the duration in the Prometheus duration syntax.
+func promDuration(d time.Duration) string {
+	if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+)
+
+func alertNames(rules *PrometheusRule) []string {
+	names := []string{}
+	for _, rule := range rules.Spec.Groups[0].Rules {
+		names = append(names, rule.Alert)
+	}
+	return names
+}
+
+func TestNewAlertRules(t *testing.T) {
+	opt := options.NewHeapsterRunOptions()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	opt.AddFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--metric_resolution=30s", "--store_memory_budget=-1"}))
+
+	rules := NewAlertRules(opt)
+	assert.Equal(t, []string{
+		"MetricsServerScrapesStalled",
+		"MetricsServerExportsStalled",
+		"MetricsServerScrapesSlow",
+		"MetricsServerSamplesOld",
+		"MetricsServerDroppingEvents",
+	}, alertNames(rules), "alerts of disabled features are left out")
+	stalled := rules.Spec.Groups[0].Rules[0]
+	assert.Equal(t, `time() - max(heapster_scraper_last_time_seconds{job="metrics-server"}) > 90`, stalled.Expr)
+	assert.Equal(t, "30s", stalled.For)
+	assert.Equal(t, `max(heapster_scraper_duration_microseconds{job="metrics-server",quantile="0.99"}) > 15000000`, rules.Spec.Groups[0].Rules[2].Expr)
+
+	require.NoError(t, fs.Parse([]string{
+		"--store_memory_budget=0",
+		"--apiservice_service=kube-system/metrics-server",
+		"--source=kubernetes.summary_api:?nodePoolLabel=pool&nodePoolConcurrency=5",
+	}))
+	rules = NewAlertRules(opt)
+	names := alertNames(rules)
+	assert.Contains(t, names, "MetricsServerStoreOverBudget")
+	assert.Contains(t, names, "MetricsServerAPIServiceStale")
+	assert.Contains(t, names, "MetricsServerNodePoolSaturated")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteAlertRules(buf, opt))
+	assert.Contains(t, buf.String(), "kind: PrometheusRule")
+	assert.Contains(t, buf.String(), "for: 2m")
+}
+
+func TestPromDuration(t *testing.T) {
+	assert.Equal(t, "3m", promDuration(3*time.Minute))
+	assert.Equal(t, "90s", promDuration(90*time.Second))
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	APIServiceGroup   = "apiregistration.k8s.io"
+	APIServiceVersion = "v1beta1"
+	APIServiceKind    = "APIService"
+	// APIService registering the Metrics API served by metrics-server.
+	APIServiceName = "v1beta1.metrics.k8s.io"
+
+	// Default interval at which the APIService is checked.
+	DefaultAPIServiceCheckInterval = time.Minute
+
+	// Reasons of the events recorded for the APIService.
+	staleAPIServiceReason      = "StaleAPIService"
+	reconciledAPIServiceReason = "APIServiceReconciled"
+)
+
+var (
+	apiServiceStale = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "operator",
+			Name:      "apiservice_stale",
+			Help:      "1 if the Metrics API APIService pointed at another service or CA bundle at the latest check, 0 otherwise.",
+		},
+	)
+	apiServiceReconciles = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "operator",
+			Name:      "apiservice_reconciles_total",
+			Help:      "Number of updates of the stale Metrics API APIService, by result.",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(apiServiceStale)
+	prometheus.MustRegister(apiServiceReconciles)
+}
+
+// APIServiceSpec holds the fields of the APIService spec which are checked.
+type APIServiceSpec struct {
+	Service *struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"service"`
+	CABundle              []byte `json:"caBundle,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+// APIServiceChecker periodically checks that the Metrics API APIService points at the
+// service of metrics-server with the expected CA bundle, for when metrics-server manages
+// its own registration. Problems are logged, counted and recorded as events, and fixed if
+// reconcile is set.
+type APIServiceChecker struct {
+	client           rest.Interface
+	serviceNamespace string
+	serviceName      string
+	// Expected CA bundle, not checked if empty.
+	caBundle  []byte
+	reconcile bool
+	// Problems found at the previous check, only recorded as an event when they change.
+	lastMessage string
+}
+
+func NewAPIServiceChecker(client rest.Interface, serviceNamespace, serviceName string, caBundle []byte, reconcile bool) *APIServiceChecker {
+	return &APIServiceChecker{
+		client:           client,
+		serviceNamespace: serviceNamespace,
+		serviceName:      serviceName,
+		caBundle:         caBundle,
+		reconcile:        reconcile,
+	}
+}
+
+// Run checks the APIService every interval until the channel is closed.
+func (this *APIServiceChecker) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := this.check(); err != nil {
+			glog.Errorf("Failed to check %s %s: %v", APIServiceKind, APIServiceName, err)
+		}
+	}, interval, stopCh)
+}
+
+// CheckAPIServiceSpec returns the differences of the spec from the expected service and,
+// if not empty, CA bundle.
+func CheckAPIServiceSpec(spec *APIServiceSpec, serviceNamespace, serviceName string, caBundle []byte) []string {
+	problems := []string{}
+	if spec.Service == nil {
+		problems = append(problems, "no service set")
+	} else if spec.Service.Namespace != serviceNamespace || spec.Service.Name != serviceName {
+		problems = append(problems, fmt.Sprintf("service is %s/%s instead of %s/%s",
+			spec.Service.Namespace, spec.Service.Name, serviceNamespace, serviceName))
+	}
+	if len(caBundle) > 0 {
+		if spec.InsecureSkipTLSVerify {
+			problems = append(problems, "insecureSkipTLSVerify is set instead of the CA bundle")
+		} else if !bytes.Equal(bytes.TrimSpace(spec.CABundle), bytes.TrimSpace(caBundle)) {
+			problems = append(problems, "CA bundle does not match the serving CA")
+		}
+	}
+	return problems
+}
+
+func (this *APIServiceChecker) check() error {
+	path := []string{"/apis", APIServiceGroup, APIServiceVersion, "apiservices", APIServiceName}
+	body, err := this.client.Get().AbsPath(path...).DoRaw()
+	if err != nil {
+		return err
+	}
+	// The object is updated as a map, so that fields unknown here are preserved.
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return err
+	}
+	var typed struct {
+		Spec APIServiceSpec `json:"spec"`
+	}
+	if err := json.Unmarshal(body, &typed); err != nil {
+		return err
+	}
+
+	problems := CheckAPIServiceSpec(&typed.Spec, this.serviceNamespace, this.serviceName, this.caBundle)
+	if len(problems) == 0 {
+		apiServiceStale.Set(0)
+		this.lastMessage = ""
+		return nil
+	}
+	apiServiceStale.Set(1)
+	message := fmt.Sprintf("%s %s is stale: %s", APIServiceKind, APIServiceName, strings.Join(problems, "; "))
+	glog.Warning(message)
+	if message != this.lastMessage {
+		this.recordEvent(corev1.EventTypeWarning, staleAPIServiceReason, message)
+		this.lastMessage = message
+	}
+	if !this.reconcile {
+		return nil
+	}
+
+	spec, _ := obj["spec"].(map[string]interface{})
+	if spec == nil {
+		spec = map[string]interface{}{}
+		obj["spec"] = spec
+	}
+	spec["service"] = map[string]interface{}{"namespace": this.serviceNamespace, "name": this.serviceName}
+	if len(this.caBundle) > 0 {
+		// Marshaled as base64, like the API server does.
+		spec["caBundle"] = this.caBundle
+		delete(spec, "insecureSkipTLSVerify")
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if _, err := this.client.Put().AbsPath(path...).Body(data).DoRaw(); err != nil {
+		apiServiceReconciles.WithLabelValues("error").Inc()
+		return err
+	}
+	apiServiceReconciles.WithLabelValues("success").Inc()
+	this.lastMessage = ""
+	glog.Infof("Updated %s %s", APIServiceKind, APIServiceName)
+	this.recordEvent(corev1.EventTypeNormal, reconciledAPIServiceReason,
+		fmt.Sprintf("Pointed %s %s at service %s/%s", APIServiceKind, APIServiceName, this.serviceNamespace, this.serviceName))
+	return nil
+}
+
+// recordEvent records an event about the APIService in the namespace of the service.
+// Failures are only logged.
+func (this *APIServiceChecker) recordEvent(eventType, reason, message string) {
+	involved := corev1.ObjectReference{
+		APIVersion: APIServiceGroup + "/" + APIServiceVersion,
+		Kind:       APIServiceKind,
+		Name:       APIServiceName,
+	}
+	if err := recordEvent(this.client, this.serviceNamespace, involved, eventType, reason, message); err != nil {
+		glog.Errorf("Failed to record event for %s %s: %v", APIServiceKind, APIServiceName, err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestCheckAPIServiceSpec(t *testing.T) {
+	spec := &APIServiceSpec{}
+	assert.Equal(t, []string{"no service set"}, CheckAPIServiceSpec(spec, "kube-system", "metrics-server", nil))
+
+	require.NoError(t, json.Unmarshal([]byte(`{"service":{"namespace":"kube-system","name":"metrics-server"},"caBundle":"Y2E="}`), spec))
+	assert.Empty(t, CheckAPIServiceSpec(spec, "kube-system", "metrics-server", nil))
+	assert.Empty(t, CheckAPIServiceSpec(spec, "kube-system", "metrics-server", []byte("ca\n")))
+	assert.Equal(t, []string{"service is kube-system/metrics-server instead of monitoring/metrics-server"},
+		CheckAPIServiceSpec(spec, "monitoring", "metrics-server", nil))
+	assert.Equal(t, []string{"CA bundle does not match the serving CA"},
+		CheckAPIServiceSpec(spec, "kube-system", "metrics-server", []byte("other")))
+
+	spec.InsecureSkipTLSVerify = true
+	assert.Equal(t, []string{"insecureSkipTLSVerify is set instead of the CA bundle"},
+		CheckAPIServiceSpec(spec, "kube-system", "metrics-server", []byte("ca")))
+}
+
+func TestAPIServiceCheckerReconcile(t *testing.T) {
+	var lock sync.Mutex
+	apiService := `{"apiVersion":"apiregistration.k8s.io/v1beta1","kind":"APIService","metadata":{"name":"v1beta1.metrics.k8s.io","resourceVersion":"1"},` +
+		`"spec":{"service":{"namespace":"kube-system","name":"old"},"insecureSkipTLSVerify":true,"groupPriorityMinimum":100}}`
+	events := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch {
+		case req.URL.Path == "/apis/apiregistration.k8s.io/v1beta1/apiservices/v1beta1.metrics.k8s.io" && req.Method == http.MethodGet:
+			w.Write([]byte(apiService))
+		case req.URL.Path == "/apis/apiregistration.k8s.io/v1beta1/apiservices/v1beta1.metrics.k8s.io" && req.Method == http.MethodPut:
+			body, _ := ioutil.ReadAll(req.Body)
+			apiService = string(body)
+			w.Write(body)
+		case req.URL.Path == "/api/v1/namespaces/kube-system/events" && req.Method == http.MethodPost:
+			events++
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+	client := kube_client.NewForConfigOrDie(&rest.Config{Host: server.URL}).Discovery().RESTClient()
+
+	checker := NewAPIServiceChecker(client, "kube-system", "metrics-server", []byte("ca"), false)
+	require.NoError(t, checker.check())
+	require.NoError(t, checker.check())
+	assert.Equal(t, 1, events, "unchanged problems are recorded once")
+
+	checker.reconcile = true
+	require.NoError(t, checker.check())
+	assert.Equal(t, 2, events)
+
+	var updated struct {
+		Spec APIServiceSpec `json:"spec"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(apiService), &updated))
+	assert.Empty(t, CheckAPIServiceSpec(&updated.Spec, "kube-system", "metrics-server", []byte("ca")))
+	assert.Contains(t, apiService, `"groupPriorityMinimum":100`)
+	assert.Contains(t, apiService, `"resourceVersion":"1"`)
+}
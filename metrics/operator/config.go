@@ -0,0 +1,126 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package operator allows metrics-server settings to be managed through a
+// MetricsServerConfig custom resource instead of command line flags.
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	Group    = "config.metrics-server.k8s.io"
+	Version  = "v1alpha1"
+	Kind     = "MetricsServerConfig"
+	Resource = "metricsserverconfigs"
+)
+
+// Flags which only make sense on the command line.
+var disallowedFlags = map[string]bool{
+	"config_resource": true,
+	"version":         true,
+}
+
+type MetricsServerConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MetricsServerConfigSpec `json:"spec"`
+}
+
+type MetricsServerConfigSpec struct {
+	// Flag values keyed by the flag name, e.g. "metric_resolution": "30s".
+	Flags map[string]string `json:"flags,omitempty"`
+}
+
+// ParseResourceName splits a namespace/name resource reference.
+func ParseResourceName(resource string) (string, string, error) {
+	parts := strings.Split(resource, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid resource reference %q, expected namespace/name", resource)
+	}
+	return parts[0], parts[1], nil
+}
+
+// GetConfig fetches the MetricsServerConfig from the API server.
+func GetConfig(client rest.Interface, namespace, name string) (*MetricsServerConfig, error) {
+	body, err := client.Get().
+		AbsPath("/apis", Group, Version, "namespaces", namespace, Resource, name).
+		DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get %s %s/%s: %v", Kind, namespace, name, err)
+	}
+	config := &MetricsServerConfig{}
+	if err := json.Unmarshal(body, config); err != nil {
+		return nil, fmt.Errorf("unable to decode %s %s/%s: %v", Kind, namespace, name, err)
+	}
+	return config, nil
+}
+
+// ValidateConfig checks that all flags in the config exist and that the resulting
+// options are valid, the same way they are checked when passed on the command line.
+func ValidateConfig(config *MetricsServerConfig) error {
+	opt := options.NewHeapsterRunOptions()
+	fs := pflag.NewFlagSet(Kind, pflag.ContinueOnError)
+	opt.AddFlags(fs)
+	if err := setFlags(fs, config.Spec.Flags, false); err != nil {
+		return err
+	}
+	return opt.Validate()
+}
+
+// ApplyConfig sets the flags from the config on the flag set. Flags which were already
+// set on the command line are left unchanged.
+func ApplyConfig(config *MetricsServerConfig, fs *pflag.FlagSet) error {
+	if err := ValidateConfig(config); err != nil {
+		return err
+	}
+	return setFlags(fs, config.Spec.Flags, true)
+}
+
+func setFlags(fs *pflag.FlagSet, values map[string]string, skipChanged bool) error {
+	// Sort for deterministic errors and ordering of repeated flags.
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if disallowedFlags[name] {
+			return fmt.Errorf("flag %q cannot be set in %s", name, Kind)
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown flag %q", name)
+		}
+		if skipChanged && f.Changed {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid value %q for flag %q: %v", values[name], name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+)
+
+func newConfig(flags map[string]string) *MetricsServerConfig {
+	return &MetricsServerConfig{Spec: MetricsServerConfigSpec{Flags: flags}}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags map[string]string
+		valid bool
+	}{
+		{name: "empty", flags: nil, valid: true},
+		{name: "valid", flags: map[string]string{"metric_resolution": "30s", "address_family": "ipv6"}, valid: true},
+		{name: "unknown flag", flags: map[string]string{"no_such_flag": "1"}, valid: false},
+		{name: "unparsable value", flags: map[string]string{"metric_resolution": "often"}, valid: false},
+		{name: "invalid value", flags: map[string]string{"metric_resolution": "1s"}, valid: false},
+		{name: "disallowed flag", flags: map[string]string{"config_resource": "a/b"}, valid: false},
+	}
+	for _, test := range tests {
+		err := ValidateConfig(newConfig(test.flags))
+		assert.Equal(t, test.valid, err == nil, "%s: %v", test.name, err)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	opt := options.NewHeapsterRunOptions()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	opt.AddFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--label_seperator=;"}))
+
+	err := ApplyConfig(newConfig(map[string]string{
+		"metric_resolution": "30s",
+		"label_seperator":   "|",
+	}), fs)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, opt.MetricResolution)
+	assert.Equal(t, ";", opt.LabelSeperator, "command line flags should take precedence")
+}
+
+func TestParseResourceName(t *testing.T) {
+	namespace, name, err := ParseResourceName("kube-system/metrics-server")
+	require.NoError(t, err)
+	assert.Equal(t, "kube-system", namespace)
+	assert.Equal(t, "metrics-server", name)
+
+	for _, invalid := range []string{"", "metrics-server", "/name", "a/b/c"} {
+		_, _, err := ParseResourceName(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// Number of consecutive batches a state must last before it's reported.
+const DegradedBatches = 3
+
+// Reasons of the events recorded on the metrics-server pod.
+const (
+	ScrapeIncompleteReason     = "ScrapeIncomplete"
+	ScrapeCompleteReason       = "ScrapeComplete"
+	StoreUpdateSlowReason      = "StoreUpdateSlow"
+	StoreUpdateRecoveredReason = "StoreUpdateRecovered"
+)
+
+// DegradationReporter records warning events on the metrics-server pod when scrapes stay
+// incomplete or batches keep reaching the store late, and normal events on recovery, so
+// the problems show up in kubectl get events.
+type DegradationReporter struct {
+	client     rest.Interface
+	namespace  string
+	name       string
+	nodeLister v1listers.NodeLister
+	// Share of the ready nodes which must be scraped.
+	completenessThreshold float64
+	// Longest time between the end of a scrape window and its batch reaching the store.
+	maxStoreDelay time.Duration
+
+	incomplete degradedState
+	slowStore  degradedState
+}
+
+// degradedState tracks for how many consecutive batches a condition held.
+type degradedState struct {
+	count    int
+	reported bool
+}
+
+// update returns whether the state started to be reported or recovered.
+func (this *degradedState) update(degraded bool) (started, recovered bool) {
+	if !degraded {
+		this.count = 0
+		recovered = this.reported
+		this.reported = false
+		return false, recovered
+	}
+	this.count++
+	if this.count >= DegradedBatches && !this.reported {
+		this.reported = true
+		return true, false
+	}
+	return false, false
+}
+
+func NewDegradationReporter(client rest.Interface, namespace, name string, nodeLister v1listers.NodeLister,
+	completenessThreshold float64, maxStoreDelay time.Duration) *DegradationReporter {
+	return &DegradationReporter{
+		client:                client,
+		namespace:             namespace,
+		name:                  name,
+		nodeLister:            nodeLister,
+		completenessThreshold: completenessThreshold,
+		maxStoreDelay:         maxStoreDelay,
+	}
+}
+
+// Subscribe registers the reporter for processed batches on the bus.
+func (this *DegradationReporter) Subscribe(eventBus *bus.Bus) {
+	eventBus.Subscribe("degradation_reporter", bus.TopicDataBatch, this.handle)
+}
+
+func (this *DegradationReporter) handle(event *bus.Event) {
+	this.check(event, time.Now())
+}
+
+func (this *DegradationReporter) check(event *bus.Event, now time.Time) {
+	nodes, err := this.nodeLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Error while listing nodes: %v", err)
+		return
+	}
+	status := ComputeScrapeStatus(event.Batch, nodes)
+	completeness := 1.0
+	if status.NodesTotal > 0 {
+		completeness = float64(status.NodesScraped) / float64(status.NodesTotal)
+	}
+	started, recovered := this.incomplete.update(completeness < this.completenessThreshold)
+	if started {
+		this.recordEvent(corev1.EventTypeWarning, ScrapeIncompleteReason, fmt.Sprintf(
+			"Only %d of %d ready nodes scraped in the last %d batches, below the threshold of %.0f%%",
+			status.NodesScraped, status.NodesTotal, DegradedBatches, this.completenessThreshold*100))
+	} else if recovered {
+		this.recordEvent(corev1.EventTypeNormal, ScrapeCompleteReason, fmt.Sprintf(
+			"%d of %d ready nodes scraped", status.NodesScraped, status.NodesTotal))
+	}
+
+	delay := now.Sub(event.Batch.Timestamp)
+	started, recovered = this.slowStore.update(delay > this.maxStoreDelay)
+	if started {
+		this.recordEvent(corev1.EventTypeWarning, StoreUpdateSlowReason, fmt.Sprintf(
+			"Batches reached the store more than %s after their scrape window in the last %d batches, latest after %s",
+			this.maxStoreDelay, DegradedBatches, delay))
+	} else if recovered {
+		this.recordEvent(corev1.EventTypeNormal, StoreUpdateRecoveredReason, fmt.Sprintf(
+			"Batch reached the store %s after its scrape window", delay))
+	}
+}
+
+// recordEvent records an event about the pod. Failures are only logged.
+func (this *DegradationReporter) recordEvent(eventType, reason, message string) {
+	involved := corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  this.namespace,
+		Name:       this.name,
+	}
+	if err := recordEvent(this.client, this.namespace, involved, eventType, reason, message); err != nil {
+		glog.Errorf("Failed to record event for pod %s/%s: %v", this.namespace, this.name, err)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	corev1 "k8s.io/api/core/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDegradationReporter(t *testing.T) {
+	events := []corev1.Event{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/namespaces/kube-system/events" || req.Method != http.MethodPost {
+			http.NotFound(w, req)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		event := corev1.Event{}
+		require.NoError(t, json.Unmarshal(body, &event))
+		events = append(events, event)
+		w.Write(body)
+	}))
+	defer server.Close()
+	client := kube_client.NewForConfigOrDie(&rest.Config{Host: server.URL}).Discovery().RESTClient()
+
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range []string{"n1", "n2"} {
+		require.NoError(t, nodes.Add(newNode(name, corev1.ConditionTrue)))
+	}
+	reporter := NewDegradationReporter(client, "kube-system", "metrics-server-abc", v1listers.NewNodeLister(nodes), 0.9, 30*time.Second)
+
+	now := time.Now()
+	incomplete := &bus.Event{Batch: &core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{
+		core.NodeKey("n1"): {},
+	}}}
+	complete := &bus.Event{Batch: &core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{
+		core.NodeKey("n1"): {},
+		core.NodeKey("n2"): {},
+	}}}
+
+	for i := 0; i < DegradedBatches-1; i++ {
+		reporter.check(incomplete, now)
+	}
+	assert.Empty(t, events, "not sustained yet")
+	reporter.check(incomplete, now)
+	reporter.check(incomplete, now)
+	require.Len(t, events, 1, "reported once")
+	assert.Equal(t, corev1.EventTypeWarning, events[0].Type)
+	assert.Equal(t, ScrapeIncompleteReason, events[0].Reason)
+	assert.Equal(t, corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "kube-system", Name: "metrics-server-abc"}, events[0].InvolvedObject)
+
+	reporter.check(complete, now)
+	require.Len(t, events, 2)
+	assert.Equal(t, corev1.EventTypeNormal, events[1].Type)
+	assert.Equal(t, ScrapeCompleteReason, events[1].Reason)
+
+	for i := 0; i < DegradedBatches; i++ {
+		reporter.check(complete, now.Add(time.Minute))
+	}
+	require.Len(t, events, 3)
+	assert.Equal(t, StoreUpdateSlowReason, events[2].Reason)
+	reporter.check(complete, now)
+	require.Len(t, events, 4)
+	assert.Equal(t, StoreUpdateRecoveredReason, events[3].Reason)
+}
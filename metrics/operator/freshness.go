@@ -0,0 +1,145 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/server/healthz"
+	v1listers "k8s.io/client-go/listers/core/v1"
+)
+
+// Name of the freshness check.
+const FreshnessCheckName = "metric-storage-fresh"
+
+// Path of the readiness checks. They're kept off /healthz, which liveness probes use, so
+// that instances serving stale metrics are taken out of rotation rather than restarted.
+const ReadinessPath = "/readyz"
+
+// freshnessCheck remembers whether it failed the last time it ran, so that only changes
+// are logged rather than every probe.
+type freshnessCheck struct {
+	lock    sync.Mutex
+	failing bool
+}
+
+// NewFreshnessCheck returns a readiness check which fails while less than the threshold
+// share of the ready nodes of the shard have metrics scraped within maxAge in the latest
+// batch, so that load balancers stop sending requests to instances serving stale metrics.
+func NewFreshnessCheck(getLatestBatch func() *core.DataBatch, nodeLister v1listers.NodeLister, shard util.Shard,
+	threshold float64, maxAge time.Duration) healthz.HealthzChecker {
+	check := &freshnessCheck{}
+	return healthz.NamedCheck(FreshnessCheckName, func(r *http.Request) error {
+		err := checkFreshness(getLatestBatch(), nodeLister, shard, threshold, maxAge, time.Now())
+		check.record(err)
+		return err
+	})
+}
+
+func (this *freshnessCheck) record(err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if failing := err != nil; failing != this.failing {
+		if failing {
+			glog.Warningf("Not ready: %v", err)
+		} else {
+			glog.Infof("Ready again, the metrics are fresh")
+		}
+		this.failing = failing
+	}
+}
+
+func checkFreshness(batch *core.DataBatch, nodeLister v1listers.NodeLister, shard util.Shard, threshold float64, maxAge time.Duration,
+	now time.Time) error {
+	fresh, total, err := countFreshNodes(batch, nodeLister, shard, maxAge, now)
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return nil
+	}
+	if share := float64(fresh) / float64(total); share < threshold {
+		return fmt.Errorf("only %d of %d ready nodes have metrics newer than %s, expected %.0f%%", fresh, total, maxAge, threshold*100)
+	}
+	return nil
+}
+
+// NewReadinessHandler returns a handler serving the checks like /healthz does, but on its
+// own path. Without checks it always passes.
+func NewReadinessHandler(checks ...healthz.HealthzChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		failed := false
+		var out bytes.Buffer
+		for _, check := range checks {
+			if err := check.Check(req); err != nil {
+				fmt.Fprintf(&out, "[-]%v failed: %v\n", check.Name(), err)
+				failed = true
+			} else {
+				fmt.Fprintf(&out, "[+]%v ok\n", check.Name())
+			}
+		}
+		if failed {
+			http.Error(w, out.String()+"readiness check failed", http.StatusServiceUnavailable)
+			return
+		}
+		if _, found := req.URL.Query()["verbose"]; !found {
+			fmt.Fprint(w, "ok")
+			return
+		}
+		out.WriteTo(w)
+		fmt.Fprint(w, "readiness check passed\n")
+	})
+}
+
+// countFreshNodes returns how many of the ready nodes of the shard have metrics scraped
+// within maxAge in the batch, and the number of ready nodes of the shard.
+func countFreshNodes(batch *core.DataBatch, nodeLister v1listers.NodeLister, shard util.Shard, maxAge time.Duration,
+	now time.Time) (int, int, error) {
+	nodes, err := nodeLister.List(labels.Everything())
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not list nodes: %v", err)
+	}
+	total, fresh := 0, 0
+	for _, node := range nodes {
+		if !isNodeReady(node) || util.IsNodeSkipped(node) || !shard.Owns(node.Name) {
+			continue
+		}
+		total++
+		if batch == nil {
+			continue
+		}
+		ms, found := batch.MetricSets[core.NodeKey(node.Name)]
+		if !found {
+			continue
+		}
+		scrapeTime := ms.ScrapeTime
+		if scrapeTime.IsZero() {
+			scrapeTime = batch.Timestamp
+		}
+		if now.Sub(scrapeTime) <= maxAge {
+			fresh++
+		}
+	}
+	return fresh, total, nil
+}
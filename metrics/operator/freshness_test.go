@@ -0,0 +1,127 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apiserver/pkg/server/healthz"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCheckFreshness(t *testing.T) {
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nodeLister := v1listers.NewNodeLister(nodes)
+	now := time.Now()
+
+	assert.NoError(t, checkFreshness(nil, nodeLister, util.Shard{}, 0.8, time.Minute, now), "no ready nodes")
+
+	for _, name := range []string{"n1", "n2", "n3", "n4", "n5"} {
+		require.NoError(t, nodes.Add(newNode(name, corev1.ConditionTrue)))
+	}
+	require.NoError(t, nodes.Add(newNode("not-ready", corev1.ConditionFalse)))
+	skipped := newNode("skipped", corev1.ConditionTrue)
+	skipped.Annotations = map[string]string{util.SkipNodeAnnotation: "true"}
+	require.NoError(t, nodes.Add(skipped))
+	assert.Error(t, checkFreshness(nil, nodeLister, util.Shard{}, 0.8, time.Minute, now), "no batch yet")
+
+	batch := &core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{
+		core.NodeKey("n1"): {ScrapeTime: now.Add(-10 * time.Second)},
+		core.NodeKey("n2"): {ScrapeTime: now.Add(-20 * time.Second)},
+		core.NodeKey("n3"): {ScrapeTime: now.Add(-30 * time.Second)},
+		core.NodeKey("n4"): {ScrapeTime: now.Add(-5 * time.Minute)},
+	}}
+	err := checkFreshness(batch, nodeLister, util.Shard{}, 0.8, time.Minute, now)
+	require.Error(t, err, "3 of 5 fresh")
+	assert.Contains(t, err.Error(), "only 3 of 5 ready nodes")
+	assert.NoError(t, checkFreshness(batch, nodeLister, util.Shard{}, 0.6, time.Minute, now))
+
+	// Nodes without a scrape time are as old as the batch.
+	batch.MetricSets[core.NodeKey("n5")] = &core.MetricSet{}
+	assert.NoError(t, checkFreshness(batch, nodeLister, util.Shard{}, 0.8, time.Minute, now))
+	assert.Error(t, checkFreshness(batch, nodeLister, util.Shard{}, 0.8, time.Minute, now.Add(2*time.Minute)), "batch got stale")
+}
+
+func TestCheckFreshnessSharded(t *testing.T) {
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nodeLister := v1listers.NewNodeLister(nodes)
+	now := time.Now()
+	shard := util.Shard{Index: 1, Count: 2}
+	batch := &core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{}}
+	names := []string{"n1", "n2", "n3", "n4", "n5", "n6"}
+	for _, name := range names {
+		require.NoError(t, nodes.Add(newNode(name, corev1.ConditionTrue)))
+		// The shard only scrapes its own nodes.
+		if shard.Owns(name) {
+			batch.MetricSets[core.NodeKey(name)] = &core.MetricSet{ScrapeTime: now}
+		}
+	}
+	require.True(t, len(batch.MetricSets) > 0 && len(batch.MetricSets) < len(names), "nodes in both shards")
+
+	assert.NoError(t, checkFreshness(batch, nodeLister, shard, 1, time.Minute, now), "nodes of other shards are not expected")
+	assert.Error(t, checkFreshness(batch, nodeLister, util.Shard{}, 1, time.Minute, now))
+}
+
+func TestFreshnessCheckRecordsTransitions(t *testing.T) {
+	check := &freshnessCheck{}
+	check.record(nil)
+	assert.False(t, check.failing)
+	check.record(errors.New("stale"))
+	assert.True(t, check.failing)
+	check.record(errors.New("stale"))
+	assert.True(t, check.failing, "still failing")
+	check.record(nil)
+	assert.False(t, check.failing)
+}
+
+func TestReadinessHandler(t *testing.T) {
+	fail := false
+	handler := NewReadinessHandler(healthz.NamedCheck("test", func(r *http.Request) error {
+		if fail {
+			return errors.New("stale")
+		}
+		return nil
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ReadinessPath+"?verbose", nil))
+	assert.Contains(t, rec.Body.String(), "[+]test ok")
+
+	fail = true
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "[-]test failed: stale")
+
+	rec = httptest.NewRecorder()
+	NewReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "ready without checks")
+}
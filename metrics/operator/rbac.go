@@ -0,0 +1,113 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+// Permission is an action metrics-server takes on the API server.
+type Permission struct {
+	Group       string
+	Resource    string
+	Subresource string
+	Verb        string
+}
+
+func (this Permission) String() string {
+	resource := this.Resource
+	if this.Subresource != "" {
+		resource += "/" + this.Subresource
+	}
+	if this.Group != "" {
+		resource += "." + this.Group
+	}
+	return fmt.Sprintf("%s %s", this.Verb, resource)
+}
+
+// Permissions needed to scrape the nodes and attribute the metrics of their pods.
+var RequiredPermissions = []Permission{
+	{Resource: "nodes", Verb: "list"},
+	{Resource: "nodes", Verb: "watch"},
+	{Resource: "nodes", Subresource: "stats", Verb: "get"},
+	{Resource: "pods", Verb: "list"},
+	{Resource: "pods", Verb: "watch"},
+	{Resource: "namespaces", Verb: "list"},
+	{Resource: "namespaces", Verb: "watch"},
+	{Group: "apps", Resource: "replicasets", Verb: "list"},
+	{Group: "apps", Resource: "replicasets", Verb: "watch"},
+}
+
+var nodeMetricsOnly = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "heapster",
+		Subsystem: "operator",
+		Name:      "node_metrics_only",
+		Help:      "1 if only NodeMetrics are served because pods can't be listed, 0 otherwise.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(nodeMetricsOnly)
+}
+
+// MissingPermissions returns the permissions which aren't granted to the client, checked
+// cluster-wide with SelfSubjectAccessReviews.
+func MissingPermissions(client authorizationclient.SelfSubjectAccessReviewInterface, permissions []Permission) ([]Permission, error) {
+	missing := []Permission{}
+	for _, permission := range permissions {
+		review, err := client.Create(&authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:       permission.Group,
+					Resource:    permission.Resource,
+					Subresource: permission.Subresource,
+					Verb:        permission.Verb,
+				},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to check permission to %s: %v", permission, err)
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, permission)
+		}
+	}
+	return missing, nil
+}
+
+// CanListPods checks that none of the missing permissions is needed to list and watch pods.
+func CanListPods(missing []Permission) bool {
+	for _, permission := range missing {
+		if permission.Group == "" && permission.Resource == "pods" && permission.Subresource == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// SetNodeMetricsOnly reports whether only NodeMetrics are served.
+func SetNodeMetricsOnly(enabled bool) {
+	if enabled {
+		nodeMetricsOnly.Set(1)
+	} else {
+		nodeMetricsOnly.Set(0)
+	}
+}
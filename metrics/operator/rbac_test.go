@@ -0,0 +1,54 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestMissingPermissions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews" || req.Method != http.MethodPost {
+			http.NotFound(w, req)
+			return
+		}
+		review := &authorizationv1.SelfSubjectAccessReview{}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(review))
+		review.Status.Allowed = review.Spec.ResourceAttributes.Resource != "pods"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(review)
+	}))
+	defer server.Close()
+	client := kube_client.NewForConfigOrDie(&rest.Config{Host: server.URL}).AuthorizationV1().SelfSubjectAccessReviews()
+
+	missing, err := MissingPermissions(client, RequiredPermissions)
+	require.NoError(t, err)
+	assert.Equal(t, []Permission{{Resource: "pods", Verb: "list"}, {Resource: "pods", Verb: "watch"}}, missing)
+	assert.False(t, CanListPods(missing))
+	assert.True(t, CanListPods([]Permission{{Resource: "nodes", Subresource: "stats", Verb: "get"}}))
+
+	assert.Equal(t, "get nodes/stats", Permission{Resource: "nodes", Subresource: "stats", Verb: "get"}.String())
+	assert.Equal(t, "list replicasets.apps", Permission{Group: "apps", Resource: "replicasets", Verb: "list"}.String())
+}
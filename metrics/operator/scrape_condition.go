@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/summary"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// Condition of the APIService explaining why nodes aren't scraped. The Available
+	// condition maintained by the aggregator is left alone.
+	ScrapeConditionType = "MetricsScraped"
+
+	allNodesScrapedReason = "AllNodesScraped"
+	scrapeFailuresReason  = "ScrapeFailures"
+)
+
+// ScrapeConditionPublisher sets the MetricsScraped condition of the Metrics API APIService
+// after every exported batch, with the causes of the scrape failures of the latest cycle
+// as message, so that the APIService alone explains degraded metrics.
+type ScrapeConditionPublisher struct {
+	client      rest.Interface
+	getFailures func() summary.ScrapeFailures
+	// Message of the condition last published, which is only updated when it changes.
+	published   bool
+	lastMessage string
+}
+
+func NewScrapeConditionPublisher(client rest.Interface, getFailures func() summary.ScrapeFailures) *ScrapeConditionPublisher {
+	return &ScrapeConditionPublisher{
+		client:      client,
+		getFailures: getFailures,
+	}
+}
+
+// Subscribe registers the publisher for processed batches on the bus.
+func (this *ScrapeConditionPublisher) Subscribe(eventBus *bus.Bus) {
+	eventBus.Subscribe("scrape_condition_publisher", bus.TopicDataBatch, this.handle)
+}
+
+func (this *ScrapeConditionPublisher) handle(event *bus.Event) {
+	failures := this.getFailures()
+	message := failures.Message()
+	if this.published && message == this.lastMessage {
+		return
+	}
+	if err := this.publish(message, time.Now()); err != nil {
+		glog.Errorf("Failed to set the %s condition of %s %s: %v", ScrapeConditionType, APIServiceKind, APIServiceName, err)
+		return
+	}
+	this.published = true
+	this.lastMessage = message
+}
+
+func (this *ScrapeConditionPublisher) publish(message string, now time.Time) error {
+	path := []string{"/apis", APIServiceGroup, APIServiceVersion, "apiservices", APIServiceName}
+	body, err := this.client.Get().AbsPath(path...).DoRaw()
+	if err != nil {
+		return err
+	}
+	// The object is updated as a map, so that fields unknown here are preserved.
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return err
+	}
+	condition := map[string]interface{}{
+		"type":    ScrapeConditionType,
+		"status":  "True",
+		"reason":  allNodesScrapedReason,
+		"message": "all nodes were scraped in the latest cycle",
+	}
+	if message != "" {
+		condition["status"] = "False"
+		condition["reason"] = scrapeFailuresReason
+		condition["message"] = message
+	}
+	SetAPIServiceCondition(obj, condition, now)
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = this.client.Put().AbsPath(append(path, "status")...).Body(data).DoRaw()
+	return err
+}
+
+// SetAPIServiceCondition replaces the condition of the same type in the status of the
+// APIService object, keeping its last transition time if the status didn't change.
+func SetAPIServiceCondition(obj map[string]interface{}, condition map[string]interface{}, now time.Time) {
+	status, _ := obj["status"].(map[string]interface{})
+	if status == nil {
+		status = map[string]interface{}{}
+		obj["status"] = status
+	}
+	conditions, _ := status["conditions"].([]interface{})
+	condition["lastTransitionTime"] = metav1.NewTime(now).UTC().Format(time.RFC3339)
+	for i, existing := range conditions {
+		c, _ := existing.(map[string]interface{})
+		if c == nil || c["type"] != condition["type"] {
+			continue
+		}
+		if c["status"] == condition["status"] && c["lastTransitionTime"] != nil {
+			condition["lastTransitionTime"] = c["lastTransitionTime"]
+		}
+		conditions[i] = condition
+		return
+	}
+	status["conditions"] = append(conditions, condition)
+}
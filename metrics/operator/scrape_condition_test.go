@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/summary"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestSetAPIServiceCondition(t *testing.T) {
+	obj := map[string]interface{}{}
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	SetAPIServiceCondition(obj, map[string]interface{}{"type": "MetricsScraped", "status": "False"}, start)
+	require.NoError(t, json.Unmarshal([]byte(`{"status":{"conditions":[{"type":"Available","status":"True"}]}}`), &obj))
+	SetAPIServiceCondition(obj, map[string]interface{}{"type": "MetricsScraped", "status": "False", "message": "a"}, start)
+	SetAPIServiceCondition(obj, map[string]interface{}{"type": "MetricsScraped", "status": "False", "message": "b"}, start.Add(time.Minute))
+
+	conditions := obj["status"].(map[string]interface{})["conditions"].([]interface{})
+	require.Len(t, conditions, 2)
+	assert.Equal(t, "Available", conditions[0].(map[string]interface{})["type"], "other conditions are kept")
+	scraped := conditions[1].(map[string]interface{})
+	assert.Equal(t, "b", scraped["message"])
+	assert.Equal(t, "2018-01-01T00:00:00Z", scraped["lastTransitionTime"], "unchanged status keeps the transition time")
+
+	SetAPIServiceCondition(obj, map[string]interface{}{"type": "MetricsScraped", "status": "True"}, start.Add(time.Minute))
+	scraped = obj["status"].(map[string]interface{})["conditions"].([]interface{})[1].(map[string]interface{})
+	assert.Equal(t, "2018-01-01T00:01:00Z", scraped["lastTransitionTime"])
+}
+
+func TestScrapeConditionPublisher(t *testing.T) {
+	var lock sync.Mutex
+	apiService := `{"apiVersion":"apiregistration.k8s.io/v1beta1","kind":"APIService","metadata":{"name":"v1beta1.metrics.k8s.io"},` +
+		`"status":{"conditions":[{"type":"Available","status":"True"}]}}`
+	updates := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.URL.Path == "/apis/apiregistration.k8s.io/v1beta1/apiservices/v1beta1.metrics.k8s.io" && req.Method == http.MethodGet:
+			w.Write([]byte(apiService))
+		case req.URL.Path == "/apis/apiregistration.k8s.io/v1beta1/apiservices/v1beta1.metrics.k8s.io/status" && req.Method == http.MethodPut:
+			body, _ := ioutil.ReadAll(req.Body)
+			apiService = string(body)
+			updates++
+			w.Write(body)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+	client := kube_client.NewForConfigOrDie(&rest.Config{Host: server.URL}).Discovery().RESTClient()
+
+	failures := summary.ScrapeFailures{Causes: []summary.ScrapeFailureCause{
+		{Cause: summary.CauseTimeout, Nodes: 2, Examples: []string{"n1", "n2"}},
+	}}
+	publisher := NewScrapeConditionPublisher(client, func() summary.ScrapeFailures { return failures })
+	publisher.handle(&bus.Event{})
+	publisher.handle(&bus.Event{})
+	assert.Equal(t, 1, updates, "unchanged condition is published once")
+
+	var obj struct {
+		Status struct {
+			Conditions []map[string]string `json:"conditions"`
+		} `json:"status"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(apiService), &obj))
+	require.Len(t, obj.Status.Conditions, 2)
+	assert.Equal(t, "False", obj.Status.Conditions[1]["status"])
+	assert.Equal(t, "2 nodes not scraped: 2 timeout (n1, n2)", obj.Status.Conditions[1]["message"])
+
+	failures = summary.ScrapeFailures{}
+	publisher.handle(&bus.Event{})
+	assert.Equal(t, 2, updates)
+	require.NoError(t, json.Unmarshal([]byte(apiService), &obj))
+	assert.Equal(t, "True", obj.Status.Conditions[1]["status"])
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/apimachinery/pkg/util/wait"
+	v1listers "k8s.io/client-go/listers/core/v1"
+)
+
+// Upper bounds of the latency buckets of the Metrics API requests, in seconds.
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// The SLIs are exported as counters and histograms, so that they're computed over any window
+// by Prometheus and survive restarts, e.g. the ratio of fresh samples over an hour is
+// increase(heapster_sli_fresh_data_samples_total[1h]) / increase(heapster_sli_data_samples_total[1h]).
+var (
+	sliDataSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "sli",
+			Name:      "data_samples_total",
+			Help:      "Number of times the freshness of the node metrics was sampled.",
+		},
+	)
+	sliFreshDataSamples = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "sli",
+			Name:      "fresh_data_samples_total",
+			Help:      "Number of samples during which all ready nodes had fresh metrics.",
+		},
+	)
+	sliAPIRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "sli",
+			Name:      "api_requests_total",
+			Help:      "Number of Metrics API requests by verb, including the ones rejected by the handler chain.",
+		},
+		[]string{"verb"},
+	)
+	sliAPIGoodRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "sli",
+			Name:      "api_good_requests_total",
+			Help:      "Number of Metrics API requests by verb which didn't fail with a server error or a 429.",
+		},
+		[]string{"verb"},
+	)
+	sliAPIRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "heapster",
+			Subsystem: "sli",
+			Name:      "api_request_duration_seconds",
+			Help:      "Latency of the Metrics API requests by verb in seconds.",
+			Buckets:   requestDurationBuckets,
+		},
+		[]string{"verb"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(sliDataSamples)
+	prometheus.MustRegister(sliFreshDataSamples)
+	prometheus.MustRegister(sliAPIRequests)
+	prometheus.MustRegister(sliAPIGoodRequests)
+	prometheus.MustRegister(sliAPIRequestDuration)
+}
+
+// RecordAPIRequest records a Metrics API request for the SLIs. Requests which failed with a
+// server error or were throttled aren't good.
+func RecordAPIRequest(verb string, status int, latency time.Duration) {
+	sliAPIRequests.WithLabelValues(verb).Inc()
+	if status < http.StatusInternalServerError && status != http.StatusTooManyRequests {
+		sliAPIGoodRequests.WithLabelValues(verb).Inc()
+	}
+	sliAPIRequestDuration.WithLabelValues(verb).Observe(latency.Seconds())
+}
+
+// SLITracker samples whether all ready nodes of the shard have fresh metrics every interval.
+type SLITracker struct {
+	getLatestBatch func() *core.DataBatch
+	nodeLister     v1listers.NodeLister
+	shard          util.Shard
+	// Age of the node metrics beyond which they're not fresh.
+	maxAge time.Duration
+}
+
+func NewSLITracker(getLatestBatch func() *core.DataBatch, nodeLister v1listers.NodeLister, shard util.Shard,
+	maxAge time.Duration) *SLITracker {
+	return &SLITracker{
+		getLatestBatch: getLatestBatch,
+		nodeLister:     nodeLister,
+		shard:          shard,
+		maxAge:         maxAge,
+	}
+}
+
+// Run samples the freshness every interval until the channel is closed.
+func (this *SLITracker) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		this.update(time.Now())
+	}, interval, stopCh)
+}
+
+func (this *SLITracker) update(now time.Time) {
+	fresh, total, err := countFreshNodes(this.getLatestBatch(), this.nodeLister, this.shard, this.maxAge, now)
+	if err != nil {
+		glog.Errorf("Failed to sample the data freshness SLI: %v", err)
+		return
+	}
+	sliDataSamples.Inc()
+	if fresh == total {
+		sliFreshDataSamples.Inc()
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	corev1 "k8s.io/api/core/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	out := &dto.Metric{}
+	require.NoError(t, counter.Write(out))
+	return out.GetCounter().GetValue()
+}
+
+func TestRecordAPIRequest(t *testing.T) {
+	total := counterValue(t, sliAPIRequests.WithLabelValues("list"))
+	good := counterValue(t, sliAPIGoodRequests.WithLabelValues("list"))
+	RecordAPIRequest("list", http.StatusOK, 20*time.Millisecond)
+	RecordAPIRequest("list", http.StatusNotFound, 20*time.Millisecond)
+	RecordAPIRequest("list", http.StatusTooManyRequests, time.Millisecond)
+	RecordAPIRequest("list", http.StatusServiceUnavailable, 3*time.Second)
+	assert.Equal(t, total+4, counterValue(t, sliAPIRequests.WithLabelValues("list")))
+	assert.Equal(t, good+2, counterValue(t, sliAPIGoodRequests.WithLabelValues("list")), "client errors but throttling are good")
+
+	out := &dto.Metric{}
+	require.NoError(t, sliAPIRequestDuration.WithLabelValues("list").(prometheus.Histogram).Write(out))
+	assert.True(t, out.GetHistogram().GetSampleCount() >= 4)
+	assert.Len(t, out.GetHistogram().GetBucket(), len(requestDurationBuckets))
+}
+
+func TestSLITrackerFreshness(t *testing.T) {
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, nodes.Add(newNode("n1", corev1.ConditionTrue)))
+	require.NoError(t, nodes.Add(newNode("n2", corev1.ConditionTrue)))
+	now := time.Now()
+	batch := &core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{
+		core.NodeKey("n1"): {ScrapeTime: now},
+	}}
+	tracker := NewSLITracker(func() *core.DataBatch { return batch }, v1listers.NewNodeLister(nodes), util.Shard{}, time.Minute)
+	samples, fresh := counterValue(t, sliDataSamples), counterValue(t, sliFreshDataSamples)
+
+	tracker.update(now)
+	assert.Equal(t, samples+1, counterValue(t, sliDataSamples))
+	assert.Equal(t, fresh, counterValue(t, sliFreshDataSamples), "n2 has no metrics")
+
+	batch.MetricSets[core.NodeKey("n2")] = &core.MetricSet{ScrapeTime: now}
+	tracker.update(now)
+	assert.Equal(t, samples+2, counterValue(t, sliDataSamples))
+	assert.Equal(t, fresh+1, counterValue(t, sliFreshDataSamples))
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	StatusKind     = "MetricsServerStatus"
+	StatusResource = "metricsserverstatuses"
+
+	// Maximum number of errors reported in the status.
+	MaxStatusErrors = 10
+)
+
+// MetricsServerStatus reports the state of the latest scrape cycle for consumption by operators.
+type MetricsServerStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status ScrapeStatus `json:"status"`
+}
+
+type ScrapeStatus struct {
+	// Timestamp of the latest exported DataBatch.
+	LastBatchTime metav1.Time `json:"lastBatchTime"`
+	// Ready nodes expected to be scraped and those present in the latest batch.
+	NodesTotal   int `json:"nodesTotal"`
+	NodesScraped int `json:"nodesScraped"`
+	// True if all ready nodes were scraped.
+	Complete bool `json:"complete"`
+	// Up to MaxStatusErrors descriptions of scrape problems.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// StatusPublisher writes a MetricsServerStatus after every exported DataBatch.
+type StatusPublisher struct {
+	client     rest.Interface
+	namespace  string
+	name       string
+	nodeLister v1listers.NodeLister
+}
+
+func NewStatusPublisher(client rest.Interface, namespace, name string, nodeLister v1listers.NodeLister) *StatusPublisher {
+	return &StatusPublisher{
+		client:     client,
+		namespace:  namespace,
+		name:       name,
+		nodeLister: nodeLister,
+	}
+}
+
+// Subscribe registers the publisher for processed batches on the bus.
+func (this *StatusPublisher) Subscribe(eventBus *bus.Bus) {
+	eventBus.Subscribe("status_publisher", bus.TopicDataBatch, this.handle)
+}
+
+func (this *StatusPublisher) handle(event *bus.Event) {
+	nodes, err := this.nodeLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Error while listing nodes: %v", err)
+		return
+	}
+	status := ComputeScrapeStatus(event.Batch, nodes)
+	if err := this.publish(status); err != nil {
+		glog.Errorf("Failed to publish %s %s/%s: %v", StatusKind, this.namespace, this.name, err)
+	}
+}
+
+// ComputeScrapeStatus compares the nodes present in the batch with the ready nodes.
+func ComputeScrapeStatus(batch *core.DataBatch, nodes []*corev1.Node) ScrapeStatus {
+	status := ScrapeStatus{LastBatchTime: metav1.NewTime(batch.Timestamp)}
+	missing := []string{}
+	for _, node := range nodes {
+		if !isNodeReady(node) || util.IsNodeSkipped(node) {
+			continue
+		}
+		status.NodesTotal++
+		if _, found := batch.MetricSets[core.NodeKey(node.Name)]; found {
+			status.NodesScraped++
+		} else {
+			missing = append(missing, node.Name)
+		}
+	}
+	status.Complete = len(missing) == 0
+
+	sort.Strings(missing)
+	for i, node := range missing {
+		if i == MaxStatusErrors-1 && len(missing) > MaxStatusErrors {
+			status.Errors = append(status.Errors, fmt.Sprintf("%d more nodes not scraped", len(missing)-i))
+			break
+		}
+		status.Errors = append(status.Errors, fmt.Sprintf("node %s: no metrics in the latest batch", node))
+	}
+	return status
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// publish updates the status object, creating it if it does not exist yet.
+func (this *StatusPublisher) publish(status ScrapeStatus) error {
+	collection := []string{"/apis", Group, Version, "namespaces", this.namespace, StatusResource}
+	obj := &MetricsServerStatus{}
+
+	body, err := this.client.Get().AbsPath(append(collection, this.name)...).DoRaw()
+	create := errors.IsNotFound(err)
+	switch {
+	case create:
+		obj.APIVersion = Group + "/" + Version
+		obj.Kind = StatusKind
+		obj.Namespace = this.namespace
+		obj.Name = this.name
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(body, obj); err != nil {
+			return err
+		}
+	}
+	obj.Status = status
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if create {
+		_, err = this.client.Post().AbsPath(collection...).Body(data).DoRaw()
+	} else {
+		_, err = this.client.Put().AbsPath(append(collection, this.name)...).Body(data).DoRaw()
+	}
+	if err == nil {
+		glog.V(4).Infof("Published %s %s/%s", StatusKind, this.namespace, this.name)
+	}
+	return err
+}
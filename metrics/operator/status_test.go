@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newNode(name string, ready corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+		},
+	}
+}
+
+func TestComputeScrapeStatus(t *testing.T) {
+	now := time.Now()
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("n1"): {},
+			core.NodeKey("n2"): {},
+		},
+	}
+	nodes := []*corev1.Node{
+		newNode("n1", corev1.ConditionTrue),
+		newNode("n2", corev1.ConditionTrue),
+		newNode("n3", corev1.ConditionTrue),
+		newNode("n4", corev1.ConditionFalse),
+	}
+
+	status := ComputeScrapeStatus(batch, nodes)
+	assert.Equal(t, now.Unix(), status.LastBatchTime.Unix())
+	assert.Equal(t, 3, status.NodesTotal)
+	assert.Equal(t, 2, status.NodesScraped)
+	assert.False(t, status.Complete)
+	assert.Equal(t, []string{"node n3: no metrics in the latest batch"}, status.Errors)
+
+	status = ComputeScrapeStatus(batch, nodes[:2])
+	assert.True(t, status.Complete)
+	assert.Empty(t, status.Errors)
+}
+
+func TestComputeScrapeStatusLimitsErrors(t *testing.T) {
+	batch := &core.DataBatch{MetricSets: map[string]*core.MetricSet{}}
+	nodes := []*corev1.Node{}
+	for i := 0; i < 2*MaxStatusErrors; i++ {
+		nodes = append(nodes, newNode(fmt.Sprintf("n%02d", i), corev1.ConditionTrue))
+	}
+
+	status := ComputeScrapeStatus(batch, nodes)
+	assert.Len(t, status.Errors, MaxStatusErrors)
+	assert.Equal(t, fmt.Sprintf("%d more nodes not scraped", MaxStatusErrors+1), status.Errors[MaxStatusErrors-1])
+}
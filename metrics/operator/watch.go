@@ -0,0 +1,117 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/pflag"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+// Default interval at which the MetricsServerConfig is checked for changes.
+const DefaultConfigWatchInterval = 30 * time.Second
+
+// Flags which are applied when they change in the MetricsServerConfig while running.
+// Other flags only take effect on restart.
+var runtimeFlags = map[string]bool{
+	"collection_mode": true,
+}
+
+// ConfigWatcher periodically reads the MetricsServerConfig and applies changes of the
+// runtime flags. Flags which are removed from the config are reset to their default.
+type ConfigWatcher struct {
+	client    rest.Interface
+	namespace string
+	name      string
+	fs        *pflag.FlagSet
+	// Called after runtime flags were changed, to put the new values into effect.
+	apply func() error
+	// Runtime flags set on the command line, which take precedence over the config.
+	pinned map[string]bool
+}
+
+// NewConfigWatcher returns a watcher updating the flag set. It must be created before the
+// config is first applied, to tell the flags given on the command line apart.
+func NewConfigWatcher(client rest.Interface, namespace, name string, fs *pflag.FlagSet, apply func() error) *ConfigWatcher {
+	pinned := map[string]bool{}
+	for name := range runtimeFlags {
+		if f := fs.Lookup(name); f != nil && f.Changed {
+			pinned[name] = true
+		}
+	}
+	return &ConfigWatcher{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		fs:        fs,
+		apply:     apply,
+		pinned:    pinned,
+	}
+}
+
+// Run checks the config every interval until the channel is closed.
+func (this *ConfigWatcher) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := this.check(); err != nil {
+			glog.Errorf("Failed to update settings from %s %s/%s: %v", Kind, this.namespace, this.name, err)
+		}
+	}, interval, stopCh)
+}
+
+func (this *ConfigWatcher) check() error {
+	config, err := GetConfig(this.client, this.namespace, this.name)
+	if err != nil {
+		return err
+	}
+	if err := ValidateConfig(config); err != nil {
+		return err
+	}
+
+	// Sort for deterministic ordering of the changes.
+	names := make([]string, 0, len(runtimeFlags))
+	for name := range runtimeFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changed := false
+	for _, name := range names {
+		f := this.fs.Lookup(name)
+		if f == nil || this.pinned[name] {
+			continue
+		}
+		value, found := config.Spec.Flags[name]
+		if !found {
+			value = f.DefValue
+		}
+		if value == f.Value.String() {
+			continue
+		}
+		if err := this.fs.Set(name, value); err != nil {
+			return err
+		}
+		glog.Infof("Changed flag %s to %q from %s %s/%s", name, value, Kind, this.namespace, this.name)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return this.apply()
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/options"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	config := newConfig(nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/apis/config.metrics-server.k8s.io/v1alpha1/namespaces/kube-system/metricsserverconfigs/config" {
+			http.NotFound(w, req)
+			return
+		}
+		json.NewEncoder(w).Encode(config)
+	}))
+	defer server.Close()
+	client := kube_client.NewForConfigOrDie(&rest.Config{Host: server.URL}).Discovery().RESTClient()
+
+	opt := options.NewHeapsterRunOptions()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	opt.AddFlags(fs)
+	applied := 0
+	watcher := NewConfigWatcher(client, "kube-system", "config", fs, func() error {
+		applied++
+		return nil
+	})
+
+	require.NoError(t, watcher.check())
+	assert.Equal(t, 0, applied, "nothing changed")
+
+	config = newConfig(map[string]string{"collection_mode": "resources", "metric_resolution": "30s"})
+	require.NoError(t, watcher.check())
+	assert.Equal(t, 1, applied)
+	assert.Equal(t, "resources", opt.CollectionMode)
+	assert.Equal(t, "1m0s", opt.MetricResolution.String(), "only runtime flags are applied")
+
+	require.NoError(t, watcher.check())
+	assert.Equal(t, 1, applied, "unchanged config")
+
+	config = newConfig(map[string]string{"collection_mode": "everything"})
+	assert.Error(t, watcher.check())
+	assert.Equal(t, "resources", opt.CollectionMode, "invalid config is not applied")
+
+	config = newConfig(nil)
+	require.NoError(t, watcher.check())
+	assert.Equal(t, 2, applied)
+	assert.Equal(t, "full", opt.CollectionMode, "removed flag is reset to the default")
+}
+
+func TestConfigWatcherCommandLinePrecedence(t *testing.T) {
+	config := newConfig(map[string]string{"collection_mode": "full"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(config)
+	}))
+	defer server.Close()
+	client := kube_client.NewForConfigOrDie(&rest.Config{Host: server.URL}).Discovery().RESTClient()
+
+	opt := options.NewHeapsterRunOptions()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	opt.AddFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--collection_mode=resources"}))
+	watcher := NewConfigWatcher(client, "kube-system", "config", fs, func() error { return nil })
+
+	require.NoError(t, watcher.check())
+	assert.Equal(t, "resources", opt.CollectionMode)
+}
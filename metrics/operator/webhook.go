@@ -0,0 +1,98 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Path under which the validating admission webhook for MetricsServerConfig is served.
+const WebhookPath = "/validate-metricsserverconfig"
+
+// Subset of the admission.k8s.io/v1beta1 AdmissionReview used by the webhook.
+type admissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *admissionRequest  `json:"request,omitempty"`
+	Response        *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID    types.UID       `json:"uid"`
+	Object json.RawMessage `json:"object,omitempty"`
+}
+
+type admissionResponse struct {
+	UID     types.UID      `json:"uid"`
+	Allowed bool           `json:"allowed"`
+	Result  *metav1.Status `json:"status,omitempty"`
+}
+
+type webhook struct{}
+
+// NewValidatingWebhook returns a handler admitting only MetricsServerConfig objects
+// which pass ValidateConfig.
+func NewValidatingWebhook() http.Handler {
+	return &webhook{}
+}
+
+func (this *webhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	review := &admissionReview{}
+	if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+		http.Error(w, fmt.Sprintf("invalid AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "AdmissionReview without request", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = admit(review.Request)
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		glog.Errorf("Error while encoding AdmissionReview: %v", err)
+	}
+}
+
+func admit(req *admissionRequest) *admissionResponse {
+	res := &admissionResponse{UID: req.UID}
+	config := &MetricsServerConfig{}
+	err := json.Unmarshal(req.Object, config)
+	if err == nil {
+		err = ValidateConfig(config)
+	}
+	if err != nil {
+		res.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+		}
+		return res
+	}
+	res.Allowed = true
+	return res
+}
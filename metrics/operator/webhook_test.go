@@ -0,0 +1,59 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func review(t *testing.T, body string) (*httptest.ResponseRecorder, *admissionReview) {
+	rec := httptest.NewRecorder()
+	NewValidatingWebhook().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, WebhookPath, strings.NewReader(body)))
+	res := &admissionReview{}
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+	}
+	return rec, res
+}
+
+func TestWebhookAdmission(t *testing.T) {
+	_, res := review(t, `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview","request":{"uid":"1",
+		"object":{"kind":"MetricsServerConfig","spec":{"flags":{"metric_resolution":"30s"}}}}}`)
+	require.NotNil(t, res.Response)
+	assert.Equal(t, "1", string(res.Response.UID))
+	assert.True(t, res.Response.Allowed)
+	assert.Equal(t, "AdmissionReview", res.Kind)
+
+	_, res = review(t, `{"request":{"uid":"2","object":{"spec":{"flags":{"metric_resolution":"1s"}}}}}`)
+	require.NotNil(t, res.Response)
+	assert.False(t, res.Response.Allowed)
+	require.NotNil(t, res.Response.Result)
+	assert.Contains(t, res.Response.Result.Message, "metric resolution")
+}
+
+func TestWebhookInvalidRequest(t *testing.T) {
+	rec, _ := review(t, `not json`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, _ = review(t, `{}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -15,14 +15,32 @@
 package options
 
 import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
 
 	"github.com/kubernetes-incubator/metrics-server/common/flags"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/apimachinery/pkg/labels"
 	genericoptions "k8s.io/apiserver/pkg/server/options"
 )
 
+// Longest slow window, limited by the retention of the long-term metric store.
+const MaxSlowWindow = 15 * time.Minute
+
+// Default share of the ready nodes which must be in a batch for the scrape to be complete.
+const DefaultCompletenessThreshold = 0.9
+
+// Share of the nodes scraped by canaries unless configured otherwise.
+const DefaultCanaryNodeShare = 0.1
+
+// Max size of a decoded snapshot batch in bytes unless configured otherwise.
+const DefaultSnapshotMaxSize = 256 << 20
+
 type HeapsterRunOptions struct {
 	// genericoptions.ReccomendedOptions - EtcdOptions
 	SecureServing  *genericoptions.SecureServingOptions
@@ -33,6 +51,16 @@ type HeapsterRunOptions struct {
 	// Only to be used to for testing
 	DisableAuthForTesting bool
 
+	// Rate limits of the API clients feeding the listers, of the TokenReview and
+	// SubjectAccessReview clients, and of all other clients, e.g. for status updates and
+	// events. Zero keeps the defaults.
+	InformerAPIQPS   float32
+	InformerAPIBurst int
+	AuthAPIQPS       float32
+	AuthAPIBurst     int
+	KubeAPIQPS       float32
+	KubeAPIBurst     int
+
 	MetricResolution    time.Duration
 	Port                int
 	Ip                  string
@@ -42,6 +70,134 @@ type HeapsterRunOptions struct {
 	Version             bool
 	LabelSeperator      string
 	DisableMetricExport bool
+	// Serve zero usage for scheduled pods which were not scraped yet.
+	PlaceholderPodMetrics bool
+	// Priority classes of pods which are left out of PodMetrics LIST responses.
+	ListExcludedPriorityClasses []string
+	// Serve Succeeded and Failed pods in PodMetrics LIST responses.
+	ListCompletedPods bool
+	// Pods which started less than this ago are withheld from the Metrics API.
+	PodMetricsMinAge time.Duration
+	// Serve the usage of ephemeral containers in PodMetrics.
+	EphemeralContainerMetrics bool
+	// Pushed summaries are used for this long after they were received, zero disables the
+	// push endpoint.
+	PushMaxAge time.Duration
+	// Label selector of the nodes which are scraped and served.
+	NodeSelector string
+	// Serve cordoned nodes in NodeMetrics LIST responses unless requested otherwise.
+	ListUnschedulableNodes bool
+	// IP family used for serving and advertising the API, empty for the default.
+	AddressFamily string
+	// MetricsServerConfig resource (namespace/name) the settings are read from.
+	ConfigResource string
+	// MetricsServerStatus resource (namespace/name) the scrape status is published to.
+	StatusResource string
+	// Window over which usage is averaged for requests with window=slow.
+	SlowWindow time.Duration
+	// Age of the latest metrics after which they're stale, zero for three times the
+	// metric resolution.
+	MaxMetricsDelay time.Duration
+	// File the latest processed batch is written to, for instances reading it with SnapshotSource.
+	SnapshotFile string
+	// Max age of the SnapshotFile batch served at startup until the first scrape, 0 to not restore it.
+	RestoreSnapshotMaxAge time.Duration
+	// Snapshot file or URL the served batches are read from instead of scraping.
+	SnapshotSource string
+	// Max size in bytes of the batches read from snapshots, after decompression.
+	SnapshotMaxSize int64
+	// CA file to verify the instance serving the snapshot URL with.
+	SnapshotSourceCAFile string
+	// Serve the latest batch for read-only replicas.
+	ServeSnapshot bool
+	// Snapshot file or URL of the primary instance a canary compares its batches with.
+	CanaryPrimary string
+	// Share of the nodes a canary scrapes.
+	CanaryNodeShare float64
+	// Shard of the nodes this instance scrapes, out of ShardCount.
+	ShardIndex int
+	ShardCount int
+	// gRPC addresses of all shards by index, which the Metrics API requests for the nodes
+	// of other shards are fanned out to.
+	ShardPeers []string
+	// Unix socket the API is additionally served on, for sidecars in the same pod.
+	UnixSocket string
+	// Address node and pod metrics are additionally streamed on over gRPC, empty to not serve them.
+	GRPCAddress string
+	// File Metrics API requests are logged to as JSON lines, empty to not log them.
+	AccessLogFile string
+	// Size in megabytes at which the access log is rotated, and the number and age in days
+	// of the rotated files kept.
+	AccessLogMaxSize    int
+	AccessLogMaxBackups int
+	AccessLogMaxAge     int
+	// Serve the stored points on /debug/storage.
+	EnableDebugHandlers bool
+	// Service (namespace/name) the Metrics API APIService is checked to point at.
+	APIServiceService string
+	// CA bundle file the APIService is checked to carry.
+	APIServiceCAFile string
+	// Fix the APIService when it is found to be stale.
+	ReconcileAPIService bool
+	// Publish the causes of the scrape failures in a condition of the APIService.
+	APIServiceScrapeCondition bool
+	// Metrics collected from the kubelet summaries, full or resources. Can be changed
+	// in the MetricsServerConfig while running.
+	CollectionMode string
+	// Maximum number of concurrent kubelet scrapes, unlimited if not positive.
+	MaxScrapeInFlight int
+	// Window the scrapes of a cycle are spread over, derived from the number of nodes if zero.
+	ScrapeSpread time.Duration
+	// Endpoint the kubelets are scraped on, summary or resource.
+	KubeletMetricsEndpoint string
+	// IP family of the addresses dual-stack nodes are scraped at, ipv4 or ipv6.
+	KubeletPreferredAddressFamily string
+	// Interval at which the kubelet summaries are streamed between the scrapes, zero if disabled.
+	KubeletStreamingInterval time.Duration
+	// How long the points of deleted pods, not ready nodes and pods of the filtered
+	// namespaces are kept. Zero keeps them as long as the batches are stored.
+	DeletedPodRetention        time.Duration
+	NotReadyNodeRetention      time.Duration
+	FilteredNamespaceRetention time.Duration
+	// History kept of the pods of some namespaces, as namespace=duration.
+	NamespaceHistory   []string
+	FilteredNamespaces []string
+	// Estimated memory the long-term metric store may use in bytes. Zero derives it from
+	// the container memory limit, negative disables the limit.
+	StoreMemoryBudget int64
+	// Pod (namespace/name) events about degraded operation are recorded on.
+	EventPod string
+	// Share of the ready nodes which must be scraped before an incomplete scrape is reported.
+	CompletenessThreshold float64
+	// Share of the ready nodes which must have fresh metrics for the instance to be healthy, 0 to not check.
+	ReadyThreshold float64
+	// Whether control plane nodes are scraped, include or exclude.
+	ControlPlaneNodes string
+	// Log whose Metrics API requests the replay command sends, how many times faster than
+	// recorded, how many at a time at most, and the instance they're sent to, empty to send
+	// them through the cluster's API server.
+	ReplayFrom        string
+	ReplaySpeed       float64
+	ReplayConcurrency int
+	ReplayTarget      string
+	// Profile config replay simulates, and how many scrape cycles it runs.
+	ReplayProfile    string
+	SimulationCycles int
+	// Most recent points per node and container served by the history subresources, zero to not serve them.
+	HistoryPoints int
+	// Template deriving the tenant of pods from their namespace, empty to not attribute tenants.
+	TenantTemplate string
+	// Prometheus remote write endpoint the node and pod usage is exported to, empty to not
+	// export it, with the TLS files, the file of the bearer token, the timeout of the writes,
+	// and the file of the key namespace and pod names are hashed with, if any.
+	RemoteWriteURL              string
+	RemoteWriteCAFile           string
+	RemoteWriteCertFile         string
+	RemoteWriteKeyFile          string
+	RemoteWriteInsecure         bool
+	RemoteWriteBearerTokenFile  string
+	RemoteWriteTimeout          time.Duration
+	RemoteWriteAnonymizeKeyFile string
 }
 
 func NewHeapsterRunOptions() *HeapsterRunOptions {
@@ -60,8 +216,15 @@ func (h *HeapsterRunOptions) AddFlags(fs *pflag.FlagSet) {
 	h.Features.AddFlags(fs)
 
 	fs.Var(&h.Sources, "source", "source(s) to watch")
+	fs.Float32Var(&h.InformerAPIQPS, "informer_api_qps", 0, "QPS of the clients listing and watching nodes, pods and replica sets. Zero keeps the client-go default")
+	fs.IntVar(&h.InformerAPIBurst, "informer_api_burst", 0, "Burst of the clients listing and watching nodes, pods and replica sets. Zero keeps the client-go default")
+	fs.Float32Var(&h.AuthAPIQPS, "auth_api_qps", 0, "QPS of the TokenReview and SubjectAccessReview clients. Zero keeps the default of 200")
+	fs.IntVar(&h.AuthAPIBurst, "auth_api_burst", 0, "Burst of the TokenReview and SubjectAccessReview clients. Zero keeps the default of 400")
+	fs.Float32Var(&h.KubeAPIQPS, "kube_api_qps", 0, "QPS of the other API clients, e.g. for status updates, events and the APIService. Zero keeps the client-go default")
+	fs.IntVar(&h.KubeAPIBurst, "kube_api_burst", 0, "Burst of the other API clients, e.g. for status updates, events and the APIService. Zero keeps the client-go default")
 	fs.Var(&h.Sinks, "sink", "external sink(s) that receive data")
 	fs.DurationVar(&h.MetricResolution, "metric_resolution", 60*time.Second, "The resolution at which heapster will retain metrics.")
+	fs.DurationVar(&h.MaxMetricsDelay, "max_metrics_delay", 0, "Age of the latest metrics after which the Metrics API serves them as stale and the health check fails. 0 uses three times the --metric_resolution")
 
 	fs.IntVar(&h.Port, "heapster-port", 8082, "port used by the Heapster-specific APIs")
 	fs.StringVar(&h.Ip, "listen_ip", "", "IP to listen on, defaults to all IPs")
@@ -69,4 +232,226 @@ func (h *HeapsterRunOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&h.Version, "version", false, "print version info and exit")
 	fs.StringVar(&h.LabelSeperator, "label_seperator", ",", "seperator used for joining labels")
 	fs.BoolVar(&h.DisableMetricExport, "disable_export", false, "Disable exporting metrics in api/v1/metric-export")
+	fs.BoolVar(&h.PlaceholderPodMetrics, "placeholder_pod_metrics", false, "Serve zero usage, marked with the metrics.k8s.io/placeholder annotation, for scheduled pods which are not yet present in any kubelet summary")
+	fs.StringSliceVar(&h.ListExcludedPriorityClasses, "list_excluded_priority_classes", []string{}, "Priority classes of pods to leave out of PodMetrics LIST responses. GET requests are not affected")
+	fs.BoolVar(&h.ListCompletedPods, "list_completed_pods", false, "Serve Succeeded and Failed pods in PodMetrics LIST responses, with the final sample of their containers while it's stored. They're left out by default, GET requests are not affected")
+	fs.DurationVar(&h.PodMetricsMinAge, "pod_metrics_min_age", 0, "Withhold PodMetrics of pods which started less than this ago, as their usage rates are computed from a single sample. Zero serves all pods")
+	fs.BoolVar(&h.EphemeralContainerMetrics, "ephemeral_container_metrics", false, "Serve the usage of ephemeral containers in PodMetrics, after the regular containers and sidecars, with their names in the metrics.k8s.io/ephemeral-containers annotation")
+	fs.DurationVar(&h.PushMaxAge, "push_max_age", 0, "Accept kubelet summaries POSTed to /ingest/summary by agents of nodes which can't be scraped, and use them instead of scraping the node for this long after they were received. Pushing requires the post verb on the non-resource URL. 0 disables the endpoint")
+	fs.StringVar(&h.NodeSelector, "node_selector", "", "Label selector restricting the nodes which are scraped and served in the Metrics API, e.g. kubernetes.io/os!=windows, for sharing the nodes of a cluster between instances. Empty selects all nodes")
+	fs.BoolVar(&h.ListUnschedulableNodes, "list_unschedulable_nodes", true, "Serve unschedulable (cordoned) nodes in NodeMetrics LIST responses. Can be overridden per request with the includeUnschedulable query parameter. GET requests are not affected")
+	fs.StringVar(&h.AddressFamily, "address_family", "", "IP family (ipv4 or ipv6) used to serve and advertise the API when --bind-address is not set. Select the family of the Service IP used by the aggregator on dual-stack clusters")
+	fs.StringVar(&h.ConfigResource, "config_resource", "", "MetricsServerConfig resource, as namespace/name, to read settings from. Flags given on the command line take precedence. Also enables the configuration validating webhook")
+	fs.DurationVar(&h.SlowWindow, "slow_window", 5*time.Minute, "Window over which usage is averaged for Metrics API requests with the window=slow query parameter, intended for reporting. Requests without it get the latest samples")
+	fs.StringVar(&h.SnapshotFile, "snapshot_file", "", "File to write the latest processed batch to after every scrape, so that instances in the same pod or on the same host can serve it with --snapshot_source")
+	fs.DurationVar(&h.RestoreSnapshotMaxAge, "restore_snapshot_max_age", 0, "Serve the batch in --snapshot_file at startup until the first scrape, if it's not older than this, so that the Metrics API doesn't report missing metrics after a restart. The file has to be on a volume kept across restarts. Not restored if 0")
+	fs.StringVar(&h.SnapshotSource, "snapshot_source", "", "Snapshot file written by another instance with --snapshot_file, or https URL of the snapshot served by another instance with --serve_snapshot, to serve metrics from. The instance does not scrape the nodes itself")
+	fs.Int64Var(&h.SnapshotMaxSize, "snapshot_max_size", DefaultSnapshotMaxSize, "Max size in bytes of a batch read from a snapshot file or URL, after decompression. Bigger snapshots are rejected rather than exhausting the memory")
+	fs.StringVar(&h.SnapshotSourceCAFile, "snapshot_source_ca_file", "", "CA file to verify the serving certificate of the --snapshot_source or --canary_primary URL with. The system roots are used if empty")
+	fs.BoolVar(&h.ServeSnapshot, "serve_snapshot", false, "Serve the latest processed batch on /snapshot, for read-only replicas started with --snapshot_source")
+	fs.StringVar(&h.CanaryPrimary, "canary_primary", "", "Snapshot file or URL of the primary instance, written with --snapshot_file or served with --serve_snapshot, to compare the metrics of the --canary_node_share of the nodes with. Canaries publish the divergence on --heapster-port and don't serve the Metrics API")
+	fs.Float64Var(&h.CanaryNodeShare, "canary_node_share", DefaultCanaryNodeShare, "Share of the nodes scraped by a canary started with --canary_primary")
+	fs.IntVar(&h.ShardIndex, "shard_index", 0, "Index, from 0, of the shard of the nodes this instance scrapes, out of --shard_count. Nodes are placed by consistent hashing of their name")
+	fs.IntVar(&h.ShardCount, "shard_count", 1, "Number of instances the nodes are sharded between, each started with its own --shard_index. 1 scrapes all nodes")
+	fs.StringSliceVar(&h.ShardPeers, "shard_peers", []string{}, "gRPC addresses (host:port) of all --shard_count shards, ordered by shard index, each served with --grpc_address. Metrics API requests for the nodes and pods of other shards are fanned out to them and merged, so that every shard serves the whole cluster while only storing its own nodes. Other endpoints only serve the nodes of the shard. Peers are called with the service account token, verified with the CA of --snapshot_source_ca_file")
+	fs.StringVar(&h.UnixSocket, "unix_socket", "", "Path of a Unix socket to additionally serve the API on, e.g. in a volume shared with sidecars of the pod. Clients authenticate with a bearer token, as TLS is not used on the socket")
+	fs.StringVar(&h.GRPCAddress, "grpc_address", "", "Address (host:port) to additionally serve node and pod metrics on through a gRPC service streaming the v1beta1 protobuf items as they're built, with the serving certificate, authentication and authorization of the API. Calls are written to the --access_log_file but not to the audit log of the cluster, which only covers requests through the aggregator. Not served if empty")
+	fs.StringVar(&h.AccessLogFile, "access_log_file", "", "File to log every Metrics API request to as a JSON line, with the verb, resource, namespace, user, latency and response size. Not logged if empty")
+	fs.IntVar(&h.AccessLogMaxSize, "access_log_max_size", 100, "Size in megabytes at which the --access_log_file is rotated")
+	fs.IntVar(&h.AccessLogMaxBackups, "access_log_max_backups", 3, "Number of rotated access log files to keep, all if 0")
+	fs.IntVar(&h.AccessLogMaxAge, "access_log_max_age", 0, "Number of days to keep rotated access log files for, forever if 0")
+	fs.BoolVar(&h.EnableDebugHandlers, "enable_debug_handlers", false, "Serve the latest stored node and container points on /debug/storage, with whether the Metrics API serves them and why not. Requires the get verb on the non-resource URL. Filtered with the node, namespace and pod query parameters")
+	fs.StringVar(&h.APIServiceService, "apiservice_service", "", "Service, as namespace/name, the v1beta1.metrics.k8s.io APIService should point at, when metrics-server manages its own registration. Enables periodic checks of the APIService, reported in metrics and events")
+	fs.StringVar(&h.APIServiceCAFile, "apiservice_ca_file", "", "CA bundle file the APIService should carry, e.g. the CA of the serving certificate. The CA bundle is not checked if empty")
+	fs.BoolVar(&h.ReconcileAPIService, "reconcile_apiservice", false, "Update the APIService to point at the --apiservice_service with the --apiservice_ca_file when it is found to be stale")
+	fs.BoolVar(&h.APIServiceScrapeCondition, "apiservice_scrape_condition", false, "Publish the causes of the failed kubelet scrapes of the latest cycle in the MetricsScraped condition of the v1beta1.metrics.k8s.io APIService. Requires update on apiservices/status")
+	fs.StringVar(&h.CollectionMode, "collection_mode", util.CollectionFull, "Metrics to collect from the kubelet summaries: full, or resources for only the cpu and memory metrics served by the Metrics API. Changes in the --config_resource are applied while running")
+	fs.IntVar(&h.MaxScrapeInFlight, "max_scrape_in_flight", 0, "Maximum number of nodes scraped at once by a pool of workers. 0 scrapes all nodes concurrently")
+	fs.DurationVar(&h.ScrapeSpread, "scrape_spread", 0, "Window the node scrapes of every cycle are spread evenly over. It must be shorter than the scrape timeout of 20s. 0 uses up to 4s depending on the number of nodes")
+	fs.StringVar(&h.KubeletPreferredAddressFamily, "kubelet_preferred_address_family", "", "IP family, ipv4 or ipv6, of the address kubelets are scraped at when a node has several addresses of the selected type, e.g. an InternalIP of each family on dual-stack nodes. Defaults to the family of the POD_IP environment variable, the pod network the metrics-server runs in, and without it to the last address of the type. Overridden by the preferredAddressFamily source option")
+	fs.DurationVar(&h.KubeletStreamingInterval, "kubelet_streaming_interval", 0, "Experimental: keep requesting the summaries of the kubelets at this interval between the scrapes, over connections kept alive and HTTP/2 where the kubelet supports it, so that scrapes use the latest summary instead of waiting for the kubelets. Kubelets don't answer conditional requests, so every request fetches and decodes a full summary: the kubelet load and decoding cost grow by the metric resolution divided by the interval, and a second summary per node is kept in memory. The requests are spread over the interval, limited by --max_scrape_in_flight and the node pools. It must be shorter than the metric resolution. 0 disables it")
+	fs.StringVar(&h.KubeletMetricsEndpoint, "kubelet_metrics_endpoint", util.KubeletEndpointSummary, "Kubelet endpoint the kubernetes.summary_api source scrapes: summary for the Summary API, or resource for the Prometheus /metrics/resource endpoint, which only has the cpu and memory usage of nodes and containers")
+	fs.StringVar(&h.StatusResource, "status_resource", "", "MetricsServerStatus resource, as namespace/name, to publish the scrape completeness, last batch time and errors to after every scrape")
+	fs.StringVar(&h.EventPod, "event_pod", "", "Pod, as namespace/name, to record events about sustained incomplete scrapes and slow store updates on, e.g. $(POD_NAMESPACE)/$(POD_NAME) from the downward API")
+	fs.Float64Var(&h.CompletenessThreshold, "completeness_threshold", DefaultCompletenessThreshold, "Share of the ready nodes which must be scraped, below which an event is recorded on the --event_pod")
+	fs.Float64Var(&h.ReadyThreshold, "ready_threshold", 0, "Share of the ready nodes which must have metrics scraped within two metric resolutions, below which the metric-storage-fresh check on /readyz fails, so that load balancers stop sending requests to the instance. Not checked if 0")
+	fs.StringVar(&h.ControlPlaneNodes, "control_plane_nodes", util.ControlPlaneInclude, "Whether nodes labeled node-role.kubernetes.io/control-plane or node-role.kubernetes.io/master are scraped: include, or exclude to treat them and their pods like nodes with the metrics-server.kubernetes.io/skip annotation, for clusters whose control plane is hosted or managed externally")
+	fs.StringVar(&h.ReplayFrom, "replay_from", "", "Audit log, or --access_log_file, in JSON lines whose Metrics API requests the replay command sends to the cluster at their recorded pace, for load testing a test instance")
+	fs.Float64Var(&h.ReplaySpeed, "replay_speed", 1, "How many times faster than recorded the replay command sends the requests. The client's rate limits are raised to let them through at that pace")
+	fs.IntVar(&h.ReplayConcurrency, "replay_concurrency", 10, "Most requests the replay command has in flight, later requests are sent late once it's reached")
+	fs.StringVar(&h.ReplayTarget, "replay_target", "", "URL of a metrics-server instance, like https://10.0.0.1:443, the replay command sends the requests to directly instead of through the API server's aggregator. They're authenticated with the cluster's credentials and the serving certificate isn't verified")
+	fs.StringVar(&h.ReplayProfile, "replay_profile", "", "Profile written by config export, whose configuration the config replay command runs against a generated cluster of the recorded shape, to reproduce reported performance issues locally. Flags given on the command line override those of the profile")
+	fs.IntVar(&h.SimulationCycles, "simulation_cycles", 10, "Number of scrape cycles the config replay command runs")
+	fs.DurationVar(&h.DeletedPodRetention, "deleted_pod_retention", 0, "How long to keep the points of deleted pods after they are gone from the API server. 0 keeps them for as long as the batches are stored")
+	fs.DurationVar(&h.NotReadyNodeRetention, "not_ready_node_retention", 0, "How long to keep the points of nodes which are not ready or deleted, and of their system containers. 0 keeps them for as long as the batches are stored")
+	fs.StringSliceVar(&h.FilteredNamespaces, "filtered_namespaces", []string{}, "Namespaces whose pod and namespace points are only kept for --filtered_namespace_retention")
+	fs.DurationVar(&h.FilteredNamespaceRetention, "filtered_namespace_retention", 0, "How long to keep the points of the --filtered_namespaces. 0 keeps them for as long as the batches are stored")
+	fs.StringSliceVar(&h.NamespaceHistory, "namespace_history", []string{}, "How long to keep the points of the pods of a namespace, as namespace=duration, e.g. kube-system=0s to keep only the latest points, or team-a=5m for a short history for custom autoscalers. Limits the windows their usage is averaged over. Other namespaces keep all stored points")
+	fs.IntVar(&h.HistoryPoints, "history_points", 0, "Number of the most recent points per node and container kept in memory and served by the history subresources of NodeMetrics and PodMetrics. 0 disables the subresources")
+	fs.StringVar(&h.TenantTemplate, "tenant_template", "", "Go template executed on the namespaces to derive the tenant their pods are attributed to, e.g. '{{ index .Labels \"tenant\" }}'. Pods of namespaces with an empty result have no tenant. Enables the tenant-aggregated usage on /tenantmetrics, which only covers the namespaces the user may list pods in")
+	fs.StringVar(&h.RemoteWriteURL, "remote-write-url", "", "Prometheus remote write endpoint, e.g. https://prometheus.example.com/api/v1/write, the cpu and memory usage of all nodes and pods is written to after every scrape, for long-term storage without a second scraping pipeline. Samples are dropped if a write fails. Not exported if empty")
+	fs.StringVar(&h.RemoteWriteCAFile, "remote-write-ca-file", "", "CA file to verify the certificate of the --remote-write-url with. The system roots are used if empty")
+	fs.StringVar(&h.RemoteWriteCertFile, "remote-write-cert-file", "", "Client certificate file presented to the --remote-write-url")
+	fs.StringVar(&h.RemoteWriteKeyFile, "remote-write-key-file", "", "Key file of the --remote-write-cert-file")
+	fs.BoolVar(&h.RemoteWriteInsecure, "remote-write-insecure", false, "Don't verify the certificate of the --remote-write-url")
+	fs.StringVar(&h.RemoteWriteBearerTokenFile, "remote-write-bearer-token-file", "", "File of the bearer token the writes to the --remote-write-url are authenticated with. It's read again for every write, so that rotated tokens are used")
+	fs.DurationVar(&h.RemoteWriteTimeout, "remote-write-timeout", 10*time.Second, "Timeout of a write to the --remote-write-url")
+	fs.StringVar(&h.RemoteWriteAnonymizeKeyFile, "remote-write-anonymize-key-file", "", "File of the key the namespace and pod names written to the --remote-write-url are hashed with, for exporting usage to shared analytics platforms. The names are kept if empty")
+	fs.Int64Var(&h.StoreMemoryBudget, "store_memory_budget", 0, "Estimated memory in bytes the long-term metric store may use before its oldest entries are dropped early. 0 uses a quarter of the container memory limit, if any; a negative value disables the limit")
+}
+
+// Validate checks the option values which cannot be checked while parsing the flags.
+func (h *HeapsterRunOptions) Validate() error {
+	if h.MetricResolution < 5*time.Second {
+		return fmt.Errorf("metric resolution needs to be greater than 5 seconds - %d", h.MetricResolution)
+	}
+	if h.MaxMetricsDelay != 0 && h.MaxMetricsDelay < h.MetricResolution {
+		return fmt.Errorf("max metrics delay needs to be at least the metric resolution - %s", h.MaxMetricsDelay)
+	}
+	if h.SlowWindow < h.MetricResolution || h.SlowWindow > MaxSlowWindow {
+		return fmt.Errorf("slow window needs to be between the metric resolution and %s - %s", MaxSlowWindow, h.SlowWindow)
+	}
+	if err := util.ValidateAddressFamily(h.AddressFamily); err != nil {
+		return err
+	}
+	if h.GRPCAddress != "" {
+		if _, _, err := net.SplitHostPort(h.GRPCAddress); err != nil {
+			return fmt.Errorf("invalid grpc address %q: %v", h.GRPCAddress, err)
+		}
+	}
+	if h.SnapshotFile != "" && h.SnapshotSource != "" {
+		return fmt.Errorf("only one of snapshot_file and snapshot_source can be set")
+	}
+	if h.SnapshotMaxSize <= 0 {
+		return fmt.Errorf("snapshot max size needs to be positive - %d", h.SnapshotMaxSize)
+	}
+	if h.RestoreSnapshotMaxAge < 0 {
+		return fmt.Errorf("restore snapshot max age needs to be at least 0 - %s", h.RestoreSnapshotMaxAge)
+	}
+	if h.RestoreSnapshotMaxAge > 0 && h.SnapshotFile == "" {
+		return fmt.Errorf("restore_snapshot_max_age requires snapshot_file")
+	}
+	if h.CanaryPrimary != "" && h.SnapshotSource != "" {
+		return fmt.Errorf("canaries scrape the nodes, canary_primary and snapshot_source can't both be set")
+	}
+	if h.CanaryNodeShare <= 0 || h.CanaryNodeShare > 1 {
+		return fmt.Errorf("canary node share needs to be greater than 0 and at most 1 - %v", h.CanaryNodeShare)
+	}
+	if h.ShardCount < 1 || h.ShardIndex < 0 || h.ShardIndex >= h.ShardCount {
+		return fmt.Errorf("shard index needs to be at least 0 and less than the shard count %d - %d", h.ShardCount, h.ShardIndex)
+	}
+	if h.ShardCount > 1 && (h.SnapshotSource != "" || h.CanaryPrimary != "") {
+		return fmt.Errorf("shards scrape the nodes, shard_count can't be set with snapshot_source or canary_primary")
+	}
+	if len(h.ShardPeers) > 0 {
+		if len(h.ShardPeers) != h.ShardCount {
+			return fmt.Errorf("shard_peers needs the gRPC addresses of all %d shards - got %d", h.ShardCount, len(h.ShardPeers))
+		}
+		if h.GRPCAddress == "" {
+			return fmt.Errorf("shards fanning out to their peers need grpc_address, for the peers to list their nodes")
+		}
+		for _, peer := range h.ShardPeers {
+			if _, _, err := net.SplitHostPort(peer); err != nil {
+				return fmt.Errorf("invalid shard peer address %q: %v", peer, err)
+			}
+		}
+	}
+	if err := util.ValidateCollectionMode(h.CollectionMode); err != nil {
+		return err
+	}
+	if err := util.ValidateAddressFamily(h.KubeletPreferredAddressFamily); err != nil {
+		return err
+	}
+	if err := util.ValidateKubeletMetricsEndpoint(h.KubeletMetricsEndpoint); err != nil {
+		return err
+	}
+	if h.APIServiceService == "" && (h.APIServiceCAFile != "" || h.ReconcileAPIService) {
+		return fmt.Errorf("apiservice_ca_file and reconcile_apiservice require apiservice_service")
+	}
+	if h.CompletenessThreshold < 0 || h.CompletenessThreshold > 1 {
+		return fmt.Errorf("completeness threshold needs to be between 0 and 1 - %v", h.CompletenessThreshold)
+	}
+	if h.AccessLogMaxSize <= 0 || h.AccessLogMaxBackups < 0 || h.AccessLogMaxAge < 0 {
+		return fmt.Errorf("access log max size needs to be greater than 0 and max backups and max age at least 0 - %d, %d, %d",
+			h.AccessLogMaxSize, h.AccessLogMaxBackups, h.AccessLogMaxAge)
+	}
+	if h.ReadyThreshold < 0 || h.ReadyThreshold > 1 {
+		return fmt.Errorf("ready threshold needs to be between 0 and 1 - %v", h.ReadyThreshold)
+	}
+	if err := util.ValidateControlPlaneMode(h.ControlPlaneNodes); err != nil {
+		return err
+	}
+	if h.ReplaySpeed <= 0 {
+		return fmt.Errorf("replay speed needs to be positive - %v", h.ReplaySpeed)
+	}
+	if h.ReplayConcurrency < 1 {
+		return fmt.Errorf("replay concurrency needs to be at least 1 - %d", h.ReplayConcurrency)
+	}
+	if h.SimulationCycles < 1 {
+		return fmt.Errorf("simulation cycles need to be at least 1 - %d", h.SimulationCycles)
+	}
+	if h.ReplayTarget != "" {
+		if target, err := url.Parse(h.ReplayTarget); err != nil || target.Scheme != "https" || target.Host == "" {
+			return fmt.Errorf("replay target needs to be an https URL - %q", h.ReplayTarget)
+		}
+	}
+	if h.HistoryPoints < 0 {
+		return fmt.Errorf("history points can't be negative - %d", h.HistoryPoints)
+	}
+	if h.PodMetricsMinAge < 0 {
+		return fmt.Errorf("pod metrics min age can't be negative - %s", h.PodMetricsMinAge)
+	}
+	if _, err := labels.Parse(h.NodeSelector); err != nil {
+		return fmt.Errorf("invalid node selector: %v", err)
+	}
+	if h.KubeletStreamingInterval < 0 || h.KubeletStreamingInterval >= h.MetricResolution {
+		return fmt.Errorf("kubelet streaming interval needs to be between 0 and the metric resolution - %s", h.KubeletStreamingInterval)
+	}
+	if h.PushMaxAge < 0 {
+		return fmt.Errorf("push max age can't be negative - %s", h.PushMaxAge)
+	}
+	if h.InformerAPIQPS < 0 || h.AuthAPIQPS < 0 || h.KubeAPIQPS < 0 || h.InformerAPIBurst < 0 || h.AuthAPIBurst < 0 || h.KubeAPIBurst < 0 {
+		return fmt.Errorf("API client QPS and burst can't be negative")
+	}
+	if _, err := ParseNamespaceHistory(h.NamespaceHistory); err != nil {
+		return err
+	}
+	if h.DeletedPodRetention < 0 || h.NotReadyNodeRetention < 0 || h.FilteredNamespaceRetention < 0 {
+		return fmt.Errorf("retention durations can't be negative")
+	}
+	if h.RemoteWriteURL != "" {
+		if endpoint, err := url.Parse(h.RemoteWriteURL); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("remote write URL needs to be an http or https URL - %q", h.RemoteWriteURL)
+		}
+		if h.RemoteWriteTimeout <= 0 {
+			return fmt.Errorf("remote write timeout needs to be positive - %s", h.RemoteWriteTimeout)
+		}
+	}
+	return nil
+}
+
+// GetMaxMetricsDelay returns the age of the latest metrics after which they're stale.
+func (h *HeapsterRunOptions) GetMaxMetricsDelay() time.Duration {
+	if h.MaxMetricsDelay != 0 {
+		return h.MaxMetricsDelay
+	}
+	return 3 * h.MetricResolution
+}
+
+// ParseNamespaceHistory parses the history kept by namespace from the namespace=duration
+// items of --namespace_history.
+func ParseNamespaceHistory(items []string) (map[string]time.Duration, error) {
+	result := make(map[string]time.Duration, len(items))
+	for _, item := range items {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid namespace history %q, expected namespace=duration", item)
+		}
+		history, err := time.ParseDuration(parts[1])
+		if err != nil || history < 0 {
+			return nil, fmt.Errorf("invalid history %q of namespace %s, expected a non-negative duration", parts[1], parts[0])
+		}
+		result[parts[0]] = history
+	}
+	return result, nil
 }
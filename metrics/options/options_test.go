@@ -0,0 +1,52 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNamespaceHistory(t *testing.T) {
+	history, err := ParseNamespaceHistory([]string{"kube-system=0s", "team-a=5m"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"kube-system": 0, "team-a": 5 * time.Minute}, history)
+	_, err = ParseNamespaceHistory([]string{"kube-system"})
+	assert.Error(t, err)
+	_, err = ParseNamespaceHistory([]string{"=5m"})
+	assert.Error(t, err)
+	_, err = ParseNamespaceHistory([]string{"kube-system=-1m"})
+	assert.Error(t, err)
+}
+
+func TestGetMaxMetricsDelay(t *testing.T) {
+	h := &HeapsterRunOptions{MetricResolution: 30 * time.Second}
+	assert.Equal(t, 90*time.Second, h.GetMaxMetricsDelay(), "derived from the resolution")
+	h.MaxMetricsDelay = 5 * time.Minute
+	assert.Equal(t, 5*time.Minute, h.GetMaxMetricsDelay())
+}
+
+func TestValidateMaxMetricsDelay(t *testing.T) {
+	h := NewHeapsterRunOptions()
+	h.MetricResolution = time.Minute
+	h.SlowWindow = time.Minute
+	h.MaxMetricsDelay = 30 * time.Second
+	err := h.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "max metrics delay")
+}
@@ -15,7 +15,11 @@
 package processors
 
 import (
+	"bytes"
+	"fmt"
 	"net/url"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/golang/glog"
@@ -32,6 +36,34 @@ import (
 type NamespaceBasedEnricher struct {
 	store     cache.Store
 	reflector *cache.Reflector
+	// Derives the tenant of a namespace, nil if tenants aren't attributed.
+	tenantTemplate *template.Template
+	// Tenants of the namespaces by name, valid for the resource version they were derived from.
+	tenants map[string]namespaceTenant
+}
+
+type namespaceTenant struct {
+	resourceVersion string
+	tenant          string
+}
+
+// ParseTenantTemplate parses a text/template executed on the namespace objects, e.g.
+// {{ index .Labels "tenant" }}. Missing label values render as empty strings.
+func ParseTenantTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("tenant").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// getTenant returns the tenant of the namespace, empty if it has none.
+func getTenant(tmpl *template.Template, namespace *corev1.Namespace) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, namespace); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
 }
 
 func (this *NamespaceBasedEnricher) Name() string {
@@ -42,9 +74,19 @@ func (this *NamespaceBasedEnricher) Process(batch *core.DataBatch) (*core.DataBa
 	for _, ms := range batch.MetricSets {
 		this.addNamespaceInfo(ms)
 	}
+	this.forgetDeletedTenants()
 	return batch, nil
 }
 
+// forgetDeletedTenants drops the tenants of the namespaces which were deleted.
+func (this *NamespaceBasedEnricher) forgetDeletedTenants() {
+	for name := range this.tenants {
+		if _, exists, err := this.store.GetByKey(name); err == nil && !exists {
+			delete(this.tenants, name)
+		}
+	}
+}
+
 // Adds UID to all namespaced elements.
 func (this *NamespaceBasedEnricher) addNamespaceInfo(metricSet *core.MetricSet) {
 	if metricSetType, found := metricSet.Labels[core.LabelMetricSetType.Key]; found &&
@@ -58,6 +100,9 @@ func (this *NamespaceBasedEnricher) addNamespaceInfo(metricSet *core.MetricSet)
 				namespace, ok := nsObj.(*corev1.Namespace)
 				if ok {
 					metricSet.Labels[core.LabelPodNamespaceUID.Key] = string(namespace.UID)
+					if tenant := this.tenantOf(namespace); tenant != "" {
+						metricSet.Labels[core.LabelTenant.Key] = tenant
+					}
 				} else {
 					glog.Errorf("Wrong namespace store content")
 				}
@@ -72,7 +117,32 @@ func (this *NamespaceBasedEnricher) addNamespaceInfo(metricSet *core.MetricSet)
 	}
 }
 
-func NewNamespaceBasedEnricher(url *url.URL) (*NamespaceBasedEnricher, error) {
+// tenantOf returns the tenant of the namespace, re-deriving it only when the namespace changed.
+func (this *NamespaceBasedEnricher) tenantOf(namespace *corev1.Namespace) string {
+	if this.tenantTemplate == nil {
+		return ""
+	}
+	if cached, found := this.tenants[namespace.Name]; found && cached.resourceVersion == namespace.ResourceVersion {
+		return cached.tenant
+	}
+	tenant, err := getTenant(this.tenantTemplate, namespace)
+	if err != nil {
+		glog.Warningf("Failed to derive tenant of namespace %s: %v", namespace.Name, err)
+	}
+	this.tenants[namespace.Name] = namespaceTenant{resourceVersion: namespace.ResourceVersion, tenant: tenant}
+	return tenant
+}
+
+// NewNamespaceBasedEnricher creates an enricher adding the namespace UIDs to the metric
+// sets and, if the tenant template isn't empty, the tenants of the namespaces.
+func NewNamespaceBasedEnricher(url *url.URL, tenantTemplate string) (*NamespaceBasedEnricher, error) {
+	var tmpl *template.Template
+	if tenantTemplate != "" {
+		var err error
+		if tmpl, err = ParseTenantTemplate(tenantTemplate); err != nil {
+			return nil, err
+		}
+	}
 	kubeConfig, err := kube_config.GetKubeClientConfig(url)
 	if err != nil {
 		return nil, err
@@ -86,7 +156,9 @@ func NewNamespaceBasedEnricher(url *url.URL) (*NamespaceBasedEnricher, error) {
 	go reflector.Run(wait.NeverStop)
 
 	return &NamespaceBasedEnricher{
-		store:     store,
-		reflector: reflector,
+		store:          store,
+		reflector:      reflector,
+		tenantTemplate: tmpl,
+		tenants:        map[string]namespaceTenant{},
 	}, nil
 }
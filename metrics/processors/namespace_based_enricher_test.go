@@ -0,0 +1,74 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNamespaceBasedEnricherTenant(t *testing.T) {
+	tmpl, err := ParseTenantTemplate(`{{ index .Labels "tenant" }}`)
+	require.NoError(t, err)
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:            "ns1",
+		UID:             "uid1",
+		ResourceVersion: "1",
+		Labels:          map[string]string{"tenant": "a"},
+	}}
+	require.NoError(t, store.Add(namespace))
+	require.NoError(t, store.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2", UID: "uid2"}}))
+	enricher := &NamespaceBasedEnricher{store: store, tenantTemplate: tmpl, tenants: map[string]namespaceTenant{}}
+
+	newMetricSet := func(namespace string) *core.MetricSet {
+		return &core.MetricSet{Labels: map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+			core.LabelNamespaceName.Key: namespace,
+		}}
+	}
+	ms1, ms2 := newMetricSet("ns1"), newMetricSet("ns2")
+	_, err = enricher.Process(&core.DataBatch{MetricSets: map[string]*core.MetricSet{"1": ms1, "2": ms2}})
+	require.NoError(t, err)
+	assert.Equal(t, "uid1", ms1.Labels[core.LabelPodNamespaceUID.Key])
+	assert.Equal(t, "a", ms1.Labels[core.LabelTenant.Key])
+	_, found := ms2.Labels[core.LabelTenant.Key]
+	assert.False(t, found, "namespace without tenant label")
+
+	changed := namespace.DeepCopy()
+	changed.ResourceVersion = "2"
+	changed.Labels["tenant"] = "b"
+	require.NoError(t, store.Update(changed))
+	ms1 = newMetricSet("ns1")
+	_, err = enricher.Process(&core.DataBatch{MetricSets: map[string]*core.MetricSet{"1": ms1}})
+	require.NoError(t, err)
+	assert.Equal(t, "b", ms1.Labels[core.LabelTenant.Key], "relabeled namespace")
+	assert.Contains(t, enricher.tenants, "ns1")
+
+	require.NoError(t, store.Delete(changed))
+	_, err = enricher.Process(&core.DataBatch{MetricSets: map[string]*core.MetricSet{}})
+	require.NoError(t, err)
+	assert.NotContains(t, enricher.tenants, "ns1", "tenants of deleted namespaces are forgotten")
+
+	_, err = ParseTenantTemplate(`{{ .Labels`)
+	assert.Error(t, err)
+}
@@ -93,9 +93,13 @@ func NewNodeAutoscalingEnricher(url *url.URL) (*NodeAutoscalingEnricher, error)
 		return nil, err
 	}
 	kubeClient := kube_client.NewForConfigOrDie(kubeConfig)
+	nodeSelector, err := util.ParseNodeSelector(url.Query())
+	if err != nil {
+		return nil, err
+	}
 
 	// watch nodes
-	nodeLister, reflector, _ := util.GetNodeLister(kubeClient)
+	nodeLister, reflector, _ := util.GetNodeLister(kubeClient, nodeSelector)
 
 	return &NodeAutoscalingEnricher{
 		nodeLister: nodeLister,
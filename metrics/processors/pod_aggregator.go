@@ -27,6 +27,7 @@ var LabelsToPopulate = []core.LabelDescriptor{
 	core.LabelPodName,
 	core.LabelNamespaceName,
 	core.LabelPodNamespaceUID,
+	core.LabelTenant,
 	core.LabelHostname,
 	core.LabelHostID,
 }
@@ -119,6 +120,8 @@ func NewPodAggregator() *PodAggregator {
 			skipped[metric.MetricDescriptor.Name] = struct{}{}
 		}
 	}
+	// Intervals between samples don't add up.
+	skipped[core.MetricCpuUsageRateWindow.MetricDescriptor.Name] = struct{}{}
 	return &PodAggregator{
 		skippedMetrics: skipped,
 	}
@@ -75,8 +75,18 @@ func (this *PodBasedEnricher) getPod(namespace, name string) (*corev1.Pod, error
 	return pod, nil
 }
 
+// setPodId labels the metric set with the UID of the pod, unless the source already did.
+// The UID reported with the sample is kept, as the informer may already know a pod
+// recreated under the same name, and the series of the samples are related by UID.
+func setPodId(ms *core.MetricSet, pod *corev1.Pod) {
+	if ms.Labels[core.LabelPodId.Key] == "" {
+		ms.Labels[core.LabelPodId.Key] = string(pod.UID)
+	}
+}
+
 func addContainerInfo(key string, containerMs *core.MetricSet, pod *corev1.Pod, batch *core.DataBatch, newMs map[string]*core.MetricSet) {
-	for _, container := range pod.Spec.Containers {
+	containers := append(util.SidecarContainers(pod), pod.Spec.Containers...)
+	for _, container := range containers {
 		if key == core.PodContainerKey(pod.Namespace, pod.Name, container.Name) {
 			updateContainerResourcesAndLimits(containerMs, container)
 			if _, ok := containerMs.Labels[core.LabelContainerBaseImage.Key]; !ok {
@@ -85,8 +95,9 @@ func addContainerInfo(key string, containerMs *core.MetricSet, pod *corev1.Pod,
 			break
 		}
 	}
+	updateContainerRestartCount(containerMs, pod, containerMs.Labels[core.LabelContainerName.Key])
 
-	containerMs.Labels[core.LabelPodId.Key] = string(pod.UID)
+	setPodId(containerMs, pod)
 	containerMs.Labels[core.LabelLabels.Key] = util.LabelsToString(pod.Labels)
 
 	namespace := containerMs.Labels[core.LabelNamespaceName.Key]
@@ -118,7 +129,7 @@ func addContainerInfo(key string, containerMs *core.MetricSet, pod *corev1.Pod,
 func addPodInfo(key string, podMs *core.MetricSet, pod *corev1.Pod, batch *core.DataBatch, newMs map[string]*core.MetricSet) {
 
 	// Add UID to pod
-	podMs.Labels[core.LabelPodId.Key] = string(pod.UID)
+	setPodId(podMs, pod)
 	podMs.Labels[core.LabelLabels.Key] = util.LabelsToString(pod.Labels)
 
 	// Add cpu/mem requests and limits to containers
@@ -143,6 +154,7 @@ func addPodInfo(key string, podMs *core.MetricSet, pod *corev1.Pod, batch *core.
 					},
 				}
 				updateContainerResourcesAndLimits(containerMs, container)
+				updateContainerRestartCount(containerMs, pod, container.Name)
 				newMs[containerKey] = containerMs
 			}
 		}
@@ -175,6 +187,23 @@ func updateContainerResourcesAndLimits(metricSet *core.MetricSet, container core
 	}
 }
 
+// updateContainerRestartCount records the restart count of the container, or sidecar, in the
+// pod status, so that usage samples spanning a restart can be told apart.
+func updateContainerRestartCount(metricSet *core.MetricSet, pod *corev1.Pod, containerName string) {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses} {
+		for _, status := range statuses {
+			if status.Name == containerName {
+				metricSet.MetricValues[core.MetricRestartCount.Name] = core.MetricValue{
+					IntValue:   int64(status.RestartCount),
+					MetricType: core.MetricCumulative,
+					ValueType:  core.ValueInt64,
+				}
+				return
+			}
+		}
+	}
+}
+
 func intValue(value int64) core.MetricValue {
 	return core.MetricValue{
 		IntValue:   value,
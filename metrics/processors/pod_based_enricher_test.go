@@ -117,6 +117,12 @@ func TestPodEnricher(t *testing.T) {
 				},
 			},
 		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "c1", RestartCount: 2},
+				{Name: "nginx", RestartCount: 1},
+			},
+		},
 	}
 
 	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
@@ -142,6 +148,7 @@ func TestPodEnricher(t *testing.T) {
 		assert.True(t, found)
 		checkRequests(t, containerMs, 100, 555)
 		checkLimits(t, containerMs, 0, 0)
+		assert.Equal(t, int64(2), containerMs.MetricValues[core.MetricRestartCount.Name].IntValue)
 	}
 }
 
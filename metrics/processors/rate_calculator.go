@@ -41,9 +41,18 @@ func (this *RateCalculator) Process(batch *core.DataBatch) (*core.DataBatch, err
 		return batch, nil
 	}
 
+	// Pods recreated under the same name are related to the previous batch by UID, so
+	// that the cumulative usage of the deleted pod is not subtracted from the new one.
+	previous := core.SeriesIndex(this.previousBatch)
 	for key, newMs := range batch.MetricSets {
 
-		if oldMs, found := this.previousBatch.MetricSets[key]; found {
+		if oldMs, found := previous[core.SeriesKey(key, newMs)]; found {
+			if newMs.Cached && newMs.ScrapeTime.Equal(oldMs.ScrapeTime) && newMs.CreateTime.Equal(oldMs.CreateTime) {
+				// The same sample served again from an earlier scrape, of a node scraped less often
+				// than every cycle, keeps the rates computed when it was new.
+				this.keepRates(newMs, oldMs)
+				continue
+			}
 			if !newMs.ScrapeTime.After(oldMs.ScrapeTime) {
 				// New must be strictly after old.
 				glog.V(4).Infof("Skipping rate calculations for %s - new batch (%s) was not scraped strictly after old batch (%s)", key, newMs.ScrapeTime, oldMs.ScrapeTime)
@@ -54,6 +63,12 @@ func (this *RateCalculator) Process(batch *core.DataBatch) (*core.DataBatch, err
 				// Create time for container must be the same.
 				continue
 			}
+			interval := newMs.ScrapeTime.UnixNano() - oldMs.ScrapeTime.UnixNano()
+			if interval <= 0 {
+				// Scrape times too far apart to be represented in nanoseconds.
+				glog.V(4).Infof("Skipping rates for %s - invalid scrape interval between new:%v old:%v", key, newMs.ScrapeTime, oldMs.ScrapeTime)
+				continue
+			}
 
 			for metricName, targetMetric := range this.rateMetricsMapping {
 				metricValNew, foundNew := newMs.MetricValues[metricName]
@@ -61,18 +76,21 @@ func (this *RateCalculator) Process(batch *core.DataBatch) (*core.DataBatch, err
 				if foundNew && foundOld {
 					if metricName == core.MetricCpuUsage.MetricDescriptor.Name {
 						// cpu/usage values are in nanoseconds; we want to have it in millicores (that's why constant 1000 is here).
-						newVal := 1000 * (metricValNew.IntValue - metricValOld.IntValue) /
-							(newMs.ScrapeTime.UnixNano() - oldMs.ScrapeTime.UnixNano())
+						newVal := 1000 * (metricValNew.IntValue - metricValOld.IntValue) / interval
 
 						newMs.MetricValues[targetMetric.MetricDescriptor.Name] = core.MetricValue{
 							ValueType:  core.ValueInt64,
 							MetricType: core.MetricGauge,
 							IntValue:   newVal,
 						}
+						newMs.MetricValues[core.MetricCpuUsageRateWindow.MetricDescriptor.Name] = core.MetricValue{
+							ValueType:  core.ValueInt64,
+							MetricType: core.MetricGauge,
+							IntValue:   interval,
+						}
 
 					} else if targetMetric.MetricDescriptor.ValueType == core.ValueFloat {
-						newVal := 1e9 * float32(metricValNew.IntValue-metricValOld.IntValue) /
-							float32(newMs.ScrapeTime.UnixNano()-oldMs.ScrapeTime.UnixNano())
+						newVal := 1e9 * float32(metricValNew.IntValue-metricValOld.IntValue) / float32(interval)
 
 						newMs.MetricValues[targetMetric.MetricDescriptor.Name] = core.MetricValue{
 							ValueType:  core.ValueFloat,
@@ -90,6 +108,22 @@ func (this *RateCalculator) Process(batch *core.DataBatch) (*core.DataBatch, err
 	return batch, nil
 }
 
+// keepRates copies the rates of the old metric set which the new one lacks.
+func (this *RateCalculator) keepRates(newMs, oldMs *core.MetricSet) {
+	names := []string{core.MetricCpuUsageRateWindow.MetricDescriptor.Name}
+	for _, targetMetric := range this.rateMetricsMapping {
+		names = append(names, targetMetric.MetricDescriptor.Name)
+	}
+	for _, name := range names {
+		if _, found := newMs.MetricValues[name]; found {
+			continue
+		}
+		if value, found := oldMs.MetricValues[name]; found {
+			newMs.MetricValues[name] = value
+		}
+	}
+}
+
 func NewRateCalculator(metrics map[string]core.Metric) *RateCalculator {
 	return &RateCalculator{
 		rateMetricsMapping: metrics,
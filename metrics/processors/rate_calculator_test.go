@@ -15,12 +15,19 @@
 package processors
 
 import (
+	"math"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestRateCalculator(t *testing.T) {
@@ -80,9 +87,9 @@ func TestRateCalculator(t *testing.T) {
 		},
 	}
 
-	procesor := NewRateCalculator(core.RateMetricsMapping)
-	procesor.Process(prev)
-	procesor.Process(current)
+	processor := NewRateCalculator(core.RateMetricsMapping)
+	processor.Process(prev)
+	processor.Process(current)
 
 	ms := current.MetricSets[key]
 	cpuRate := ms.MetricValues[core.MetricCpuUsageRate.Name]
@@ -90,4 +97,113 @@ func TestRateCalculator(t *testing.T) {
 
 	assert.InEpsilon(t, 13, cpuRate.IntValue, 2)
 	assert.InEpsilon(t, 2, txeRate.FloatValue, 0.1)
+	assert.Equal(t, int64(time.Minute), ms.MetricValues[core.MetricCpuUsageRateWindow.Name].IntValue)
+}
+
+func TestRateCalculatorInvalidInterval(t *testing.T) {
+	key := core.PodContainerKey("ns1", "pod1", "c")
+	now := time.Now()
+	container := func(scrapeTime time.Time, usage int64) util.DummyContainer {
+		return util.DummyContainer{Namespace: "ns1", Pod: "pod1", Name: "c",
+			CreateTime: now.Add(-time.Hour), ScrapeTime: scrapeTime, CpuUsage: usage}
+	}
+	prev := util.NewDummyBatch(now, container(now, 1000))
+	// Bogus scrape time 2^64ns after the previous one, which is not representable in nanoseconds.
+	current := util.NewDummyBatch(now.Add(time.Minute), container(now.Add(math.MaxInt64).Add(math.MaxInt64).Add(2), 2000))
+
+	processor := NewRateCalculator(core.RateMetricsMapping)
+	processor.Process(prev)
+	processor.Process(current)
+
+	_, found := current.MetricSets[key].MetricValues[core.MetricCpuUsageRate.Name]
+	assert.False(t, found)
+}
+
+func TestRateCalculatorKeepsRatesOfCachedSamples(t *testing.T) {
+	key := core.PodContainerKey("ns1", "pod1", "c")
+	now := time.Now()
+	container := func(scrapeTime time.Time, usage int64) util.DummyContainer {
+		return util.DummyContainer{Namespace: "ns1", Pod: "pod1", Name: "c",
+			CreateTime: now.Add(-time.Hour), ScrapeTime: scrapeTime, CpuUsage: usage}
+	}
+
+	processor := NewRateCalculator(core.RateMetricsMapping)
+	processor.Process(util.NewDummyBatch(now, container(now, 0)))
+	processor.Process(util.NewDummyBatch(now.Add(time.Minute), container(now.Add(time.Minute), 6e9)))
+	// The node wasn't scraped again, so the previous sample is served once more.
+	cached := util.NewDummyBatch(now.Add(2*time.Minute), container(now.Add(time.Minute), 6e9))
+	cached.MetricSets[key].Cached = true
+	processor.Process(cached)
+
+	ms := cached.MetricSets[key]
+	assert.Equal(t, int64(100), ms.MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+	assert.Equal(t, int64(time.Minute), ms.MetricValues[core.MetricCpuUsageRateWindow.Name].IntValue)
+
+	// A sample scraped again with the same scrape time is not new, and gets no rates.
+	repeated := util.NewDummyBatch(now.Add(3*time.Minute), container(now.Add(time.Minute), 6e9))
+	processor.Process(repeated)
+	_, found := repeated.MetricSets[key].MetricValues[core.MetricCpuUsageRate.Name]
+	assert.False(t, found)
+}
+
+func TestRateCalculatorRecreatedPod(t *testing.T) {
+	key := core.PodContainerKey("ns1", "pod-0", "c")
+	now := time.Now()
+	container := func(timestamp time.Time, uid string, usage int64) util.DummyContainer {
+		// Containers of both pods started within the same second.
+		return util.DummyContainer{Namespace: "ns1", Pod: "pod-0", PodUID: uid, Name: "c",
+			CreateTime: now.Add(-time.Minute).Truncate(time.Second), ScrapeTime: timestamp, CpuUsage: usage}
+	}
+
+	processor := NewRateCalculator(core.RateMetricsMapping)
+	processor.Process(util.NewDummyBatch(now, container(now, "uid1", 30e9)))
+	recreated := util.NewDummyBatch(now.Add(time.Minute), container(now.Add(time.Minute), "uid2", 6e9))
+	processor.Process(recreated)
+	_, found := recreated.MetricSets[key].MetricValues[core.MetricCpuUsageRate.Name]
+	assert.False(t, found, "no rate across pods of the same name")
+
+	next := util.NewDummyBatch(now.Add(2*time.Minute), container(now.Add(2*time.Minute), "uid2", 12e9))
+	processor.Process(next)
+	assert.Equal(t, int64(100), next.MetricSets[key].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+}
+
+// The informer knows the recreated pod while the kubelet still reports the deleted one.
+// Enriching the samples of the deleted pod must not relate them to the recreated pod.
+func TestProcessorChainRecreatedPod(t *testing.T) {
+	key := core.PodContainerKey("ns1", "pod-0", "c")
+	now := time.Now()
+	container := func(timestamp time.Time, uid string, usage int64) util.DummyContainer {
+		return util.DummyContainer{Namespace: "ns1", Pod: "pod-0", PodUID: uid, Name: "c",
+			CreateTime: now.Add(-time.Minute).Truncate(time.Second), ScrapeTime: timestamp, CpuUsage: usage}
+	}
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, pods.Add(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "pod-0", UID: "uid2"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "c"}}},
+	}))
+	enricher, err := NewPodBasedEnricher(v1listers.NewPodLister(pods))
+	require.NoError(t, err)
+	chain := []core.DataProcessor{
+		NewTimestampRegressionGuard(DefaultMaxRegressionHold),
+		NewRateCalculator(core.RateMetricsMapping),
+		enricher,
+		NewPodAggregator(),
+	}
+	process := func(batch *core.DataBatch) *core.DataBatch {
+		for _, processor := range chain {
+			var err error
+			batch, err = processor.Process(batch)
+			require.NoError(t, err)
+		}
+		return batch
+	}
+
+	deleted := process(util.NewDummyBatch(now, container(now, "uid1", 30e9)))
+	assert.Equal(t, "uid1", deleted.MetricSets[key].Labels[core.LabelPodId.Key], "UID reported by the kubelet")
+	recreated := process(util.NewDummyBatch(now.Add(time.Minute), container(now.Add(time.Minute), "uid2", 6e9)))
+	_, found := recreated.MetricSets[key].MetricValues[core.MetricCpuUsageRate.Name]
+	assert.False(t, found, "no rate across pods of the same name")
+
+	next := process(util.NewDummyBatch(now.Add(2*time.Minute), container(now.Add(2*time.Minute), "uid2", 12e9)))
+	assert.Equal(t, int64(100), next.MetricSets[key].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
 }
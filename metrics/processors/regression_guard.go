@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+// Default for how long after scraping previous metric sets may be kept in place of older ones.
+const DefaultMaxRegressionHold = 3 * time.Minute
+
+var timestampRegressions = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "processor",
+		Name:      "timestamp_regressions_total",
+		Help:      "Number of metric sets scraped with older samples than the previous batch, which were replaced by the previous ones.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(timestampRegressions)
+}
+
+// TimestampRegressionGuard keeps the metric sets of the previous batch in place of ones
+// with older samples, e.g. when a kubelet serves an outdated summary from its cache.
+// Previous metric sets are kept for at most maxHold after they were scraped, so that a
+// kubelet whose clock was set back is accepted eventually.
+type TimestampRegressionGuard struct {
+	maxHold       time.Duration
+	previousBatch *core.DataBatch
+}
+
+func (this *TimestampRegressionGuard) Name() string {
+	return "timestamp regression guard"
+}
+
+func (this *TimestampRegressionGuard) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	if this.previousBatch == nil {
+		this.previousBatch = batch
+		return batch, nil
+	}
+
+	previous := core.SeriesIndex(this.previousBatch)
+	for key, newMs := range batch.MetricSets {
+		oldMs, found := previous[core.SeriesKey(key, newMs)]
+		if !found || !newMs.ScrapeTime.Before(oldMs.ScrapeTime) || !newMs.CreateTime.Equal(oldMs.CreateTime) {
+			continue
+		}
+		if batch.Timestamp.Sub(oldMs.ScrapeTime) > this.maxHold {
+			continue
+		}
+		glog.V(2).Infof("Keeping previous metrics for %s - new batch was scraped at %s, before the previous one at %s", key, newMs.ScrapeTime, oldMs.ScrapeTime)
+		timestampRegressions.Inc()
+		// The previous batch may still be served, so the metric set is copied before
+		// later processors modify it.
+		batch.MetricSets[key] = oldMs.Copy()
+	}
+	this.previousBatch = batch
+	return batch, nil
+}
+
+func NewTimestampRegressionGuard(maxHold time.Duration) *TimestampRegressionGuard {
+	return &TimestampRegressionGuard{
+		maxHold: maxHold,
+	}
+}
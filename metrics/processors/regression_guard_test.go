@@ -0,0 +1,57 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+)
+
+func TestTimestampRegressionGuard(t *testing.T) {
+	key := core.PodContainerKey("ns1", "pod1", "c")
+	now := time.Now()
+	container := func(scrapeTime time.Time, usage int64) util.DummyContainer {
+		return util.DummyContainer{Namespace: "ns1", Pod: "pod1", Name: "c",
+			CreateTime: now.Add(-time.Hour), ScrapeTime: scrapeTime, CpuUsage: usage}
+	}
+	getUsage := func(batch *core.DataBatch) int64 {
+		return batch.MetricSets[key].MetricValues[core.MetricCpuUsage.MetricDescriptor.Name].IntValue
+	}
+
+	guard := NewTimestampRegressionGuard(2 * time.Minute)
+	first := util.NewDummyBatch(now, container(now, 2000))
+	guard.Process(first)
+
+	// The kubelet served an older summary.
+	regressed := util.NewDummyBatch(now.Add(time.Minute), container(now.Add(-30*time.Second), 1000))
+	guard.Process(regressed)
+	assert.Equal(t, int64(2000), getUsage(regressed))
+	assert.False(t, regressed.MetricSets[key] == first.MetricSets[key], "metric set not copied")
+
+	// Newer samples are accepted again.
+	current := util.NewDummyBatch(now.Add(2*time.Minute), container(now.Add(2*time.Minute), 3000))
+	guard.Process(current)
+	assert.Equal(t, int64(3000), getUsage(current))
+
+	// The previous metric set is too old to be kept.
+	late := util.NewDummyBatch(now.Add(5*time.Minute), container(now.Add(time.Minute), 500))
+	guard.Process(late)
+	assert.Equal(t, int64(500), getUsage(late))
+}
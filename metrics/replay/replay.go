@@ -0,0 +1,293 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replay sends recorded Metrics API requests to a running metrics-server at their
+// recorded pace, for load testing changes of the serving path with realistic traffic.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Prefix of the requests which are replayed.
+const apiPrefix = "/apis/metrics.k8s.io/"
+
+// Longest line read from a log.
+const maxLineSize = 1024 * 1024
+
+// Request is a recorded request of the Metrics API.
+type Request struct {
+	// Time since the first recorded request.
+	Offset time.Duration
+	// Path and query of the request.
+	URI string
+}
+
+// logEntry holds the fields of both the lines of kubernetes audit logs and of the
+// --access_log_file.
+type logEntry struct {
+	// Audit events.
+	Stage                    string    `json:"stage"`
+	RequestURI               string    `json:"requestURI"`
+	RequestReceivedTimestamp time.Time `json:"requestReceivedTimestamp"`
+	// Access log lines.
+	Time time.Time `json:"time"`
+	Path string    `json:"path"`
+
+	Verb string `json:"verb"`
+}
+
+// ParseLog reads the Metrics API requests of a JSON lines audit log or access log, ordered
+// by when they were received. Audit events are read at their ResponseComplete stage,
+// watches and requests of other APIs are left out.
+func ParseLog(r io.Reader) ([]Request, error) {
+	type timedRequest struct {
+		time time.Time
+		uri  string
+	}
+	var requests []timedRequest
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+		entry := logEntry{}
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, fmt.Errorf("invalid log line %d: %v", line, err)
+		}
+		uri, received := entry.RequestURI, entry.RequestReceivedTimestamp
+		if uri == "" {
+			uri, received = entry.Path, entry.Time
+		}
+		if (entry.Stage != "" && entry.Stage != "ResponseComplete") || entry.Verb == "watch" || !strings.HasPrefix(uri, apiPrefix) {
+			continue
+		}
+		if received.IsZero() {
+			return nil, fmt.Errorf("log line %d has no time", line)
+		}
+		requests = append(requests, timedRequest{time: received, uri: uri})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].time.Before(requests[j].time) })
+	result := make([]Request, len(requests))
+	for i, request := range requests {
+		result[i] = Request{Offset: request.time.Sub(requests[0].time), URI: request.uri}
+	}
+	return result, nil
+}
+
+// Summary describes how the replayed requests were served.
+type Summary struct {
+	Requests int
+	// Number of requests by status code, zero for requests which got no response.
+	Statuses map[int]int
+	// Number of requests sent later than recorded because all workers were busy.
+	Delayed  int
+	Duration time.Duration
+	// Latencies of the requests, sorted.
+	latencies []time.Duration
+}
+
+// Percentile returns the latency which the share of the requests, between 0 and 1, took at most.
+func (this *Summary) Percentile(share float64) time.Duration {
+	if len(this.latencies) == 0 {
+		return 0
+	}
+	index := int(share*float64(len(this.latencies))+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(this.latencies) {
+		index = len(this.latencies) - 1
+	}
+	return this.latencies[index]
+}
+
+// Failed returns the number of requests which got no response or an error status.
+func (this *Summary) Failed() int {
+	failed := 0
+	for status, count := range this.Statuses {
+		if status == 0 || status >= 400 {
+			failed += count
+		}
+	}
+	return failed
+}
+
+// Write prints the request counts and latencies.
+func (this *Summary) Write(w io.Writer) error {
+	statuses := make([]int, 0, len(this.Statuses))
+	for status := range this.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	if _, err := fmt.Fprintf(w, "%d requests in %s, %d delayed\n", this.Requests, this.Duration, this.Delayed); err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		name := fmt.Sprint(status)
+		if status == 0 {
+			name = "no response"
+		}
+		if _, err := fmt.Fprintf(w, "status %s: %d\n", name, this.Statuses[status]); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "latency p50 %s, p90 %s, p99 %s, max %s\n", this.Percentile(0.5), this.Percentile(0.9), this.Percentile(0.99), this.Percentile(1))
+	return err
+}
+
+// PeakRate returns the most requests replayed within a second, speed times faster than recorded.
+func PeakRate(requests []Request, speed float64) int {
+	peak := 0
+	first := 0
+	for last := range requests {
+		for float64(requests[last].Offset-requests[first].Offset)/speed >= float64(time.Second) {
+			first++
+		}
+		if count := last - first + 1; count > peak {
+			peak = count
+		}
+	}
+	return peak
+}
+
+// NewClient returns a client of the config for replaying the requests. Its rate limits let
+// the requests through at their replayed pace, and it records when requests are sent, so
+// that the latencies don't include waiting on the client.
+func NewClient(config *rest.Config, requests []Request, speed float64, concurrency int) (rest.Interface, error) {
+	replayConfig := *config
+	peak := PeakRate(requests, speed)
+	if peak < 1 {
+		peak = 1
+	}
+	replayConfig.QPS = float32(peak)
+	replayConfig.Burst = peak
+	if concurrency > peak {
+		replayConfig.Burst = concurrency
+	}
+	wrap := config.WrapTransport
+	replayConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &sentTransport{rt}
+	}
+	client, err := kube_client.NewForConfig(&replayConfig)
+	if err != nil {
+		return nil, err
+	}
+	return client.Discovery().RESTClient(), nil
+}
+
+type sentKey struct{}
+
+// sentTransport records when the first attempt of a request is sent to the time in its
+// context.
+type sentTransport struct {
+	rt http.RoundTripper
+}
+
+func (this *sentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if sent, ok := req.Context().Value(sentKey{}).(*time.Time); ok && sent.IsZero() {
+		*sent = time.Now()
+	}
+	return this.rt.RoundTrip(req)
+}
+
+// Replayer sends the requests through the client, speed times faster than recorded, with
+// at most concurrency requests in flight.
+type Replayer struct {
+	client      rest.Interface
+	speed       float64
+	concurrency int
+	// Replaced in tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func NewReplayer(client rest.Interface, speed float64, concurrency int) *Replayer {
+	return &Replayer{
+		client:      client,
+		speed:       speed,
+		concurrency: concurrency,
+		now:         time.Now,
+		sleep:       time.Sleep,
+	}
+}
+
+// Run replays the requests and waits for their responses.
+func (this *Replayer) Run(requests []Request) *Summary {
+	summary := &Summary{Statuses: map[int]int{}}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, this.concurrency)
+	start := this.now()
+	for _, request := range requests {
+		due := start.Add(time.Duration(float64(request.Offset) / this.speed))
+		if wait := due.Sub(this.now()); wait > 0 {
+			this.sleep(wait)
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			lock.Lock()
+			summary.Delayed++
+			lock.Unlock()
+			slots <- struct{}{}
+		}
+		wg.Add(1)
+		go func(uri string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			// Set when the request is sent if the client is created by NewClient, so
+			// that waiting on its rate limiter isn't counted.
+			sent := time.Time{}
+			ctx := context.WithValue(context.Background(), sentKey{}, &sent)
+			called := time.Now()
+			status := 0
+			this.client.Get().Context(ctx).RequestURI(uri).Do().StatusCode(&status)
+			if sent.IsZero() {
+				sent = called
+			}
+			latency := time.Since(sent)
+			lock.Lock()
+			defer lock.Unlock()
+			summary.Requests++
+			summary.Statuses[status]++
+			summary.latencies = append(summary.latencies, latency)
+		}(request.URI)
+	}
+	wg.Wait()
+	summary.Duration = this.now().Sub(start)
+	sort.Slice(summary.latencies, func(i, j int) bool { return summary.latencies[i] < summary.latencies[j] })
+	return summary
+}
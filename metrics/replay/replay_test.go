@@ -0,0 +1,171 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const testLog = `
+{"kind":"Event","stage":"RequestReceived","requestURI":"/apis/metrics.k8s.io/v1beta1/nodes","verb":"list","requestReceivedTimestamp":"2018-01-01T00:00:00.000000Z"}
+{"kind":"Event","stage":"ResponseComplete","requestURI":"/apis/metrics.k8s.io/v1beta1/nodes","verb":"list","requestReceivedTimestamp":"2018-01-01T00:00:00.000000Z"}
+{"kind":"Event","stage":"ResponseComplete","requestURI":"/api/v1/nodes","verb":"list","requestReceivedTimestamp":"2018-01-01T00:00:00.500000Z"}
+{"kind":"Event","stage":"ResponseComplete","requestURI":"/apis/metrics.k8s.io/v1beta1/namespaces/ns1/pods?labelSelector=app%3Dweb","verb":"list","requestReceivedTimestamp":"2018-01-01T00:00:02.000000Z"}
+{"time":"2018-01-01T00:00:01Z","verb":"get","path":"/apis/metrics.k8s.io/v1beta1/nodes/node1","status":200}
+{"time":"2018-01-01T00:00:03Z","verb":"watch","path":"/apis/metrics.k8s.io/v1beta1/pods","status":200}
+`
+
+func TestParseLog(t *testing.T) {
+	requests, err := ParseLog(strings.NewReader(testLog))
+	require.NoError(t, err)
+	assert.Equal(t, []Request{
+		{Offset: 0, URI: "/apis/metrics.k8s.io/v1beta1/nodes"},
+		{Offset: time.Second, URI: "/apis/metrics.k8s.io/v1beta1/nodes/node1"},
+		{Offset: 2 * time.Second, URI: "/apis/metrics.k8s.io/v1beta1/namespaces/ns1/pods?labelSelector=app%3Dweb"},
+	}, requests)
+
+	_, err = ParseLog(strings.NewReader("{\n"))
+	assert.Error(t, err)
+	_, err = ParseLog(strings.NewReader(`{"path":"/apis/metrics.k8s.io/v1beta1/nodes"}`))
+	assert.Error(t, err, "no time")
+}
+
+func TestReplayer(t *testing.T) {
+	var lock sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		received = append(received, req.URL.RequestURI())
+		lock.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/missing") {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := kube_client.NewForConfigOrDie(&rest.Config{Host: server.URL}).Discovery().RESTClient()
+
+	replayer := NewReplayer(client, 2, 1)
+	now := time.Now()
+	replayer.now = func() time.Time { return now }
+	replayer.sleep = func(d time.Duration) { now = now.Add(d) }
+	summary := replayer.Run([]Request{
+		{Offset: 0, URI: "/apis/metrics.k8s.io/v1beta1/nodes"},
+		{Offset: 10 * time.Second, URI: "/apis/metrics.k8s.io/v1beta1/nodes/missing"},
+		{Offset: 20 * time.Second, URI: "/apis/metrics.k8s.io/v1beta1/pods?labelSelector=app%3Dweb"},
+	})
+
+	assert.Equal(t, 3, summary.Requests)
+	assert.Equal(t, map[int]int{200: 2, 404: 1}, summary.Statuses)
+	assert.Equal(t, 1, summary.Failed())
+	assert.Equal(t, 10*time.Second, summary.Duration, "twice as fast as recorded")
+	assert.Contains(t, received, "/apis/metrics.k8s.io/v1beta1/pods?labelSelector=app%3Dweb")
+
+	out := &bytes.Buffer{}
+	require.NoError(t, summary.Write(out))
+	assert.Contains(t, out.String(), "3 requests in")
+	assert.Contains(t, out.String(), "status 404: 1\n")
+}
+
+func TestPeakRate(t *testing.T) {
+	requests := []Request{
+		{Offset: 0},
+		{Offset: 500 * time.Millisecond},
+		{Offset: 900 * time.Millisecond},
+		{Offset: 1500 * time.Millisecond},
+		{Offset: 10 * time.Second},
+	}
+	assert.Equal(t, 3, PeakRate(requests, 1))
+	assert.Equal(t, 4, PeakRate(requests, 2))
+	assert.Equal(t, 2, PeakRate(requests, 0.5))
+	assert.Equal(t, 0, PeakRate(nil, 1))
+}
+
+func TestNewClient(t *testing.T) {
+	var lock sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		count++
+		lock.Unlock()
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	// More at once than the default limits of 5 QPS and a burst of 10 let through within
+	// seconds.
+	requests := make([]Request, 50)
+	for i := range requests {
+		requests[i] = Request{Offset: time.Duration(i) * time.Millisecond, URI: "/apis/metrics.k8s.io/v1beta1/nodes"}
+	}
+	wrapped := false
+	client, err := NewClient(&rest.Config{
+		Host: server.URL,
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			wrapped = true
+			return rt
+		},
+	}, requests, 1, 5)
+	require.NoError(t, err)
+	assert.True(t, wrapped, "the config's transport wrapper is kept")
+
+	start := time.Now()
+	summary := NewReplayer(client, 1, 5).Run(requests)
+	assert.Equal(t, map[int]int{200: 50}, summary.Statuses)
+	assert.Equal(t, 50, count)
+	assert.True(t, time.Since(start) < 5*time.Second, "not throttled to the default QPS")
+}
+
+func TestReplayerLatencyAfterRateLimiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	requests := []Request{{URI: "/apis/metrics.k8s.io/v1beta1/nodes"}, {URI: "/apis/metrics.k8s.io/v1beta1/pods"}}
+	client, err := NewClient(&rest.Config{Host: server.URL}, requests, 1, 1)
+	require.NoError(t, err)
+	// Waits a second before letting the second request through.
+	client.(*rest.RESTClient).Throttle = flowcontrol.NewTokenBucketRateLimiter(1, 1)
+
+	summary := NewReplayer(client, 1, 1).Run(requests)
+	assert.Equal(t, map[int]int{200: 2}, summary.Statuses)
+	assert.True(t, summary.Duration >= 900*time.Millisecond, "throttled: %s", summary.Duration)
+	assert.True(t, summary.Percentile(1) < 500*time.Millisecond, "latency without the wait on the limiter: %s", summary.Percentile(1))
+}
+
+func TestSummaryPercentile(t *testing.T) {
+	summary := &Summary{}
+	assert.Equal(t, time.Duration(0), summary.Percentile(0.5))
+	for i := 1; i <= 100; i++ {
+		summary.latencies = append(summary.latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, summary.Percentile(0.5))
+	assert.Equal(t, 99*time.Millisecond, summary.Percentile(0.99))
+	assert.Equal(t, 100*time.Millisecond, summary.Percentile(1))
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/summary"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+// Namespace of the generated pods, profiles don't record namespaces.
+const Namespace = "simulated"
+
+const (
+	// Usage of every generated container.
+	containerCpuMillicores = 100
+	containerMemoryBytes   = 64 * 1024 * 1024
+	// How long before the simulation the nodes, pods and containers started.
+	startedAgo = time.Hour
+)
+
+// Cluster is a generated cluster of the shape of a profile.
+type Cluster struct {
+	Nodes []*corev1.Node
+	Pods  []*corev1.Pod
+	// Whether only cpu and memory metrics are decoded, as in the resources collection mode.
+	ResourcesOnly bool
+	// Pods scheduled to each node.
+	nodePods  map[string][]*corev1.Pod
+	startTime time.Time
+}
+
+// NewCluster generates the nodes and pods of a cluster of the given shape. The nodes get
+// as many pods as in the pods per node, and the containers are spread evenly over the pods.
+// The pods left over are pending.
+func NewCluster(shape ClusterShape) *Cluster {
+	cluster := &Cluster{
+		Nodes:     make([]*corev1.Node, 0, shape.Nodes),
+		Pods:      make([]*corev1.Pod, 0, shape.Pods),
+		nodePods:  make(map[string][]*corev1.Pod, shape.Nodes),
+		startTime: time.Now().Add(-startedAgo),
+	}
+	for i := 0; i < shape.Nodes; i++ {
+		cluster.Nodes = append(cluster.Nodes, cluster.newNode(i))
+	}
+
+	node := 0
+	for _, bucket := range shape.PodsPerNode {
+		for i := 0; i < bucket.Nodes && node < len(cluster.Nodes); i++ {
+			for j := 0; j < bucket.Pods; j++ {
+				cluster.addPod(shape, cluster.Nodes[node].Name)
+			}
+			node++
+		}
+	}
+	for len(cluster.Pods) < shape.Pods {
+		cluster.addPod(shape, "")
+	}
+	return cluster
+}
+
+func (this *Cluster) newNode(index int) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              fmt.Sprintf("node-%d", index),
+			CreationTimestamp: metav1.NewTime(this.startTime),
+		},
+		Spec: corev1.NodeSpec{ExternalID: fmt.Sprintf("node-%d", index)},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func (this *Cluster) addPod(shape ClusterShape, nodeName string) {
+	index := len(this.Pods)
+	containers := shape.Containers / shape.Pods
+	if index < shape.Containers%shape.Pods {
+		containers++
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              fmt.Sprintf("pod-%d", index),
+			Namespace:         Namespace,
+			UID:               types.UID(fmt.Sprintf("pod-%d", index)),
+			CreationTimestamp: metav1.NewTime(this.startTime),
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	for i := 0; i < containers; i++ {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name: fmt.Sprintf("container-%d", i),
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    *resource.NewMilliQuantity(containerCpuMillicores, resource.DecimalSI),
+					corev1.ResourceMemory: *resource.NewQuantity(containerMemoryBytes, resource.BinarySI),
+				},
+			},
+		})
+	}
+	if nodeName != "" {
+		pod.Status.Phase = corev1.PodRunning
+		this.nodePods[nodeName] = append(this.nodePods[nodeName], pod)
+	}
+	this.Pods = append(this.Pods, pod)
+}
+
+// PodLister returns a lister of the pods of the cluster.
+func (this *Cluster) PodLister() v1listers.PodLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range this.Pods {
+		indexer.Add(pod)
+	}
+	return v1listers.NewPodLister(indexer)
+}
+
+// NodeLister returns a lister of the nodes of the cluster.
+func (this *Cluster) NodeLister() v1listers.NodeLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range this.Nodes {
+		indexer.Add(node)
+	}
+	return v1listers.NewNodeLister(indexer)
+}
+
+// GetMetricsSources returns a source for every node, decoding summaries generated at every
+// scrape as the summary source decodes those of the kubelets.
+func (this *Cluster) GetMetricsSources() []core.MetricsSource {
+	sources := make([]core.MetricsSource, 0, len(this.Nodes))
+	for _, node := range this.Nodes {
+		name := node.Name
+		sources = append(sources, summary.NewGeneratedMetricsSource(node, this.ResourcesOnly, func(now time.Time) *stats.Summary {
+			return this.getSummary(name, now)
+		}))
+	}
+	return sources
+}
+
+// getSummary returns the summary of the node at the given time. Every container uses the
+// same CPU and memory, and nodes use what their containers use.
+func (this *Cluster) getSummary(nodeName string, now time.Time) *stats.Summary {
+	start := metav1.NewTime(this.startTime)
+	result := &stats.Summary{
+		Node: stats.NodeStats{NodeName: nodeName, StartTime: start},
+		Pods: []stats.PodStats{},
+	}
+	nodeContainers := 0
+	for _, pod := range this.nodePods[nodeName] {
+		podStats := stats.PodStats{
+			PodRef:    stats.PodReference{Name: pod.Name, Namespace: pod.Namespace, UID: string(pod.UID)},
+			StartTime: start,
+		}
+		for _, container := range pod.Spec.Containers {
+			podStats.Containers = append(podStats.Containers, stats.ContainerStats{
+				Name:      container.Name,
+				StartTime: start,
+				CPU:       this.getCPUStats(1, now),
+				Memory:    getMemoryStats(1, now),
+			})
+		}
+		nodeContainers += len(pod.Spec.Containers)
+		result.Pods = append(result.Pods, podStats)
+	}
+	result.Node.CPU = this.getCPUStats(nodeContainers, now)
+	result.Node.Memory = getMemoryStats(nodeContainers, now)
+	return result
+}
+
+func (this *Cluster) getCPUStats(containers int, now time.Time) *stats.CPUStats {
+	millicores := uint64(containers * containerCpuMillicores)
+	nanoCores := millicores * 1000 * 1000
+	coreNanoSeconds := uint64(now.Sub(this.startTime)) * millicores / 1000
+	return &stats.CPUStats{
+		Time:                 metav1.NewTime(now),
+		UsageNanoCores:       &nanoCores,
+		UsageCoreNanoSeconds: &coreNanoSeconds,
+	}
+}
+
+func getMemoryStats(containers int, now time.Time) *stats.MemoryStats {
+	bytes := uint64(containers * containerMemoryBytes)
+	return &stats.MemoryStats{
+		Time:            metav1.NewTime(now),
+		UsageBytes:      &bytes,
+		WorkingSetBytes: &bytes,
+	}
+}
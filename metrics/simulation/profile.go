@@ -0,0 +1,157 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package simulation runs the scrape and processing pipeline against generated clusters of
+// the shape recorded in configuration profiles, for reproducing reported performance issues
+// without access to the clusters.
+package simulation
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+
+	"github.com/kubernetes-incubator/metrics-server/common/flags"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Value put in place of credentials in profiles.
+const RedactedValue = "<redacted>"
+
+// Profile is the configuration and the shape of a cluster, as written by config export.
+type Profile struct {
+	// Effective value of every flag, including defaults.
+	Flags map[string]string `json:"flags"`
+	// Names of the flags which were explicitly set.
+	SetFlags []string     `json:"setFlags"`
+	Cluster  ClusterShape `json:"cluster"`
+}
+
+// ClusterShape is the size of a cluster, without any names.
+type ClusterShape struct {
+	Nodes      int `json:"nodes"`
+	Pods       int `json:"pods"`
+	Containers int `json:"containers"`
+	// Number of nodes by the number of pods scheduled to them, by ascending pod count.
+	PodsPerNode []PodsPerNodeBucket `json:"podsPerNode"`
+}
+
+type PodsPerNodeBucket struct {
+	Pods  int `json:"pods"`
+	Nodes int `json:"nodes"`
+}
+
+// GetClusterShape returns the shape of the cluster with the given nodes and pods. Pods
+// scheduled to unknown nodes only count as pods.
+func GetClusterShape(nodes []corev1.Node, pods []corev1.Pod) ClusterShape {
+	shape := ClusterShape{
+		Nodes:       len(nodes),
+		Pods:        len(pods),
+		PodsPerNode: []PodsPerNodeBucket{},
+	}
+	podCounts := make(map[string]int, len(nodes))
+	for _, node := range nodes {
+		podCounts[node.Name] = 0
+	}
+	for _, pod := range pods {
+		shape.Containers += len(pod.Spec.Containers)
+		if _, found := podCounts[pod.Spec.NodeName]; found {
+			podCounts[pod.Spec.NodeName]++
+		}
+	}
+
+	nodeCounts := map[int]int{}
+	for _, count := range podCounts {
+		nodeCounts[count]++
+	}
+	for pods, nodes := range nodeCounts {
+		shape.PodsPerNode = append(shape.PodsPerNode, PodsPerNodeBucket{Pods: pods, Nodes: nodes})
+	}
+	sort.Slice(shape.PodsPerNode, func(i, j int) bool { return shape.PodsPerNode[i].Pods < shape.PodsPerNode[j].Pods })
+	return shape
+}
+
+// ReadProfile reads a YAML profile and checks that its cluster shape is consistent.
+func ReadProfile(r io.Reader) (*Profile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	profile := &Profile{}
+	if err := yaml.Unmarshal(data, profile); err != nil {
+		return nil, fmt.Errorf("invalid profile: %v", err)
+	}
+	if err := profile.Cluster.validate(); err != nil {
+		return nil, fmt.Errorf("invalid cluster shape: %v", err)
+	}
+	return profile, nil
+}
+
+func (this *ClusterShape) validate() error {
+	if this.Nodes < 0 || this.Pods < 0 || this.Containers < 0 {
+		return fmt.Errorf("negative count")
+	}
+	nodes, pods := 0, 0
+	for _, bucket := range this.PodsPerNode {
+		if bucket.Nodes < 0 || bucket.Pods < 0 {
+			return fmt.Errorf("negative count in the pods per node")
+		}
+		nodes += bucket.Nodes
+		pods += bucket.Nodes * bucket.Pods
+	}
+	if nodes > this.Nodes {
+		return fmt.Errorf("%d nodes in the pods per node, out of %d", nodes, this.Nodes)
+	}
+	if pods > this.Pods {
+		return fmt.Errorf("%d pods scheduled to nodes, out of %d", pods, this.Pods)
+	}
+	return nil
+}
+
+// Apply sets the flags which were explicitly set in the profile, and returns the names of
+// those it left out. Flags already set on the command line are kept. Redacted credentials,
+// the anonymized sources and sinks, and flags unknown to this version are left out.
+func (this *Profile) Apply(fs *pflag.FlagSet) ([]string, error) {
+	names := append([]string{}, this.SetFlags...)
+	sort.Strings(names)
+	skipped := []string{}
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil {
+			skipped = append(skipped, name)
+			continue
+		}
+		if f.Changed {
+			continue
+		}
+		value, found := this.Flags[name]
+		if _, uris := f.Value.(*flags.Uris); uris || !found || value == RedactedValue {
+			skipped = append(skipped, name)
+			continue
+		}
+		if strings.HasSuffix(f.Value.Type(), "Slice") {
+			// Slices are written in brackets, but set from their comma separated items.
+			value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		}
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value of flag %s: %v", name, err)
+		}
+	}
+	return skipped, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/common/flags"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testProfile = `
+version: v0.2.1
+flags:
+  metric_resolution: 30s
+  label_seperator: ","
+  list_excluded_priority_classes: '[low,batch]'
+  max_procs: "4"
+  sink: 'influxdb:http://redacted'
+  api_token: <redacted>
+  removed_flag: "true"
+setFlags:
+- metric_resolution
+- list_excluded_priority_classes
+- max_procs
+- sink
+- api_token
+- removed_flag
+cluster:
+  nodes: 3
+  pods: 5
+  containers: 11
+  podsPerNode:
+  - pods: 0
+    nodes: 1
+  - pods: 2
+    nodes: 2
+`
+
+func TestGetClusterShape(t *testing.T) {
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "n1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "n2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "n3"}},
+	}
+	pods := []corev1.Pod{
+		{Spec: corev1.PodSpec{NodeName: "n1", Containers: make([]corev1.Container, 2)}},
+		{Spec: corev1.PodSpec{NodeName: "n1", Containers: make([]corev1.Container, 1)}},
+		{Spec: corev1.PodSpec{NodeName: "n2", Containers: make([]corev1.Container, 1)}},
+		{Spec: corev1.PodSpec{NodeName: "gone", Containers: make([]corev1.Container, 1)}},
+		{Spec: corev1.PodSpec{Containers: make([]corev1.Container, 1)}},
+	}
+	assert.Equal(t, ClusterShape{
+		Nodes:       3,
+		Pods:        5,
+		Containers:  6,
+		PodsPerNode: []PodsPerNodeBucket{{Pods: 0, Nodes: 1}, {Pods: 1, Nodes: 1}, {Pods: 2, Nodes: 1}},
+	}, GetClusterShape(nodes, pods))
+}
+
+func TestReadProfile(t *testing.T) {
+	profile, err := ReadProfile(strings.NewReader(testProfile))
+	require.NoError(t, err)
+	assert.Equal(t, ClusterShape{
+		Nodes:       3,
+		Pods:        5,
+		Containers:  11,
+		PodsPerNode: []PodsPerNodeBucket{{Pods: 0, Nodes: 1}, {Pods: 2, Nodes: 2}},
+	}, profile.Cluster)
+	assert.Equal(t, "30s", profile.Flags["metric_resolution"])
+
+	_, err = ReadProfile(strings.NewReader("cluster: [\n"))
+	assert.Error(t, err)
+	_, err = ReadProfile(strings.NewReader("cluster: {nodes: 1, pods: 2, podsPerNode: [{pods: 1, nodes: 2}]}"))
+	assert.Error(t, err, "more nodes in the buckets than in the cluster")
+	_, err = ReadProfile(strings.NewReader("cluster: {nodes: 2, pods: 3, podsPerNode: [{pods: 2, nodes: 2}]}"))
+	assert.Error(t, err, "more pods scheduled than in the cluster")
+	_, err = ReadProfile(strings.NewReader("cluster: {nodes: -1}"))
+	assert.Error(t, err)
+}
+
+func TestApplyProfile(t *testing.T) {
+	profile, err := ReadProfile(strings.NewReader(testProfile))
+	require.NoError(t, err)
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	resolution := fs.Duration("metric_resolution", 0, "")
+	excluded := fs.StringSlice("list_excluded_priority_classes", []string{}, "")
+	maxProcs := fs.Int("max_procs", 0, "")
+	sinks := flags.Uris{}
+	fs.Var(&sinks, "sink", "")
+	token := fs.String("api_token", "", "")
+	require.NoError(t, fs.Parse([]string{"--max_procs=2"}))
+
+	skipped, err := profile.Apply(fs)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api_token", "removed_flag", "sink"}, skipped)
+	assert.Equal(t, "30s", resolution.String())
+	assert.Equal(t, []string{"low", "batch"}, *excluded)
+	assert.Equal(t, 2, *maxProcs, "set on the command line")
+	assert.Empty(t, sinks)
+	assert.Empty(t, *token)
+
+	profile.Flags["metric_resolution"] = "often"
+	_, err = profile.Apply(pflag.NewFlagSet("test", pflag.ContinueOnError))
+	assert.NoError(t, err, "unknown flags are skipped")
+	fs = pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.Duration("metric_resolution", 0, "")
+	_, err = profile.Apply(fs)
+	assert.Error(t, err)
+}
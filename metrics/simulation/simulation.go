@@ -0,0 +1,104 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulation
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+// Simulation scrapes, processes and exports batches in cycles, as the manager does.
+type Simulation struct {
+	source     core.MetricsSource
+	processors []core.DataProcessor
+	sink       core.DataSink
+}
+
+func NewSimulation(source core.MetricsSource, processors []core.DataProcessor, sink core.DataSink) *Simulation {
+	return &Simulation{
+		source:     source,
+		processors: processors,
+		sink:       sink,
+	}
+}
+
+// Cycle is how long the steps of a cycle took.
+type Cycle struct {
+	Scrape  time.Duration
+	Process time.Duration
+	Export  time.Duration
+	// Metric sets of the processed batch.
+	MetricSets int
+	// Bytes of allocated heap objects after the cycle.
+	HeapBytes uint64
+}
+
+// Report describes the cycles of a simulation.
+type Report struct {
+	Cycles []Cycle
+}
+
+// Run runs the given number of cycles. The cycles run back to back rather than every
+// resolution, but scrape the metrics of the resolution before them, as the manager does.
+func (this *Simulation) Run(cycles int, resolution time.Duration) *Report {
+	report := &Report{Cycles: make([]Cycle, 0, cycles)}
+	for i := 0; i < cycles; i++ {
+		cycle := Cycle{}
+		end := time.Now()
+		batch := this.source.ScrapeMetrics(end.Add(-resolution), end)
+		cycle.Scrape = time.Since(end)
+
+		processed := time.Now()
+		var err error
+		for _, processor := range this.processors {
+			if batch, err = processor.Process(batch); err != nil {
+				// Not exported, as by the manager.
+				glog.Errorf("Error in processor %s: %v", processor.Name(), err)
+				break
+			}
+		}
+		cycle.Process = time.Since(processed)
+
+		if err == nil {
+			cycle.MetricSets = len(batch.MetricSets)
+			exported := time.Now()
+			this.sink.ExportData(batch)
+			cycle.Export = time.Since(exported)
+		}
+
+		memStats := runtime.MemStats{}
+		runtime.ReadMemStats(&memStats)
+		cycle.HeapBytes = memStats.HeapAlloc
+		report.Cycles = append(report.Cycles, cycle)
+	}
+	return report
+}
+
+// Write writes a line for every cycle.
+func (this *Report) Write(w io.Writer) error {
+	for i, cycle := range this.Cycles {
+		if _, err := fmt.Fprintf(w, "cycle %d: scrape %s, process %s, export %s, %d metric sets, %d heap bytes\n",
+			i+1, cycle.Scrape, cycle.Process, cycle.Export, cycle.MetricSets, cycle.HeapBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
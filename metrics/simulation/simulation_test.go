@@ -0,0 +1,98 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulation
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/processors"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var testShape = ClusterShape{
+	Nodes:       3,
+	Pods:        5,
+	Containers:  11,
+	PodsPerNode: []PodsPerNodeBucket{{Pods: 0, Nodes: 1}, {Pods: 2, Nodes: 2}},
+}
+
+func TestNewCluster(t *testing.T) {
+	cluster := NewCluster(testShape)
+	nodes := []corev1.Node{}
+	for _, node := range cluster.Nodes {
+		nodes = append(nodes, *node)
+	}
+	pods := []corev1.Pod{}
+	for _, pod := range cluster.Pods {
+		pods = append(pods, *pod)
+	}
+	assert.Equal(t, testShape, GetClusterShape(nodes, pods))
+	assert.Len(t, cluster.Pods[0].Spec.Containers, 3, "containers spread over the pods")
+	assert.Equal(t, "", cluster.Pods[4].Spec.NodeName)
+	assert.Equal(t, corev1.PodPending, cluster.Pods[4].Status.Phase)
+
+	listed, err := cluster.PodLister().Pods(Namespace).List(labels.Everything())
+	require.NoError(t, err)
+	assert.Len(t, listed, 5)
+	listedNodes, err := cluster.NodeLister().List(labels.Everything())
+	require.NoError(t, err)
+	assert.Len(t, listedNodes, 3)
+	assert.Len(t, cluster.GetMetricsSources(), 3)
+
+	assert.Empty(t, NewCluster(ClusterShape{}).Nodes)
+}
+
+func TestSimulation(t *testing.T) {
+	cluster := NewCluster(testShape)
+	source, err := sources.NewSourceManager(cluster, time.Minute, bus.NewBus(bus.DefaultSubscriberBufferSize))
+	require.NoError(t, err)
+	sink := metricsink.NewMetricSink(time.Minute, time.Minute, []string{})
+	simulation := NewSimulation(source, []core.DataProcessor{
+		processors.NewRateCalculator(core.RateMetricsMapping),
+		processors.NewPodAggregator(),
+	}, sink)
+
+	report := simulation.Run(2, time.Minute)
+	require.Len(t, report.Cycles, 2)
+	// 3 nodes, the 4 scheduled pods and their 9 containers.
+	assert.Equal(t, 16, report.Cycles[1].MetricSets)
+	assert.NotZero(t, report.Cycles[1].HeapBytes)
+
+	batch := sink.GetLatestDataBatch()
+	require.NotNil(t, batch)
+	node := batch.MetricSets[core.NodeKey("node-1")]
+	require.NotNil(t, node)
+	// Rates are rounded down, for every container of the pods.
+	assert.InDelta(t, 500, node.MetricValues[core.MetricCpuUsageRate.Name].IntValue, 1, "5 containers of 100m")
+	assert.Equal(t, int64(5*containerMemoryBytes), node.MetricValues[core.MetricMemoryWorkingSet.Name].IntValue)
+	pod := batch.MetricSets[core.PodKey(Namespace, "pod-0")]
+	require.NotNil(t, pod)
+	assert.InDelta(t, 300, pod.MetricValues[core.MetricCpuUsageRate.Name].IntValue, 3)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, report.Write(out))
+	assert.Contains(t, out.String(), "cycle 2: scrape ")
+	assert.Contains(t, out.String(), ", 16 metric sets, ")
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package anonymize replaces the names of namespaces and pods in the data exported by the
+// sinks, for exporting usage to shared analytics platforms. The Metrics API is served
+// from the metric sink, which always keeps the names.
+package anonymize
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+)
+
+// Sink URI options configuring the anonymization, which sinks don't otherwise interpret.
+var Options = []string{"anonymize", "anonymizeKeyFile"}
+
+// Number of hex digits of the hash names are replaced by.
+const hashLength = 16
+
+// Anonymizer replaces names by a keyed hash of them, which stays the same for the same
+// name, so that the usage of a pod or namespace can still be followed over time. A nil
+// Anonymizer keeps the names.
+type Anonymizer struct {
+	key []byte
+}
+
+// NewAnonymizer returns the anonymizer configured by the sink URI options, nil if names
+// are kept. With anonymize=true names are hashed with SHA-256, keyed by the contents of
+// anonymizeKeyFile, which is required: without a key, names could be recovered by
+// hashing guesses.
+func NewAnonymizer(opts url.Values) (*Anonymizer, error) {
+	if len(opts["anonymize"]) < 1 {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(opts["anonymize"][0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid anonymize: %v", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	if len(opts["anonymizeKeyFile"]) < 1 || opts["anonymizeKeyFile"][0] == "" {
+		return nil, fmt.Errorf("anonymize requires an anonymizeKeyFile, names hashed without a key can be recovered by hashing guesses")
+	}
+	return NewKeyedAnonymizer(opts["anonymizeKeyFile"][0])
+}
+
+// NewKeyedAnonymizer returns an anonymizer hashing names keyed by the contents of the file,
+// nil if the file name is empty.
+func NewKeyedAnonymizer(keyFile string) (*Anonymizer, error) {
+	if keyFile == "" {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("anonymize key file %s is empty", keyFile)
+	}
+	return &Anonymizer{key: key}, nil
+}
+
+// Name returns the replacement of the name. Empty names are kept.
+func (this *Anonymizer) Name(name string) string {
+	if this == nil || name == "" {
+		return name
+	}
+	mac := hmac.New(sha256.New, this.key)
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))[:hashLength]
+}
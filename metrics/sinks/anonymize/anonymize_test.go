@@ -0,0 +1,63 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anonymize
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymizer(t *testing.T) {
+	anonymizer, err := NewAnonymizer(url.Values{})
+	require.NoError(t, err)
+	assert.Nil(t, anonymizer)
+	assert.Equal(t, "kube-system", anonymizer.Name("kube-system"), "nil keeps names")
+
+	_, err = NewAnonymizer(url.Values{"anonymize": {"true"}})
+	assert.Error(t, err, "a key is required")
+	anonymizer, err = NewAnonymizer(url.Values{"anonymize": {"false"}})
+	require.NoError(t, err)
+	assert.Nil(t, anonymizer)
+
+	keyFile, err := ioutil.TempFile("", "anonymize")
+	require.NoError(t, err)
+	defer os.Remove(keyFile.Name())
+	keyFile.WriteString("secret\n")
+	keyFile.Close()
+	keyed, err := NewAnonymizer(url.Values{"anonymize": {"true"}, "anonymizeKeyFile": {keyFile.Name()}})
+	require.NoError(t, err)
+	name := keyed.Name("kube-system")
+	assert.Len(t, name, hashLength)
+	assert.Equal(t, name, keyed.Name("kube-system"))
+	assert.NotEqual(t, name, keyed.Name("default"))
+	assert.Equal(t, "", keyed.Name(""))
+	assert.NotEqual(t, name, (&Anonymizer{}).Name("kube-system"), "keyed hash")
+	fromKeyFile, err := NewKeyedAnonymizer(keyFile.Name())
+	require.NoError(t, err)
+	assert.Equal(t, name, fromKeyFile.Name("kube-system"))
+	anonymizer, err = NewKeyedAnonymizer("")
+	require.NoError(t, err)
+	assert.Nil(t, anonymizer, "names are kept without a key file")
+
+	_, err = NewAnonymizer(url.Values{"anonymize": {"maybe"}})
+	assert.Error(t, err)
+	_, err = NewAnonymizer(url.Values{"anonymize": {"true"}, "anonymizeKeyFile": {"/nonexistent"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noexporters
+// +build !noexporters
+
+package sinks
+
+import (
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sinks/anonymize"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sinks/parquet"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sinks/remotewrite"
+)
+
+func init() {
+	registerExporter("parquet", parquet.NewParquetSink)
+	remoteWriteFactory = newRemoteWriteSink
+}
+
+func newRemoteWriteSink(config RemoteWriteConfig) (core.DataSink, error) {
+	anonymizer, err := anonymize.NewKeyedAnonymizer(config.AnonymizeKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return remotewrite.NewRemoteWriteSink(config.URL, config.TLS, config.BearerTokenFile, config.Timeout, anonymizer)
+}
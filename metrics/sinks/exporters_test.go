@@ -0,0 +1,42 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noexporters
+// +build !noexporters
+
+package sinks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportersRegistered(t *testing.T) {
+	assert.Contains(t, exporterFactories, "parquet")
+	assert.NotNil(t, remoteWriteFactory)
+}
+
+func TestBuildRemoteWrite(t *testing.T) {
+	factory := NewSinkFactory()
+	sink, err := factory.BuildRemoteWrite(RemoteWriteConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, sink, "not configured")
+	sink, err = factory.BuildRemoteWrite(RemoteWriteConfig{URL: "https://prometheus/api/v1/write", Timeout: time.Second})
+	assert.NoError(t, err)
+	assert.NotNil(t, sink)
+	_, err = factory.BuildRemoteWrite(RemoteWriteConfig{URL: "https://prometheus/api/v1/write", Timeout: time.Second, AnonymizeKeyFile: "/nonexistent"})
+	assert.Error(t, err)
+}
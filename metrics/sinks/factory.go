@@ -16,30 +16,77 @@ package sinks
 
 import (
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/metrics-server/common/flags"
 	"github.com/kubernetes-incubator/metrics-server/metrics/core"
 	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"k8s.io/client-go/transport"
 )
 
+// Creates the sink of an exporter from the value of its sink URI.
+type exporterFactory func(uri *url.URL) (core.DataSink, error)
+
+// Sinks exporting the metrics out of the process, by the key of their sink URI. Each is
+// registered by a file which can be left out of the build with a tag.
+var exporterFactories = map[string]exporterFactory{}
+
+// registerExporter makes the exporter available as a sink. It's meant to be called from
+// init functions.
+func registerExporter(key string, factory exporterFactory) {
+	if _, found := exporterFactories[key]; found {
+		panic(fmt.Sprintf("exporter %q registered twice", key))
+	}
+	exporterFactories[key] = factory
+}
+
+// RemoteWriteConfig configures the export of the node and pod usage to a Prometheus remote
+// write endpoint, set by the --remote-write flags. Nothing is exported if the URL is empty.
+type RemoteWriteConfig struct {
+	URL              string
+	TLS              transport.TLSConfig
+	BearerTokenFile  string
+	Timeout          time.Duration
+	AnonymizeKeyFile string
+}
+
+// Creates the remote write exporter. It's registered by the file of the exporters, and nil
+// in builds without them.
+var remoteWriteFactory func(config RemoteWriteConfig) (core.DataSink, error)
+
 type SinkFactory struct {
 }
 
 func (this *SinkFactory) Build(uri flags.Uri) (core.DataSink, error) {
 	switch uri.Key {
 	case "metric":
-		return metricsink.NewMetricSink(140*time.Second, 15*time.Minute, []string{
+		return metricsink.NewMetricSink(140*time.Second, metricsink.LongStoreDuration, []string{
 			core.MetricCpuUsageRate.MetricDescriptor.Name,
-			core.MetricMemoryUsage.MetricDescriptor.Name}), nil
+			core.MetricMemoryUsage.MetricDescriptor.Name,
+			core.MetricMemoryWorkingSet.MetricDescriptor.Name}), nil
 	default:
+		if factory, found := exporterFactories[uri.Key]; found {
+			return factory(&uri.Val)
+		}
 		return nil, fmt.Errorf("Sink not recognized: %s", uri.Key)
 	}
 }
 
-func (this *SinkFactory) BuildAll(uris flags.Uris) (*metricsink.MetricSink, []core.DataSink) {
-	result := make([]core.DataSink, 0, len(uris))
+// BuildRemoteWrite returns the remote write exporter configured, nil if none is.
+func (this *SinkFactory) BuildRemoteWrite(config RemoteWriteConfig) (core.DataSink, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+	if remoteWriteFactory == nil {
+		return nil, fmt.Errorf("--remote-write-url is not supported, the binary was built with the noexporters tag")
+	}
+	return remoteWriteFactory(config)
+}
+
+func (this *SinkFactory) BuildAll(uris flags.Uris, remoteWrite RemoteWriteConfig) (*metricsink.MetricSink, []core.DataSink) {
+	result := make([]core.DataSink, 0, len(uris)+1)
 	var metric *metricsink.MetricSink
 	for _, uri := range uris {
 		sink, err := this.Build(uri)
@@ -52,8 +99,13 @@ func (this *SinkFactory) BuildAll(uris flags.Uris) (*metricsink.MetricSink, []co
 		}
 		result = append(result, sink)
 	}
+	if sink, err := this.BuildRemoteWrite(remoteWrite); err != nil {
+		glog.Errorf("Failed to create remote write sink: %v", err)
+	} else if sink != nil {
+		result = append(result, sink)
+	}
 
-	if len([]flags.Uri(uris)) != 0 && len(result) == 0 {
+	if (len([]flags.Uri(uris)) != 0 || remoteWrite.URL != "") && len(result) == 0 {
 		glog.Fatal("No available sink to use")
 	}
 
@@ -0,0 +1,66 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var containerCpuThrottledDesc = prometheus.NewDesc(
+	prometheus.BuildFQName("heapster", "container", "cpu_throttled_seconds_total"),
+	"Cumulative time the container was throttled by the CFS quota in seconds.",
+	[]string{"namespace", "pod", "container"},
+	nil,
+)
+
+// Exposes selected metrics from the latest collected DataBatch on the
+// Prometheus endpoint of the server.
+type containerMetricsCollector struct {
+	metricSink *MetricSink
+}
+
+func NewContainerMetricsCollector(metricSink *MetricSink) prometheus.Collector {
+	return &containerMetricsCollector{
+		metricSink: metricSink,
+	}
+}
+
+func (this *containerMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- containerCpuThrottledDesc
+}
+
+func (this *containerMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	batch := this.metricSink.GetLatestDataBatch()
+	if batch == nil {
+		return
+	}
+	for _, ms := range batch.MetricSets {
+		if ms.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePodContainer {
+			continue
+		}
+		throttled, found := ms.MetricValues[core.MetricCpuThrottledTime.Name]
+		if !found {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(containerCpuThrottledDesc, prometheus.CounterValue,
+			float64(throttled.IntValue)/float64(time.Second),
+			ms.Labels[core.LabelNamespaceName.Key],
+			ms.Labels[core.LabelPodName.Key],
+			ms.Labels[core.LabelContainerName.Key])
+	}
+}
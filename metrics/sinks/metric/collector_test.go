@@ -0,0 +1,74 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+func TestContainerMetricsCollector(t *testing.T) {
+	metricSink := NewMetricSink(time.Minute, time.Minute, []string{})
+	metricSink.ExportData(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns1", "pod1", "c1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+					core.LabelNamespaceName.Key: "ns1",
+					core.LabelPodName.Key:       "pod1",
+					core.LabelContainerName.Key: "c1",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuThrottledTime.Name: {
+						ValueType:  core.ValueInt64,
+						MetricType: core.MetricCumulative,
+						IntValue:   int64(1500 * time.Millisecond),
+					},
+				},
+			},
+			core.PodKey("ns1", "pod1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuThrottledTime.Name: {IntValue: 100},
+				},
+			},
+		},
+	})
+
+	ch := make(chan prometheus.Metric, 10)
+	NewContainerMetricsCollector(metricSink).Collect(ch)
+	close(ch)
+
+	var collected []prometheus.Metric
+	for m := range ch {
+		collected = append(collected, m)
+	}
+	if !assert.Len(t, collected, 1) {
+		return
+	}
+	out := &dto.Metric{}
+	assert.NoError(t, collected[0].Write(out))
+	assert.Equal(t, 1.5, out.GetCounter().GetValue())
+	assert.Len(t, out.GetLabel(), 3)
+}
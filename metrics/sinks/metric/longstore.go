@@ -0,0 +1,26 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nohistory
+// +build !nohistory
+
+package metric
+
+import (
+	"time"
+)
+
+// LongStoreDuration is how long the long-stored metrics are kept by the metric sink, for
+// the averaged windows and the time selection of the Metrics API.
+const LongStoreDuration = 15 * time.Minute
@@ -19,8 +19,38 @@ import (
 	"time"
 
 	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+var (
+	longStoreBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "metric_sink",
+			Name:      "long_store_bytes",
+			Help:      "Estimated memory used by the values in the long store in bytes.",
+		},
+	)
+
+	longStoreBudgetEvictions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "metric_sink",
+			Name:      "long_store_budget_evictions_total",
+			Help:      "Number of long store entries dropped early to stay within the memory budget.",
+		},
+	)
+)
+
+// DefaultMaxDelay is the age of the latest batch after which it's too old to be served,
+// three times the default metric resolution.
+const DefaultMaxDelay = 3 * time.Minute
+
+func init() {
+	prometheus.MustRegister(longStoreBytes)
+	prometheus.MustRegister(longStoreBudgetEvictions)
+}
+
 // A simple in-memory storage for metrics. It divides metrics into 2 categories
 // * metrics that need to be stored for couple minutes.
 // * metrics that need to be stored for longer time (15 min, 1 hour).
@@ -38,6 +68,14 @@ type MetricSink struct {
 	shortStore []*core.DataBatch
 	// Memory-efficient long/mid term storage for metrics.
 	longStore []*multimetricStore
+
+	// Drops the points of deleted pods, not ready nodes and filtered namespaces early.
+	retention *RetentionPolicy
+	// Estimated size in bytes the long store is kept under by dropping its oldest
+	// entries early, zero if unlimited.
+	longStoreBudget int64
+	// Age of the latest batch after which it's too old to be served.
+	maxDelay time.Duration
 }
 
 // Stores values of a single metrics for different MetricSets.
@@ -49,17 +87,43 @@ type multimetricStore struct {
 	timestamp time.Time
 	// Metric name to int64store with metric values.
 	store map[string]int64Store
+	// Series keys of the MetricSets whose series key differs from their key.
+	series map[string]string
+}
+
+// seriesKey returns the series key of the MetricSet stored under key.
+func (this *multimetricStore) seriesKey(key string) string {
+	if series, found := this.series[key]; found {
+		return series
+	}
+	return key
+}
+
+// Rough memory cost of a stored value in the long store, including the map entry and key.
+const bytesPerLongStoreValue = 96
+
+// size estimates the memory used by the store in bytes.
+func (this *multimetricStore) size() int64 {
+	values := 0
+	for _, substore := range this.store {
+		values += len(substore)
+	}
+	return int64(values) * bytesPerLongStoreValue
 }
 
 func buildMultimetricStore(metrics []string, batch *core.DataBatch) *multimetricStore {
 	store := multimetricStore{
 		timestamp: batch.Timestamp,
 		store:     make(map[string]int64Store, len(metrics)),
+		series:    map[string]string{},
 	}
 	for _, metric := range metrics {
 		store.store[metric] = make(int64Store, len(batch.MetricSets))
 	}
 	for key, ms := range batch.MetricSets {
+		if series := core.SeriesKey(key, ms); series != key {
+			store.series[key] = series
+		}
 		for _, metric := range metrics {
 			if metricValue, found := ms.MetricValues[metric]; found {
 				metricstore := store.store[metric]
@@ -84,9 +148,58 @@ func (this *MetricSink) ExportData(batch *core.DataBatch) {
 
 	now := time.Now()
 	// TODO: add sorting
-	this.longStore = append(popOldStore(this.longStore, now.Add(-this.longStoreDuration)),
-		buildMultimetricStore(this.longStoreMetrics, batch))
+	if this.longStoreDuration > 0 {
+		this.longStore = append(popOldStore(this.longStore, now.Add(-this.longStoreDuration)),
+			buildMultimetricStore(this.longStoreMetrics, batch))
+	}
 	this.shortStore = append(popOld(this.shortStore, now.Add(-this.shortStoreDuration)), batch)
+	this.compact(now)
+	this.pruneHistory(now, batch)
+	this.enforceLongStoreBudget()
+}
+
+// SetLongStoreBudget limits the estimated memory used by the long store. Once exceeded,
+// the oldest entries are dropped before the long store duration has passed, shortening
+// the windows that can be averaged over. Zero disables the limit.
+func (this *MetricSink) SetLongStoreBudget(bytes int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.longStoreBudget = bytes
+}
+
+// SetMaxDelay sets the age of the latest batch after which it's too old to be served,
+// DefaultMaxDelay by default.
+func (this *MetricSink) SetMaxDelay(maxDelay time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.maxDelay = maxDelay
+}
+
+// MaxDelay returns the age of the latest batch after which it's too old to be served.
+func (this *MetricSink) MaxDelay() time.Duration {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.maxDelay
+}
+
+func (this *MetricSink) enforceLongStoreBudget() {
+	var total int64
+	sizes := make([]int64, len(this.longStore))
+	for i, store := range this.longStore {
+		sizes[i] = store.size()
+		total += sizes[i]
+	}
+	// The latest entry is always kept.
+	dropped := 0
+	for this.longStoreBudget > 0 && total > this.longStoreBudget && dropped < len(this.longStore)-1 {
+		total -= sizes[dropped]
+		dropped++
+	}
+	if dropped > 0 {
+		this.longStore = this.longStore[dropped:]
+		longStoreBudgetEvictions.Add(float64(dropped))
+	}
+	longStoreBytes.Set(float64(total))
 }
 
 func (this *MetricSink) GetLatestDataBatch() *core.DataBatch {
@@ -99,6 +212,167 @@ func (this *MetricSink) GetLatestDataBatch() *core.DataBatch {
 	return this.shortStore[len(this.shortStore)-1]
 }
 
+// GetDataBatchAt returns the retained DataBatch with the timestamp closest to the given time,
+// or nil if the time is before the oldest retained batch or no batches are stored. Batches
+// older than the short store are rebuilt from the long store, so they hold only the
+// long-stored metrics, of the MetricSets of the latest batch that were then of the same series.
+func (this *MetricSink) GetDataBatchAt(timestamp time.Time) *core.DataBatch {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(this.shortStore) == 0 || timestamp.Before(this.oldestTimestamp()) {
+		return nil
+	}
+	var result *core.DataBatch
+	var distance time.Duration
+	for _, batch := range this.shortStore {
+		if d := absDuration(batch.Timestamp.Sub(timestamp)); result == nil || d < distance {
+			result, distance = batch, d
+		}
+	}
+	// The short store wins ties, as the long store holds the same batches without labels.
+	var closest *multimetricStore
+	for _, store := range this.longStore {
+		if d := absDuration(store.timestamp.Sub(timestamp)); d < distance {
+			closest, distance = store, d
+		}
+	}
+	if closest != nil {
+		return this.rebuildDataBatch(closest)
+	}
+	return result
+}
+
+// GetOldestTimestamp returns the timestamp of the oldest retained batch, or the zero time
+// if no batches are stored.
+func (this *MetricSink) GetOldestTimestamp() time.Time {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.oldestTimestamp()
+}
+
+func (this *MetricSink) oldestTimestamp() time.Time {
+	var oldest time.Time
+	if len(this.shortStore) > 0 {
+		oldest = this.shortStore[0].Timestamp
+	}
+	if len(this.longStore) > 0 && (oldest.IsZero() || this.longStore[0].timestamp.Before(oldest)) {
+		oldest = this.longStore[0].timestamp
+	}
+	return oldest
+}
+
+// rebuildDataBatch returns a DataBatch with the values of the long store entry and the labels
+// of the latest batch. MetricSets missing from the latest batch are lost.
+func (this *MetricSink) rebuildDataBatch(store *multimetricStore) *core.DataBatch {
+	latest := this.shortStore[len(this.shortStore)-1]
+	result := &core.DataBatch{
+		Timestamp:  store.timestamp,
+		MetricSets: make(map[string]*core.MetricSet, len(latest.MetricSets)),
+	}
+	for key, ms := range latest.MetricSets {
+		if store.seriesKey(key) != core.SeriesKey(key, ms) {
+			continue
+		}
+		values := make(map[string]core.MetricValue, len(store.store))
+		for metric, substore := range store.store {
+			if value, found := substore[key]; found {
+				values[metric] = core.MetricValue{
+					IntValue:   value,
+					ValueType:  core.ValueInt64,
+					MetricType: core.MetricGauge,
+				}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		result.MetricSets[key] = &core.MetricSet{
+			CreateTime:   ms.CreateTime,
+			ScrapeTime:   store.timestamp,
+			MetricValues: values,
+			Labels:       ms.Labels,
+		}
+	}
+	return result
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// latestSeriesKeys returns the series keys of the MetricSets of the latest batch. Values
+// stored under the same key for another series, e.g. a deleted pod of the same name, are
+// left out of averages and queries.
+func (this *MetricSink) latestSeriesKeys() map[string]string {
+	if len(this.shortStore) == 0 {
+		return nil
+	}
+	latest := this.shortStore[len(this.shortStore)-1]
+	result := make(map[string]string, len(latest.MetricSets))
+	for key, ms := range latest.MetricSets {
+		result[key] = core.SeriesKey(key, ms)
+	}
+	return result
+}
+
+// GetAveragedDataBatch returns a copy of the latest DataBatch in which the values of the
+// long-stored metrics are averaged over the given window, ending at the latest batch.
+// MetricSets missing from some of the batches are averaged over the batches they are in,
+// and pods recreated under the same name over the batches since they were recreated.
+func (this *MetricSink) GetAveragedDataBatch(window time.Duration) *core.DataBatch {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(this.shortStore) == 0 {
+		return nil
+	}
+	latest := this.shortStore[len(this.shortStore)-1]
+	start := latest.Timestamp.Add(-window)
+	series := this.latestSeriesKeys()
+
+	sums := make(map[string]map[string]int64, len(this.longStoreMetrics))
+	counts := make(map[string]map[string]int64, len(this.longStoreMetrics))
+	for _, metric := range this.longStoreMetrics {
+		sums[metric] = make(map[string]int64)
+		counts[metric] = make(map[string]int64)
+	}
+	for _, store := range this.longStore {
+		if !store.timestamp.After(start) || store.timestamp.After(latest.Timestamp) {
+			continue
+		}
+		for metric, substore := range store.store {
+			for key, value := range substore {
+				if store.seriesKey(key) != series[key] {
+					continue
+				}
+				sums[metric][key] += value
+				counts[metric][key]++
+			}
+		}
+	}
+
+	result := &core.DataBatch{
+		Timestamp:  latest.Timestamp,
+		MetricSets: make(map[string]*core.MetricSet, len(latest.MetricSets)),
+	}
+	for key, ms := range latest.MetricSets {
+		averaged := *ms
+		averaged.MetricValues = make(map[string]core.MetricValue, len(ms.MetricValues))
+		for metric, value := range ms.MetricValues {
+			if count := counts[metric][key]; count > 0 {
+				value.IntValue = sums[metric][key] / count
+			}
+			averaged.MetricValues[metric] = value
+		}
+		result.MetricSets[key] = &averaged
+	}
+	return result
+}
+
 func (this *MetricSink) GetShortStore() []*core.DataBatch {
 	this.lock.Lock()
 	defer this.lock.Unlock()
@@ -110,6 +384,8 @@ func (this *MetricSink) GetShortStore() []*core.DataBatch {
 	return result
 }
 
+// GetMetric returns the values of the metric of the MetricSets between start and end. Of
+// pods recreated under the same name, only the values of the latest pod are returned.
 func (this *MetricSink) GetMetric(metricName string, keys []string, start, end time.Time) map[string][]core.TimestampedMetricValue {
 	this.lock.Lock()
 	defer this.lock.Unlock()
@@ -121,6 +397,11 @@ func (this *MetricSink) GetMetric(metricName string, keys []string, start, end t
 		}
 	}
 
+	series := this.latestSeriesKeys()
+	isCurrent := func(key, seriesKey string) bool {
+		latest, found := series[key]
+		return !found || latest == seriesKey
+	}
 	result := make(map[string][]core.TimestampedMetricValue)
 	if useLongStore {
 		for _, store := range this.longStore {
@@ -128,7 +409,7 @@ func (this *MetricSink) GetMetric(metricName string, keys []string, start, end t
 			if !store.timestamp.Before(start) && !store.timestamp.After(end) {
 				substore := store.store[metricName]
 				for _, key := range keys {
-					if val, found := substore[key]; found {
+					if val, found := substore[key]; found && isCurrent(key, store.seriesKey(key)) {
 						result[key] = append(result[key], core.TimestampedMetricValue{
 							Timestamp: store.timestamp,
 							MetricValue: core.MetricValue{
@@ -147,7 +428,7 @@ func (this *MetricSink) GetMetric(metricName string, keys []string, start, end t
 			if !batch.Timestamp.Before(start) && !batch.Timestamp.After(end) {
 				for _, key := range keys {
 					metricSet, found := batch.MetricSets[key]
-					if !found {
+					if !found || !isCurrent(key, core.SeriesKey(key, metricSet)) {
 						continue
 					}
 					metricValue, found := metricSet.MetricValues[metricName]
@@ -335,6 +616,7 @@ func popOldStore(storages []*multimetricStore, cutoffTime time.Time) []*multimet
 	return result
 }
 
+// NewMetricSink creates a metric sink. Without a long store duration no long store is kept.
 func NewMetricSink(shortStoreDuration, longStoreDuration time.Duration, longStoreMetrics []string) *MetricSink {
 	return &MetricSink{
 		longStoreMetrics:   longStoreMetrics,
@@ -342,5 +624,6 @@ func NewMetricSink(shortStoreDuration, longStoreDuration time.Duration, longStor
 		shortStoreDuration: shortStoreDuration,
 		longStore:          make([]*multimetricStore, 0),
 		shortStore:         make([]*core.DataBatch, 0),
+		maxDelay:           DefaultMaxDelay,
 	}
 }
@@ -182,6 +182,190 @@ func TestGetMetrics(t *testing.T) {
 	assert.Contains(t, metricNames, "m2")
 }
 
+func TestGetAveragedDataBatch(t *testing.T) {
+	now := time.Now()
+	key := core.PodKey("ns1", "pod1")
+	otherKey := core.PodKey("ns1", "other")
+
+	batch1, batch2, batch3 := makeBatches(now, key, otherKey)
+
+	metrics := NewMetricSink(300*time.Second, 300*time.Second, []string{"m1"})
+	assert.Nil(t, metrics.GetAveragedDataBatch(time.Minute))
+	metrics.ExportData(&batch1)
+	metrics.ExportData(&batch2)
+	metrics.ExportData(&batch3)
+
+	averaged := metrics.GetAveragedDataBatch(200 * time.Second)
+	assert.Equal(t, batch3.Timestamp, averaged.Timestamp)
+	assert.Equal(t, int64(40), averaged.MetricSets[key].MetricValues["m1"].IntValue)
+	assert.Equal(t, int64(123), averaged.MetricSets[otherKey].MetricValues["m1"].IntValue)
+	// m2 is not long-stored, so the latest value is kept.
+	assert.Equal(t, int64(222), averaged.MetricSets[key].MetricValues["m2"].IntValue)
+
+	// batch1 doesn't belong to the window.
+	averaged = metrics.GetAveragedDataBatch(60 * time.Second)
+	assert.Equal(t, int64(30), averaged.MetricSets[key].MetricValues["m1"].IntValue)
+
+	// The stored batch is left intact.
+	assert.Equal(t, int64(20), metrics.GetLatestDataBatch().MetricSets[key].MetricValues["m1"].IntValue)
+}
+
+func TestRecreatedPodSeries(t *testing.T) {
+	now := time.Now()
+	key := core.PodKey("ns1", "pod-0")
+	newBatch := func(timestamp time.Time, uid string, value int64) *core.DataBatch {
+		return &core.DataBatch{
+			Timestamp: timestamp,
+			MetricSets: map[string]*core.MetricSet{
+				key: {
+					Labels: map[string]string{
+						core.LabelMetricSetType.Key: core.MetricSetTypePod,
+						core.LabelPodId.Key:         uid,
+					},
+					MetricValues: map[string]core.MetricValue{
+						"m1": {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: value},
+					},
+				},
+			},
+		}
+	}
+
+	metrics := NewMetricSink(300*time.Second, 300*time.Second, []string{"m1"})
+	metrics.ExportData(newBatch(now.Add(-120*time.Second), "uid1", 100))
+	metrics.ExportData(newBatch(now.Add(-60*time.Second), "uid2", 20))
+	metrics.ExportData(newBatch(now, "uid2", 40))
+
+	averaged := metrics.GetAveragedDataBatch(200 * time.Second)
+	assert.Equal(t, int64(30), averaged.MetricSets[key].MetricValues["m1"].IntValue, "deleted pod left out")
+
+	values := metrics.GetMetric("m1", []string{key}, now.Add(-200*time.Second), now)
+	assert.Equal(t, 2, len(values[key]))
+}
+
+func TestGetDataBatchAt(t *testing.T) {
+	now := time.Now()
+	key := core.PodKey("ns1", "pod1")
+	otherKey := core.PodKey("ns1", "other")
+
+	batch1, batch2, batch3 := makeBatches(now, key, otherKey)
+
+	metrics := NewMetricSink(300*time.Second, 300*time.Second, []string{"m1"})
+	assert.Nil(t, metrics.GetDataBatchAt(now))
+	metrics.ExportData(&batch1)
+	metrics.ExportData(&batch2)
+	metrics.ExportData(&batch3)
+
+	assert.Equal(t, batch2.Timestamp, metrics.GetDataBatchAt(now.Add(-50*time.Second)).Timestamp)
+	assert.Equal(t, batch3.Timestamp, metrics.GetDataBatchAt(now).Timestamp)
+	assert.Nil(t, metrics.GetDataBatchAt(now.Add(-time.Hour)), "before the oldest retained batch")
+	assert.Equal(t, batch1.Timestamp, metrics.GetOldestTimestamp())
+}
+
+func TestWithoutLongStore(t *testing.T) {
+	now := time.Now()
+	key := core.PodKey("ns1", "pod1")
+	otherKey := core.PodKey("ns1", "other")
+
+	batch1, batch2, batch3 := makeBatches(now, key, otherKey)
+
+	metrics := NewMetricSink(300*time.Second, 0, []string{"m1"})
+	metrics.ExportData(&batch1)
+	metrics.ExportData(&batch2)
+	metrics.ExportData(&batch3)
+
+	assert.Empty(t, metrics.longStore)
+	assert.Equal(t, batch3.Timestamp, metrics.GetLatestDataBatch().Timestamp)
+	assert.Equal(t, batch2.Timestamp, metrics.GetDataBatchAt(now.Add(-50*time.Second)).Timestamp)
+	assert.Equal(t, batch1.Timestamp, metrics.GetOldestTimestamp())
+}
+
+func TestGetDataBatchAtLongStore(t *testing.T) {
+	now := time.Now()
+	key := core.PodKey("ns1", "pod1")
+	otherKey := core.PodKey("ns1", "other")
+
+	batch1, batch2, batch3 := makeBatches(now, key, otherKey)
+
+	metrics := NewMetricSink(100*time.Second, 300*time.Second, []string{"m1"})
+	metrics.ExportData(&batch1)
+	metrics.ExportData(&batch2)
+	metrics.ExportData(&batch3)
+	// The short store holds batch2 and batch3 only.
+	assert.Equal(t, 2, len(metrics.GetShortStore()))
+	assert.Equal(t, batch1.Timestamp, metrics.GetOldestTimestamp())
+
+	batch := metrics.GetDataBatchAt(now.Add(-170 * time.Second))
+	if assert.NotNil(t, batch) {
+		assert.Equal(t, batch1.Timestamp, batch.Timestamp)
+		ms, found := batch.MetricSets[key]
+		if assert.True(t, found) {
+			assert.Equal(t, map[string]core.MetricValue{
+				"m1": {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 60},
+			}, ms.MetricValues, "only the long-stored metrics")
+			assert.Equal(t, batch3.MetricSets[key].Labels, ms.Labels)
+			assert.Equal(t, batch1.Timestamp, ms.ScrapeTime)
+		}
+		_, found = batch.MetricSets[otherKey]
+		assert.False(t, found, "not in batch1")
+	}
+	assert.Nil(t, metrics.GetDataBatchAt(now.Add(-190*time.Second)))
+	assert.Equal(t, batch2.Timestamp, metrics.GetDataBatchAt(now.Add(-80*time.Second)).Timestamp)
+}
+
+func TestGetDataBatchAtLongStoreRecreatedPod(t *testing.T) {
+	now := time.Now()
+	key := core.PodKey("ns1", "pod1")
+	batch := func(timestamp time.Time, uid string, value int64) *core.DataBatch {
+		return &core.DataBatch{
+			Timestamp: timestamp,
+			MetricSets: map[string]*core.MetricSet{
+				key: {
+					Labels: map[string]string{
+						core.LabelMetricSetType.Key: core.MetricSetTypePod,
+						core.LabelPodId.Key:         uid,
+					},
+					MetricValues: map[string]core.MetricValue{
+						"m1": {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: value},
+					},
+				},
+			},
+		}
+	}
+
+	metrics := NewMetricSink(100*time.Second, 300*time.Second, []string{"m1"})
+	metrics.ExportData(batch(now.Add(-180*time.Second), "old", 60))
+	metrics.ExportData(batch(now, "new", 20))
+
+	retained := metrics.GetDataBatchAt(now.Add(-180 * time.Second))
+	if assert.NotNil(t, retained) {
+		_, found := retained.MetricSets[key]
+		assert.False(t, found, "the values of the deleted pod are not served under the labels of the new one")
+	}
+}
+
+func TestLongStoreBudget(t *testing.T) {
+	now := time.Now()
+	key := core.PodKey("ns1", "pod1")
+	otherKey := core.PodKey("ns1", "other")
+
+	batch1, batch2, batch3 := makeBatches(now, key, otherKey)
+
+	metrics := NewMetricSink(300*time.Second, 300*time.Second, []string{"m1"})
+	// Room for the values of the two latest batches.
+	metrics.SetLongStoreBudget(3 * bytesPerLongStoreValue)
+	metrics.ExportData(&batch1)
+	metrics.ExportData(&batch2)
+	metrics.ExportData(&batch3)
+
+	result := metrics.GetMetric("m1", []string{key}, now.Add(-300*time.Second), now)
+	assert.Equal(t, 2, len(result[key]), "oldest entry dropped")
+	assert.Equal(t, batch2.Timestamp, result[key][0].Timestamp)
+
+	metrics.SetLongStoreBudget(1)
+	metrics.ExportData(&batch3)
+	assert.Equal(t, 1, len(metrics.GetMetric("m1", []string{key}, now.Add(-300*time.Second), now)[key]), "latest entry is kept")
+}
+
 func TestGetLabeledMetrics(t *testing.T) {
 	now := time.Now().UTC()
 	key := core.PodKey("ns1", "pod1")
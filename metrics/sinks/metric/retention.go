@@ -0,0 +1,369 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1listers "k8s.io/client-go/listers/core/v1"
+)
+
+// Categories of MetricSets which are only kept for a limited time.
+const (
+	RetentionDeletedPods        = "deleted_pods"
+	RetentionNotReadyNodes      = "not_ready_nodes"
+	RetentionFilteredNamespaces = "filtered_namespaces"
+)
+
+var retentionEvictions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "metric_sink",
+		Name:      "retention_evictions_total",
+		Help:      "Number of MetricSets evicted from the stored batches by the retention policy, by category.",
+	},
+	[]string{"category"},
+)
+
+func init() {
+	prometheus.MustRegister(retentionEvictions)
+}
+
+// RetentionPolicy decides how long the points of deleted pods, nodes which are not ready
+// and pods of filtered namespaces are kept in the sink. Once a MetricSet has been in its
+// category for the keep-for duration, it's dropped from all stored batches. A keep-for of
+// zero keeps the points for as long as the batches are stored.
+//
+// Independently, the pod and namespace points of the namespaces with a limited history
+// are only kept for that long after their batch, except for the latest batch, which
+// limits how far averages and histories of their pods reach back.
+type RetentionPolicy struct {
+	DeletedPods        time.Duration
+	NotReadyNodes      time.Duration
+	FilteredNamespaces time.Duration
+	// History kept by namespace, zero to keep only the latest points.
+	NamespaceHistory map[string]time.Duration
+
+	namespaces map[string]bool
+	podLister  v1listers.PodLister
+	nodeLister v1listers.NodeLister
+
+	// MetricSets in a category with a keep-for, by key.
+	tracked map[string]*retainedMetricSet
+	// MetricSets of the namespaces with a limited history in the stores, by key.
+	limited map[string]*limitedMetricSet
+	// Longest history whose expired points were pruned from the store entries, by their
+	// timestamp.
+	pruned map[time.Time]time.Duration
+}
+
+type retainedMetricSet struct {
+	category string
+	since    time.Time
+}
+
+type limitedMetricSet struct {
+	history time.Duration
+	// Timestamp of the latest batch the MetricSet was in.
+	seen time.Time
+}
+
+func NewRetentionPolicy(deletedPods, notReadyNodes, filteredNamespaces time.Duration, namespaces []string,
+	namespaceHistory map[string]time.Duration, podLister v1listers.PodLister, nodeLister v1listers.NodeLister) *RetentionPolicy {
+	namespaceSet := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		namespaceSet[namespace] = true
+	}
+	return &RetentionPolicy{
+		DeletedPods:        deletedPods,
+		NotReadyNodes:      notReadyNodes,
+		FilteredNamespaces: filteredNamespaces,
+		NamespaceHistory:   namespaceHistory,
+		namespaces:         namespaceSet,
+		podLister:          podLister,
+		nodeLister:         nodeLister,
+		tracked:            map[string]*retainedMetricSet{},
+		limited:            map[string]*limitedMetricSet{},
+		pruned:             map[time.Time]time.Duration{},
+	}
+}
+
+// historyOf returns the history kept of the MetricSet, false if it's not limited.
+func (this *RetentionPolicy) historyOf(ms *core.MetricSet) (time.Duration, bool) {
+	switch ms.Labels[core.LabelMetricSetType.Key] {
+	case core.MetricSetTypeNamespace, core.MetricSetTypePod, core.MetricSetTypePodContainer:
+		history, found := this.NamespaceHistory[ms.Labels[core.LabelNamespaceName.Key]]
+		return history, found
+	}
+	return 0, false
+}
+
+func (this *RetentionPolicy) keepFor(category string) time.Duration {
+	switch category {
+	case RetentionDeletedPods:
+		return this.DeletedPods
+	case RetentionNotReadyNodes:
+		return this.NotReadyNodes
+	case RetentionFilteredNamespaces:
+		return this.FilteredNamespaces
+	}
+	return 0
+}
+
+// category returns the category of the MetricSet, or an empty string if it's kept with
+// the batches.
+func (this *RetentionPolicy) category(ms *core.MetricSet) string {
+	namespace := ms.Labels[core.LabelNamespaceName.Key]
+	switch ms.Labels[core.LabelMetricSetType.Key] {
+	case core.MetricSetTypeNamespace:
+		if this.namespaces[namespace] {
+			return RetentionFilteredNamespaces
+		}
+	case core.MetricSetTypePod, core.MetricSetTypePodContainer:
+		if this.namespaces[namespace] {
+			return RetentionFilteredNamespaces
+		}
+		if this.podLister == nil {
+			return ""
+		}
+		_, err := this.podLister.Pods(namespace).Get(ms.Labels[core.LabelPodName.Key])
+		if errors.IsNotFound(err) {
+			return RetentionDeletedPods
+		} else if err != nil {
+			glog.Errorf("error while getting pod %s/%s: %v", namespace, ms.Labels[core.LabelPodName.Key], err)
+		}
+	case core.MetricSetTypeNode, core.MetricSetTypeSystemContainer:
+		if this.nodeLister == nil {
+			return ""
+		}
+		node, err := this.nodeLister.Get(ms.Labels[core.LabelNodename.Key])
+		if errors.IsNotFound(err) {
+			return RetentionNotReadyNodes
+		} else if err != nil {
+			glog.Errorf("error while getting node %s: %v", ms.Labels[core.LabelNodename.Key], err)
+			return ""
+		}
+		for _, c := range node.Status.Conditions {
+			if c.Type == corev1.NodeReady && c.Status != corev1.ConditionTrue {
+				return RetentionNotReadyNodes
+			}
+		}
+	}
+	return ""
+}
+
+// evictions returns the keys of the MetricSets to drop from the stores, with their
+// category. MetricSets are classified by their latest labels in the batches; ones only
+// left in the long store keep aging in the category they were last seen in.
+func (this *RetentionPolicy) evictions(now time.Time, batches []*core.DataBatch, longStore []*multimetricStore) map[string]string {
+	latest := map[string]*core.MetricSet{}
+	for _, batch := range batches {
+		for key, ms := range batch.MetricSets {
+			latest[key] = ms
+		}
+	}
+	for key, ms := range latest {
+		category := this.category(ms)
+		if category == "" || this.keepFor(category) <= 0 {
+			delete(this.tracked, key)
+			continue
+		}
+		if tracked, found := this.tracked[key]; !found || tracked.category != category {
+			this.tracked[key] = &retainedMetricSet{category: category, since: now}
+		}
+	}
+
+	result := map[string]string{}
+	for key, tracked := range this.tracked {
+		if _, found := latest[key]; !found && !inLongStore(key, longStore) {
+			delete(this.tracked, key)
+			continue
+		}
+		if now.Sub(tracked.since) >= this.keepFor(tracked.category) {
+			result[key] = tracked.category
+		}
+	}
+	return result
+}
+
+func inLongStore(key string, longStore []*multimetricStore) bool {
+	for _, store := range longStore {
+		for _, substore := range store.store {
+			if _, found := substore[key]; found {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetRetentionPolicy makes the sink drop MetricSets according to the policy whenever a
+// batch is exported.
+func (this *MetricSink) SetRetentionPolicy(policy *RetentionPolicy) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.retention = policy
+}
+
+// compact removes the MetricSets evicted by the retention policy. Stored batches may be
+// in use by readers, so they are replaced by copies instead of being modified.
+func (this *MetricSink) compact(now time.Time) {
+	if this.retention == nil {
+		return
+	}
+	evicted := this.retention.evictions(now, this.shortStore, this.longStore)
+	if len(evicted) == 0 {
+		return
+	}
+	for i, batch := range this.shortStore {
+		present := false
+		for key := range evicted {
+			if _, found := batch.MetricSets[key]; found {
+				present = true
+				break
+			}
+		}
+		if !present {
+			continue
+		}
+		compacted := &core.DataBatch{
+			Timestamp:  batch.Timestamp,
+			MetricSets: make(map[string]*core.MetricSet, len(batch.MetricSets)),
+		}
+		for key, ms := range batch.MetricSets {
+			if _, found := evicted[key]; !found {
+				compacted.MetricSets[key] = ms
+			}
+		}
+		this.shortStore[i] = compacted
+	}
+	for _, store := range this.longStore {
+		for _, substore := range store.store {
+			for key := range evicted {
+				delete(substore, key)
+			}
+		}
+	}
+	for _, category := range evicted {
+		retentionEvictions.WithLabelValues(category).Inc()
+	}
+}
+
+// pruneHistory drops the points of the namespaces with a limited history which are older
+// than their history from all but the latest entries of the stores. The MetricSets of the
+// limited namespaces are indexed as their batches are exported, so that long-stored values
+// are pruned even once their MetricSets have left the short store. An entry is only
+// scanned when it gets older than a history longer than those already pruned from it.
+func (this *MetricSink) pruneHistory(now time.Time, batch *core.DataBatch) {
+	policy := this.retention
+	if policy == nil || len(policy.NamespaceHistory) == 0 || len(this.shortStore) == 0 {
+		return
+	}
+	for key, ms := range batch.MetricSets {
+		if history, found := policy.historyOf(ms); found {
+			policy.limited[key] = &limitedMetricSet{history: history, seen: batch.Timestamp}
+		}
+	}
+
+	histories := make([]time.Duration, 0, len(policy.NamespaceHistory))
+	for _, history := range policy.NamespaceHistory {
+		histories = append(histories, history)
+	}
+	sort.Slice(histories, func(i, j int) bool { return histories[i] < histories[j] })
+	// expired returns the longest history exceeded by the age of the entry which wasn't
+	// pruned from it yet, with the one pruned before, or false if there is none.
+	expired := func(timestamp time.Time) (time.Duration, time.Duration, bool) {
+		pruned, found := policy.pruned[timestamp]
+		if !found {
+			pruned = -1
+		}
+		age := now.Sub(timestamp)
+		for i := len(histories) - 1; i >= 0; i-- {
+			if age > histories[i] {
+				return histories[i], pruned, histories[i] > pruned
+			}
+		}
+		return 0, pruned, false
+	}
+	// prunedBetween tells whether the points of the MetricSet expire after a history longer
+	// than from and up to to.
+	prunedBetween := func(key string, from, to time.Duration) bool {
+		limited, found := policy.limited[key]
+		return found && limited.history > from && limited.history <= to
+	}
+
+	pruned := map[time.Time]time.Duration{}
+	for i := 0; i < len(this.shortStore)-1; i++ {
+		batch := this.shortStore[i]
+		history, from, found := expired(batch.Timestamp)
+		if !found {
+			continue
+		}
+		pruned[batch.Timestamp] = history
+		compacted := &core.DataBatch{
+			Timestamp:  batch.Timestamp,
+			MetricSets: make(map[string]*core.MetricSet, len(batch.MetricSets)),
+		}
+		for key, ms := range batch.MetricSets {
+			if !prunedBetween(key, from, history) {
+				compacted.MetricSets[key] = ms
+			}
+		}
+		if len(compacted.MetricSets) < len(batch.MetricSets) {
+			this.shortStore[i] = compacted
+		}
+	}
+	for i := 0; i < len(this.longStore)-1; i++ {
+		store := this.longStore[i]
+		history, from, found := expired(store.timestamp)
+		if !found {
+			continue
+		}
+		pruned[store.timestamp] = history
+		for _, substore := range store.store {
+			for key := range substore {
+				if prunedBetween(key, from, history) {
+					delete(substore, key)
+				}
+			}
+		}
+	}
+	for timestamp, history := range pruned {
+		policy.pruned[timestamp] = history
+	}
+
+	// Forget the entries and MetricSets which left the stores.
+	oldest := this.shortStore[0].Timestamp
+	if len(this.longStore) > 0 && this.longStore[0].timestamp.Before(oldest) {
+		oldest = this.longStore[0].timestamp
+	}
+	for timestamp := range policy.pruned {
+		if timestamp.Before(oldest) {
+			delete(policy.pruned, timestamp)
+		}
+	}
+	for key, limited := range policy.limited {
+		if limited.seen.Before(oldest) {
+			delete(policy.limited, key)
+		}
+	}
+}
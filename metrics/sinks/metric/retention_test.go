@@ -0,0 +1,169 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func retentionBatch(timestamp time.Time) *core.DataBatch {
+	batch := &core.DataBatch{Timestamp: timestamp, MetricSets: map[string]*core.MetricSet{}}
+	for _, pod := range []struct{ namespace, name string }{{"ns1", "running"}, {"ns1", "deleted"}, {"filtered", "running"}} {
+		batch.MetricSets[core.PodKey(pod.namespace, pod.name)] = &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypePod,
+				core.LabelNamespaceName.Key: pod.namespace,
+				core.LabelPodName.Key:       pod.name,
+			},
+			MetricValues: map[string]core.MetricValue{"m1": {ValueType: core.ValueInt64, IntValue: 1}},
+		}
+	}
+	for _, node := range []string{"ready", "notready"} {
+		batch.MetricSets[core.NodeKey(node)] = &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+				core.LabelNodename.Key:      node,
+			},
+			MetricValues: map[string]core.MetricValue{"m1": {ValueType: core.ValueInt64, IntValue: 1}},
+		}
+	}
+	return batch
+}
+
+func newRetentionListers() (v1listers.PodLister, v1listers.NodeLister) {
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	pods.Add(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "running"}})
+	pods.Add(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "filtered", Name: "running"}})
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nodes.Add(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "ready"},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	})
+	nodes.Add(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "notready"},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionUnknown}}},
+	})
+	return v1listers.NewPodLister(pods), v1listers.NewNodeLister(nodes)
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	now := time.Now()
+	podLister, nodeLister := newRetentionListers()
+	sink := NewMetricSink(time.Hour, time.Hour, []string{"m1"})
+	sink.SetRetentionPolicy(NewRetentionPolicy(5*time.Minute, 10*time.Minute, 0, []string{"filtered"}, nil, podLister, nodeLister))
+
+	sink.ExportData(retentionBatch(now))
+	assert.Len(t, sink.GetLatestDataBatch().MetricSets, 5, "nothing evicted before the keep-for")
+	exported := sink.GetLatestDataBatch()
+
+	sink.compact(now.Add(6 * time.Minute))
+	latest := sink.GetLatestDataBatch()
+	assert.NotContains(t, latest.MetricSets, core.PodKey("ns1", "deleted"))
+	assert.Contains(t, latest.MetricSets, core.PodKey("ns1", "running"))
+	assert.Contains(t, latest.MetricSets, core.PodKey("filtered", "running"), "zero keep-for keeps the points")
+	assert.Contains(t, latest.MetricSets, core.NodeKey("notready"))
+	assert.Empty(t, sink.GetMetric("m1", []string{core.PodKey("ns1", "deleted")}, now, now), "evicted from the long store")
+	assert.Len(t, exported.MetricSets, 5, "stored batches in use are not modified")
+
+	sink.compact(now.Add(11 * time.Minute))
+	assert.NotContains(t, sink.GetLatestDataBatch().MetricSets, core.NodeKey("notready"))
+	assert.Contains(t, sink.GetLatestDataBatch().MetricSets, core.NodeKey("ready"))
+}
+
+func TestRetentionPolicyFilteredNamespaces(t *testing.T) {
+	now := time.Now()
+	podLister, nodeLister := newRetentionListers()
+	policy := NewRetentionPolicy(0, 0, time.Minute, []string{"filtered"}, nil, podLister, nodeLister)
+
+	batches := []*core.DataBatch{retentionBatch(now)}
+	assert.Empty(t, policy.evictions(now, batches, nil))
+	assert.Equal(t, map[string]string{core.PodKey("filtered", "running"): RetentionFilteredNamespaces},
+		policy.evictions(now.Add(time.Minute), batches, nil))
+
+	assert.Empty(t, policy.evictions(now.Add(2*time.Minute), nil, nil))
+	assert.Empty(t, policy.tracked, "sets gone from the stores are no longer tracked")
+}
+
+func TestNamespaceHistory(t *testing.T) {
+	now := time.Now()
+	podLister, nodeLister := newRetentionListers()
+	sink := NewMetricSink(10*time.Minute, 10*time.Minute, []string{"m1"})
+	sink.SetRetentionPolicy(NewRetentionPolicy(0, 0, 0, nil, map[string]time.Duration{"ns1": 0, "filtered": 90 * time.Second}, podLister, nodeLister))
+	for _, age := range []time.Duration{3 * time.Minute, time.Minute, 0} {
+		sink.ExportData(retentionBatch(now.Add(-age)))
+	}
+
+	count := func(key string) (int, int) {
+		short := 0
+		for _, batch := range sink.GetShortStore() {
+			if _, found := batch.MetricSets[key]; found {
+				short++
+			}
+		}
+		return short, len(sink.GetMetric("m1", []string{key}, now.Add(-time.Hour), now)[key])
+	}
+	short, long := count(core.PodKey("ns1", "running"))
+	assert.Equal(t, 1, short, "only the latest points")
+	assert.Equal(t, 1, long)
+	short, long = count(core.PodKey("filtered", "running"))
+	assert.Equal(t, 2, short)
+	assert.Equal(t, 2, long)
+	short, long = count(core.NodeKey("ready"))
+	assert.Equal(t, 3, short, "nodes are not limited")
+	assert.Equal(t, 3, long)
+}
+
+func TestNamespaceHistoryOfLongStore(t *testing.T) {
+	now := time.Now()
+	podLister, nodeLister := newRetentionListers()
+	sink := NewMetricSink(2*time.Minute, 10*time.Minute, []string{"m1"})
+	policy := NewRetentionPolicy(0, 0, 0, nil, map[string]time.Duration{"ns1": 5 * time.Minute}, podLister, nodeLister)
+	sink.SetRetentionPolicy(policy)
+	gone := retentionBatch(now.Add(-7 * time.Minute))
+	gone.MetricSets[core.PodKey("ns1", "gone")] = &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypePod,
+			core.LabelNamespaceName.Key: "ns1",
+			core.LabelPodName.Key:       "gone",
+		},
+		MetricValues: map[string]core.MetricValue{"m1": {ValueType: core.ValueInt64, IntValue: 1}},
+	}
+	sink.ExportData(gone)
+	assert.Len(t, sink.GetMetric("m1", []string{core.PodKey("ns1", "gone")}, now.Add(-time.Hour), now)[core.PodKey("ns1", "gone")], 1,
+		"the latest points are kept")
+
+	sink.ExportData(retentionBatch(now.Add(-4 * time.Minute)))
+	require.Len(t, sink.GetShortStore(), 1, "the pod left the short store")
+	assert.Empty(t, sink.GetMetric("m1", []string{core.PodKey("ns1", "gone")}, now.Add(-time.Hour), now)[core.PodKey("ns1", "gone")],
+		"long-stored points are pruned too")
+	assert.Len(t, sink.GetMetric("m1", []string{core.PodKey("ns1", "running")}, now.Add(-time.Hour), now)[core.PodKey("ns1", "running")], 1)
+	assert.Len(t, sink.GetMetric("m1", []string{core.NodeKey("ready")}, now.Add(-time.Hour), now)[core.NodeKey("ready")], 2)
+	assert.Equal(t, map[time.Time]time.Duration{now.Add(-7 * time.Minute): 5 * time.Minute}, policy.pruned,
+		"entries are only pruned again once older than a longer history")
+
+	sink.ExportData(retentionBatch(now))
+	assert.Len(t, sink.GetMetric("m1", []string{core.PodKey("ns1", "running")}, now.Add(-time.Hour), now)[core.PodKey("ns1", "running")], 2)
+	assert.Contains(t, policy.limited, core.PodKey("ns1", "gone"), "indexed while long-stored")
+}
@@ -0,0 +1,36 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build noexporters
+// +build noexporters
+
+package sinks
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubernetes-incubator/metrics-server/common/flags"
+)
+
+func TestNoExporters(t *testing.T) {
+	assert.Empty(t, exporterFactories)
+	assert.Nil(t, remoteWriteFactory)
+	_, err := (&SinkFactory{}).Build(flags.Uri{Key: "parquet", Val: url.URL{}})
+	assert.Error(t, err)
+	_, err = (&SinkFactory{}).BuildRemoteWrite(RemoteWriteConfig{URL: "https://prometheus/api/v1/write"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,181 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sinks/anonymize"
+)
+
+const (
+	// Default interval at which the collected usage is written to a new file.
+	DefaultInterval = time.Hour
+	// Layout of the time of the first row, used in the file names.
+	fileTimeLayout = "20060102T150405Z"
+)
+
+// Dumps the usage of all containers to Parquet files for offline analysis. A file is
+// written to the directory given as the path of the sink URI every interval, e.g.
+//
+//	--sink=parquet:/var/lib/usage?interval=30m
+//
+// With anonymize=true the namespace and pod names are hashed, see anonymize.NewAnonymizer.
+type parquetSink struct {
+	sync.Mutex
+	dir        string
+	interval   time.Duration
+	anonymizer *anonymize.Anonymizer
+	// Rows not written yet, and the time the first of them was collected.
+	rows  []usageRow
+	start time.Time
+}
+
+func NewParquetSink(uri *url.URL) (core.DataSink, error) {
+	if uri.Path == "" {
+		return nil, fmt.Errorf("the parquet sink needs a directory to write to")
+	}
+	interval := DefaultInterval
+	opts := uri.Query()
+	if len(opts["interval"]) >= 1 {
+		var err error
+		interval, err = time.ParseDuration(opts["interval"][0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %v", err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval needs to be positive - %s", interval)
+		}
+	}
+	anonymizer, err := anonymize.NewAnonymizer(opts)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(uri.Path); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", uri.Path)
+	}
+	return &parquetSink{
+		dir:        uri.Path,
+		interval:   interval,
+		anonymizer: anonymizer,
+	}, nil
+}
+
+func (this *parquetSink) Name() string {
+	return "Parquet Sink"
+}
+
+func (this *parquetSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	if len(this.rows) == 0 {
+		this.start = batch.Timestamp
+	}
+	this.rows = append(this.rows, getUsageRows(batch, this.anonymizer)...)
+	if batch.Timestamp.Sub(this.start) >= this.interval {
+		this.flush()
+	}
+}
+
+func (this *parquetSink) Stop() {
+	this.Lock()
+	defer this.Unlock()
+	this.flush()
+}
+
+// flush writes the buffered rows to a new file. They are dropped if writing fails, so
+// that a full volume does not grow the memory use indefinitely.
+func (this *parquetSink) flush() {
+	if len(this.rows) == 0 {
+		return
+	}
+	path := filepath.Join(this.dir, fmt.Sprintf("usage-%s.parquet", this.start.UTC().Format(fileTimeLayout)))
+	if err := writeFileAtomically(path, this.rows); err != nil {
+		glog.Errorf("Failed to write %d rows to %s: %v", len(this.rows), path, err)
+	} else {
+		glog.V(2).Infof("Wrote %d rows to %s", len(this.rows), path)
+	}
+	this.rows = nil
+}
+
+// writeFileAtomically writes the file under a temporary name first, so that analysis
+// tools picking up the files never see a partial one.
+func writeFileAtomically(path string, rows []usageRow) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := writeFile(w, rows); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// getUsageRows returns the usage of the containers in the batch for which both the
+// CPU usage rate and the memory working set are known, with the names anonymized.
+func getUsageRows(batch *core.DataBatch, anonymizer *anonymize.Anonymizer) []usageRow {
+	rows := make([]usageRow, 0, len(batch.MetricSets))
+	for _, ms := range batch.MetricSets {
+		if ms.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePodContainer {
+			continue
+		}
+		cpu, found := ms.MetricValues[core.MetricCpuUsageRate.Name]
+		if !found {
+			continue
+		}
+		memory, found := ms.MetricValues[core.MetricMemoryWorkingSet.Name]
+		if !found {
+			continue
+		}
+		timestamp := ms.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		rows = append(rows, usageRow{
+			Timestamp:        timestamp,
+			Node:             ms.Labels[core.LabelNodename.Key],
+			Namespace:        anonymizer.Name(ms.Labels[core.LabelNamespaceName.Key]),
+			Pod:              anonymizer.Name(ms.Labels[core.LabelPodName.Key]),
+			Container:        ms.Labels[core.LabelContainerName.Key],
+			CpuUsage:         cpu.IntValue,
+			MemoryWorkingSet: memory.IntValue,
+		})
+	}
+	return rows
+}
@@ -0,0 +1,231 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+// compactReader decodes Thrift compact structs into maps from field id to value.
+type compactReader struct {
+	*bytes.Reader
+}
+
+func (this compactReader) varint() int64 {
+	v, err := binary.ReadUvarint(this)
+	if err != nil {
+		panic(err)
+	}
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (this compactReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return this.varint()
+	case thriftBinary:
+		n, _ := binary.ReadUvarint(this)
+		b := make([]byte, n)
+		this.Read(b)
+		return string(b)
+	case thriftList:
+		header, _ := this.ReadByte()
+		size := int(header >> 4)
+		if size == 15 {
+			n, _ := binary.ReadUvarint(this)
+			size = int(n)
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = this.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		fields := map[int16]interface{}{}
+		var id int16
+		for {
+			header, _ := this.ReadByte()
+			if header == 0 {
+				return fields
+			}
+			if delta := int16(header >> 4); delta != 0 {
+				id += delta
+			} else {
+				id = int16(this.varint())
+			}
+			fields[id] = this.value(header & 0x0f)
+		}
+	}
+	panic(fmt.Sprintf("unsupported type %d", typ))
+}
+
+func readStruct(data []byte) (map[int16]interface{}, int) {
+	r := compactReader{bytes.NewReader(data)}
+	fields := r.value(thriftStruct).(map[int16]interface{})
+	return fields, len(data) - r.Len()
+}
+
+func TestWriteFile(t *testing.T) {
+	timestamp := time.Date(2017, 11, 2, 12, 0, 0, 0, time.UTC)
+	rows := make([]usageRow, 20)
+	for i := range rows {
+		rows[i] = usageRow{
+			Timestamp:        timestamp,
+			Node:             "node1",
+			Namespace:        "ns1",
+			Pod:              fmt.Sprintf("pod%d", i),
+			Container:        "c",
+			CpuUsage:         int64(i),
+			MemoryWorkingSet: int64(1000 * i),
+		}
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeFile(buf, rows))
+	data := buf.Bytes()
+
+	require.Equal(t, magic, string(data[:4]))
+	require.Equal(t, magic, string(data[len(data)-4:]))
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer, n := readStruct(data[len(data)-8-footerLength : len(data)-8])
+	require.Equal(t, footerLength, n)
+
+	assert.Equal(t, int64(20), footer[3], "num_rows")
+	schema := footer[2].([]interface{})
+	require.Len(t, schema, 8)
+	assert.Equal(t, int64(7), schema[0].(map[int16]interface{})[5], "num_children")
+	assert.Equal(t, "pod", schema[4].(map[int16]interface{})[4])
+
+	rowGroup := footer[4].([]interface{})[0].(map[int16]interface{})
+	chunks := rowGroup[1].([]interface{})
+	require.Len(t, chunks, 7)
+
+	// Read the pod names from their page.
+	meta := chunks[3].(map[int16]interface{})[3].(map[int16]interface{})
+	assert.Equal(t, []interface{}{"pod"}, meta[3])
+	offset := meta[9].(int64)
+	header, n := readStruct(data[offset:])
+	assert.Equal(t, int64(20), header[5].(map[int16]interface{})[1], "num_values")
+	page := data[int(offset)+n : int(offset)+n+int(header[2].(int64))]
+	for i := range rows {
+		length := int(binary.LittleEndian.Uint32(page))
+		assert.Equal(t, rows[i].Pod, string(page[4:4+length]))
+		page = page[4+length:]
+	}
+	assert.Empty(t, page)
+}
+
+func TestExportData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sink, err := NewParquetSink(&url.URL{Path: dir, RawQuery: "interval=2m"})
+	require.NoError(t, err)
+
+	start := time.Date(2017, 11, 2, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Minute)
+		sink.ExportData(&core.DataBatch{
+			Timestamp: timestamp,
+			MetricSets: map[string]*core.MetricSet{
+				core.PodContainerKey("ns1", "pod1", "c"): {
+					ScrapeTime: timestamp,
+					Labels: map[string]string{
+						core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+						core.LabelNamespaceName.Key: "ns1",
+						core.LabelPodName.Key:       "pod1",
+						core.LabelContainerName.Key: "c",
+					},
+					MetricValues: map[string]core.MetricValue{
+						core.MetricCpuUsageRate.Name:     {IntValue: 100},
+						core.MetricMemoryWorkingSet.Name: {IntValue: 1000},
+					},
+				},
+				core.NodeKey("node1"): {
+					Labels: map[string]string{
+						core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+					},
+				},
+			},
+		})
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "usage-20171102T120000Z.parquet")}, files)
+
+	sink.Stop()
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestNewParquetSinkInvalid(t *testing.T) {
+	for _, uri := range []string{"", "/nonexistent", "/tmp?interval=soon", "/tmp?interval=0"} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewParquetSink(parsed)
+		assert.Error(t, err, uri)
+	}
+}
+
+func TestAnonymizedUsageRows(t *testing.T) {
+	keyFile, err := ioutil.TempFile("", "anonymize")
+	require.NoError(t, err)
+	defer os.Remove(keyFile.Name())
+	keyFile.WriteString("secret")
+	keyFile.Close()
+	sink, err := NewParquetSink(&url.URL{Path: os.TempDir(), RawQuery: url.Values{"anonymize": {"true"}, "anonymizeKeyFile": {keyFile.Name()}}.Encode()})
+	require.NoError(t, err)
+	anonymizer := sink.(*parquetSink).anonymizer
+	require.NotNil(t, anonymizer)
+
+	rows := getUsageRows(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns1", "pod1", "c"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+					core.LabelNodename.Key:      "node1",
+					core.LabelNamespaceName.Key: "ns1",
+					core.LabelPodName.Key:       "pod1",
+					core.LabelContainerName.Key: "c",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsageRate.Name:     {IntValue: 100},
+					core.MetricMemoryWorkingSet.Name: {IntValue: 1000},
+				},
+			},
+		},
+	}, anonymizer)
+	require.Len(t, rows, 1)
+	assert.Equal(t, anonymizer.Name("ns1"), rows[0].Namespace)
+	assert.Equal(t, anonymizer.Name("pod1"), rows[0].Pod)
+	assert.NotEqual(t, "pod1", rows[0].Pod)
+	assert.Equal(t, "c", rows[0].Container)
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Type codes of the Thrift compact protocol, in which Parquet metadata is encoded.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// compactWriter writes the subset of the Thrift compact protocol needed for the
+// Parquet page headers and file metadata.
+type compactWriter struct {
+	buf bytes.Buffer
+	// Id of the last field written in each of the enclosing structs.
+	lastField []int16
+}
+
+func (this *compactWriter) Bytes() []byte {
+	return this.buf.Bytes()
+}
+
+func (this *compactWriter) writeVarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	this.buf.Write(b[:n])
+}
+
+func (this *compactWriter) writeZigzag(v int64) {
+	this.writeVarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (this *compactWriter) structBegin() {
+	this.lastField = append(this.lastField, 0)
+}
+
+func (this *compactWriter) structEnd() {
+	this.buf.WriteByte(0)
+	this.lastField = this.lastField[:len(this.lastField)-1]
+}
+
+func (this *compactWriter) fieldBegin(id int16, typ byte) {
+	last := &this.lastField[len(this.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		this.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		this.buf.WriteByte(typ)
+		this.writeZigzag(int64(id))
+	}
+	*last = id
+}
+
+func (this *compactWriter) listBegin(elemType byte, size int) {
+	if size < 15 {
+		this.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	this.buf.WriteByte(0xf0 | elemType)
+	this.writeVarint(uint64(size))
+}
+
+func (this *compactWriter) i32(v int32) {
+	this.writeZigzag(int64(v))
+}
+
+func (this *compactWriter) i64(v int64) {
+	this.writeZigzag(v)
+}
+
+func (this *compactWriter) binary(v string) {
+	this.writeVarint(uint64(len(v)))
+	this.buf.WriteString(v)
+}
+
+func (this *compactWriter) i32Field(id int16, v int32) {
+	this.fieldBegin(id, thriftI32)
+	this.i32(v)
+}
+
+func (this *compactWriter) i64Field(id int16, v int64) {
+	this.fieldBegin(id, thriftI64)
+	this.i64(v)
+}
+
+func (this *compactWriter) binaryField(id int16, v string) {
+	this.fieldBegin(id, thriftBinary)
+	this.binary(v)
+}
+
+func (this *compactWriter) structField(id int16) {
+	this.fieldBegin(id, thriftStruct)
+	this.structBegin()
+}
+
+func (this *compactWriter) listField(id int16, elemType byte, size int) {
+	this.fieldBegin(id, thriftList)
+	this.listBegin(elemType, size)
+}
@@ -0,0 +1,207 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const magic = "PAR1"
+
+// Parquet physical types.
+const (
+	typeInt64     = 2
+	typeByteArray = 6
+)
+
+// Parquet converted types, -1 for none.
+const (
+	convertedNone            = -1
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+)
+
+// Value of other Parquet metadata enums used by the writer.
+const (
+	repetitionRequired = 0
+	pageTypeData       = 0
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+)
+
+// Usage of a single container at the given time.
+type usageRow struct {
+	Timestamp time.Time
+	Node      string
+	Namespace string
+	Pod       string
+	Container string
+	// CPU usage rate in millicores.
+	CpuUsage int64
+	// Memory working set in bytes.
+	MemoryWorkingSet int64
+}
+
+type column struct {
+	name          string
+	typ           int32
+	convertedType int32
+	// PLAIN encoded values.
+	data bytes.Buffer
+}
+
+func (this *column) appendInt64(v int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(v))
+	this.data.Write(b[:])
+}
+
+func (this *column) appendString(v string) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(len(v)))
+	this.data.Write(b[:])
+	this.data.WriteString(v)
+}
+
+func newColumns(rows []usageRow) []*column {
+	columns := []*column{
+		{name: "timestamp", typ: typeInt64, convertedType: convertedTimestampMillis},
+		{name: "node", typ: typeByteArray, convertedType: convertedUTF8},
+		{name: "namespace", typ: typeByteArray, convertedType: convertedUTF8},
+		{name: "pod", typ: typeByteArray, convertedType: convertedUTF8},
+		{name: "container", typ: typeByteArray, convertedType: convertedUTF8},
+		{name: "cpu_usage_millicores", typ: typeInt64, convertedType: convertedNone},
+		{name: "memory_working_set_bytes", typ: typeInt64, convertedType: convertedNone},
+	}
+	for _, row := range rows {
+		columns[0].appendInt64(row.Timestamp.UnixNano() / int64(time.Millisecond))
+		columns[1].appendString(row.Node)
+		columns[2].appendString(row.Namespace)
+		columns[3].appendString(row.Pod)
+		columns[4].appendString(row.Container)
+		columns[5].appendInt64(row.CpuUsage)
+		columns[6].appendInt64(row.MemoryWorkingSet)
+	}
+	return columns
+}
+
+// writeFile writes the rows as a Parquet file with a single row group of uncompressed,
+// PLAIN encoded required columns.
+func writeFile(w io.Writer, rows []usageRow) error {
+	columns := newColumns(rows)
+	offsets := make([]int64, len(columns))
+	sizes := make([]int64, len(columns))
+
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	offset := int64(len(magic))
+	for i, col := range columns {
+		header := pageHeader(len(rows), col.data.Len())
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(col.data.Bytes()); err != nil {
+			return err
+		}
+		offsets[i] = offset
+		sizes[i] = int64(len(header) + col.data.Len())
+		offset += sizes[i]
+	}
+
+	footer := fileMetadata(columns, len(rows), offsets, sizes)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	for _, data := range [][]byte{footer, length[:], []byte(magic)} {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pageHeader(numValues, size int) []byte {
+	w := &compactWriter{}
+	w.structBegin()
+	w.i32Field(1, pageTypeData)
+	w.i32Field(2, int32(size))
+	w.i32Field(3, int32(size))
+	w.structField(5)
+	w.i32Field(1, int32(numValues))
+	w.i32Field(2, encodingPlain)
+	w.i32Field(3, encodingRLE)
+	w.i32Field(4, encodingRLE)
+	w.structEnd()
+	w.structEnd()
+	return w.Bytes()
+}
+
+func fileMetadata(columns []*column, numRows int, offsets, sizes []int64) []byte {
+	w := &compactWriter{}
+	w.structBegin()
+	w.i32Field(1, 1)
+
+	// The schema is flattened, starting with the root element.
+	w.listField(2, thriftStruct, len(columns)+1)
+	w.structBegin()
+	w.binaryField(4, "schema")
+	w.i32Field(5, int32(len(columns)))
+	w.structEnd()
+	for _, col := range columns {
+		w.structBegin()
+		w.i32Field(1, col.typ)
+		w.i32Field(3, repetitionRequired)
+		w.binaryField(4, col.name)
+		if col.convertedType != convertedNone {
+			w.i32Field(6, col.convertedType)
+		}
+		w.structEnd()
+	}
+	w.i64Field(3, int64(numRows))
+
+	var totalSize int64
+	w.listField(4, thriftStruct, 1)
+	w.structBegin()
+	w.listField(1, thriftStruct, len(columns))
+	for i, col := range columns {
+		w.structBegin()
+		w.i64Field(2, offsets[i])
+		w.structField(3)
+		w.i32Field(1, col.typ)
+		w.listField(2, thriftI32, 1)
+		w.i32(encodingPlain)
+		w.listField(3, thriftBinary, 1)
+		w.binary(col.name)
+		w.i32Field(4, codecUncompressed)
+		w.i64Field(5, int64(numRows))
+		w.i64Field(6, sizes[i])
+		w.i64Field(7, sizes[i])
+		w.i64Field(9, offsets[i])
+		w.structEnd()
+		w.structEnd()
+		totalSize += sizes[i]
+	}
+	w.i64Field(2, totalSize)
+	w.i64Field(3, int64(numRows))
+	w.structEnd()
+
+	w.binaryField(6, "metrics-server")
+	w.structEnd()
+	return w.Bytes()
+}
@@ -0,0 +1,210 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/glog"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sinks/anonymize"
+	"k8s.io/client-go/transport"
+)
+
+var remoteWriteSamples = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "remote_write",
+		Name:      "samples_total",
+		Help:      "Number of samples sent to the remote write endpoint, by result.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(remoteWriteSamples)
+}
+
+// Writes the cpu and memory usage of all nodes and pods of every batch to a Prometheus
+// remote write endpoint, for long-term storage without a second scraping pipeline. The
+// namespace and pod names are hashed if an anonymizer is given, see anonymize.Anonymizer.
+// Samples are dropped if the write fails.
+type remoteWriteSink struct {
+	endpoint   string
+	client     *http.Client
+	anonymizer *anonymize.Anonymizer
+}
+
+// NewRemoteWriteSink returns a sink writing to the http or https endpoint, authenticated
+// with the bearer token in the file if it's not empty. The file is read again for every
+// write, so that rotated tokens are used.
+func NewRemoteWriteSink(endpoint string, tls transport.TLSConfig, bearerTokenFile string, timeout time.Duration, anonymizer *anonymize.Anonymizer) (core.DataSink, error) {
+	uri, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote write URL: %v", err)
+	}
+	if uri.Scheme != "http" && uri.Scheme != "https" {
+		return nil, fmt.Errorf("the remote write URL needs to be an http or https URL - %s", endpoint)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("remote write timeout needs to be positive - %s", timeout)
+	}
+	rt, err := transport.New(&transport.Config{TLS: tls})
+	if err != nil {
+		return nil, err
+	}
+	if bearerTokenFile != "" {
+		if _, err := readToken(bearerTokenFile); err != nil {
+			return nil, err
+		}
+		rt = &tokenFileRoundTripper{path: bearerTokenFile, rt: rt}
+	}
+	return &remoteWriteSink{
+		endpoint:   endpoint,
+		client:     &http.Client{Transport: rt, Timeout: timeout},
+		anonymizer: anonymizer,
+	}, nil
+}
+
+// tokenFileRoundTripper authenticates every request with the bearer token in the file at
+// the time of the request.
+type tokenFileRoundTripper struct {
+	path string
+	rt   http.RoundTripper
+}
+
+func readToken(path string) (string, error) {
+	token, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the bearer token: %v", err)
+	}
+	return string(bytes.TrimSpace(token)), nil
+}
+
+func (this *tokenFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := readToken(this.path)
+	if err != nil {
+		return nil, err
+	}
+	return transport.NewBearerAuthRoundTripper(token, this.rt).RoundTrip(req)
+}
+
+func (this *remoteWriteSink) Name() string {
+	return "Remote Write Sink"
+}
+
+func (this *remoteWriteSink) ExportData(batch *core.DataBatch) {
+	series := getUsageSeries(batch, this.anonymizer)
+	if len(series) == 0 {
+		return
+	}
+	if err := this.write(series); err != nil {
+		glog.Errorf("Failed to write %d samples to %s: %v", len(series), this.endpoint, err)
+		remoteWriteSamples.WithLabelValues("failed").Add(float64(len(series)))
+		return
+	}
+	glog.V(4).Infof("Wrote %d samples to %s", len(series), this.endpoint)
+	remoteWriteSamples.WithLabelValues("sent").Add(float64(len(series)))
+}
+
+func (this *remoteWriteSink) Stop() {
+	// Nothing is buffered.
+}
+
+func (this *remoteWriteSink) write(series []*prompb.TimeSeries) error {
+	data, err := proto.Marshal(&prompb.WriteRequest{Timeseries: series})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, this.endpoint, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// getUsageSeries returns a single-sample series for the cpu usage rate and the memory
+// working set of every node and pod in the batch, with the names anonymized.
+func getUsageSeries(batch *core.DataBatch, anonymizer *anonymize.Anonymizer) []*prompb.TimeSeries {
+	series := make([]*prompb.TimeSeries, 0, 2*len(batch.MetricSets))
+	for _, ms := range batch.MetricSets {
+		var prefix string
+		var labels []*prompb.Label
+		switch ms.Labels[core.LabelMetricSetType.Key] {
+		case core.MetricSetTypeNode:
+			prefix = "metrics_server_node_"
+			labels = []*prompb.Label{{Name: "node", Value: ms.Labels[core.LabelNodename.Key]}}
+		case core.MetricSetTypePod:
+			prefix = "metrics_server_pod_"
+			labels = []*prompb.Label{
+				{Name: "namespace", Value: anonymizer.Name(ms.Labels[core.LabelNamespaceName.Key])},
+				{Name: "node", Value: ms.Labels[core.LabelNodename.Key]},
+				{Name: "pod", Value: anonymizer.Name(ms.Labels[core.LabelPodName.Key])},
+			}
+		default:
+			continue
+		}
+		timestamp := ms.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		millis := timestamp.UnixNano() / int64(time.Millisecond)
+
+		if cpu, found := ms.MetricValues[core.MetricCpuUsageRate.Name]; found {
+			series = append(series, newTimeSeries(prefix+"cpu_usage_cores", labels, float64(cpu.IntValue)/1000, millis))
+		}
+		if memory, found := ms.MetricValues[core.MetricMemoryWorkingSet.Name]; found {
+			series = append(series, newTimeSeries(prefix+"memory_working_set_bytes", labels, float64(memory.IntValue), millis))
+		}
+	}
+	return series
+}
+
+// newTimeSeries returns a series of a single sample, with the labels sorted by name as
+// receivers require.
+func newTimeSeries(name string, labels []*prompb.Label, value float64, timestamp int64) *prompb.TimeSeries {
+	ts := &prompb.TimeSeries{
+		Labels:  make([]*prompb.Label, 0, len(labels)+1),
+		Samples: []*prompb.Sample{{Value: value, Timestamp: timestamp}},
+	}
+	ts.Labels = append(ts.Labels, &prompb.Label{Name: "__name__", Value: name})
+	ts.Labels = append(ts.Labels, labels...)
+	sort.Slice(ts.Labels, func(i, j int) bool { return ts.Labels[i].Name < ts.Labels[j].Name })
+	return ts
+}
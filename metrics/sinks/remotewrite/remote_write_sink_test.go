@@ -0,0 +1,182 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sinks/anonymize"
+	"k8s.io/client-go/transport"
+)
+
+func decodeWriteRequest(t *testing.T, body []byte) *prompb.WriteRequest {
+	data, err := snappy.Decode(nil, body)
+	require.NoError(t, err)
+	request := &prompb.WriteRequest{}
+	require.NoError(t, proto.Unmarshal(data, request))
+	return request
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "token")
+	require.NoError(t, err)
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString("token1\n")
+	tokenFile.Close()
+
+	var requests []*http.Request
+	var bodies [][]byte
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests = append(requests, req)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink, err := NewRemoteWriteSink(server.URL+"/api/v1/write?tenant=a", transport.TLSConfig{}, tokenFile.Name(), 5*time.Second, nil)
+	require.NoError(t, err)
+
+	now := time.Unix(1500000000, 0)
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"): {
+				ScrapeTime: now.Add(-time.Second),
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+					core.LabelNodename.Key:      "node1",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsageRate.Name:     {IntValue: 1500},
+					core.MetricMemoryWorkingSet.Name: {IntValue: 1024},
+				},
+			},
+			core.PodKey("ns1", "pod1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+					core.LabelNamespaceName.Key: "ns1",
+					core.LabelPodName.Key:       "pod1",
+					core.LabelNodename.Key:      "node1",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsageRate.Name: {IntValue: 250},
+				},
+			},
+			core.PodContainerKey("ns1", "pod1", "c1"): {
+				Labels: map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypePodContainer},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsageRate.Name: {IntValue: 250},
+				},
+			},
+		},
+	}
+	sink.ExportData(batch)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "snappy", requests[0].Header.Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", requests[0].Header.Get("Content-Type"))
+	assert.Equal(t, "Bearer token1", requests[0].Header.Get("Authorization"))
+	assert.Equal(t, "a", requests[0].URL.Query().Get("tenant"))
+
+	series := map[string]*prompb.TimeSeries{}
+	for _, ts := range decodeWriteRequest(t, bodies[0]).Timeseries {
+		require.Equal(t, "__name__", ts.Labels[0].Name, "labels are sorted")
+		series[ts.Labels[0].Value] = ts
+	}
+	require.Len(t, series, 3)
+	assert.Equal(t, &prompb.TimeSeries{
+		Labels:  []*prompb.Label{{Name: "__name__", Value: "metrics_server_node_cpu_usage_cores"}, {Name: "node", Value: "node1"}},
+		Samples: []*prompb.Sample{{Value: 1.5, Timestamp: now.Add(-time.Second).UnixNano() / int64(time.Millisecond)}},
+	}, series["metrics_server_node_cpu_usage_cores"])
+	assert.Equal(t, 1024.0, series["metrics_server_node_memory_working_set_bytes"].Samples[0].Value)
+	assert.Equal(t, &prompb.TimeSeries{
+		Labels: []*prompb.Label{
+			{Name: "__name__", Value: "metrics_server_pod_cpu_usage_cores"},
+			{Name: "namespace", Value: "ns1"}, {Name: "node", Value: "node1"}, {Name: "pod", Value: "pod1"},
+		},
+		Samples: []*prompb.Sample{{Value: 0.25, Timestamp: now.UnixNano() / int64(time.Millisecond)}},
+	}, series["metrics_server_pod_cpu_usage_cores"], "batch time without scrape time")
+
+	// The token is read again for every write.
+	require.NoError(t, ioutil.WriteFile(tokenFile.Name(), []byte("token2\n"), 0600))
+	sink.ExportData(batch)
+	require.Len(t, requests, 2)
+	assert.Equal(t, "Bearer token2", requests[1].Header.Get("Authorization"))
+
+	// Failed writes are dropped.
+	status = http.StatusInternalServerError
+	sink.ExportData(batch)
+	assert.Len(t, requests, 3)
+}
+
+func TestAnonymizedUsageSeries(t *testing.T) {
+	keyFile, err := ioutil.TempFile("", "anonymize")
+	require.NoError(t, err)
+	defer os.Remove(keyFile.Name())
+	keyFile.WriteString("secret\n")
+	keyFile.Close()
+	anonymizer, err := anonymize.NewKeyedAnonymizer(keyFile.Name())
+	require.NoError(t, err)
+
+	series := getUsageSeries(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodKey("ns1", "pod1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+					core.LabelNamespaceName.Key: "ns1",
+					core.LabelPodName.Key:       "pod1",
+					core.LabelNodename.Key:      "node1",
+				},
+				MetricValues: map[string]core.MetricValue{core.MetricCpuUsageRate.Name: {IntValue: 250}},
+			},
+		},
+	}, anonymizer)
+	require.Len(t, series, 1)
+	assert.Equal(t, []*prompb.Label{
+		{Name: "__name__", Value: "metrics_server_pod_cpu_usage_cores"},
+		{Name: "namespace", Value: anonymizer.Name("ns1")},
+		{Name: "node", Value: "node1"},
+		{Name: "pod", Value: anonymizer.Name("pod1")},
+	}, series[0].Labels)
+}
+
+func TestNewRemoteWriteSinkErrors(t *testing.T) {
+	for _, test := range []struct {
+		endpoint        string
+		bearerTokenFile string
+		timeout         time.Duration
+	}{
+		{"/api/v1/write", "", time.Second},
+		{"https://prometheus/api/v1/write", "", 0},
+		{"https://prometheus/api/v1/write", "/nonexistent", time.Second},
+	} {
+		_, err := NewRemoteWriteSink(test.endpoint, transport.TLSConfig{}, test.bearerTokenFile, test.timeout, nil)
+		assert.Error(t, err, "%+v", test)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+// FormatVersion is the version of the snapshot format written by Encode. Readers reject
+// snapshots of newer versions. Fields added to the batch don't need a new version: gob
+// skips fields unknown to the reader and leaves fields missing from the snapshot zero.
+const FormatVersion = 1
+
+// Compression of the batch in a snapshot.
+type Compression byte
+
+const (
+	CompressionNone Compression = 0
+	CompressionGzip Compression = 1
+)
+
+// Snapshots start with the magic, the format version and the compression, followed by
+// the gob encoded batch. Unversioned snapshots of older instances start right with the
+// gob stream, whose first byte, the length of a message, is never zero.
+var magic = []byte("\x00msb")
+
+const headerSize = 6
+
+// Encode writes the batch in the current snapshot format.
+func Encode(w io.Writer, batch *core.DataBatch, compression Compression) error {
+	header := append(append([]byte{}, magic...), FormatVersion, byte(compression))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	switch compression {
+	case CompressionNone:
+		return gob.NewEncoder(w).Encode(batch)
+	case CompressionGzip:
+		zw := gzip.NewWriter(w)
+		if err := gob.NewEncoder(zw).Encode(batch); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	default:
+		return fmt.Errorf("unknown snapshot compression %d", compression)
+	}
+}
+
+// Decode reads a batch written by Encode, or by older instances writing unversioned
+// snapshots. Batches of more than maxSize bytes, after decompression, are rejected, so that
+// a corrupt or hostile snapshot can't exhaust the memory.
+func Decode(r io.Reader, maxSize int64) (*core.DataBatch, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(headerSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var payload io.Reader = br
+	if len(header) == headerSize && bytes.Equal(header[:len(magic)], magic) {
+		version, compression := header[len(magic)], Compression(header[len(magic)+1])
+		if version > FormatVersion {
+			return nil, fmt.Errorf("snapshot format version %d is newer than the supported version %d", version, FormatVersion)
+		}
+		br.Discard(headerSize)
+		switch compression {
+		case CompressionNone:
+		case CompressionGzip:
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			payload = zr
+		default:
+			return nil, fmt.Errorf("unknown snapshot compression %d", compression)
+		}
+	}
+	limited := &io.LimitedReader{R: payload, N: maxSize}
+	batch := &core.DataBatch{}
+	if err := gob.NewDecoder(limited).Decode(batch); err != nil {
+		if limited.N <= 0 {
+			return nil, fmt.Errorf("snapshot exceeds the maximum size of %d bytes", maxSize)
+		}
+		return nil, err
+	}
+	return batch, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+// Max size of the batches decoded in tests.
+const testMaxSize = 1 << 20
+
+func TestEncodeAndDecode(t *testing.T) {
+	batch := testBatch(time.Now().Round(0), 100)
+	sizes := map[Compression]int{}
+	for _, compression := range []Compression{CompressionNone, CompressionGzip} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, Encode(buf, batch, compression))
+		sizes[compression] = buf.Len()
+		assert.Equal(t, []byte{0, 'm', 's', 'b', FormatVersion, byte(compression)}, buf.Bytes()[:headerSize])
+
+		decoded, err := Decode(buf, testMaxSize)
+		require.NoError(t, err)
+		assert.True(t, batch.Timestamp.Equal(decoded.Timestamp))
+		assert.Equal(t, int64(100), decoded.MetricSets[core.NodeKey("node1")].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+	}
+	assert.Error(t, Encode(&bytes.Buffer{}, batch, Compression(7)))
+}
+
+func TestDecodeUnversioned(t *testing.T) {
+	// Snapshots of older instances are plain gob streams.
+	buf := &bytes.Buffer{}
+	require.NoError(t, gob.NewEncoder(buf).Encode(testBatch(time.Now(), 100)))
+	batch, err := Decode(buf, testMaxSize)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), batch.MetricSets[core.NodeKey("node1")].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+}
+
+func TestDecodeAddedFields(t *testing.T) {
+	// A batch of a newer instance with a field unknown here.
+	type newerDataBatch struct {
+		Timestamp  time.Time
+		MetricSets map[string]*core.MetricSet
+		Origin     string
+	}
+	batch := testBatch(time.Now(), 100)
+	buf := bytes.NewBuffer(append(append([]byte{}, magic...), FormatVersion, byte(CompressionNone)))
+	require.NoError(t, gob.NewEncoder(buf).Encode(&newerDataBatch{Timestamp: batch.Timestamp, MetricSets: batch.MetricSets, Origin: "primary"}))
+	decoded, err := Decode(buf, testMaxSize)
+	require.NoError(t, err)
+	assert.Len(t, decoded.MetricSets, 1)
+
+	// A batch of an older instance without the metric sets.
+	type olderDataBatch struct {
+		Timestamp time.Time
+	}
+	buf = &bytes.Buffer{}
+	require.NoError(t, gob.NewEncoder(buf).Encode(&olderDataBatch{Timestamp: batch.Timestamp}))
+	decoded, err = Decode(buf, testMaxSize)
+	require.NoError(t, err)
+	assert.True(t, batch.Timestamp.Equal(decoded.Timestamp))
+	assert.Empty(t, decoded.MetricSets)
+}
+
+func TestDecodeNewerVersion(t *testing.T) {
+	buf := bytes.NewBuffer(append(append([]byte{}, magic...), FormatVersion+1, byte(CompressionNone)))
+	_, err := Decode(buf, testMaxSize)
+	assert.Error(t, err)
+
+	buf = bytes.NewBuffer(append(append([]byte{}, magic...), FormatVersion, 7))
+	_, err = Decode(buf, testMaxSize)
+	assert.Error(t, err, "unknown compression")
+}
+
+func TestDecodeMaxSize(t *testing.T) {
+	batch := testBatch(time.Now(), 100)
+	for i := 0; i < 1000; i++ {
+		batch.MetricSets[core.NodeKey(fmt.Sprintf("node%d", i))] = batch.MetricSets[core.NodeKey("node1")]
+	}
+	for _, compression := range []Compression{CompressionNone, CompressionGzip} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, Encode(buf, batch, compression))
+		encoded := buf.Bytes()
+
+		_, err := Decode(bytes.NewReader(encoded), 10<<10)
+		require.Error(t, err, "compression %d", compression)
+		assert.Contains(t, err.Error(), "exceeds the maximum size")
+		decoded, err := Decode(bytes.NewReader(encoded), testMaxSize)
+		require.NoError(t, err)
+		assert.Len(t, decoded.MetricSets, 1000)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"k8s.io/client-go/transport"
+)
+
+// Path under which the latest batch is served to read-only replicas.
+const Path = "/snapshot"
+
+const (
+	// Token presented by read-only replicas to the instance serving the snapshots.
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// Timeout of snapshot requests of read-only replicas.
+	remoteReadTimeout = 30 * time.Second
+)
+
+type handler struct {
+	metricSink *metricsink.MetricSink
+
+	// The latest batch is only encoded once, however many replicas read it.
+	lock    sync.Mutex
+	etag    string
+	encoded []byte
+}
+
+// NewHandler returns a handler serving the latest batch of the sink in the format read by
+// remote Readers. The ETag identifies the batch, so that unchanged batches aren't sent again.
+func NewHandler(metricSink *metricsink.MetricSink) http.Handler {
+	return &handler{metricSink: metricSink}
+}
+
+func (this *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	batch := this.metricSink.GetLatestDataBatch()
+	if batch == nil {
+		http.Error(w, "no metrics have been collected yet", http.StatusServiceUnavailable)
+		return
+	}
+	etag := fmt.Sprintf("\"%d\"", batch.Timestamp.UnixNano())
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	this.lock.Lock()
+	if this.etag != etag {
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, batch, CompressionGzip); err != nil {
+			this.lock.Unlock()
+			glog.Errorf("Error while encoding snapshot: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		this.etag, this.encoded = etag, buf.Bytes()
+	}
+	encoded := this.encoded
+	this.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", etag)
+	if _, err := w.Write(encoded); err != nil {
+		glog.Errorf("Error while writing snapshot: %v", err)
+	}
+}
+
+// NewClient returns a client for reading snapshots from another instance. It verifies the
+// serving certificate against the CA file, if given, and authenticates with the service
+// account token when running in a pod.
+func NewClient(caFile string) (*http.Client, error) {
+	config := &transport.Config{
+		TLS: transport.TLSConfig{CAFile: caFile},
+	}
+	if token, err := ioutil.ReadFile(serviceAccountTokenFile); err == nil {
+		config.BearerToken = string(bytes.TrimSpace(token))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	rt, err := transport.New(config)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: rt, Timeout: remoteReadTimeout}, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snapshot shares the latest processed DataBatch between instances, through a
+// file on the same host or over HTTP, so that only some of them have to scrape the nodes.
+package snapshot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Interval at which readers check the snapshot file for a new batch.
+const DefaultPollInterval = 5 * time.Second
+
+// Writer replaces the snapshot file with every processed batch.
+type Writer struct {
+	path string
+}
+
+func NewWriter(path string) *Writer {
+	return &Writer{path: path}
+}
+
+// Subscribe registers the writer for processed batches on the bus.
+func (this *Writer) Subscribe(eventBus *bus.Bus) {
+	eventBus.Subscribe("snapshot_writer", bus.TopicDataBatch, this.handle)
+}
+
+func (this *Writer) handle(event *bus.Event) {
+	if err := Write(this.path, event.Batch); err != nil {
+		glog.Errorf("Failed to write snapshot %s: %v", this.path, err)
+	}
+}
+
+// Write stores the batch in the file. The file is replaced atomically, so readers
+// never see a partially written batch.
+func Write(path string, batch *core.DataBatch) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := Encode(w, batch, CompressionGzip); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Restore exports the batch in the snapshot file to the sink, so that metrics are served
+// right after a restart rather than after the first scrape. Batches older than maxAge are
+// not restored, nor ones bigger than maxSize bytes. Returns whether the batch was restored;
+// a missing file is not an error.
+func Restore(path string, sink core.DataSink, maxAge time.Duration, maxSize int64) (bool, error) {
+	batch, err := NewReader(path, maxSize).Read()
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if age := time.Since(batch.Timestamp); age > maxAge {
+		glog.Infof("Not restoring snapshot %s of %s, older than %s", path, batch.Timestamp, maxAge)
+		return false, nil
+	}
+	sink.ExportData(batch)
+	return true, nil
+}
+
+// Reader reads the batches written to a snapshot file by a Writer, or served at a URL by
+// the snapshot Handler.
+type Reader struct {
+	path string
+	// Client for reading from a URL, nil for files.
+	client *http.Client
+	// Max size of a decoded batch in bytes.
+	maxSize int64
+	// The snapshot file, or the ETag of the snapshot, most recently read.
+	info os.FileInfo
+	etag string
+}
+
+// NewReader returns a reader of the snapshot file, rejecting batches bigger than maxSize
+// bytes.
+func NewReader(path string, maxSize int64) *Reader {
+	return &Reader{path: path, maxSize: maxSize}
+}
+
+// NewRemoteReader returns a reader of the snapshot served at the URL, rejecting batches
+// bigger than maxSize bytes.
+func NewRemoteReader(url string, client *http.Client, maxSize int64) *Reader {
+	return &Reader{path: url, client: client, maxSize: maxSize}
+}
+
+// Read returns the batch in the snapshot, or nil if it has not been replaced since the
+// last call.
+func (this *Reader) Read() (*core.DataBatch, error) {
+	if this.client != nil {
+		return this.readRemote()
+	}
+	return this.readFile()
+}
+
+func (this *Reader) readRemote() (*core.DataBatch, error) {
+	req, err := http.NewRequest("GET", this.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if this.etag != "" {
+		req.Header.Set("If-None-Match", this.etag)
+	}
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil
+	default:
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to get snapshot from %s: %s %s", this.path, resp.Status, body)
+	}
+	batch, err := Decode(resp.Body, this.maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot from %s: %v", this.path, err)
+	}
+	this.etag = resp.Header.Get("ETag")
+	return batch, nil
+}
+
+func (this *Reader) readFile() (*core.DataBatch, error) {
+	file, err := os.Open(this.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if this.info != nil && os.SameFile(this.info, info) {
+		return nil, nil
+	}
+	if info.Size() == 0 {
+		return nil, fmt.Errorf("snapshot %s is empty", this.path)
+	}
+
+	batch, err := Decode(file, this.maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %v", this.path, err)
+	}
+	this.info = info
+	return batch, nil
+}
+
+// Run exports every new batch in the snapshot file to the sink until stop is closed.
+func (this *Reader) Run(sink core.DataSink, interval time.Duration, stop <-chan struct{}) {
+	var latest time.Time
+	wait.Until(func() {
+		batch, err := this.Read()
+		if err != nil {
+			glog.Errorf("Failed to read snapshot: %v", err)
+			return
+		}
+		if batch == nil || !batch.Timestamp.After(latest) {
+			return
+		}
+		latest = batch.Timestamp
+		glog.V(2).Infof("Exporting snapshot with %d metric sets from %s", len(batch.MetricSets), batch.Timestamp)
+		sink.ExportData(batch)
+	}, interval, stop)
+}
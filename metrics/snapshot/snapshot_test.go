@@ -0,0 +1,167 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+)
+
+func testBatch(timestamp time.Time, usage int64) *core.DataBatch {
+	return &core.DataBatch{
+		Timestamp: timestamp,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"): {
+				CreateTime: timestamp.Add(-time.Hour),
+				ScrapeTime: timestamp,
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsageRate.Name: {
+						ValueType:  core.ValueInt64,
+						MetricType: core.MetricGauge,
+						IntValue:   usage,
+					},
+				},
+				LabeledMetrics: []core.LabeledMetric{},
+			},
+		},
+	}
+}
+
+func TestWriteAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "batch")
+
+	reader := NewReader(path, testMaxSize)
+	_, err = reader.Read()
+	assert.Error(t, err, "missing snapshot")
+
+	now := time.Now().Round(0)
+	first := testBatch(now, 100)
+	require.NoError(t, Write(path, first))
+	batch, err := reader.Read()
+	require.NoError(t, err)
+	require.NotNil(t, batch)
+	assert.True(t, first.Timestamp.Equal(batch.Timestamp))
+	ms := batch.MetricSets[core.NodeKey("node1")]
+	require.NotNil(t, ms)
+	assert.Equal(t, core.MetricSetTypeNode, ms.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, int64(100), ms.MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+
+	batch, err = reader.Read()
+	require.NoError(t, err)
+	assert.Nil(t, batch, "unchanged snapshot")
+
+	require.NoError(t, Write(path, testBatch(now.Add(time.Minute), 200)))
+	batch, err = reader.Read()
+	require.NoError(t, err)
+	require.NotNil(t, batch)
+	assert.Equal(t, int64(200), batch.MetricSets[core.NodeKey("node1")].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "temporary files left behind")
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "batch")
+	require.NoError(t, Write(path, testBatch(time.Now(), 100)))
+
+	sink := util.NewDummySink("sink", 0)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		NewReader(path, testMaxSize).Run(sink, 10*time.Millisecond, stop)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	<-done
+	assert.Equal(t, 1, sink.GetExportCount())
+}
+
+func TestRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "batch")
+	sink := util.NewDummySink("sink", 0)
+
+	restored, err := Restore(path, sink, time.Minute, testMaxSize)
+	require.NoError(t, err, "no snapshot written yet")
+	assert.False(t, restored)
+
+	require.NoError(t, Write(path, testBatch(time.Now().Add(-2*time.Minute), 100)))
+	restored, err = Restore(path, sink, time.Minute, testMaxSize)
+	require.NoError(t, err)
+	assert.False(t, restored, "snapshot too old")
+	assert.Equal(t, 0, sink.GetExportCount())
+
+	require.NoError(t, Write(path, testBatch(time.Now().Add(-10*time.Second), 100)))
+	restored, err = Restore(path, sink, time.Minute, testMaxSize)
+	require.NoError(t, err)
+	assert.True(t, restored)
+	assert.Equal(t, 1, sink.GetExportCount())
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("garbage"), 0644))
+	_, err = Restore(path, sink, time.Minute, testMaxSize)
+	assert.Error(t, err)
+}
+
+func TestRemoteRead(t *testing.T) {
+	sink := metricsink.NewMetricSink(time.Minute, time.Minute, []string{})
+	server := httptest.NewServer(NewHandler(sink))
+	defer server.Close()
+
+	reader := NewRemoteReader(server.URL+Path, http.DefaultClient, testMaxSize)
+	_, err := reader.Read()
+	assert.Error(t, err, "no batch collected yet")
+
+	now := time.Now()
+	sink.ExportData(testBatch(now, 100))
+	batch, err := reader.Read()
+	require.NoError(t, err)
+	require.NotNil(t, batch)
+	assert.Equal(t, int64(100), batch.MetricSets[core.NodeKey("node1")].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+
+	batch, err = reader.Read()
+	require.NoError(t, err)
+	assert.Nil(t, batch, "unchanged snapshot")
+
+	sink.ExportData(testBatch(now.Add(time.Minute), 200))
+	batch, err = reader.Read()
+	require.NoError(t, err)
+	require.NotNil(t, batch)
+	assert.Equal(t, int64(200), batch.MetricSets[core.NodeKey("node1")].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cri
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// The subset of the CRI v1alpha2 RuntimeService messages needed to read container stats.
+// Field numbers follow k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2/api.proto,
+// which isn't vendored.
+
+// Full name of the RuntimeService ListContainerStats method.
+const listContainerStatsMethod = "/runtime.v1alpha2.RuntimeService/ListContainerStats"
+
+type ListContainerStatsRequest struct {
+	Filter *ContainerStatsFilter `protobuf:"bytes,1,opt,name=filter" json:"filter,omitempty"`
+}
+
+func (m *ListContainerStatsRequest) Reset()         { *m = ListContainerStatsRequest{} }
+func (m *ListContainerStatsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListContainerStatsRequest) ProtoMessage()    {}
+
+type ContainerStatsFilter struct {
+	Id            string            `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	PodSandboxId  string            `protobuf:"bytes,2,opt,name=pod_sandbox_id,json=podSandboxId,proto3" json:"pod_sandbox_id,omitempty"`
+	LabelSelector map[string]string `protobuf:"bytes,3,rep,name=label_selector,json=labelSelector" json:"label_selector,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *ContainerStatsFilter) Reset()         { *m = ContainerStatsFilter{} }
+func (m *ContainerStatsFilter) String() string { return proto.CompactTextString(m) }
+func (*ContainerStatsFilter) ProtoMessage()    {}
+
+type ListContainerStatsResponse struct {
+	Stats []*ContainerStats `protobuf:"bytes,1,rep,name=stats" json:"stats,omitempty"`
+}
+
+func (m *ListContainerStatsResponse) Reset()         { *m = ListContainerStatsResponse{} }
+func (m *ListContainerStatsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListContainerStatsResponse) ProtoMessage()    {}
+
+type ContainerStats struct {
+	Attributes    *ContainerAttributes `protobuf:"bytes,1,opt,name=attributes" json:"attributes,omitempty"`
+	Cpu           *CpuUsage            `protobuf:"bytes,2,opt,name=cpu" json:"cpu,omitempty"`
+	Memory        *MemoryUsage         `protobuf:"bytes,3,opt,name=memory" json:"memory,omitempty"`
+	WritableLayer *FilesystemUsage     `protobuf:"bytes,4,opt,name=writable_layer,json=writableLayer" json:"writable_layer,omitempty"`
+}
+
+func (m *ContainerStats) Reset()         { *m = ContainerStats{} }
+func (m *ContainerStats) String() string { return proto.CompactTextString(m) }
+func (*ContainerStats) ProtoMessage()    {}
+
+type ContainerAttributes struct {
+	Id          string             `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Metadata    *ContainerMetadata `protobuf:"bytes,2,opt,name=metadata" json:"metadata,omitempty"`
+	Labels      map[string]string  `protobuf:"bytes,3,rep,name=labels" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Annotations map[string]string  `protobuf:"bytes,4,rep,name=annotations" json:"annotations,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *ContainerAttributes) Reset()         { *m = ContainerAttributes{} }
+func (m *ContainerAttributes) String() string { return proto.CompactTextString(m) }
+func (*ContainerAttributes) ProtoMessage()    {}
+
+type ContainerMetadata struct {
+	Name    string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Attempt uint32 `protobuf:"varint,2,opt,name=attempt,proto3" json:"attempt,omitempty"`
+}
+
+func (m *ContainerMetadata) Reset()         { *m = ContainerMetadata{} }
+func (m *ContainerMetadata) String() string { return proto.CompactTextString(m) }
+func (*ContainerMetadata) ProtoMessage()    {}
+
+type CpuUsage struct {
+	// Unix time in nanoseconds.
+	Timestamp            int64        `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	UsageCoreNanoSeconds *UInt64Value `protobuf:"bytes,2,opt,name=usage_core_nano_seconds,json=usageCoreNanoSeconds" json:"usage_core_nano_seconds,omitempty"`
+}
+
+func (m *CpuUsage) Reset()         { *m = CpuUsage{} }
+func (m *CpuUsage) String() string { return proto.CompactTextString(m) }
+func (*CpuUsage) ProtoMessage()    {}
+
+type MemoryUsage struct {
+	// Unix time in nanoseconds.
+	Timestamp       int64        `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	WorkingSetBytes *UInt64Value `protobuf:"bytes,2,opt,name=working_set_bytes,json=workingSetBytes" json:"working_set_bytes,omitempty"`
+}
+
+func (m *MemoryUsage) Reset()         { *m = MemoryUsage{} }
+func (m *MemoryUsage) String() string { return proto.CompactTextString(m) }
+func (*MemoryUsage) ProtoMessage()    {}
+
+type FilesystemUsage struct {
+	// Unix time in nanoseconds.
+	Timestamp  int64              `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	StorageId  *StorageIdentifier `protobuf:"bytes,2,opt,name=storage_id,json=storageId" json:"storage_id,omitempty"`
+	UsedBytes  *UInt64Value       `protobuf:"bytes,3,opt,name=used_bytes,json=usedBytes" json:"used_bytes,omitempty"`
+	InodesUsed *UInt64Value       `protobuf:"bytes,4,opt,name=inodes_used,json=inodesUsed" json:"inodes_used,omitempty"`
+}
+
+func (m *FilesystemUsage) Reset()         { *m = FilesystemUsage{} }
+func (m *FilesystemUsage) String() string { return proto.CompactTextString(m) }
+func (*FilesystemUsage) ProtoMessage()    {}
+
+type StorageIdentifier struct {
+	Uuid string `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+}
+
+func (m *StorageIdentifier) Reset()         { *m = StorageIdentifier{} }
+func (m *StorageIdentifier) String() string { return proto.CompactTextString(m) }
+func (*StorageIdentifier) ProtoMessage()    {}
+
+type UInt64Value struct {
+	Value uint64 `protobuf:"varint,1,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *UInt64Value) Reset()         { *m = UInt64Value{} }
+func (m *UInt64Value) String() string { return proto.CompactTextString(m) }
+func (*UInt64Value) ProtoMessage()    {}
@@ -0,0 +1,258 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cri
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const (
+	// Socket of the container runtime used unless the endpoint source option is set.
+	DefaultEndpoint = "unix:///var/run/crio/crio.sock"
+	// Longest a ListContainerStats request may take.
+	DefaultRequestTimeout = 10 * time.Second
+
+	// Labels the kubelet puts on the containers it creates through the CRI.
+	podNameLabel       = "io.kubernetes.pod.name"
+	podNamespaceLabel  = "io.kubernetes.pod.namespace"
+	podUIDLabel        = "io.kubernetes.pod.uid"
+	containerNameLabel = "io.kubernetes.container.name"
+
+	nodeNameEnv = "NODE_NAME"
+)
+
+var criRequestLatency = prometheus.NewSummary(
+	prometheus.SummaryOpts{
+		Namespace: "heapster",
+		Subsystem: "cri",
+		Name:      "request_duration_microseconds",
+		Help:      "The CRI ListContainerStats request latencies in microseconds.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(criRequestLatency)
+}
+
+// Container stats read from the container runtime of the local node through the CRI,
+// bypassing the kubelet. Used when metrics-server runs as an agent on every node.
+// The CRI has no node or pod level stats: only container metric sets are produced,
+// pods are filled in by the pod aggregator.
+type criMetricsSource struct {
+	nodeName string
+	hostName string
+	conn     *grpc.ClientConn
+	timeout  time.Duration
+}
+
+func (this *criMetricsSource) Name() string {
+	return this.String()
+}
+
+func (this *criMetricsSource) String() string {
+	return fmt.Sprintf("cri:%s", this.nodeName)
+}
+
+func (this *criMetricsSource) ScrapeMetrics(start, end time.Time) *DataBatch {
+	result := &DataBatch{
+		Timestamp:  time.Now(),
+		MetricSets: map[string]*MetricSet{},
+	}
+
+	response, err := func() (*ListContainerStatsResponse, error) {
+		startTime := time.Now()
+		defer func() {
+			criRequestLatency.Observe(float64(time.Since(startTime)) / float64(time.Microsecond))
+		}()
+		return this.listContainerStats()
+	}()
+	if err != nil {
+		glog.Errorf("error while getting container stats from the container runtime of node %s: %v", this.nodeName, err)
+		return result
+	}
+	result.MetricSets = this.decodeContainerStats(response.Stats)
+	return result
+}
+
+func (this *criMetricsSource) listContainerStats() (*ListContainerStatsResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), this.timeout)
+	defer cancel()
+	response := &ListContainerStatsResponse{}
+	if err := grpc.Invoke(ctx, listContainerStatsMethod, &ListContainerStatsRequest{Filter: &ContainerStatsFilter{}}, response, this.conn); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// decodeContainerStats translates the CRI container stats into the flattened heapster
+// MetricSet API. Containers not created by the kubelet are left out.
+func (this *criMetricsSource) decodeContainerStats(containers []*ContainerStats) map[string]*MetricSet {
+	result := map[string]*MetricSet{}
+	for _, container := range containers {
+		if container.Attributes == nil {
+			continue
+		}
+		labels := container.Attributes.Labels
+		namespace, pod, name := labels[podNamespaceLabel], labels[podNameLabel], labels[containerNameLabel]
+		if namespace == "" || pod == "" || name == "" {
+			glog.V(4).Infof("Skipping container %s without pod labels", container.Attributes.Id)
+			continue
+		}
+
+		containerMetrics := &MetricSet{
+			Labels: map[string]string{
+				LabelMetricSetType.Key: MetricSetTypePodContainer,
+				LabelNodename.Key:      this.nodeName,
+				LabelHostname.Key:      this.hostName,
+				LabelPodId.Key:         labels[podUIDLabel],
+				LabelPodName.Key:       pod,
+				LabelNamespaceName.Key: namespace,
+				LabelContainerName.Key: name,
+			},
+			MetricValues:   map[string]MetricValue{},
+			LabeledMetrics: []LabeledMetric{},
+		}
+		if cpu := container.Cpu; cpu != nil {
+			containerMetrics.ScrapeTime = unixNano(cpu.Timestamp)
+			addIntMetric(containerMetrics, &MetricCpuUsage, cpu.UsageCoreNanoSeconds)
+		}
+		if memory := container.Memory; memory != nil {
+			if containerMetrics.ScrapeTime.IsZero() {
+				containerMetrics.ScrapeTime = unixNano(memory.Timestamp)
+			}
+			addIntMetric(containerMetrics, &MetricMemoryWorkingSet, memory.WorkingSetBytes)
+		}
+		if fs := container.WritableLayer; fs != nil && fs.UsedBytes != nil {
+			containerMetrics.LabeledMetrics = append(containerMetrics.LabeledMetrics, LabeledMetric{
+				Name:   MetricFilesystemUsage.Name,
+				Labels: map[string]string{LabelResourceID.Key: "/"},
+				MetricValue: MetricValue{
+					ValueType:  ValueInt64,
+					MetricType: MetricFilesystemUsage.Type,
+					IntValue:   int64(fs.UsedBytes.Value),
+				},
+			})
+		}
+		result[PodContainerKey(namespace, pod, name)] = containerMetrics
+	}
+	return result
+}
+
+func unixNano(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, timestamp)
+}
+
+func addIntMetric(metrics *MetricSet, metric *Metric, value *UInt64Value) {
+	if value == nil {
+		glog.V(9).Infof("skipping metric %s because the value was nil", metric.Name)
+		return
+	}
+	metrics.MetricValues[metric.Name] = MetricValue{
+		ValueType:  ValueInt64,
+		MetricType: metric.Type,
+		IntValue:   int64(value.Value),
+	}
+}
+
+type criProvider struct {
+	source *criMetricsSource
+}
+
+func (this *criProvider) GetMetricsSources() []MetricsSource {
+	return []MetricsSource{this.source}
+}
+
+// NewCRIProvider creates a provider scraping the container runtime of the node it runs
+// on. The node is given by the nodeName source option or the NODE_NAME environment
+// variable, the runtime socket by the endpoint option.
+func NewCRIProvider(uri *url.URL) (MetricsSourceProvider, error) {
+	opts := uri.Query()
+
+	nodeName := os.Getenv(nodeNameEnv)
+	if len(opts["nodeName"]) >= 1 {
+		nodeName = opts["nodeName"][0]
+	}
+	if nodeName == "" {
+		return nil, fmt.Errorf("the CRI source requires the nodeName option or the %s environment variable", nodeNameEnv)
+	}
+
+	endpoint := DefaultEndpoint
+	if len(opts["endpoint"]) >= 1 {
+		endpoint = opts["endpoint"][0]
+	}
+	socket, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := DefaultRequestTimeout
+	if len(opts["timeout"]) >= 1 {
+		timeout, err = time.ParseDuration(opts["timeout"][0])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout: %q must be a positive duration", opts["timeout"][0])
+		}
+	}
+
+	hostName, err := os.Hostname()
+	if err != nil {
+		hostName = nodeName
+	}
+
+	// The connection is established lazily and re-established as needed by grpc.
+	conn, err := grpc.Dial(socket, grpc.WithInsecure(), grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", addr, timeout)
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the container runtime at %s: %v", endpoint, err)
+	}
+
+	return &criProvider{source: &criMetricsSource{
+		nodeName: nodeName,
+		hostName: hostName,
+		conn:     conn,
+		timeout:  timeout,
+	}}, nil
+}
+
+// parseEndpoint returns the path of the unix socket of the endpoint, given as a path
+// or a unix:// URL.
+func parseEndpoint(endpoint string) (string, error) {
+	if !strings.Contains(endpoint, "://") {
+		return endpoint, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %v", endpoint, err)
+	}
+	if u.Scheme != "unix" {
+		return "", fmt.Errorf("invalid endpoint %q: only unix sockets are supported", endpoint)
+	}
+	return u.Path, nil
+}
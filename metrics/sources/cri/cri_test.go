@@ -0,0 +1,123 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cri
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+type fakeRuntimeService struct {
+	response *ListContainerStatsResponse
+}
+
+func (this *fakeRuntimeService) serve(t *testing.T, socket string) *grpc.Server {
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "runtime.v1alpha2.RuntimeService",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "ListContainerStats",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				if err := dec(&ListContainerStatsRequest{}); err != nil {
+					return nil, err
+				}
+				return this.response, nil
+			},
+		}},
+	}, this)
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	go server.Serve(listener)
+	return server
+}
+
+func TestCRIMetricsSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cri")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "runtime.sock")
+
+	now := time.Now().Truncate(time.Second)
+	service := &fakeRuntimeService{response: &ListContainerStatsResponse{Stats: []*ContainerStats{
+		{
+			Attributes: &ContainerAttributes{Id: "c1", Labels: map[string]string{
+				podNamespaceLabel:  "ns1",
+				podNameLabel:       "pod1",
+				podUIDLabel:        "uid1",
+				containerNameLabel: "app",
+			}},
+			Cpu:           &CpuUsage{Timestamp: now.UnixNano(), UsageCoreNanoSeconds: &UInt64Value{Value: 5000}},
+			Memory:        &MemoryUsage{Timestamp: now.UnixNano(), WorkingSetBytes: &UInt64Value{Value: 1024}},
+			WritableLayer: &FilesystemUsage{UsedBytes: &UInt64Value{Value: 2048}},
+		},
+		{
+			Attributes: &ContainerAttributes{Id: "c2", Labels: map[string]string{}},
+			Cpu:        &CpuUsage{Timestamp: now.UnixNano(), UsageCoreNanoSeconds: &UInt64Value{Value: 1}},
+		},
+	}}}
+	server := service.serve(t, socket)
+	defer server.Stop()
+
+	provider, err := NewCRIProvider(&url.URL{RawQuery: url.Values{
+		"nodeName": {"node1"},
+		"endpoint": {"unix://" + socket},
+	}.Encode()})
+	require.NoError(t, err)
+	sources := provider.GetMetricsSources()
+	require.Len(t, sources, 1)
+	assert.Equal(t, "cri:node1", sources[0].Name())
+
+	batch := sources[0].ScrapeMetrics(now.Add(-time.Minute), now)
+	require.Len(t, batch.MetricSets, 1, "container without pod labels is left out")
+	ms := batch.MetricSets[core.PodContainerKey("ns1", "pod1", "app")]
+	require.NotNil(t, ms)
+	assert.Equal(t, core.MetricSetTypePodContainer, ms.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, "node1", ms.Labels[core.LabelNodename.Key])
+	assert.Equal(t, "uid1", ms.Labels[core.LabelPodId.Key])
+	assert.True(t, now.Equal(ms.ScrapeTime))
+	assert.Equal(t, int64(5000), ms.MetricValues[core.MetricCpuUsage.Name].IntValue)
+	assert.Equal(t, int64(1024), ms.MetricValues[core.MetricMemoryWorkingSet.Name].IntValue)
+	require.Len(t, ms.LabeledMetrics, 1)
+	assert.Equal(t, int64(2048), ms.LabeledMetrics[0].IntValue)
+}
+
+func TestNewCRIProviderOptions(t *testing.T) {
+	os.Unsetenv(nodeNameEnv)
+	_, err := NewCRIProvider(&url.URL{})
+	assert.Error(t, err, "node name is required")
+
+	_, err = NewCRIProvider(&url.URL{RawQuery: "nodeName=node1&endpoint=tcp://localhost:1234"})
+	assert.Error(t, err, "only unix sockets")
+
+	_, err = NewCRIProvider(&url.URL{RawQuery: "nodeName=node1&timeout=0s"})
+	assert.Error(t, err)
+
+	socket, err := parseEndpoint("/var/run/containerd/containerd.sock")
+	require.NoError(t, err)
+	assert.Equal(t, "/var/run/containerd/containerd.sock", socket)
+}
@@ -19,11 +19,16 @@ import (
 
 	"github.com/kubernetes-incubator/metrics-server/common/flags"
 	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/cri"
 	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
 	"github.com/kubernetes-incubator/metrics-server/metrics/sources/summary"
+	v1listers "k8s.io/client-go/listers/core/v1"
 )
 
 type SourceFactory struct {
+	// Lister of all pods shared with the sources which watch pods, nil if they watch them
+	// themselves.
+	podLister v1listers.PodLister
 }
 
 func (this *SourceFactory) Build(uri flags.Uri) (core.MetricsSourceProvider, error) {
@@ -32,7 +37,10 @@ func (this *SourceFactory) Build(uri flags.Uri) (core.MetricsSourceProvider, err
 		provider, err := kubelet.NewKubeletProvider(&uri.Val)
 		return provider, err
 	case "kubernetes.summary_api":
-		provider, err := summary.NewSummaryProvider(&uri.Val)
+		provider, err := summary.NewSummaryProvider(&uri.Val, this.podLister)
+		return provider, err
+	case "kubernetes.cri_api":
+		provider, err := cri.NewCRIProvider(&uri.Val)
 		return provider, err
 	default:
 		return nil, fmt.Errorf("Source not recognized: %s", uri.Key)
@@ -46,6 +54,7 @@ func (this *SourceFactory) BuildAll(uris flags.Uris) (core.MetricsSourceProvider
 	return this.Build(uris[0])
 }
 
-func NewSourceFactory() *SourceFactory {
-	return &SourceFactory{}
+// NewSourceFactory returns a factory of sources sharing the pod lister, which may be nil.
+func NewSourceFactory(podLister v1listers.PodLister) *SourceFactory {
+	return &SourceFactory{podLister: podLister}
 }
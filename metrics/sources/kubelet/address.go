@@ -0,0 +1,162 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Name of the resolver used when the source doesn't select one.
+const DefaultNodeAddressResolver = "priority"
+
+// Address types tried by the priority resolver unless configured otherwise.
+var DefaultAddressTypes = []corev1.NodeAddressType{corev1.NodeInternalIP}
+
+// WithPreferredAddressFamily returns the source URI with the preferredAddressFamily
+// option set to the family, unless it's empty or the URI sets it already.
+func WithPreferredAddressFamily(uri *url.URL, family string) *url.URL {
+	result := *uri
+	opts := result.Query()
+	if family != "" && len(opts["preferredAddressFamily"]) == 0 {
+		opts.Set("preferredAddressFamily", family)
+	}
+	result.RawQuery = opts.Encode()
+	return &result
+}
+
+// NodeAddressResolver resolves where the kubelet of a node is scraped.
+type NodeAddressResolver interface {
+	// ResolveNodeAddress returns the hostname of the node and the host its kubelet
+	// is reached at.
+	ResolveNodeAddress(node *corev1.Node) (string, Host, error)
+}
+
+// NodeAddressResolverFactory creates a resolver from the source options, for kubelets
+// listening on the given port.
+type NodeAddressResolverFactory func(opts url.Values, port int) (NodeAddressResolver, error)
+
+var (
+	resolverFactoriesLock sync.Mutex
+	resolverFactories     = map[string]NodeAddressResolverFactory{
+		DefaultNodeAddressResolver: newPriorityNodeAddressResolver,
+	}
+)
+
+// RegisterNodeAddressResolver makes a resolver available to the sources under the name,
+// e.g. one resolving private endpoints of the kubelets through a cloud API. It's meant
+// to be called from init functions.
+func RegisterNodeAddressResolver(name string, factory NodeAddressResolverFactory) {
+	resolverFactoriesLock.Lock()
+	defer resolverFactoriesLock.Unlock()
+	if _, found := resolverFactories[name]; found {
+		panic(fmt.Sprintf("node address resolver %q registered twice", name))
+	}
+	resolverFactories[name] = factory
+}
+
+// NewNodeAddressResolver creates the resolver selected by the nodeAddressResolver source
+// option, the priority resolver by default.
+func NewNodeAddressResolver(opts url.Values, port int) (NodeAddressResolver, error) {
+	name := DefaultNodeAddressResolver
+	if len(opts["nodeAddressResolver"]) >= 1 {
+		name = opts["nodeAddressResolver"][0]
+	}
+	resolverFactoriesLock.Lock()
+	factory, found := resolverFactories[name]
+	names := make([]string, 0, len(resolverFactories))
+	for name := range resolverFactories {
+		names = append(names, name)
+	}
+	resolverFactoriesLock.Unlock()
+	if !found {
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown node address resolver %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return factory(opts, port)
+}
+
+// PriorityNodeAddressResolver scrapes nodes at their address of the first type in the
+// list they have. If a node has several addresses of that type, e.g. a dual-stack node
+// reporting an InternalIP of each family, the first one of the preferred family is used,
+// and the last one if the node has none of that family or no family is preferred.
+type PriorityNodeAddressResolver struct {
+	AddressTypes []corev1.NodeAddressType
+	// util.AddressFamilyIPv4, util.AddressFamilyIPv6, or empty.
+	AddressFamily string
+	Port          int
+}
+
+func newPriorityNodeAddressResolver(opts url.Values, port int) (NodeAddressResolver, error) {
+	addressTypes := DefaultAddressTypes
+	if len(opts["nodeAddressTypes"]) >= 1 {
+		addressTypes = nil
+		for _, value := range strings.Split(opts["nodeAddressTypes"][0], ",") {
+			addressType := corev1.NodeAddressType(strings.TrimSpace(value))
+			switch addressType {
+			case corev1.NodeHostName, corev1.NodeInternalIP, corev1.NodeExternalIP, corev1.NodeInternalDNS, corev1.NodeExternalDNS:
+				addressTypes = append(addressTypes, addressType)
+			default:
+				return nil, fmt.Errorf("invalid nodeAddressTypes: unknown address type %q", addressType)
+			}
+		}
+	}
+	family := ""
+	if len(opts["preferredAddressFamily"]) >= 1 {
+		family = strings.ToLower(opts["preferredAddressFamily"][0])
+		if err := util.ValidateAddressFamily(family); err != nil {
+			return nil, fmt.Errorf("invalid preferredAddressFamily: %v", err)
+		}
+	}
+	return &PriorityNodeAddressResolver{AddressTypes: addressTypes, AddressFamily: family, Port: port}, nil
+}
+
+func (this *PriorityNodeAddressResolver) ResolveNodeAddress(node *corev1.Node) (string, Host, error) {
+	hostname := node.Name
+	addresses := make(map[corev1.NodeAddressType][]string, len(node.Status.Addresses))
+	for _, addr := range node.Status.Addresses {
+		if addr.Address == "" {
+			continue
+		}
+		if addr.Type == corev1.NodeHostName {
+			hostname = addr.Address
+		}
+		addresses[addr.Type] = append(addresses[addr.Type], addr.Address)
+	}
+
+	for _, addressType := range this.AddressTypes {
+		if candidates, found := addresses[addressType]; found {
+			address := this.selectAddress(candidates)
+			return hostname, Host{IP: address, Port: this.Port, ServerName: hostname, AddressType: string(addressType)}, nil
+		}
+	}
+	return hostname, Host{}, fmt.Errorf("Node %v has no address of types %v", node.Name, this.AddressTypes)
+}
+
+// selectAddress returns the first of the addresses in the preferred family, or the last one.
+func (this *PriorityNodeAddressResolver) selectAddress(addresses []string) string {
+	for _, address := range addresses {
+		if this.AddressFamily != "" && util.AddressFamilyOf(address) == this.AddressFamily {
+			return address
+		}
+	}
+	return addresses[len(addresses)-1]
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var nodeAddressChanges = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "kubelet",
+		Name:      "node_address_changes_total",
+		Help:      "Number of times the resolved kubelet address of a known node changed.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(nodeAddressChanges)
+}
+
+// CachingNodeAddressResolver keeps the address resolved for every node until the node
+// lister reports a change of the fields it is resolved from, so that nodes are not resolved
+// again in every scrape cycle. Failed resolutions are retried every time. It has to be
+// passed to util.GetNodeLister as a handler of the node events.
+type CachingNodeAddressResolver struct {
+	resolver NodeAddressResolver
+	lock     sync.Mutex
+	entries  map[string]*resolvedNodeAddress
+	// Incremented by every invalidation, so that a resolution which raced with one is
+	// not cached.
+	generation uint64
+}
+
+type resolvedNodeAddress struct {
+	generation uint64
+	// Whether the address is still valid. The last address is kept after an invalidation
+	// to tell whether the next resolution changed it.
+	valid    bool
+	hostname string
+	host     Host
+}
+
+func NewCachingNodeAddressResolver(resolver NodeAddressResolver) *CachingNodeAddressResolver {
+	return &CachingNodeAddressResolver{
+		resolver: resolver,
+		entries:  map[string]*resolvedNodeAddress{},
+	}
+}
+
+func (this *CachingNodeAddressResolver) ResolveNodeAddress(node *corev1.Node) (string, Host, error) {
+	this.lock.Lock()
+	var generation uint64
+	if entry, found := this.entries[node.Name]; found {
+		if entry.valid {
+			this.lock.Unlock()
+			return entry.hostname, entry.host, nil
+		}
+		generation = entry.generation
+	}
+	this.lock.Unlock()
+
+	hostname, host, err := this.resolver.ResolveNodeAddress(node)
+	if err != nil {
+		return hostname, host, err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry, found := this.entries[node.Name]
+	if !found {
+		if generation != 0 {
+			// Deleted while being resolved.
+			return hostname, host, nil
+		}
+		entry = &resolvedNodeAddress{}
+		this.entries[node.Name] = entry
+	} else if entry.generation != generation {
+		return hostname, host, nil
+	} else if entry.host != host || entry.hostname != hostname {
+		glog.V(2).Infof("Kubelet address of node %s changed from %s:%d to %s:%d", node.Name, entry.host.IP, entry.host.Port, host.IP, host.Port)
+		nodeAddressChanges.Inc()
+	}
+	entry.valid = true
+	entry.hostname = hostname
+	entry.host = host
+	return hostname, host, nil
+}
+
+func (this *CachingNodeAddressResolver) OnAdd(obj interface{}) {
+	if node, ok := obj.(*corev1.Node); ok {
+		this.invalidate(node.Name)
+	}
+}
+
+func (this *CachingNodeAddressResolver) OnUpdate(oldObj, newObj interface{}) {
+	old, ok := oldObj.(*corev1.Node)
+	node, newOk := newObj.(*corev1.Node)
+	if !newOk {
+		return
+	}
+	if !ok || addressFieldsChanged(old, node) {
+		this.invalidate(node.Name)
+	}
+}
+
+func (this *CachingNodeAddressResolver) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if node, ok := obj.(*corev1.Node); ok {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		delete(this.entries, node.Name)
+	}
+}
+
+// invalidate makes the next resolution of the node resolve it again, and any resolution
+// in progress, which may be based on an older version of the node, not be cached.
+func (this *CachingNodeAddressResolver) invalidate(name string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if entry, found := this.entries[name]; found {
+		this.generation++
+		entry.generation = this.generation
+		entry.valid = false
+	}
+}
+
+// addressFieldsChanged tells whether a node changed in one of the fields its address is
+// resolved from: its addresses and kubelet port, and, for registered resolvers, its provider
+// ID, labels and annotations. The conditions and heartbeats, which change all the time, are
+// left out.
+func addressFieldsChanged(old, node *corev1.Node) bool {
+	return !reflect.DeepEqual(old.Status.Addresses, node.Status.Addresses) ||
+		old.Status.DaemonEndpoints.KubeletEndpoint.Port != node.Status.DaemonEndpoints.KubeletEndpoint.Port ||
+		old.Spec.ProviderID != node.Spec.ProviderID ||
+		!reflect.DeepEqual(old.Labels, node.Labels) ||
+		!reflect.DeepEqual(old.Annotations, node.Annotations)
+}
@@ -0,0 +1,249 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+type staticNodeAddressResolver struct{}
+
+func (this *staticNodeAddressResolver) ResolveNodeAddress(node *corev1.Node) (string, Host, error) {
+	return node.Name, Host{IP: "10.0.0.1", Port: 443}, nil
+}
+
+func TestPriorityNodeAddressResolver(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "node1.example.com"},
+			{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+			{Type: corev1.NodeInternalIP, Address: "192.168.0.1"},
+		}},
+	}
+
+	resolver, err := NewNodeAddressResolver(url.Values{}, 10250)
+	require.NoError(t, err)
+	hostname, host, err := resolver.ResolveNodeAddress(node)
+	require.NoError(t, err)
+	assert.Equal(t, "node1.example.com", hostname)
+	assert.Equal(t, Host{IP: "192.168.0.1", Port: 10250, ServerName: "node1.example.com", AddressType: "InternalIP"}, host)
+
+	resolver, err = NewNodeAddressResolver(url.Values{"nodeAddressTypes": {"InternalDNS,ExternalIP,InternalIP"}}, 10250)
+	require.NoError(t, err)
+	_, host, err = resolver.ResolveNodeAddress(node)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.1", host.IP, "first type the node has an address of")
+
+	resolver, err = NewNodeAddressResolver(url.Values{"nodeAddressTypes": {"InternalDNS"}}, 10250)
+	require.NoError(t, err)
+	_, _, err = resolver.ResolveNodeAddress(node)
+	assert.Error(t, err, "no address of the types")
+
+	_, err = NewNodeAddressResolver(url.Values{"nodeAddressTypes": {"PrivateLink"}}, 10250)
+	assert.Error(t, err)
+}
+
+func TestRegisterNodeAddressResolver(t *testing.T) {
+	_, err := NewNodeAddressResolver(url.Values{"nodeAddressResolver": {"static"}}, 10250)
+	assert.Error(t, err, "unknown resolver")
+
+	RegisterNodeAddressResolver("static", func(opts url.Values, port int) (NodeAddressResolver, error) {
+		return &staticNodeAddressResolver{}, nil
+	})
+	defer func() {
+		resolverFactoriesLock.Lock()
+		delete(resolverFactories, "static")
+		resolverFactoriesLock.Unlock()
+	}()
+	resolver, err := NewNodeAddressResolver(url.Values{"nodeAddressResolver": {"static"}}, 10250)
+	require.NoError(t, err)
+	_, host, err := resolver.ResolveNodeAddress(&corev1.Node{})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", host.IP)
+
+	assert.Panics(t, func() {
+		RegisterNodeAddressResolver(DefaultNodeAddressResolver, newPriorityNodeAddressResolver)
+	})
+}
+
+// Resolver counting its resolutions and resolving nodes at their first address.
+type countingNodeAddressResolver struct {
+	count int
+}
+
+func (this *countingNodeAddressResolver) ResolveNodeAddress(node *corev1.Node) (string, Host, error) {
+	this.count++
+	if len(node.Status.Addresses) == 0 {
+		return node.Name, Host{}, fmt.Errorf("node %s has no address", node.Name)
+	}
+	return node.Name, Host{IP: node.Status.Addresses[0].Address, Port: 10250}, nil
+}
+
+func nodeAddressChangesValue(t *testing.T) float64 {
+	out := &dto.Metric{}
+	require.NoError(t, nodeAddressChanges.Write(out))
+	return out.GetCounter().GetValue()
+}
+
+func TestCachingNodeAddressResolver(t *testing.T) {
+	counting := &countingNodeAddressResolver{}
+	resolver := NewCachingNodeAddressResolver(counting)
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{
+			Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "192.168.0.1"}},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	changes := nodeAddressChangesValue(t)
+
+	resolver.OnAdd(node)
+	_, host, err := resolver.ResolveNodeAddress(node)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.0.1", host.IP)
+	heartbeat := node.DeepCopy()
+	heartbeat.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+	resolver.OnUpdate(node, heartbeat)
+	_, host, err = resolver.ResolveNodeAddress(heartbeat)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.0.1", host.IP)
+	assert.Equal(t, 1, counting.count, "heartbeats don't change the address")
+
+	labeled := heartbeat.DeepCopy()
+	labeled.Labels = map[string]string{"zone": "a"}
+	resolver.OnUpdate(heartbeat, labeled)
+	_, host, err = resolver.ResolveNodeAddress(labeled)
+	require.NoError(t, err)
+	assert.Equal(t, 2, counting.count, "labels may change the address")
+	assert.Equal(t, changes, nodeAddressChangesValue(t), "resolved again to the same address")
+
+	moved := labeled.DeepCopy()
+	moved.Status.Addresses[0].Address = "192.168.0.2"
+	resolver.OnUpdate(labeled, moved)
+	_, host, err = resolver.ResolveNodeAddress(moved)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.0.2", host.IP)
+	assert.Equal(t, 3, counting.count)
+	assert.Equal(t, changes+1, nodeAddressChangesValue(t))
+
+	unaddressed := moved.DeepCopy()
+	unaddressed.Status.Addresses = nil
+	resolver.OnUpdate(moved, unaddressed)
+	for i := 0; i < 2; i++ {
+		_, _, err = resolver.ResolveNodeAddress(unaddressed)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 5, counting.count, "failures are not cached")
+
+	resolver.OnDelete(cache.DeletedFinalStateUnknown{Key: "node1", Obj: unaddressed})
+	assert.Empty(t, resolver.entries)
+}
+
+func TestCachingNodeAddressResolverRacingInvalidation(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "192.168.0.1"}}},
+	}
+	moved := node.DeepCopy()
+	moved.Status.Addresses[0].Address = "192.168.0.2"
+	movedAgain := node.DeepCopy()
+	movedAgain.Status.Addresses[0].Address = "192.168.0.3"
+	racing := &racingNodeAddressResolver{}
+	resolver := NewCachingNodeAddressResolver(racing)
+
+	resolver.OnAdd(node)
+	_, _, err := resolver.ResolveNodeAddress(node)
+	require.NoError(t, err)
+	resolver.OnUpdate(node, moved)
+	racing.during = func() { resolver.OnUpdate(moved, movedAgain) }
+	_, host, err := resolver.ResolveNodeAddress(moved)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.0.2", host.IP)
+
+	racing.during = nil
+	_, host, err = resolver.ResolveNodeAddress(movedAgain)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.0.3", host.IP, "the racing resolution was not cached")
+	_, _, err = resolver.ResolveNodeAddress(movedAgain)
+	require.NoError(t, err)
+	assert.Equal(t, 3, racing.count)
+}
+
+// Resolver calling a function in the middle of every resolution.
+type racingNodeAddressResolver struct {
+	countingNodeAddressResolver
+	during func()
+}
+
+func (this *racingNodeAddressResolver) ResolveNodeAddress(node *corev1.Node) (string, Host, error) {
+	if this.during != nil {
+		this.during()
+	}
+	return this.countingNodeAddressResolver.ResolveNodeAddress(node)
+}
+
+func TestPriorityNodeAddressResolverAddressFamily(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "fd00::1"},
+			{Type: corev1.NodeInternalIP, Address: "192.168.0.1"},
+			{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+		}},
+	}
+
+	resolver, err := NewNodeAddressResolver(url.Values{}, 10250)
+	require.NoError(t, err)
+	_, host, err := resolver.ResolveNodeAddress(node)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.0.1", host.IP, "last address without preferred family")
+
+	resolver, err = NewNodeAddressResolver(url.Values{"preferredAddressFamily": {"ipv6"}}, 10250)
+	require.NoError(t, err)
+	_, host, err = resolver.ResolveNodeAddress(node)
+	require.NoError(t, err)
+	assert.Equal(t, "fd00::1", host.IP)
+
+	resolver, err = NewNodeAddressResolver(url.Values{"nodeAddressTypes": {"ExternalIP"}, "preferredAddressFamily": {"IPv6"}}, 10250)
+	require.NoError(t, err)
+	_, host, err = resolver.ResolveNodeAddress(node)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.1", host.IP, "falls back to the other family")
+
+	_, err = NewNodeAddressResolver(url.Values{"preferredAddressFamily": {"ipv5"}}, 10250)
+	assert.Error(t, err)
+}
+
+func TestWithPreferredAddressFamily(t *testing.T) {
+	uri, err := url.Parse("https://kubernetes.default?useServiceAccount=true")
+	require.NoError(t, err)
+	assert.Equal(t, uri.String(), WithPreferredAddressFamily(uri, "").String())
+	assert.Equal(t, []string{"ipv6"}, WithPreferredAddressFamily(uri, "ipv6").Query()["preferredAddressFamily"])
+
+	uri, err = url.Parse("https://kubernetes.default?preferredAddressFamily=ipv4")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ipv4"}, WithPreferredAddressFamily(uri, "ipv6").Query()["preferredAddressFamily"], "the source option wins")
+}
@@ -58,6 +58,14 @@ func GetKubeConfigs(uri *url.URL) (*kube_client.Config, *kubelet_client.KubeletC
 		}
 	}
 
+	kubeletVerifyNodeAddresses := false
+	if len(opts["kubeletVerifyNodeAddresses"]) >= 1 {
+		kubeletVerifyNodeAddresses, err = strconv.ParseBool(opts["kubeletVerifyNodeAddresses"][0])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	glog.Infof("Using Kubernetes client with master %q and version %+v\n", kubeConfig.Host, kubeConfig.GroupVersion)
 	glog.Infof("Using kubelet port %d", kubeletPort)
 
@@ -66,6 +74,8 @@ func GetKubeConfigs(uri *url.URL) (*kube_client.Config, *kubelet_client.KubeletC
 		EnableHttps:     kubeletHttps,
 		TLSClientConfig: kubeConfig.TLSClientConfig,
 		BearerToken:     kubeConfig.BearerToken,
+
+		VerifyNodeAddresses: kubeletVerifyNodeAddresses,
 	}
 
 	return kubeConfig, kubeletConfig, nil
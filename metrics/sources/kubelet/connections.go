@@ -0,0 +1,70 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	kubeletConnections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet",
+			Name:      "connections_total",
+			Help:      "Number of connections requests to kubelets were sent on, by whether an idle connection of the pool was reused.",
+		},
+		[]string{"reused"},
+	)
+	kubeletTLSHandshakes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet",
+			Name:      "tls_handshakes_total",
+			Help:      "Number of TLS handshakes with kubelets, by negotiated protocol, e.g. h2 for HTTP/2.",
+		},
+		[]string{"protocol"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(kubeletConnections)
+	prometheus.MustRegister(kubeletTLSHandshakes)
+}
+
+// traceConnection returns the request with a trace recording whether it reused a
+// connection of the pool, and the handshake of new TLS connections.
+func traceConnection(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			kubeletConnections.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				protocol := state.NegotiatedProtocol
+				if protocol == "" {
+					protocol = "http/1.1"
+				}
+				kubeletTLSHandshakes.WithLabelValues(protocol).Inc()
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
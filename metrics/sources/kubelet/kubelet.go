@@ -250,7 +250,9 @@ func (this *kubeletMetricsSource) ScrapeMetrics(start, end time.Time) *DataBatch
 
 func (this *kubeletMetricsSource) scrapeKubelet(client *KubeletClient, host Host, start, end time.Time) ([]cadvisor.ContainerInfo, error) {
 	startTime := time.Now()
-	defer kubeletRequestLatency.WithLabelValues(this.hostname).Observe(float64(time.Since(startTime)))
+	defer func() {
+		kubeletRequestLatency.WithLabelValues(this.hostname).Observe(float64(time.Since(startTime)) / float64(time.Microsecond))
+	}()
 	return client.GetAllRawContainers(host, start, end)
 }
 
@@ -325,6 +327,10 @@ func NewKubeletProvider(uri *url.URL) (MetricsSourceProvider, error) {
 	if err != nil {
 		return nil, err
 	}
+	nodeSelector, err := util.ParseNodeSelector(uri.Query())
+	if err != nil {
+		return nil, err
+	}
 
 	// Get nodes to test if the client is configured well. Watch gives less error information.
 	if _, err := kubeClient.CoreV1().Nodes().List(metav1.ListOptions{}); err != nil {
@@ -332,7 +338,7 @@ func NewKubeletProvider(uri *url.URL) (MetricsSourceProvider, error) {
 	}
 
 	// watch nodes
-	nodeLister, reflector, _ := util.GetNodeLister(kubeClient)
+	nodeLister, reflector, _ := util.GetNodeLister(kubeClient, nodeSelector)
 
 	return &kubeletProvider{
 		nodeLister:    nodeLister,
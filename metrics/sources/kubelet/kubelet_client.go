@@ -20,15 +20,23 @@ package kubelet
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	cadvisor "github.com/google/cadvisor/info/v1"
 	kubelet_client "github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet/util"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
 )
 
@@ -36,11 +44,23 @@ type Host struct {
 	IP       string
 	Port     int
 	Resource string
+	// Name of the node sent for SNI when the client verifies node addresses.
+	ServerName string
+	// Scheme and host:port the kubelet is reached at instead of IP and Port, e.g. a
+	// load balancer in front of it. Empty unless set by a URLRewriter.
+	Scheme  string
+	Address string
+	// Type of the node address the IP was taken from, empty if unknown.
+	AddressType string
 }
 
 type KubeletClient struct {
 	config *kubelet_client.KubeletClientConfig
 	client *http.Client
+
+	// Clients with per-node TLS configuration, keyed by server name and IP.
+	hostClientsLock sync.Mutex
+	hostClients     map[string]*http.Client
 }
 
 type ErrNotFound struct {
@@ -51,6 +71,34 @@ func (err *ErrNotFound) Error() string {
 	return fmt.Sprintf("%q not found", err.endpoint)
 }
 
+// ErrStatus is returned for responses with a status other than OK, Not Found and Not
+// Modified, e.g. when the scraper isn't authorized.
+type ErrStatus struct {
+	StatusCode int
+	status     string
+	body       string
+}
+
+func (err *ErrStatus) Error() string {
+	return fmt.Sprintf("request failed - %q, response: %q", err.status, err.body)
+}
+
+// ErrDecode is returned for responses which can't be decoded.
+type ErrDecode struct {
+	message string
+}
+
+func (err *ErrDecode) Error() string {
+	return err.message
+}
+
+func newDecodeError(format string, args ...interface{}) error {
+	return &ErrDecode{message: fmt.Sprintf(format, args...)}
+}
+
+// Returned for conditional requests whose response didn't change.
+var errNotModified = errors.New("not modified")
+
 func IsNotFoundError(err error) bool {
 	_, isNotFound := err.(*ErrNotFound)
 	return isNotFound
@@ -64,19 +112,39 @@ func sampleContainerStats(stats []*cadvisor.ContainerStats) []*cadvisor.Containe
 }
 
 func (self *KubeletClient) postRequestAndGetValue(client *http.Client, req *http.Request, value interface{}) error {
-	response, err := client.Do(req)
+	_, err := self.doRequestAndGetValue(client, req, value)
+	return err
+}
+
+// doRequestAndGetValue decodes the response into the value and returns the response headers.
+func (self *KubeletClient) doRequestAndGetValue(client *http.Client, req *http.Request, value interface{}) (http.Header, error) {
+	header, body, err := self.doRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, value); err != nil {
+		return nil, newDecodeError("failed to parse output. Response: %q. Error: %v", string(body), err)
+	}
+	return header, nil
+}
+
+// doRequest returns the headers and body of a successful response.
+func (self *KubeletClient) doRequest(client *http.Client, req *http.Request) (http.Header, []byte, error) {
+	response, err := client.Do(traceConnection(req))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer response.Body.Close()
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response body - %v", err)
+		return nil, nil, fmt.Errorf("failed to read response body - %v", err)
 	}
 	if response.StatusCode == http.StatusNotFound {
-		return &ErrNotFound{req.URL.String()}
+		return nil, nil, &ErrNotFound{req.URL.String()}
+	} else if response.StatusCode == http.StatusNotModified {
+		return response.Header, nil, errNotModified
 	} else if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("request failed - %q, response: %q", response.Status, string(body))
+		return nil, nil, &ErrStatus{StatusCode: response.StatusCode, status: response.Status, body: string(body)}
 	}
 
 	kubeletAddr := "[unknown]"
@@ -84,12 +152,16 @@ func (self *KubeletClient) postRequestAndGetValue(client *http.Client, req *http
 		kubeletAddr = req.URL.Host
 	}
 	glog.V(10).Infof("Raw response from Kubelet at %s: %s", kubeletAddr, string(body))
+	return response.Header, body, nil
+}
 
-	err = json.Unmarshal(body, value)
-	if err != nil {
-		return fmt.Errorf("failed to parse output. Response: %q. Error: %v", string(body), err)
+// getCacheAge returns the age of a cached response advertised with the Age header.
+func getCacheAge(header http.Header) time.Duration {
+	age, err := strconv.Atoi(header.Get("Age"))
+	if err != nil || age < 0 {
+		return 0
 	}
-	return nil
+	return time.Duration(age) * time.Second
 }
 
 func (self *KubeletClient) parseStat(containerInfo *cadvisor.ContainerInfo) *cadvisor.ContainerInfo {
@@ -131,32 +203,357 @@ func (self *KubeletClient) GetAllRawContainers(host Host, start, end time.Time)
 		scheme = "https"
 	}
 
-	url := fmt.Sprintf("%s://%s:%d/stats/container/", scheme, host.IP, host.Port)
+	url := fmt.Sprintf("%s://%s/stats/container/", scheme, net.JoinHostPort(host.IP, strconv.Itoa(host.Port)))
 
 	return self.getAllContainers(url, start, end)
 }
 
 func (self *KubeletClient) GetSummary(host Host) (*stats.Summary, error) {
+	summary, _, err := self.GetSummaryWithCacheAge(host)
+	return summary, err
+}
+
+// GetSummaryWithCacheAge also returns for how long the kubelet has been serving the summary
+// from its cache, if it advertises it with the Age header.
+func (self *KubeletClient) GetSummaryWithCacheAge(host Host) (*stats.Summary, time.Duration, error) {
+	summary, _, cacheAge, err := self.GetSummaryWithPodUsage(host)
+	return summary, cacheAge, err
+}
+
+// PodUsageStats holds the cpu and memory usage of a pod cgroup, which newer kubelets report
+// in the summary and includes the pause container and the pod overhead. The vendored
+// summary types predate these fields.
+type PodUsageStats struct {
+	PodRef stats.PodReference `json:"podRef"`
+	CPU    *stats.CPUStats    `json:"cpu,omitempty"`
+	Memory *stats.MemoryStats `json:"memory,omitempty"`
+	// Swap usage and CPU throttling of the containers, if the kubelet reports them.
+	Containers []ContainerUsageStats `json:"containers,omitempty"`
+}
+
+// ContainerUsageStats holds the stats of a container the vendored summary types lack.
+type ContainerUsageStats struct {
+	Name string              `json:"name"`
+	Swap *SwapStats          `json:"swap,omitempty"`
+	CPU  *CPUThrottlingStats `json:"cpu,omitempty"`
+}
+
+// CPUThrottlingStats holds the time a container was throttled by its CFS quota, which
+// kubelets exposing the CFS stats of cadvisor report next to the cpu usage.
+type CPUThrottlingStats struct {
+	ThrottledTimeNanoSeconds *uint64 `json:"throttledTimeNanoSeconds,omitempty"`
+}
+
+// SwapStats holds the swap usage of a node or container, which kubelets report in the
+// summary when the NodeSwap feature is enabled.
+type SwapStats struct {
+	Time               metav1.Time `json:"time"`
+	SwapAvailableBytes *uint64     `json:"swapAvailableBytes,omitempty"`
+	SwapUsageBytes     *uint64     `json:"swapUsageBytes,omitempty"`
+}
+
+// SummaryExtensions holds the stats of the summary which the vendored types lack.
+type SummaryExtensions struct {
+	Node struct {
+		Swap *SwapStats `json:"swap,omitempty"`
+	} `json:"node"`
+	Pods []PodUsageStats `json:"pods"`
+}
+
+// extendedSummary is a summary with the stats the vendored types lack, so that the summary
+// is decoded in a single pass. The fields of the extended types shadow the embedded ones
+// with the same names.
+type extendedSummary struct {
+	Node extendedNodeStats  `json:"node"`
+	Pods []extendedPodStats `json:"pods"`
+}
+
+type extendedNodeStats struct {
+	stats.NodeStats
+	Swap *SwapStats `json:"swap,omitempty"`
+}
+
+type extendedPodStats struct {
+	stats.PodStats
+	CPU        *stats.CPUStats          `json:"cpu,omitempty"`
+	Memory     *stats.MemoryStats       `json:"memory,omitempty"`
+	Containers []extendedContainerStats `json:"containers"`
+}
+
+type extendedContainerStats struct {
+	stats.ContainerStats
+	CPU  *extendedCPUStats `json:"cpu,omitempty"`
+	Swap *SwapStats        `json:"swap,omitempty"`
+}
+
+type extendedCPUStats struct {
+	stats.CPUStats
+	CPUThrottlingStats
+}
+
+// split returns the summary in the vendored types, and the extensions.
+func (this *extendedSummary) split() (*stats.Summary, *SummaryExtensions) {
+	summary := &stats.Summary{Node: this.Node.NodeStats}
+	extensions := &SummaryExtensions{}
+	extensions.Node.Swap = this.Node.Swap
+	if this.Pods != nil {
+		summary.Pods = make([]stats.PodStats, 0, len(this.Pods))
+		extensions.Pods = make([]PodUsageStats, 0, len(this.Pods))
+	}
+	for _, pod := range this.Pods {
+		podStats := pod.PodStats
+		usage := PodUsageStats{PodRef: pod.PodRef, CPU: pod.CPU, Memory: pod.Memory}
+		if pod.Containers != nil {
+			podStats.Containers = make([]stats.ContainerStats, 0, len(pod.Containers))
+		}
+		for _, container := range pod.Containers {
+			containerStats := container.ContainerStats
+			containerUsage := ContainerUsageStats{Name: container.Name, Swap: container.Swap}
+			if container.CPU != nil {
+				containerStats.CPU = &container.CPU.CPUStats
+				if container.CPU.ThrottledTimeNanoSeconds != nil {
+					containerUsage.CPU = &container.CPU.CPUThrottlingStats
+				}
+			}
+			podStats.Containers = append(podStats.Containers, containerStats)
+			usage.Containers = append(usage.Containers, containerUsage)
+		}
+		summary.Pods = append(summary.Pods, podStats)
+		extensions.Pods = append(extensions.Pods, usage)
+	}
+	return summary, extensions
+}
+
+// GetSummaryWithPodUsage also returns the usage of the pod cgroups, for the pods the kubelet
+// reports it for.
+func (self *KubeletClient) GetSummaryWithPodUsage(host Host) (*stats.Summary, []PodUsageStats, time.Duration, error) {
+	summary, extensions, cacheAge, err := self.GetSummaryWithExtensions(host)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return summary, extensions.Pods, cacheAge, nil
+}
+
+// GetSummaryWithExtensions also returns the stats of the summary the vendored types lack.
+func (self *KubeletClient) GetSummaryWithExtensions(host Host) (*stats.Summary, *SummaryExtensions, time.Duration, error) {
+	req, err := http.NewRequest("GET", self.SummaryURL(host), nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	client, err := self.clientForHost(host)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	decoded := &extendedSummary{}
+	header, err := self.doRequestAndGetValue(client, req, decoded)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	summary, extensions := decoded.split()
+	return summary, extensions, getCacheAge(header), nil
+}
+
+// SummaryResponse is a summary with the validators of the response it was decoded from.
+type SummaryResponse struct {
+	Summary    *stats.Summary
+	Extensions *SummaryExtensions
+	CacheAge   time.Duration
+	// ETag and Last-Modified headers of the response, empty if the kubelet sent none.
+	ETag         string
+	LastModified string
+	// Whether the kubelet answered that the summary didn't change since the previous response.
+	NotModified bool
+}
+
+// GetSummaryIfModified requests the summary conditionally on the validators of the previous
+// response, nil for none, which is returned again if the kubelet answers that the summary
+// didn't change. Kubelets which send no validators answer every request in full.
+func (self *KubeletClient) GetSummaryIfModified(host Host, previous *SummaryResponse) (*SummaryResponse, error) {
+	req, err := http.NewRequest("GET", self.SummaryURL(host), nil)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+	client, err := self.clientForHost(host)
+	if err != nil {
+		return nil, err
+	}
+	header, body, err := self.doRequest(client, req)
+	if err == errNotModified && previous != nil {
+		response := *previous
+		response.CacheAge = getCacheAge(header)
+		response.NotModified = true
+		return &response, nil
+	} else if err != nil {
+		return nil, err
+	}
+	decoded := &extendedSummary{}
+	if err := json.Unmarshal(body, decoded); err != nil {
+		return nil, newDecodeError("failed to parse output. Response: %q. Error: %v", string(body), err)
+	}
+	response := &SummaryResponse{
+		CacheAge:     getCacheAge(header),
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}
+	response.Summary, response.Extensions = decoded.split()
+	return response, nil
+}
+
+// GetResourceMetrics returns the metric families served by the kubelet of the host on
+// its Prometheus resource metrics endpoint.
+func (self *KubeletClient) GetResourceMetrics(host Host) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequest("GET", self.ResourceMetricsURL(host), nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := self.clientForHost(host)
+	if err != nil {
+		return nil, err
+	}
+	_, body, err := self.doRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	if err != nil {
+		return nil, newDecodeError("failed to parse resource metrics: %v", err)
+	}
+	return families, nil
+}
+
+// SummaryURL returns the URL the summary of the host is requested from.
+func (self *KubeletClient) SummaryURL(host Host) string {
+	return self.kubeletURL(host, "/stats/summary/")
+}
+
+// ResourceMetricsURL returns the URL the resource metrics of the host are requested from.
+func (self *KubeletClient) ResourceMetricsURL(host Host) string {
+	return self.kubeletURL(host, "/metrics/resource")
+}
+
+func (self *KubeletClient) kubeletURL(host Host, path string) string {
 	url := url.URL{
 		Scheme: "http",
-		Host:   fmt.Sprintf("%s:%d", host.IP, host.Port),
-		Path:   "/stats/summary/",
+		Host:   net.JoinHostPort(host.IP, strconv.Itoa(host.Port)),
+		Path:   path,
 	}
 	if self.config != nil && self.config.EnableHttps {
 		url.Scheme = "https"
 	}
+	if host.Scheme != "" {
+		url.Scheme = host.Scheme
+	}
+	if host.Address != "" {
+		url.Host = host.Address
+	}
+	return url.String()
+}
+
+// Ways the serving certificates of kubelets are verified.
+const (
+	TLSModeNone                    = "none"
+	TLSModeInsecure                = "insecure-skip-verify"
+	TLSModeVerifyAddress           = "verify-address"
+	TLSModeVerifyHostnameOrAddress = "verify-hostname-or-address"
+)
+
+// Ways the client authenticates to kubelets, joined with "+" if several are configured.
+const (
+	AuthModeNone              = "none"
+	AuthModeClientCertificate = "client-certificate"
+	AuthModeBearerToken       = "bearer-token"
+)
 
-	req, err := http.NewRequest("GET", url.String(), nil)
+// TLSMode returns how the serving certificate of the kubelet of the host is verified.
+func (self *KubeletClient) TLSMode(host Host) string {
+	if strings.HasPrefix(self.SummaryURL(host), "http:") {
+		return TLSModeNone
+	}
+	if self.config == nil || self.config.Insecure {
+		return TLSModeInsecure
+	}
+	if self.config.VerifyNodeAddresses && host.ServerName != "" && host.Address == "" {
+		return TLSModeVerifyHostnameOrAddress
+	}
+	return TLSModeVerifyAddress
+}
+
+// AuthMode returns how the client authenticates to the kubelets.
+func (self *KubeletClient) AuthMode() string {
+	if self.config == nil {
+		return AuthModeNone
+	}
+	modes := []string{}
+	if len(self.config.CertData) > 0 || self.config.CertFile != "" {
+		modes = append(modes, AuthModeClientCertificate)
+	}
+	if self.config.BearerToken != "" {
+		modes = append(modes, AuthModeBearerToken)
+	}
+	if len(modes) == 0 {
+		return AuthModeNone
+	}
+	return strings.Join(modes, "+")
+}
+
+// clientForHost returns the client to be used for requests to the host.
+// Kubelets reached at a rewritten address are verified against that address.
+func (self *KubeletClient) clientForHost(host Host) (*http.Client, error) {
+	if self.config == nil || !self.config.EnableHttps || !self.config.VerifyNodeAddresses || host.ServerName == "" || host.Address != "" {
+		if self.client == nil {
+			return http.DefaultClient, nil
+		}
+		return self.client, nil
+	}
+
+	self.hostClientsLock.Lock()
+	defer self.hostClientsLock.Unlock()
+	key := hostClientKey(host)
+	if client, found := self.hostClients[key]; found {
+		return client, nil
+	}
+	transport, err := kubelet_client.MakeTransportForHost(self.config, host.ServerName, host.IP)
 	if err != nil {
 		return nil, err
 	}
-	summary := &stats.Summary{}
-	client := self.client
-	if client == nil {
-		client = http.DefaultClient
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   self.config.HTTPTimeout,
 	}
-	err = self.postRequestAndGetValue(client, req, summary)
-	return summary, err
+	if self.hostClients == nil {
+		self.hostClients = make(map[string]*http.Client)
+	}
+	self.hostClients[key] = client
+	return client, nil
+}
+
+// RetainHosts forgets the clients of the hosts which aren't scraped anymore, e.g. of removed
+// nodes or nodes with a new address.
+func (self *KubeletClient) RetainHosts(hosts []Host) {
+	self.hostClientsLock.Lock()
+	defer self.hostClientsLock.Unlock()
+	present := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		present[hostClientKey(host)] = true
+	}
+	for key := range self.hostClients {
+		if !present[key] {
+			delete(self.hostClients, key)
+		}
+	}
+}
+
+func hostClientKey(host Host) string {
+	return host.ServerName + "/" + host.IP
 }
 
 func (self *KubeletClient) GetPort() int {
@@ -16,13 +16,18 @@ package kubelet
 
 import (
 	"encoding/json"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
 	cadvisor_api "github.com/google/cadvisor/info/v1"
+	kubelet_client "github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	restclient "k8s.io/client-go/rest"
 	util "k8s.io/client-go/util/testing"
 )
 
@@ -102,3 +107,238 @@ func TestAllContainers(t *testing.T) {
 	checkContainer(t, rootContainer, containers[0])
 	checkContainer(t, subcontainer, containers[1])
 }
+
+func TestGetSummaryWithCacheAge(t *testing.T) {
+	age := "7"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if age != "" {
+			w.Header().Set("Age", age)
+		}
+		w.Write([]byte(`{"node":{"nodeName":"node1"}}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(serverURL.Port())
+	require.NoError(t, err)
+	host := Host{IP: serverURL.Hostname(), Port: port}
+
+	kubeletClient := KubeletClient{}
+	summary, cacheAge, err := kubeletClient.GetSummaryWithCacheAge(host)
+	require.NoError(t, err)
+	assert.Equal(t, "node1", summary.Node.NodeName)
+	assert.Equal(t, 7*time.Second, cacheAge)
+
+	for _, age = range []string{"", "-1", "soon"} {
+		_, cacheAge, err = kubeletClient.GetSummaryWithCacheAge(host)
+		require.NoError(t, err)
+		assert.Equal(t, time.Duration(0), cacheAge, "Age %q", age)
+	}
+}
+
+func TestGetSummaryIfModified(t *testing.T) {
+	etag := `"1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if etag != "" && req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.Write([]byte(`{"node":{"nodeName":"node1"}}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(serverURL.Port())
+	require.NoError(t, err)
+	host := Host{IP: serverURL.Hostname(), Port: port}
+
+	kubeletClient := KubeletClient{}
+	response, err := kubeletClient.GetSummaryIfModified(host, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "node1", response.Summary.Node.NodeName)
+	assert.Equal(t, `"1"`, response.ETag)
+	assert.False(t, response.NotModified)
+
+	unchanged, err := kubeletClient.GetSummaryIfModified(host, response)
+	require.NoError(t, err)
+	assert.True(t, unchanged.NotModified)
+	assert.Equal(t, response.Summary, unchanged.Summary, "previous summary")
+
+	etag = ""
+	response, err = kubeletClient.GetSummaryIfModified(host, response)
+	require.NoError(t, err)
+	assert.False(t, response.NotModified, "kubelets without validators answer in full")
+	assert.Equal(t, "node1", response.Summary.Node.NodeName)
+}
+
+func TestGetSummaryWithPodUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"node":{"nodeName":"node1"},"pods":[` +
+			`{"podRef":{"name":"pod1","namespace":"ns1"},"cpu":{"usageNanoCores":150000000},"memory":{"workingSetBytes":2048},"containers":[{"name":"c1"}]},` +
+			`{"podRef":{"name":"pod2","namespace":"ns1"},"containers":[{"name":"c2"}]}]}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(serverURL.Port())
+	require.NoError(t, err)
+
+	kubeletClient := KubeletClient{}
+	summary, podUsage, _, err := kubeletClient.GetSummaryWithPodUsage(Host{IP: serverURL.Hostname(), Port: port})
+	require.NoError(t, err)
+	require.Len(t, summary.Pods, 2)
+	assert.Equal(t, "c1", summary.Pods[0].Containers[0].Name)
+	require.Len(t, podUsage, 2)
+	assert.Equal(t, "pod1", podUsage[0].PodRef.Name)
+	require.NotNil(t, podUsage[0].CPU)
+	assert.Equal(t, uint64(150000000), *podUsage[0].CPU.UsageNanoCores)
+	assert.Equal(t, uint64(2048), *podUsage[0].Memory.WorkingSetBytes)
+	assert.Nil(t, podUsage[1].CPU, "not reported by older kubelets")
+}
+
+func TestGetSummaryWithExtensions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"node":{"nodeName":"node1","swap":{"swapAvailableBytes":4096,"swapUsageBytes":1024}},"pods":[` +
+			`{"podRef":{"name":"pod1","namespace":"ns1"},"containers":[{"name":"c1","swap":{"swapUsageBytes":512}},{"name":"c2"}]}]}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(serverURL.Port())
+	require.NoError(t, err)
+
+	kubeletClient := KubeletClient{}
+	_, extensions, _, err := kubeletClient.GetSummaryWithExtensions(Host{IP: serverURL.Hostname(), Port: port})
+	require.NoError(t, err)
+	require.NotNil(t, extensions.Node.Swap)
+	assert.Equal(t, uint64(1024), *extensions.Node.Swap.SwapUsageBytes)
+	assert.Equal(t, uint64(4096), *extensions.Node.Swap.SwapAvailableBytes)
+	require.Len(t, extensions.Pods, 1)
+	require.Len(t, extensions.Pods[0].Containers, 2)
+	assert.Equal(t, uint64(512), *extensions.Pods[0].Containers[0].Swap.SwapUsageBytes)
+	assert.Nil(t, extensions.Pods[0].Containers[1].Swap, "swap disabled")
+}
+
+func TestGetSummaryWithExtensionsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(serverURL.Port())
+	require.NoError(t, err)
+
+	kubeletClient := KubeletClient{}
+	summary, extensions, _, err := kubeletClient.GetSummaryWithExtensions(Host{IP: serverURL.Hostname(), Port: port})
+	assert.Error(t, err)
+	assert.Nil(t, summary)
+	assert.Nil(t, extensions)
+	summary, podUsage, _, err := kubeletClient.GetSummaryWithPodUsage(Host{IP: serverURL.Hostname(), Port: port})
+	assert.Error(t, err)
+	assert.Nil(t, summary)
+	assert.Nil(t, podUsage)
+}
+
+func TestExtendedSummarySplit(t *testing.T) {
+	decoded := &extendedSummary{}
+	require.NoError(t, json.Unmarshal([]byte(`{"node":{"nodeName":"node1","cpu":{"usageNanoCores":100},"swap":{"swapUsageBytes":1024}},"pods":[`+
+		`{"podRef":{"name":"pod1","namespace":"ns1"},"cpu":{"usageNanoCores":50},"containers":[`+
+		`{"name":"c1","cpu":{"usageCoreNanoSeconds":300,"throttledTimeNanoSeconds":200},"memory":{"workingSetBytes":2048},"swap":{"swapUsageBytes":512}},`+
+		`{"name":"c2","cpu":{"usageCoreNanoSeconds":100}}]}]}`), decoded))
+
+	summary, extensions := decoded.split()
+	assert.Equal(t, "node1", summary.Node.NodeName)
+	assert.Equal(t, uint64(100), *summary.Node.CPU.UsageNanoCores)
+	require.Len(t, summary.Pods, 1)
+	assert.Equal(t, "pod1", summary.Pods[0].PodRef.Name)
+	require.Len(t, summary.Pods[0].Containers, 2)
+	assert.Equal(t, "c1", summary.Pods[0].Containers[0].Name)
+	assert.Equal(t, uint64(300), *summary.Pods[0].Containers[0].CPU.UsageCoreNanoSeconds)
+	assert.Equal(t, uint64(2048), *summary.Pods[0].Containers[0].Memory.WorkingSetBytes)
+	assert.Equal(t, uint64(100), *summary.Pods[0].Containers[1].CPU.UsageCoreNanoSeconds)
+
+	assert.Equal(t, uint64(1024), *extensions.Node.Swap.SwapUsageBytes)
+	require.Len(t, extensions.Pods, 1)
+	assert.Equal(t, "pod1", extensions.Pods[0].PodRef.Name)
+	assert.Equal(t, uint64(50), *extensions.Pods[0].CPU.UsageNanoCores)
+	require.Len(t, extensions.Pods[0].Containers, 2)
+	assert.Equal(t, "c1", extensions.Pods[0].Containers[0].Name)
+	assert.Equal(t, uint64(512), *extensions.Pods[0].Containers[0].Swap.SwapUsageBytes)
+	assert.Equal(t, uint64(200), *extensions.Pods[0].Containers[0].CPU.ThrottledTimeNanoSeconds)
+	assert.Nil(t, extensions.Pods[0].Containers[1].CPU, "throttling not reported")
+}
+
+func TestTLSAndAuthMode(t *testing.T) {
+	host := Host{IP: "10.0.0.1", Port: 10250, ServerName: "node1"}
+	tests := []struct {
+		name     string
+		config   kubelet_client.KubeletClientConfig
+		host     Host
+		tlsMode  string
+		authMode string
+	}{{
+		name:     "http",
+		config:   kubelet_client.KubeletClientConfig{Port: 10255},
+		host:     host,
+		tlsMode:  TLSModeNone,
+		authMode: AuthModeNone,
+	}, {
+		name: "insecure",
+		config: kubelet_client.KubeletClientConfig{EnableHttps: true, BearerToken: "token",
+			TLSClientConfig: restclient.TLSClientConfig{Insecure: true}},
+		host:     host,
+		tlsMode:  TLSModeInsecure,
+		authMode: AuthModeBearerToken,
+	}, {
+		name: "client certificate",
+		config: kubelet_client.KubeletClientConfig{EnableHttps: true, BearerToken: "token",
+			TLSClientConfig: restclient.TLSClientConfig{CertFile: "client.crt"}},
+		host:     host,
+		tlsMode:  TLSModeVerifyAddress,
+		authMode: AuthModeClientCertificate + "+" + AuthModeBearerToken,
+	}, {
+		name:    "node addresses",
+		config:  kubelet_client.KubeletClientConfig{EnableHttps: true, VerifyNodeAddresses: true},
+		host:    host,
+		tlsMode: TLSModeVerifyHostnameOrAddress,
+	}, {
+		name:    "rewritten address",
+		config:  kubelet_client.KubeletClientConfig{EnableHttps: true, VerifyNodeAddresses: true},
+		host:    Host{IP: "10.0.0.1", Port: 10250, ServerName: "node1", Address: "lb.example.com:443"},
+		tlsMode: TLSModeVerifyAddress,
+	}}
+
+	for _, test := range tests {
+		client := &KubeletClient{config: &test.config}
+		assert.Equal(t, test.tlsMode, client.TLSMode(test.host), test.name)
+		if test.authMode != "" {
+			assert.Equal(t, test.authMode, client.AuthMode(), test.name)
+		}
+	}
+	assert.Equal(t, "https://lb.example.com:443/stats/summary/",
+		(&KubeletClient{config: &kubelet_client.KubeletClientConfig{EnableHttps: true}}).SummaryURL(tests[4].host))
+}
+
+func TestRetainHosts(t *testing.T) {
+	client := &KubeletClient{config: &kubelet_client.KubeletClientConfig{EnableHttps: true, VerifyNodeAddresses: true}}
+	node1 := Host{IP: "10.0.0.1", Port: 10250, ServerName: "node1"}
+	node2 := Host{IP: "10.0.0.2", Port: 10250, ServerName: "node2"}
+	first, err := client.clientForHost(node1)
+	require.NoError(t, err)
+	_, err = client.clientForHost(node2)
+	require.NoError(t, err)
+	require.Len(t, client.hostClients, 2)
+
+	client.RetainHosts([]Host{node1})
+	assert.Len(t, client.hostClients, 1, "the client of the removed node is forgotten")
+	kept, err := client.clientForHost(node1)
+	require.NoError(t, err)
+	assert.True(t, first == kept)
+
+	client.RetainHosts([]Host{{IP: "10.0.0.3", Port: 10250, ServerName: "node1"}})
+	assert.Empty(t, client.hostClients, "the client of the old address is forgotten")
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+)
+
+// URLRewriteConfig maps node names to the address their kubelet is reached at, e.g.
+// when kubelets are fronted by load balancers terminating TLS:
+//
+//	rules:
+//	- node: '^(ip-[0-9-]+)\.ec2\.internal$'
+//	  scheme: https
+//	  host: '$1.kubelets.example.com:443'
+//
+// The first rule whose node expression matches the node name is applied. Host may
+// refer to submatches of the expression. Nodes not matched by any rule are scraped
+// at their address as usual.
+type URLRewriteConfig struct {
+	Rules []URLRewriteRuleConfig `json:"rules"`
+}
+
+type URLRewriteRuleConfig struct {
+	// Regular expression matched against the node name.
+	Node string `json:"node"`
+	// Scheme of the kubelet URL, http or https. Empty to keep the configured one.
+	Scheme string `json:"scheme,omitempty"`
+	// Host and port of the kubelet URL.
+	Host string `json:"host"`
+}
+
+type urlRewriteRule struct {
+	node   *regexp.Regexp
+	scheme string
+	host   string
+}
+
+// URLRewriter rewrites the kubelet URLs of nodes.
+type URLRewriter struct {
+	rules []urlRewriteRule
+}
+
+// LoadURLRewriter reads a URLRewriteConfig from the file.
+func LoadURLRewriter(path string) (*URLRewriter, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubelet URL rewrite config: %v", err)
+	}
+	config := &URLRewriteConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse kubelet URL rewrite config %s: %v", path, err)
+	}
+	return NewURLRewriter(config)
+}
+
+func NewURLRewriter(config *URLRewriteConfig) (*URLRewriter, error) {
+	rewriter := &URLRewriter{}
+	for i, rule := range config.Rules {
+		node, err := regexp.Compile(rule.Node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node expression in kubelet URL rewrite rule %d: %v", i, err)
+		}
+		switch rule.Scheme {
+		case "", "http", "https":
+		default:
+			return nil, fmt.Errorf("invalid scheme %q in kubelet URL rewrite rule %d", rule.Scheme, i)
+		}
+		if rule.Host == "" {
+			return nil, fmt.Errorf("missing host in kubelet URL rewrite rule %d", i)
+		}
+		rewriter.rules = append(rewriter.rules, urlRewriteRule{
+			node:   node,
+			scheme: rule.Scheme,
+			host:   rule.Host,
+		})
+	}
+	return rewriter, nil
+}
+
+// Rewrite sets the scheme and address of the host from the first rule matching the node.
+// It returns false if no rule matches.
+func (this *URLRewriter) Rewrite(nodeName string, host *Host) (bool, error) {
+	for _, rule := range this.rules {
+		match := rule.node.FindStringSubmatchIndex(nodeName)
+		if match == nil {
+			continue
+		}
+		address := string(rule.node.ExpandString(nil, rule.host, nodeName, match))
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return false, fmt.Errorf("invalid kubelet address %q for node %s: %v", address, nodeName, err)
+		}
+		host.Scheme = rule.scheme
+		host.Address = address
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadURLRewriter(t *testing.T) {
+	file, err := ioutil.TempFile("", "url-rewrite")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`
+rules:
+- node: '^(ip-[0-9-]+)\.ec2\.internal$'
+  scheme: https
+  host: '$1.kubelets.example.com:443'
+- node: '^edge-'
+  host: 'edge-lb.example.com:10250'
+`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	rewriter, err := LoadURLRewriter(file.Name())
+	require.NoError(t, err)
+
+	host := Host{IP: "10.0.0.1", Port: 10250}
+	rewritten, err := rewriter.Rewrite("ip-10-0-0-1.ec2.internal", &host)
+	require.NoError(t, err)
+	assert.True(t, rewritten)
+	assert.Equal(t, "https", host.Scheme)
+	assert.Equal(t, "ip-10-0-0-1.kubelets.example.com:443", host.Address)
+
+	host = Host{IP: "10.0.0.2", Port: 10250}
+	rewritten, err = rewriter.Rewrite("edge-1", &host)
+	require.NoError(t, err)
+	assert.True(t, rewritten)
+	assert.Equal(t, "", host.Scheme)
+	assert.Equal(t, "edge-lb.example.com:10250", host.Address)
+
+	host = Host{IP: "10.0.0.3", Port: 10250}
+	rewritten, err = rewriter.Rewrite("node-3", &host)
+	require.NoError(t, err)
+	assert.False(t, rewritten)
+	assert.Equal(t, Host{IP: "10.0.0.3", Port: 10250}, host)
+}
+
+func TestNewURLRewriterInvalid(t *testing.T) {
+	for _, rule := range []URLRewriteRuleConfig{
+		{Node: "(", Host: "lb:443"},
+		{Node: ".*", Scheme: "ftp", Host: "lb:443"},
+		{Node: ".*"},
+	} {
+		_, err := NewURLRewriter(&URLRewriteConfig{Rules: []URLRewriteRuleConfig{rule}})
+		assert.Error(t, err, "%+v", rule)
+	}
+
+	rewriter, err := NewURLRewriter(&URLRewriteConfig{Rules: []URLRewriteRuleConfig{
+		{Node: "^(.*)$", Host: "$1.lb"},
+	}})
+	require.NoError(t, err)
+	_, err = rewriter.Rewrite("node-1", &Host{})
+	assert.Error(t, err, "address without port")
+}
+
+func TestGetSummaryRewrittenAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"node":{"nodeName":"node1"}}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// The node address is not reachable, the kubelet is only served at the rewritten one.
+	host := Host{IP: "192.0.2.1", Port: 1, Scheme: "http", Address: serverURL.Host}
+	kubeletClient := KubeletClient{}
+	summary, err := kubeletClient.GetSummary(host)
+	require.NoError(t, err)
+	assert.Equal(t, "node1", summary.Node.NodeName)
+}
@@ -15,6 +15,8 @@
 package client
 
 import (
+	"crypto/x509"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -43,6 +45,14 @@ type KubeletClientConfig struct {
 
 	// Dial is a custom dialer used for the client
 	Dial utilnet.DialFunc
+
+	// VerifyNodeAddresses accepts serving certificates valid for either the node
+	// hostname or the dialed address, instead of the dialed address only.
+	VerifyNodeAddresses bool
+
+	// EnableHTTP2 negotiates HTTP/2 with kubelets supporting it, so that the requests to a
+	// kubelet share a single connection.
+	EnableHTTP2 bool
 }
 
 func MakeTransport(config *KubeletClientConfig) (http.RoundTripper, error) {
@@ -53,7 +63,7 @@ func MakeTransport(config *KubeletClientConfig) (http.RoundTripper, error) {
 
 	rt := http.DefaultTransport
 	if config.Dial != nil || tlsConfig != nil {
-		rt = utilnet.SetOldTransportDefaults(&http.Transport{
+		rt = config.setTransportDefaults(&http.Transport{
 			Dial:            config.Dial,
 			TLSClientConfig: tlsConfig,
 		})
@@ -62,6 +72,77 @@ func MakeTransport(config *KubeletClientConfig) (http.RoundTripper, error) {
 	return transport.HTTPWrappersForConfig(config.transportConfig(), rt)
 }
 
+// MakeTransportForHost creates a transport which dials the kubelet by ip, sends serverName
+// for SNI and accepts serving certificates signed by the configured CA for either of them.
+func MakeTransportForHost(config *KubeletClientConfig, serverName, ip string) (http.RoundTripper, error) {
+	tlsConfig, err := transport.TLSConfigFor(config.transportConfig())
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil || tlsConfig.InsecureSkipVerify {
+		return MakeTransport(config)
+	}
+
+	roots := tlsConfig.RootCAs
+	tlsConfig.ServerName = serverName
+	// Verification is done below, against both names.
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return verifyServingCertificate(rawCerts, roots, serverName, ip)
+	}
+
+	rt := config.setTransportDefaults(&http.Transport{
+		Dial:            config.Dial,
+		TLSClientConfig: tlsConfig,
+	})
+	return transport.HTTPWrappersForConfig(config.transportConfig(), rt)
+}
+
+func (c *KubeletClientConfig) setTransportDefaults(t *http.Transport) *http.Transport {
+	if c.EnableHTTP2 {
+		return utilnet.SetTransportDefaults(t)
+	}
+	return utilnet.SetOldTransportDefaults(t)
+}
+
+// verifyServingCertificate verifies the presented chain against roots and checks
+// that the leaf certificate is valid for at least one of the names.
+func verifyServingCertificate(rawCerts [][]byte, roots *x509.CertPool, names ...string) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no serving certificate presented")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse serving certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return err
+	}
+
+	err := fmt.Errorf("serving certificate is not valid for any of %v", names)
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if err = certs[0].VerifyHostname(name); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
 // transportConfig converts a client config to an appropriate transport config.
 func (c *KubeletClientConfig) transportConfig() *transport.Config {
 	cfg := &transport.Config{
@@ -0,0 +1,52 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/util/cert"
+)
+
+func generateServingCert(t *testing.T, host string) ([][]byte, *x509.CertPool) {
+	certPEM, _, err := cert.GenerateSelfSignedCertKey(host, []net.IP{}, []string{})
+	require.NoError(t, err)
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+	return [][]byte{block.Bytes}, roots
+}
+
+func TestVerifyServingCertificate(t *testing.T) {
+	ipOnly, ipRoots := generateServingCert(t, "10.0.0.1")
+	assert.NoError(t, verifyServingCertificate(ipOnly, ipRoots, "node1", "10.0.0.1"))
+	assert.Error(t, verifyServingCertificate(ipOnly, ipRoots, "node1", "10.0.0.2"))
+
+	hostnameOnly, hostnameRoots := generateServingCert(t, "node1")
+	assert.NoError(t, verifyServingCertificate(hostnameOnly, hostnameRoots, "node1", "10.0.0.1"))
+	assert.Error(t, verifyServingCertificate(hostnameOnly, hostnameRoots, "node2", "10.0.0.1"))
+
+	// Certificates from an unknown CA are rejected regardless of the names.
+	assert.Error(t, verifyServingCertificate(ipOnly, hostnameRoots, "node1", "10.0.0.1"))
+	assert.Error(t, verifyServingCertificate(nil, ipRoots, "node1", "10.0.0.1"))
+}
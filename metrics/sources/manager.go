@@ -15,9 +15,13 @@
 package sources
 
 import (
+	"fmt"
 	"math/rand"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
 	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
 
 	"github.com/golang/glog"
@@ -28,6 +32,8 @@ const (
 	DefaultMetricsScrapeTimeout = 20 * time.Second
 	MaxDelayMs                  = 4 * 1000
 	DelayPerSourceMs            = 8
+	// Number of slots the scrape delay window is divided into for spreading the cost.
+	MaxScheduleSlots = 10
 )
 
 var (
@@ -52,23 +58,85 @@ var (
 		},
 		[]string{"source"},
 	)
+
+	// Spread between the earliest and the latest sample within a scrape cycle.
+	scrapeSkew = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "heapster",
+			Subsystem: "scraper",
+			Name:      "skew_seconds",
+			Help:      "Spread between the earliest and the latest sample timestamp in a scrape cycle in seconds.",
+			Buckets:   []float64{0.5, 1, 2.5, 5, 10, 15, 20, 30, 60},
+		},
+	)
+
+	// Number of sources being scraped.
+	scrapesInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "scraper",
+			Name:      "in_flight",
+			Help:      "Number of sources being scraped.",
+		},
+	)
+
+	// Variance of the expected cost scheduled per delay slot in the last cycle.
+	slotCostVariance = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "scraper",
+			Name:      "slot_cost_variance",
+			Help:      "Variance of the expected scrape cost, in metric sets, scheduled per delay slot in the last cycle.",
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(lastScrapeTimestamp)
 	prometheus.MustRegister(scraperDuration)
+	prometheus.MustRegister(scrapeSkew)
+	prometheus.MustRegister(slotCostVariance)
+	prometheus.MustRegister(scrapesInFlight)
 }
 
-func NewSourceManager(metricsSourceProvider MetricsSourceProvider, metricsScrapeTimeout time.Duration) (MetricsSource, error) {
+// NewSourceManager creates a source which scrapes all sources from the provider. If the bus
+// is not nil, every per-source result is also published on it.
+func NewSourceManager(metricsSourceProvider MetricsSourceProvider, metricsScrapeTimeout time.Duration, eventBus *bus.Bus) (MetricsSource, error) {
+	return NewLimitedSourceManager(metricsSourceProvider, metricsScrapeTimeout, 0, 0, eventBus)
+}
+
+// NewLimitedSourceManager creates a source manager scraping at most maxInFlight sources at
+// once, unlimited if not positive, with a pool of workers. The scrapes start spread over
+// the spread window, or over a window growing with the number of sources up to
+// MaxDelayMs if it's zero. The window has to be shorter than the scrape timeout.
+func NewLimitedSourceManager(metricsSourceProvider MetricsSourceProvider, metricsScrapeTimeout time.Duration,
+	maxInFlight int, spread time.Duration, eventBus *bus.Bus) (MetricsSource, error) {
+	if spread < 0 || (spread > 0 && spread >= metricsScrapeTimeout) {
+		return nil, fmt.Errorf("scrape spread needs to be shorter than the scrape timeout %s - %s", metricsScrapeTimeout, spread)
+	}
 	return &sourceManager{
 		metricsSourceProvider: metricsSourceProvider,
 		metricsScrapeTimeout:  metricsScrapeTimeout,
+		maxInFlight:           maxInFlight,
+		spread:                spread,
+		bus:                   eventBus,
+		costs:                 make(map[string]int),
 	}, nil
 }
 
 type sourceManager struct {
 	metricsSourceProvider MetricsSourceProvider
 	metricsScrapeTimeout  time.Duration
+	// Maximum number of concurrent scrapes, unlimited if not positive.
+	maxInFlight int
+	// Window the scrapes are spread over, derived from the number of sources if zero.
+	spread time.Duration
+	bus    *bus.Bus
+
+	// Number of metric sets returned by each source in its last scrape, used
+	// to spread expensive sources evenly over the delay window.
+	costsLock sync.Mutex
+	costs     map[string]int
 }
 
 func (this *sourceManager) Name() string {
@@ -83,36 +151,32 @@ func (this *sourceManager) ScrapeMetrics(start, end time.Time) *DataBatch {
 	startTime := time.Now()
 	timeoutTime := startTime.Add(this.metricsScrapeTimeout)
 
-	delayMs := DelayPerSourceMs * len(sources)
-	if delayMs > MaxDelayMs {
-		delayMs = MaxDelayMs
-	}
-
-	for _, source := range sources {
+	random := rand.New(rand.NewSource(startTime.UnixNano()))
+	scrapes, slotCosts := planScrapes(sources, this.getCosts(sources), startTime, getSpreadWindow(len(sources), this.spread), random)
+	slotCostVariance.Set(getVariance(slotCosts))
 
-		go func(source MetricsSource, channel chan *DataBatch, start, end, timeoutTime time.Time, delayInMs int) {
-
-			// Prevents network congestion.
-			time.Sleep(time.Duration(rand.Intn(delayMs)) * time.Millisecond)
-
-			glog.V(2).Infof("Querying source: %s", source)
-			metrics := scrape(source, start, end)
-			now := time.Now()
-			if !now.Before(timeoutTime) {
-				glog.Warningf("Failed to get %s response in time", source)
-				return
-			}
-			timeForResponse := timeoutTime.Sub(now)
-
-			select {
-			case channel <- metrics:
-				// passed the response correctly.
-				return
-			case <-time.After(timeForResponse):
-				glog.Warningf("Failed to send the response back %s", source)
-				return
-			}
-		}(source, responseChannel, start, end, timeoutTime, delayMs)
+	if this.maxInFlight <= 0 {
+		for _, scrape := range scrapes {
+			go this.scrapeSource(scrape, responseChannel, start, end, timeoutTime)
+		}
+	} else {
+		sortByStartTime(scrapes)
+		queue := make(chan scheduledScrape, len(scrapes))
+		for _, scrape := range scrapes {
+			queue <- scrape
+		}
+		close(queue)
+		workers := this.maxInFlight
+		if workers > len(scrapes) {
+			workers = len(scrapes)
+		}
+		for i := 0; i < workers; i++ {
+			go func() {
+				for scrape := range queue {
+					this.scrapeSource(scrape, responseChannel, start, end, timeoutTime)
+				}
+			}()
+		}
 	}
 	response := DataBatch{
 		Timestamp:  end,
@@ -149,22 +213,235 @@ responseloop:
 		}
 	}
 
-	glog.V(1).Infof("ScrapeMetrics: time: %s size: %d", time.Since(startTime), len(response.MetricSets))
+	if observer, ok := this.metricsSourceProvider.(ScrapeCycleObserver); ok {
+		observer.CompleteScrapeCycle(time.Now())
+	}
+
+	skew := getScrapeSkew(&response)
+	scrapeSkew.Observe(skew.Seconds())
+
+	glog.V(1).Infof("ScrapeMetrics: time: %s size: %d skew: %s", time.Since(startTime), len(response.MetricSets), skew)
 	for i, value := range latencies {
 		glog.V(1).Infof("   scrape  bucket %d: %d", i, value)
 	}
 	return &response
 }
 
+// A source and the time its scrape is due.
+type scheduledScrape struct {
+	source    MetricsSource
+	startTime time.Time
+}
+
+// getSpreadWindow returns the window the scrapes of the sources are spread over: the
+// configured spread, or a window growing with the number of sources up to MaxDelayMs.
+func getSpreadWindow(sourceCount int, spread time.Duration) time.Duration {
+	if spread > 0 {
+		return spread
+	}
+	delayMs := DelayPerSourceMs * sourceCount
+	if delayMs > MaxDelayMs {
+		delayMs = MaxDelayMs
+	}
+	return time.Duration(delayMs) * time.Millisecond
+}
+
+// planScrapes returns when each source is scraped in a cycle starting at startTime. The
+// sources are put into the slots of the window by their cost, and start at a random time
+// within their slot, so that they don't all hit the network at once. Prioritized sources
+// are scraped right away. Also returns the expected cost of every slot.
+func planScrapes(sources []MetricsSource, costs map[string]int, startTime time.Time, window time.Duration,
+	random *rand.Rand) ([]scheduledScrape, []int) {
+	slots, slotCosts := scheduleSources(sources, costs, MaxScheduleSlots, random)
+	delayMs := int(window / time.Millisecond)
+	slotMs := 1
+	if len(slotCosts) > 0 && delayMs/len(slotCosts) > 1 {
+		slotMs = delayMs / len(slotCosts)
+	}
+
+	scrapes := make([]scheduledScrape, len(sources))
+	for i, source := range sources {
+		scrapes[i] = scheduledScrape{source: source, startTime: startTime}
+		if ps, ok := source.(PrioritizedMetricsSource); !ok || !ps.IsPrioritized() {
+			delay := time.Duration(slots[i]*slotMs+random.Intn(slotMs)) * time.Millisecond
+			scrapes[i].startTime = startTime.Add(delay)
+		}
+	}
+	return scrapes, slotCosts
+}
+
+// sortByStartTime orders the scrapes by when they are due, the order the workers of a
+// limited source manager take them in.
+func sortByStartTime(scrapes []scheduledScrape) {
+	sort.SliceStable(scrapes, func(i, j int) bool { return scrapes[i].startTime.Before(scrapes[j].startTime) })
+}
+
+// scrapeSource scrapes the source when it's due and sends the result to the channel,
+// unless the scrape timed out.
+func (this *sourceManager) scrapeSource(scheduled scheduledScrape, channel chan *DataBatch, start, end, timeoutTime time.Time) {
+	source := scheduled.source
+	if delay := scheduled.startTime.Sub(time.Now()); delay > 0 {
+		time.Sleep(delay)
+	}
+	if !time.Now().Before(timeoutTime) {
+		glog.Warningf("Failed to start scraping %s in time", source)
+		return
+	}
+
+	glog.V(2).Infof("Querying source: %s", source)
+	scrapesInFlight.Inc()
+	metrics := scrape(source, start, end)
+	scrapesInFlight.Dec()
+	now := time.Now()
+	if !now.Before(timeoutTime) {
+		glog.Warningf("Failed to get %s response in time", source)
+		return
+	}
+	if metrics != nil {
+		this.setCost(source.Name(), len(metrics.MetricSets))
+	}
+	if this.bus != nil && metrics != nil {
+		this.bus.Publish(&bus.Event{Topic: bus.TopicSourceBatch, Source: source.Name(), Batch: metrics})
+	}
+	timeForResponse := timeoutTime.Sub(now)
+
+	select {
+	case channel <- metrics:
+		// passed the response correctly.
+	case <-time.After(timeForResponse):
+		glog.Warningf("Failed to send the response back %s", source)
+	}
+}
+
+// getCosts returns the known costs of the sources and forgets the costs of sources which
+// are gone.
+func (this *sourceManager) getCosts(sources []MetricsSource) map[string]int {
+	this.costsLock.Lock()
+	defer this.costsLock.Unlock()
+
+	costs := make(map[string]int, len(sources))
+	kept := make(map[string]int, len(sources))
+	for _, source := range sources {
+		if cost, found := this.costs[source.Name()]; found {
+			costs[source.Name()] = cost
+			kept[source.Name()] = cost
+		}
+	}
+	this.costs = kept
+	return costs
+}
+
+func (this *sourceManager) setCost(name string, cost int) {
+	this.costsLock.Lock()
+	defer this.costsLock.Unlock()
+
+	this.costs[name] = cost
+}
+
+// scheduleSources assigns every source to one of at most maxSlots delay slots, so that
+// the expected cost of the slots is as even as possible. Sources scraped for the first
+// time are assumed to cost the average of the known ones. Returns the slot of every
+// source and the total expected cost of every slot.
+func scheduleSources(sources []MetricsSource, costs map[string]int, maxSlots int, random *rand.Rand) ([]int, []int) {
+	slotCount := len(sources)
+	if slotCount > maxSlots {
+		slotCount = maxSlots
+	}
+
+	total, known := 0, 0
+	for _, source := range sources {
+		if cost, found := costs[source.Name()]; found {
+			total += cost
+			known++
+		}
+	}
+	defaultCost := 1
+	if known > 0 {
+		defaultCost = total / known
+	}
+	expected := make([]int, len(sources))
+	order := make([]int, len(sources))
+	for i, source := range sources {
+		expected[i] = defaultCost
+		if cost, found := costs[source.Name()]; found {
+			expected[i] = cost
+		}
+		order[i] = i
+	}
+
+	// Greedily put the most expensive remaining source into the cheapest slot.
+	sort.SliceStable(order, func(a, b int) bool { return expected[order[a]] > expected[order[b]] })
+	slots := make([]int, len(sources))
+	slotCosts := make([]int, slotCount)
+	for _, i := range order {
+		cheapest := 0
+		for slot := range slotCosts {
+			if slotCosts[slot] < slotCosts[cheapest] {
+				cheapest = slot
+			}
+		}
+		slots[i] = cheapest
+		slotCosts[cheapest] += expected[i]
+	}
+
+	// Shuffle the slots, so the most expensive sources are not always scraped first.
+	permutation := random.Perm(slotCount)
+	shuffled := make([]int, slotCount)
+	for i := range slots {
+		slots[i] = permutation[slots[i]]
+	}
+	for slot, cost := range slotCosts {
+		shuffled[permutation[slot]] = cost
+	}
+	return slots, shuffled
+}
+
+func getVariance(values []int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	mean := 0.0
+	for _, value := range values {
+		mean += float64(value)
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (float64(value) - mean) * (float64(value) - mean)
+	}
+	return variance / float64(len(values))
+}
+
+// getScrapeSkew returns the time between the earliest and the latest sample in the batch.
+func getScrapeSkew(batch *DataBatch) time.Duration {
+	var earliest, latest time.Time
+	for _, ms := range batch.MetricSets {
+		if ms.ScrapeTime.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || ms.ScrapeTime.Before(earliest) {
+			earliest = ms.ScrapeTime
+		}
+		if latest.IsZero() || ms.ScrapeTime.After(latest) {
+			latest = ms.ScrapeTime
+		}
+	}
+	return latest.Sub(earliest)
+}
+
 func scrape(s MetricsSource, start, end time.Time) *DataBatch {
 	sourceName := s.Name()
 	startTime := time.Now()
-	defer lastScrapeTimestamp.
-		WithLabelValues(sourceName).
-		Set(float64(time.Now().Unix()))
-	defer scraperDuration.
-		WithLabelValues(sourceName).
-		Observe(float64(time.Since(startTime)) / float64(time.Microsecond))
+	defer func() {
+		lastScrapeTimestamp.
+			WithLabelValues(sourceName).
+			Set(float64(time.Now().Unix()))
+	}()
+	defer func() {
+		scraperDuration.
+			WithLabelValues(sourceName).
+			Observe(float64(time.Since(startTime)) / float64(time.Microsecond))
+	}()
 
 	return s.ScrapeMetrics(start, end)
 }
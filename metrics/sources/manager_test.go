@@ -15,9 +15,14 @@
 package sources
 
 import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
+	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
 	"github.com/kubernetes-incubator/metrics-server/metrics/util"
 )
 
@@ -26,7 +31,7 @@ func TestAllSourcesReplyInTime(t *testing.T) {
 		util.NewDummyMetricsSource("s1", time.Second),
 		util.NewDummyMetricsSource("s2", time.Second))
 
-	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3)
+	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3, nil)
 	now := time.Now()
 	end := now.Truncate(10 * time.Second)
 	dataBatch := manager.ScrapeMetrics(end.Add(-10*time.Second), end)
@@ -55,7 +60,7 @@ func TestOneSourcesReplyInTime(t *testing.T) {
 		util.NewDummyMetricsSource("s1", time.Second),
 		util.NewDummyMetricsSource("s2", 30*time.Second))
 
-	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3)
+	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3, nil)
 	now := time.Now()
 	end := now.Truncate(10 * time.Second)
 	dataBatch := manager.ScrapeMetrics(end.Add(-10*time.Second), end)
@@ -88,7 +93,7 @@ func TestNoSourcesReplyInTime(t *testing.T) {
 		util.NewDummyMetricsSource("s1", 30*time.Second),
 		util.NewDummyMetricsSource("s2", 30*time.Second))
 
-	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3)
+	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3, nil)
 	now := time.Now()
 	end := now.Truncate(10 * time.Second)
 	dataBatch := manager.ScrapeMetrics(end.Add(-10*time.Second), end)
@@ -115,3 +120,151 @@ func TestNoSourcesReplyInTime(t *testing.T) {
 		t.Fatal("s2 found")
 	}
 }
+
+func TestScrapeSkew(t *testing.T) {
+	now := time.Now()
+	batch := &DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*MetricSet{
+			"s1": {ScrapeTime: now.Add(-5 * time.Second)},
+			"s2": {ScrapeTime: now},
+			"s3": {ScrapeTime: now.Add(-2 * time.Second)},
+			"s4": {},
+		},
+	}
+	if skew := getScrapeSkew(batch); skew != 5*time.Second {
+		t.Fatalf("unexpected skew: %s", skew)
+	}
+	if skew := getScrapeSkew(&DataBatch{}); skew != 0 {
+		t.Fatalf("unexpected skew for empty batch: %s", skew)
+	}
+}
+
+type namedSource string
+
+func (this namedSource) Name() string {
+	return string(this)
+}
+
+func (this namedSource) ScrapeMetrics(start, end time.Time) *DataBatch {
+	return &DataBatch{Timestamp: end}
+}
+
+func TestScheduleSources(t *testing.T) {
+	sources := []MetricsSource{
+		namedSource("big"),
+		namedSource("small1"),
+		namedSource("small2"),
+		namedSource("small3"),
+	}
+	costs := map[string]int{"big": 90, "small1": 30, "small2": 30, "small3": 30}
+
+	slots, slotCosts := scheduleSources(sources, costs, 2, rand.New(rand.NewSource(1)))
+	if len(slots) != len(sources) || len(slotCosts) != 2 {
+		t.Fatalf("unexpected schedule: %v %v", slots, slotCosts)
+	}
+	if slotCosts[0] != 90 || slotCosts[1] != 90 {
+		t.Fatalf("unbalanced slot costs: %v", slotCosts)
+	}
+	for i := 1; i < len(sources); i++ {
+		if slots[i] == slots[0] {
+			t.Fatalf("small source %s scheduled together with the big one: %v", sources[i].Name(), slots)
+		}
+	}
+
+	// A new source is expected to cost the average of the known ones.
+	sources = []MetricsSource{namedSource("a"), namedSource("b"), namedSource("new")}
+	_, slotCosts = scheduleSources(sources, map[string]int{"a": 10, "b": 30}, 10, rand.New(rand.NewSource(1)))
+	sort.Ints(slotCosts)
+	if len(slotCosts) != 3 || slotCosts[0] != 10 || slotCosts[1] != 20 || slotCosts[2] != 30 {
+		t.Fatalf("unexpected slot costs: %v", slotCosts)
+	}
+
+	if variance := getVariance([]int{1, 3}); variance != 1 {
+		t.Fatalf("unexpected variance: %v", variance)
+	}
+}
+
+// Source counting the concurrent scrapes of all sources sharing the counter.
+type countingSource struct {
+	name    string
+	counter *inFlightCounter
+}
+
+type inFlightCounter struct {
+	sync.Mutex
+	current int
+	max     int
+}
+
+func (this *countingSource) Name() string {
+	return this.name
+}
+
+func (this *countingSource) ScrapeMetrics(start, end time.Time) *DataBatch {
+	this.counter.Lock()
+	this.counter.current++
+	if this.counter.current > this.counter.max {
+		this.counter.max = this.counter.current
+	}
+	this.counter.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	this.counter.Lock()
+	this.counter.current--
+	this.counter.Unlock()
+	return &DataBatch{Timestamp: end, MetricSets: map[string]*MetricSet{this.name: {}}}
+}
+
+func TestLimitedSourceManager(t *testing.T) {
+	counter := &inFlightCounter{}
+	sources := []MetricsSource{}
+	for i := 0; i < 8; i++ {
+		sources = append(sources, &countingSource{name: fmt.Sprintf("s%d", i), counter: counter})
+	}
+	provider := util.NewDummyMetricsSourceProvider(sources...)
+
+	manager, err := NewLimitedSourceManager(provider, 3*time.Second, 2, 100*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Now()
+	dataBatch := manager.ScrapeMetrics(now.Add(-10*time.Second), now)
+	if len(dataBatch.MetricSets) != len(sources) {
+		t.Fatalf("expected all %d sources, got %d", len(sources), len(dataBatch.MetricSets))
+	}
+	if counter.max > 2 {
+		t.Fatalf("expected at most 2 concurrent scrapes, got %d", counter.max)
+	}
+	if elapsed := time.Since(now); elapsed < 200*time.Millisecond {
+		t.Fatalf("8 scrapes of 50ms by 2 workers took too short: %s", elapsed)
+	}
+
+	if _, err := NewLimitedSourceManager(provider, 3*time.Second, 2, 3*time.Second, nil); err == nil {
+		t.Fatal("expected error for spread not shorter than the timeout")
+	}
+}
+
+// observingProvider records when the scrape cycles of its sources are completed.
+type observingProvider struct {
+	*util.DummyMetricsSourceProvider
+	completed []time.Time
+}
+
+func (this *observingProvider) CompleteScrapeCycle(now time.Time) {
+	this.completed = append(this.completed, now)
+}
+
+func TestScrapeCycleCompleted(t *testing.T) {
+	provider := &observingProvider{DummyMetricsSourceProvider: util.NewDummyMetricsSourceProvider(
+		util.NewDummyMetricsSource("s1", 100*time.Millisecond))}
+	manager, _ := NewSourceManager(provider, time.Second*3, nil)
+	start := time.Now()
+	manager.ScrapeMetrics(start.Add(-10*time.Second), start)
+
+	if len(provider.completed) != 1 {
+		t.Fatalf("expected one completed cycle, got %d", len(provider.completed))
+	}
+	if scraped := provider.completed[0].Sub(start); scraped < 100*time.Millisecond {
+		t.Fatalf("cycle completed before the source was scraped: after %s", scraped)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+// The tests below run the scheduling of the source manager over virtual time, for
+// clusters far bigger than the ones the real scrape tests can afford. Every cycle is
+// planned with a fixed seed, so the results are deterministic.
+
+// Epoch of the virtual clock.
+var simEpoch = time.Unix(1500000000, 0)
+
+type prioritizedNamedSource string
+
+func (this prioritizedNamedSource) Name() string {
+	return string(this)
+}
+
+func (this prioritizedNamedSource) ScrapeMetrics(start, end time.Time) *DataBatch {
+	return &DataBatch{Timestamp: end}
+}
+
+func (this prioritizedNamedSource) IsPrioritized() bool {
+	return true
+}
+
+// simCluster returns the sources of a cluster of nodes, every heavyEvery-th running many
+// more pods than the rest, and their costs as learned from a previous cycle.
+func simCluster(nodes, heavyEvery int) ([]MetricsSource, map[string]int) {
+	sources := make([]MetricsSource, nodes)
+	costs := make(map[string]int, nodes)
+	for i := range sources {
+		name := fmt.Sprintf("node-%d", i)
+		sources[i] = namedSource(name)
+		costs[name] = 20
+		if i%heavyEvery == 0 {
+			costs[name] = 500
+		}
+	}
+	return sources, costs
+}
+
+// simWorkers runs the scrapes on a pool of workers the way a limited source manager does,
+// each scrape taking the given duration, and returns when every scrape actually started.
+func simWorkers(scrapes []scheduledScrape, workers int, duration func(MetricsSource) time.Duration) map[string]time.Time {
+	sortByStartTime(scrapes)
+	free := make([]time.Time, workers)
+	for i := range free {
+		free[i] = simEpoch
+	}
+	started := make(map[string]time.Time, len(scrapes))
+	for _, scrape := range scrapes {
+		// The worker becoming free first takes the next scrape.
+		worker := 0
+		for i := range free {
+			if free[i].Before(free[worker]) {
+				worker = i
+			}
+		}
+		start := scrape.startTime
+		if free[worker].After(start) {
+			start = free[worker]
+		}
+		started[scrape.source.Name()] = start
+		free[worker] = start.Add(duration(scrape.source))
+	}
+	return started
+}
+
+func TestSimulatedSpreadWithinWindow(t *testing.T) {
+	sources, costs := simCluster(5000, 20)
+	sources = append(sources, prioritizedNamedSource("pushed"))
+	window := getSpreadWindow(len(sources), 0)
+	if window != MaxDelayMs*time.Millisecond {
+		t.Fatalf("unexpected window for %d sources: %s", len(sources), window)
+	}
+
+	for seed := int64(0); seed < 20; seed++ {
+		scrapes, _ := planScrapes(sources, costs, simEpoch, window, rand.New(rand.NewSource(seed)))
+		for _, scrape := range scrapes {
+			delay := scrape.startTime.Sub(simEpoch)
+			if _, prioritized := scrape.source.(prioritizedNamedSource); prioritized {
+				if delay != 0 {
+					t.Fatalf("seed %d: prioritized source delayed by %s", seed, delay)
+				}
+				continue
+			}
+			if delay < 0 || delay >= window {
+				t.Fatalf("seed %d: %s starts at %s, outside of the %s window", seed, scrape.source.Name(), delay, window)
+			}
+		}
+	}
+}
+
+func TestSimulatedSpreadEvenLoad(t *testing.T) {
+	sources, costs := simCluster(5000, 20)
+	window := getSpreadWindow(len(sources), 0)
+	const bucket = 100 * time.Millisecond
+	buckets := int(window / bucket)
+
+	for seed := int64(0); seed < 20; seed++ {
+		scrapes, slotCosts := planScrapes(sources, costs, simEpoch, window, rand.New(rand.NewSource(seed)))
+
+		// The slots differ by at most the cost of the most expensive source.
+		minCost, maxCost := slotCosts[0], slotCosts[0]
+		for _, cost := range slotCosts {
+			if cost < minCost {
+				minCost = cost
+			}
+			if cost > maxCost {
+				maxCost = cost
+			}
+		}
+		if maxCost-minCost > 500 {
+			t.Fatalf("seed %d: unbalanced slot costs: %v", seed, slotCosts)
+		}
+
+		// No thundering herd: no bucket gets much more than its share of the starts or costs.
+		starts := make([]int, buckets)
+		bucketCosts := make([]int, buckets)
+		total := 0
+		for _, scrape := range scrapes {
+			i := int(scrape.startTime.Sub(simEpoch) / bucket)
+			starts[i]++
+			bucketCosts[i] += costs[scrape.source.Name()]
+			total += costs[scrape.source.Name()]
+		}
+		for i := range starts {
+			if starts[i] > 2*len(scrapes)/buckets {
+				t.Fatalf("seed %d: %d of %d scrapes start in bucket %d", seed, starts[i], len(scrapes), i)
+			}
+			if bucketCosts[i] > 2*total/buckets {
+				t.Fatalf("seed %d: bucket %d costs %d of %d", seed, i, bucketCosts[i], total)
+			}
+		}
+	}
+}
+
+func TestSimulatedSpreadJitter(t *testing.T) {
+	sources, costs := simCluster(2000, 20)
+	window := getSpreadWindow(len(sources), 0)
+
+	plan := func(seed int64) map[string]time.Time {
+		scrapes, _ := planScrapes(sources, costs, simEpoch, window, rand.New(rand.NewSource(seed)))
+		result := make(map[string]time.Time, len(scrapes))
+		for _, scrape := range scrapes {
+			result[scrape.source.Name()] = scrape.startTime
+		}
+		return result
+	}
+
+	// The same seed gives the same plan.
+	first, again := plan(1), plan(1)
+	for name, start := range first {
+		if !again[name].Equal(start) {
+			t.Fatalf("%s planned at %s and %s with the same seed", name, start, again[name])
+		}
+	}
+
+	// Consecutive cycles don't scrape the nodes at the same offsets, nor the heavy nodes
+	// always first.
+	heavyFirst := 0
+	previous := first
+	for seed := int64(2); seed < 12; seed++ {
+		current := plan(seed)
+		same := 0
+		for name, start := range current {
+			if previous[name].Equal(start) {
+				same++
+			}
+		}
+		if same > len(sources)/10 {
+			t.Fatalf("seed %d: %d of %d sources start at the same offset as in the previous cycle", seed, same, len(sources))
+		}
+		if current["node-0"].Sub(simEpoch) < window/MaxScheduleSlots {
+			heavyFirst++
+		}
+		previous = current
+	}
+	if heavyFirst == 10 {
+		t.Fatal("heavy node scraped in the first slot of every cycle")
+	}
+}
+
+func TestSimulatedSpreadWorkers(t *testing.T) {
+	sources, costs := simCluster(5000, 20)
+	window := getSpreadWindow(len(sources), 0)
+	slot := window / MaxScheduleSlots
+	// Scrapes take a millisecond per 10 units of cost.
+	duration := func(source MetricsSource) time.Duration {
+		return time.Duration(costs[source.Name()]/10) * time.Millisecond
+	}
+
+	scrapes, _ := planScrapes(sources, costs, simEpoch, window, rand.New(rand.NewSource(1)))
+	due := make(map[string]time.Time, len(scrapes))
+	for _, scrape := range scrapes {
+		due[scrape.source.Name()] = scrape.startTime
+	}
+
+	// Enough workers keep up with the plan.
+	started := simWorkers(scrapes, 50, duration)
+	for name, start := range started {
+		if lag := start.Sub(due[name]); lag > slot {
+			t.Fatalf("%s started %s late with 50 workers", name, lag)
+		}
+	}
+
+	// Too few workers fall behind but still take the scrapes in the order they are due,
+	// and finish before the scrape timeout.
+	started = simWorkers(scrapes, 2, duration)
+	for i := 1; i < len(scrapes); i++ {
+		if started[scrapes[i].source.Name()].Before(started[scrapes[i-1].source.Name()]) {
+			t.Fatalf("%s started before %s which was due earlier", scrapes[i].source.Name(), scrapes[i-1].source.Name())
+		}
+	}
+	last := started[scrapes[len(scrapes)-1].source.Name()]
+	if last.Sub(simEpoch) <= window {
+		t.Fatalf("expected 2 workers to fall behind the %s window, last scrape started at %s", window, last.Sub(simEpoch))
+	}
+	if last.Sub(simEpoch) >= DefaultMetricsScrapeTimeout {
+		t.Fatalf("last scrape started after the timeout: %s", last.Sub(simEpoch))
+	}
+}
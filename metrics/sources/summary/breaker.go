@@ -0,0 +1,173 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Number of consecutive failed scrapes after which a node is backed off.
+const breakerFailureThreshold = 3
+
+// Default for the longest a failing node is backed off.
+const DefaultMaxScrapeBackoff = 10 * time.Minute
+
+var (
+	breakerOpenNodes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet_summary",
+			Name:      "backed_off_nodes",
+			Help:      "Number of nodes which are not scraped because their previous scrapes failed.",
+		},
+	)
+
+	breakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet_summary",
+			Name:      "backoff_transitions_total",
+			Help:      "Number of times nodes were backed off (open) or scraped successfully again after being backed off (closed).",
+		},
+		[]string{"state"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(breakerOpenNodes)
+	prometheus.MustRegister(breakerTransitions)
+}
+
+// nodeBreaker backs off the nodes whose kubelets keep failing, so that unreachable nodes
+// don't use up the scrape timeout and flood the log every cycle. After
+// breakerFailureThreshold consecutive failures a node is skipped for the base backoff,
+// doubled with every further failure up to max, with up to a fifth of jitter. Once the
+// backoff passed the node is tried again, and closes the breaker if it succeeds.
+type nodeBreaker struct {
+	base time.Duration
+	max  time.Duration
+	// Returns a number in [0, 1) for the jitter.
+	random func() float64
+
+	lock  sync.Mutex
+	nodes map[string]*breakerState
+}
+
+type breakerState struct {
+	failures int
+	// Time before which the node is not scraped, zero if the breaker is closed.
+	openUntil time.Time
+}
+
+func newNodeBreaker(base, max time.Duration) *nodeBreaker {
+	return &nodeBreaker{
+		base:   base,
+		max:    max,
+		random: rand.Float64,
+		nodes:  map[string]*breakerState{},
+	}
+}
+
+// allow checks whether the node may be scraped now. If not, it returns why.
+func (this *nodeBreaker) allow(node string, now time.Time) (string, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	state, found := this.nodes[node]
+	if !found || !now.Before(state.openUntil) {
+		return "", true
+	}
+	return fmt.Sprintf("backed off after %d failed scrapes until %s", state.failures, state.openUntil.Format(time.RFC3339)), false
+}
+
+func (this *nodeBreaker) success(node string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	state, found := this.nodes[node]
+	if !found {
+		return
+	}
+	if !state.openUntil.IsZero() {
+		glog.Infof("Scraped node %s again after %d failed scrapes", node, state.failures)
+		breakerTransitions.WithLabelValues("closed").Inc()
+	}
+	delete(this.nodes, node)
+	this.updateOpenNodes()
+}
+
+func (this *nodeBreaker) failure(node string, now time.Time) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	state, found := this.nodes[node]
+	if !found {
+		state = &breakerState{}
+		this.nodes[node] = state
+	}
+	state.failures++
+	if state.failures < breakerFailureThreshold {
+		return
+	}
+	backoff := this.max
+	if exponent := uint(state.failures - breakerFailureThreshold); exponent < 32 && this.base<<exponent < this.max {
+		backoff = this.base << exponent
+	}
+	backoff -= time.Duration(float64(backoff) * this.random() / 5)
+	if state.openUntil.IsZero() {
+		glog.Warningf("Backing off node %s after %d failed scrapes, further failures are logged at level 2", node, state.failures)
+		breakerTransitions.WithLabelValues("open").Inc()
+	}
+	state.openUntil = now.Add(backoff)
+	this.updateOpenNodes()
+}
+
+// isOpen checks whether the node failed often enough to be backed off.
+func (this *nodeBreaker) isOpen(node string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	state, found := this.nodes[node]
+	return found && !state.openUntil.IsZero()
+}
+
+// retain forgets about the nodes that are gone.
+func (this *nodeBreaker) retain(nodes []*corev1.Node) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	present := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		present[node.Name] = true
+	}
+	for name := range this.nodes {
+		if !present[name] {
+			delete(this.nodes, name)
+		}
+	}
+	this.updateOpenNodes()
+}
+
+func (this *nodeBreaker) updateOpenNodes() {
+	open := 0
+	for _, state := range this.nodes {
+		if !state.openUntil.IsZero() {
+			open++
+		}
+	}
+	breakerOpenNodes.Set(float64(open))
+}
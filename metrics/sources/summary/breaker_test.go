@@ -0,0 +1,77 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeBreaker(t *testing.T) {
+	breaker := newNodeBreaker(time.Minute, 5*time.Minute)
+	breaker.random = func() float64 { return 0 }
+	now := time.Now()
+
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		breaker.failure("node1", now)
+	}
+	_, allowed := breaker.allow("node1", now)
+	assert.True(t, allowed, "below the threshold")
+	assert.False(t, breaker.isOpen("node1"))
+
+	breaker.failure("node1", now)
+	reason, allowed := breaker.allow("node1", now.Add(59*time.Second))
+	assert.False(t, allowed)
+	assert.Contains(t, reason, "backed off after 3 failed scrapes")
+	_, allowed = breaker.allow("node1", now.Add(time.Minute))
+	assert.True(t, allowed, "tried again after the backoff")
+
+	// Backoff doubles with every further failure, up to the max.
+	now = now.Add(time.Minute)
+	breaker.failure("node1", now)
+	_, allowed = breaker.allow("node1", now.Add(119*time.Second))
+	assert.False(t, allowed)
+	for i := 0; i < 5; i++ {
+		breaker.failure("node1", now)
+	}
+	_, allowed = breaker.allow("node1", now.Add(5*time.Minute))
+	assert.True(t, allowed)
+
+	breaker.success("node1")
+	assert.False(t, breaker.isOpen("node1"))
+	breaker.failure("node1", now)
+	_, allowed = breaker.allow("node1", now)
+	assert.True(t, allowed, "failures counted anew")
+
+	breaker.retain([]*corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}})
+	assert.Empty(t, breaker.nodes)
+}
+
+func TestNodeBreakerJitter(t *testing.T) {
+	breaker := newNodeBreaker(time.Minute, 5*time.Minute)
+	breaker.random = func() float64 { return 0.5 }
+	now := time.Now()
+	for i := 0; i < breakerFailureThreshold; i++ {
+		breaker.failure("node1", now)
+	}
+	_, allowed := breaker.allow("node1", now.Add(53*time.Second))
+	assert.False(t, allowed)
+	_, allowed = breaker.allow("node1", now.Add(54*time.Second))
+	assert.True(t, allowed, "a tenth shorter")
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"net/url"
+	"sync/atomic"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+)
+
+// CollectionModeSetter is implemented by the summary source provider, whose collection
+// mode can be changed while running.
+type CollectionModeSetter interface {
+	// SetCollectionMode selects which metrics are collected from the kubelet summaries,
+	// from the next scrape on.
+	SetCollectionMode(mode string) error
+}
+
+// WithCollectionMode returns a copy of the URI setting the collectionMode option to the
+// mode, unless it's empty.
+func WithCollectionMode(uri *url.URL, mode string) *url.URL {
+	if mode == "" {
+		return uri
+	}
+	result := *uri
+	opts := result.Query()
+	opts.Set("collectionMode", mode)
+	result.RawQuery = opts.Encode()
+	return &result
+}
+
+// collectionMode is the collection mode of a provider, which can be changed while its
+// sources are created.
+type collectionMode struct {
+	// Set while only cpu and memory metrics are collected. It is read when the sources
+	// of a scrape are created, so that a mode change applies from the next scrape on.
+	resourcesOnly int32
+}
+
+func (this *collectionMode) SetCollectionMode(mode string) error {
+	if err := util.ValidateCollectionMode(mode); err != nil {
+		return err
+	}
+	var value int32
+	if mode == util.CollectionResources {
+		value = 1
+	}
+	atomic.StoreInt32(&this.resourcesOnly, value)
+	return nil
+}
+
+func (this *collectionMode) isResourcesOnly() bool {
+	return atomic.LoadInt32(&this.resourcesOnly) == 1
+}
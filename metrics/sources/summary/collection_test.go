@@ -0,0 +1,44 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+)
+
+func TestSetCollectionMode(t *testing.T) {
+	var provider CollectionModeSetter = &summaryProvider{}
+	mode := &provider.(*summaryProvider).collectionMode
+	assert.Error(t, provider.SetCollectionMode("everything"))
+	assert.False(t, mode.isResourcesOnly())
+	require.NoError(t, provider.SetCollectionMode(util.CollectionResources))
+	assert.True(t, mode.isResourcesOnly())
+	require.NoError(t, provider.SetCollectionMode(util.CollectionFull))
+	assert.False(t, mode.isResourcesOnly())
+}
+
+func TestWithCollectionMode(t *testing.T) {
+	uri, err := url.Parse("https://kubernetes.default?inClusterConfig=false")
+	require.NoError(t, err)
+	assert.Equal(t, uri, WithCollectionMode(uri, ""))
+	assert.Equal(t, "https://kubernetes.default?collectionMode=resources&inClusterConfig=false", WithCollectionMode(uri, util.CollectionResources).String())
+	assert.Equal(t, "https://kubernetes.default?inClusterConfig=false", uri.String(), "the URI is copied")
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+// Path under which the decode reports of the nodes are served.
+const DecodeReportPath = "/debug/summary-decode"
+
+// Problems found in the stats of kubelet summaries, which leave out the affected objects
+// or make their usage less accurate.
+const (
+	IssueMissingCPUStats         = "missing_cpu_stats"
+	IssueMissingCPUUsage         = "missing_cpu_usage"
+	IssueMissingMemoryStats      = "missing_memory_stats"
+	IssueMissingMemoryWorkingSet = "missing_memory_working_set"
+	IssueMissingTimestamp        = "missing_timestamp"
+	IssueMissingStartTime        = "missing_start_time"
+)
+
+// Number of affected objects listed per issue.
+const maxIssueExamples = 10
+
+var summaryDecodeIssues = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "kubelet_summary",
+		Name:      "decode_issues_total",
+		Help:      "Number of objects with missing or invalid stats in the decoded kubelet summaries, by issue.",
+	},
+	[]string{"issue"},
+)
+
+func init() {
+	prometheus.MustRegister(summaryDecodeIssues)
+}
+
+// NodeDecodeReport lists the issues found in the latest summary of a node.
+type NodeDecodeReport struct {
+	Node      string    `json:"node"`
+	Timestamp time.Time `json:"timestamp"`
+	// Number of affected objects by issue.
+	Issues map[string]int `json:"issues"`
+	// Some of the affected objects by issue: "node", "system/<container>" or
+	// "<namespace>/<pod>/<container>".
+	Examples map[string][]string `json:"examples,omitempty"`
+}
+
+func newNodeDecodeReport(node string) *NodeDecodeReport {
+	return &NodeDecodeReport{
+		Node:      node,
+		Timestamp: time.Now(),
+		Issues:    map[string]int{},
+		Examples:  map[string][]string{},
+	}
+}
+
+func (this *NodeDecodeReport) add(issue, object string) {
+	this.Issues[issue]++
+	if len(this.Examples[issue]) < maxIssueExamples {
+		this.Examples[issue] = append(this.Examples[issue], object)
+	}
+	summaryDecodeIssues.WithLabelValues(issue).Inc()
+}
+
+// checkStats records the issues of the stats of a node or container.
+func (this *NodeDecodeReport) checkStats(object string, startTime time.Time, cpu *stats.CPUStats, memory *stats.MemoryStats) {
+	if startTime.IsZero() {
+		this.add(IssueMissingStartTime, object)
+	}
+	this.checkUsage(object, cpu, memory)
+}
+
+// checkUsage records the issues of the cpu and memory stats of a node or container.
+func (this *NodeDecodeReport) checkUsage(object string, cpu *stats.CPUStats, memory *stats.MemoryStats) {
+	if cpu == nil {
+		this.add(IssueMissingCPUStats, object)
+	} else if cpu.UsageCoreNanoSeconds == nil {
+		this.add(IssueMissingCPUUsage, object)
+	}
+	if memory == nil {
+		this.add(IssueMissingMemoryStats, object)
+	} else if memory.WorkingSetBytes == nil {
+		this.add(IssueMissingMemoryWorkingSet, object)
+	}
+	if (cpu == nil || cpu.Time.IsZero()) && (memory == nil || memory.Time.IsZero()) {
+		this.add(IssueMissingTimestamp, object)
+	}
+}
+
+// Latest decode reports of the scraped nodes, shared by all summary sources.
+var decodeReports = struct {
+	sync.Mutex
+	nodes map[string]*NodeDecodeReport
+}{nodes: map[string]*NodeDecodeReport{}}
+
+func storeDecodeReport(report *NodeDecodeReport) {
+	if !keepDebugState {
+		return
+	}
+	decodeReports.Lock()
+	defer decodeReports.Unlock()
+	decodeReports.nodes[report.Node] = report
+}
+
+// retainDecodeReports drops the reports of nodes which no longer exist.
+func retainDecodeReports(nodes []*corev1.Node) {
+	names := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		names[node.Name] = true
+	}
+	decodeReports.Lock()
+	defer decodeReports.Unlock()
+	for name := range decodeReports.nodes {
+		if !names[name] {
+			delete(decodeReports.nodes, name)
+		}
+	}
+}
+
+// GetDecodeReports returns the latest decode reports of the nodes, sorted by node. Nodes
+// whose summaries had no issues are left out unless all is set.
+func GetDecodeReports(all bool) []NodeDecodeReport {
+	decodeReports.Lock()
+	defer decodeReports.Unlock()
+	result := make([]NodeDecodeReport, 0, len(decodeReports.nodes))
+	for _, report := range decodeReports.nodes {
+		if all || len(report.Issues) > 0 {
+			result = append(result, *report)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Node < result[j].Node })
+	return result
+}
+
+// NewDecodeReportHandler returns a handler serving the decode reports of the nodes with
+// issues as JSON, or of all scraped nodes with the all query parameter.
+func NewDecodeReportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, all := req.URL.Query()["all"]
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(GetDecodeReports(all)); err != nil {
+			glog.Errorf("Error while writing decode reports: %v", err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nodebug
+// +build !nodebug
+
+package summary
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+func TestDecodeReport(t *testing.T) {
+	defer retainDecodeReports(nil)
+	ms := testingSummaryMetricsSource()
+	noCPU := genTestSummaryContainer(cName00, seedPod0Container0)
+	noCPU.CPU = nil
+	noStartTime := genTestSummaryContainer(cName01, seedPod0Container1)
+	noStartTime.StartTime = metav1.Time{}
+	summary := stats.Summary{
+		Node: stats.NodeStats{
+			NodeName:  nodeInfo.NodeName,
+			StartTime: metav1.NewTime(startTime),
+			CPU:       genTestSummaryCPU(seedNode),
+			Memory:    genTestSummaryMemory(seedNode),
+		},
+		Pods: []stats.PodStats{{
+			PodRef:     stats.PodReference{Name: pName0, Namespace: namespace0},
+			StartTime:  metav1.NewTime(startTime),
+			Containers: []stats.ContainerStats{noCPU, noStartTime},
+		}},
+	}
+	ms.decodeSummary(&summary)
+	storeDecodeReport(ms.report)
+
+	reports := GetDecodeReports(false)
+	require.Len(t, reports, 1)
+	assert.Equal(t, nodeInfo.NodeName, reports[0].Node)
+	assert.Equal(t, map[string]int{IssueMissingCPUStats: 1, IssueMissingStartTime: 1}, reports[0].Issues)
+	assert.Equal(t, []string{namespace0 + "/" + pName0 + "/" + cName00}, reports[0].Examples[IssueMissingCPUStats])
+
+	server := httptest.NewServer(NewDecodeReportHandler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL + DecodeReportPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	served := []NodeDecodeReport{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&served))
+	require.Len(t, served, 1)
+	assert.Equal(t, reports[0].Issues, served[0].Issues)
+
+	summary.Pods = nil
+	ms.decodeSummary(&summary)
+	storeDecodeReport(ms.report)
+	assert.Empty(t, GetDecodeReports(false), "no issues in the latest summary")
+	assert.Len(t, GetDecodeReports(true), 1)
+
+	retainDecodeReports([]*corev1.Node{})
+	assert.Empty(t, GetDecodeReports(true), "node removed")
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build gofuzz
+// +build gofuzz
+
+// Fuzzing entry points for go-fuzz (github.com/dvyukov/go-fuzz). The seed corpus of
+// anonymized kubelet summaries lives in testdata/corpus:
+//
+//   go-fuzz-build -func Fuzz github.com/kubernetes-incubator/metrics-server/metrics/sources/summary
+//   go-fuzz -bin summary-fuzz.zip -workdir testdata
+//
+// Build with -func FuzzIngest to fuzz the ingestion into the storage as well.
+
+package summary
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/processors"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+// Fuzz decodes the data as a kubelet summary.
+func Fuzz(data []byte) int {
+	summary := &stats.Summary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		return 0
+	}
+	fuzzSource().decodeSummary(summary)
+	return 1
+}
+
+// FuzzIngest decodes the data as two consecutive kubelet summaries and passes them
+// through the rate calculation and the metric sink to the storage.
+func FuzzIngest(data []byte) int {
+	summary := &stats.Summary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		return 0
+	}
+
+	source := fuzzSource()
+	rateCalculator := processors.NewRateCalculator(RateMetricsMapping)
+	sink := metricsink.NewMetricSink(140*time.Second, 15*time.Minute, []string{
+		MetricCpuUsageRate.MetricDescriptor.Name,
+		MetricMemoryUsage.MetricDescriptor.Name,
+		MetricMemoryWorkingSet.MetricDescriptor.Name})
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		batch := &DataBatch{
+			Timestamp:  now.Add(time.Duration(i) * time.Minute),
+			MetricSets: source.decodeSummary(summary),
+		}
+		if i > 0 {
+			// Pretend every sample was taken a minute later, so that rates are computed.
+			for _, ms := range batch.MetricSets {
+				ms.ScrapeTime = ms.ScrapeTime.Add(time.Minute)
+			}
+		}
+		batch, err := rateCalculator.Process(batch)
+		if err != nil {
+			return 0
+		}
+		sink.ExportData(batch)
+	}
+
+	batch := sink.GetAveragedDataBatch(5 * time.Minute)
+	if batch == nil {
+		panic("no batch stored")
+	}
+	for _, ms := range batch.MetricSets {
+		util.ParseResourceList(ms)
+	}
+	return 1
+}
+
+func fuzzSource() *summaryMetricsSource {
+	return &summaryMetricsSource{
+		node: NodeInfo{
+			NodeName: "fuzz",
+			HostName: "fuzz",
+			HostID:   "fuzz",
+		},
+		kubeletClient: &kubelet.KubeletClient{},
+	}
+}
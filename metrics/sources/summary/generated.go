@@ -0,0 +1,65 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
+	corev1 "k8s.io/api/core/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+// generatedMetricsSource decodes the summaries of a node generated at every scrape instead
+// of fetching them from its kubelet, for simulating clusters.
+type generatedMetricsSource struct {
+	generate func(now time.Time) *stats.Summary
+	decoder  *summaryMetricsSource
+}
+
+// NewGeneratedMetricsSource returns a source decoding the summary generate returns for the
+// time of each scrape, as if it had been returned by the kubelet of the node. Only
+// resource metrics are decoded if resourcesOnly is set, as in the resources collection mode.
+func NewGeneratedMetricsSource(node *corev1.Node, resourcesOnly bool, generate func(now time.Time) *stats.Summary) MetricsSource {
+	return &generatedMetricsSource{
+		generate: generate,
+		decoder: &summaryMetricsSource{
+			node: NodeInfo{
+				NodeName:       node.Name,
+				HostName:       node.Name,
+				HostID:         node.Spec.ExternalID,
+				KubeletVersion: node.Status.NodeInfo.KubeletVersion,
+			},
+			resourcesOnly: resourcesOnly,
+		},
+	}
+}
+
+func (this *generatedMetricsSource) Name() string {
+	return this.String()
+}
+
+func (this *generatedMetricsSource) String() string {
+	return fmt.Sprintf("kubelet_summary_generated:%s", this.decoder.node.NodeName)
+}
+
+func (this *generatedMetricsSource) ScrapeMetrics(start, end time.Time) *DataBatch {
+	now := time.Now()
+	return &DataBatch{
+		Timestamp:  now,
+		MetricSets: this.decoder.decodeSummary(this.generate(now)),
+	}
+}
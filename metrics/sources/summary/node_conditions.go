@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeCondition is a condition a node must be in to be scraped. Nodes which don't
+// report the condition at all are scraped.
+type NodeCondition struct {
+	Type   corev1.NodeConditionType
+	Status corev1.ConditionStatus
+}
+
+// Conditions required unless the nodeConditions option is given.
+var DefaultNodeConditions = []NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+
+// ParseNodeConditions parses a comma separated list of conditions as Type=Status, e.g.
+// "Ready=True,NetworkUnavailable=False".
+func ParseNodeConditions(value string) ([]NodeCondition, error) {
+	var conditions []NodeCondition
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid node condition %q, expected Type=Status", item)
+		}
+		status := corev1.ConditionStatus(strings.TrimSpace(parts[1]))
+		switch status {
+		case corev1.ConditionTrue, corev1.ConditionFalse, corev1.ConditionUnknown:
+		default:
+			return nil, fmt.Errorf("invalid node condition %q: status must be True, False or Unknown", item)
+		}
+		conditions = append(conditions, NodeCondition{Type: corev1.NodeConditionType(strings.TrimSpace(parts[0])), Status: status})
+	}
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("no node conditions given")
+	}
+	return conditions, nil
+}
+
+// unmetNodeCondition returns the first of the conditions the node reports with another
+// status.
+func unmetNodeCondition(node *corev1.Node, conditions []NodeCondition) (*corev1.NodeCondition, bool) {
+	for _, required := range conditions {
+		for i := range node.Status.Conditions {
+			c := &node.Status.Conditions[i]
+			if c.Type == required.Type && c.Status != required.Status {
+				return c, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseNodeConditions(t *testing.T) {
+	conditions, err := ParseNodeConditions("Ready=True, NetworkUnavailable=False")
+	require.NoError(t, err)
+	assert.Equal(t, []NodeCondition{
+		{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+		{Type: corev1.NodeNetworkUnavailable, Status: corev1.ConditionFalse},
+	}, conditions)
+
+	for _, value := range []string{"", "Ready", "=True", "Ready=Yes"} {
+		_, err := ParseNodeConditions(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestNodeConditions(t *testing.T) {
+	node := func(name string, conditions ...corev1.NodeCondition) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Conditions: conditions,
+				Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+			},
+		}
+	}
+	ready := corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionTrue}
+	notReady := corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionFalse}
+	networkUnavailable := corev1.NodeCondition{Type: corev1.NodeNetworkUnavailable, Status: corev1.ConditionTrue}
+
+	provider := &summaryProvider{
+		addressResolver: &kubelet.PriorityNodeAddressResolver{AddressTypes: kubelet.DefaultAddressTypes, Port: 10250},
+	}
+	_, err := provider.getNodeInfo(node("n1", ready, networkUnavailable))
+	assert.NoError(t, err, "only Ready is required by default")
+	_, err = provider.getNodeInfo(node("n2", notReady))
+	assert.EqualError(t, err, "Node n2 is not ready")
+
+	provider.nodeConditions, err = ParseNodeConditions("Ready=True,NetworkUnavailable=False")
+	require.NoError(t, err)
+	_, err = provider.getNodeInfo(node("n1", ready, networkUnavailable))
+	assert.EqualError(t, err, "Node n1 has condition NetworkUnavailable=True")
+	_, err = provider.getNodeInfo(node("n3", ready))
+	assert.NoError(t, err, "nodes not reporting a condition are scraped")
+}
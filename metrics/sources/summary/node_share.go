@@ -0,0 +1,81 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+)
+
+// WithNodeShare returns a copy of the URI setting the nodeShare option to the share of the
+// nodes, between 0 and 1, e.g. for canary instances only scraping some nodes. The URI is
+// returned as is if the share holds all nodes.
+func WithNodeShare(uri *url.URL, share float64) *url.URL {
+	if share >= 1 {
+		return uri
+	}
+	result := *uri
+	opts := result.Query()
+	opts.Set("nodeShare", strconv.FormatFloat(share, 'g', -1, 64))
+	result.RawQuery = opts.Encode()
+	return &result
+}
+
+// parseNodeShare returns the share of the nodes the options of the URI limit the scrapes
+// to, 1 if they don't.
+func parseNodeShare(opts url.Values) (float64, error) {
+	if len(opts["nodeShare"]) == 0 {
+		return 1, nil
+	}
+	share, err := strconv.ParseFloat(opts.Get("nodeShare"), 64)
+	if err != nil || share <= 0 || share > 1 {
+		return 0, fmt.Errorf("invalid nodeShare: %q must be greater than 0 and at most 1", opts.Get("nodeShare"))
+	}
+	return share, nil
+}
+
+// WithShard returns a copy of the URI setting the shardIndex and shardCount options to
+// the shard, unless it holds all nodes.
+func WithShard(uri *url.URL, shard util.Shard) *url.URL {
+	if shard.Count <= 1 {
+		return uri
+	}
+	result := *uri
+	opts := result.Query()
+	opts.Set("shardIndex", strconv.Itoa(shard.Index))
+	opts.Set("shardCount", strconv.Itoa(shard.Count))
+	result.RawQuery = opts.Encode()
+	return &result
+}
+
+// parseShard returns the shard the options of the URI limit the scrapes to, all nodes if
+// they don't.
+func parseShard(opts url.Values) (util.Shard, error) {
+	if len(opts["shardCount"]) == 0 {
+		return util.Shard{}, nil
+	}
+	count, err := strconv.Atoi(opts.Get("shardCount"))
+	if err != nil {
+		return util.Shard{}, fmt.Errorf("invalid shardCount: %q must be an integer", opts.Get("shardCount"))
+	}
+	index, err := strconv.Atoi(opts.Get("shardIndex"))
+	if err != nil {
+		return util.Shard{}, fmt.Errorf("invalid shardIndex: %q must be an integer", opts.Get("shardIndex"))
+	}
+	return util.NewShard(index, count)
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+)
+
+func TestWithNodeShare(t *testing.T) {
+	uri := &url.URL{Scheme: "https", Host: "kubernetes.default", RawQuery: "useServiceAccount=true"}
+	assert.Equal(t, uri, WithNodeShare(uri, 1))
+	share, err := parseNodeShare(uri.Query())
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, share, "all nodes by default")
+
+	share, err = parseNodeShare(WithNodeShare(uri, 0.25).Query())
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, share)
+	assert.Equal(t, "useServiceAccount=true", uri.RawQuery, "the URI is copied")
+	_, err = parseNodeShare(url.Values{"nodeShare": {"0"}})
+	assert.Error(t, err)
+	_, err = parseNodeShare(url.Values{"nodeShare": {"1.5"}})
+	assert.Error(t, err)
+}
+
+func TestWithShard(t *testing.T) {
+	uri := &url.URL{Scheme: "https", Host: "kubernetes.default", RawQuery: "useServiceAccount=true"}
+	assert.Equal(t, uri, WithShard(uri, util.Shard{Index: 0, Count: 1}))
+
+	sharded := WithShard(uri, util.Shard{Index: 1, Count: 3})
+	shard, err := parseShard(sharded.Query())
+	require.NoError(t, err)
+	assert.Equal(t, util.Shard{Index: 1, Count: 3}, shard)
+	assert.Equal(t, "true", sharded.Query().Get("useServiceAccount"))
+	assert.Equal(t, "useServiceAccount=true", uri.RawQuery, "the URI is copied")
+
+	shard, err = parseShard(uri.Query())
+	require.NoError(t, err)
+	assert.Equal(t, util.Shard{}, shard)
+	_, err = parseShard(url.Values{"shardIndex": {"3"}, "shardCount": {"3"}})
+	assert.Error(t, err)
+	_, err = parseShard(url.Values{"shardCount": {"three"}})
+	assert.Error(t, err)
+}
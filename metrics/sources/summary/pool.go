@@ -0,0 +1,128 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Longest a scrape waits for a free slot of its node pool, leaving time for the request
+// itself within the scrape timeout.
+const DefaultNodePoolWaitTimeout = 10 * time.Second
+
+var (
+	poolInFlightRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet_summary",
+			Name:      "pool_in_flight_requests",
+			Help:      "Number of summary requests in flight per node pool.",
+		},
+		[]string{"pool"},
+	)
+
+	poolWaitTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet_summary",
+			Name:      "pool_wait_timeouts_total",
+			Help:      "Number of scrapes skipped because all slots of their node pool stayed busy.",
+		},
+		[]string{"pool"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(poolInFlightRequests)
+	prometheus.MustRegister(poolWaitTimeouts)
+}
+
+// poolLimiter limits the concurrent summary requests of every node pool, so a pool of
+// slow nodes can't tie up the scrapes of the healthy ones. Nodes are grouped by the value
+// of a label, nodes without it form the "" pool.
+type poolLimiter struct {
+	label   string
+	limit   int
+	timeout time.Duration
+
+	lock  sync.Mutex
+	pools map[string]*scrapePool
+}
+
+type scrapePool struct {
+	name    string
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newPoolLimiter(label string, limit int, timeout time.Duration) *poolLimiter {
+	return &poolLimiter{
+		label:   label,
+		limit:   limit,
+		timeout: timeout,
+		pools:   map[string]*scrapePool{},
+	}
+}
+
+// pool returns the pool of the node.
+func (this *poolLimiter) pool(node *corev1.Node) *scrapePool {
+	name := node.Labels[this.label]
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	pool, found := this.pools[name]
+	if !found {
+		pool = &scrapePool{name: name, slots: make(chan struct{}, this.limit), timeout: this.timeout}
+		this.pools[name] = pool
+	}
+	return pool
+}
+
+// retain forgets the pools without nodes.
+func (this *poolLimiter) retain(nodes []*corev1.Node) {
+	present := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		present[node.Labels[this.label]] = true
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for name := range this.pools {
+		if !present[name] {
+			delete(this.pools, name)
+			poolInFlightRequests.DeleteLabelValues(name)
+		}
+	}
+}
+
+// acquire waits for a free slot and returns false if none was freed within the timeout.
+// Every successful acquire must be followed by a release.
+func (this *scrapePool) acquire() bool {
+	select {
+	case this.slots <- struct{}{}:
+	case <-time.After(this.timeout):
+		poolWaitTimeouts.WithLabelValues(this.name).Inc()
+		return false
+	}
+	poolInFlightRequests.WithLabelValues(this.name).Inc()
+	return true
+}
+
+func (this *scrapePool) release() {
+	<-this.slots
+	poolInFlightRequests.WithLabelValues(this.name).Dec()
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPoolLimiter(t *testing.T) {
+	limiter := newPoolLimiter("pool", 2, 10*time.Millisecond)
+	slow := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "slow1", Labels: map[string]string{"pool": "slow"}}}
+	slowPool := limiter.pool(slow)
+	healthyPool := limiter.pool(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "healthy1", Labels: map[string]string{"pool": "healthy"}}})
+	assert.Equal(t, slowPool, limiter.pool(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "slow2", Labels: map[string]string{"pool": "slow"}}}))
+	assert.Equal(t, "", limiter.pool(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}}).name)
+
+	assert.True(t, slowPool.acquire())
+	assert.True(t, slowPool.acquire())
+	assert.False(t, slowPool.acquire(), "all slots of the pool are busy")
+	assert.True(t, healthyPool.acquire(), "other pools are not affected")
+
+	slowPool.release()
+	assert.True(t, slowPool.acquire())
+
+	limiter.retain([]*corev1.Node{slow})
+	assert.Len(t, limiter.pools, 1)
+	assert.Equal(t, slowPool, limiter.pool(slow), "pools with nodes are kept")
+}
@@ -0,0 +1,232 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
+	corev1 "k8s.io/api/core/v1"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+// Path summaries are pushed to by agents of nodes whose kubelet can't be scraped, e.g.
+// virtual kubelets or edge nodes behind NAT. Pushing requires the post verb on the
+// non-resource URL, and agents can only push the summary of their own node.
+const PushPath = "/ingest/summary"
+
+// Prefix of the names of the users nodes authenticate as, followed by the node name.
+const nodeUserPrefix = "system:node:"
+
+// Largest summary accepted.
+const maxPushedSummaryBytes = 16 << 20
+
+var pushedSummaries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "kubelet_summary",
+		Name:      "pushed_total",
+		Help:      "Number of summaries pushed by node agents, by result.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(pushedSummaries)
+}
+
+// Latest summaries pushed by node agents, shared by all summary sources. A pushed summary
+// is used in place of scraping the node for maxAge after it was received.
+var pushes = struct {
+	sync.Mutex
+	maxAge time.Duration
+	nodes  map[string]*pushedSummary
+}{nodes: map[string]*pushedSummary{}}
+
+type pushedSummary struct {
+	summary  *stats.Summary
+	received time.Time
+}
+
+// SetPushMaxAge enables pushed summaries, which are used for at most maxAge after they
+// were received. Zero disables them.
+func SetPushMaxAge(maxAge time.Duration) {
+	pushes.Lock()
+	defer pushes.Unlock()
+	pushes.maxAge = maxAge
+}
+
+func storePushedSummary(summary *stats.Summary, now time.Time) {
+	pushes.Lock()
+	defer pushes.Unlock()
+	pushes.nodes[summary.Node.NodeName] = &pushedSummary{summary: summary, received: now}
+}
+
+// getPushedSummary returns the summary pushed for the node, nil if there is no recent one.
+func getPushedSummary(node string, now time.Time) *stats.Summary {
+	pushes.Lock()
+	defer pushes.Unlock()
+	pushed, found := pushes.nodes[node]
+	if !found || pushes.maxAge <= 0 || now.Sub(pushed.received) > pushes.maxAge {
+		return nil
+	}
+	return pushed.summary
+}
+
+// retainPushedSummaries drops the summaries of nodes which no longer exist.
+func retainPushedSummaries(nodes []*corev1.Node) {
+	names := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		names[node.Name] = true
+	}
+	pushes.Lock()
+	defer pushes.Unlock()
+	for name := range pushes.nodes {
+		if !names[name] {
+			delete(pushes.nodes, name)
+		}
+	}
+}
+
+// NewPushHandler returns a handler accepting summaries in the format of the kubelet
+// Summary API. They're served for the node named in the summary, which has to exist, and
+// are only accepted from the user of that node, system:node:<name>. Pods the pod lister
+// doesn't place on the node are dropped from the summary, all pods if the lister is nil.
+func NewPushHandler(mapper genericapirequest.RequestContextMapper, podLister v1listers.PodLister) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "summaries have to be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, ok := mapper.Get(req)
+		if !ok {
+			http.Error(w, "no context found for request", http.StatusInternalServerError)
+			return
+		}
+		user, ok := genericapirequest.UserFrom(ctx)
+		if !ok {
+			pushedSummaries.WithLabelValues("unauthenticated").Inc()
+			http.Error(w, "summaries can only be pushed by authenticated nodes", http.StatusUnauthorized)
+			return
+		}
+		summary, err := decodePushedSummary(http.MaxBytesReader(w, req.Body, maxPushedSummaryBytes))
+		if err != nil {
+			pushedSummaries.WithLabelValues("invalid").Inc()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if user.GetName() != nodeUserPrefix+summary.Node.NodeName {
+			pushedSummaries.WithLabelValues("forbidden").Inc()
+			http.Error(w, fmt.Sprintf("user %q can't push the summary of node %s", user.GetName(), summary.Node.NodeName), http.StatusForbidden)
+			return
+		}
+		if rejected := retainScheduledPods(summary, podLister); rejected > 0 {
+			glog.Warningf("Dropped %d pods not scheduled on node %s from its pushed summary", rejected, summary.Node.NodeName)
+		}
+		storePushedSummary(summary, time.Now())
+		pushedSummaries.WithLabelValues("accepted").Inc()
+		glog.V(4).Infof("Received summary of node %s with %d pods", summary.Node.NodeName, len(summary.Pods))
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func decodePushedSummary(r io.Reader) (*stats.Summary, error) {
+	summary := &stats.Summary{}
+	if err := json.NewDecoder(r).Decode(summary); err != nil {
+		return nil, fmt.Errorf("failed to decode summary: %v", err)
+	}
+	if summary.Node.NodeName == "" {
+		return nil, fmt.Errorf("summary has no node name")
+	}
+	return summary, nil
+}
+
+// retainScheduledPods drops the pods of the summary which the lister doesn't place on its
+// node, so an agent can't report usage of pods of other nodes, and returns their number.
+func retainScheduledPods(summary *stats.Summary, podLister v1listers.PodLister) int {
+	retained := summary.Pods[:0]
+	for _, pod := range summary.Pods {
+		if podLister == nil {
+			break
+		}
+		scheduled, err := podLister.Pods(pod.PodRef.Namespace).Get(pod.PodRef.Name)
+		if err != nil || scheduled.Spec.NodeName != summary.Node.NodeName || string(scheduled.UID) != pod.PodRef.UID {
+			continue
+		}
+		retained = append(retained, pod)
+	}
+	rejected := len(summary.Pods) - len(retained)
+	summary.Pods = retained
+	return rejected
+}
+
+// pushedMetricsSource serves a summary pushed for a node. It's prioritized, as there is
+// nothing to delay.
+type pushedMetricsSource struct {
+	summary *stats.Summary
+	decoder *summaryMetricsSource
+}
+
+func newPushedMetricsSource(node *corev1.Node, summary *stats.Summary, resourcesOnly bool) *pushedMetricsSource {
+	return &pushedMetricsSource{
+		summary: summary,
+		decoder: &summaryMetricsSource{
+			node: NodeInfo{
+				NodeName:       node.Name,
+				HostName:       node.Name,
+				HostID:         node.Spec.ExternalID,
+				KubeletVersion: node.Status.NodeInfo.KubeletVersion,
+			},
+			resourcesOnly: resourcesOnly,
+		},
+	}
+}
+
+func (this *pushedMetricsSource) Name() string {
+	return this.String()
+}
+
+func (this *pushedMetricsSource) String() string {
+	return fmt.Sprintf("kubelet_summary_push:%s", this.decoder.node.NodeName)
+}
+
+func (this *pushedMetricsSource) IsPrioritized() bool {
+	return true
+}
+
+func (this *pushedMetricsSource) ScrapeMetrics(start, end time.Time) *DataBatch {
+	if isNodeDeleted(this.decoder.node.NodeName) {
+		glog.V(2).Infof("Discarding pushed summary of node %s, which was deleted", this.decoder.node.NodeName)
+		deletedNodeScrapes.Inc()
+		return &DataBatch{Timestamp: time.Now(), MetricSets: map[string]*MetricSet{}}
+	}
+	result := &DataBatch{
+		Timestamp:  time.Now(),
+		MetricSets: this.decoder.decodeSummary(this.summary),
+	}
+	storeDecodeReport(this.decoder.report)
+	return result
+}
@@ -0,0 +1,181 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+	kubelet_client "github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet/util"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testPushedSummary = `{
+  "node": {
+    "nodeName": "edge",
+    "startTime": "2017-01-01T00:00:00Z",
+    "cpu": {"time": "2017-01-01T00:01:00Z", "usageCoreNanoSeconds": 1000000000},
+    "memory": {"time": "2017-01-01T00:01:00Z", "workingSetBytes": 1048576}
+  },
+  "pods": []
+}`
+
+// newTestPushHandler returns the push handler, with requests authenticated as the user
+// named in their X-Remote-User header.
+func newTestPushHandler(podLister v1listers.PodLister) http.Handler {
+	mapper := genericapirequest.NewRequestContextMapper()
+	handler := NewPushHandler(mapper, podLister)
+	return genericapirequest.WithRequestContext(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if name := req.Header.Get("X-Remote-User"); name != "" {
+			ctx, _ := mapper.Get(req)
+			mapper.Update(req, genericapirequest.WithUser(ctx, &user.DefaultInfo{Name: name}))
+		}
+		handler.ServeHTTP(w, req)
+	}), mapper)
+}
+
+func TestPushHandler(t *testing.T) {
+	SetPushMaxAge(time.Minute)
+	defer SetPushMaxAge(0)
+	defer retainPushedSummaries(nil)
+	handler := newTestPushHandler(nil)
+
+	push := func(method, user, body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, PushPath, strings.NewReader(body))
+		req.Header.Set("X-Remote-User", user)
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusMethodNotAllowed, push(http.MethodGet, "system:node:edge", ""))
+	assert.Equal(t, http.StatusBadRequest, push(http.MethodPost, "system:node:edge", "{"))
+	assert.Equal(t, http.StatusBadRequest, push(http.MethodPost, "system:node:edge", `{"node": {}}`), "no node name")
+	assert.Equal(t, http.StatusUnauthorized, push(http.MethodPost, "", testPushedSummary))
+	assert.Equal(t, http.StatusForbidden, push(http.MethodPost, "system:node:other", testPushedSummary), "summary of another node")
+	assert.Equal(t, http.StatusForbidden, push(http.MethodPost, "admin", testPushedSummary))
+	assert.Nil(t, getPushedSummary("edge", time.Now()))
+
+	assert.Equal(t, http.StatusAccepted, push(http.MethodPost, "system:node:edge", testPushedSummary))
+	pushed := getPushedSummary("edge", time.Now())
+	require.NotNil(t, pushed)
+	assert.Equal(t, uint64(1048576), *pushed.Node.Memory.WorkingSetBytes)
+	assert.Nil(t, getPushedSummary("edge", time.Now().Add(2*time.Minute)), "stale")
+
+	SetPushMaxAge(0)
+	assert.Nil(t, getPushedSummary("edge", time.Now()), "disabled")
+}
+
+func TestPushHandlerScheduledPods(t *testing.T) {
+	SetPushMaxAge(time.Minute)
+	defer SetPushMaxAge(0)
+	defer retainPushedSummaries(nil)
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, pods.Add(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "local", UID: "uid1"}, Spec: corev1.PodSpec{NodeName: "edge"}}))
+	require.NoError(t, pods.Add(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "remote", UID: "uid2"}, Spec: corev1.PodSpec{NodeName: "node1"}}))
+	require.NoError(t, pods.Add(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "recreated", UID: "uid4"}, Spec: corev1.PodSpec{NodeName: "edge"}}))
+	handler := newTestPushHandler(v1listers.NewPodLister(pods))
+
+	body := strings.Replace(testPushedSummary, `"pods": []`, `"pods": [
+    {"podRef": {"namespace": "ns1", "name": "local", "uid": "uid1"}},
+    {"podRef": {"namespace": "ns1", "name": "remote", "uid": "uid2"}},
+    {"podRef": {"namespace": "ns1", "name": "unknown", "uid": "uid3"}},
+    {"podRef": {"namespace": "ns1", "name": "recreated", "uid": "uid3"}}
+  ]`, 1)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, PushPath, strings.NewReader(body))
+	req.Header.Set("X-Remote-User", "system:node:edge")
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	pushed := getPushedSummary("edge", time.Now())
+	require.NotNil(t, pushed)
+	require.Len(t, pushed.Pods, 1, "only pods the informer places on the node are kept")
+	assert.Equal(t, "local", pushed.Pods[0].PodRef.Name)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, PushPath, strings.NewReader(body))
+	req.Header.Set("X-Remote-User", "system:node:edge")
+	newTestPushHandler(nil).ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, getPushedSummary("edge", time.Now()).Pods, "pods can't be checked without a pod lister")
+}
+
+func TestPushedMetricsSource(t *testing.T) {
+	SetPushMaxAge(time.Minute)
+	defer SetPushMaxAge(0)
+	defer retainPushedSummaries(nil)
+	summary, err := decodePushedSummary(strings.NewReader(testPushedSummary))
+	require.NoError(t, err)
+	storePushedSummary(summary, time.Now())
+
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	// The node can't be scraped, it has no address.
+	edge := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge"},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	}
+	require.NoError(t, nodes.Add(edge))
+	kubeletClient, err := kubelet.NewKubeletClient(&kubelet_client.KubeletClientConfig{Port: 10250})
+	require.NoError(t, err)
+	provider := &summaryProvider{
+		nodeLister:      v1listers.NewNodeLister(nodes),
+		kubeletClient:   kubeletClient,
+		addressResolver: &kubelet.PriorityNodeAddressResolver{AddressTypes: kubelet.DefaultAddressTypes, Port: 10250},
+		nodeConditions:  DefaultNodeConditions,
+	}
+
+	sources := provider.GetMetricsSources()
+	require.Len(t, sources, 1)
+	require.IsType(t, &pushedMetricsSource{}, sources[0])
+	assert.True(t, sources[0].(*pushedMetricsSource).IsPrioritized())
+	batch := sources[0].ScrapeMetrics(time.Now(), time.Now())
+	require.Contains(t, batch.MetricSets, NodeKey("edge"))
+	node := batch.MetricSets[NodeKey("edge")]
+	assert.Equal(t, "edge", node.Labels[LabelNodename.Key])
+	assert.Equal(t, int64(1048576), node.MetricValues[MetricMemoryWorkingSet.Name].IntValue)
+
+	isNodeDeleted = func(node string) bool { return node == "edge" }
+	assert.Empty(t, sources[0].ScrapeMetrics(time.Now(), time.Now()).MetricSets, "pushed summaries of deleted nodes are discarded")
+	assert.Empty(t, provider.GetMetricsSources())
+	isNodeDeleted = util.IsNodeDeleted
+
+	edge.Status.Conditions[0].Status = corev1.ConditionFalse
+	require.NoError(t, nodes.Update(edge))
+	assert.Empty(t, provider.GetMetricsSources(), "pushed summaries of nodes which aren't ready aren't used")
+	require.NotNil(t, getPushedSummary("edge", time.Now()))
+
+	edge.Status.Conditions[0].Status = corev1.ConditionTrue
+	edge.Spec.Taints = []corev1.Taint{{Key: toBeDeletedTaint}}
+	require.NoError(t, nodes.Update(edge))
+	assert.Empty(t, provider.GetMetricsSources(), "nor of nodes going away")
+
+	require.NoError(t, nodes.Delete(edge))
+	assert.Empty(t, provider.GetMetricsSources())
+	assert.Nil(t, getPushedSummary("edge", time.Now()), "dropped with the node")
+}
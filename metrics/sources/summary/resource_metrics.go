@@ -0,0 +1,216 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+// Metrics of the kubelet resource metrics endpoint.
+const (
+	resourceNodeCPUUsage           = "node_cpu_usage_seconds_total"
+	resourceNodeMemoryWorkingSet   = "node_memory_working_set_bytes"
+	resourceContainerCPUUsage      = "container_cpu_usage_seconds_total"
+	resourceContainerMemoryWorking = "container_memory_working_set_bytes"
+	resourceContainerStartTime     = "container_start_time_seconds"
+	resourceScrapeError            = "scrape_error"
+)
+
+// WithKubeletMetricsEndpoint returns a copy of the URI setting the kubeletMetricsEndpoint
+// option to the endpoint, unless it's empty.
+func WithKubeletMetricsEndpoint(uri *url.URL, endpoint string) *url.URL {
+	if endpoint == "" {
+		return uri
+	}
+	result := *uri
+	opts := result.Query()
+	opts.Set("kubeletMetricsEndpoint", endpoint)
+	result.RawQuery = opts.Encode()
+	return &result
+}
+
+// parseResourceEndpoint returns whether the options of the URI select the resource
+// metrics endpoint of the kubelets, rather than their Summary API.
+func parseResourceEndpoint(opts url.Values) (bool, error) {
+	if len(opts["kubeletMetricsEndpoint"]) == 0 {
+		return false, nil
+	}
+	endpoint := opts.Get("kubeletMetricsEndpoint")
+	if err := util.ValidateKubeletMetricsEndpoint(endpoint); err != nil {
+		return false, err
+	}
+	return endpoint == util.KubeletEndpointResource, nil
+}
+
+type resourceContainerKey struct {
+	namespace string
+	pod       string
+	container string
+}
+
+// decodeResourceMetrics translates the families of the kubelet resource metrics endpoint
+// into a summary, so that they are decoded like the Summary API. The pods have neither
+// UIDs nor pod-level stats, and the node has no start time.
+func decodeResourceMetrics(families map[string]*dto.MetricFamily, nodeName string) (*stats.Summary, error) {
+	if family, found := families[resourceScrapeError]; found {
+		for _, m := range family.Metric {
+			if getResourceValue(m) != 0 {
+				return nil, fmt.Errorf("kubelet failed to collect resource metrics")
+			}
+		}
+	}
+
+	summary := &stats.Summary{Node: stats.NodeStats{NodeName: nodeName}}
+	for _, m := range families[resourceNodeCPUUsage].GetMetric() {
+		summary.Node.CPU = &stats.CPUStats{
+			Time:                 getResourceTime(m),
+			UsageCoreNanoSeconds: secondsToNanoseconds(getResourceValue(m)),
+		}
+	}
+	for _, m := range families[resourceNodeMemoryWorkingSet].GetMetric() {
+		summary.Node.Memory = &stats.MemoryStats{
+			Time:            getResourceTime(m),
+			WorkingSetBytes: toUint64(getResourceValue(m)),
+		}
+	}
+
+	containers := map[resourceContainerKey]*stats.ContainerStats{}
+	getContainer := func(m *dto.Metric) (*stats.ContainerStats, bool) {
+		key := resourceContainerKey{
+			namespace: getResourceLabel(m, "namespace"),
+			pod:       getResourceLabel(m, "pod"),
+			container: getResourceLabel(m, "container"),
+		}
+		if key.namespace == "" || key.pod == "" || key.container == "" {
+			return nil, false
+		}
+		container, found := containers[key]
+		if !found {
+			container = &stats.ContainerStats{Name: key.container}
+			containers[key] = container
+		}
+		return container, true
+	}
+	for _, m := range families[resourceContainerCPUUsage].GetMetric() {
+		if container, ok := getContainer(m); ok {
+			container.CPU = &stats.CPUStats{
+				Time:                 getResourceTime(m),
+				UsageCoreNanoSeconds: secondsToNanoseconds(getResourceValue(m)),
+			}
+		}
+	}
+	for _, m := range families[resourceContainerMemoryWorking].GetMetric() {
+		if container, ok := getContainer(m); ok {
+			container.Memory = &stats.MemoryStats{
+				Time:            getResourceTime(m),
+				WorkingSetBytes: toUint64(getResourceValue(m)),
+			}
+		}
+	}
+	for _, m := range families[resourceContainerStartTime].GetMetric() {
+		if container, ok := getContainer(m); ok {
+			container.StartTime = secondsToTime(getResourceValue(m))
+		}
+	}
+
+	keys := make([]resourceContainerKey, 0, len(containers))
+	for key := range containers {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		if a.pod != b.pod {
+			return a.pod < b.pod
+		}
+		return a.container < b.container
+	})
+	for _, key := range keys {
+		n := len(summary.Pods)
+		if n == 0 || summary.Pods[n-1].PodRef.Namespace != key.namespace || summary.Pods[n-1].PodRef.Name != key.pod {
+			summary.Pods = append(summary.Pods, stats.PodStats{
+				PodRef: stats.PodReference{Namespace: key.namespace, Name: key.pod},
+			})
+			n++
+		}
+		pod := &summary.Pods[n-1]
+		container := containers[key]
+		// The pod started with its first container.
+		if !container.StartTime.IsZero() && (pod.StartTime.IsZero() || container.StartTime.Before(&pod.StartTime)) {
+			pod.StartTime = container.StartTime
+		}
+		pod.Containers = append(pod.Containers, *container)
+	}
+	return summary, nil
+}
+
+func getResourceLabel(m *dto.Metric, name string) string {
+	for _, label := range m.Label {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func getResourceValue(m *dto.Metric) float64 {
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	default:
+		return m.Untyped.GetValue()
+	}
+}
+
+// getResourceTime returns the time the sample was collected at, zero if the sample has
+// no timestamp.
+func getResourceTime(m *dto.Metric) metav1.Time {
+	if m.TimestampMs == nil {
+		return metav1.Time{}
+	}
+	return metav1.NewTime(time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond)))
+}
+
+func secondsToNanoseconds(seconds float64) *uint64 {
+	return toUint64(seconds * float64(time.Second))
+}
+
+func secondsToTime(seconds float64) metav1.Time {
+	if seconds <= 0 {
+		return metav1.Time{}
+	}
+	return metav1.NewTime(time.Unix(0, int64(seconds*float64(time.Second))))
+}
+
+func toUint64(value float64) *uint64 {
+	if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	result := uint64(value)
+	return &result
+}
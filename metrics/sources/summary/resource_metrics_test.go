@@ -0,0 +1,145 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+const testResourceMetrics = `# HELP container_cpu_usage_seconds_total [ALPHA] Cumulative cpu time consumed by the container in core-seconds
+# TYPE container_cpu_usage_seconds_total counter
+container_cpu_usage_seconds_total{container="app",namespace="ns1",pod="pod1"} 2.5 1633253812125
+container_cpu_usage_seconds_total{container="sidecar",namespace="ns1",pod="pod1"} 0.5 1633253812125
+container_cpu_usage_seconds_total{container="app",namespace="ns2",pod="pod2"} 1 1633253812125
+# HELP container_memory_working_set_bytes [ALPHA] Current working set of the container in bytes
+# TYPE container_memory_working_set_bytes gauge
+container_memory_working_set_bytes{container="app",namespace="ns1",pod="pod1"} 1.048576e+06 1633253812125
+container_memory_working_set_bytes{container="sidecar",namespace="ns1",pod="pod1"} 2048 1633253812125
+container_memory_working_set_bytes{container="app",namespace="ns2",pod="pod2"} 4096 1633253812125
+# HELP container_start_time_seconds [ALPHA] Start time of the container since unix epoch in seconds
+# TYPE container_start_time_seconds gauge
+container_start_time_seconds{container="app",namespace="ns1",pod="pod1"} 1.633253e+09 1633253812125
+container_start_time_seconds{container="sidecar",namespace="ns1",pod="pod1"} 1.633252e+09 1633253812125
+# HELP node_cpu_usage_seconds_total [ALPHA] Cumulative cpu time consumed by the node in core-seconds
+# TYPE node_cpu_usage_seconds_total counter
+node_cpu_usage_seconds_total 357.35 1633253812125
+# HELP node_memory_working_set_bytes [ALPHA] Current working set of the node in bytes
+# TYPE node_memory_working_set_bytes gauge
+node_memory_working_set_bytes 1.4e+09 1633253812125
+# HELP scrape_error [ALPHA] 1 if there was an error while getting container metrics, 0 otherwise
+# TYPE scrape_error gauge
+scrape_error 0
+`
+
+func decodeTestResourceMetrics(t *testing.T, text string) (*stats.Summary, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	require.NoError(t, err)
+	return decodeResourceMetrics(families, "node1")
+}
+
+func TestDecodeResourceMetrics(t *testing.T) {
+	summary, err := decodeTestResourceMetrics(t, testResourceMetrics)
+	require.NoError(t, err)
+	sampleTime := time.Unix(1633253812, 125000000)
+
+	assert.Equal(t, "node1", summary.Node.NodeName)
+	require.NotNil(t, summary.Node.CPU)
+	assert.Equal(t, uint64(357350000000), *summary.Node.CPU.UsageCoreNanoSeconds)
+	assert.True(t, sampleTime.Equal(summary.Node.CPU.Time.Time))
+	require.NotNil(t, summary.Node.Memory)
+	assert.Equal(t, uint64(1400000000), *summary.Node.Memory.WorkingSetBytes)
+
+	require.Len(t, summary.Pods, 2)
+	pod := summary.Pods[0]
+	assert.Equal(t, stats.PodReference{Namespace: "ns1", Name: "pod1"}, pod.PodRef)
+	assert.True(t, time.Unix(1633252000, 0).Equal(pod.StartTime.Time), "start of the first container")
+	require.Len(t, pod.Containers, 2)
+	assert.Equal(t, "app", pod.Containers[0].Name)
+	assert.Equal(t, uint64(2500000000), *pod.Containers[0].CPU.UsageCoreNanoSeconds)
+	assert.Equal(t, uint64(1048576), *pod.Containers[0].Memory.WorkingSetBytes)
+	assert.True(t, time.Unix(1633253000, 0).Equal(pod.Containers[0].StartTime.Time))
+	assert.Equal(t, "sidecar", pod.Containers[1].Name)
+
+	pod = summary.Pods[1]
+	assert.Equal(t, "pod2", pod.PodRef.Name)
+	require.Len(t, pod.Containers, 1)
+	assert.True(t, pod.Containers[0].StartTime.IsZero())
+	assert.True(t, pod.StartTime.IsZero())
+
+	_, err = decodeTestResourceMetrics(t, strings.Replace(testResourceMetrics, "scrape_error 0", "scrape_error 1", 1))
+	assert.Error(t, err)
+}
+
+func TestScrapeResourceMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/metrics/resource" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(testResourceMetrics))
+	}))
+	defer server.Close()
+
+	ms := testingSummaryMetricsSource()
+	ms.resourceEndpoint = true
+	split := strings.SplitN(strings.Replace(server.URL, "http://", "", 1), ":", 2)
+	ms.node.IP = split[0]
+	port, err := strconv.Atoi(split[1])
+	require.NoError(t, err)
+	ms.node.Port = port
+
+	res := ms.ScrapeMetrics(time.Now(), time.Now())
+	node := res.MetricSets[core.NodeKey(ms.node.NodeName)]
+	require.NotNil(t, node)
+	assert.Equal(t, int64(357350000000), node.MetricValues[core.MetricCpuUsage.Name].IntValue)
+	container := res.MetricSets[core.PodContainerKey("ns1", "pod1", "app")]
+	require.NotNil(t, container)
+	assert.Equal(t, int64(1048576), container.MetricValues[core.MetricMemoryWorkingSet.Name].IntValue)
+	assert.NotNil(t, res.MetricSets[core.PodKey("ns2", "pod2")])
+	assert.Equal(t, []string{"ns2/pod2/app"}, ms.report.Examples[IssueMissingStartTime], "the node start time isn't expected")
+}
+
+func TestKubeletMetricsEndpointOption(t *testing.T) {
+	uri, err := url.Parse("https://kubernetes.default")
+	require.NoError(t, err)
+	resourceEndpoint, err := parseResourceEndpoint(uri.Query())
+	require.NoError(t, err)
+	assert.False(t, resourceEndpoint, "the Summary API by default")
+
+	resourceEndpoint, err = parseResourceEndpoint(WithKubeletMetricsEndpoint(uri, util.KubeletEndpointResource).Query())
+	require.NoError(t, err)
+	assert.True(t, resourceEndpoint)
+	resourceEndpoint, err = parseResourceEndpoint(WithKubeletMetricsEndpoint(uri, util.KubeletEndpointSummary).Query())
+	require.NoError(t, err)
+	assert.False(t, resourceEndpoint)
+	_, err = parseResourceEndpoint(WithKubeletMetricsEndpoint(uri, "cadvisor").Query())
+	assert.Error(t, err)
+	assert.Equal(t, uri, WithKubeletMetricsEndpoint(uri, ""))
+}
@@ -0,0 +1,301 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+)
+
+// Path under which the scrape failures of the latest cycle are served.
+const ScrapeFailuresPath = "/debug/scrape-failures"
+
+// Causes scrape failures are classified by.
+const (
+	CauseNodeNotReady      = "node_not_ready"
+	CauseAddressResolution = "address_resolution"
+	CauseNodePoolFull      = "node_pool_full"
+	CauseBackoff           = "backoff"
+	CauseTimeout           = "timeout"
+	CauseConnectionRefused = "connection_refused"
+	CauseDNS               = "dns"
+	CauseTLS               = "tls"
+	CauseUnauthorized      = "unauthorized"
+	CauseNotFound          = "not_found"
+	CauseDecode            = "decode"
+	CauseOther             = "other"
+)
+
+// Number of nodes listed per cause.
+const maxFailureExamples = 3
+
+var scrapeFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "kubelet_summary",
+		Name:      "scrape_failures_total",
+		Help:      "Number of nodes which could not be scraped, by cause.",
+	},
+	[]string{"cause"},
+)
+
+func init() {
+	prometheus.MustRegister(scrapeFailures)
+}
+
+// ClassifyScrapeError returns the cause of the failed kubelet request.
+func ClassifyScrapeError(err error) string {
+	switch err := unwrapScrapeError(err).(type) {
+	case *kubelet.ErrNotFound:
+		return CauseNotFound
+	case *kubelet.ErrStatus:
+		if err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden {
+			return CauseUnauthorized
+		}
+	case *kubelet.ErrDecode:
+		return CauseDecode
+	case *net.DNSError:
+		if err.Timeout() {
+			return CauseTimeout
+		}
+		return CauseDNS
+	case x509.UnknownAuthorityError, x509.HostnameError, x509.CertificateInvalidError, x509.SystemRootsError,
+		tls.RecordHeaderError:
+		return CauseTLS
+	case *net.OpError:
+		if err.Op == "remote error" {
+			// Alerts sent by the kubelet during the handshake.
+			return CauseTLS
+		}
+		if err.Timeout() {
+			return CauseTimeout
+		}
+	case syscall.Errno:
+		if err == syscall.ECONNREFUSED {
+			return CauseConnectionRefused
+		}
+		if err.Timeout() {
+			return CauseTimeout
+		}
+	}
+	if err == context.DeadlineExceeded {
+		return CauseTimeout
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return CauseTimeout
+	}
+	return CauseOther
+}
+
+// unwrapScrapeError returns the innermost error of the url, net and syscall errors the HTTP
+// client wraps the errors of requests into, unless one of them is a timeout.
+func unwrapScrapeError(err error) error {
+	for {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return err
+		}
+		switch wrapper := err.(type) {
+		case *url.Error:
+			err = wrapper.Err
+		case *net.OpError:
+			if wrapper.Op == "remote error" {
+				return err
+			}
+			err = wrapper.Err
+		case *os.SyscallError:
+			err = wrapper.Err
+		default:
+			return err
+		}
+	}
+}
+
+// ScrapeFailureCause lists the nodes which failed for one cause.
+type ScrapeFailureCause struct {
+	Cause string `json:"cause"`
+	Nodes int    `json:"nodes"`
+	// Some of the failed nodes.
+	Examples []string `json:"examples"`
+}
+
+// ScrapeFailures are the failures of a scrape cycle, most frequent cause first.
+type ScrapeFailures struct {
+	Timestamp time.Time            `json:"timestamp"`
+	Causes    []ScrapeFailureCause `json:"causes"`
+}
+
+// Message summarizes the failures in one line, empty if there were none.
+func (this *ScrapeFailures) Message() string {
+	if len(this.Causes) == 0 {
+		return ""
+	}
+	nodes := 0
+	parts := make([]string, 0, len(this.Causes))
+	for _, cause := range this.Causes {
+		nodes += cause.Nodes
+		examples := strings.Join(cause.Examples, ", ")
+		if cause.Nodes > len(cause.Examples) {
+			examples += ", ..."
+		}
+		parts = append(parts, fmt.Sprintf("%d %s (%s)", cause.Nodes, cause.Cause, examples))
+	}
+	return fmt.Sprintf("%d nodes not scraped: %s", nodes, strings.Join(parts, "; "))
+}
+
+// ScrapeFailureReporter is implemented by the summary source provider, which classifies the
+// failed scrapes of every cycle.
+type ScrapeFailureReporter interface {
+	GetScrapeFailures() ScrapeFailures
+}
+
+// scrapeFailureTracker keeps the failures of the scrape cycles of a provider: those of the
+// cycle in progress, and the summary of the latest completed one.
+type scrapeFailureTracker struct {
+	lock    sync.Mutex
+	current *scrapeCycle
+	latest  ScrapeFailures
+}
+
+// scrapeCycle collects the causes the nodes of a scrape cycle failed for. Failures of
+// scrapes which outlast the cycle are only counted.
+type scrapeCycle struct {
+	lock      sync.Mutex
+	completed bool
+	nodes     map[string]string
+}
+
+func newScrapeFailureTracker() *scrapeFailureTracker {
+	return &scrapeFailureTracker{}
+}
+
+// start begins a new cycle. A cycle still in progress, whose completion wasn't reported,
+// is completed first. Without a tracker failures are only counted.
+func (this *scrapeFailureTracker) start(now time.Time) *scrapeCycle {
+	if this == nil {
+		return nil
+	}
+	this.lock.Lock()
+	current := this.current
+	this.lock.Unlock()
+	if current != nil {
+		this.complete(current, now)
+	}
+	cycle := &scrapeCycle{nodes: map[string]string{}}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.current = cycle
+	return cycle
+}
+
+// complete makes the failures of the cycle the latest ones, unless it was completed already.
+func (this *scrapeFailureTracker) complete(cycle *scrapeCycle, now time.Time) {
+	cycle.lock.Lock()
+	if cycle.completed {
+		cycle.lock.Unlock()
+		return
+	}
+	cycle.completed = true
+	latest := summarizeFailures(cycle.nodes, now)
+	cycle.lock.Unlock()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.latest = latest
+}
+
+// completeCurrent completes the cycle in progress.
+func (this *scrapeFailureTracker) completeCurrent(now time.Time) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	current := this.current
+	this.lock.Unlock()
+	if current != nil {
+		this.complete(current, now)
+	}
+}
+
+func (this *scrapeFailureTracker) get() ScrapeFailures {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.latest
+}
+
+// record records the cause the node failed for in the cycle. The cycle may be nil, for
+// sources created outside of a provider.
+func (this *scrapeCycle) record(node, cause string) {
+	scrapeFailures.WithLabelValues(cause).Inc()
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if !this.completed {
+		this.nodes[node] = cause
+	}
+}
+
+func summarizeFailures(nodeCauses map[string]string, now time.Time) ScrapeFailures {
+	byCause := map[string][]string{}
+	for node, cause := range nodeCauses {
+		byCause[cause] = append(byCause[cause], node)
+	}
+	result := ScrapeFailures{Timestamp: now, Causes: make([]ScrapeFailureCause, 0, len(byCause))}
+	for cause, nodes := range byCause {
+		sort.Strings(nodes)
+		examples := nodes
+		if len(examples) > maxFailureExamples {
+			examples = examples[:maxFailureExamples]
+		}
+		result.Causes = append(result.Causes, ScrapeFailureCause{Cause: cause, Nodes: len(nodes), Examples: examples})
+	}
+	sort.Slice(result.Causes, func(i, j int) bool {
+		a, b := result.Causes[i], result.Causes[j]
+		if a.Nodes != b.Nodes {
+			return a.Nodes > b.Nodes
+		}
+		return a.Cause < b.Cause
+	})
+	return result
+}
+
+// NewScrapeFailuresHandler returns a handler serving the scrape failures of the latest
+// cycle, returned by getFailures, as JSON.
+func NewScrapeFailuresHandler(getFailures func() ScrapeFailures) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(getFailures()); err != nil {
+			glog.Errorf("Error while writing scrape failures: %v", err)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+)
+
+// timeoutError is a net.Error like those of the client's timeouts.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "net/http: request canceled (Client.Timeout exceeded)" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyScrapeError(t *testing.T) {
+	requestError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://10.0.0.1:10250/stats/summary/", Err: err}
+	}
+	dialError := func(err error) error {
+		return requestError(&net.OpError{Op: "dial", Net: "tcp", Err: err})
+	}
+	for name, tc := range map[string]struct {
+		err   error
+		cause string
+	}{
+		"dial timeout":       {dialError(timeoutError{}), CauseTimeout},
+		"client timeout":     {requestError(timeoutError{}), CauseTimeout},
+		"deadline":           {requestError(context.DeadlineExceeded), CauseTimeout},
+		"connection refused": {dialError(&os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}), CauseConnectionRefused},
+		"no such host":       {dialError(&net.DNSError{Err: "no such host", Name: "node1"}), CauseDNS},
+		"dns timeout":        {dialError(&net.DNSError{Err: "i/o timeout", Name: "node1", IsTimeout: true}), CauseTimeout},
+		"unknown authority":  {requestError(x509.UnknownAuthorityError{}), CauseTLS},
+		"hostname mismatch":  {requestError(x509.HostnameError{Host: "10.0.0.1", Certificate: &x509.Certificate{}}), CauseTLS},
+		"tls alert":          {requestError(&net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}), CauseTLS},
+		"unauthorized":       {&kubelet.ErrStatus{StatusCode: http.StatusUnauthorized}, CauseUnauthorized},
+		"forbidden":          {&kubelet.ErrStatus{StatusCode: http.StatusForbidden}, CauseUnauthorized},
+		"server error":       {&kubelet.ErrStatus{StatusCode: http.StatusInternalServerError}, CauseOther},
+		"not found":          {&kubelet.ErrNotFound{}, CauseNotFound},
+		"decode":             {&kubelet.ErrDecode{}, CauseDecode},
+		// Messages don't matter, only the types of the errors.
+		"message only": {errors.New("dial tcp 10.0.0.1:10250: i/o timeout"), CauseOther},
+	} {
+		assert.Equal(t, tc.cause, ClassifyScrapeError(tc.err), name)
+	}
+}
+
+func TestScrapeFailures(t *testing.T) {
+	now := time.Now()
+	tracker := newScrapeFailureTracker()
+	cycle := tracker.start(now)
+	cycle.record("n3", CauseTLS)
+	cycle.record("n1", CauseTimeout)
+	cycle.record("n2", CauseTimeout)
+	cycle.record("n4", CauseTimeout)
+	cycle.record("n5", CauseTimeout)
+	assert.Empty(t, tracker.get().Causes, "the cycle is still in progress")
+	tracker.completeCurrent(now)
+
+	failures := tracker.get()
+	require.Len(t, failures.Causes, 2)
+	assert.Equal(t, ScrapeFailureCause{Cause: CauseTimeout, Nodes: 4, Examples: []string{"n1", "n2", "n4"}}, failures.Causes[0])
+	assert.Equal(t, "5 nodes not scraped: 4 timeout (n1, n2, n4, ...); 1 tls (n3)", failures.Message())
+
+	rec := httptest.NewRecorder()
+	NewScrapeFailuresHandler(tracker.get).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ScrapeFailuresPath, nil))
+	served := ScrapeFailures{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Len(t, served.Causes, 2)
+
+	tracker.start(now.Add(time.Minute))
+	tracker.completeCurrent(now.Add(time.Minute))
+	failures = tracker.get()
+	assert.Empty(t, failures.Causes)
+	assert.Equal(t, "", failures.Message())
+}
+
+func TestScrapeFailuresOfLateScrapes(t *testing.T) {
+	now := time.Now()
+	tracker := newScrapeFailureTracker()
+	cycle := tracker.start(now)
+	cycle.record("n1", CauseTimeout)
+	tracker.completeCurrent(now)
+
+	// A scrape of the completed cycle which failed after the manager stopped waiting.
+	cycle.record("n2", CauseTimeout)
+	assert.Equal(t, 1, tracker.get().Causes[0].Nodes)
+
+	// A cycle whose completion wasn't reported is completed by the next one.
+	next := tracker.start(now.Add(time.Minute))
+	next.record("n3", CauseDNS)
+	tracker.start(now.Add(2 * time.Minute))
+	failures := tracker.get()
+	require.Len(t, failures.Causes, 1)
+	assert.Equal(t, ScrapeFailureCause{Cause: CauseDNS, Nodes: 1, Examples: []string{"n3"}}, failures.Causes[0])
+	assert.Equal(t, now.Add(2*time.Minute), failures.Timestamp)
+
+	// Sources created outside of a provider have no cycle.
+	var none *scrapeCycle
+	none.record("n4", CauseOther)
+}
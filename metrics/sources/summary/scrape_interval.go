@@ -0,0 +1,201 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Annotation with the interval at which a node is scraped, e.g. "5m" for an edge node on
+// a slow link. The node is scraped on its own timer and the cycles serve its latest scrape,
+// so intervals shorter than the metric resolution only add requests. Can be overridden with
+// the scrapeIntervalAnnotation source option.
+const DefaultScrapeIntervalAnnotation = "metrics-server.kubernetes.io/scrape-interval"
+
+// getScrapeInterval returns the interval the node asks to be scraped at, or zero if it
+// doesn't carry a valid one.
+func getScrapeInterval(node *corev1.Node, annotation string) time.Duration {
+	if annotation == "" {
+		return 0
+	}
+	value, found := node.Annotations[annotation]
+	if !found {
+		return 0
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		glog.Warningf("Ignoring invalid %s annotation %q of node %s", annotation, value, node.Name)
+		return 0
+	}
+	return interval
+}
+
+// intervalScheduler scrapes the nodes with their own scrape interval on a timer per node,
+// between the scrape cycles, and keeps the metrics of their latest scrape for the cycles to
+// serve. Nodes which were never scraped, whose last scrape failed or whose metrics are older
+// than two intervals are scraped by the cycles instead, which restarts their timer.
+type intervalScheduler struct {
+	lock  sync.Mutex
+	nodes map[string]*intervalNode
+}
+
+type intervalNode struct {
+	interval time.Duration
+	// Scrapes the node on the timer, replaced by the one of every cycle. It stores its
+	// metric sets in the scheduler.
+	source MetricsSource
+	// Set once the node was scraped, stopped when it's forgotten.
+	timer *time.Timer
+	// Unprocessed metric sets of the latest scrape, nil if it failed.
+	metricSets map[string]*MetricSet
+	scraped    time.Time
+}
+
+func newIntervalScheduler() *intervalScheduler {
+	return &intervalScheduler{nodes: map[string]*intervalNode{}}
+}
+
+// cached records the interval and source of the node, and returns copies of the metric sets
+// of its latest scrape with the time it started, or nil if the cycle has to scrape it.
+func (this *intervalScheduler) cached(node string, interval time.Duration, source MetricsSource, now time.Time) (map[string]*MetricSet, time.Time) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry, found := this.nodes[node]
+	if !found {
+		entry = &intervalNode{}
+		this.nodes[node] = entry
+	}
+	entry.source = source
+	if entry.interval != interval {
+		entry.interval = interval
+		if entry.timer != nil && entry.timer.Stop() {
+			this.schedule(node, entry, now)
+		}
+	}
+	if entry.metricSets == nil || now.Sub(entry.scraped) > 2*interval {
+		return nil, time.Time{}
+	}
+	result := make(map[string]*MetricSet, len(entry.metricSets))
+	for key, ms := range entry.metricSets {
+		served := ms.Copy()
+		served.Cached = true
+		result[key] = served
+	}
+	return result, entry.scraped
+}
+
+// store keeps copies of the metric sets of a successful scrape of the node started at the
+// time, as processors modify the scraped ones, and schedules the next scrape an interval
+// after it.
+func (this *intervalScheduler) store(node string, metricSets map[string]*MetricSet, scraped time.Time) {
+	copies := make(map[string]*MetricSet, len(metricSets))
+	for key, ms := range metricSets {
+		copies[key] = ms.Copy()
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry, found := this.nodes[node]
+	if !found || scraped.Before(entry.scraped) {
+		return
+	}
+	entry.metricSets = copies
+	entry.scraped = scraped
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	this.schedule(node, entry, time.Now())
+}
+
+// schedule starts the timer scraping the node an interval after its latest scrape.
+func (this *intervalScheduler) schedule(node string, entry *intervalNode, now time.Time) {
+	entry.timer = time.AfterFunc(entry.scraped.Add(entry.interval).Sub(now), func() {
+		this.scrape(node, entry)
+	})
+}
+
+func (this *intervalScheduler) scrape(node string, entry *intervalNode) {
+	this.lock.Lock()
+	if this.nodes[node] != entry {
+		this.lock.Unlock()
+		return
+	}
+	source := entry.source
+	this.lock.Unlock()
+
+	now := time.Now()
+	glog.V(4).Infof("Scraping node %s, whose scrape interval is up", node)
+	if batch := source.ScrapeMetrics(now, now); batch == nil || len(batch.MetricSets) == 0 {
+		// Left to the cycles until a scrape succeeds again.
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if !entry.scraped.After(now) {
+			entry.metricSets = nil
+		}
+	}
+}
+
+// retain forgets the nodes which no longer exist or are scraped every cycle, and stops
+// their timers.
+func (this *intervalScheduler) retain(nodes map[string]bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for node, entry := range this.nodes {
+		if !nodes[node] {
+			if entry.timer != nil {
+				entry.timer.Stop()
+			}
+			delete(this.nodes, node)
+		}
+	}
+}
+
+// cachedMetricsSource serves the metrics of a node scraped before the cycle. It's
+// prioritized, as there is nothing to delay.
+type cachedMetricsSource struct {
+	node       string
+	metricSets map[string]*MetricSet
+	scraped    time.Time
+}
+
+func (this *cachedMetricsSource) Name() string {
+	return this.String()
+}
+
+func (this *cachedMetricsSource) String() string {
+	return fmt.Sprintf("kubelet_summary_cache:%s", this.node)
+}
+
+func (this *cachedMetricsSource) IsPrioritized() bool {
+	return true
+}
+
+func (this *cachedMetricsSource) ScrapeMetrics(start, end time.Time) *DataBatch {
+	if isNodeDeleted(this.node) {
+		glog.V(2).Infof("Discarding cached summary of node %s, which was deleted", this.node)
+		deletedNodeScrapes.Inc()
+		return &DataBatch{Timestamp: this.scraped, MetricSets: map[string]*MetricSet{}}
+	}
+	return &DataBatch{
+		Timestamp:  this.scraped,
+		MetricSets: this.metricSets,
+	}
+}
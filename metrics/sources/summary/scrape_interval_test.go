@@ -0,0 +1,171 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+	kubelet_client "github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet/util"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetScrapeInterval(t *testing.T) {
+	node := func(value string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "edge",
+			Annotations: map[string]string{DefaultScrapeIntervalAnnotation: value},
+		}}
+	}
+	assert.Equal(t, 5*time.Minute, getScrapeInterval(node("5m"), DefaultScrapeIntervalAnnotation))
+	assert.Equal(t, time.Duration(0), getScrapeInterval(node("5m"), ""), "disabled")
+	assert.Equal(t, time.Duration(0), getScrapeInterval(node("often"), DefaultScrapeIntervalAnnotation))
+	assert.Equal(t, time.Duration(0), getScrapeInterval(node("-1m"), DefaultScrapeIntervalAnnotation))
+	assert.Equal(t, time.Duration(0), getScrapeInterval(&corev1.Node{}, DefaultScrapeIntervalAnnotation))
+}
+
+// Source of a node with its own scrape interval, storing the metric sets of its scrapes in the
+// scheduler, or failing if it has none.
+type intervalTestSource struct {
+	scheduler *intervalScheduler
+	scrapes   chan time.Time
+
+	lock       sync.Mutex
+	metricSets map[string]*MetricSet
+}
+
+func (this *intervalTestSource) setMetricSets(metricSets map[string]*MetricSet) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.metricSets = metricSets
+}
+
+func (this *intervalTestSource) Name() string {
+	return "interval_test"
+}
+
+func (this *intervalTestSource) IsPrioritized() bool {
+	return false
+}
+
+func (this *intervalTestSource) ScrapeMetrics(start, end time.Time) *DataBatch {
+	this.lock.Lock()
+	metricSets := this.metricSets
+	this.lock.Unlock()
+	if metricSets != nil {
+		this.scheduler.store("edge", metricSets, start)
+	}
+	this.scrapes <- start
+	return &DataBatch{Timestamp: start, MetricSets: metricSets}
+}
+
+func TestIntervalScheduler(t *testing.T) {
+	scheduler := newIntervalScheduler()
+	source := &intervalTestSource{scheduler: scheduler, scrapes: make(chan time.Time, 10)}
+	interval := 50 * time.Millisecond
+	now := time.Now()
+	cached, _ := scheduler.cached("edge", interval, source, now)
+	assert.Nil(t, cached, "never scraped")
+
+	scraped := map[string]*MetricSet{NodeKey("edge"): {ScrapeTime: now, MetricValues: map[string]MetricValue{}}}
+	scheduler.store("edge", scraped, now)
+	scraped[NodeKey("edge")].MetricValues["modified"] = MetricValue{}
+	cached, timestamp := scheduler.cached("edge", interval, source, now.Add(10*time.Millisecond))
+	require.Len(t, cached, 1)
+	assert.Equal(t, now, timestamp)
+	ms := cached[NodeKey("edge")]
+	assert.Empty(t, ms.MetricValues, "processors don't modify the stored metric sets")
+	assert.True(t, ms.Cached)
+	assert.Equal(t, now, ms.ScrapeTime, "served with the time it was scraped at")
+
+	// The timer scrapes the node an interval after its last scrape, and every interval after.
+	source.setMetricSets(map[string]*MetricSet{NodeKey("edge"): {}})
+	first := <-source.scrapes
+	second := <-source.scrapes
+	assert.True(t, !first.Before(now.Add(interval)), "scraped at %s, %s after the last scrape", first, first.Sub(now))
+	assert.True(t, second.Sub(first) >= interval, "scraped again after %s", second.Sub(first))
+
+	// After a failure the cycles scrape the node.
+	source.setMetricSets(nil)
+	failed := <-source.scrapes
+	for i := 0; ; i++ {
+		if cached, _ := scheduler.cached("edge", interval, source, failed); cached == nil {
+			break
+		}
+		require.True(t, i < 1000, "metrics of the failed node are still served")
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-source.scrapes:
+		t.Fatal("the timer stops after a failure")
+	case <-time.After(3 * interval):
+	}
+
+	scheduler.retain(map[string]bool{})
+	assert.Empty(t, scheduler.nodes)
+}
+
+func TestScrapeIntervalAnnotation(t *testing.T) {
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, nodes.Add(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "edge",
+			Annotations: map[string]string{DefaultScrapeIntervalAnnotation: "1h"},
+		},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}}},
+	}))
+	kubeletClient, err := kubelet.NewKubeletClient(&kubelet_client.KubeletClientConfig{Port: 10250})
+	require.NoError(t, err)
+	provider := &summaryProvider{
+		nodeLister:               v1listers.NewNodeLister(nodes),
+		kubeletClient:            kubeletClient,
+		addressResolver:          &kubelet.PriorityNodeAddressResolver{AddressTypes: kubelet.DefaultAddressTypes, Port: 10250},
+		scrapeIntervalAnnotation: DefaultScrapeIntervalAnnotation,
+		intervals:                newIntervalScheduler(),
+	}
+
+	sources := provider.GetMetricsSources()
+	require.Len(t, sources, 1)
+	source, ok := sources[0].(*summaryMetricsSource)
+	require.True(t, ok, "due node is scraped")
+	assert.Equal(t, provider.intervals, source.intervals)
+	scraped := time.Now().Add(-time.Minute)
+	provider.intervals.store("edge", map[string]*MetricSet{NodeKey("edge"): {ScrapeTime: scraped}}, scraped)
+	defer provider.intervals.retain(map[string]bool{})
+
+	sources = provider.GetMetricsSources()
+	require.Len(t, sources, 1)
+	require.IsType(t, &cachedMetricsSource{}, sources[0])
+	batch := sources[0].ScrapeMetrics(time.Now(), time.Now())
+	assert.Equal(t, scraped, batch.Timestamp)
+	require.Contains(t, batch.MetricSets, NodeKey("edge"))
+	assert.Equal(t, scraped, batch.MetricSets[NodeKey("edge")].ScrapeTime)
+	assert.True(t, batch.MetricSets[NodeKey("edge")].Cached)
+
+	isNodeDeleted = func(node string) bool { return node == "edge" }
+	defer func() { isNodeDeleted = util.IsNodeDeleted }()
+	batch = sources[0].ScrapeMetrics(time.Now(), time.Now())
+	assert.Empty(t, batch.MetricSets, "cached metrics of deleted nodes are discarded")
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// Path under which the scrape targets of the nodes are served.
+const ScrapeTargetsPath = "/debug/scrape-targets"
+
+// ScrapeTarget describes how the kubelet of a node is scraped, as resolved in the latest
+// scrape cycle.
+type ScrapeTarget struct {
+	Node string `json:"node"`
+	URL  string `json:"url,omitempty"`
+	// Type of the node address the kubelet is reached at, e.g. InternalIP.
+	AddressType string `json:"addressType,omitempty"`
+	// How the serving certificate is verified, e.g. verify-address.
+	TLSMode string `json:"tlsMode,omitempty"`
+	// How the client authenticates, e.g. bearer-token.
+	AuthMode string `json:"authMode,omitempty"`
+	// Why the node is not scraped, empty if it is.
+	Skipped string `json:"skipped,omitempty"`
+	// Error resolving the target.
+	Error string `json:"error,omitempty"`
+}
+
+// Scrape targets of the latest scrape cycle, shared by all summary sources.
+var scrapeTargets = struct {
+	sync.Mutex
+	targets []ScrapeTarget
+}{}
+
+func storeScrapeTargets(targets []ScrapeTarget) {
+	if !keepDebugState {
+		return
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Node < targets[j].Node })
+	scrapeTargets.Lock()
+	defer scrapeTargets.Unlock()
+	scrapeTargets.targets = targets
+}
+
+// GetScrapeTargets returns the scrape targets of the nodes, sorted by node.
+func GetScrapeTargets() []ScrapeTarget {
+	scrapeTargets.Lock()
+	defer scrapeTargets.Unlock()
+	return append([]ScrapeTarget{}, scrapeTargets.targets...)
+}
+
+// NewScrapeTargetsHandler returns a handler serving the scrape targets of the nodes as JSON.
+func NewScrapeTargetsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(GetScrapeTargets()); err != nil {
+			glog.Errorf("Error while writing scrape targets: %v", err)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nodebug
+// +build !nodebug
+
+package summary
+
+import (
+	"testing"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+	kubelet_client "github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet/util"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestScrapeTargets(t *testing.T) {
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, nodes.Add(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "ready"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "ready.example.com"},
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+		}},
+	}))
+	require.NoError(t, nodes.Add(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "no-address"},
+	}))
+	require.NoError(t, nodes.Add(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "cordoned"},
+		Spec:       corev1.NodeSpec{Unschedulable: true},
+	}))
+	require.NoError(t, nodes.Add(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "skipped", Annotations: map[string]string{util.SkipNodeAnnotation: "true"}},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.2"},
+		}},
+	}))
+
+	kubeletClient, err := kubelet.NewKubeletClient(&kubelet_client.KubeletClientConfig{
+		Port:                10250,
+		EnableHttps:         true,
+		BearerToken:         "token",
+		VerifyNodeAddresses: true,
+	})
+	require.NoError(t, err)
+	provider := &summaryProvider{
+		nodeLister:      v1listers.NewNodeLister(nodes),
+		kubeletClient:   kubeletClient,
+		addressResolver: &kubelet.PriorityNodeAddressResolver{AddressTypes: kubelet.DefaultAddressTypes, Port: 10250},
+	}
+
+	provider.GetMetricsSources()
+	targets := GetScrapeTargets()
+	require.Len(t, targets, 4)
+	assert.Equal(t, ScrapeTarget{Node: "cordoned", Skipped: "node is unschedulable"}, targets[0])
+	assert.Equal(t, "no-address", targets[1].Node)
+	assert.NotEmpty(t, targets[1].Error)
+	assert.Equal(t, ScrapeTarget{
+		Node:        "ready",
+		URL:         "https://10.0.0.1:10250/stats/summary/",
+		AddressType: "InternalIP",
+		TLSMode:     kubelet.TLSModeVerifyHostnameOrAddress,
+		AuthMode:    kubelet.AuthModeBearerToken,
+	}, targets[2])
+	assert.Equal(t, ScrapeTarget{Node: "skipped", Skipped: "skipped with the metrics-server.kubernetes.io/skip annotation"}, targets[3])
+}
@@ -0,0 +1,244 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"math/rand"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+)
+
+// Share of the streaming interval the requests of a round are spread over, leaving the rest
+// for the last requests to complete before the next round.
+const streamingSpreadShare = 0.8
+
+// WithStreaming returns a copy of the URI setting the streamingInterval and
+// streamingConcurrency options to the interval and concurrency, unless they're zero or the
+// URI already sets the option.
+func WithStreaming(uri *url.URL, interval time.Duration, concurrency int) *url.URL {
+	result := *uri
+	opts := result.Query()
+	if interval > 0 && len(opts["streamingInterval"]) == 0 {
+		opts.Set("streamingInterval", interval.String())
+	}
+	if concurrency > 0 && len(opts["streamingConcurrency"]) == 0 {
+		opts.Set("streamingConcurrency", strconv.Itoa(concurrency))
+	}
+	result.RawQuery = opts.Encode()
+	return &result
+}
+
+var (
+	summaryStreams = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet_summary",
+			Name:      "streams",
+			Help:      "Number of nodes whose summaries are streamed between the scrapes.",
+		},
+	)
+
+	summaryStreamRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet_summary",
+			Name:      "stream_requests_total",
+			Help:      "Number of summary requests of the streams, by result: modified, not_modified if the kubelet answered the conditional request with the previous summary, error, or skipped if the node pool stayed busy.",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(summaryStreams)
+	prometheus.MustRegister(summaryStreamRequests)
+}
+
+// summaryStreamer requests the summaries of the scraped nodes every interval, each
+// conditionally on the previous response. The requests of a round are spread over the
+// interval in a random order, sent by at most concurrency workers, and take a slot of the
+// node's pool like the scrapes. Summaries older than two intervals, e.g. while a kubelet
+// fails, are not served, and the node is scraped directly instead.
+type summaryStreamer struct {
+	interval time.Duration
+	// Most requests in flight, unlimited if not positive.
+	concurrency int
+	fetch       func(host kubelet.Host, previous *kubelet.SummaryResponse) (*kubelet.SummaryResponse, error)
+	// Only used by the rounds.
+	random *rand.Rand
+
+	lock    sync.Mutex
+	streams map[string]*summaryStream
+}
+
+type summaryStream struct {
+	host kubelet.Host
+	// Pool of the node, nil if the pools are disabled.
+	pool *scrapePool
+
+	lock     sync.Mutex
+	latest   *kubelet.SummaryResponse
+	received time.Time
+}
+
+func newSummaryStreamer(interval time.Duration, concurrency int, fetch func(kubelet.Host, *kubelet.SummaryResponse) (*kubelet.SummaryResponse, error)) *summaryStreamer {
+	return &summaryStreamer{
+		interval:    interval,
+		concurrency: concurrency,
+		fetch:       fetch,
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		streams:     map[string]*summaryStream{},
+	}
+}
+
+// run requests the summaries every interval until stopped.
+func (this *summaryStreamer) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			this.round(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+type scheduledPoll struct {
+	node   string
+	stream *summaryStream
+	due    time.Time
+}
+
+// round requests the summaries of all streamed nodes once, spread over the interval from
+// the start, and returns when all requests completed.
+func (this *summaryStreamer) round(start time.Time) {
+	this.lock.Lock()
+	polls := make([]scheduledPoll, 0, len(this.streams))
+	for node, stream := range this.streams {
+		polls = append(polls, scheduledPoll{node: node, stream: stream})
+	}
+	this.lock.Unlock()
+	if len(polls) == 0 {
+		return
+	}
+
+	window := time.Duration(float64(this.interval) * streamingSpreadShare)
+	queue := make(chan scheduledPoll, len(polls))
+	for i, j := range this.random.Perm(len(polls)) {
+		poll := polls[j]
+		poll.due = start.Add(window * time.Duration(i) / time.Duration(len(polls)))
+		queue <- poll
+	}
+	close(queue)
+	workers := this.concurrency
+	if workers <= 0 || workers > len(polls) {
+		workers = len(polls)
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for poll := range queue {
+				if delay := poll.due.Sub(time.Now()); delay > 0 {
+					time.Sleep(delay)
+				}
+				this.poll(poll.node, poll.stream)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ensure streams the summary of the node, restarting the stream if the node is reached at
+// another host.
+func (this *summaryStreamer) ensure(node string, host kubelet.Host, pool *scrapePool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if stream, found := this.streams[node]; found && stream.host == host {
+		stream.lock.Lock()
+		stream.pool = pool
+		stream.lock.Unlock()
+		return
+	}
+	this.streams[node] = &summaryStream{host: host, pool: pool}
+	summaryStreams.Set(float64(len(this.streams)))
+}
+
+func (this *summaryStreamer) poll(node string, stream *summaryStream) {
+	stream.lock.Lock()
+	previous, pool := stream.latest, stream.pool
+	stream.lock.Unlock()
+	if pool != nil {
+		if !pool.acquire() {
+			summaryStreamRequests.WithLabelValues("skipped").Inc()
+			return
+		}
+		defer pool.release()
+	}
+	response, err := this.fetch(stream.host, previous)
+	if err != nil {
+		glog.V(4).Infof("error while streaming metrics summary from Kubelet %s(%s:%d): %v", node, stream.host.IP, stream.host.Port, err)
+		summaryStreamRequests.WithLabelValues("error").Inc()
+		return
+	}
+	if response.NotModified {
+		summaryStreamRequests.WithLabelValues("not_modified").Inc()
+	} else {
+		summaryStreamRequests.WithLabelValues("modified").Inc()
+	}
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	stream.latest = response
+	stream.received = time.Now()
+}
+
+// get returns the latest summary of the node and its age, or nil if none was received in
+// the last two intervals.
+func (this *summaryStreamer) get(node string, now time.Time) (*kubelet.SummaryResponse, time.Duration) {
+	this.lock.Lock()
+	stream, found := this.streams[node]
+	this.lock.Unlock()
+	if !found {
+		return nil, 0
+	}
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	if stream.latest == nil || now.Sub(stream.received) > 2*this.interval {
+		return nil, 0
+	}
+	return stream.latest, stream.latest.CacheAge + now.Sub(stream.received)
+}
+
+// retain stops streaming the nodes which are no longer scraped.
+func (this *summaryStreamer) retain(nodes map[string]bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for node := range this.streams {
+		if !nodes[node] {
+			delete(this.streams, node)
+		}
+	}
+	summaryStreams.Set(float64(len(this.streams)))
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+	corev1 "k8s.io/api/core/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+func TestSummaryStreamer(t *testing.T) {
+	fetched := make(chan kubelet.Host, 10)
+	var fail bool
+	streamer := newSummaryStreamer(time.Hour, 0, func(host kubelet.Host, previous *kubelet.SummaryResponse) (*kubelet.SummaryResponse, error) {
+		defer func() { fetched <- host }()
+		if fail {
+			return nil, fmt.Errorf("connection refused")
+		}
+		if previous != nil {
+			response := *previous
+			response.NotModified = true
+			return &response, nil
+		}
+		return &kubelet.SummaryResponse{
+			Summary:  &stats.Summary{Node: stats.NodeStats{NodeName: host.IP}},
+			CacheAge: time.Second,
+		}, nil
+	})
+	host := kubelet.Host{IP: "10.0.0.1", Port: 10250}
+
+	response, _ := streamer.get("node1", time.Now())
+	assert.Nil(t, response, "not streamed")
+
+	streamer.ensure("node1", host, nil)
+	assert.Len(t, fetched, 0, "requested by the rounds only")
+	stream := streamer.streams["node1"]
+	streamer.ensure("node1", host, nil)
+	assert.True(t, stream == streamer.streams["node1"], "already streamed")
+	streamer.poll("node1", stream)
+	<-fetched
+	response, age := streamer.get("node1", time.Now().Add(time.Minute))
+	require.NotNil(t, response)
+	assert.Equal(t, "10.0.0.1", response.Summary.Node.NodeName)
+	assert.True(t, age >= time.Minute+time.Second, "age includes the time since it was received")
+
+	streamer.poll("node1", stream)
+	<-fetched
+	response, _ = streamer.get("node1", time.Now())
+	require.NotNil(t, response)
+	assert.True(t, response.NotModified)
+
+	fail = true
+	streamer.poll("node1", stream)
+	<-fetched
+	response, _ = streamer.get("node1", time.Now())
+	assert.NotNil(t, response, "previous summary served while recent")
+	response, _ = streamer.get("node1", time.Now().Add(3*time.Hour))
+	assert.Nil(t, response, "too old")
+
+	fail = false
+	moved := kubelet.Host{IP: "10.0.0.2", Port: 10250}
+	streamer.ensure("node1", moved, nil)
+	response, _ = streamer.get("node1", time.Now())
+	assert.Nil(t, response, "restarted at the new host")
+	streamer.round(time.Now())
+	assert.Equal(t, moved, <-fetched)
+
+	streamer.retain(map[string]bool{})
+	assert.Empty(t, streamer.streams)
+}
+
+func TestSummaryStreamerRound(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	var times []time.Time
+	streamer := newSummaryStreamer(100*time.Millisecond, 2, func(host kubelet.Host, previous *kubelet.SummaryResponse) (*kubelet.SummaryResponse, error) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		times = append(times, time.Now())
+		lock.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		return &kubelet.SummaryResponse{Summary: &stats.Summary{}}, nil
+	})
+	pool := newPoolLimiter("pool", 1, time.Millisecond).pool(&corev1.Node{})
+	pool.slots <- struct{}{}
+	for i := 0; i < 8; i++ {
+		node := fmt.Sprintf("node%d", i)
+		streamer.ensure(node, kubelet.Host{IP: node}, nil)
+	}
+	streamer.ensure("busy", kubelet.Host{IP: "busy"}, pool)
+
+	start := time.Now()
+	streamer.round(start)
+	assert.Len(t, times, 8, "the node of the busy pool is skipped")
+	assert.True(t, maxInFlight <= 2, "at most the concurrency in flight: %d", maxInFlight)
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	assert.True(t, times[len(times)-1].Sub(start) >= 50*time.Millisecond, "spread over the interval")
+	response, _ := streamer.get("busy", time.Now())
+	assert.Nil(t, response)
+	response, _ = streamer.get("node0", time.Now())
+	assert.NotNil(t, response)
+}
+
+func TestWithStreaming(t *testing.T) {
+	uri, err := url.Parse("https://kubernetes.default?streamingConcurrency=3")
+	require.NoError(t, err)
+	result := WithStreaming(uri, 5*time.Second, 10)
+	assert.Equal(t, "5s", result.Query().Get("streamingInterval"))
+	assert.Equal(t, "3", result.Query().Get("streamingConcurrency"), "set by the URI")
+	assert.Equal(t, "streamingConcurrency=3", uri.RawQuery, "copied")
+	assert.Empty(t, WithStreaming(uri, 0, 0).Query().Get("streamingInterval"))
+}
@@ -17,6 +17,9 @@ package summary
 import (
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	. "github.com/kubernetes-incubator/metrics-server/metrics/core"
@@ -27,6 +30,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	kube_client "k8s.io/client-go/kubernetes"
 	v1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
@@ -43,15 +47,60 @@ var (
 		},
 		[]string{"node"},
 	)
+
+	// Time between the kubelet collecting the node stats and metrics-server scraping them.
+	summarySampleAge = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet_summary",
+			Name:      "sample_age_seconds",
+			Help:      "Age of the node stats in the kubelet summary at scrape time in seconds.",
+			Buckets:   []float64{1, 2.5, 5, 10, 15, 20, 30, 60},
+		},
+	)
+
+	deletedNodeScrapes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet_summary",
+			Name:      "deleted_node_scrapes_total",
+			Help:      "Number of scrape results discarded because the node was deleted while it was scraped.",
+		},
+	)
 )
 
 // Prefix used for the LabelResourceID for volume metrics.
 const VolumeResourcePrefix = "Volume:"
 
+const (
+	// Annotation which marks a node as being under planned maintenance. Nodes carrying
+	// it with a "true" value are not scraped. Can be overridden with the
+	// maintenanceAnnotation source option.
+	DefaultMaintenanceAnnotation = "metrics.k8s.io/maintenance"
+
+	// Taints put on nodes which are going away and should not be scraped.
+	outOfServiceTaint = "node.kubernetes.io/out-of-service"
+	toBeDeletedTaint  = "ToBeDeletedByClusterAutoscaler"
+
+	// Ready condition message set by the kubelet during graceful node shutdown.
+	nodeShutdownMessage = "node is shutting down"
+
+	// Time after the expected kubelet housekeeping at which the node is scraped, and the
+	// longest a scrape is delayed to get there.
+	housekeepingMargin   = 500 * time.Millisecond
+	maxHousekeepingDelay = 10 * time.Second
+)
+
 func init() {
 	prometheus.MustRegister(summaryRequestLatency)
+	prometheus.MustRegister(summarySampleAge)
+	prometheus.MustRegister(deletedNodeScrapes)
 }
 
+// Tells whether the node was deleted from the API server, for the scrapes of every source
+// type to be discarded. Replaced in tests.
+var isNodeDeleted = util.IsNodeDeleted
+
 type NodeInfo struct {
 	kubelet.Host
 	NodeName       string
@@ -64,6 +113,32 @@ type NodeInfo struct {
 type summaryMetricsSource struct {
 	node          NodeInfo
 	kubeletClient *kubelet.KubeletClient
+	// Whether the node runs pods which should get the freshest data.
+	prioritized bool
+	// Used to align the scrape with the kubelet housekeeping, nil if disabled.
+	housekeeping *housekeepingTracker
+	// Scrape time of samples without their own timestamp, zero if unknown.
+	fallbackScrapeTime time.Time
+	// Whether only cpu and memory metrics are decoded.
+	resourcesOnly bool
+	// Whether the kubelet is scraped on its resource metrics endpoint instead of the Summary API.
+	resourceEndpoint bool
+	// Issues found while decoding the latest summary.
+	report *NodeDecodeReport
+	// Limits the concurrent requests to the node pool, nil if unlimited.
+	pool *scrapePool
+	// Backs off the node while it keeps failing, nil if disabled.
+	breaker *nodeBreaker
+	// Usage of the pod cgroups of the summary being decoded, keyed by namespace/name.
+	podUsage map[string]*kubelet.PodUsageStats
+	// Swap usage of the node of the summary being decoded, nil if not reported.
+	nodeSwap *kubelet.SwapStats
+	// Keeps the scraped metrics of nodes with their own scrape interval, nil for others.
+	intervals *intervalScheduler
+	// Streams the summary of the node between the scrapes, nil if disabled.
+	streamer *summaryStreamer
+	// Cycle the failures of the scrape are recorded in, nil if only counted.
+	cycle *scrapeCycle
 }
 
 func NewSummaryMetricsSource(node NodeInfo, client *kubelet.KubeletClient) MetricsSource {
@@ -73,6 +148,10 @@ func NewSummaryMetricsSource(node NodeInfo, client *kubelet.KubeletClient) Metri
 	}
 }
 
+func (this *summaryMetricsSource) IsPrioritized() bool {
+	return this.prioritized
+}
+
 func (this *summaryMetricsSource) Name() string {
 	return this.String()
 }
@@ -87,22 +166,97 @@ func (this *summaryMetricsSource) ScrapeMetrics(start, end time.Time) *DataBatch
 		MetricSets: map[string]*MetricSet{},
 	}
 
-	summary, err := func() (*stats.Summary, error) {
+	if this.housekeeping != nil {
+		if delay := this.housekeeping.delay(this.node.NodeName, time.Now()); delay > 0 {
+			glog.V(4).Infof("Delaying scrape of node %s by %s to follow kubelet housekeeping", this.node.NodeName, delay)
+			time.Sleep(delay)
+		}
+	}
+
+	if this.pool != nil {
+		if !this.pool.acquire() {
+			glog.Errorf("error while getting metrics summary from Kubelet %s(%s:%d): no free slot in node pool %q", this.node.NodeName, this.node.IP, this.node.Port, this.pool.name)
+			this.cycle.record(this.node.NodeName, CauseNodePoolFull)
+			return result
+		}
+		defer this.pool.release()
+	}
+
+	summary, extensions, cacheAge, err := func() (*stats.Summary, *kubelet.SummaryExtensions, time.Duration, error) {
 		startTime := time.Now()
-		defer summaryRequestLatency.WithLabelValues(this.node.HostName).Observe(float64(time.Since(startTime)))
-		return this.kubeletClient.GetSummary(this.node.Host)
+		defer func() {
+			summaryRequestLatency.WithLabelValues(this.node.HostName).Observe(float64(time.Since(startTime)) / float64(time.Microsecond))
+		}()
+		if this.resourceEndpoint {
+			summary, err := this.getResourceMetrics()
+			return summary, &kubelet.SummaryExtensions{}, 0, err
+		}
+		if this.streamer != nil {
+			if response, age := this.streamer.get(this.node.NodeName, time.Now()); response != nil {
+				return response.Summary, response.Extensions, age, nil
+			}
+		}
+		return this.kubeletClient.GetSummaryWithExtensions(this.node.Host)
 	}()
 
 	if err != nil {
-		glog.Errorf("error while getting metrics summary from Kubelet %s(%s:%d): %v", this.node.NodeName, this.node.IP, this.node.Port, err)
+		if this.breaker != nil && this.breaker.isOpen(this.node.NodeName) {
+			glog.V(2).Infof("error while getting metrics summary from backed off Kubelet %s(%s:%d): %v", this.node.NodeName, this.node.IP, this.node.Port, err)
+		} else {
+			glog.Errorf("error while getting metrics summary from Kubelet %s(%s:%d): %v", this.node.NodeName, this.node.IP, this.node.Port, err)
+		}
+		this.cycle.record(this.node.NodeName, ClassifyScrapeError(err))
+		if this.breaker != nil {
+			this.breaker.failure(this.node.NodeName, time.Now())
+		}
 		return result
 	}
+	if this.breaker != nil {
+		this.breaker.success(this.node.NodeName)
+	}
+	if isNodeDeleted(this.node.NodeName) {
+		// The node was deleted while it was scraped, its points must not be stored again.
+		glog.V(2).Infof("Discarding summary of node %s, which was deleted", this.node.NodeName)
+		deletedNodeScrapes.Inc()
+		return result
+	}
+	if cacheAge > 0 {
+		// The summary was served from the kubelet cache, so samples without their own
+		// timestamp are as old as the cached response.
+		this.fallbackScrapeTime = time.Now().Add(-cacheAge)
+	}
 
+	podUsage := extensions.Pods
+	this.podUsage = make(map[string]*kubelet.PodUsageStats, len(podUsage))
+	for i := range podUsage {
+		this.podUsage[podUsage[i].PodRef.Namespace+"/"+podUsage[i].PodRef.Name] = &podUsage[i]
+	}
+	this.nodeSwap = extensions.Node.Swap
 	result.MetricSets = this.decodeSummary(summary)
+	storeDecodeReport(this.report)
+	if this.intervals != nil {
+		this.intervals.store(this.node.NodeName, result.MetricSets, result.Timestamp)
+	}
+
+	if sampleTime := this.getScrapeTime(summary.Node.CPU, summary.Node.Memory, summary.Node.Network); !sampleTime.IsZero() {
+		summarySampleAge.Observe(time.Since(sampleTime).Seconds())
+		if this.housekeeping != nil {
+			this.housekeeping.observe(this.node.NodeName, sampleTime)
+		}
+	}
 
 	return result
 }
 
+// getResourceMetrics scrapes the resource metrics endpoint of the kubelet.
+func (this *summaryMetricsSource) getResourceMetrics() (*stats.Summary, error) {
+	families, err := this.kubeletClient.GetResourceMetrics(this.node.Host)
+	if err != nil {
+		return nil, err
+	}
+	return decodeResourceMetrics(families, this.node.NodeName)
+}
+
 const (
 	RootFsKey = "/"
 	LogsKey   = "logs"
@@ -119,6 +273,7 @@ var systemNameMap = map[string]string{
 func (this *summaryMetricsSource) decodeSummary(summary *stats.Summary) map[string]*MetricSet {
 	glog.V(9).Infof("Begin summary decode")
 	result := map[string]*MetricSet{}
+	this.report = newNodeDecodeReport(this.node.NodeName)
 
 	labels := map[string]string{
 		LabelNodename.Key: this.node.NodeName,
@@ -154,16 +309,26 @@ func (this *summaryMetricsSource) decodeNodeStats(metrics map[string]*MetricSet,
 		ScrapeTime:     this.getScrapeTime(node.CPU, node.Memory, node.Network),
 	}
 	nodeMetrics.Labels[LabelMetricSetType.Key] = MetricSetTypeNode
+	if this.resourceEndpoint {
+		// The resource metrics endpoint doesn't report the start time of the node.
+		this.report.checkUsage("node", node.CPU, node.Memory)
+	} else {
+		this.report.checkStats("node", node.StartTime.Time, node.CPU, node.Memory)
+	}
 
 	this.decodeUptime(nodeMetrics, node.StartTime.Time)
 	this.decodeCPUStats(nodeMetrics, node.CPU)
 	this.decodeMemoryStats(nodeMetrics, node.Memory)
-	this.decodeNetworkStats(nodeMetrics, node.Network)
-	this.decodeFsStats(nodeMetrics, RootFsKey, node.Fs)
+	this.decodeSwapStats(nodeMetrics, this.nodeSwap)
+	if !this.resourcesOnly {
+		this.decodeNetworkStats(nodeMetrics, node.Network)
+		this.decodeFsStats(nodeMetrics, RootFsKey, node.Fs)
+	}
 	metrics[NodeKey(node.NodeName)] = nodeMetrics
 
 	for _, container := range node.SystemContainers {
 		key := NodeContainerKey(node.NodeName, this.getContainerName(&container))
+		this.report.checkStats("system/"+container.Name, container.StartTime.Time, container.CPU, container.Memory)
 		containerMetrics := this.decodeContainerStats(labels, &container)
 		containerMetrics.Labels[LabelMetricSetType.Key] = MetricSetTypeSystemContainer
 		metrics[key] = containerMetrics
@@ -186,15 +351,31 @@ func (this *summaryMetricsSource) decodePodStats(metrics map[string]*MetricSet,
 	podMetrics.Labels[LabelNamespaceName.Key] = ref.Namespace
 
 	this.decodeUptime(podMetrics, pod.StartTime.Time)
-	this.decodeNetworkStats(podMetrics, pod.Network)
-	for _, vol := range pod.VolumeStats {
-		this.decodeFsStats(podMetrics, VolumeResourcePrefix+vol.Name, &vol.FsStats)
+	usage := this.podUsage[ref.Namespace+"/"+ref.Name]
+	if usage != nil {
+		this.decodePodUsage(podMetrics, usage)
+	}
+	if !this.resourcesOnly {
+		this.decodeNetworkStats(podMetrics, pod.Network)
+		for _, vol := range pod.VolumeStats {
+			this.decodeFsStats(podMetrics, VolumeResourcePrefix+vol.Name, &vol.FsStats)
+		}
 	}
 	metrics[PodKey(ref.Namespace, ref.Name)] = podMetrics
 
 	for _, container := range pod.Containers {
 		key := PodContainerKey(ref.Namespace, ref.Name, container.Name)
-		metrics[key] = this.decodeContainerStats(podMetrics.Labels, &container)
+		this.report.checkStats(ref.Namespace+"/"+ref.Name+"/"+container.Name, container.StartTime.Time, container.CPU, container.Memory)
+		containerMetrics := this.decodeContainerStats(podMetrics.Labels, &container)
+		if usage != nil {
+			for i := range usage.Containers {
+				if usage.Containers[i].Name == container.Name {
+					this.decodeSwapStats(containerMetrics, usage.Containers[i].Swap)
+					this.decodeCPUThrottlingStats(containerMetrics, usage.Containers[i].CPU)
+				}
+			}
+		}
+		metrics[key] = containerMetrics
 	}
 }
 
@@ -213,9 +394,11 @@ func (this *summaryMetricsSource) decodeContainerStats(podLabels map[string]stri
 	this.decodeUptime(containerMetrics, container.StartTime.Time)
 	this.decodeCPUStats(containerMetrics, container.CPU)
 	this.decodeMemoryStats(containerMetrics, container.Memory)
-	this.decodeFsStats(containerMetrics, RootFsKey, container.Rootfs)
-	this.decodeFsStats(containerMetrics, LogsKey, container.Logs)
-	this.decodeUserDefinedMetrics(containerMetrics, container.UserDefinedMetrics)
+	if !this.resourcesOnly {
+		this.decodeFsStats(containerMetrics, RootFsKey, container.Rootfs)
+		this.decodeFsStats(containerMetrics, LogsKey, container.Logs)
+		this.decodeUserDefinedMetrics(containerMetrics, container.UserDefinedMetrics)
+	}
 
 	return containerMetrics
 }
@@ -251,6 +434,37 @@ func (this *summaryMetricsSource) decodeMemoryStats(metrics *MetricSet, memory *
 	this.addIntMetric(metrics, &MetricMemoryMajorPageFaults, memory.MajorPageFaults)
 }
 
+// decodeSwapStats adds the swap usage, which kubelets only report with swap enabled.
+func (this *summaryMetricsSource) decodeSwapStats(metrics *MetricSet, swap *kubelet.SwapStats) {
+	if swap == nil {
+		return
+	}
+
+	this.addIntMetric(metrics, &MetricMemorySwapUsage, swap.SwapUsageBytes)
+	this.addIntMetric(metrics, &MetricMemorySwapAvailable, swap.SwapAvailableBytes)
+}
+
+// decodeCPUThrottlingStats adds the time the container was throttled, which kubelets only
+// report with the CFS stats exposed.
+func (this *summaryMetricsSource) decodeCPUThrottlingStats(metrics *MetricSet, cpu *kubelet.CPUThrottlingStats) {
+	if cpu == nil {
+		return
+	}
+
+	this.addIntMetric(metrics, &MetricCpuThrottledTime, cpu.ThrottledTimeNanoSeconds)
+}
+
+// decodePodUsage adds the usage of the pod cgroup, as rates computed by the kubelet.
+func (this *summaryMetricsSource) decodePodUsage(metrics *MetricSet, usage *kubelet.PodUsageStats) {
+	if usage.CPU != nil && usage.CPU.UsageNanoCores != nil {
+		millicores := *usage.CPU.UsageNanoCores / 1000000
+		this.addIntMetric(metrics, &MetricPodCgroupCpuUsageRate, &millicores)
+	}
+	if usage.Memory != nil {
+		this.addIntMetric(metrics, &MetricPodCgroupMemoryWorkingSet, usage.Memory.WorkingSetBytes)
+	}
+}
+
 func (this *summaryMetricsSource) decodeNetworkStats(metrics *MetricSet, network *stats.NetworkStats) {
 	if network == nil {
 		glog.V(9).Infof("missing network metrics!")
@@ -308,7 +522,7 @@ func (this *summaryMetricsSource) getScrapeTime(cpu *stats.CPUStats, memory *sta
 	case network != nil && !network.Time.IsZero():
 		return network.Time.Time
 	default:
-		return time.Time{}
+		return this.fallbackScrapeTime
 	}
 }
 
@@ -358,6 +572,57 @@ type summaryProvider struct {
 	nodeLister    v1listers.NodeLister
 	reflector     *cache.Reflector
 	kubeletClient *kubelet.KubeletClient
+	// Annotation marking nodes under maintenance, empty if disabled.
+	maintenanceAnnotation string
+	// Whether cordoned nodes are scraped.
+	scrapeUnschedulable bool
+	// Nodes running pods from these namespaces or priority classes are scraped first.
+	// The pod lister is only set if any of them is configured.
+	podLister          v1listers.PodLister
+	priorityNamespaces map[string]bool
+	priorityClasses    map[string]bool
+	// Aligns scrapes with the kubelet housekeeping, nil if disabled.
+	housekeeping *housekeepingTracker
+	// Resolves the addresses the kubelets are scraped at.
+	addressResolver kubelet.NodeAddressResolver
+	// Rewrites the kubelet URLs of nodes, nil if disabled.
+	urlRewriter *kubelet.URLRewriter
+	// Limits the concurrent scrapes per node pool, nil if disabled.
+	pools *poolLimiter
+	// Conditions nodes must be in to be scraped, DefaultNodeConditions if empty.
+	nodeConditions []NodeCondition
+	// Annotation with the scrape interval of a node, empty if disabled.
+	scrapeIntervalAnnotation string
+	// Keeps the metrics of nodes with their own scrape interval between their scrapes.
+	intervals *intervalScheduler
+	// Backs off failing nodes, nil if disabled.
+	breaker *nodeBreaker
+	// Streams the summaries of the scraped nodes, nil if disabled.
+	streamer *summaryStreamer
+	// Failures of the scrape cycles, nil if only counted.
+	failures *scrapeFailureTracker
+	// Shard of the nodes which are scraped.
+	shard util.Shard
+	// Share of the nodes of the shard which are scraped, 0 for all.
+	nodeShare float64
+	// Whether the kubelets are scraped on their resource metrics endpoint.
+	resourceEndpoint bool
+	// Which metrics are collected.
+	collectionMode
+}
+
+// CompleteScrapeCycle makes the failures of the cycle in progress the latest ones. The
+// source manager calls it once the sources of the cycle were scraped or timed out.
+func (this *summaryProvider) CompleteScrapeCycle(now time.Time) {
+	this.failures.completeCurrent(now)
+}
+
+// GetScrapeFailures returns the failures of the latest completed scrape cycle.
+func (this *summaryProvider) GetScrapeFailures() ScrapeFailures {
+	if this.failures == nil {
+		return ScrapeFailures{}
+	}
+	return this.failures.get()
 }
 
 func (this *summaryProvider) GetMetricsSources() []MetricsSource {
@@ -368,66 +633,468 @@ func (this *summaryProvider) GetMetricsSources() []MetricsSource {
 		return sources
 	}
 
+	now := time.Now()
+	cycle := this.failures.start(now)
+	priorityNodes := this.getPriorityNodes()
+	resourcesOnly := this.isResourcesOnly()
+	others := []MetricsSource{}
+	targets := make([]ScrapeTarget, 0, len(nodes))
+	intervalNodes := map[string]bool{}
+	streamedNodes := map[string]bool{}
+	hosts := []kubelet.Host{}
 	for _, node := range nodes {
+		if (this.nodeShare > 0 && !util.InShare(node.Name, this.nodeShare)) || !this.shard.Owns(node.Name) {
+			continue
+		}
+		if reason := util.NodeSkipReason(node); reason != "" {
+			targets = append(targets, ScrapeTarget{Node: node.Name, Skipped: reason})
+			continue
+		}
+		if reason, skip := this.isNodeGoingAway(node); skip {
+			glog.V(2).Infof("Skipping node %v: %s", node.Name, reason)
+			targets = append(targets, ScrapeTarget{Node: node.Name, Skipped: reason})
+			continue
+		}
+		// Pushed summaries replace the scrape, after the same checks of the node.
+		if pushed := getPushedSummary(node.Name, now); pushed != nil {
+			if c, unmet := this.unmetNodeCondition(node); unmet {
+				targets = append(targets, ScrapeTarget{Node: node.Name, Error: fmt.Sprintf("Node %v has condition %s=%s", node.Name, c.Type, c.Status)})
+				cycle.record(node.Name, CauseNodeNotReady)
+				continue
+			}
+			if isNodeDeleted(node.Name) {
+				targets = append(targets, ScrapeTarget{Node: node.Name, Skipped: "node was deleted"})
+				continue
+			}
+			targets = append(targets, ScrapeTarget{Node: node.Name, Skipped: "summary pushed by node agent"})
+			sources = append(sources, newPushedMetricsSource(node, pushed, resourcesOnly))
+			continue
+		}
 		info, err := this.getNodeInfo(node)
+		targets = append(targets, this.getScrapeTarget(node.Name, info, err))
 		if err != nil {
 			glog.Errorf("%v", err)
+			if _, unmet := this.unmetNodeCondition(node); unmet {
+				cycle.record(node.Name, CauseNodeNotReady)
+			} else {
+				cycle.record(node.Name, CauseAddressResolution)
+			}
 			continue
 		}
-		sources = append(sources, NewSummaryMetricsSource(info, this.kubeletClient))
+		hosts = append(hosts, info.Host)
+		source := &summaryMetricsSource{
+			node:             info,
+			kubeletClient:    this.kubeletClient,
+			prioritized:      priorityNodes[node.Name],
+			housekeeping:     this.housekeeping,
+			resourcesOnly:    resourcesOnly,
+			resourceEndpoint: this.resourceEndpoint,
+			breaker:          this.breaker,
+			cycle:            cycle,
+		}
+		if this.pools != nil {
+			source.pool = this.pools.pool(node)
+		}
+		if interval := getScrapeInterval(node, this.scrapeIntervalAnnotation); interval > 0 && this.intervals != nil {
+			intervalNodes[node.Name] = true
+			source.intervals = this.intervals
+			if metricSets, scraped := this.intervals.cached(node.Name, interval, source, now); metricSets != nil {
+				glog.V(4).Infof("Serving metrics of node %s from its last scrape, it's scraped every %s", node.Name, interval)
+				sources = append(sources, &cachedMetricsSource{node: node.Name, metricSets: metricSets, scraped: scraped})
+				continue
+			}
+		}
+		if this.breaker != nil {
+			if reason, allowed := this.breaker.allow(node.Name, now); !allowed {
+				glog.V(4).Infof("Skipping node %v: %s", node.Name, reason)
+				targets[len(targets)-1].Skipped = reason
+				cycle.record(node.Name, CauseBackoff)
+				continue
+			}
+		}
+		// Nodes with their own scrape interval are not streamed, they ask for fewer requests.
+		if this.streamer != nil && !this.resourceEndpoint && !intervalNodes[node.Name] {
+			this.streamer.ensure(node.Name, info.Host, source.pool)
+			streamedNodes[node.Name] = true
+			source.streamer = this.streamer
+		}
+		if source.prioritized {
+			sources = append(sources, source)
+		} else {
+			others = append(others, source)
+		}
+	}
+	if this.housekeeping != nil {
+		this.housekeeping.retain(nodes)
+	}
+	if this.pools != nil {
+		this.pools.retain(nodes)
+	}
+	if this.intervals != nil {
+		this.intervals.retain(intervalNodes)
+	}
+	if this.breaker != nil {
+		this.breaker.retain(nodes)
 	}
-	return sources
+	if this.streamer != nil {
+		this.streamer.retain(streamedNodes)
+	}
+	if this.kubeletClient != nil {
+		this.kubeletClient.RetainHosts(hosts)
+	}
+	retainDecodeReports(nodes)
+	retainPushedSummaries(nodes)
+	storeScrapeTargets(targets)
+	return append(sources, others...)
 }
 
-func (this *summaryProvider) getNodeInfo(node *corev1.Node) (NodeInfo, error) {
+func (this *summaryProvider) getScrapeTarget(node string, info NodeInfo, err error) ScrapeTarget {
+	if err != nil {
+		return ScrapeTarget{Node: node, Error: err.Error()}
+	}
+	url := this.kubeletClient.SummaryURL(info.Host)
+	if this.resourceEndpoint {
+		url = this.kubeletClient.ResourceMetricsURL(info.Host)
+	}
+	return ScrapeTarget{
+		Node:        node,
+		URL:         url,
+		AddressType: info.AddressType,
+		TLSMode:     this.kubeletClient.TLSMode(info.Host),
+		AuthMode:    this.kubeletClient.AuthMode(),
+	}
+}
+
+// Remembers when the kubelets last collected their stats, to time the next scrape of
+// each node just after the next expected housekeeping.
+type housekeepingTracker struct {
+	interval time.Duration
+
+	lock            sync.Mutex
+	lastSampleTimes map[string]time.Time
+}
+
+func newHousekeepingTracker(interval time.Duration) *housekeepingTracker {
+	return &housekeepingTracker{
+		interval:        interval,
+		lastSampleTimes: make(map[string]time.Time),
+	}
+}
+
+// delay returns how long to wait before scraping the node, zero if unknown or too long.
+func (this *housekeepingTracker) delay(node string, now time.Time) time.Duration {
+	this.lock.Lock()
+	last, found := this.lastSampleTimes[node]
+	this.lock.Unlock()
+	if !found || this.interval <= 0 || now.Before(last) {
+		return 0
+	}
+
+	delay := this.interval - now.Sub(last)%this.interval + housekeepingMargin
+	if delay > this.interval {
+		// Housekeeping happened within the margin.
+		delay -= this.interval
+	}
+	if delay > maxHousekeepingDelay {
+		return 0
+	}
+	return delay
+}
+
+func (this *housekeepingTracker) observe(node string, sampleTime time.Time) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.lastSampleTimes[node] = sampleTime
+}
+
+// retain forgets about the nodes that are gone.
+func (this *housekeepingTracker) retain(nodes []*corev1.Node) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	present := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		present[node.Name] = true
+	}
+	for name := range this.lastSampleTimes {
+		if !present[name] {
+			delete(this.lastSampleTimes, name)
+		}
+	}
+}
+
+// getPriorityNodes returns the names of nodes running pods from the priority
+// namespaces or priority classes.
+func (this *summaryProvider) getPriorityNodes() map[string]bool {
+	result := map[string]bool{}
+	if this.podLister == nil {
+		return result
+	}
+	pods, err := this.podLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("error while listing pods: %v", err)
+		return result
+	}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+		if this.priorityNamespaces[pod.Namespace] || this.priorityClasses[pod.Spec.PriorityClassName] {
+			result[pod.Spec.NodeName] = true
+		}
+	}
+	return result
+}
+
+// isNodeGoingAway checks whether the node is shutting down, under maintenance or,
+// unless scrapeUnschedulable is set, cordoned, in which case scrape failures are expected and should not be reported.
+func (this *summaryProvider) isNodeGoingAway(node *corev1.Node) (string, bool) {
+	if this.maintenanceAnnotation != "" {
+		if value, found := node.Annotations[this.maintenanceAnnotation]; found {
+			if inMaintenance, err := strconv.ParseBool(value); err == nil && inMaintenance {
+				return fmt.Sprintf("node has %s annotation", this.maintenanceAnnotation), true
+			}
+		}
+	}
+	if node.Spec.Unschedulable && !this.scrapeUnschedulable {
+		return "node is unschedulable", true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == outOfServiceTaint || taint.Key == toBeDeletedTaint {
+			return fmt.Sprintf("node has %s taint", taint.Key), true
+		}
+	}
 	for _, c := range node.Status.Conditions {
-		if c.Type == corev1.NodeReady && c.Status != corev1.ConditionTrue {
+		if c.Type == corev1.NodeReady && c.Status != corev1.ConditionTrue && strings.Contains(c.Message, nodeShutdownMessage) {
+			return "node is shutting down", true
+		}
+	}
+	return "", false
+}
+
+func (this *summaryProvider) unmetNodeCondition(node *corev1.Node) (*corev1.NodeCondition, bool) {
+	conditions := this.nodeConditions
+	if len(conditions) == 0 {
+		conditions = DefaultNodeConditions
+	}
+	return unmetNodeCondition(node, conditions)
+}
+
+func (this *summaryProvider) getNodeInfo(node *corev1.Node) (NodeInfo, error) {
+	if c, unmet := this.unmetNodeCondition(node); unmet {
+		if c.Type == corev1.NodeReady {
 			return NodeInfo{}, fmt.Errorf("Node %v is not ready", node.Name)
 		}
+		return NodeInfo{}, fmt.Errorf("Node %v has condition %s=%s", node.Name, c.Type, c.Status)
+	}
+	hostname, host, err := this.addressResolver.ResolveNodeAddress(node)
+	if err != nil {
+		return NodeInfo{}, err
 	}
 	info := NodeInfo{
-		NodeName: node.Name,
-		HostName: node.Name,
-		HostID:   node.Spec.ExternalID,
-		Host: kubelet.Host{
-			Port: this.kubeletClient.GetPort(),
-		},
+		Host:           host,
+		NodeName:       node.Name,
+		HostName:       hostname,
+		HostID:         node.Spec.ExternalID,
 		KubeletVersion: node.Status.NodeInfo.KubeletVersion,
 	}
 
-	for _, addr := range node.Status.Addresses {
-		if addr.Type == corev1.NodeHostName && addr.Address != "" {
-			info.HostName = addr.Address
+	if this.urlRewriter != nil {
+		if _, err := this.urlRewriter.Rewrite(node.Name, &info.Host); err != nil {
+			return info, err
 		}
-		if addr.Type == corev1.NodeInternalIP && addr.Address != "" {
-			info.IP = addr.Address
-		}
-	}
-
-	if info.IP == "" {
-		return info, fmt.Errorf("Node %v has no valid hostname and/or IP address: %v %v", node.Name, info.HostName, info.IP)
 	}
 
 	return info, nil
 }
 
-func NewSummaryProvider(uri *url.URL) (MetricsSourceProvider, error) {
+// NewSummaryProvider returns the provider of the sources configured by the URI. Pods are
+// listed with the pod lister if given, rather than with a watch of their own.
+func NewSummaryProvider(uri *url.URL, podLister v1listers.PodLister) (MetricsSourceProvider, error) {
 	// create clients
 	kubeConfig, kubeletConfig, err := kubelet.GetKubeConfigs(uri)
 	if err != nil {
 		return nil, err
 	}
 	kubeClient := kube_client.NewForConfigOrDie(kubeConfig)
+	opts := uri.Query()
+
+	var streamingInterval time.Duration
+	if len(opts["streamingInterval"]) >= 1 {
+		streamingInterval, err = time.ParseDuration(opts["streamingInterval"][0])
+		if err != nil || streamingInterval < 0 {
+			return nil, fmt.Errorf("invalid streamingInterval: %q must be a non-negative duration", opts["streamingInterval"][0])
+		}
+	}
+	streamingConcurrency := 0
+	if len(opts["streamingConcurrency"]) >= 1 {
+		streamingConcurrency, err = strconv.Atoi(opts["streamingConcurrency"][0])
+		if err != nil || streamingConcurrency < 0 {
+			return nil, fmt.Errorf("invalid streamingConcurrency: %q must be a non-negative integer", opts["streamingConcurrency"][0])
+		}
+	}
+	shard, err := parseShard(opts)
+	if err != nil {
+		return nil, err
+	}
+	nodeSelector, err := util.ParseNodeSelector(opts)
+	if err != nil {
+		return nil, err
+	}
+	nodeShare, err := parseNodeShare(opts)
+	if err != nil {
+		return nil, err
+	}
+	resourceEndpoint, err := parseResourceEndpoint(opts)
+	if err != nil {
+		return nil, err
+	}
+	mode := util.CollectionFull
+	if len(opts["collectionMode"]) >= 1 {
+		mode = opts["collectionMode"][0]
+	}
+
+	// Streamed summaries are requested over a single HTTP/2 connection per kubelet.
+	kubeletConfig.EnableHTTP2 = streamingInterval > 0
 	kubeletClient, err := kubelet.NewKubeletClient(kubeletConfig)
 	if err != nil {
 		return nil, err
 	}
+
+	maintenanceAnnotation := DefaultMaintenanceAnnotation
+	if len(opts["maintenanceAnnotation"]) >= 1 {
+		maintenanceAnnotation = opts["maintenanceAnnotation"][0]
+	}
+
+	scrapeUnschedulable := true
+	if len(opts["scrapeUnschedulable"]) >= 1 {
+		scrapeUnschedulable, err = strconv.ParseBool(opts["scrapeUnschedulable"][0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrapeUnschedulable: %v", err)
+		}
+	}
+
+	var housekeeping *housekeepingTracker
+	if len(opts["housekeepingInterval"]) >= 1 {
+		interval, err := time.ParseDuration(opts["housekeepingInterval"][0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid housekeepingInterval: %v", err)
+		}
+		if interval > 0 {
+			housekeeping = newHousekeepingTracker(interval)
+		}
+	}
+
+	addressResolver, err := kubelet.NewNodeAddressResolver(opts, kubeletClient.GetPort())
+	if err != nil {
+		return nil, err
+	}
+	// Invalidated by the events of the node lister.
+	cachingResolver := kubelet.NewCachingNodeAddressResolver(addressResolver)
+
+	var urlRewriter *kubelet.URLRewriter
+	if len(opts["kubeletURLRewriteConfig"]) >= 1 {
+		urlRewriter, err = kubelet.LoadURLRewriter(opts["kubeletURLRewriteConfig"][0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	priorityNamespaces := parseSetOption(opts["priorityNamespaces"])
+	priorityClasses := parseSetOption(opts["priorityClasses"])
+
+	var pools *poolLimiter
+	if len(opts["nodePoolLabel"]) >= 1 && opts["nodePoolLabel"][0] != "" {
+		if len(opts["nodePoolConcurrency"]) < 1 {
+			return nil, fmt.Errorf("nodePoolLabel requires nodePoolConcurrency")
+		}
+		limit, err := strconv.Atoi(opts["nodePoolConcurrency"][0])
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid nodePoolConcurrency: %q must be a positive integer", opts["nodePoolConcurrency"][0])
+		}
+		pools = newPoolLimiter(opts["nodePoolLabel"][0], limit, DefaultNodePoolWaitTimeout)
+	}
+
+	nodeConditions := DefaultNodeConditions
+	if len(opts["nodeConditions"]) >= 1 {
+		nodeConditions, err = ParseNodeConditions(opts["nodeConditions"][0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid nodeConditions: %v", err)
+		}
+	}
+
+	scrapeIntervalAnnotation := DefaultScrapeIntervalAnnotation
+	if len(opts["scrapeIntervalAnnotation"]) >= 1 {
+		scrapeIntervalAnnotation = opts["scrapeIntervalAnnotation"][0]
+	}
+
+	var breaker *nodeBreaker
+	if len(opts["scrapeBackoff"]) >= 1 {
+		base, err := time.ParseDuration(opts["scrapeBackoff"][0])
+		if err != nil || base < 0 {
+			return nil, fmt.Errorf("invalid scrapeBackoff: %q must be a non-negative duration", opts["scrapeBackoff"][0])
+		}
+		max := DefaultMaxScrapeBackoff
+		if len(opts["maxScrapeBackoff"]) >= 1 {
+			max, err = time.ParseDuration(opts["maxScrapeBackoff"][0])
+			if err != nil || max < base {
+				return nil, fmt.Errorf("invalid maxScrapeBackoff: %q must be a duration of at least scrapeBackoff", opts["maxScrapeBackoff"][0])
+			}
+		}
+		if base > 0 {
+			breaker = newNodeBreaker(base, max)
+		}
+	}
+
 	// watch nodes
-	nodeLister, reflector, _ := util.GetNodeLister(kubeClient)
+	nodeLister, reflector, _ := util.GetNodeLister(kubeClient, nodeSelector, cachingResolver)
 
-	return &summaryProvider{
-		nodeLister:    nodeLister,
-		reflector:     reflector,
-		kubeletClient: kubeletClient,
-	}, nil
+	provider := &summaryProvider{
+		nodeLister:               nodeLister,
+		reflector:                reflector,
+		kubeletClient:            kubeletClient,
+		maintenanceAnnotation:    maintenanceAnnotation,
+		scrapeUnschedulable:      scrapeUnschedulable,
+		priorityNamespaces:       priorityNamespaces,
+		priorityClasses:          priorityClasses,
+		housekeeping:             housekeeping,
+		addressResolver:          cachingResolver,
+		urlRewriter:              urlRewriter,
+		pools:                    pools,
+		nodeConditions:           nodeConditions,
+		scrapeIntervalAnnotation: scrapeIntervalAnnotation,
+		intervals:                newIntervalScheduler(),
+		breaker:                  breaker,
+		failures:                 newScrapeFailureTracker(),
+		shard:                    shard,
+		nodeShare:                nodeShare,
+		resourceEndpoint:         resourceEndpoint,
+	}
+	if err := provider.SetCollectionMode(mode); err != nil {
+		return nil, err
+	}
+	if streamingInterval > 0 {
+		provider.streamer = newSummaryStreamer(streamingInterval, streamingConcurrency, kubeletClient.GetSummaryIfModified)
+		go provider.streamer.run(wait.NeverStop)
+	}
+	if len(priorityNamespaces) > 0 || len(priorityClasses) > 0 {
+		// watch pods to find the nodes to scrape first
+		provider.podLister = podLister
+		if provider.podLister == nil {
+			provider.podLister, _, _ = util.GetPodLister(kubeConfig)
+		}
+	}
+	return provider, nil
+}
+
+// parseSetOption turns repeated and/or comma separated option values into a set.
+func parseSetOption(values []string) map[string]bool {
+	result := map[string]bool{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result[item] = true
+			}
+		}
+	}
+	return result
 }
@@ -16,7 +16,11 @@ package summary
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -24,9 +28,13 @@ import (
 
 	"github.com/kubernetes-incubator/metrics-server/metrics/core"
 	"github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet"
+	kubelet_client "github.com/kubernetes-incubator/metrics-server/metrics/sources/kubelet/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	util "k8s.io/client-go/util/testing"
 	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
 )
@@ -280,6 +288,129 @@ func TestDecodeSummaryMetrics(t *testing.T) {
 	}
 }
 
+func TestDecodeSummaryResourcesOnly(t *testing.T) {
+	ms := testingSummaryMetricsSource()
+	ms.resourcesOnly = true
+	summary := stats.Summary{
+		Node: stats.NodeStats{
+			NodeName:  nodeInfo.NodeName,
+			StartTime: metav1.NewTime(startTime),
+			CPU:       genTestSummaryCPU(seedNode),
+			Memory:    genTestSummaryMemory(seedNode),
+			Network:   genTestSummaryNetwork(seedNode),
+			Fs:        genTestSummaryFsStats(seedNode),
+		},
+		Pods: []stats.PodStats{{
+			PodRef:    stats.PodReference{Name: pName0, Namespace: namespace0},
+			StartTime: metav1.NewTime(startTime),
+			Network:   genTestSummaryNetwork(seedPod0),
+			Containers: []stats.ContainerStats{
+				genTestSummaryContainer(cName00, seedPod0Container0),
+			},
+			VolumeStats: []stats.VolumeStats{{
+				Name:    "A",
+				FsStats: *genTestSummaryFsStats(seedPod0),
+			}},
+		}},
+	}
+
+	metrics := ms.decodeSummary(&summary)
+	for _, key := range []string{core.NodeKey(nodeInfo.NodeName), core.PodContainerKey(namespace0, pName0, cName00)} {
+		m, ok := metrics[key]
+		require.True(t, ok, "missing metric %q", key)
+		assert.Contains(t, m.MetricValues, core.MetricCpuUsage.Name, key)
+		assert.Contains(t, m.MetricValues, core.MetricMemoryWorkingSet.Name, key)
+		assert.NotContains(t, m.MetricValues, core.MetricNetworkRx.Name, key)
+		assert.Empty(t, m.LabeledMetrics, key)
+	}
+	pod := metrics[core.PodKey(namespace0, pName0)]
+	require.NotNil(t, pod)
+	assert.NotContains(t, pod.MetricValues, core.MetricNetworkRx.Name)
+	assert.Empty(t, pod.LabeledMetrics)
+}
+
+func TestDecodePodUsage(t *testing.T) {
+	ms := testingSummaryMetricsSource()
+	nanoCores, workingSet := uint64(250000000), uint64(4096)
+	ms.podUsage = map[string]*kubelet.PodUsageStats{
+		namespace0 + "/" + pName0: {
+			CPU:    &stats.CPUStats{UsageNanoCores: &nanoCores},
+			Memory: &stats.MemoryStats{WorkingSetBytes: &workingSet},
+		},
+	}
+	summary := stats.Summary{
+		Node: stats.NodeStats{NodeName: nodeInfo.NodeName},
+		Pods: []stats.PodStats{{
+			PodRef:     stats.PodReference{Name: pName0, Namespace: namespace0},
+			Containers: []stats.ContainerStats{genTestSummaryContainer(cName00, seedPod0Container0)},
+		}, {
+			PodRef:     stats.PodReference{Name: pName1, Namespace: namespace0},
+			Containers: []stats.ContainerStats{genTestSummaryContainer(cName10, seedPod1Container)},
+		}},
+	}
+
+	metrics := ms.decodeSummary(&summary)
+	pod := metrics[core.PodKey(namespace0, pName0)]
+	require.NotNil(t, pod)
+	assert.Equal(t, int64(250), pod.MetricValues[core.MetricPodCgroupCpuUsageRate.Name].IntValue)
+	assert.Equal(t, int64(4096), pod.MetricValues[core.MetricPodCgroupMemoryWorkingSet.Name].IntValue)
+	assert.NotContains(t, metrics[core.PodContainerKey(namespace0, pName0, cName00)].MetricValues, core.MetricPodCgroupCpuUsageRate.Name)
+	assert.NotContains(t, metrics[core.PodKey(namespace0, pName1)].MetricValues, core.MetricPodCgroupCpuUsageRate.Name, "not reported")
+}
+
+func TestDecodeSwapStats(t *testing.T) {
+	ms := testingSummaryMetricsSource()
+	nodeSwap, containerSwap, available := uint64(1024), uint64(512), uint64(4096)
+	ms.nodeSwap = &kubelet.SwapStats{SwapUsageBytes: &nodeSwap, SwapAvailableBytes: &available}
+	ms.podUsage = map[string]*kubelet.PodUsageStats{
+		namespace0 + "/" + pName0: {
+			Containers: []kubelet.ContainerUsageStats{{Name: cName00, Swap: &kubelet.SwapStats{SwapUsageBytes: &containerSwap}}},
+		},
+	}
+	summary := stats.Summary{
+		Node: stats.NodeStats{NodeName: nodeInfo.NodeName},
+		Pods: []stats.PodStats{{
+			PodRef:     stats.PodReference{Name: pName0, Namespace: namespace0},
+			Containers: []stats.ContainerStats{genTestSummaryContainer(cName00, seedPod0Container0), genTestSummaryContainer(cName01, seedPod0Container1)},
+		}},
+	}
+
+	metrics := ms.decodeSummary(&summary)
+	node := metrics[core.NodeKey(nodeInfo.NodeName)]
+	require.NotNil(t, node)
+	assert.Equal(t, int64(1024), node.MetricValues[core.MetricMemorySwapUsage.Name].IntValue)
+	assert.Equal(t, int64(4096), node.MetricValues[core.MetricMemorySwapAvailable.Name].IntValue)
+	container := metrics[core.PodContainerKey(namespace0, pName0, cName00)]
+	require.NotNil(t, container)
+	assert.Equal(t, int64(512), container.MetricValues[core.MetricMemorySwapUsage.Name].IntValue)
+	assert.NotContains(t, container.MetricValues, core.MetricMemorySwapAvailable.Name)
+	assert.NotContains(t, metrics[core.PodContainerKey(namespace0, pName0, cName01)].MetricValues, core.MetricMemorySwapUsage.Name, "not reported")
+}
+
+func TestDecodeCPUThrottlingStats(t *testing.T) {
+	ms := testingSummaryMetricsSource()
+	throttled := uint64(2000000000)
+	ms.podUsage = map[string]*kubelet.PodUsageStats{
+		namespace0 + "/" + pName0: {
+			Containers: []kubelet.ContainerUsageStats{{Name: cName00, CPU: &kubelet.CPUThrottlingStats{ThrottledTimeNanoSeconds: &throttled}}},
+		},
+	}
+	summary := stats.Summary{
+		Node: stats.NodeStats{NodeName: nodeInfo.NodeName},
+		Pods: []stats.PodStats{{
+			PodRef:     stats.PodReference{Name: pName0, Namespace: namespace0},
+			Containers: []stats.ContainerStats{genTestSummaryContainer(cName00, seedPod0Container0), genTestSummaryContainer(cName01, seedPod0Container1)},
+		}},
+	}
+
+	metrics := ms.decodeSummary(&summary)
+	container := metrics[core.PodContainerKey(namespace0, pName0, cName00)]
+	require.NotNil(t, container)
+	assert.Equal(t, int64(2000000000), container.MetricValues[core.MetricCpuThrottledTime.Name].IntValue)
+	assert.Contains(t, container.MetricValues, core.MetricCpuUsage.Name)
+	assert.NotContains(t, metrics[core.PodContainerKey(namespace0, pName0, cName01)].MetricValues, core.MetricCpuThrottledTime.Name, "not reported")
+}
+
 func genTestSummaryContainer(name string, seed int) stats.ContainerStats {
 	return stats.ContainerStats{
 		Name:      name,
@@ -379,3 +510,187 @@ func TestScrapeSummaryMetrics(t *testing.T) {
 	res := ms.ScrapeMetrics(time.Now(), time.Now())
 	assert.Equal(t, res.MetricSets["node:test"].Labels[core.LabelMetricSetType.Key], core.MetricSetTypeNode)
 }
+
+func TestIsNodeGoingAway(t *testing.T) {
+	provider := &summaryProvider{maintenanceAnnotation: DefaultMaintenanceAnnotation, scrapeUnschedulable: true}
+	tests := []struct {
+		name string
+		node corev1.Node
+		skip bool
+	}{{
+		name: "healthy node",
+		node: corev1.Node{},
+		skip: false,
+	}, {
+		name: "maintenance annotation",
+		node: corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{DefaultMaintenanceAnnotation: "true"},
+		}},
+		skip: true,
+	}, {
+		name: "maintenance annotation disabled",
+		node: corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{DefaultMaintenanceAnnotation: "false"},
+		}},
+		skip: false,
+	}, {
+		name: "out of service taint",
+		node: corev1.Node{Spec: corev1.NodeSpec{
+			Taints: []corev1.Taint{{Key: outOfServiceTaint, Effect: corev1.TaintEffectNoExecute}},
+		}},
+		skip: true,
+	}, {
+		name: "shutting down",
+		node: corev1.Node{Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:    corev1.NodeReady,
+				Status:  corev1.ConditionFalse,
+				Message: nodeShutdownMessage,
+			}},
+		}},
+		skip: true,
+	}, {
+		name: "not ready",
+		node: corev1.Node{Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:   corev1.NodeReady,
+				Status: corev1.ConditionFalse,
+			}},
+		}},
+		skip: false,
+	}, {
+		name: "unschedulable",
+		node: corev1.Node{Spec: corev1.NodeSpec{Unschedulable: true}},
+		skip: false,
+	}}
+
+	for _, test := range tests {
+		_, skip := provider.isNodeGoingAway(&test.node)
+		assert.Equal(t, test.skip, skip, test.name)
+	}
+
+	provider.scrapeUnschedulable = false
+	_, skip := provider.isNodeGoingAway(&corev1.Node{Spec: corev1.NodeSpec{Unschedulable: true}})
+	assert.True(t, skip, "unschedulable with scrapeUnschedulable disabled")
+	_, skip = provider.isNodeGoingAway(&corev1.Node{})
+	assert.False(t, skip, "schedulable with scrapeUnschedulable disabled")
+}
+
+func TestGetMetricsSourcesPriorityOrder(t *testing.T) {
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, name := range []string{"node-a", "node-b", "node-c", "node-d"} {
+		require.NoError(t, nodes.Add(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+			},
+		}))
+	}
+	for _, pod := range []*corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "dns"},
+		Spec:       corev1.PodSpec{NodeName: "node-c"},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "critical"},
+		Spec:       corev1.PodSpec{NodeName: "node-d", PriorityClassName: "system-cluster-critical"},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec:       corev1.PodSpec{NodeName: "node-a"},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "pending"},
+	}} {
+		require.NoError(t, pods.Add(pod))
+	}
+
+	kubeletClient, err := kubelet.NewKubeletClient(&kubelet_client.KubeletClientConfig{Port: 10250})
+	require.NoError(t, err)
+	provider := &summaryProvider{
+		nodeLister:         v1listers.NewNodeLister(nodes),
+		kubeletClient:      kubeletClient,
+		addressResolver:    &kubelet.PriorityNodeAddressResolver{AddressTypes: kubelet.DefaultAddressTypes, Port: 10250},
+		podLister:          v1listers.NewPodLister(pods),
+		priorityNamespaces: parseSetOption([]string{"kube-system"}),
+		priorityClasses:    parseSetOption([]string{"system-node-critical, system-cluster-critical"}),
+	}
+
+	sources := provider.GetMetricsSources()
+	require.Len(t, sources, 4)
+	var prioritized []string
+	for i, source := range sources {
+		ps := source.(core.PrioritizedMetricsSource)
+		if ps.IsPrioritized() {
+			assert.True(t, i < 2, "prioritized source %s not scraped first", source.Name())
+			prioritized = append(prioritized, source.(*summaryMetricsSource).node.NodeName)
+		}
+	}
+	sort.Strings(prioritized)
+	assert.Equal(t, []string{"node-c", "node-d"}, prioritized)
+}
+
+func TestHousekeepingDelay(t *testing.T) {
+	tracker := newHousekeepingTracker(10 * time.Second)
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), tracker.delay("node1", now), "unknown node")
+
+	tracker.observe("node1", now.Add(-23*time.Second))
+	assert.Equal(t, 7*time.Second+housekeepingMargin, tracker.delay("node1", now))
+
+	// Housekeeping has just happened.
+	tracker.observe("node1", now.Add(-20*time.Second-housekeepingMargin/2))
+	assert.Equal(t, housekeepingMargin/2, tracker.delay("node1", now))
+
+	tracker.retain([]*corev1.Node{})
+	assert.Equal(t, time.Duration(0), tracker.delay("node1", now), "forgotten node")
+}
+
+// Decodes the summaries of the fuzzing corpus, which must not crash the decoder.
+func TestDecodeSummaryCorpus(t *testing.T) {
+	files, err := filepath.Glob("testdata/corpus/*")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	ms := testingSummaryMetricsSource()
+	decoded := map[string]map[string]*core.MetricSet{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		summary := &stats.Summary{}
+		if err := json.Unmarshal(data, summary); err != nil {
+			continue
+		}
+		decoded[filepath.Base(file)] = ms.decodeSummary(summary)
+	}
+	assert.NotContains(t, decoded, "malformed.json")
+
+	linux := decoded["linux.json"]
+	require.NotNil(t, linux)
+	checkIntMetric(t, linux[core.NodeKey("node-1")], "node", core.MetricCpuUsage, 1630422588647)
+	checkIntMetric(t, linux[core.NodeContainerKey("node-1", "kubelet")], "kubelet", core.MetricMemoryWorkingSet, 72155136)
+	checkIntMetric(t, linux[core.PodContainerKey("kube-system", "kube-dns-5d8b8f4d9c-abcde", "sidecar")], "sidecar", core.MetricMemoryWorkingSet, 11231232)
+
+	windows := decoded["windows.json"]
+	require.NotNil(t, windows)
+	iis := windows[core.PodContainerKey("default", "iis-7c9d7d9f8-xyz12", "iis")]
+	checkIntMetric(t, iis, "iis", core.MetricCpuUsage, 1094400000000)
+	checkIntMetric(t, iis, "iis", core.MetricMemoryWorkingSet, 117219328)
+	assert.NotContains(t, iis.MetricValues, core.MetricMemoryUsage.Name)
+
+	partial := decoded["partial.json"]
+	require.NotNil(t, partial)
+	assert.NotContains(t, partial[core.PodContainerKey("default", "no-stats", "c")].MetricValues, core.MetricCpuUsage.Name)
+}
+
+func TestNewSummaryProviderSharesPodLister(t *testing.T) {
+	podLister := v1listers.NewPodLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))
+	uri, err := url.Parse("http://127.0.0.1:1?inClusterConfig=false&priorityNamespaces=kube-system")
+	require.NoError(t, err)
+	provider, err := NewSummaryProvider(uri, podLister)
+	require.NoError(t, err)
+	assert.True(t, provider.(*summaryProvider).podLister == podLister, "no second pod watch")
+
+	uri, err = url.Parse("http://127.0.0.1:1?inClusterConfig=false")
+	require.NoError(t, err)
+	provider, err = NewSummaryProvider(uri, podLister)
+	require.NoError(t, err)
+	assert.Nil(t, provider.(*summaryProvider).podLister, "pods are only listed for the priority nodes")
+}
@@ -0,0 +1,334 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batchdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Path under which the diffs between batches are served.
+const Path = "/batchdiff"
+
+// Kinds of the objects of the diff entries.
+const (
+	KindNode = "Node"
+	KindPod  = "Pod"
+)
+
+// Usage of a node or pod. Entries of removed objects only carry the kind, namespace and name.
+type Entry struct {
+	Kind      string       `json:"kind"`
+	Namespace string       `json:"namespace,omitempty"`
+	Name      string       `json:"name"`
+	Timestamp *metav1.Time `json:"timestamp,omitempty"`
+	// Usage of a node.
+	Usage metrics.ResourceList `json:"usage,omitempty"`
+	// Usage of the containers of a pod, sorted by name.
+	Containers []metrics.ContainerMetrics `json:"containers,omitempty"`
+}
+
+// Changes of the served nodes and pods from the batch of the since generation to the latest
+// batch. Entries are sorted by kind, namespace and name.
+type BatchDiff struct {
+	// Generation of the latest batch, to be sent as since in the next request. It's the
+	// resourceVersion of the objects served by the Metrics API from that batch.
+	Generation string      `json:"generation"`
+	Since      string      `json:"since,omitempty"`
+	Timestamp  metav1.Time `json:"timestamp"`
+	Added      []Entry     `json:"added"`
+	Updated    []Entry     `json:"updated"`
+	Removed    []Entry     `json:"removed"`
+}
+
+type handler struct {
+	metricSink *metricsink.MetricSink
+	nodes      rest.Lister
+	pods       rest.Lister
+	authorizer authorizer.Authorizer
+	mapper     genericapirequest.RequestContextMapper
+}
+
+// NewHandler returns a handler serving the nodes and pods whose usage changed since the
+// batch of the generation in the since query parameter, so that caching consumers don't
+// need to list all metrics every cycle. Without since, all of them are served as added.
+// Entries are the NodeMetrics and PodMetrics the storages serve, so the same objects are
+// withheld, and only cover the nodes and the namespaces of pods the user may list. Pods
+// are left out if the lister is nil. Generations which have left the short store are
+// answered from the long store at its resolution, and ones which are no longer retained
+// at all with 410 Gone, after which the consumer has to start over without since.
+func NewHandler(metricSink *metricsink.MetricSink, nodes, pods rest.Lister, authz authorizer.Authorizer,
+	mapper genericapirequest.RequestContextMapper) http.Handler {
+	return &handler{
+		metricSink: metricSink,
+		nodes:      nodes,
+		pods:       pods,
+		authorizer: authz,
+		mapper:     mapper,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx, ok := h.mapper.Get(req)
+	if !ok {
+		http.Error(w, "no context found for request", http.StatusInternalServerError)
+		return
+	}
+	userInfo, ok := genericapirequest.UserFrom(ctx)
+	if !ok {
+		http.Error(w, "no user found for request", http.StatusUnauthorized)
+		return
+	}
+	latest := h.metricSink.GetLatestDataBatch()
+	if util.IsStale(h.metricSink, latest) {
+		http.Error(w, util.MetricsStaleMessage(latest), http.StatusServiceUnavailable)
+		return
+	}
+
+	since := req.URL.Query().Get("since")
+	var previous time.Time
+	if since != "" {
+		nanos, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid generation %q", since), http.StatusBadRequest)
+			return
+		}
+		previous = time.Unix(0, nanos)
+		if previous.After(latest.Timestamp) || previous.Before(h.metricSink.GetOldestTimestamp()) {
+			http.Error(w, fmt.Sprintf("generation %s is no longer stored, request again without since", since), http.StatusGone)
+			return
+		}
+	}
+
+	allowed, err := h.newFilter(userInfo)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to authorize: %v", err), http.StatusInternalServerError)
+		return
+	}
+	after, err := h.snapshot(ctx, latest.Timestamp, allowed)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	before := map[string]*snapshotEntry{}
+	var gone map[string]*snapshotEntry
+	if since != "" {
+		if before, err = h.snapshot(ctx, previous, allowed); err != nil {
+			writeError(w, err)
+			return
+		}
+		gone = batchSnapshot(h.metricSink.GetDataBatchAt(previous), allowed)
+	}
+	diff := getDiff(before, after, gone)
+	diff.Generation = util.ResourceVersion(latest)
+	diff.Timestamp = metav1.NewTime(latest.Timestamp)
+	diff.Since = since
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		glog.Errorf("Error while encoding batch diff: %v", err)
+	}
+}
+
+// filter tells whether the entry of a node or of a pod in a namespace may be served.
+type filter func(kind, namespace string) bool
+
+func (h *handler) newFilter(userInfo user.Info) (filter, error) {
+	nodes, err := util.CanList(h.authorizer, userInfo, "nodes", "")
+	if err != nil {
+		return nil, err
+	}
+	var namespaces *util.NamespaceFilter
+	if h.pods != nil {
+		if namespaces, err = util.NewNamespaceFilter(h.authorizer, userInfo); err != nil {
+			return nil, err
+		}
+	}
+	return func(kind, namespace string) bool {
+		if kind == KindNode {
+			return nodes
+		}
+		return namespaces != nil && namespaces.Allowed(namespace)
+	}, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if status, ok := err.(errors.APIStatus); ok {
+		code = int(status.Status().Code)
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// snapshotEntry is the entry of an object in a batch, with a fingerprint of its usage.
+type snapshotEntry struct {
+	entry       Entry
+	fingerprint string
+}
+
+// getDiff returns the changes from the entries served before to the ones served now.
+// Objects in gone which aren't served now are removed as well, as the storages no longer
+// know about deleted nodes and pods when serving an earlier batch.
+func getDiff(before, after, gone map[string]*snapshotEntry) *BatchDiff {
+	diff := &BatchDiff{
+		Added:   []Entry{},
+		Updated: []Entry{},
+		Removed: []Entry{},
+	}
+	for key, current := range after {
+		if old, found := before[key]; !found {
+			diff.Added = append(diff.Added, current.entry)
+		} else if old.fingerprint != current.fingerprint {
+			diff.Updated = append(diff.Updated, current.entry)
+		}
+	}
+	for key, old := range before {
+		if _, found := after[key]; !found {
+			diff.Removed = append(diff.Removed, removedEntry(old))
+		}
+	}
+	for key, old := range gone {
+		_, served := after[key]
+		_, removed := before[key]
+		if !served && !removed {
+			diff.Removed = append(diff.Removed, removedEntry(old))
+		}
+	}
+	for _, entries := range [][]Entry{diff.Added, diff.Updated, diff.Removed} {
+		sortEntries(entries)
+	}
+	return diff
+}
+
+func removedEntry(old *snapshotEntry) Entry {
+	return Entry{Kind: old.entry.Kind, Namespace: old.entry.Namespace, Name: old.entry.Name}
+}
+
+// snapshot returns the entries of the nodes and pods the storages serve from the batch at
+// the time, by kind, namespace and name.
+func (h *handler) snapshot(ctx genericapirequest.Context, timestamp time.Time, allowed filter) (map[string]*snapshotEntry, error) {
+	ctx = util.WithTime(genericapirequest.WithNamespace(ctx, metav1.NamespaceAll), timestamp)
+	result := map[string]*snapshotEntry{}
+	if allowed(KindNode, "") {
+		obj, err := h.nodes.List(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range obj.(*metrics.NodeMetricsList).Items {
+			result[KindNode+"/"+item.Name] = &snapshotEntry{
+				entry:       Entry{Kind: KindNode, Name: item.Name, Timestamp: newTime(item.Timestamp.Time), Usage: item.Usage},
+				fingerprint: fingerprint(item.Usage),
+			}
+		}
+	}
+	if h.pods == nil {
+		return result, nil
+	}
+	obj, err := h.pods.List(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range obj.(*metrics.PodMetricsList).Items {
+		if !allowed(KindPod, item.Namespace) {
+			continue
+		}
+		result[KindPod+"/"+item.Namespace+"/"+item.Name] = newPodEntry(item.Namespace, item.Name, item.Timestamp.Time, item.Containers)
+	}
+	return result, nil
+}
+
+// batchSnapshot returns the entries of the nodes and pods in the batch, withheld or not,
+// by kind, namespace and name. Only their keys are used.
+func batchSnapshot(batch *core.DataBatch, allowed filter) map[string]*snapshotEntry {
+	result := map[string]*snapshotEntry{}
+	if batch == nil {
+		return result
+	}
+	for _, ms := range batch.MetricSets {
+		switch ms.Labels[core.LabelMetricSetType.Key] {
+		case core.MetricSetTypeNode:
+			name := ms.Labels[core.LabelNodename.Key]
+			if allowed(KindNode, "") {
+				result[KindNode+"/"+name] = &snapshotEntry{entry: Entry{Kind: KindNode, Name: name}}
+			}
+		case core.MetricSetTypePodContainer:
+			namespace, pod := ms.Labels[core.LabelNamespaceName.Key], ms.Labels[core.LabelPodName.Key]
+			if allowed(KindPod, namespace) {
+				result[KindPod+"/"+namespace+"/"+pod] = &snapshotEntry{entry: Entry{Kind: KindPod, Namespace: namespace, Name: pod}}
+			}
+		}
+	}
+	return result
+}
+
+// newPodEntry returns the entry of the pod, with its containers sorted by name.
+func newPodEntry(namespace, name string, timestamp time.Time, containers []metrics.ContainerMetrics) *snapshotEntry {
+	sorted := make([]metrics.ContainerMetrics, len(containers))
+	copy(sorted, containers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	parts := make([]string, 0, len(sorted))
+	for _, c := range sorted {
+		parts = append(parts, c.Name+":"+fingerprint(c.Usage))
+	}
+	return &snapshotEntry{
+		entry:       Entry{Kind: KindPod, Namespace: namespace, Name: name, Timestamp: newTime(timestamp), Containers: sorted},
+		fingerprint: strings.Join(parts, ";"),
+	}
+}
+
+func newTime(t time.Time) *metav1.Time {
+	result := metav1.NewTime(t)
+	return &result
+}
+
+// fingerprint returns the resources and quantities of the usage in a canonical form.
+func fingerprint(usage metrics.ResourceList) string {
+	parts := make([]string, 0, len(usage))
+	for name, quantity := range usage {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, quantity.String()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
@@ -0,0 +1,255 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batchdiff
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	nodemetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/nodemetrics"
+	podmetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/podmetrics"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+func nodeMetrics(node string, cpu, mem int64) *core.MetricSet {
+	return &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+			core.LabelNodename.Key:      node,
+		},
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: mem},
+		},
+	}
+}
+
+func containerMetrics(namespace, pod, container string, cpu, mem int64) *core.MetricSet {
+	return &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+			core.LabelNamespaceName.Key: namespace,
+			core.LabelPodName.Key:       pod,
+			core.LabelContainerName.Key: container,
+		},
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: mem},
+		},
+	}
+}
+
+func newPod(namespace, name string, phase v1.PodPhase, started time.Time, containers ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, CreationTimestamp: metav1.NewTime(started)},
+		Status:     v1.PodStatus{Phase: phase, StartTime: &metav1.Time{Time: started}},
+	}
+	for _, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: c})
+	}
+	return pod
+}
+
+// testAuthorizer allows admin everything, and other users to list nodes and the pods in
+// namespace ns.
+func testAuthorizer(a authorizer.Attributes) (bool, string, error) {
+	if a.GetUser().GetName() == "admin" {
+		return true, "", nil
+	}
+	return a.GetResource() == "nodes" || a.GetNamespace() == "ns", "", nil
+}
+
+type testServer struct {
+	handler   http.Handler
+	sink      *metricsink.MetricSink
+	nodeStore cache.Indexer
+	podStore  cache.Indexer
+}
+
+// newTestServer returns the handler over the node and pod storages, with requests
+// authenticated as the user named in their X-Remote-User header. Pods which started less
+// than a minute before the batch are withheld.
+func newTestServer(t *testing.T, shortDuration, longDuration time.Duration) *testServer {
+	s := &testServer{
+		sink: metricsink.NewMetricSink(shortDuration, longDuration,
+			[]string{core.MetricCpuUsageRate.Name, core.MetricMemoryWorkingSet.Name}),
+		nodeStore: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		podStore:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+	}
+	nodes := nodemetricsstorage.NewStorage(metrics.Resource("nodes"), s.sink, v1listers.NewNodeLister(s.nodeStore), true, time.Minute, metricsutil.Shard{})
+	pods := podmetricsstorage.NewStorage(metrics.Resource("pods"), s.sink, v1listers.NewPodLister(s.podStore), false, nil, false, time.Minute, time.Minute, false, metricsutil.Shard{})
+	mapper := genericapirequest.NewRequestContextMapper()
+	handler := NewHandler(s.sink, nodes, pods, authorizer.AuthorizerFunc(testAuthorizer), mapper)
+	s.handler = genericapirequest.WithRequestContext(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if name := req.Header.Get("X-Remote-User"); name != "" {
+			ctx, _ := mapper.Get(req)
+			mapper.Update(req, genericapirequest.WithUser(ctx, &user.DefaultInfo{Name: name}))
+		}
+		handler.ServeHTTP(w, req)
+	}), mapper)
+	return s
+}
+
+func (s *testServer) addNodes(t *testing.T, names ...string) {
+	for _, name := range names {
+		require.NoError(t, s.nodeStore.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+}
+
+func (s *testServer) getDiff(t *testing.T, userName, query string) (*httptest.ResponseRecorder, *BatchDiff) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", Path+query, nil)
+	req.Header.Set("X-Remote-User", userName)
+	s.handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		return recorder, nil
+	}
+	diff := &BatchDiff{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), diff))
+	return recorder, diff
+}
+
+func TestBatchDiff(t *testing.T) {
+	s := newTestServer(t, time.Minute, time.Minute)
+	recorder, _ := s.getDiff(t, "dev", "")
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code, "no batch yet")
+
+	now := time.Now()
+	s.addNodes(t, "node1", "node2")
+	for _, pod := range []*v1.Pod{
+		newPod("ns", "web", v1.PodRunning, now.Add(-time.Hour), "c", "side"),
+		newPod("ns", "db", v1.PodRunning, now.Add(-time.Hour), "c"),
+		newPod("ns", "young", v1.PodRunning, now.Add(-80*time.Second), "c"),
+		newPod("ns", "done", v1.PodSucceeded, now.Add(-time.Hour), "c"),
+		newPod("other", "app", v1.PodRunning, now.Add(-time.Hour), "c"),
+	} {
+		require.NoError(t, s.podStore.Add(pod))
+	}
+	first := &core.DataBatch{
+		Timestamp: now.Add(-30 * time.Second),
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"):                     nodeMetrics("node1", 1000, 4000),
+			core.NodeKey("node2"):                     nodeMetrics("node2", 2000, 8000),
+			core.PodContainerKey("ns", "web", "c"):    containerMetrics("ns", "web", "c", 100, 1000),
+			core.PodContainerKey("ns", "web", "side"): containerMetrics("ns", "web", "side", 10, 100),
+			core.PodContainerKey("ns", "db", "c"):     containerMetrics("ns", "db", "c", 50, 500),
+			core.PodContainerKey("ns", "young", "c"):  containerMetrics("ns", "young", "c", 50, 500),
+			core.PodContainerKey("ns", "done", "c"):   containerMetrics("ns", "done", "c", 50, 500),
+			core.PodContainerKey("other", "app", "c"): containerMetrics("other", "app", "c", 50, 500),
+		},
+	}
+	s.sink.ExportData(first)
+	recorder, diff := s.getDiff(t, "dev", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, util.ResourceVersion(first), diff.Generation)
+	require.Len(t, diff.Added, 4, "everything served is added without since")
+	assert.Equal(t, "node1", diff.Added[0].Name)
+	assert.Equal(t, "db", diff.Added[2].Name)
+	assert.Equal(t, "web", diff.Added[3].Name)
+	require.Len(t, diff.Added[3].Containers, 2)
+	assert.Equal(t, "side", diff.Added[3].Containers[1].Name)
+
+	recorder, diff = s.getDiff(t, "admin", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Len(t, diff.Added, 5, "pods of all namespaces are served to admin")
+	assert.Equal(t, "other", diff.Added[4].Namespace)
+
+	require.NoError(t, s.nodeStore.Delete(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}))
+	s.addNodes(t, "node3")
+	second := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"):                     nodeMetrics("node1", 1000, 4000),
+			core.NodeKey("node3"):                     nodeMetrics("node3", 500, 1000),
+			core.PodContainerKey("ns", "web", "c"):    containerMetrics("ns", "web", "c", 100, 1000),
+			core.PodContainerKey("ns", "web", "side"): containerMetrics("ns", "web", "side", 20, 100),
+			core.PodContainerKey("ns", "db", "c"):     containerMetrics("ns", "db", "c", 50, 500),
+			core.PodContainerKey("ns", "young", "c"):  containerMetrics("ns", "young", "c", 50, 500),
+			core.PodContainerKey("other", "app", "c"): containerMetrics("other", "app", "c", 60, 500),
+		},
+	}
+	s.sink.ExportData(second)
+	recorder, diff = s.getDiff(t, "dev", "?since="+util.ResourceVersion(first))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, util.ResourceVersion(first), diff.Since)
+	assert.Equal(t, util.ResourceVersion(second), diff.Generation)
+	require.Len(t, diff.Added, 2)
+	assert.Equal(t, "node3", diff.Added[0].Name)
+	assert.Equal(t, "young", diff.Added[1].Name, "served once old enough")
+	require.Len(t, diff.Updated, 1, "unchanged usage and other namespaces are left out")
+	assert.Equal(t, "web", diff.Updated[0].Name)
+	require.Len(t, diff.Removed, 2)
+	assert.Equal(t, Entry{Kind: KindNode, Name: "node2"}, diff.Removed[0], "deleted nodes are removed")
+	assert.Equal(t, Entry{Kind: KindPod, Namespace: "ns", Name: "done"}, diff.Removed[1], "withheld before, gone now")
+
+	recorder, diff = s.getDiff(t, "dev", "?since="+diff.Generation)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, diff.Added)
+	assert.Empty(t, diff.Updated)
+	assert.Empty(t, diff.Removed)
+
+	recorder, _ = s.getDiff(t, "dev", "?since=1")
+	assert.Equal(t, http.StatusGone, recorder.Code, "generation no longer stored")
+	recorder, _ = s.getDiff(t, "dev", "?since=soon")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	recorder, _ = s.getDiff(t, "", "")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestBatchDiffLongStore(t *testing.T) {
+	s := newTestServer(t, time.Minute, 10*time.Minute)
+	s.addNodes(t, "node1", "node2")
+	now := time.Now()
+	first := &core.DataBatch{
+		Timestamp: now.Add(-3 * time.Minute),
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"): nodeMetrics("node1", 1000, 4000),
+		},
+	}
+	s.sink.ExportData(first)
+	s.sink.ExportData(&core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"): nodeMetrics("node1", 2000, 4000),
+			core.NodeKey("node2"): nodeMetrics("node2", 500, 1000),
+		},
+	})
+	require.Len(t, s.sink.GetShortStore(), 1)
+
+	recorder, diff := s.getDiff(t, "dev", "?since="+util.ResourceVersion(first))
+	require.Equal(t, http.StatusOK, recorder.Code, "served from the long store")
+	require.Len(t, diff.Added, 1)
+	assert.Equal(t, "node2", diff.Added[0].Name)
+	require.Len(t, diff.Updated, 1)
+	assert.Equal(t, "node1", diff.Updated[0].Name)
+	assert.Empty(t, diff.Removed)
+}
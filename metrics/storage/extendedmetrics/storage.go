@@ -0,0 +1,92 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extendedmetrics
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Name of the subresource of NodeMetrics serving the fields the Metrics API has no schema
+// for.
+const Subresource = "extended"
+
+// NodeMetrics is the usage of a node as served by the Metrics API, with the extended fields.
+type NodeMetrics struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Timestamp         metav1.Time          `json:"timestamp"`
+	Window            metav1.Duration      `json:"window"`
+	Usage             metrics.ResourceList `json:"usage"`
+	// Change of usage per minute over the retained batches, left out with less than two
+	// samples.
+	UsageTrend metrics.ResourceList `json:"usageTrend,omitempty"`
+	// Time the node booted as reported by its kubelet, and how long it had been up at the
+	// time of the usage. Usage of nodes which just rebooted is measured over counters
+	// which are still warming up. Left out if the kubelet doesn't report it.
+	StartTime *metav1.Time     `json:"startTime,omitempty"`
+	Uptime    *metav1.Duration `json:"uptime,omitempty"`
+}
+
+// NodeGetter returns the extended metrics of a node, or the error NodeMetrics are served
+// with.
+type NodeGetter interface {
+	GetExtended(ctx genericapirequest.Context, name string) (*NodeMetrics, error)
+}
+
+type storage struct {
+	getter NodeGetter
+}
+
+var _ rest.Connecter = &storage{}
+
+// NewNodeStorage returns the storage of the extended subresource of NodeMetrics, serving
+// the extended metrics of the node got from the getter.
+func NewNodeStorage(getter NodeGetter) rest.Storage {
+	return &storage{getter: getter}
+}
+
+func (s *storage) New() runtime.Object {
+	return &metrics.NodeMetrics{}
+}
+
+func (s *storage) NewConnectOptions() (runtime.Object, bool, string) {
+	return nil, false, ""
+}
+
+func (s *storage) ConnectMethods() []string {
+	return []string{http.MethodGet}
+}
+
+func (s *storage) Connect(ctx genericapirequest.Context, name string, _ runtime.Object, responder rest.Responder) (http.Handler, error) {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		nodeMetrics, err := s.getter.GetExtended(ctx, name)
+		if err != nil {
+			responder.Error(err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(nodeMetrics); err != nil {
+			glog.Errorf("Error while encoding extended node metrics: %v", err)
+		}
+	}), nil
+}
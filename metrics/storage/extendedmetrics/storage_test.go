@@ -0,0 +1,86 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extendedmetrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+type fakeGetter map[string]*NodeMetrics
+
+func (g fakeGetter) GetExtended(ctx genericapirequest.Context, name string) (*NodeMetrics, error) {
+	if nodeMetrics, found := g[name]; found {
+		return nodeMetrics, nil
+	}
+	return nil, errors.NewNotFound(metrics.Resource("nodes"), name)
+}
+
+type fakeResponder struct {
+	err error
+}
+
+func (r *fakeResponder) Object(statusCode int, obj runtime.Object) {}
+
+func (r *fakeResponder) Error(err error) {
+	r.err = err
+}
+
+func TestNodeStorage(t *testing.T) {
+	startTime := metav1.NewTime(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage := NewNodeStorage(fakeGetter{"n1": {
+		ObjectMeta: metav1.ObjectMeta{Name: "n1"},
+		Usage:      metrics.ResourceList{metrics.ResourceName(v1.ResourceCPU): resource.MustParse("1")},
+		UsageTrend: metrics.ResourceList{metrics.ResourceName(v1.ResourceCPU): resource.MustParse("-5m")},
+		StartTime:  &startTime,
+		Uptime:     &metav1.Duration{Duration: 90 * time.Second},
+	}})
+	connect := func(name string) (*httptest.ResponseRecorder, error) {
+		responder := &fakeResponder{}
+		handler, err := storage.(rest.Connecter).Connect(genericapirequest.NewContext(), name, nil, responder)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/extended", nil))
+		return rec, responder.err
+	}
+
+	rec, err := connect("n1")
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var served map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Equal(t, map[string]interface{}{"cpu": "-5m"}, served["usageTrend"])
+	assert.Equal(t, map[string]interface{}{"cpu": "1"}, served["usage"])
+	assert.Equal(t, "2017-01-01T00:00:00Z", served["startTime"])
+	assert.Equal(t, "1m30s", served["uptime"])
+
+	_, err = connect("n2")
+	assert.True(t, errors.IsNotFound(err))
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/bus"
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+// Usage of a node or container at one scrape.
+type point struct {
+	timestamp time.Time
+	// Millicores and bytes.
+	cpu    int64
+	memory int64
+}
+
+// ring holds the last points of a node or container, overwriting the oldest once full.
+type ring struct {
+	// Series key of the points, to start over when a pod is recreated under the same name.
+	series string
+	points []point
+	next   int
+	full   bool
+}
+
+func newRing(series string, size int) *ring {
+	return &ring{series: series, points: make([]point, size)}
+}
+
+func (this *ring) add(p point) {
+	this.points[this.next] = p
+	this.next++
+	if this.next == len(this.points) {
+		this.next = 0
+		this.full = true
+	}
+}
+
+// latest returns the timestamp of the newest point.
+func (this *ring) latest() time.Time {
+	i := this.next - 1
+	if i < 0 {
+		i = len(this.points) - 1
+	}
+	return this.points[i].timestamp
+}
+
+// since returns the points after the time, oldest first.
+func (this *ring) since(start time.Time) []point {
+	var ordered []point
+	if this.full {
+		ordered = append(append(ordered, this.points[this.next:]...), this.points[:this.next]...)
+	} else {
+		ordered = this.points[:this.next]
+	}
+	result := make([]point, 0, len(ordered))
+	for _, p := range ordered {
+		if p.timestamp.After(start) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Buffer keeps the last points of the usage of every node and pod container, so their
+// history is served independently of the retention of the metric sink.
+type Buffer struct {
+	lock sync.RWMutex
+	// Points kept per node and container.
+	size int
+	// How long a node or container is kept without new points.
+	maxAge time.Duration
+	nodes  map[string]*ring
+	// Containers by pod key and container name.
+	pods map[string]map[string]*ring
+}
+
+// NewBuffer returns a buffer of the last size points per node and container. Those without
+// points for maxAge are dropped.
+func NewBuffer(size int, maxAge time.Duration) *Buffer {
+	return &Buffer{
+		size:   size,
+		maxAge: maxAge,
+		nodes:  map[string]*ring{},
+		pods:   map[string]map[string]*ring{},
+	}
+}
+
+// Subscribe fills the buffer with the batches exported to the sinks.
+func (this *Buffer) Subscribe(eventBus *bus.Bus) {
+	eventBus.Subscribe("history_buffer", bus.TopicDataBatch, func(event *bus.Event) {
+		this.add(event.Batch)
+	})
+}
+
+func (this *Buffer) add(batch *core.DataBatch) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for key, ms := range batch.MetricSets {
+		cpu, found := ms.MetricValues[core.MetricCpuUsageRate.Name]
+		if !found {
+			continue
+		}
+		memory, found := ms.MetricValues[core.MetricMemoryWorkingSet.Name]
+		if !found {
+			continue
+		}
+		p := point{timestamp: batch.Timestamp, cpu: cpu.IntValue, memory: memory.IntValue}
+		switch ms.Labels[core.LabelMetricSetType.Key] {
+		case core.MetricSetTypeNode:
+			node := ms.Labels[core.LabelNodename.Key]
+			if this.nodes[node] == nil {
+				this.nodes[node] = newRing(key, this.size)
+			}
+			this.nodes[node].add(p)
+		case core.MetricSetTypePodContainer:
+			podKey := core.PodKey(ms.Labels[core.LabelNamespaceName.Key], ms.Labels[core.LabelPodName.Key])
+			containers := this.pods[podKey]
+			if containers == nil {
+				containers = map[string]*ring{}
+				this.pods[podKey] = containers
+			}
+			container, series := ms.Labels[core.LabelContainerName.Key], core.SeriesKey(key, ms)
+			if r := containers[container]; r == nil || r.series != series {
+				containers[container] = newRing(series, this.size)
+			}
+			containers[container].add(p)
+		}
+	}
+	this.evict(batch.Timestamp.Add(-this.maxAge))
+}
+
+func (this *Buffer) evict(cutoff time.Time) {
+	for node, r := range this.nodes {
+		if r.latest().Before(cutoff) {
+			delete(this.nodes, node)
+		}
+	}
+	for podKey, containers := range this.pods {
+		for container, r := range containers {
+			if r.latest().Before(cutoff) {
+				delete(containers, container)
+			}
+		}
+		if len(containers) == 0 {
+			delete(this.pods, podKey)
+		}
+	}
+}
+
+// nodePoints returns the points of the node after the time, oldest first.
+func (this *Buffer) nodePoints(node string, start time.Time) []point {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	r, found := this.nodes[node]
+	if !found {
+		return nil
+	}
+	return r.since(start)
+}
+
+// containerPoints returns the points of the containers of the pod after the time, by
+// container name, and the names sorted.
+func (this *Buffer) containerPoints(namespace, pod string, start time.Time) (map[string][]point, []string) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	containers := this.pods[core.PodKey(namespace, pod)]
+	result := make(map[string][]point, len(containers))
+	names := make([]string, 0, len(containers))
+	for container, r := range containers {
+		result[container] = r.since(start)
+		names = append(names, container)
+	}
+	sort.Strings(names)
+	return result, names
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+func TestRing(t *testing.T) {
+	now := time.Now()
+	r := newRing("series", 3)
+	for i := 0; i < 5; i++ {
+		r.add(point{timestamp: now.Add(time.Duration(i) * time.Minute), cpu: int64(i)})
+	}
+	points := r.since(time.Time{})
+	if assert.Len(t, points, 3, "the oldest points are overwritten") {
+		assert.Equal(t, []int64{2, 3, 4}, []int64{points[0].cpu, points[1].cpu, points[2].cpu})
+	}
+	assert.Equal(t, now.Add(4*time.Minute), r.latest())
+	assert.Len(t, r.since(now.Add(3*time.Minute)), 1)
+}
+
+func TestBufferRecreatedPod(t *testing.T) {
+	now := time.Now()
+	buffer := NewBuffer(10, 15*time.Minute)
+	key := core.PodContainerKey("ns", "p", "c")
+	buffer.add(&core.DataBatch{Timestamp: now.Add(-time.Minute), MetricSets: map[string]*core.MetricSet{
+		key: metricSet(core.MetricSetTypePodContainer, "ns", "p", "c", "old", 100, 1000),
+	}})
+	buffer.add(&core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{
+		key: metricSet(core.MetricSetTypePodContainer, "ns", "p", "c", "new", 200, 2000),
+	}})
+
+	points, containers := buffer.containerPoints("ns", "p", time.Time{})
+	assert.Equal(t, []string{"c"}, containers)
+	if assert.Len(t, points["c"], 1, "the points of the deleted pod are dropped") {
+		assert.Equal(t, int64(200), points["c"][0].cpu)
+	}
+}
+
+func TestBufferEviction(t *testing.T) {
+	now := time.Now()
+	buffer := NewBuffer(10, 5*time.Minute)
+	buffer.add(&core.DataBatch{Timestamp: now.Add(-10 * time.Minute), MetricSets: map[string]*core.MetricSet{
+		core.NodeKey("n1"):                   metricSet(core.MetricSetTypeNode, "", "", "", "", 1000, 10000),
+		core.PodContainerKey("ns", "p", "c"): metricSet(core.MetricSetTypePodContainer, "ns", "p", "c", "uid", 100, 1000),
+	}})
+	buffer.add(&core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{}})
+
+	assert.Empty(t, buffer.nodePoints("n1", time.Time{}))
+	points, containers := buffer.containerPoints("ns", "p", time.Time{})
+	assert.Empty(t, points)
+	assert.Empty(t, containers)
+	assert.Empty(t, buffer.pods, "pods without containers are dropped")
+}
+
+func TestBufferIncompleteMetricSets(t *testing.T) {
+	buffer := NewBuffer(10, 5*time.Minute)
+	ms := metricSet(core.MetricSetTypeNode, "", "", "", "", 1000, 10000)
+	delete(ms.MetricValues, core.MetricMemoryWorkingSet.Name)
+	buffer.add(&core.DataBatch{Timestamp: time.Now(), MetricSets: map[string]*core.MetricSet{core.NodeKey("n1"): ms}})
+
+	assert.Empty(t, buffer.nodePoints("n1", time.Time{}), "points need both cpu and memory")
+}
@@ -0,0 +1,201 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Name of the subresource of NodeMetrics and PodMetrics serving their recent usage.
+const Subresource = "history"
+
+// Usage of a node or pod at one scrape.
+type MetricsPoint struct {
+	Timestamp metav1.Time `json:"timestamp"`
+	// Usage of the node, or of the containers of the pod.
+	Usage      metrics.ResourceList       `json:"usage,omitempty"`
+	Containers []metrics.ContainerMetrics `json:"containers,omitempty"`
+}
+
+// The most recent points of a node or pod, oldest first.
+type MetricsHistory struct {
+	Kind      string          `json:"kind"`
+	Namespace string          `json:"namespace,omitempty"`
+	Name      string          `json:"name"`
+	Window    metav1.Duration `json:"window,omitempty"`
+	Points    []MetricsPoint  `json:"points"`
+}
+
+type storage struct {
+	buffer *Buffer
+	// Whether the history of pods, or of nodes, is served.
+	pods bool
+}
+
+var _ rest.Connecter = &storage{}
+
+// NewNodeStorage returns the storage of the history subresource of NodeMetrics, serving
+// the last points of the node in the buffer. They can be limited with the points query
+// parameter, and to those within the window parameter, a duration.
+func NewNodeStorage(buffer *Buffer) rest.Storage {
+	return &storage{buffer: buffer}
+}
+
+// NewPodStorage returns the storage of the history subresource of PodMetrics, serving the
+// last points of the containers of the pod like NewNodeStorage.
+func NewPodStorage(buffer *Buffer) rest.Storage {
+	return &storage{buffer: buffer, pods: true}
+}
+
+func (s *storage) New() runtime.Object {
+	if s.pods {
+		return &metrics.PodMetrics{}
+	}
+	return &metrics.NodeMetrics{}
+}
+
+func (s *storage) NewConnectOptions() (runtime.Object, bool, string) {
+	return nil, false, ""
+}
+
+func (s *storage) ConnectMethods() []string {
+	return []string{http.MethodGet}
+}
+
+func (s *storage) Connect(ctx genericapirequest.Context, name string, _ runtime.Object, responder rest.Responder) (http.Handler, error) {
+	namespace := genericapirequest.NamespaceValue(ctx)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		points, window, err := parseLimits(s.buffer.size, query.Get("points"), query.Get("window"))
+		if err != nil {
+			responder.Error(errors.NewBadRequest(err.Error()))
+			return
+		}
+		var start time.Time
+		if window > 0 {
+			start = time.Now().Add(-window)
+		}
+		var history *MetricsHistory
+		if s.pods {
+			history = s.getPodHistory(namespace, name, start)
+		} else {
+			history = s.getNodeHistory(name, start)
+		}
+		if len(history.Points) == 0 {
+			responder.Error(errors.NewNotFound(s.groupResource(), name))
+			return
+		}
+		if len(history.Points) > points {
+			history.Points = history.Points[len(history.Points)-points:]
+		}
+		history.Window = metav1.Duration{Duration: window}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(history); err != nil {
+			glog.Errorf("Error while encoding metrics history: %v", err)
+		}
+	}), nil
+}
+
+func (s *storage) groupResource() schema.GroupResource {
+	if s.pods {
+		return metrics.Resource("pods/" + Subresource)
+	}
+	return metrics.Resource("nodes/" + Subresource)
+}
+
+// parseLimits returns the number of points, at most maxPoints, and the window, zero if the
+// points aren't limited by age.
+func parseLimits(maxPoints int, pointsParam, windowParam string) (int, time.Duration, error) {
+	points := maxPoints
+	if pointsParam != "" {
+		value, err := strconv.Atoi(pointsParam)
+		if err != nil || value < 1 {
+			return 0, 0, fmt.Errorf("invalid points %q, expected a positive integer", pointsParam)
+		}
+		if value < points {
+			points = value
+		}
+	}
+	var window time.Duration
+	if windowParam != "" {
+		value, err := time.ParseDuration(windowParam)
+		if err != nil || value <= 0 {
+			return 0, 0, fmt.Errorf("invalid window %q, expected a positive duration", windowParam)
+		}
+		window = value
+	}
+	return points, window, nil
+}
+
+func (s *storage) getNodeHistory(node string, start time.Time) *MetricsHistory {
+	history := &MetricsHistory{Kind: "Node", Name: node, Points: []MetricsPoint{}}
+	for _, p := range s.buffer.nodePoints(node, start) {
+		history.Points = append(history.Points, MetricsPoint{
+			Timestamp: metav1.NewTime(p.timestamp),
+			Usage:     usage(p),
+		})
+	}
+	return history
+}
+
+func (s *storage) getPodHistory(namespace, pod string, start time.Time) *MetricsHistory {
+	history := &MetricsHistory{Kind: "Pod", Namespace: namespace, Name: pod, Points: []MetricsPoint{}}
+	containerPoints, containers := s.buffer.containerPoints(namespace, pod, start)
+
+	points := map[time.Time]*MetricsPoint{}
+	for _, container := range containers {
+		for _, p := range containerPoints[container] {
+			mp, found := points[p.timestamp]
+			if !found {
+				mp = &MetricsPoint{Timestamp: metav1.NewTime(p.timestamp)}
+				points[p.timestamp] = mp
+			}
+			mp.Containers = append(mp.Containers, metrics.ContainerMetrics{Name: container, Usage: usage(p)})
+		}
+	}
+	timestamps := make([]time.Time, 0, len(points))
+	for timestamp := range points {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i].Before(timestamps[j]) })
+	for _, timestamp := range timestamps {
+		history.Points = append(history.Points, *points[timestamp])
+	}
+	return history
+}
+
+func usage(p point) metrics.ResourceList {
+	return metrics.ResourceList{
+		metrics.ResourceName(v1.ResourceCPU):    *resource.NewMilliQuantity(p.cpu, resource.DecimalSI),
+		metrics.ResourceName(v1.ResourceMemory): *resource.NewQuantity(p.memory, resource.BinarySI),
+	}
+}
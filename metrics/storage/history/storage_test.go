@@ -0,0 +1,138 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+func metricSet(setType, namespace, pod, container, uid string, cpu, mem int64) *core.MetricSet {
+	return &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: setType,
+			core.LabelNodename.Key:      "n1",
+			core.LabelNamespaceName.Key: namespace,
+			core.LabelPodName.Key:       pod,
+			core.LabelPodId.Key:         uid,
+			core.LabelContainerName.Key: container,
+		},
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: mem},
+		},
+	}
+}
+
+func newTestBuffer(now time.Time, size int) *Buffer {
+	buffer := NewBuffer(size, 15*time.Minute)
+	for i := int64(0); i < 4; i++ {
+		buffer.add(&core.DataBatch{
+			Timestamp: now.Add(time.Duration(i-3) * time.Minute),
+			MetricSets: map[string]*core.MetricSet{
+				core.NodeKey("n1"):                    metricSet(core.MetricSetTypeNode, "", "", "", "", 1000+i, 10000),
+				core.PodContainerKey("ns", "p", "c1"): metricSet(core.MetricSetTypePodContainer, "ns", "p", "c1", "uid", 100+i, 1000),
+				core.PodContainerKey("ns", "p", "c2"): metricSet(core.MetricSetTypePodContainer, "ns", "p", "c2", "uid", 10+i, 100),
+			},
+		})
+	}
+	return buffer
+}
+
+type fakeResponder struct {
+	err error
+}
+
+func (r *fakeResponder) Object(statusCode int, obj runtime.Object) {}
+
+func (r *fakeResponder) Error(err error) {
+	r.err = err
+}
+
+func getHistory(t *testing.T, storage rest.Storage, namespace, name, query string) (*MetricsHistory, error) {
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), namespace)
+	responder := &fakeResponder{}
+	handler, err := storage.(rest.Connecter).Connect(ctx, name, nil, responder)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/history?"+query, nil))
+	if responder.err != nil {
+		return nil, responder.err
+	}
+	require.Equal(t, http.StatusOK, rec.Code)
+	history := &MetricsHistory{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), history))
+	return history, nil
+}
+
+func TestNodeHistory(t *testing.T) {
+	now := time.Now()
+	storage := NewNodeStorage(newTestBuffer(now, 3))
+
+	history, err := getHistory(t, storage, "", "n1", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Node", history.Kind)
+	require.Len(t, history.Points, 3, "limited to the buffer size")
+	cpu := history.Points[0].Usage["cpu"]
+	assert.Equal(t, int64(1001), cpu.MilliValue(), "oldest point first")
+	cpu = history.Points[2].Usage["cpu"]
+	assert.Equal(t, int64(1003), cpu.MilliValue())
+
+	history, _ = getHistory(t, storage, "", "n1", "points=1")
+	require.Len(t, history.Points, 1)
+	assert.Equal(t, now.Unix(), history.Points[0].Timestamp.Unix())
+
+	history, _ = getHistory(t, storage, "", "n1", "window=90s")
+	assert.Len(t, history.Points, 2)
+
+	_, err = getHistory(t, storage, "", "n2", "")
+	assert.True(t, errors.IsNotFound(err))
+	_, err = getHistory(t, storage, "", "n1", "points=0")
+	assert.True(t, errors.IsBadRequest(err))
+	_, err = getHistory(t, storage, "", "n1", "window=-1m")
+	assert.True(t, errors.IsBadRequest(err))
+}
+
+func TestPodHistory(t *testing.T) {
+	storage := NewPodStorage(newTestBuffer(time.Now(), 10))
+
+	history, err := getHistory(t, storage, "ns", "p", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Pod", history.Kind)
+	assert.Equal(t, "ns", history.Namespace)
+	require.Len(t, history.Points, 4)
+	last := history.Points[3]
+	require.Len(t, last.Containers, 2)
+	assert.Equal(t, "c1", last.Containers[0].Name)
+	cpu := last.Containers[0].Usage["cpu"]
+	assert.Equal(t, int64(103), cpu.MilliValue())
+	mem := last.Containers[1].Usage["memory"]
+	assert.Equal(t, int64(100), mem.Value())
+
+	_, err = getHistory(t, storage, "other", "p", "")
+	assert.True(t, errors.IsNotFound(err), "the pod is looked up in the namespace of the request")
+}
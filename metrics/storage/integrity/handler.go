@@ -0,0 +1,163 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integrity
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Path under which the hashes of the stored batches are served.
+const Path = "/debug/integrity"
+
+// BatchHashes holds the content hashes of the points stored for one scrape cycle.
+type BatchHashes struct {
+	Timestamp metav1.Time `json:"timestamp"`
+	// Hash of all node hashes, in node order.
+	Hash string `json:"hash"`
+	// Hash of the metric sets of every node, covering the node, its pods and containers.
+	Nodes map[string]string `json:"nodes"`
+}
+
+// ReplicaHashes lists the hashes of the batches held by one replica, oldest first.
+// Replicas serving the same data report the same hashes for the same timestamps.
+type ReplicaHashes struct {
+	Replica string        `json:"replica"`
+	Batches []BatchHashes `json:"batches"`
+}
+
+type handler struct {
+	metricSink *metricsink.MetricSink
+	replica    string
+}
+
+// NewHandler returns a handler serving the content hashes of the batches kept by the sink,
+// identified by the host name of the replica.
+func NewHandler(metricSink *metricsink.MetricSink) http.Handler {
+	replica, err := os.Hostname()
+	if err != nil {
+		glog.Warningf("Failed to get the host name: %v", err)
+	}
+	return &handler{
+		metricSink: metricSink,
+		replica:    replica,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	res := &ReplicaHashes{Replica: h.replica, Batches: []BatchHashes{}}
+	for _, batch := range h.metricSink.GetShortStore() {
+		res.Batches = append(res.Batches, getBatchHashes(batch))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		glog.Errorf("Error while encoding batch hashes: %v", err)
+	}
+}
+
+// getBatchHashes hashes the metric sets of the batch by node. Metric sets which don't
+// belong to a node, like the namespace and cluster aggregates, are derived from the
+// others and left out.
+func getBatchHashes(batch *core.DataBatch) BatchHashes {
+	keysByNode := make(map[string][]string)
+	for key, ms := range batch.MetricSets {
+		if node := ms.Labels[core.LabelNodename.Key]; node != "" {
+			keysByNode[node] = append(keysByNode[node], key)
+		}
+	}
+
+	res := BatchHashes{
+		Timestamp: metav1.NewTime(batch.Timestamp),
+		Nodes:     make(map[string]string, len(keysByNode)),
+	}
+	nodes := make([]string, 0, len(keysByNode))
+	for node, keys := range keysByNode {
+		sort.Strings(keys)
+		h := sha256.New()
+		for _, key := range keys {
+			hashMetricSet(h, key, batch.MetricSets[key])
+		}
+		res.Nodes[node] = hex.EncodeToString(h.Sum(nil))
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	h := sha256.New()
+	for _, node := range nodes {
+		writeString(h, node)
+		writeString(h, res.Nodes[node])
+	}
+	res.Hash = hex.EncodeToString(h.Sum(nil))
+	return res
+}
+
+// hashMetricSet writes the key, scrape time and values of the metric set to the hash, in
+// an order independent of map iteration.
+func hashMetricSet(h hash.Hash, key string, ms *core.MetricSet) {
+	writeString(h, key)
+	writeInt(h, ms.ScrapeTime.UnixNano())
+
+	names := make([]string, 0, len(ms.MetricValues))
+	for name := range ms.MetricValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeString(h, name)
+		writeValue(h, ms.MetricValues[name])
+	}
+
+	labeled := make([]string, 0, len(ms.LabeledMetrics))
+	values := make(map[string]core.MetricValue, len(ms.LabeledMetrics))
+	for _, metric := range ms.LabeledMetrics {
+		id := metric.Name + "{" + util.LabelsToString(metric.Labels) + "}"
+		labeled = append(labeled, id)
+		values[id] = metric.MetricValue
+	}
+	sort.Strings(labeled)
+	for _, id := range labeled {
+		writeString(h, id)
+		writeValue(h, values[id])
+	}
+}
+
+func writeValue(h hash.Hash, value core.MetricValue) {
+	writeInt(h, value.IntValue)
+	writeInt(h, int64(math.Float32bits(value.FloatValue)))
+}
+
+func writeString(h hash.Hash, s string) {
+	writeInt(h, int64(len(s)))
+	h.Write([]byte(s))
+}
+
+func writeInt(h hash.Hash, v int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(v))
+	h.Write(buf[:])
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integrity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+)
+
+func testBatch(timestamp time.Time, cpu int64) *core.DataBatch {
+	metricSet := func(typ string, cpu int64) *core.MetricSet {
+		return &core.MetricSet{
+			ScrapeTime: timestamp,
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: typ,
+				core.LabelNodename.Key:      "n1",
+			},
+			MetricValues: map[string]core.MetricValue{
+				core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+				core.MetricMemoryWorkingSet.Name: {IntValue: 1000},
+			},
+			LabeledMetrics: []core.LabeledMetric{{
+				Name:        core.MetricFilesystemUsage.Name,
+				Labels:      map[string]string{core.LabelResourceID.Key: "/"},
+				MetricValue: core.MetricValue{IntValue: 10},
+			}},
+		}
+	}
+	return &core.DataBatch{
+		Timestamp: timestamp,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("n1"):                        metricSet(core.MetricSetTypeNode, cpu),
+			core.PodContainerKey("ns1", "pod1", "c1"): metricSet(core.MetricSetTypePodContainer, 10),
+			core.ClusterKey(): {
+				Labels:       map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypeCluster},
+				MetricValues: map[string]core.MetricValue{core.MetricCpuUsageRate.Name: {IntValue: cpu}},
+			},
+		},
+	}
+}
+
+func TestGetBatchHashes(t *testing.T) {
+	now := time.Now()
+	hashes := getBatchHashes(testBatch(now, 100))
+	require.Len(t, hashes.Nodes, 1)
+	assert.NotEmpty(t, hashes.Nodes["n1"])
+	assert.Equal(t, hashes, getBatchHashes(testBatch(now, 100)), "same content")
+
+	changed := getBatchHashes(testBatch(now, 200))
+	assert.NotEqual(t, hashes.Nodes["n1"], changed.Nodes["n1"])
+	assert.NotEqual(t, hashes.Hash, changed.Hash)
+
+	rescraped := getBatchHashes(testBatch(now.Add(time.Second), 100))
+	assert.NotEqual(t, hashes.Nodes["n1"], rescraped.Nodes["n1"], "different scrape time")
+}
+
+func TestHandler(t *testing.T) {
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, []string{})
+	now := time.Now()
+	metricSink.ExportData(testBatch(now.Add(-time.Second), 100))
+	metricSink.ExportData(testBatch(now, 200))
+
+	rec := httptest.NewRecorder()
+	NewHandler(metricSink).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	res := &ReplicaHashes{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+	require.Len(t, res.Batches, 2)
+	assert.True(t, res.Batches[1].Timestamp.After(res.Batches[0].Timestamp.Time))
+	assert.Equal(t, getBatchHashes(testBatch(now, 200)).Hash, res.Batches[1].Hash)
+}
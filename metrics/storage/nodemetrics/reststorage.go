@@ -16,13 +16,17 @@ package app
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/golang/glog"
 
 	"github.com/kubernetes-incubator/metrics-server/metrics/core"
 	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/extendedmetrics"
 	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
@@ -41,18 +45,29 @@ type MetricStorage struct {
 	groupResource schema.GroupResource
 	metricSink    *metricsink.MetricSink
 	nodeLister    v1listers.NodeLister
+	// Whether LIST requests serve unschedulable nodes, unless the request says otherwise.
+	listUnschedulable bool
+	// Interval at which nodes are scraped, the expected window of the latest samples.
+	metricResolution time.Duration
+	// Shard of the nodes served, the ones scraped by this instance.
+	shard metricsutil.Shard
 }
 
 var _ rest.KindProvider = &MetricStorage{}
 var _ rest.Storage = &MetricStorage{}
 var _ rest.Getter = &MetricStorage{}
 var _ rest.Lister = &MetricStorage{}
+var _ extendedmetrics.NodeGetter = &MetricStorage{}
 
-func NewStorage(groupResource schema.GroupResource, metricSink *metricsink.MetricSink, nodeLister v1listers.NodeLister) *MetricStorage {
+func NewStorage(groupResource schema.GroupResource, metricSink *metricsink.MetricSink, nodeLister v1listers.NodeLister,
+	listUnschedulable bool, metricResolution time.Duration, shard metricsutil.Shard) *MetricStorage {
 	return &MetricStorage{
-		groupResource: groupResource,
-		metricSink:    metricSink,
-		nodeLister:    nodeLister,
+		groupResource:     groupResource,
+		metricSink:        metricSink,
+		nodeLister:        nodeLister,
+		listUnschedulable: listUnschedulable,
+		metricResolution:  metricResolution,
+		shard:             shard,
 	}
 }
 
@@ -72,12 +87,41 @@ func (m *MetricStorage) NewList() runtime.Object {
 }
 
 // Lister interface
+// Items are sorted by name. The list and its items carry the resourceVersion of the
+// batch they were served from, which only changes when new metrics are collected.
+// Unschedulable nodes are left out if listUnschedulable isn't set, or the request asks so.
 func (m *MetricStorage) List(ctx genericapirequest.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	res := &metrics.NodeMetricsList{}
+	resourceVersion, err := m.Visit(ctx, options, func(item *metrics.NodeMetrics) error {
+		res.Items = append(res.Items, *item)
+		return nil
+	})
+	if err != nil {
+		return &metrics.NodeMetricsList{}, err
+	}
+	res.ResourceVersion = resourceVersion
+	return res, nil
+}
+
+// Visit calls fn with the items of the list, in order, as soon as each is built, so that
+// they can be streamed without building the whole list. It returns the resourceVersion of
+// the list, or the first error of fn.
+func (m *MetricStorage) Visit(ctx genericapirequest.Context, options *metainternalversion.ListOptions, fn func(*metrics.NodeMetrics) error) (string, error) {
 	labelSelector := labels.Everything()
 	if options != nil && options.LabelSelector != nil {
 		labelSelector = options.LabelSelector
 	}
+	includeUnschedulable, found := util.IncludeUnschedulableFrom(ctx)
+	if !found {
+		includeUnschedulable = m.listUnschedulable
+	}
 	nodes, err := m.nodeLister.ListWithPredicate(func(node *v1.Node) bool {
+		if m.listExclusionReason(node, includeUnschedulable) != "" {
+			return false
+		}
+		if !util.MatchesFieldSelector(options, "", node.Name) {
+			return false
+		}
 		if labelSelector.Empty() {
 			return true
 		}
@@ -86,34 +130,205 @@ func (m *MetricStorage) List(ctx genericapirequest.Context, options *metainterna
 	if err != nil {
 		errMsg := fmt.Errorf("Error while listing nodes: %v", err)
 		glog.Error(errMsg)
-		return &metrics.NodeMetricsList{}, errMsg
+		return "", errMsg
+	}
+
+	batch, window := util.GetDataBatch(ctx, m.metricSink)
+	if util.IsStaleFor(ctx, m.metricSink, batch) {
+		return "", util.NewMetricsStaleError(m.groupResource, "", batch)
+	}
+
+	selection := util.FieldSelectionFrom(ctx)
+	visit := func(item *metrics.NodeMetrics) error {
+		if selection != nil {
+			selection.FilterNodeMetrics(item)
+		}
+		return fn(item)
+	}
+	if label := util.GroupByFrom(ctx); label != "" {
+		grouped, err := m.getGroupedNodeMetrics(batch, window, nodes, label)
+		if err != nil {
+			return "", err
+		}
+		for i := range grouped.Items {
+			if err := visit(&grouped.Items[i]); err != nil {
+				return "", err
+			}
+		}
+		return util.ResourceVersion(batch), nil
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	keys := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		keys = append(keys, core.NodeKey(node.Name))
+	}
+	trends := util.GetUsageTrends(m.metricSink, batch, keys)
+	for _, node := range nodes {
+		item := m.getNodeMetrics(batch, window, node.Name)
+		if item == nil {
+			continue
+		}
+		key := core.NodeKey(node.Name)
+		if trend, found := trends[key]; found {
+			util.AddUsageTrendAnnotation(&item.ObjectMeta, trend)
+		}
+		if util.WindowFrom(ctx) == 0 {
+			m.addEffectiveWindow(batch, item)
+		}
+		if err := visit(item); err != nil {
+			return "", err
+		}
 	}
+	return util.ResourceVersion(batch), nil
+}
 
-	res := metrics.NodeMetricsList{}
+// getGroupedNodeMetrics returns one item per value of the label, named after the value,
+// with the total usage of the nodes carrying it. Nodes without the label are left out.
+// It fails if a node carrying the label has no metrics, rather than serving a total
+// which leaves it out.
+func (m *MetricStorage) getGroupedNodeMetrics(batch *core.DataBatch, window time.Duration, nodes []*v1.Node, label string) (*metrics.NodeMetricsList, error) {
+	groups := make(map[string]*metrics.NodeMetrics)
+	counts := make(map[string]int)
 	for _, node := range nodes {
-		if m := m.getNodeMetrics(node.Name); m != nil {
-			res.Items = append(res.Items, *m)
+		value, found := node.Labels[label]
+		if !found {
+			continue
 		}
+		nodeMetrics := m.getNodeMetrics(batch, window, node.Name)
+		if nodeMetrics == nil {
+			return nil, errors.NewServiceUnavailable(fmt.Sprintf("unable to aggregate the usage of the nodes with %s=%s: unable to fetch metrics from node %s",
+				label, value, node.Name))
+		}
+		group, found := groups[value]
+		if !found {
+			group = &metrics.NodeMetrics{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              value,
+					CreationTimestamp: metav1.NewTime(batch.Timestamp),
+					ResourceVersion:   util.ResourceVersion(batch),
+					Labels:            map[string]string{label: value},
+				},
+				Timestamp: nodeMetrics.Timestamp,
+				Window:    nodeMetrics.Window,
+				Usage:     metrics.ResourceList{},
+			}
+			groups[value] = group
+		}
+		util.AddResourceList(group.Usage, nodeMetrics.Usage)
+		counts[value]++
+	}
+
+	res := &metrics.NodeMetricsList{
+		ListMeta: metav1.ListMeta{ResourceVersion: util.ResourceVersion(batch)},
+		Items:    make([]metrics.NodeMetrics, 0, len(groups)),
 	}
-	return &res, nil
+	for value, group := range groups {
+		group.Annotations = map[string]string{util.AggregatedNodesAnnotation: strconv.Itoa(counts[value])}
+		res.Items = append(res.Items, *group)
+	}
+	sort.Slice(res.Items, func(i, j int) bool { return res.Items[i].Name < res.Items[j].Name })
+	return res, nil
 }
 
 // Getter interface
 func (m *MetricStorage) Get(ctx genericapirequest.Context, name string, opts *metav1.GetOptions) (runtime.Object, error) {
 	// TODO: pay attention to get options
-	nodeMetrics := m.getNodeMetrics(name)
-	if nodeMetrics == nil {
-		return &metrics.NodeMetrics{}, errors.NewNotFound(m.groupResource, name)
+	nodeMetrics, batch, err := m.get(ctx, name)
+	if err != nil {
+		return &metrics.NodeMetrics{}, err
+	}
+	key := core.NodeKey(name)
+	if trend, found := util.GetUsageTrends(m.metricSink, batch, []string{key})[key]; found {
+		util.AddUsageTrendAnnotation(&nodeMetrics.ObjectMeta, trend)
+	}
+	if util.WindowFrom(ctx) == 0 {
+		m.addEffectiveWindow(batch, nodeMetrics)
+	}
+	if selection := util.FieldSelectionFrom(ctx); selection != nil {
+		selection.FilterNodeMetrics(nodeMetrics)
 	}
 	return nodeMetrics, nil
 }
 
-func (m *MetricStorage) getNodeMetrics(node string) *metrics.NodeMetrics {
-	batch := m.metricSink.GetLatestDataBatch()
-	if batch == nil {
-		return nil
+// GetExtended returns the metrics of the node with the fields of the extended subresource.
+func (m *MetricStorage) GetExtended(ctx genericapirequest.Context, name string) (*extendedmetrics.NodeMetrics, error) {
+	nodeMetrics, batch, err := m.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	key := core.NodeKey(name)
+	result := &extendedmetrics.NodeMetrics{
+		ObjectMeta: nodeMetrics.ObjectMeta,
+		Timestamp:  nodeMetrics.Timestamp,
+		Window:     nodeMetrics.Window,
+		Usage:      nodeMetrics.Usage,
+	}
+	if trend, found := util.GetUsageTrends(m.metricSink, batch, []string{key})[key]; found {
+		result.UsageTrend = trend
+	}
+	if startTime, uptime, found := util.GetNodeUptime(batch, key); found {
+		result.StartTime = &metav1.Time{Time: startTime}
+		result.Uptime = &metav1.Duration{Duration: uptime}
+	}
+	return result, nil
+}
+
+// get returns the metrics of the node, without annotations, and the batch they're from.
+func (m *MetricStorage) get(ctx genericapirequest.Context, name string) (*metrics.NodeMetrics, *core.DataBatch, error) {
+	batch, window := util.GetDataBatch(ctx, m.metricSink)
+	if util.IsStaleFor(ctx, m.metricSink, batch) {
+		return nil, nil, util.NewMetricsStaleError(m.groupResource, name, batch)
+	}
+
+	if metricsutil.IsNodeDeleted(name) || !m.shard.Owns(name) {
+		// The points of the node stay in the stored batches for a while.
+		return nil, nil, errors.NewNotFound(m.groupResource, name)
 	}
+	nodeMetrics := m.getNodeMetrics(batch, window, name)
+	if nodeMetrics == nil {
+		if _, err := m.nodeLister.Get(name); err == nil {
+			return nil, nil, util.NewNodeUnscrapableError(m.groupResource, name, name)
+		}
+		return nil, nil, errors.NewNotFound(m.groupResource, name)
+	}
+	return nodeMetrics, batch, nil
+}
+
+// ListExclusionReason returns why the metrics of the node are left out of LIST responses
+// which don't ask for unschedulable nodes, empty if they're served. It's meant for
+// debugging missing metrics.
+func (m *MetricStorage) ListExclusionReason(name string) string {
+	node, err := m.nodeLister.Get(name)
+	if err != nil {
+		return err.Error()
+	}
+	return m.listExclusionReason(node, m.listUnschedulable)
+}
+
+// listExclusionReason returns why the node is left out of LIST responses, empty if it isn't.
+func (m *MetricStorage) listExclusionReason(node *v1.Node, includeUnschedulable bool) string {
+	if node.Spec.Unschedulable && !includeUnschedulable {
+		return "unschedulable node, listed only on request"
+	}
+	if reason := metricsutil.NodeSkipReason(node); reason != "" {
+		return reason
+	}
+	if !m.shard.Owns(node.Name) {
+		return "node of another shard"
+	}
+	return ""
+}
+
+// addEffectiveWindow annotates the item with the interval its latest usage was measured
+// over, if it deviates from the metric resolution.
+func (m *MetricStorage) addEffectiveWindow(batch *core.DataBatch, item *metrics.NodeMetrics) {
+	if window, found := util.GetDeviatingWindow(batch, []string{core.NodeKey(item.Name)}, m.metricResolution); found {
+		util.AddEffectiveWindowAnnotation(&item.ObjectMeta, window)
+	}
+}
 
+func (m *MetricStorage) getNodeMetrics(batch *core.DataBatch, window time.Duration, node string) *metrics.NodeMetrics {
 	ms, found := batch.MetricSets[core.NodeKey(node)]
 	if !found {
 		return nil
@@ -127,10 +342,11 @@ func (m *MetricStorage) getNodeMetrics(node string) *metrics.NodeMetrics {
 	return &metrics.NodeMetrics{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              node,
-			CreationTimestamp: metav1.NewTime(time.Now()),
+			CreationTimestamp: metav1.NewTime(batch.Timestamp),
+			ResourceVersion:   util.ResourceVersion(batch),
 		},
 		Timestamp: metav1.NewTime(batch.Timestamp),
-		Window:    metav1.Duration{Duration: time.Minute},
+		Window:    metav1.Duration{Duration: window},
 		Usage:     usage,
 	}
 }
@@ -0,0 +1,215 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+func nodeMetricSet(cpu, memory int64) *core.MetricSet {
+	return &core.MetricSet{
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: memory},
+		},
+	}
+}
+
+// newTestStorage returns the storage of three batches a minute apart, in which the usage
+// of n1 grows by 100m and 1Ki a minute. n1 booted an hour before the latest batch, n2
+// isn't scraped.
+func newTestStorage(t *testing.T) *MetricStorage {
+	nodeStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, nodeStore.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1"}}))
+	require.NoError(t, nodeStore.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n2"}}))
+
+	metricSink := metricsink.NewMetricSink(time.Minute, 10*time.Minute,
+		[]string{core.MetricCpuUsageRate.Name, core.MetricMemoryWorkingSet.Name})
+	now := time.Now()
+	for i := int64(0); i < 3; i++ {
+		ms := nodeMetricSet(100+100*i, 4096+1024*i)
+		ms.CreateTime = now.Add(-time.Hour)
+		metricSink.ExportData(&core.DataBatch{
+			Timestamp:  now.Add(time.Duration(i-2) * time.Minute),
+			MetricSets: map[string]*core.MetricSet{core.NodeKey("n1"): ms},
+		})
+	}
+	return NewStorage(metrics.Resource("nodemetrics"), metricSink, v1listers.NewNodeLister(nodeStore), false, time.Minute, metricsutil.Shard{})
+}
+
+func TestGetAddsUsageTrend(t *testing.T) {
+	storage := newTestStorage(t)
+	obj, err := storage.Get(genericapirequest.NewContext(), "n1", &metav1.GetOptions{})
+	require.NoError(t, err)
+	nodeMetrics := obj.(*metrics.NodeMetrics)
+	assert.JSONEq(t, `{"cpu":"100m","memory":"1Ki"}`, nodeMetrics.Annotations[util.UsageTrendAnnotation])
+}
+
+func TestAddUsageTrendAnnotationMerges(t *testing.T) {
+	meta := metav1.ObjectMeta{Annotations: map[string]string{util.EffectiveWindowAnnotation: "30s"}}
+	util.AddUsageTrendAnnotation(&meta, metrics.ResourceList{
+		metrics.ResourceName(v1.ResourceCPU): *resource.NewMilliQuantity(-15, resource.DecimalSI),
+	})
+	assert.Equal(t, map[string]string{
+		util.EffectiveWindowAnnotation: "30s",
+		util.UsageTrendAnnotation:      `{"cpu":"-15m"}`,
+	}, meta.Annotations)
+
+	meta = metav1.ObjectMeta{}
+	util.AddUsageTrendAnnotation(&meta, metrics.ResourceList{})
+	assert.Equal(t, map[string]string{util.UsageTrendAnnotation: `{}`}, meta.Annotations)
+}
+
+func TestGetNodeUptime(t *testing.T) {
+	now := time.Now()
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("n1"): {CreateTime: now.Add(-90*time.Second - time.Millisecond)},
+			core.NodeKey("n2"): {},
+			core.NodeKey("n3"): {CreateTime: now.Add(time.Second)},
+		},
+	}
+	startTime, uptime, found := util.GetNodeUptime(batch, core.NodeKey("n1"))
+	require.True(t, found)
+	assert.True(t, startTime.Equal(now.Add(-90*time.Second-time.Millisecond)))
+	assert.Equal(t, 90*time.Second, uptime)
+
+	_, _, found = util.GetNodeUptime(batch, core.NodeKey("n2"))
+	assert.False(t, found, "start time unknown")
+	_, _, found = util.GetNodeUptime(batch, core.NodeKey("n4"))
+	assert.False(t, found)
+	_, uptime, found = util.GetNodeUptime(batch, core.NodeKey("n3"))
+	require.True(t, found)
+	assert.Equal(t, time.Duration(0), uptime, "clock skew")
+}
+
+func TestGetExtended(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := genericapirequest.NewContext()
+	extended, err := storage.GetExtended(ctx, "n1")
+	require.NoError(t, err)
+	assert.Equal(t, "n1", extended.Name)
+	assert.Empty(t, extended.Annotations)
+	cpu := extended.Usage[metrics.ResourceName(v1.ResourceCPU)]
+	assert.Equal(t, int64(300), cpu.MilliValue())
+	trendCPU := extended.UsageTrend[metrics.ResourceName(v1.ResourceCPU)]
+	assert.Equal(t, int64(100), trendCPU.MilliValue())
+	trendMemory := extended.UsageTrend[metrics.ResourceName(v1.ResourceMemory)]
+	assert.Equal(t, int64(1024), trendMemory.Value())
+	require.NotNil(t, extended.StartTime)
+	assert.True(t, extended.StartTime.Equal(&metav1.Time{Time: extended.Timestamp.Add(-time.Hour)}))
+	require.NotNil(t, extended.Uptime)
+	assert.Equal(t, time.Hour, extended.Uptime.Duration)
+
+	_, err = storage.GetExtended(ctx, "n2")
+	require.Error(t, err)
+	assert.Equal(t, util.StatusReasonNodeUnscrapable, err.(*errors.StatusError).ErrStatus.Reason)
+	_, err = storage.GetExtended(ctx, "n3")
+	assert.True(t, errors.IsNotFound(err))
+}
+
+// newTestGroupedStorage returns the storage of a batch with the metrics of n1 and n2 in
+// zone a and n3 in zone b, and of the nodes scraped, n4 without a zone.
+func newTestGroupedStorage(t *testing.T, scraped ...string) *MetricStorage {
+	nodeStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, zone := range map[string]string{"n1": "a", "n2": "a", "n3": "b"} {
+		require.NoError(t, nodeStore.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"zone": zone}}}))
+	}
+	require.NoError(t, nodeStore.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n4"}}))
+
+	metricSink := metricsink.NewMetricSink(time.Minute, 10*time.Minute,
+		[]string{core.MetricCpuUsageRate.Name, core.MetricMemoryWorkingSet.Name})
+	batch := &core.DataBatch{Timestamp: time.Now(), MetricSets: map[string]*core.MetricSet{}}
+	for _, name := range scraped {
+		batch.MetricSets[core.NodeKey(name)] = nodeMetricSet(100, 1024)
+	}
+	metricSink.ExportData(batch)
+	return NewStorage(metrics.Resource("nodemetrics"), metricSink, v1listers.NewNodeLister(nodeStore), false, time.Minute, metricsutil.Shard{})
+}
+
+func TestListGroupBy(t *testing.T) {
+	storage := newTestGroupedStorage(t, "n1", "n2", "n3")
+	obj, err := storage.List(util.WithGroupBy(genericapirequest.NewContext(), "zone"), nil)
+	require.NoError(t, err)
+	items := obj.(*metrics.NodeMetricsList).Items
+	require.Len(t, items, 2)
+
+	assert.Equal(t, "a", items[0].Name)
+	assert.Equal(t, map[string]string{"zone": "a"}, items[0].Labels)
+	assert.Equal(t, "2", items[0].Annotations[util.AggregatedNodesAnnotation])
+	cpu, memory := items[0].Usage[metrics.ResourceName(v1.ResourceCPU)], items[0].Usage[metrics.ResourceName(v1.ResourceMemory)]
+	assert.Equal(t, int64(200), cpu.MilliValue())
+	assert.Equal(t, int64(2048), memory.Value())
+
+	assert.Equal(t, "b", items[1].Name)
+	assert.Equal(t, "1", items[1].Annotations[util.AggregatedNodesAnnotation])
+	cpu = items[1].Usage[metrics.ResourceName(v1.ResourceCPU)]
+	assert.Equal(t, int64(100), cpu.MilliValue())
+}
+
+func TestListGroupByMissingNode(t *testing.T) {
+	storage := newTestGroupedStorage(t, "n1", "n3")
+	_, err := storage.List(util.WithGroupBy(genericapirequest.NewContext(), "zone"), nil)
+	require.Error(t, err)
+	require.IsType(t, &errors.StatusError{}, err)
+	assert.Equal(t, metav1.StatusReasonServiceUnavailable, err.(*errors.StatusError).ErrStatus.Reason)
+	assert.Contains(t, err.Error(), "zone=a")
+	assert.Contains(t, err.Error(), "n2")
+}
+
+func TestListOrderAndResourceVersion(t *testing.T) {
+	storage := newTestGroupedStorage(t, "n3", "n1", "n2")
+	list := func() *metrics.NodeMetricsList {
+		obj, err := storage.List(genericapirequest.NewContext(), nil)
+		require.NoError(t, err)
+		return obj.(*metrics.NodeMetricsList)
+	}
+
+	first := list()
+	require.Len(t, first.Items, 3)
+	for i, name := range []string{"n1", "n2", "n3"} {
+		assert.Equal(t, name, first.Items[i].Name)
+		assert.Equal(t, first.ResourceVersion, first.Items[i].ResourceVersion, name)
+	}
+	assert.Equal(t, first, list(), "lists of the same batch are identical")
+
+	latest := storage.metricSink.GetLatestDataBatch()
+	storage.metricSink.ExportData(&core.DataBatch{Timestamp: latest.Timestamp.Add(time.Minute), MetricSets: latest.MetricSets})
+	second := list()
+	firstVersion, err := strconv.ParseInt(first.ResourceVersion, 10, 64)
+	require.NoError(t, err)
+	secondVersion, err := strconv.ParseInt(second.ResourceVersion, 10, 64)
+	require.NoError(t, err)
+	assert.True(t, secondVersion > firstVersion, "the resourceVersion increases with new batches")
+}
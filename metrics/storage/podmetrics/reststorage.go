@@ -15,16 +15,23 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/kubernetes-incubator/metrics-server/metrics/core"
 	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
 	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -37,10 +44,63 @@ import (
 	_ "k8s.io/metrics/pkg/apis/metrics/install"
 )
 
+// Annotation set on PodMetrics served for pods which were not scraped yet.
+const PlaceholderAnnotation = "metrics.k8s.io/placeholder"
+
+// Annotation with the restart counts of the containers observed when they were scraped,
+// as a JSON object keyed by container name. Consumers can compare them across samples
+// to discard usage spanning a restart.
+const ContainerRestartsAnnotation = "metrics.k8s.io/container-restarts"
+
+// Annotation with the comma separated names of the containers which are sidecars, i.e.
+// restartable init containers. They're served after the regular containers.
+const SidecarContainersAnnotation = "metrics.k8s.io/sidecar-containers"
+
+// Annotation with the comma separated names of the ephemeral (debug) containers. They're
+// served after the regular containers and sidecars, if enabled.
+const EphemeralContainersAnnotation = "metrics.k8s.io/ephemeral-containers"
+
+// Annotation with the usage of the pod cgroup as a JSON resource list, e.g.
+// {"cpu":"120m","memory":"80Mi"}. It includes the pause container and the pod overhead,
+// so it exceeds the sum of the containers. Only set if the kubelet reports it.
+const PodCgroupUsageAnnotation = "metrics.k8s.io/pod-cgroup-usage"
+
+var withheldYoungPods = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "api",
+		Name:      "withheld_young_pods_total",
+		Help:      "Number of times PodMetrics of pods younger than the minimum pod age were withheld from responses.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(withheldYoungPods)
+}
+
 type MetricStorage struct {
-	groupResource schema.GroupResource
-	metricSink    *metricsink.MetricSink
-	podLister     v1listers.PodLister
+	groupResource     schema.GroupResource
+	metricSink        *metricsink.MetricSink
+	podLister         v1listers.PodLister
+	servePlaceholders bool
+	// Priority classes of pods that are not served in LIST responses.
+	listExcludedPriorityClasses map[string]bool
+	// Whether Succeeded and Failed pods are served in LIST responses, with the final sample
+	// of their containers while it's kept.
+	listCompletedPods bool
+	// Interval at which nodes are scraped, the expected window of the latest samples.
+	metricResolution time.Duration
+	// Pods which started less than this before the batch are not served, as their usage
+	// rates are computed from too few samples. Zero serves all pods.
+	minPodAge time.Duration
+	// Whether containers the kubelet reports which aren't in the pod spec, i.e. ephemeral
+	// containers, are served.
+	serveEphemeralContainers bool
+	// Names of the containers of the latest batch served by pod, if ephemeral containers
+	// are served.
+	podContainers podContainerIndex
+	// Shard of the nodes whose pods are served, the ones scraped by this instance.
+	shard metricsutil.Shard
 }
 
 var _ rest.KindProvider = &MetricStorage{}
@@ -48,11 +108,24 @@ var _ rest.Storage = &MetricStorage{}
 var _ rest.Getter = &MetricStorage{}
 var _ rest.Lister = &MetricStorage{}
 
-func NewStorage(groupResource schema.GroupResource, metricSink *metricsink.MetricSink, podLister v1listers.PodLister) *MetricStorage {
+func NewStorage(groupResource schema.GroupResource, metricSink *metricsink.MetricSink, podLister v1listers.PodLister,
+	servePlaceholders bool, listExcludedPriorityClasses []string, listCompletedPods bool, metricResolution, minPodAge time.Duration,
+	serveEphemeralContainers bool, shard metricsutil.Shard) *MetricStorage {
+	excluded := make(map[string]bool, len(listExcludedPriorityClasses))
+	for _, class := range listExcludedPriorityClasses {
+		excluded[class] = true
+	}
 	return &MetricStorage{
-		groupResource: groupResource,
-		metricSink:    metricSink,
-		podLister:     podLister,
+		groupResource:               groupResource,
+		metricSink:                  metricSink,
+		podLister:                   podLister,
+		servePlaceholders:           servePlaceholders,
+		listExcludedPriorityClasses: excluded,
+		listCompletedPods:           listCompletedPods,
+		metricResolution:            metricResolution,
+		minPodAge:                   minPodAge,
+		serveEphemeralContainers:    serveEphemeralContainers,
+		shard:                       shard,
 	}
 }
 
@@ -72,7 +145,25 @@ func (m *MetricStorage) NewList() runtime.Object {
 }
 
 // Lister interface
+// Items are sorted by namespace and name. The list and its items carry the resourceVersion of the
+// batch they were served from, which only changes when new metrics are collected.
 func (m *MetricStorage) List(ctx genericapirequest.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	res := &metrics.PodMetricsList{}
+	resourceVersion, err := m.Visit(ctx, options, func(item *metrics.PodMetrics) error {
+		res.Items = append(res.Items, *item)
+		return nil
+	})
+	if err != nil {
+		return &metrics.PodMetricsList{}, err
+	}
+	res.ResourceVersion = resourceVersion
+	return res, nil
+}
+
+// Visit calls fn with the items of the list, in order, as soon as each is built, so that
+// they can be streamed without building the whole list. It returns the resourceVersion of
+// the list, or the first error of fn.
+func (m *MetricStorage) Visit(ctx genericapirequest.Context, options *metainternalversion.ListOptions, fn func(*metrics.PodMetrics) error) (string, error) {
 	labelSelector := labels.Everything()
 	if options != nil && options.LabelSelector != nil {
 		labelSelector = options.LabelSelector
@@ -82,18 +173,50 @@ func (m *MetricStorage) List(ctx genericapirequest.Context, options *metainterna
 	if err != nil {
 		errMsg := fmt.Errorf("Error while listing pods for selector %v: %v", labelSelector, err)
 		glog.Error(errMsg)
-		return &metrics.PodMetricsList{}, errMsg
+		return "", errMsg
+	}
+
+	batch, window := util.GetDataBatch(ctx, m.metricSink)
+	if util.IsStaleFor(ctx, m.metricSink, batch) {
+		return "", util.NewMetricsStaleError(m.groupResource, "", batch)
 	}
 
-	res := metrics.PodMetricsList{}
+	sort.Slice(pods, func(i, j int) bool {
+		a, b := pods[i], pods[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	ephemeral := m.getPodContainers(batch)
+	selection := util.FieldSelectionFrom(ctx)
 	for _, pod := range pods {
-		if podMetrics := m.getPodMetrics(pod); podMetrics != nil {
-			res.Items = append(res.Items, *podMetrics)
-		} else {
+		if m.listExclusionReason(pod) != "" || !util.MatchesFieldSelector(options, pod.Namespace, pod.Name) {
+			continue
+		}
+		if m.isTooYoung(batch, pod) {
+			withheldYoungPods.Inc()
+			continue
+		}
+		item := m.getPodMetrics(batch, window, pod, ephemeral)
+		if item == nil {
+			item = m.getPlaceholderPodMetrics(batch, window, pod)
+		}
+		if item == nil {
 			glog.Infof("No metrics for pod %s/%s", pod.Namespace, pod.Name)
+			continue
+		}
+		if util.WindowFrom(ctx) == 0 {
+			m.addEffectiveWindow(batch, item)
+		}
+		if selection != nil {
+			selection.FilterPodMetrics(item)
+		}
+		if err := fn(item); err != nil {
+			return "", err
 		}
 	}
-	return &res, nil
+	return util.ResourceVersion(batch), nil
 }
 
 // Getter interface
@@ -110,30 +233,57 @@ func (m *MetricStorage) Get(ctx genericapirequest.Context, name string, opts *me
 		return &metrics.PodMetrics{}, errors.NewNotFound(v1.Resource("pods"), fmt.Sprintf("%v/%v", namespace, name))
 	}
 
-	podMetrics := m.getPodMetrics(pod)
+	batch, window := util.GetDataBatch(ctx, m.metricSink)
+	if util.IsStaleFor(ctx, m.metricSink, batch) {
+		return &metrics.PodMetrics{}, util.NewMetricsStaleError(m.groupResource, fmt.Sprintf("%v/%v", namespace, name), batch)
+	}
+
+	if !m.shard.Owns(pod.Spec.NodeName) {
+		return &metrics.PodMetrics{}, errors.NewNotFound(m.groupResource, fmt.Sprintf("%v/%v", namespace, name))
+	}
+	if m.isTooYoung(batch, pod) {
+		withheldYoungPods.Inc()
+		return &metrics.PodMetrics{}, errors.NewNotFound(m.groupResource, fmt.Sprintf("%v/%v", namespace, name))
+	}
+
+	podMetrics := m.getPodMetrics(batch, window, pod, m.getPodContainers(batch))
+	if podMetrics == nil {
+		podMetrics = m.getPlaceholderPodMetrics(batch, window, pod)
+	}
 	if podMetrics == nil {
+		if pod.Spec.NodeName != "" && !metricsutil.IsNodeNameSkipped(pod.Spec.NodeName) {
+			if _, found := batch.MetricSets[core.NodeKey(pod.Spec.NodeName)]; !found {
+				return &metrics.PodMetrics{}, util.NewNodeUnscrapableError(m.groupResource, fmt.Sprintf("%v/%v", namespace, name), pod.Spec.NodeName)
+			}
+		}
 		return &metrics.PodMetrics{}, errors.NewNotFound(m.groupResource, fmt.Sprintf("%v/%v", namespace, name))
 	}
+	if util.WindowFrom(ctx) == 0 {
+		m.addEffectiveWindow(batch, podMetrics)
+	}
+	if selection := util.FieldSelectionFrom(ctx); selection != nil {
+		selection.FilterPodMetrics(podMetrics)
+	}
 	return podMetrics, nil
 }
 
-func (m *MetricStorage) getPodMetrics(pod *v1.Pod) *metrics.PodMetrics {
-	batch := m.metricSink.GetLatestDataBatch()
-	if batch == nil {
-		return nil
-	}
-
+// getPodMetrics returns the metrics of the pod, or nil if some of its containers weren't
+// scraped yet. Containers in the batch but not in the pod spec are served as ephemeral
+// containers if listed in podContainers.
+func (m *MetricStorage) getPodMetrics(batch *core.DataBatch, window time.Duration, pod *v1.Pod, podContainers map[string][]string) *metrics.PodMetrics {
 	res := &metrics.PodMetrics{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:              pod.Name,
 			Namespace:         pod.Namespace,
-			CreationTimestamp: metav1.NewTime(time.Now()),
+			CreationTimestamp: metav1.NewTime(batch.Timestamp),
+			ResourceVersion:   util.ResourceVersion(batch),
 		},
 		Timestamp:  metav1.NewTime(batch.Timestamp),
-		Window:     metav1.Duration{Duration: time.Minute},
+		Window:     metav1.Duration{Duration: window},
 		Containers: make([]metrics.ContainerMetrics, 0),
 	}
 
+	restarts := make(map[string]int64, len(pod.Spec.Containers))
 	for _, c := range pod.Spec.Containers {
 		ms, found := batch.MetricSets[core.PodContainerKey(pod.Namespace, pod.Name, c.Name)]
 		if !found {
@@ -145,7 +295,251 @@ func (m *MetricStorage) getPodMetrics(pod *v1.Pod) *metrics.PodMetrics {
 			return nil
 		}
 		res.Containers = append(res.Containers, metrics.ContainerMetrics{Name: c.Name, Usage: usage})
+		if count, found := ms.MetricValues[core.MetricRestartCount.Name]; found {
+			restarts[c.Name] = count.IntValue
+		}
+	}
+
+	// Sidecars which weren't scraped yet are left out rather than holding back the pod.
+	sidecars := []string{}
+	for _, c := range metricsutil.SidecarContainers(pod) {
+		ms, found := batch.MetricSets[core.PodContainerKey(pod.Namespace, pod.Name, c.Name)]
+		if !found {
+			continue
+		}
+		usage, err := util.ParseResourceList(ms)
+		if err != nil {
+			continue
+		}
+		res.Containers = append(res.Containers, metrics.ContainerMetrics{Name: c.Name, Usage: usage})
+		sidecars = append(sidecars, c.Name)
+		if count, found := ms.MetricValues[core.MetricRestartCount.Name]; found {
+			restarts[c.Name] = count.IntValue
+		}
+	}
+
+	// Containers which aren't in the spec can only be ephemeral containers, as container
+	// names are unique across the regular, init and ephemeral containers.
+	ephemeral := []string{}
+	if names := podContainers[pod.Namespace+"/"+pod.Name]; len(names) > 0 {
+		inSpec := make(map[string]bool, len(pod.Spec.Containers)+len(pod.Spec.InitContainers))
+		for _, c := range pod.Spec.Containers {
+			inSpec[c.Name] = true
+		}
+		for _, c := range pod.Spec.InitContainers {
+			inSpec[c.Name] = true
+		}
+		for _, name := range names {
+			if inSpec[name] {
+				continue
+			}
+			ms := batch.MetricSets[core.PodContainerKey(pod.Namespace, pod.Name, name)]
+			if isStaleContainer(pod, ms) {
+				continue
+			}
+			usage, err := util.ParseResourceList(ms)
+			if err != nil {
+				continue
+			}
+			res.Containers = append(res.Containers, metrics.ContainerMetrics{Name: name, Usage: usage})
+			ephemeral = append(ephemeral, name)
+		}
 	}
 
+	podUsage := getPodCgroupUsage(batch, pod)
+	if len(sidecars) > 0 || len(ephemeral) > 0 || len(restarts) > 0 || podUsage != nil {
+		res.Annotations = map[string]string{}
+	}
+	if podUsage != nil {
+		if encoded, err := json.Marshal(podUsage); err == nil {
+			res.Annotations[PodCgroupUsageAnnotation] = string(encoded)
+		}
+	}
+	if len(sidecars) > 0 {
+		res.Annotations[SidecarContainersAnnotation] = strings.Join(sidecars, ",")
+	}
+	if len(ephemeral) > 0 {
+		res.Annotations[EphemeralContainersAnnotation] = strings.Join(ephemeral, ",")
+	}
+	if len(restarts) > 0 {
+		if encoded, err := json.Marshal(restarts); err == nil {
+			res.Annotations[ContainerRestartsAnnotation] = string(encoded)
+		}
+	}
+	return res
+}
+
+// getPodContainers returns the sorted names of the containers in the batch by namespace/name
+// of their pod, or nil if ephemeral containers aren't served. The vendored pod API predates
+// ephemeral containers, so they're told apart as the containers missing from the spec.
+func (m *MetricStorage) getPodContainers(batch *core.DataBatch) map[string][]string {
+	if !m.serveEphemeralContainers {
+		return nil
+	}
+	return m.podContainers.get(batch)
+}
+
+// podContainerIndex keeps the names of the containers of a batch by namespace/name of their
+// pod, so that they're only gathered once per batch rather than on every GET.
+type podContainerIndex struct {
+	lock       sync.Mutex
+	batch      *core.DataBatch
+	containers map[string][]string
+}
+
+// get returns the sorted names of the containers in the batch by namespace/name of their
+// pod, rebuilding the index if it's of another batch.
+func (this *podContainerIndex) get(batch *core.DataBatch) map[string][]string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.batch == batch {
+		return this.containers
+	}
+	containers := map[string][]string{}
+	for _, ms := range batch.MetricSets {
+		if ms.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePodContainer {
+			continue
+		}
+		pod := ms.Labels[core.LabelNamespaceName.Key] + "/" + ms.Labels[core.LabelPodName.Key]
+		containers[pod] = append(containers[pod], ms.Labels[core.LabelContainerName.Key])
+	}
+	for _, names := range containers {
+		sort.Strings(names)
+	}
+	this.batch, this.containers = batch, containers
+	return containers
+}
+
+// isStaleContainer returns whether the container isn't one of the pod, but one of an earlier
+// pod with the same name, e.g. kept in the metric sets cached for a node with its own scrape
+// interval. Such containers are missing from the spec too, but aren't ephemeral containers.
+func isStaleContainer(pod *v1.Pod, ms *core.MetricSet) bool {
+	if ms == nil {
+		return true
+	}
+	if uid := ms.Labels[core.LabelPodId.Key]; uid != "" && pod.UID != "" && uid != string(pod.UID) {
+		return true
+	}
+	started := pod.Status.StartTime
+	return started != nil && !ms.CreateTime.IsZero() && ms.CreateTime.Before(started.Time)
+}
+
+// getPodCgroupUsage returns the usage of the pod cgroup, or nil if the kubelet didn't report
+// both cpu and memory.
+func getPodCgroupUsage(batch *core.DataBatch, pod *v1.Pod) metrics.ResourceList {
+	ms, found := batch.MetricSets[core.PodKey(pod.Namespace, pod.Name)]
+	if !found {
+		return nil
+	}
+	cpu, cpuFound := ms.MetricValues[core.MetricPodCgroupCpuUsageRate.Name]
+	memory, memoryFound := ms.MetricValues[core.MetricPodCgroupMemoryWorkingSet.Name]
+	if !cpuFound || !memoryFound {
+		return nil
+	}
+	return metrics.ResourceList{
+		metrics.ResourceName(v1.ResourceCPU.String()):    *resource.NewMilliQuantity(cpu.IntValue, resource.DecimalSI),
+		metrics.ResourceName(v1.ResourceMemory.String()): *resource.NewQuantity(memory.IntValue, resource.BinarySI),
+	}
+}
+
+// addEffectiveWindow annotates the item with the interval the latest usage of its containers
+// was measured over, if it deviates from the metric resolution.
+func (m *MetricStorage) addEffectiveWindow(batch *core.DataBatch, item *metrics.PodMetrics) {
+	keys := make([]string, 0, len(item.Containers))
+	for _, c := range item.Containers {
+		keys = append(keys, core.PodContainerKey(item.Namespace, item.Name, c.Name))
+	}
+	if window, found := util.GetDeviatingWindow(batch, keys, m.metricResolution); found {
+		util.AddEffectiveWindowAnnotation(&item.ObjectMeta, window)
+	}
+}
+
+// ListExclusionReason returns why the metrics of the pod are left out of LIST responses of
+// the batch, empty if they're served. It's meant for debugging missing metrics.
+func (m *MetricStorage) ListExclusionReason(batch *core.DataBatch, namespace, name string) string {
+	pod, err := m.podLister.Pods(namespace).Get(name)
+	if err != nil {
+		return err.Error()
+	}
+	if reason := m.listExclusionReason(pod); reason != "" {
+		return reason
+	}
+	if m.isTooYoung(batch, pod) {
+		return fmt.Sprintf("pod started less than --pod_metrics_min_age=%s before the batch", m.minPodAge)
+	}
+	return ""
+}
+
+// listExclusionReason returns why the pod is left out of LIST responses, empty if it isn't.
+// Pods of skipped nodes never have usage, so they're skipped without further noise.
+// Completed pods are skipped unless requested: they have no usage but the final sample of
+// their containers, which short-lived pods like jobs would bloat the responses with.
+func (m *MetricStorage) listExclusionReason(pod *v1.Pod) string {
+	if !m.listCompletedPods && (pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed) {
+		return fmt.Sprintf("%s pod, listed only with --list_completed_pods", pod.Status.Phase)
+	}
+	if pod.Spec.NodeName != "" && metricsutil.IsNodeNameSkipped(pod.Spec.NodeName) {
+		return fmt.Sprintf("pod of the skipped node %s", pod.Spec.NodeName)
+	}
+	if m.listExcludedPriorityClasses[pod.Spec.PriorityClassName] {
+		return fmt.Sprintf("pod of the priority class %s excluded with --list_excluded_priority_classes", pod.Spec.PriorityClassName)
+	}
+	if !m.shard.Owns(pod.Spec.NodeName) {
+		return fmt.Sprintf("pod of the node %s of another shard", pod.Spec.NodeName)
+	}
+	return ""
+}
+
+// isTooYoung checks whether the pod started less than the minimum pod age before the batch.
+// Pods which didn't start yet are aged from their creation.
+func (m *MetricStorage) isTooYoung(batch *core.DataBatch, pod *v1.Pod) bool {
+	if m.minPodAge <= 0 {
+		return false
+	}
+	started := pod.CreationTimestamp.Time
+	if pod.Status.StartTime != nil {
+		started = pod.Status.StartTime.Time
+	}
+	return batch.Timestamp.Sub(started) < m.minPodAge
+}
+
+// getPlaceholderPodMetrics returns zero usage for pods which are scheduled to a
+// node that was scraped, but weren't present in its summary yet. Returns nil if
+// placeholders are disabled or the pod doesn't qualify.
+func (m *MetricStorage) getPlaceholderPodMetrics(batch *core.DataBatch, window time.Duration, pod *v1.Pod) *metrics.PodMetrics {
+	if !m.servePlaceholders || pod.Spec.NodeName == "" {
+		return nil
+	}
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return nil
+	}
+	if _, found := batch.MetricSets[core.NodeKey(pod.Spec.NodeName)]; !found {
+		return nil
+	}
+	if _, found := batch.MetricSets[core.PodKey(pod.Namespace, pod.Name)]; found {
+		return nil
+	}
+
+	res := &metrics.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              pod.Name,
+			Namespace:         pod.Namespace,
+			CreationTimestamp: metav1.NewTime(batch.Timestamp),
+			ResourceVersion:   util.ResourceVersion(batch),
+			Annotations:       map[string]string{PlaceholderAnnotation: "true"},
+		},
+		Timestamp:  metav1.NewTime(batch.Timestamp),
+		Window:     metav1.Duration{Duration: window},
+		Containers: make([]metrics.ContainerMetrics, 0, len(pod.Spec.Containers)),
+	}
+	for _, c := range pod.Spec.Containers {
+		res.Containers = append(res.Containers, metrics.ContainerMetrics{
+			Name: c.Name,
+			Usage: metrics.ResourceList{
+				metrics.ResourceName(v1.ResourceCPU.String()):    *resource.NewMilliQuantity(0, resource.DecimalSI),
+				metrics.ResourceName(v1.ResourceMemory.String()): *resource.NewQuantity(0, resource.BinarySI),
+			},
+		})
+	}
 	return res
 }
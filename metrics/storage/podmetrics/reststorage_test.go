@@ -0,0 +1,404 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+func containerMetrics(cpu, mem int64) *core.MetricSet {
+	return &core.MetricSet{
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: mem},
+		},
+	}
+}
+
+// newTrimmedPod returns a running pod with the containers, created an hour before it
+// started, trimmed like the pods of the lister are.
+func newTrimmedPod(name string, started time.Time, containers ...string) *v1.Pod {
+	startTime := metav1.NewTime(started)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, CreationTimestamp: metav1.NewTime(started.Add(-time.Hour))},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning, StartTime: &startTime},
+	}
+	for _, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: c, Image: "image", Command: []string{c}})
+	}
+	metricsutil.TrimPod(pod)
+	return pod
+}
+
+func newTestStorage(t *testing.T, batch *core.DataBatch, minPodAge time.Duration, pods ...*v1.Pod) *MetricStorage {
+	return newShardStorage(t, batch, minPodAge, metricsutil.Shard{}, pods...)
+}
+
+func newShardStorage(t *testing.T, batch *core.DataBatch, minPodAge time.Duration, shard metricsutil.Shard, pods ...*v1.Pod) *MetricStorage {
+	podStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		require.NoError(t, podStore.Add(pod))
+	}
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, nil)
+	metricSink.ExportData(batch)
+	return NewStorage(metrics.Resource("pods"), metricSink, v1listers.NewPodLister(podStore), false, nil, false, time.Minute, minPodAge, false, shard)
+}
+
+func TestTrimmedPodTooYoung(t *testing.T) {
+	now := time.Now()
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns", "fresh", "c"): containerMetrics(100, 1000),
+			core.PodContainerKey("ns", "old", "c"):   containerMetrics(100, 1000),
+		},
+	}
+	// The fresh pod was created long ago, but only started shortly before the batch.
+	storage := newTestStorage(t, batch, time.Minute,
+		newTrimmedPod("fresh", now.Add(-10*time.Second), "c"), newTrimmedPod("old", now.Add(-time.Hour), "c"))
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "ns")
+
+	_, err := storage.Get(ctx, "fresh", &metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err), "the start time is kept when trimming")
+	obj, err := storage.List(ctx, nil)
+	require.NoError(t, err)
+	list := obj.(*metrics.PodMetricsList)
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "old", list.Items[0].Name)
+}
+
+func TestListExclusionReason(t *testing.T) {
+	now := time.Now()
+	batch := &core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{}}
+	completed := newTrimmedPod("completed", now.Add(-time.Hour), "c")
+	completed.Status.Phase = v1.PodSucceeded
+	storage := newTestStorage(t, batch, time.Minute,
+		newTrimmedPod("fresh", now.Add(-10*time.Second), "c"), newTrimmedPod("old", now.Add(-time.Hour), "c"), completed)
+
+	assert.Empty(t, storage.ListExclusionReason(batch, "ns", "old"))
+	assert.Equal(t, "pod started less than --pod_metrics_min_age=1m0s before the batch", storage.ListExclusionReason(batch, "ns", "fresh"))
+	assert.Equal(t, "Succeeded pod, listed only with --list_completed_pods", storage.ListExclusionReason(batch, "ns", "completed"))
+	assert.Contains(t, storage.ListExclusionReason(batch, "ns", "missing"), "not found")
+}
+
+func TestServedTrimmedPod(t *testing.T) {
+	now := time.Now()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "web", CreationTimestamp: metav1.NewTime(now.Add(-time.Hour))},
+		Spec: v1.PodSpec{
+			NodeName:       "node1",
+			InitContainers: []v1.Container{{Name: "proxy", Image: "proxy"}},
+			Containers:     []v1.Container{{Name: "c", Image: "image", Env: []v1.EnvVar{{Name: "A", Value: "B"}}}},
+		},
+		Status: v1.PodStatus{
+			Phase:                 v1.PodRunning,
+			StartTime:             &metav1.Time{Time: now.Add(-time.Hour)},
+			InitContainerStatuses: []v1.ContainerStatus{{Name: "proxy", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}}},
+			ContainerStatuses:     []v1.ContainerStatus{{Name: "c", RestartCount: 2, ImageID: "sha256:1234"}},
+		},
+	}
+	metricsutil.TrimPod(pod)
+	restarts := containerMetrics(100, 1000)
+	restarts.MetricValues[core.MetricRestartCount.Name] = core.MetricValue{IntValue: 2}
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns", "web", "c"):     restarts,
+			core.PodContainerKey("ns", "web", "proxy"): containerMetrics(10, 100),
+		},
+	}
+	storage := newTestStorage(t, batch, time.Minute, pod)
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "ns")
+
+	obj, err := storage.Get(ctx, "web", &metav1.GetOptions{})
+	require.NoError(t, err)
+	podMetrics := obj.(*metrics.PodMetrics)
+	require.Len(t, podMetrics.Containers, 2)
+	assert.Equal(t, "c", podMetrics.Containers[0].Name)
+	cpu := podMetrics.Containers[0].Usage[metrics.ResourceName(v1.ResourceCPU)]
+	assert.Equal(t, int64(100), cpu.MilliValue())
+	assert.Equal(t, "proxy", podMetrics.Containers[1].Name)
+	assert.Equal(t, "proxy", podMetrics.Annotations[SidecarContainersAnnotation], "sidecars are detected on trimmed pods")
+	assert.Equal(t, `{"c":2}`, podMetrics.Annotations[ContainerRestartsAnnotation])
+}
+
+func TestMinPodAge(t *testing.T) {
+	now := time.Now()
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns", "fresh", "c"):   containerMetrics(100, 1000),
+			core.PodContainerKey("ns", "pending", "c"): containerMetrics(100, 1000),
+		},
+	}
+	// Pods which didn't start yet are aged from their creation.
+	pending := newTrimmedPod("pending", now, "c")
+	pending.Status.StartTime = nil
+	pending.CreationTimestamp = metav1.NewTime(now.Add(-30 * time.Second))
+	fresh := newTrimmedPod("fresh", now.Add(-10*time.Second), "c")
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "ns")
+
+	storage := newTestStorage(t, batch, time.Minute, fresh, pending)
+	obj, err := storage.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, obj.(*metrics.PodMetricsList).Items)
+	_, err = storage.Get(ctx, "pending", &metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+
+	storage = newTestStorage(t, batch, 20*time.Second, fresh, pending)
+	obj, err = storage.List(ctx, nil)
+	require.NoError(t, err)
+	items := obj.(*metrics.PodMetricsList).Items
+	require.Len(t, items, 1)
+	assert.Equal(t, "pending", items[0].Name)
+
+	storage = newTestStorage(t, batch, 0, fresh, pending)
+	obj, err = storage.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, obj.(*metrics.PodMetricsList).Items, 2, "all pods are served without a minimum age")
+}
+
+func TestShardPods(t *testing.T) {
+	now := time.Now()
+	local := newTrimmedPod("local", now.Add(-time.Hour), "c")
+	remote := newTrimmedPod("remote", now.Add(-time.Hour), "c")
+	shard := metricsutil.Shard{Index: metricsutil.ShardOf("node1", 2), Count: 2}
+	for i := 0; shard.Owns(remote.Spec.NodeName); i++ {
+		remote.Spec.NodeName = fmt.Sprintf("node%d", i)
+	}
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns", "local", "c"):  containerMetrics(100, 1000),
+			core.PodContainerKey("ns", "remote", "c"): containerMetrics(100, 1000),
+		},
+	}
+	storage := newShardStorage(t, batch, 0, shard, local, remote)
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "ns")
+
+	obj, err := storage.List(ctx, nil)
+	require.NoError(t, err)
+	items := obj.(*metrics.PodMetricsList).Items
+	require.Len(t, items, 1, "pods of the nodes of other shards are left out")
+	assert.Equal(t, "local", items[0].Name)
+	_, err = storage.Get(ctx, "remote", &metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+	_, err = storage.Get(ctx, "local", &metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+// ephemeralContainerMetrics returns the metrics of a container of the pod with the uid,
+// started at the time.
+func ephemeralContainerMetrics(name, uid string, started time.Time) *core.MetricSet {
+	ms := containerMetrics(100, 1000)
+	ms.CreateTime = started
+	ms.Labels = map[string]string{
+		core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+		core.LabelNamespaceName.Key: "ns",
+		core.LabelPodName.Key:       "pod",
+		core.LabelContainerName.Key: name,
+		core.LabelPodId.Key:         uid,
+	}
+	return ms
+}
+
+func TestEphemeralContainers(t *testing.T) {
+	now := time.Now()
+	pod := newTrimmedPod("pod", now.Add(-time.Hour), "c")
+	pod.UID = "uid"
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns", "pod", "c"):        ephemeralContainerMetrics("c", "uid", now.Add(-time.Hour)),
+			core.PodContainerKey("ns", "pod", "debug"):    ephemeralContainerMetrics("debug", "uid", now.Add(-time.Minute)),
+			core.PodContainerKey("ns", "pod", "previous"): ephemeralContainerMetrics("previous", "old-uid", now.Add(-time.Minute)),
+			core.PodContainerKey("ns", "pod", "exited"):   ephemeralContainerMetrics("exited", "", now.Add(-2*time.Hour)),
+		},
+	}
+	podStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, podStore.Add(pod))
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, nil)
+	metricSink.ExportData(batch)
+	storage := NewStorage(metrics.Resource("pods"), metricSink, v1listers.NewPodLister(podStore), false, nil, false, time.Minute, 0, true, metricsutil.Shard{})
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "ns")
+
+	for i := 0; i < 2; i++ {
+		obj, err := storage.Get(ctx, "pod", &metav1.GetOptions{})
+		require.NoError(t, err)
+		podMetrics := obj.(*metrics.PodMetrics)
+		require.Len(t, podMetrics.Containers, 2, "containers of earlier pods aren't ephemeral")
+		assert.Equal(t, "c", podMetrics.Containers[0].Name)
+		assert.Equal(t, "debug", podMetrics.Containers[1].Name)
+		assert.Equal(t, "debug", podMetrics.Annotations[EphemeralContainersAnnotation])
+	}
+	assert.Equal(t, []string{"c", "debug", "exited", "previous"}, storage.podContainers.containers["ns/pod"],
+		"the containers are indexed once per batch")
+}
+
+func TestPodContainerIndex(t *testing.T) {
+	index := &podContainerIndex{}
+	first := &core.DataBatch{MetricSets: map[string]*core.MetricSet{
+		core.PodContainerKey("ns", "pod", "b"): ephemeralContainerMetrics("b", "", time.Time{}),
+		core.PodContainerKey("ns", "pod", "a"): ephemeralContainerMetrics("a", "", time.Time{}),
+		core.PodKey("ns", "pod"):               {Labels: map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypePod}},
+	}}
+	assert.Equal(t, map[string][]string{"ns/pod": {"a", "b"}}, index.get(first))
+
+	second := &core.DataBatch{MetricSets: map[string]*core.MetricSet{
+		core.PodContainerKey("ns", "pod", "a"): ephemeralContainerMetrics("a", "", time.Time{}),
+	}}
+	assert.Equal(t, map[string][]string{"ns/pod": {"a"}}, index.get(second), "rebuilt for a new batch")
+}
+
+func TestListCompletedPods(t *testing.T) {
+	now := time.Now()
+	running := newTrimmedPod("running", now.Add(-time.Hour), "c")
+	succeeded := newTrimmedPod("succeeded", now.Add(-time.Hour), "c")
+	succeeded.Status.Phase = v1.PodSucceeded
+	failed := newTrimmedPod("failed", now.Add(-time.Hour), "c")
+	failed.Status.Phase = v1.PodFailed
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns", "running", "c"):   containerMetrics(100, 1000),
+			core.PodContainerKey("ns", "succeeded", "c"): containerMetrics(10, 100),
+			core.PodContainerKey("ns", "failed", "c"):    containerMetrics(20, 200),
+		},
+	}
+	podStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range []*v1.Pod{running, succeeded, failed} {
+		require.NoError(t, podStore.Add(pod))
+	}
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, nil)
+	metricSink.ExportData(batch)
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "ns")
+	list := func(listCompletedPods bool) []string {
+		storage := NewStorage(metrics.Resource("pods"), metricSink, v1listers.NewPodLister(podStore), false, nil, listCompletedPods, time.Minute, 0, false, metricsutil.Shard{})
+		obj, err := storage.List(ctx, nil)
+		require.NoError(t, err)
+		names := []string{}
+		for _, item := range obj.(*metrics.PodMetricsList).Items {
+			names = append(names, item.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"running"}, list(false), "completed pods are left out by default")
+	assert.Equal(t, []string{"failed", "running", "succeeded"}, list(true), "with their final sample")
+
+	storage := NewStorage(metrics.Resource("pods"), metricSink, v1listers.NewPodLister(podStore), false, nil, false, time.Minute, 0, false, metricsutil.Shard{})
+	obj, err := storage.Get(ctx, "succeeded", &metav1.GetOptions{})
+	require.NoError(t, err, "GET isn't affected")
+	cpu := obj.(*metrics.PodMetrics).Containers[0].Usage[metrics.ResourceName(v1.ResourceCPU)]
+	assert.Equal(t, int64(10), cpu.MilliValue())
+}
+
+func TestPlaceholderPodMetrics(t *testing.T) {
+	now := time.Now()
+	scraped := newTrimmedPod("scraped", now.Add(-time.Hour), "c")
+	starting := newTrimmedPod("starting", now.Add(-time.Second), "c1", "c2")
+	unscrapedNode := newTrimmedPod("unscraped-node", now.Add(-time.Second), "c")
+	unscrapedNode.Spec.NodeName = "node2"
+	completed := newTrimmedPod("completed", now.Add(-time.Hour), "c")
+	completed.Status.Phase = v1.PodSucceeded
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"):                      containerMetrics(1000, 10000),
+			core.PodContainerKey("ns", "scraped", "c"): containerMetrics(100, 1000),
+		},
+	}
+	podStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range []*v1.Pod{scraped, starting, unscrapedNode, completed} {
+		require.NoError(t, podStore.Add(pod))
+	}
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, nil)
+	metricSink.ExportData(batch)
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "ns")
+	newStorage := func(servePlaceholders bool) *MetricStorage {
+		return NewStorage(metrics.Resource("pods"), metricSink, v1listers.NewPodLister(podStore), servePlaceholders, nil, false, time.Minute, 0, false, metricsutil.Shard{})
+	}
+
+	obj, err := newStorage(false).List(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, obj.(*metrics.PodMetricsList).Items, 1)
+	_, err = newStorage(false).Get(ctx, "starting", &metav1.GetOptions{})
+	assert.Error(t, err, "no placeholders by default")
+
+	storage := newStorage(true)
+	obj, err = storage.List(ctx, nil)
+	require.NoError(t, err)
+	items := obj.(*metrics.PodMetricsList).Items
+	require.Len(t, items, 2, "only pods of scraped nodes get placeholders")
+	assert.Equal(t, "scraped", items[0].Name)
+	assert.Empty(t, items[0].Annotations[PlaceholderAnnotation])
+	assert.Equal(t, "starting", items[1].Name)
+	assert.Equal(t, "true", items[1].Annotations[PlaceholderAnnotation])
+
+	obj, err = storage.Get(ctx, "starting", &metav1.GetOptions{})
+	require.NoError(t, err)
+	placeholder := obj.(*metrics.PodMetrics)
+	assert.Equal(t, "true", placeholder.Annotations[PlaceholderAnnotation])
+	assert.True(t, placeholder.Timestamp.Time.Equal(now))
+	require.Len(t, placeholder.Containers, 2)
+	for _, c := range placeholder.Containers {
+		cpu, memory := c.Usage[metrics.ResourceName(v1.ResourceCPU)], c.Usage[metrics.ResourceName(v1.ResourceMemory)]
+		assert.True(t, cpu.IsZero(), c.Name)
+		assert.True(t, memory.IsZero(), c.Name)
+	}
+	_, err = storage.Get(ctx, "unscraped-node", &metav1.GetOptions{})
+	assert.Error(t, err)
+}
+
+func TestListOrder(t *testing.T) {
+	now := time.Now()
+	batch := &core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{}}
+	pods := []*v1.Pod{}
+	for _, key := range [][2]string{{"ns2", "a"}, {"ns1", "b"}, {"ns1", "a"}, {"ns2", "c"}} {
+		pod := newTrimmedPod(key[1], now.Add(-time.Hour), "c")
+		pod.Namespace = key[0]
+		pods = append(pods, pod)
+		batch.MetricSets[core.PodContainerKey(key[0], key[1], "c")] = containerMetrics(100, 1000)
+	}
+	storage := newTestStorage(t, batch, 0, pods...)
+
+	obj, err := storage.List(genericapirequest.NewContext(), nil)
+	require.NoError(t, err)
+	list := obj.(*metrics.PodMetricsList)
+	names := []string{}
+	for _, item := range list.Items {
+		names = append(names, item.Namespace+"/"+item.Name)
+		assert.Equal(t, list.ResourceVersion, item.ResourceVersion, item.Name)
+	}
+	assert.Equal(t, []string{"ns1/a", "ns1/b", "ns2/a", "ns2/c"}, names)
+	assert.NotEmpty(t, list.ResourceVersion)
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resizemetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Name of the subresource of PodMetrics serving the resize metrics of its containers.
+const Subresource = "resize"
+
+// Window the peak usage is taken over unless the request selects one.
+const DefaultPeakWindow = 5 * time.Minute
+
+// Usage and resources of a container, for controllers resizing pods in place without
+// getting both the pod metrics and the pod.
+type ContainerResizeMetrics struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	// Latest usage, and highest usage within the window.
+	Usage metrics.ResourceList `json:"usage"`
+	Peak  metrics.ResourceList `json:"peak"`
+	// Resources the container runs with, reported in the pod status while and after the pod
+	// is resized in place, otherwise those of the pod spec.
+	Requests v1.ResourceList `json:"requests,omitempty"`
+	Limits   v1.ResourceList `json:"limits,omitempty"`
+}
+
+// Items are sorted by container.
+type ResizeMetricsList struct {
+	Timestamp metav1.Time              `json:"timestamp"`
+	Window    metav1.Duration          `json:"window"`
+	Items     []ContainerResizeMetrics `json:"items"`
+}
+
+var (
+	resourceCPU    = metrics.ResourceName(v1.ResourceCPU)
+	resourceMemory = metrics.ResourceName(v1.ResourceMemory)
+
+	groupResource = metrics.Resource("pods/" + Subresource)
+)
+
+type storage struct {
+	metricSink *metricsink.MetricSink
+	podLister  v1listers.PodLister
+	// Longest peak window, limited by the retention of the long-term metric store.
+	maxWindow time.Duration
+}
+
+var _ rest.Connecter = &storage{}
+
+// NewStorage returns the storage of the resize subresource of PodMetrics, serving the
+// resize metrics of the containers of the pod. The peak window is selected with the window
+// query parameter, a duration of at most maxWindow.
+func NewStorage(metricSink *metricsink.MetricSink, podLister v1listers.PodLister, maxWindow time.Duration) rest.Storage {
+	return &storage{
+		metricSink: metricSink,
+		podLister:  podLister,
+		maxWindow:  maxWindow,
+	}
+}
+
+func (s *storage) New() runtime.Object {
+	return &metrics.PodMetrics{}
+}
+
+func (s *storage) NewConnectOptions() (runtime.Object, bool, string) {
+	return nil, false, ""
+}
+
+func (s *storage) ConnectMethods() []string {
+	return []string{http.MethodGet}
+}
+
+func (s *storage) Connect(ctx genericapirequest.Context, name string, _ runtime.Object, responder rest.Responder) (http.Handler, error) {
+	namespace := genericapirequest.NamespaceValue(ctx)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		window := DefaultPeakWindow
+		if value := req.URL.Query().Get("window"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				responder.Error(errors.NewBadRequest(fmt.Sprintf("invalid window %q, expected a positive duration", value)))
+				return
+			}
+			window = parsed
+		}
+		if window > s.maxWindow {
+			window = s.maxWindow
+		}
+
+		batch := s.metricSink.GetLatestDataBatch()
+		if util.IsStale(s.metricSink, batch) {
+			responder.Error(util.NewMetricsStaleError(groupResource, fmt.Sprintf("%v/%v", namespace, name), batch))
+			return
+		}
+		pod, err := s.podLister.Pods(namespace).Get(name)
+		if errors.IsNotFound(err) || (err == nil && (pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed)) {
+			responder.Error(errors.NewNotFound(groupResource, fmt.Sprintf("%v/%v", namespace, name)))
+			return
+		}
+		if err != nil {
+			glog.Errorf("Error while getting pod %s/%s: %v", namespace, name, err)
+			responder.Error(err)
+			return
+		}
+		list := s.getResizeMetrics(batch, pod, window)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			glog.Errorf("Error while encoding resize metrics: %v", err)
+		}
+	}), nil
+}
+
+func (s *storage) getResizeMetrics(batch *core.DataBatch, pod *v1.Pod, window time.Duration) *ResizeMetricsList {
+	list := &ResizeMetricsList{
+		Timestamp: metav1.NewTime(batch.Timestamp),
+		Window:    metav1.Duration{Duration: window},
+		Items:     []ContainerResizeMetrics{},
+	}
+	var keys []string
+	containers := append(append([]v1.Container{}, pod.Spec.Containers...), metricsutil.SidecarContainers(pod)...)
+	for i := range containers {
+		c := &containers[i]
+		key := core.PodContainerKey(pod.Namespace, pod.Name, c.Name)
+		ms, found := batch.MetricSets[key]
+		if !found {
+			continue
+		}
+		usage, err := util.ParseResourceList(ms)
+		if err != nil {
+			continue
+		}
+		resources := metricsutil.ContainerResources(pod, c)
+		keys = append(keys, key)
+		list.Items = append(list.Items, ContainerResizeMetrics{
+			Namespace: pod.Namespace,
+			Pod:       pod.Name,
+			Container: c.Name,
+			Usage:     usage,
+			Requests:  resources.Requests,
+			Limits:    resources.Limits,
+		})
+	}
+
+	start := batch.Timestamp.Add(-window)
+	cpu := s.metricSink.GetMetric(core.MetricCpuUsageRate.Name, keys, start, batch.Timestamp)
+	memory := s.metricSink.GetMetric(core.MetricMemoryWorkingSet.Name, keys, start, batch.Timestamp)
+	for i, key := range keys {
+		item := &list.Items[i]
+		peakCPU, peakMemory := item.Usage[resourceCPU], item.Usage[resourceMemory]
+		if value := maxValue(cpu[key]); value > peakCPU.MilliValue() {
+			peakCPU = *resource.NewMilliQuantity(value, resource.DecimalSI)
+		}
+		if value := maxValue(memory[key]); value > peakMemory.Value() {
+			peakMemory = *resource.NewQuantity(value, resource.BinarySI)
+		}
+		item.Peak = metrics.ResourceList{resourceCPU: peakCPU, resourceMemory: peakMemory}
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Container < list.Items[j].Container })
+	return list
+}
+
+func maxValue(values []core.TimestampedMetricValue) int64 {
+	var result int64
+	for _, value := range values {
+		if value.IntValue > result {
+			result = value.IntValue
+		}
+	}
+	return result
+}
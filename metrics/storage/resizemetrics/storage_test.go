@@ -0,0 +1,184 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resizemetrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func containerMetrics(cpu, mem int64) *core.MetricSet {
+	return &core.MetricSet{
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: mem},
+		},
+	}
+}
+
+type fakeResponder struct {
+	err error
+}
+
+func (r *fakeResponder) Object(statusCode int, obj runtime.Object) {}
+
+func (r *fakeResponder) Error(err error) {
+	r.err = err
+}
+
+func getResizeMetrics(t *testing.T, storage rest.Storage, namespace, name, query string) (*ResizeMetricsList, error) {
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), namespace)
+	responder := &fakeResponder{}
+	handler, err := storage.(rest.Connecter).Connect(ctx, name, nil, responder)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resize?"+query, nil))
+	if responder.err != nil {
+		return nil, responder.err
+	}
+	require.Equal(t, http.StatusOK, rec.Code)
+	list := &ResizeMetricsList{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), list))
+	return list, nil
+}
+
+func newTestStorage(t *testing.T) rest.Storage {
+	podStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, podStore.Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "web"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "c", Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m")},
+				Limits:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+			}}},
+			InitContainers: []v1.Container{{Name: "proxy"}},
+		},
+		Status: v1.PodStatus{
+			Phase:                 v1.PodRunning,
+			InitContainerStatuses: []v1.ContainerStatus{{Name: "proxy", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}}},
+		},
+	}))
+	require.NoError(t, podStore.Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "resized", Annotations: map[string]string{
+			metricsutil.ContainerStatusResourcesAnnotation: `[{"name":"c","resources":{"requests":{"cpu":"500m"}}}]`,
+		}},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "c", Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+		}}}},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}))
+	require.NoError(t, podStore.Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "done"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "c"}}},
+		Status:     v1.PodStatus{Phase: v1.PodSucceeded},
+	}))
+
+	metricSink := metricsink.NewMetricSink(time.Minute, 10*time.Minute,
+		[]string{core.MetricCpuUsageRate.Name, core.MetricMemoryWorkingSet.Name})
+	now := time.Now()
+	for i, usage := range [][2]int64{{100, 3000}, {400, 1000}, {200, 2000}} {
+		metricSink.ExportData(&core.DataBatch{
+			Timestamp: now.Add(time.Duration(i-2) * time.Minute),
+			MetricSets: map[string]*core.MetricSet{
+				core.PodContainerKey("ns", "web", "c"):     containerMetrics(usage[0], usage[1]),
+				core.PodContainerKey("ns", "web", "proxy"): containerMetrics(10, 100),
+				core.PodContainerKey("ns", "resized", "c"): containerMetrics(300, 100),
+				core.PodContainerKey("ns", "done", "c"):    containerMetrics(1, 1),
+			},
+		})
+	}
+	return NewStorage(metricSink, v1listers.NewPodLister(podStore), 10*time.Minute)
+}
+
+func TestGetResizeMetrics(t *testing.T) {
+	storage := newTestStorage(t)
+
+	list, err := getResizeMetrics(t, storage, "ns", "web", "")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPeakWindow, list.Window.Duration)
+	require.Len(t, list.Items, 2)
+	c := list.Items[0]
+	assert.Equal(t, "c", c.Container)
+	cpu, mem := c.Usage["cpu"], c.Usage["memory"]
+	assert.Equal(t, int64(200), cpu.MilliValue())
+	assert.Equal(t, int64(2000), mem.Value())
+	cpu, mem = c.Peak["cpu"], c.Peak["memory"]
+	assert.Equal(t, int64(400), cpu.MilliValue())
+	assert.Equal(t, int64(3000), mem.Value())
+	request := c.Requests[v1.ResourceCPU]
+	assert.Equal(t, "250m", request.String())
+	limit := c.Limits[v1.ResourceMemory]
+	assert.Equal(t, "1Gi", limit.String())
+
+	proxy := list.Items[1]
+	assert.Equal(t, "proxy", proxy.Container, "sidecars are included")
+	cpu = proxy.Peak["cpu"]
+	assert.Equal(t, int64(10), cpu.MilliValue())
+
+	list, err = getResizeMetrics(t, storage, "ns", "web", "window=30s")
+	require.NoError(t, err)
+	cpu, mem = list.Items[0].Peak["cpu"], list.Items[0].Peak["memory"]
+	assert.Equal(t, int64(200), cpu.MilliValue(), "older peaks are out of the window")
+	assert.Equal(t, int64(2000), mem.Value())
+
+	list, err = getResizeMetrics(t, storage, "ns", "web", "window=1h")
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, list.Window.Duration, "window is limited")
+}
+
+func TestGetResizeMetricsResizedPod(t *testing.T) {
+	list, err := getResizeMetrics(t, newTestStorage(t), "ns", "resized", "")
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	request := list.Items[0].Requests[v1.ResourceCPU]
+	assert.Equal(t, "500m", request.String(), "the resources in the pod status are preferred over the spec")
+}
+
+func TestGetResizeMetricsErrors(t *testing.T) {
+	storage := newTestStorage(t)
+	for _, query := range []string{"window=soon", "window=-1m"} {
+		_, err := getResizeMetrics(t, storage, "ns", "web", query)
+		assert.True(t, errors.IsBadRequest(err), query)
+	}
+	_, err := getResizeMetrics(t, storage, "ns", "done", "")
+	assert.True(t, errors.IsNotFound(err), "completed pods are left out")
+	_, err = getResizeMetrics(t, storage, "other", "web", "")
+	assert.True(t, errors.IsNotFound(err), "the pod is looked up in the namespace of the request")
+
+	stale := NewStorage(metricsink.NewMetricSink(time.Minute, time.Minute, nil), v1listers.NewPodLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})), time.Minute)
+	_, err = getResizeMetrics(t, stale, "ns", "web", "")
+	require.IsType(t, &errors.StatusError{}, err)
+	assert.Equal(t, util.StatusReasonMetricsStale, err.(*errors.StatusError).ErrStatus.Reason)
+}
@@ -0,0 +1,392 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shards
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/grpcapi"
+	nodemetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/nodemetrics"
+	podmetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/podmetrics"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// Time the peers have to answer the requests fanned out to them.
+const DefaultTimeout = 10 * time.Second
+
+var peerFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "api",
+		Name:      "shard_peer_failures_total",
+		Help:      "Number of Metrics API requests fanned out to a peer shard which it failed to answer, by shard.",
+	},
+	[]string{"shard"},
+)
+
+func init() {
+	prometheus.MustRegister(peerFailures)
+}
+
+// Peer serves the metrics of the nodes of another shard, like the Metrics gRPC service of
+// grpcapi.Client.
+type Peer interface {
+	ListNodeMetrics(ctx context.Context, request *grpcapi.ListMetricsRequest, fn func(*v1beta1.NodeMetrics) error) error
+	ListPodMetrics(ctx context.Context, request *grpcapi.ListMetricsRequest, fn func(*v1beta1.PodMetrics) error) error
+}
+
+// Peers are the other shards of an instance. Requests of the Metrics API for the nodes of
+// other shards are fanned out to them when served, so each shard only stores the metrics
+// of its own nodes.
+type Peers struct {
+	shard metricsutil.Shard
+	// Peers by shard index, nil for the shard of this instance.
+	peers []Peer
+	// Converts the items of the peers to the internal types.
+	convertor runtime.ObjectConvertor
+	timeout   time.Duration
+}
+
+// NewPeers returns the peers of the shard, listed by shard index with nil for the shard.
+func NewPeers(shard metricsutil.Shard, peers []Peer, convertor runtime.ObjectConvertor, timeout time.Duration) *Peers {
+	return &Peers{
+		shard:     shard,
+		peers:     peers,
+		convertor: convertor,
+		timeout:   timeout,
+	}
+}
+
+// owner returns the peer of the shard the node belongs to, nil if it's the shard of this
+// instance.
+func (this *Peers) owner(node string) (int, Peer) {
+	shard := metricsutil.ShardOf(node, len(this.peers))
+	if shard == this.shard.Index {
+		return shard, nil
+	}
+	return shard, this.peers[shard]
+}
+
+// fanOut calls fn with every peer in parallel, and waits for them to return. Peers which
+// fail are logged and counted.
+func (this *Peers) fanOut(ctx genericapirequest.Context, fn func(ctx context.Context, shard int, peer Peer) error) {
+	ctx, cancel := context.WithTimeout(ctx, this.timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for shard, peer := range this.peers {
+		if shard == this.shard.Index || peer == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(shard int, peer Peer) {
+			defer wg.Done()
+			if err := fn(ctx, shard, peer); err != nil {
+				glog.Errorf("Leaving out the metrics of shard %d, which failed to list them: %v", shard, err)
+				peerFailures.WithLabelValues(strconv.Itoa(shard)).Inc()
+			}
+		}(shard, peer)
+	}
+	wg.Wait()
+}
+
+// get calls fn with the peer, and returns the error of the API for the item it failed
+// to get.
+func (this *Peers) get(ctx genericapirequest.Context, groupResource schema.GroupResource, name string, shard int,
+	fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, this.timeout)
+	defer cancel()
+	err := fn(ctx)
+	switch grpc.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.NotFound:
+		return errors.NewNotFound(groupResource, name)
+	}
+	glog.Errorf("Failed to get the metrics of %s from shard %d: %v", name, shard, err)
+	peerFailures.WithLabelValues(strconv.Itoa(shard)).Inc()
+	if grpc.Code(err) == codes.Unavailable {
+		return errors.NewServiceUnavailable(grpc.ErrorDesc(err))
+	}
+	return errors.NewInternalError(err)
+}
+
+// request returns the request of the peers for the API request.
+func (this *Peers) request(ctx genericapirequest.Context, options *metainternalversion.ListOptions) *grpcapi.ListMetricsRequest {
+	request := &grpcapi.ListMetricsRequest{
+		Namespace:   genericapirequest.NamespaceValue(ctx),
+		GroupBy:     util.GroupByFrom(ctx),
+		WindowNanos: int64(util.WindowFrom(ctx)),
+	}
+	if options != nil && options.LabelSelector != nil {
+		request.LabelSelector = options.LabelSelector.String()
+	}
+	if timestamp := util.TimeFrom(ctx); !timestamp.IsZero() {
+		request.TimeNanos = timestamp.UnixNano()
+	}
+	if include, found := util.IncludeUnschedulableFrom(ctx); found {
+		request.IncludeUnschedulable = strconv.FormatBool(include)
+	}
+	return request
+}
+
+// NodeStorage serves the NodeMetrics of the nodes of all shards, from the storage of this
+// shard and the peers. Lists are sorted by name and carry the resourceVersion of this
+// shard. Peers which fail are left out of lists.
+type NodeStorage struct {
+	*nodemetricsstorage.MetricStorage
+	groupResource schema.GroupResource
+	peers         *Peers
+}
+
+var _ rest.Getter = &NodeStorage{}
+var _ rest.Lister = &NodeStorage{}
+
+func NewNodeStorage(groupResource schema.GroupResource, local *nodemetricsstorage.MetricStorage, peers *Peers) *NodeStorage {
+	return &NodeStorage{
+		MetricStorage: local,
+		groupResource: groupResource,
+		peers:         peers,
+	}
+}
+
+// Lister interface
+func (this *NodeStorage) List(ctx genericapirequest.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	obj, err := this.MetricStorage.List(ctx, options)
+	if err != nil {
+		return obj, err
+	}
+	res := obj.(*metrics.NodeMetricsList)
+	request := this.peers.request(ctx, options)
+	listed := make([][]metrics.NodeMetrics, len(this.peers.peers))
+	this.peers.fanOut(ctx, func(ctx context.Context, shard int, peer Peer) error {
+		var items []metrics.NodeMetrics
+		err := peer.ListNodeMetrics(ctx, request, func(in *v1beta1.NodeMetrics) error {
+			item := metrics.NodeMetrics{}
+			if err := this.peers.convertor.Convert(in, &item, nil); err != nil {
+				return err
+			}
+			items = append(items, item)
+			return nil
+		})
+		if err == nil {
+			listed[shard] = items
+		}
+		return err
+	})
+
+	// The peers don't get the field selector, and their groups aren't named after nodes.
+	grouped := util.GroupByFrom(ctx) != ""
+	selection := util.FieldSelectionFrom(ctx)
+	for _, items := range listed {
+		for i := range items {
+			if !grouped && !util.MatchesFieldSelector(options, "", items[i].Name) {
+				continue
+			}
+			if selection != nil {
+				selection.FilterNodeMetrics(&items[i])
+			}
+			res.Items = append(res.Items, items[i])
+		}
+	}
+	if grouped {
+		res.Items = mergeGroups(res.Items)
+	}
+	sort.Slice(res.Items, func(i, j int) bool { return res.Items[i].Name < res.Items[j].Name })
+	return res, nil
+}
+
+// mergeGroups merges the groups of the shards with the same label value, adding up their
+// usage and number of nodes.
+func mergeGroups(items []metrics.NodeMetrics) []metrics.NodeMetrics {
+	merged := make([]metrics.NodeMetrics, 0, len(items))
+	byName := make(map[string]int, len(items))
+	for _, item := range items {
+		i, found := byName[item.Name]
+		if !found {
+			byName[item.Name] = len(merged)
+			merged = append(merged, item)
+			continue
+		}
+		group := &merged[i]
+		usage := metrics.ResourceList{}
+		util.AddResourceList(usage, group.Usage)
+		util.AddResourceList(usage, item.Usage)
+		group.Usage = usage
+		if nodes, found := item.Annotations[util.AggregatedNodesAnnotation]; found {
+			count, _ := strconv.Atoi(group.Annotations[util.AggregatedNodesAnnotation])
+			added, _ := strconv.Atoi(nodes)
+			annotations := make(map[string]string, len(group.Annotations))
+			for key, value := range group.Annotations {
+				annotations[key] = value
+			}
+			annotations[util.AggregatedNodesAnnotation] = strconv.Itoa(count + added)
+			group.Annotations = annotations
+		}
+	}
+	return merged
+}
+
+// Getter interface
+// Nodes of other shards are got from their peer.
+func (this *NodeStorage) Get(ctx genericapirequest.Context, name string, opts *metav1.GetOptions) (runtime.Object, error) {
+	shard, peer := this.peers.owner(name)
+	if peer == nil {
+		return this.MetricStorage.Get(ctx, name, opts)
+	}
+	request := this.peers.request(ctx, nil)
+	request.Name = name
+	var item *metrics.NodeMetrics
+	err := this.peers.get(ctx, this.groupResource, name, shard, func(ctx context.Context) error {
+		return peer.ListNodeMetrics(ctx, request, func(in *v1beta1.NodeMetrics) error {
+			item = &metrics.NodeMetrics{}
+			return this.peers.convertor.Convert(in, item, nil)
+		})
+	})
+	if err != nil {
+		return &metrics.NodeMetrics{}, err
+	}
+	if item == nil {
+		return &metrics.NodeMetrics{}, errors.NewNotFound(this.groupResource, name)
+	}
+	if selection := util.FieldSelectionFrom(ctx); selection != nil {
+		selection.FilterNodeMetrics(item)
+	}
+	return item, nil
+}
+
+// PodStorage serves the PodMetrics of the pods of all shards, from the storage of this
+// shard and the peers. Lists are sorted by namespace and name and carry the resourceVersion
+// of this shard. Peers which fail are left out of lists.
+type PodStorage struct {
+	*podmetricsstorage.MetricStorage
+	groupResource schema.GroupResource
+	podLister     v1listers.PodLister
+	peers         *Peers
+}
+
+var _ rest.Getter = &PodStorage{}
+var _ rest.Lister = &PodStorage{}
+
+func NewPodStorage(groupResource schema.GroupResource, local *podmetricsstorage.MetricStorage, podLister v1listers.PodLister, peers *Peers) *PodStorage {
+	return &PodStorage{
+		MetricStorage: local,
+		groupResource: groupResource,
+		podLister:     podLister,
+		peers:         peers,
+	}
+}
+
+// Lister interface
+func (this *PodStorage) List(ctx genericapirequest.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	obj, err := this.MetricStorage.List(ctx, options)
+	if err != nil {
+		return obj, err
+	}
+	res := obj.(*metrics.PodMetricsList)
+	request := this.peers.request(ctx, options)
+	listed := make([][]metrics.PodMetrics, len(this.peers.peers))
+	this.peers.fanOut(ctx, func(ctx context.Context, shard int, peer Peer) error {
+		var items []metrics.PodMetrics
+		err := peer.ListPodMetrics(ctx, request, func(in *v1beta1.PodMetrics) error {
+			item := metrics.PodMetrics{}
+			if err := this.peers.convertor.Convert(in, &item, nil); err != nil {
+				return err
+			}
+			items = append(items, item)
+			return nil
+		})
+		if err == nil {
+			listed[shard] = items
+		}
+		return err
+	})
+
+	// The peers don't get the field selector.
+	selection := util.FieldSelectionFrom(ctx)
+	for _, items := range listed {
+		for i := range items {
+			if !util.MatchesFieldSelector(options, items[i].Namespace, items[i].Name) {
+				continue
+			}
+			if selection != nil {
+				selection.FilterPodMetrics(&items[i])
+			}
+			res.Items = append(res.Items, items[i])
+		}
+	}
+	sort.Slice(res.Items, func(i, j int) bool {
+		a, b := res.Items[i], res.Items[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return res, nil
+}
+
+// Getter interface
+// Pods of the nodes of other shards are got from their peer.
+func (this *PodStorage) Get(ctx genericapirequest.Context, name string, opts *metav1.GetOptions) (runtime.Object, error) {
+	namespace := genericapirequest.NamespaceValue(ctx)
+	pod, err := this.podLister.Pods(namespace).Get(name)
+	if err != nil {
+		// Also reported by the storage of this shard.
+		return this.MetricStorage.Get(ctx, name, opts)
+	}
+	shard, peer := this.peers.owner(pod.Spec.NodeName)
+	if peer == nil {
+		return this.MetricStorage.Get(ctx, name, opts)
+	}
+	request := this.peers.request(ctx, nil)
+	request.Name = name
+	var item *metrics.PodMetrics
+	key := namespace + "/" + name
+	err = this.peers.get(ctx, this.groupResource, key, shard, func(ctx context.Context) error {
+		return peer.ListPodMetrics(ctx, request, func(in *v1beta1.PodMetrics) error {
+			item = &metrics.PodMetrics{}
+			return this.peers.convertor.Convert(in, item, nil)
+		})
+	})
+	if err != nil {
+		return &metrics.PodMetrics{}, err
+	}
+	if item == nil {
+		return &metrics.PodMetrics{}, errors.NewNotFound(this.groupResource, key)
+	}
+	if selection := util.FieldSelectionFrom(ctx); selection != nil {
+		selection.FilterPodMetrics(item)
+	}
+	return item, nil
+}
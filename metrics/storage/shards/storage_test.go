@@ -0,0 +1,254 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shards
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"github.com/kubernetes-incubator/metrics-server/metrics/grpcapi"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	nodemetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/nodemetrics"
+	podmetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/podmetrics"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/metrics/pkg/apis/metrics"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// fakePeer serves its items, or fails with err, and records the requests.
+type fakePeer struct {
+	nodes    []v1beta1.NodeMetrics
+	pods     []v1beta1.PodMetrics
+	err      error
+	requests []*grpcapi.ListMetricsRequest
+}
+
+func (this *fakePeer) ListNodeMetrics(ctx context.Context, request *grpcapi.ListMetricsRequest, fn func(*v1beta1.NodeMetrics) error) error {
+	this.requests = append(this.requests, request)
+	for i := range this.nodes {
+		if request.Name != "" && request.Name != this.nodes[i].Name {
+			continue
+		}
+		if err := fn(&this.nodes[i]); err != nil {
+			return err
+		}
+	}
+	return this.err
+}
+
+func (this *fakePeer) ListPodMetrics(ctx context.Context, request *grpcapi.ListMetricsRequest, fn func(*v1beta1.PodMetrics) error) error {
+	this.requests = append(this.requests, request)
+	for i := range this.pods {
+		if request.Name != "" && request.Name != this.pods[i].Name {
+			continue
+		}
+		if err := fn(&this.pods[i]); err != nil {
+			return err
+		}
+	}
+	return this.err
+}
+
+// nodeOf returns a node name placed in the shard of two.
+func nodeOf(t *testing.T, shard int, prefix string) string {
+	for i := 0; i < 100; i++ {
+		node := fmt.Sprintf("%s-%d", prefix, i)
+		if metricsutil.ShardOf(node, 2) == shard {
+			return node
+		}
+	}
+	t.Fatalf("no node of shard %d", shard)
+	return ""
+}
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	require.NoError(t, metrics.AddToScheme(scheme))
+	require.NoError(t, v1beta1.AddToScheme(scheme))
+	return scheme
+}
+
+func usageOf(cpu string) v1.ResourceList {
+	return v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}
+}
+
+type testShards struct {
+	local    string
+	remote   string
+	peer     *fakePeer
+	nodes    *NodeStorage
+	pods     *PodStorage
+	podStore cache.Indexer
+}
+
+// newTestShards returns the storages of shard 0 of two, which has one node with a pod,
+// and whose peer has another.
+func newTestShards(t *testing.T) *testShards {
+	s := &testShards{
+		local:    nodeOf(t, 0, "local"),
+		remote:   nodeOf(t, 1, "remote"),
+		peer:     &fakePeer{},
+		podStore: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+	}
+	s.peer.nodes = []v1beta1.NodeMetrics{{ObjectMeta: metav1.ObjectMeta{Name: s.remote}, Usage: usageOf("200m")}}
+	s.peer.pods = []v1beta1.PodMetrics{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "remote-pod"},
+		Containers: []v1beta1.ContainerMetrics{{Name: "c", Usage: usageOf("20m")}},
+	}}
+
+	now := time.Now()
+	sink := metricsink.NewMetricSink(time.Minute, time.Minute, nil)
+	sink.ExportData(&core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey(s.local): {MetricValues: map[string]core.MetricValue{
+				core.MetricCpuUsageRate.Name:     {IntValue: 100},
+				core.MetricMemoryWorkingSet.Name: {IntValue: 1000},
+			}},
+			core.PodContainerKey("ns", "local-pod", "c"): {MetricValues: map[string]core.MetricValue{
+				core.MetricCpuUsageRate.Name:     {IntValue: 10},
+				core.MetricMemoryWorkingSet.Name: {IntValue: 100},
+			}},
+		},
+	})
+	nodeStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range []string{s.local, s.remote} {
+		require.NoError(t, nodeStore.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: node}}))
+	}
+	started := metav1.NewTime(now.Add(-time.Hour))
+	for name, node := range map[string]string{"local-pod": s.local, "remote-pod": s.remote} {
+		require.NoError(t, s.podStore.Add(&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, CreationTimestamp: started},
+			Spec:       v1.PodSpec{NodeName: node, Containers: []v1.Container{{Name: "c"}}},
+			Status:     v1.PodStatus{Phase: v1.PodRunning, StartTime: &started},
+		}))
+	}
+
+	shard := metricsutil.Shard{Index: 0, Count: 2}
+	peers := NewPeers(shard, []Peer{nil, s.peer}, newScheme(t), time.Second)
+	s.nodes = NewNodeStorage(metrics.Resource("nodemetrics"), nodemetricsstorage.NewStorage(metrics.Resource("nodemetrics"), sink,
+		v1listers.NewNodeLister(nodeStore), true, time.Minute, shard), peers)
+	podLister := v1listers.NewPodLister(s.podStore)
+	s.pods = NewPodStorage(metrics.Resource("podmetrics"), podmetricsstorage.NewStorage(metrics.Resource("podmetrics"), sink,
+		podLister, false, nil, false, time.Minute, 0, false, shard), podLister, peers)
+	return s
+}
+
+func TestListNodesOfAllShards(t *testing.T) {
+	s := newTestShards(t)
+	obj, err := s.nodes.List(util.WithIncludeUnschedulable(genericapirequest.NewContext(), false), nil)
+	require.NoError(t, err)
+	items := obj.(*metrics.NodeMetricsList).Items
+	require.Len(t, items, 2)
+	names := []string{items[0].Name, items[1].Name}
+	assert.Equal(t, []string{s.local, s.remote}, names, "sorted by name")
+	cpu := items[1].Usage[metrics.ResourceName(v1.ResourceCPU)]
+	assert.Equal(t, int64(200), cpu.MilliValue())
+	require.Len(t, s.peer.requests, 1, "the peer is only asked for its own nodes")
+	assert.Equal(t, "false", s.peer.requests[0].IncludeUnschedulable)
+
+	s.peer.requests = nil
+	_, err = s.nodes.List(util.WithWindow(genericapirequest.NewContext(), 5*time.Minute), nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5*time.Minute), s.peer.requests[0].WindowNanos, "the window is forwarded")
+
+	s.peer.err = grpc.Errorf(codes.Unavailable, "metrics are stale")
+	obj, err = s.nodes.List(genericapirequest.NewContext(), nil)
+	require.NoError(t, err, "failed peers are left out")
+	items = obj.(*metrics.NodeMetricsList).Items
+	require.Len(t, items, 1)
+	assert.Equal(t, s.local, items[0].Name)
+}
+
+func TestGetNodeOfOtherShard(t *testing.T) {
+	s := newTestShards(t)
+	ctx := genericapirequest.NewContext()
+	obj, err := s.nodes.Get(ctx, s.remote, &metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, s.remote, obj.(*metrics.NodeMetrics).Name)
+	require.Len(t, s.peer.requests, 1)
+	assert.Equal(t, s.remote, s.peer.requests[0].Name)
+
+	_, err = s.nodes.Get(ctx, s.local, &metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, s.peer.requests, 1, "nodes of the shard are served locally")
+
+	s.peer.nodes = nil
+	_, err = s.nodes.Get(ctx, s.remote, &metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+	s.peer.err = grpc.Errorf(codes.NotFound, "not found")
+	_, err = s.nodes.Get(ctx, s.remote, &metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+	s.peer.err = grpc.Errorf(codes.Unavailable, "metrics are stale")
+	_, err = s.nodes.Get(ctx, s.remote, &metav1.GetOptions{})
+	require.IsType(t, &errors.StatusError{}, err)
+	assert.Equal(t, int32(http.StatusServiceUnavailable), err.(*errors.StatusError).Status().Code)
+}
+
+func TestPodsOfAllShards(t *testing.T) {
+	s := newTestShards(t)
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "ns")
+	obj, err := s.pods.List(ctx, nil)
+	require.NoError(t, err)
+	items := obj.(*metrics.PodMetricsList).Items
+	require.Len(t, items, 2)
+	assert.Equal(t, "local-pod", items[0].Name)
+	assert.Equal(t, "remote-pod", items[1].Name)
+	assert.Equal(t, "ns", s.peer.requests[0].Namespace)
+
+	obj, err = s.pods.Get(ctx, "remote-pod", &metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "remote-pod", obj.(*metrics.PodMetrics).Name)
+	assert.Equal(t, "remote-pod", s.peer.requests[1].Name)
+	_, err = s.pods.Get(ctx, "local-pod", &metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, s.peer.requests, 2, "pods of the nodes of the shard are served locally")
+}
+
+func TestMergeGroups(t *testing.T) {
+	group := func(name, cpu, nodes string) metrics.NodeMetrics {
+		return metrics.NodeMetrics{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{util.AggregatedNodesAnnotation: nodes}},
+			Usage:      metrics.ResourceList{metrics.ResourceName(v1.ResourceCPU): resource.MustParse(cpu)},
+		}
+	}
+	shard0 := group("a", "100m", "2")
+	merged := mergeGroups([]metrics.NodeMetrics{shard0, group("b", "50m", "1"), group("a", "300m", "3")})
+	require.Len(t, merged, 2)
+	assert.Equal(t, "a", merged[0].Name)
+	cpu := merged[0].Usage[metrics.ResourceName(v1.ResourceCPU)]
+	assert.Equal(t, int64(400), cpu.MilliValue())
+	assert.Equal(t, "5", merged[0].Annotations[util.AggregatedNodesAnnotation])
+	assert.Equal(t, "2", shard0.Annotations[util.AggregatedNodesAnnotation], "the groups aren't modified")
+	assert.Equal(t, "b", merged[1].Name)
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storagedump serves the points of the latest stored batch, and whether they can
+// be served by the Metrics API, for finding out why metrics of an object are missing.
+package storagedump
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Path under which the stored points are served.
+const Path = "/debug/storage"
+
+// StoredPoint is the latest point of a node or container.
+type StoredPoint struct {
+	Node      string `json:"node,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+
+	ScrapeTime    metav1.Time      `json:"scrapeTime"`
+	CPUMillicores *int64           `json:"cpuMillicores,omitempty"`
+	MemoryBytes   *int64           `json:"memoryBytes,omitempty"`
+	Window        *metav1.Duration `json:"window,omitempty"`
+	// Whether the Metrics API serves the usage of the point.
+	Served bool `json:"served"`
+	// Why the point is not served, or is served over a window deviating from the resolution.
+	Problem string `json:"problem,omitempty"`
+}
+
+// StorageDump lists the points of the latest batch, sorted by node or by pod and container.
+type StorageDump struct {
+	Timestamp metav1.Time `json:"timestamp"`
+	// Whether the batch is too old for the Metrics API to serve.
+	Stale      bool          `json:"stale"`
+	Nodes      []StoredPoint `json:"nodes"`
+	Containers []StoredPoint `json:"containers"`
+}
+
+// NodeFilter is the NodeMetrics storage, which tells why the metrics of a node aren't listed.
+type NodeFilter interface {
+	ListExclusionReason(name string) string
+}
+
+// PodFilter is the PodMetrics storage, which tells why the metrics of a pod aren't listed.
+type PodFilter interface {
+	ListExclusionReason(batch *core.DataBatch, namespace, name string) string
+}
+
+type handler struct {
+	metricSink *metricsink.MetricSink
+	resolution time.Duration
+	nodes      NodeFilter
+	pods       PodFilter
+}
+
+// NewHandler returns a handler serving the points of the latest batch of the sink, served
+// by the storages. Without pod storage no container point is served. The node, namespace
+// and pod query parameters restrict the points to the ones of an object.
+func NewHandler(metricSink *metricsink.MetricSink, resolution time.Duration, nodes NodeFilter, pods PodFilter) http.Handler {
+	return &handler{
+		metricSink: metricSink,
+		resolution: resolution,
+		nodes:      nodes,
+		pods:       pods,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	batch := h.metricSink.GetLatestDataBatch()
+	if batch == nil {
+		http.Error(w, "no metrics have been collected yet", http.StatusServiceUnavailable)
+		return
+	}
+	query := req.URL.Query()
+	dump := h.getStorageDump(batch, util.IsStale(h.metricSink, batch), query.Get("node"), query.Get("namespace"), query.Get("pod"))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dump); err != nil {
+		glog.Errorf("Error while encoding storage dump: %v", err)
+	}
+}
+
+func (h *handler) getStorageDump(batch *core.DataBatch, stale bool, node, namespace, pod string) *StorageDump {
+	dump := &StorageDump{
+		Timestamp:  metav1.NewTime(batch.Timestamp),
+		Stale:      stale,
+		Nodes:      []StoredPoint{},
+		Containers: []StoredPoint{},
+	}
+	for key, ms := range batch.MetricSets {
+		if node != "" && ms.Labels[core.LabelNodename.Key] != node {
+			continue
+		}
+		switch ms.Labels[core.LabelMetricSetType.Key] {
+		case core.MetricSetTypeNode:
+			if namespace != "" || pod != "" {
+				continue
+			}
+			point := getStoredPoint(batch, key, ms, h.resolution, dump.Stale, func() string {
+				return h.nodes.ListExclusionReason(ms.Labels[core.LabelNodename.Key])
+			})
+			point.Node = ms.Labels[core.LabelNodename.Key]
+			dump.Nodes = append(dump.Nodes, point)
+		case core.MetricSetTypePodContainer:
+			if (namespace != "" && ms.Labels[core.LabelNamespaceName.Key] != namespace) || (pod != "" && ms.Labels[core.LabelPodName.Key] != pod) {
+				continue
+			}
+			point := getStoredPoint(batch, key, ms, h.resolution, dump.Stale, func() string {
+				if h.pods == nil {
+					return "pods are not served"
+				}
+				return h.pods.ListExclusionReason(batch, ms.Labels[core.LabelNamespaceName.Key], ms.Labels[core.LabelPodName.Key])
+			})
+			point.Node = ms.Labels[core.LabelNodename.Key]
+			point.Namespace = ms.Labels[core.LabelNamespaceName.Key]
+			point.Pod = ms.Labels[core.LabelPodName.Key]
+			point.Container = ms.Labels[core.LabelContainerName.Key]
+			dump.Containers = append(dump.Containers, point)
+		}
+	}
+	sort.Slice(dump.Nodes, func(i, j int) bool { return dump.Nodes[i].Node < dump.Nodes[j].Node })
+	sort.Slice(dump.Containers, func(i, j int) bool {
+		a, b := dump.Containers[i], dump.Containers[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+		return a.Container < b.Container
+	})
+	return dump
+}
+
+// getStoredPoint checks the point the way the Metrics API does before serving it, with
+// exclusionReason telling why the storage leaves out its object.
+func getStoredPoint(batch *core.DataBatch, key string, ms *core.MetricSet, resolution time.Duration, stale bool, exclusionReason func() string) StoredPoint {
+	point := StoredPoint{ScrapeTime: metav1.NewTime(ms.ScrapeTime)}
+	if cpu, found := ms.MetricValues[core.MetricCpuUsageRate.Name]; found {
+		point.CPUMillicores = &cpu.IntValue
+	}
+	if memory, found := ms.MetricValues[core.MetricMemoryWorkingSet.Name]; found {
+		point.MemoryBytes = &memory.IntValue
+	}
+	if window, found := ms.MetricValues[core.MetricCpuUsageRateWindow.Name]; found {
+		point.Window = &metav1.Duration{Duration: time.Duration(window.IntValue)}
+	}
+
+	if _, err := util.ParseResourceList(ms); err != nil {
+		point.Problem = err.Error()
+		return point
+	}
+	if stale {
+		point.Problem = util.MetricsStaleMessage(batch)
+		return point
+	}
+	if reason := exclusionReason(); reason != "" {
+		point.Problem = reason
+		return point
+	}
+	point.Served = true
+	if window, found := util.GetDeviatingWindow(batch, []string{key}, resolution); found {
+		point.Problem = fmt.Sprintf("cpu usage measured over %s, deviating from the %s resolution", window, resolution)
+	}
+	return point
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagedump
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/metrics/pkg/apis/metrics"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	nodemetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/nodemetrics"
+	podmetricsstorage "github.com/kubernetes-incubator/metrics-server/metrics/storage/podmetrics"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+)
+
+var _ NodeFilter = &nodemetricsstorage.MetricStorage{}
+var _ PodFilter = &podmetricsstorage.MetricStorage{}
+
+// newTestHandler returns a handler of the sink whose storages list the nodes and pods of
+// testBatch, with pod3 completed.
+func newTestHandler(t *testing.T, sink *metricsink.MetricSink) *handler {
+	nodeStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range []string{"n1", "n2"} {
+		require.NoError(t, nodeStore.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+	podStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range []struct{ namespace, name, node string }{{"ns1", "pod1", "n1"}, {"ns2", "pod2", "n2"}, {"ns2", "pod3", "n2"}} {
+		phase := v1.PodRunning
+		if pod.name == "pod3" {
+			phase = v1.PodSucceeded
+		}
+		require.NoError(t, podStore.Add(&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: pod.namespace, Name: pod.name},
+			Spec:       v1.PodSpec{NodeName: pod.node},
+			Status:     v1.PodStatus{Phase: phase},
+		}))
+	}
+	nodes := nodemetricsstorage.NewStorage(metrics.Resource("nodemetrics"), sink, v1listers.NewNodeLister(nodeStore), true, time.Minute, metricsutil.Shard{})
+	pods := podmetricsstorage.NewStorage(metrics.Resource("podmetrics"), sink, v1listers.NewPodLister(podStore), false, nil, false, time.Minute, 0, false, metricsutil.Shard{})
+	return NewHandler(sink, time.Minute, nodes, pods).(*handler)
+}
+
+func testBatch(timestamp time.Time) *core.DataBatch {
+	metricSet := func(labels map[string]string, values map[string]core.MetricValue) *core.MetricSet {
+		return &core.MetricSet{ScrapeTime: timestamp, Labels: labels, MetricValues: values}
+	}
+	usage := map[string]core.MetricValue{
+		core.MetricCpuUsageRate.Name:       {IntValue: 100},
+		core.MetricMemoryWorkingSet.Name:   {IntValue: 1000},
+		core.MetricCpuUsageRateWindow.Name: {IntValue: int64(time.Minute)},
+	}
+	return &core.DataBatch{
+		Timestamp: timestamp,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("n1"): metricSet(map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+				core.LabelNodename.Key:      "n1",
+			}, usage),
+			core.NodeKey("n2"): metricSet(map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+				core.LabelNodename.Key:      "n2",
+			}, map[string]core.MetricValue{
+				core.MetricCpuUsageRate.Name:       {IntValue: 100},
+				core.MetricMemoryWorkingSet.Name:   {IntValue: 1000},
+				core.MetricCpuUsageRateWindow.Name: {IntValue: int64(5 * time.Minute)},
+			}),
+			core.PodContainerKey("ns1", "pod1", "c1"): metricSet(map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+				core.LabelNodename.Key:      "n1",
+				core.LabelNamespaceName.Key: "ns1",
+				core.LabelPodName.Key:       "pod1",
+				core.LabelContainerName.Key: "c1",
+			}, map[string]core.MetricValue{
+				core.MetricMemoryWorkingSet.Name: {IntValue: 1000},
+			}),
+			core.PodContainerKey("ns2", "pod2", "c1"): metricSet(map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+				core.LabelNodename.Key:      "n2",
+				core.LabelNamespaceName.Key: "ns2",
+				core.LabelPodName.Key:       "pod2",
+				core.LabelContainerName.Key: "c1",
+			}, usage),
+			core.PodContainerKey("ns2", "pod3", "c1"): metricSet(map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+				core.LabelNodename.Key:      "n2",
+				core.LabelNamespaceName.Key: "ns2",
+				core.LabelPodName.Key:       "pod3",
+				core.LabelContainerName.Key: "c1",
+			}, usage),
+			core.ClusterKey(): metricSet(map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypeCluster,
+			}, usage),
+		},
+	}
+}
+
+func TestGetStorageDump(t *testing.T) {
+	now := time.Now()
+	h := newTestHandler(t, metricsink.NewMetricSink(time.Minute, time.Minute, []string{}))
+	dump := h.getStorageDump(testBatch(now), false, "", "", "")
+	assert.False(t, dump.Stale)
+	require.Len(t, dump.Nodes, 2)
+	assert.Equal(t, "n1", dump.Nodes[0].Node)
+	assert.True(t, dump.Nodes[0].Served)
+	assert.Empty(t, dump.Nodes[0].Problem)
+	assert.Equal(t, int64(100), *dump.Nodes[0].CPUMillicores)
+	assert.True(t, dump.Nodes[1].Served)
+	assert.Contains(t, dump.Nodes[1].Problem, "5m0s, deviating from the 1m0s resolution")
+
+	require.Len(t, dump.Containers, 3)
+	assert.Equal(t, "pod1", dump.Containers[0].Pod)
+	assert.False(t, dump.Containers[0].Served)
+	assert.Equal(t, "cpu not found", dump.Containers[0].Problem)
+	assert.Nil(t, dump.Containers[0].CPUMillicores)
+	assert.Equal(t, "pod2", dump.Containers[1].Pod)
+	assert.True(t, dump.Containers[1].Served)
+	assert.Equal(t, "pod3", dump.Containers[2].Pod)
+	assert.False(t, dump.Containers[2].Served, "completed pods aren't listed")
+	assert.Equal(t, "Succeeded pod, listed only with --list_completed_pods", dump.Containers[2].Problem)
+
+	dump = h.getStorageDump(testBatch(now), false, "n2", "", "")
+	assert.Len(t, dump.Nodes, 1)
+	assert.Len(t, dump.Containers, 2)
+	dump = h.getStorageDump(testBatch(now), false, "", "ns1", "pod1")
+	assert.Empty(t, dump.Nodes)
+	require.Len(t, dump.Containers, 1)
+	assert.Equal(t, "c1", dump.Containers[0].Container)
+
+	dump = h.getStorageDump(testBatch(now.Add(-time.Hour)), true, "", "", "")
+	assert.True(t, dump.Stale)
+	assert.False(t, dump.Nodes[0].Served)
+	assert.Contains(t, dump.Nodes[0].Problem, "metrics are stale")
+}
+
+func TestHandler(t *testing.T) {
+	sink := metricsink.NewMetricSink(time.Minute, time.Minute, []string{})
+	handler := newTestHandler(t, sink)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", Path, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	sink.ExportData(testBatch(time.Now()))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", Path+"?namespace=ns2", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	dump := &StorageDump{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), dump))
+	require.Len(t, dump.Containers, 2)
+	assert.Equal(t, "pod2", dump.Containers[0].Pod)
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemmetrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Path under which the system container metrics are served.
+const Path = "/systemcontainermetrics"
+
+// Usage of the node-level system containers (kubelet, runtime, system.slice, ...) of a node.
+type SystemContainerMetrics struct {
+	Node       string                     `json:"node"`
+	Timestamp  metav1.Time                `json:"timestamp"`
+	Window     metav1.Duration            `json:"window"`
+	Containers []metrics.ContainerMetrics `json:"containers"`
+	// Usage of the whole node and the summed usage of the containers of its pods.
+	NodeUsage metrics.ResourceList `json:"nodeUsage,omitempty"`
+	PodsUsage metrics.ResourceList `json:"podsUsage,omitempty"`
+	// Usage of the node not accounted to pods, i.e. the node overhead. Only set when the
+	// node usage is known, and never negative.
+	Overhead metrics.ResourceList `json:"overhead,omitempty"`
+}
+
+type SystemContainerMetricsList struct {
+	Items []SystemContainerMetrics `json:"items"`
+}
+
+type handler struct {
+	metricSink *metricsink.MetricSink
+}
+
+// NewHandler returns a handler serving the usage of the system containers reported in
+// the kubelet summaries, along with the overhead of the nodes over the usage of their
+// pods. The node can be selected with the node query parameter.
+func NewHandler(metricSink *metricsink.MetricSink) http.Handler {
+	return &handler{
+		metricSink: metricSink,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	batch := h.metricSink.GetLatestDataBatch()
+	if util.IsStale(h.metricSink, batch) {
+		http.Error(w, util.MetricsStaleMessage(batch), http.StatusServiceUnavailable)
+		return
+	}
+	list := getSystemContainerMetrics(batch, req.URL.Query().Get("node"))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		glog.Errorf("Error while encoding system container metrics: %v", err)
+	}
+}
+
+func getSystemContainerMetrics(batch *core.DataBatch, node string) *SystemContainerMetricsList {
+	nodes := make(map[string]*SystemContainerMetrics)
+	getItem := func(nodeName string) *SystemContainerMetrics {
+		item, found := nodes[nodeName]
+		if !found {
+			item = &SystemContainerMetrics{
+				Node:       nodeName,
+				Timestamp:  metav1.NewTime(batch.Timestamp),
+				Window:     metav1.Duration{Duration: util.FastWindowDuration},
+				Containers: []metrics.ContainerMetrics{},
+			}
+			nodes[nodeName] = item
+		}
+		return item
+	}
+	for _, ms := range batch.MetricSets {
+		msType := ms.Labels[core.LabelMetricSetType.Key]
+		if msType != core.MetricSetTypeSystemContainer && msType != core.MetricSetTypeNode && msType != core.MetricSetTypePodContainer {
+			continue
+		}
+		nodeName := ms.Labels[core.LabelNodename.Key]
+		if nodeName == "" || (node != "" && nodeName != node) {
+			continue
+		}
+		usage, err := util.ParseResourceList(ms)
+		if err != nil {
+			continue
+		}
+		item := getItem(nodeName)
+		switch msType {
+		case core.MetricSetTypeSystemContainer:
+			item.Containers = append(item.Containers, metrics.ContainerMetrics{
+				Name:  ms.Labels[core.LabelContainerName.Key],
+				Usage: usage,
+			})
+		case core.MetricSetTypeNode:
+			item.NodeUsage = usage
+		case core.MetricSetTypePodContainer:
+			if item.PodsUsage == nil {
+				item.PodsUsage = metrics.ResourceList{}
+			}
+			util.AddResourceList(item.PodsUsage, usage)
+		}
+	}
+
+	res := &SystemContainerMetricsList{Items: make([]SystemContainerMetrics, 0, len(nodes))}
+	for _, item := range nodes {
+		sort.Slice(item.Containers, func(i, j int) bool { return item.Containers[i].Name < item.Containers[j].Name })
+		if item.NodeUsage != nil {
+			item.Overhead = getOverhead(item.NodeUsage, item.PodsUsage)
+		}
+		res.Items = append(res.Items, *item)
+	}
+	sort.Slice(res.Items, func(i, j int) bool { return res.Items[i].Node < res.Items[j].Node })
+	return res
+}
+
+// getOverhead returns the node usage minus the pods usage, at least zero per resource.
+func getOverhead(nodeUsage, podsUsage metrics.ResourceList) metrics.ResourceList {
+	overhead := metrics.ResourceList{}
+	for name, quantity := range nodeUsage {
+		diff := quantity.DeepCopy()
+		if pods, found := podsUsage[name]; found {
+			diff.Sub(pods)
+		}
+		if diff.Sign() < 0 {
+			diff.Set(0)
+		}
+		overhead[name] = diff
+	}
+	return overhead
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemmetrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+)
+
+func systemContainerMetrics(node, container string, cpu, mem int64) *core.MetricSet {
+	return &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypeSystemContainer,
+			core.LabelNodename.Key:      node,
+			core.LabelContainerName.Key: container,
+		},
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: mem},
+		},
+	}
+}
+
+func TestSystemContainerMetrics(t *testing.T) {
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, []string{})
+	metricSink.ExportData(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeContainerKey("n1", "kubelet"):       systemContainerMetrics("n1", "kubelet", 100, 1000),
+			core.NodeContainerKey("n1", "docker-daemon"): systemContainerMetrics("n1", "docker-daemon", 50, 500),
+			core.NodeContainerKey("n2", "kubelet"):       systemContainerMetrics("n2", "kubelet", 10, 100),
+			core.NodeKey("n1"): {
+				Labels: map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypeNode},
+			},
+		},
+	})
+	h := NewHandler(metricSink)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	list := &SystemContainerMetricsList{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), list))
+	require.Len(t, list.Items, 2)
+	assert.Equal(t, "n1", list.Items[0].Node)
+	require.Len(t, list.Items[0].Containers, 2)
+	assert.Equal(t, "docker-daemon", list.Items[0].Containers[0].Name)
+	assert.Equal(t, "kubelet", list.Items[0].Containers[1].Name)
+	cpu := list.Items[0].Containers[1].Usage["cpu"]
+	assert.Equal(t, int64(100), cpu.MilliValue())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path+"?node=n2", nil))
+	list = &SystemContainerMetricsList{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), list))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "n2", list.Items[0].Node)
+}
+
+func TestNodeOverhead(t *testing.T) {
+	node := systemContainerMetrics("n1", "", 500, 5000)
+	node.Labels[core.LabelMetricSetType.Key] = core.MetricSetTypeNode
+	container := func(pod string, cpu, mem int64) *core.MetricSet {
+		ms := systemContainerMetrics("n1", "app", cpu, mem)
+		ms.Labels[core.LabelMetricSetType.Key] = core.MetricSetTypePodContainer
+		ms.Labels[core.LabelPodName.Key] = pod
+		return ms
+	}
+	list := getSystemContainerMetrics(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("n1"):                      node,
+			core.NodeContainerKey("n1", "kubelet"):  systemContainerMetrics("n1", "kubelet", 100, 1000),
+			core.PodContainerKey("ns", "p1", "app"): container("p1", 200, 1000),
+			core.PodContainerKey("ns", "p2", "app"): container("p2", 100, 5000),
+			core.NodeContainerKey("n2", "kubelet"):  systemContainerMetrics("n2", "kubelet", 10, 100),
+		},
+	}, "")
+
+	require.Len(t, list.Items, 2)
+	n1 := list.Items[0]
+	cpu, mem := n1.PodsUsage["cpu"], n1.PodsUsage["memory"]
+	assert.Equal(t, int64(300), cpu.MilliValue())
+	assert.Equal(t, int64(6000), mem.Value())
+	cpu, mem = n1.Overhead["cpu"], n1.Overhead["memory"]
+	assert.Equal(t, int64(200), cpu.MilliValue())
+	assert.Equal(t, int64(0), mem.Value(), "overhead isn't negative")
+	assert.Nil(t, list.Items[1].Overhead, "node usage unknown")
+}
+
+func TestSystemContainerMetricsWithoutData(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHandler(metricsink.NewMetricSink(time.Minute, time.Minute, []string{})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
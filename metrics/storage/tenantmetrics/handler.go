@@ -0,0 +1,146 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenantmetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Path under which the tenant metrics are served.
+const Path = "/tenantmetrics"
+
+// Aggregated usage of the pods in all namespaces of a tenant.
+type TenantMetrics struct {
+	Tenant    string          `json:"tenant"`
+	Timestamp metav1.Time     `json:"timestamp"`
+	Window    metav1.Duration `json:"window"`
+	// Number of namespaces and pods for which metrics were available.
+	Namespaces int                  `json:"namespaces"`
+	Pods       int                  `json:"pods"`
+	Usage      metrics.ResourceList `json:"usage"`
+}
+
+type TenantMetricsList struct {
+	Items []TenantMetrics `json:"items"`
+}
+
+type handler struct {
+	metricSink *metricsink.MetricSink
+	authorizer authorizer.Authorizer
+	mapper     genericapirequest.RequestContextMapper
+}
+
+// NewHandler returns a handler serving the container usage aggregated by the tenant
+// label of the pods. Pods without a tenant are left out. The tenant can be selected
+// with the tenant query parameter. Only the namespaces the user may list the PodMetrics
+// of are aggregated, so tenants without such a namespace are not served at all.
+func NewHandler(metricSink *metricsink.MetricSink, authz authorizer.Authorizer, mapper genericapirequest.RequestContextMapper) http.Handler {
+	return &handler{
+		metricSink: metricSink,
+		authorizer: authz,
+		mapper:     mapper,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx, ok := h.mapper.Get(req)
+	if !ok {
+		http.Error(w, "no context found for request", http.StatusInternalServerError)
+		return
+	}
+	userInfo, ok := genericapirequest.UserFrom(ctx)
+	if !ok {
+		http.Error(w, "no user found for request", http.StatusUnauthorized)
+		return
+	}
+	batch := h.metricSink.GetLatestDataBatch()
+	if util.IsStale(h.metricSink, batch) {
+		http.Error(w, util.MetricsStaleMessage(batch), http.StatusServiceUnavailable)
+		return
+	}
+	namespaces, err := util.NewNamespaceFilter(h.authorizer, userInfo)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to authorize: %v", err), http.StatusInternalServerError)
+		return
+	}
+	list := getTenantMetrics(batch, req.URL.Query().Get("tenant"), namespaces.Allowed)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		glog.Errorf("Error while encoding tenant metrics: %v", err)
+	}
+}
+
+type tenantUsage struct {
+	item       *TenantMetrics
+	namespaces map[string]bool
+	pods       map[string]bool
+}
+
+func getTenantMetrics(batch *core.DataBatch, tenant string, allowed func(namespace string) bool) *TenantMetricsList {
+	tenants := make(map[string]*tenantUsage)
+	for _, ms := range batch.MetricSets {
+		if ms.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePodContainer {
+			continue
+		}
+		tenantName := ms.Labels[core.LabelTenant.Key]
+		namespace := ms.Labels[core.LabelNamespaceName.Key]
+		if tenantName == "" || (tenant != "" && tenantName != tenant) || !allowed(namespace) {
+			continue
+		}
+		usage, err := util.ParseResourceList(ms)
+		if err != nil {
+			continue
+		}
+		t, found := tenants[tenantName]
+		if !found {
+			t = &tenantUsage{
+				item: &TenantMetrics{
+					Tenant:    tenantName,
+					Timestamp: metav1.NewTime(batch.Timestamp),
+					Window:    metav1.Duration{Duration: util.FastWindowDuration},
+					Usage:     metrics.ResourceList{},
+				},
+				namespaces: map[string]bool{},
+				pods:       map[string]bool{},
+			}
+			tenants[tenantName] = t
+		}
+		t.namespaces[namespace] = true
+		t.pods[namespace+"/"+ms.Labels[core.LabelPodName.Key]] = true
+		util.AddResourceList(t.item.Usage, usage)
+	}
+
+	res := &TenantMetricsList{Items: make([]TenantMetrics, 0, len(tenants))}
+	for _, t := range tenants {
+		t.item.Namespaces = len(t.namespaces)
+		t.item.Pods = len(t.pods)
+		res.Items = append(res.Items, *t.item)
+	}
+	sort.Slice(res.Items, func(i, j int) bool { return res.Items[i].Tenant < res.Items[j].Tenant })
+	return res
+}
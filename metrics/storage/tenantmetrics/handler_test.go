@@ -0,0 +1,127 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenantmetrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// testAuthorizer allows admin everything, and other users to list the pods in namespace a1.
+func testAuthorizer(a authorizer.Attributes) (bool, string, error) {
+	return a.GetUser().GetName() == "admin" || a.GetNamespace() == "a1", "", nil
+}
+
+// newTestHandler returns the handler with requests authenticated as the user named in
+// their X-Remote-User header.
+func newTestHandler(metricSink *metricsink.MetricSink) http.Handler {
+	mapper := genericapirequest.NewRequestContextMapper()
+	handler := NewHandler(metricSink, authorizer.AuthorizerFunc(testAuthorizer), mapper)
+	return genericapirequest.WithRequestContext(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if name := req.Header.Get("X-Remote-User"); name != "" {
+			ctx, _ := mapper.Get(req)
+			mapper.Update(req, genericapirequest.WithUser(ctx, &user.DefaultInfo{Name: name}))
+		}
+		handler.ServeHTTP(w, req)
+	}), mapper)
+}
+
+func get(h http.Handler, path, userName string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if userName != "" {
+		req.Header.Set("X-Remote-User", userName)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func containerMetrics(tenant, namespace, pod, container string, cpu, mem int64) *core.MetricSet {
+	labels := map[string]string{
+		core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+		core.LabelNamespaceName.Key: namespace,
+		core.LabelPodName.Key:       pod,
+		core.LabelContainerName.Key: container,
+	}
+	if tenant != "" {
+		labels[core.LabelTenant.Key] = tenant
+	}
+	return &core.MetricSet{
+		Labels: labels,
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: mem},
+		},
+	}
+}
+
+func TestTenantMetrics(t *testing.T) {
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, []string{})
+	metricSink.ExportData(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("a1", "p1", "c1"): containerMetrics("a", "a1", "p1", "c1", 100, 1000),
+			core.PodContainerKey("a1", "p1", "c2"): containerMetrics("a", "a1", "p1", "c2", 50, 500),
+			core.PodContainerKey("a2", "p1", "c1"): containerMetrics("a", "a2", "p1", "c1", 10, 100),
+			core.PodContainerKey("b1", "p1", "c1"): containerMetrics("b", "b1", "p1", "c1", 1, 10),
+			core.PodContainerKey("x", "p1", "c1"):  containerMetrics("", "x", "p1", "c1", 1000, 10000),
+		},
+	})
+	h := newTestHandler(metricSink)
+
+	rec := get(h, Path, "admin")
+	require.Equal(t, http.StatusOK, rec.Code)
+	list := &TenantMetricsList{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), list))
+	require.Len(t, list.Items, 2, "pods without tenant are left out")
+	a := list.Items[0]
+	assert.Equal(t, "a", a.Tenant)
+	assert.Equal(t, 2, a.Namespaces)
+	assert.Equal(t, 2, a.Pods)
+	cpu, mem := a.Usage["cpu"], a.Usage["memory"]
+	assert.Equal(t, int64(160), cpu.MilliValue())
+	assert.Equal(t, int64(1600), mem.Value())
+
+	rec = get(h, Path+"?tenant=b", "admin")
+	list = &TenantMetricsList{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), list))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "b", list.Items[0].Tenant)
+	assert.Equal(t, 1, list.Items[0].Pods)
+
+	rec = get(h, Path, "user")
+	require.Equal(t, http.StatusOK, rec.Code)
+	list = &TenantMetricsList{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), list))
+	require.Len(t, list.Items, 1, "only tenants of namespaces the user may list")
+	assert.Equal(t, "a", list.Items[0].Tenant)
+	assert.Equal(t, 1, list.Items[0].Namespaces)
+	cpu = list.Items[0].Usage["cpu"]
+	assert.Equal(t, int64(150), cpu.MilliValue(), "only the namespaces the user may list")
+
+	assert.Equal(t, http.StatusUnauthorized, get(h, Path, "").Code)
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"github.com/golang/glog"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// CanList checks whether the user may list the resource of the Metrics API in the
+// namespace, in all namespaces if empty.
+func CanList(authz authorizer.Authorizer, userInfo user.Info, resource, namespace string) (bool, error) {
+	authorized, _, err := authz.Authorize(authorizer.AttributesRecord{
+		User:            userInfo,
+		Verb:            "list",
+		Namespace:       namespace,
+		APIGroup:        v1beta1.SchemeGroupVersion.Group,
+		APIVersion:      v1beta1.SchemeGroupVersion.Version,
+		Resource:        resource,
+		ResourceRequest: true,
+	})
+	return authorized, err
+}
+
+// NamespaceFilter tells which namespaces a user may list the PodMetrics of, so that
+// endpoints aggregating over namespaces only serve what the Metrics API would. Decisions
+// are cached, so a filter should only be used for a single request.
+type NamespaceFilter struct {
+	authorizer authorizer.Authorizer
+	user       user.Info
+	all        bool
+	decisions  map[string]bool
+}
+
+// NewNamespaceFilter returns the filter of the user, which allows all namespaces if the
+// user may list pods cluster-wide.
+func NewNamespaceFilter(authz authorizer.Authorizer, userInfo user.Info) (*NamespaceFilter, error) {
+	all, err := CanList(authz, userInfo, "pods", "")
+	if err != nil {
+		return nil, err
+	}
+	return &NamespaceFilter{
+		authorizer: authz,
+		user:       userInfo,
+		all:        all,
+		decisions:  map[string]bool{},
+	}, nil
+}
+
+// Allowed checks whether the user may list the PodMetrics of the namespace. Namespaces
+// which can't be authorized are not allowed.
+func (this *NamespaceFilter) Allowed(namespace string) bool {
+	if this.all {
+		return true
+	}
+	allowed, found := this.decisions[namespace]
+	if !found {
+		var err error
+		allowed, err = CanList(this.authorizer, this.user, "pods", namespace)
+		if err != nil {
+			glog.Errorf("Error while authorizing %s to list pods in namespace %s: %v", this.user.GetName(), namespace, err)
+		}
+		this.decisions[namespace] = allowed
+	}
+	return allowed
+}
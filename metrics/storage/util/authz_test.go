@@ -0,0 +1,81 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// fakeAuthorizer allows admin to list pods everywhere and others in ns1. Authorizing
+// in namespace err fails.
+type fakeAuthorizer struct {
+	calls int
+}
+
+func (this *fakeAuthorizer) Authorize(a authorizer.Attributes) (bool, string, error) {
+	this.calls++
+	if a.GetVerb() != "list" || a.GetAPIGroup() != v1beta1.SchemeGroupVersion.Group || a.GetResource() != "pods" {
+		return false, "", nil
+	}
+	if a.GetUser().GetName() == "admin" {
+		return true, "", nil
+	}
+	switch a.GetNamespace() {
+	case "ns1":
+		return true, "", nil
+	case "err":
+		return false, "", fmt.Errorf("unavailable")
+	}
+	return false, "", nil
+}
+
+func TestNamespaceFilter(t *testing.T) {
+	authz := &fakeAuthorizer{}
+	filter, err := NewNamespaceFilter(authz, &user.DefaultInfo{Name: "dev"})
+	require.NoError(t, err)
+	assert.True(t, filter.Allowed("ns1"))
+	assert.False(t, filter.Allowed("ns2"))
+	assert.False(t, filter.Allowed("err"), "namespaces which can't be authorized are not allowed")
+	calls := authz.calls
+	assert.True(t, filter.Allowed("ns1"))
+	assert.False(t, filter.Allowed("ns2"))
+	assert.Equal(t, calls, authz.calls, "decisions are cached")
+
+	authz = &fakeAuthorizer{}
+	filter, err = NewNamespaceFilter(authz, &user.DefaultInfo{Name: "admin"})
+	require.NoError(t, err)
+	assert.True(t, filter.Allowed("ns2"))
+	assert.Equal(t, 1, authz.calls, "cluster-wide access is only checked once")
+}
+
+func TestCanList(t *testing.T) {
+	authz := &fakeAuthorizer{}
+	allowed, err := CanList(authz, &user.DefaultInfo{Name: "dev"}, "nodes", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	allowed, err = CanList(authz, &user.DefaultInfo{Name: "admin"}, "pods", "")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	_, err = CanList(authz, &user.DefaultInfo{Name: "dev"}, "pods", "err")
+	assert.Error(t, err)
+}
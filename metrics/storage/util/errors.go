@@ -0,0 +1,79 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// The latest data batch is missing or too old to be served.
+	StatusReasonMetricsStale metav1.StatusReason = "MetricsStale"
+	// The object exists, but the node it lives on could not be scraped.
+	StatusReasonNodeUnscrapable metav1.StatusReason = "NodeUnscrapable"
+)
+
+// IsStale returns true if the batch of the sink should not be used to serve metrics, as
+// it's older than the maximum delay of the sink.
+func IsStale(metricSink *metricsink.MetricSink, batch *core.DataBatch) bool {
+	return batch == nil || time.Since(batch.Timestamp) > metricSink.MaxDelay()
+}
+
+// MetricsStaleMessage describes why the batch is stale.
+func MetricsStaleMessage(batch *core.DataBatch) string {
+	if batch == nil {
+		return "no metrics have been collected yet"
+	}
+	return fmt.Sprintf("metrics are stale (latest scrape at %s)", batch.Timestamp.Format(time.RFC3339))
+}
+
+// NewMetricsStaleError returns an error indicating that no current metrics are available.
+func NewMetricsStaleError(qualifiedResource schema.GroupResource, name string, batch *core.DataBatch) *errors.StatusError {
+	return &errors.StatusError{ErrStatus: metav1.Status{
+		Status: metav1.StatusFailure,
+		Code:   http.StatusServiceUnavailable,
+		Reason: StatusReasonMetricsStale,
+		Details: &metav1.StatusDetails{
+			Group: qualifiedResource.Group,
+			Kind:  qualifiedResource.Resource,
+			Name:  name,
+		},
+		Message: MetricsStaleMessage(batch),
+	}}
+}
+
+// NewNodeUnscrapableError returns an error indicating that metrics for the object are missing
+// because the given node was not scraped successfully.
+func NewNodeUnscrapableError(qualifiedResource schema.GroupResource, name, node string) *errors.StatusError {
+	return &errors.StatusError{ErrStatus: metav1.Status{
+		Status: metav1.StatusFailure,
+		Code:   http.StatusNotFound,
+		Reason: StatusReasonNodeUnscrapable,
+		Details: &metav1.StatusDetails{
+			Group: qualifiedResource.Group,
+			Kind:  qualifiedResource.Resource,
+			Name:  name,
+		},
+		Message: fmt.Sprintf("%s %q not found: unable to fetch metrics from node %s", qualifiedResource.String(), name, node),
+	}}
+}
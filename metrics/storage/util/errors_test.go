@@ -0,0 +1,37 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+)
+
+func TestIsStale(t *testing.T) {
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, nil)
+	now := time.Now()
+	assert.True(t, IsStale(metricSink, nil))
+	assert.False(t, IsStale(metricSink, &core.DataBatch{Timestamp: now.Add(-2 * time.Minute)}))
+	assert.True(t, IsStale(metricSink, &core.DataBatch{Timestamp: now.Add(-4 * time.Minute)}), "older than the default delay")
+
+	metricSink.SetMaxDelay(time.Minute)
+	assert.True(t, IsStale(metricSink, &core.DataBatch{Timestamp: now.Add(-2 * time.Minute)}))
+	assert.False(t, IsStale(metricSink, &core.DataBatch{Timestamp: now.Add(-30 * time.Second)}))
+}
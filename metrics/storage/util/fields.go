@@ -0,0 +1,166 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Query parameter of Metrics API requests selecting the fields to serve, e.g.
+// fields=containers[*].usage.cpu.
+const FieldsParam = "fields"
+
+// Prefix of the PodMetrics fields of the containers.
+const containersPrefix = "containers[*]."
+
+// Fields always served, as the API types or the API server require them.
+var alwaysSelectedFields = map[string]bool{
+	"metadata.name":      true,
+	"metadata.namespace": true,
+	"timestamp":          true,
+	"window":             true,
+}
+
+// FieldSelection lists the fields of NodeMetrics or PodMetrics to serve. Other fields
+// are cleared, except for names, timestamp and window, which are always served.
+type FieldSelection struct {
+	// Metadata fields to serve besides name and namespace, all if allMetadata is set.
+	metadata    map[string]bool
+	allMetadata bool
+	// Resources to serve the usage of, all if allUsage is set.
+	usage    map[string]bool
+	allUsage bool
+}
+
+// ParseFieldSelection parses the comma separated fields of NodeMetrics or, if pods is
+// set, PodMetrics. The usage fields of PodMetrics are prefixed with containers[*].
+func ParseFieldSelection(value string, pods bool) (*FieldSelection, error) {
+	selection := &FieldSelection{
+		metadata: map[string]bool{},
+		usage:    map[string]bool{},
+	}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if alwaysSelectedFields[field] || field == "" {
+			continue
+		}
+		if err := selection.add(field, pods); err != nil {
+			return nil, err
+		}
+	}
+	return selection, nil
+}
+
+func (this *FieldSelection) add(field string, pods bool) error {
+	switch field {
+	case "metadata":
+		this.allMetadata = true
+		return nil
+	case "metadata.creationTimestamp", "metadata.resourceVersion", "metadata.labels", "metadata.annotations":
+		this.metadata[strings.TrimPrefix(field, "metadata.")] = true
+		return nil
+	}
+
+	usage := field
+	if pods {
+		switch field {
+		case "containers":
+			this.allUsage = true
+			return nil
+		case "containers[*].name":
+			return nil
+		}
+		if !strings.HasPrefix(field, containersPrefix) {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		usage = strings.TrimPrefix(field, containersPrefix)
+	}
+	if usage == "usage" {
+		this.allUsage = true
+		return nil
+	}
+	if resource := strings.TrimPrefix(usage, "usage."); resource != usage && resource != "" {
+		this.usage[resource] = true
+		return nil
+	}
+	return fmt.Errorf("unknown field %q", field)
+}
+
+// FilterObjectMeta clears the metadata fields which are not selected.
+func (this *FieldSelection) FilterObjectMeta(meta *metav1.ObjectMeta) {
+	if this.allMetadata {
+		return
+	}
+	if !this.metadata["creationTimestamp"] {
+		meta.CreationTimestamp = metav1.Time{}
+	}
+	if !this.metadata["resourceVersion"] {
+		meta.ResourceVersion = ""
+	}
+	if !this.metadata["labels"] {
+		meta.Labels = nil
+	}
+	if !this.metadata["annotations"] {
+		meta.Annotations = nil
+	}
+}
+
+// FilterResourceList returns the usage of the selected resources only.
+func (this *FieldSelection) FilterResourceList(usage metrics.ResourceList) metrics.ResourceList {
+	if this.allUsage {
+		return usage
+	}
+	result := metrics.ResourceList{}
+	for name, quantity := range usage {
+		if this.usage[string(name)] {
+			result[name] = quantity
+		}
+	}
+	return result
+}
+
+// FilterNodeMetrics clears the fields of the NodeMetrics which are not selected.
+func (this *FieldSelection) FilterNodeMetrics(nodeMetrics *metrics.NodeMetrics) {
+	this.FilterObjectMeta(&nodeMetrics.ObjectMeta)
+	nodeMetrics.Usage = this.FilterResourceList(nodeMetrics.Usage)
+}
+
+// FilterPodMetrics clears the fields of the PodMetrics which are not selected.
+func (this *FieldSelection) FilterPodMetrics(podMetrics *metrics.PodMetrics) {
+	this.FilterObjectMeta(&podMetrics.ObjectMeta)
+	for i := range podMetrics.Containers {
+		podMetrics.Containers[i].Usage = this.FilterResourceList(podMetrics.Containers[i].Usage)
+	}
+}
+
+type fieldsKeyType int
+
+const fieldsKey fieldsKeyType = iota
+
+// WithFieldSelection returns a copy of the context which restricts the served fields.
+func WithFieldSelection(ctx genericapirequest.Context, selection *FieldSelection) genericapirequest.Context {
+	return genericapirequest.WithValue(ctx, fieldsKey, selection)
+}
+
+// FieldSelectionFrom returns the field selection of the context, nil if all fields are served.
+func FieldSelectionFrom(ctx genericapirequest.Context) *FieldSelection {
+	selection, _ := ctx.Value(fieldsKey).(*FieldSelection)
+	return selection
+}
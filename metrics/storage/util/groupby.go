@@ -0,0 +1,42 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+const (
+	// Query parameter of NodeMetrics LIST requests selecting the node label to aggregate by.
+	GroupByParam = "groupBy"
+
+	// Annotation with the number of nodes aggregated into a NodeMetrics group.
+	AggregatedNodesAnnotation = "metrics.k8s.io/aggregated-nodes"
+)
+
+type groupByKeyType int
+
+const groupByKey groupByKeyType = iota
+
+// WithGroupBy returns a copy of the context which requests aggregation by the label.
+func WithGroupBy(ctx genericapirequest.Context, label string) genericapirequest.Context {
+	return genericapirequest.WithValue(ctx, groupByKey, label)
+}
+
+// GroupByFrom returns the label requested for aggregation in the context, empty if none.
+func GroupByFrom(ctx genericapirequest.Context) string {
+	label, _ := ctx.Value(groupByKey).(string)
+	return label
+}
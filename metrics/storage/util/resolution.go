@@ -0,0 +1,66 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"math"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Annotation with the interval the CPU usage was measured over, set when it deviates
+	// from the metric resolution, e.g. because scrapes were missed.
+	EffectiveWindowAnnotation = "metrics.k8s.io/effective-window"
+
+	// Relative difference to the metric resolution above which a window deviates.
+	MaxWindowDeviation = 0.5
+)
+
+// GetDeviatingWindow returns the interval the CPU usage of the metric sets with the given
+// keys was measured over which deviates the most from the resolution. Returns false if
+// none of them deviates by more than MaxWindowDeviation.
+func GetDeviatingWindow(batch *core.DataBatch, keys []string, resolution time.Duration) (time.Duration, bool) {
+	if batch == nil || resolution <= 0 {
+		return 0, false
+	}
+	var result time.Duration
+	maxDeviation := MaxWindowDeviation
+	for _, key := range keys {
+		ms, found := batch.MetricSets[key]
+		if !found {
+			continue
+		}
+		value, found := ms.MetricValues[core.MetricCpuUsageRateWindow.Name]
+		if !found {
+			continue
+		}
+		window := time.Duration(value.IntValue)
+		if deviation := math.Abs(float64(window-resolution)) / float64(resolution); deviation > maxDeviation {
+			result, maxDeviation = window, deviation
+		}
+	}
+	return result, result > 0
+}
+
+// AddEffectiveWindowAnnotation annotates the object with the window, to the millisecond.
+func AddEffectiveWindowAnnotation(meta *metav1.ObjectMeta, window time.Duration) {
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[EffectiveWindowAnnotation] = (window / time.Millisecond * time.Millisecond).String()
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Annotation with the change of usage per minute over the retained batches, as a JSON
+// resource list, e.g. {"cpu":"-15m","memory":"2Mi"}.
+const UsageTrendAnnotation = "metrics.k8s.io/usage-trend"
+
+// GetUsageTrends returns the change of cpu and memory usage per minute of the metric sets
+// with the given keys, fitted by least squares over the batches kept by the sink up to the
+// given one. Keys with less than two samples are left out.
+func GetUsageTrends(metricSink *metricsink.MetricSink, batch *core.DataBatch, keys []string) map[string]metrics.ResourceList {
+	cpu := metricSink.GetMetric(core.MetricCpuUsageRate.Name, keys, time.Time{}, batch.Timestamp)
+	memory := metricSink.GetMetric(core.MetricMemoryWorkingSet.Name, keys, time.Time{}, batch.Timestamp)
+
+	result := make(map[string]metrics.ResourceList, len(keys))
+	for _, key := range keys {
+		cpuSlope, cpuFound := slopePerMinute(cpu[key])
+		memorySlope, memoryFound := slopePerMinute(memory[key])
+		if !cpuFound || !memoryFound {
+			continue
+		}
+		result[key] = metrics.ResourceList{
+			metrics.ResourceName(v1.ResourceCPU.String()): *resource.NewMilliQuantity(
+				int64(math.Floor(cpuSlope+0.5)),
+				resource.DecimalSI),
+			metrics.ResourceName(v1.ResourceMemory.String()): *resource.NewQuantity(
+				int64(math.Floor(memorySlope+0.5)),
+				resource.BinarySI),
+		}
+	}
+	return result
+}
+
+// AddUsageTrendAnnotation adds the usage trend to the annotations of the object.
+func AddUsageTrendAnnotation(meta *metav1.ObjectMeta, trend metrics.ResourceList) {
+	encoded, err := json.Marshal(trend)
+	if err != nil {
+		return
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[UsageTrendAnnotation] = string(encoded)
+}
+
+// slopePerMinute fits a line through the values by least squares and returns its slope.
+func slopePerMinute(values []core.TimestampedMetricValue) (float64, bool) {
+	if len(values) < 2 {
+		return 0, false
+	}
+	start := values[0].Timestamp
+	var sumX, sumY float64
+	for _, value := range values {
+		sumX += value.Timestamp.Sub(start).Minutes()
+		sumY += float64(value.IntValue)
+	}
+	n := float64(len(values))
+	meanX, meanY := sumX/n, sumY/n
+	var covariance, variance float64
+	for _, value := range values {
+		dx := value.Timestamp.Sub(start).Minutes() - meanX
+		covariance += dx * (float64(value.IntValue) - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return 0, false
+	}
+	return covariance / variance, true
+}
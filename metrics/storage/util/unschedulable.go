@@ -0,0 +1,40 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// Query parameter of NodeMetrics LIST requests selecting whether unschedulable (cordoned)
+// nodes are served, overriding the --list_unschedulable_nodes flag.
+const IncludeUnschedulableParam = "includeUnschedulable"
+
+type includeUnschedulableKeyType int
+
+const includeUnschedulableKey includeUnschedulableKeyType = iota
+
+// WithIncludeUnschedulable returns a copy of the context which requests unschedulable
+// nodes to be included in or left out of LIST responses.
+func WithIncludeUnschedulable(ctx genericapirequest.Context, include bool) genericapirequest.Context {
+	return genericapirequest.WithValue(ctx, includeUnschedulableKey, include)
+}
+
+// IncludeUnschedulableFrom returns whether the context requests unschedulable nodes
+// to be included, and whether it requests anything at all.
+func IncludeUnschedulableFrom(ctx genericapirequest.Context) (bool, bool) {
+	include, found := ctx.Value(includeUnschedulableKey).(bool)
+	return include, found
+}
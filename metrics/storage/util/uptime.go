@@ -0,0 +1,36 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+)
+
+// GetNodeUptime returns the time the node of the metric set with the key booted as
+// reported by its kubelet, and how long it had been up at the time of the batch. Usage of
+// nodes which just rebooted is measured over counters which are still warming up. Nothing
+// is found if the start time is unknown.
+func GetNodeUptime(batch *core.DataBatch, key string) (startTime time.Time, uptime time.Duration, found bool) {
+	ms, found := batch.MetricSets[key]
+	if !found || ms.CreateTime.IsZero() {
+		return time.Time{}, 0, false
+	}
+	if uptime = batch.Timestamp.Sub(ms.CreateTime); uptime < 0 {
+		uptime = 0
+	}
+	return ms.CreateTime, uptime / time.Second * time.Second, true
+}
@@ -16,13 +16,33 @@ package util
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/kubernetes-incubator/metrics-server/metrics/core"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	"k8s.io/apimachinery/pkg/fields"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	"k8s.io/metrics/pkg/apis/metrics"
 )
 
+// SwapMetrics adds the swap usage to the usage of nodes and containers whose kubelets report
+// it. It's off by default, for clients which expect only cpu and memory.
+const SwapMetrics utilfeature.Feature = "SwapMetrics"
+
+// Name of the swap usage in the served resource lists.
+const ResourceSwap = "swap"
+
+func init() {
+	err := utilfeature.DefaultFeatureGate.Add(map[utilfeature.Feature]utilfeature.FeatureSpec{
+		SwapMetrics: {Default: false, PreRelease: utilfeature.Alpha},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
 func ParseResourceList(ms *core.MetricSet) (metrics.ResourceList, error) {
 	cpu, found := ms.MetricValues[core.MetricCpuUsageRate.MetricDescriptor.Name]
 	if !found {
@@ -33,12 +53,45 @@ func ParseResourceList(ms *core.MetricSet) (metrics.ResourceList, error) {
 		return metrics.ResourceList{}, fmt.Errorf("memory not found")
 	}
 
-	return metrics.ResourceList{
+	usage := metrics.ResourceList{
 		metrics.ResourceName(v1.ResourceCPU.String()): *resource.NewMilliQuantity(
 			cpu.IntValue,
 			resource.DecimalSI),
 		metrics.ResourceName(v1.ResourceMemory.String()): *resource.NewQuantity(
 			mem.IntValue,
 			resource.BinarySI),
-	}, nil
+	}
+	if swap, found := ms.MetricValues[core.MetricMemorySwapUsage.Name]; found && utilfeature.DefaultFeatureGate.Enabled(SwapMetrics) {
+		usage[ResourceSwap] = *resource.NewQuantity(swap.IntValue, resource.BinarySI)
+	}
+	return usage, nil
+}
+
+// AddResourceList adds the usage to the total.
+func AddResourceList(total, usage metrics.ResourceList) {
+	for name, quantity := range usage {
+		sum, found := total[name]
+		if !found {
+			total[name] = quantity.DeepCopy()
+			continue
+		}
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
+
+// ResourceVersion identifies the batch the metrics were served from. It is the same for
+// all objects and lists served from one batch and increases with every new batch.
+func ResourceVersion(batch *core.DataBatch) string {
+	return strconv.FormatInt(batch.Timestamp.UnixNano(), 10)
+}
+
+// MatchesFieldSelector returns whether the object of the namespace, empty for nodes, and
+// name matches the field selector of the list options. Objects are selected by their
+// metadata.name and metadata.namespace fields.
+func MatchesFieldSelector(options *metainternalversion.ListOptions, namespace, name string) bool {
+	if options == nil || options.FieldSelector == nil || options.FieldSelector.Empty() {
+		return true
+	}
+	return options.FieldSelector.Matches(fields.Set{"metadata.name": name, "metadata.namespace": namespace})
 }
@@ -0,0 +1,55 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	"k8s.io/api/core/v1"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+func TestParseResourceListSwap(t *testing.T) {
+	ms := &core.MetricSet{MetricValues: map[string]core.MetricValue{
+		core.MetricCpuUsageRate.Name:     {IntValue: 100},
+		core.MetricMemoryWorkingSet.Name: {IntValue: 2048},
+		core.MetricMemorySwapUsage.Name:  {IntValue: 1024},
+	}}
+
+	usage, err := ParseResourceList(ms)
+	require.NoError(t, err)
+	_, found := usage[ResourceSwap]
+	assert.False(t, found, "swap is left out with the gate off")
+
+	require.NoError(t, utilfeature.DefaultFeatureGate.Set(string(SwapMetrics)+"=true"))
+	defer utilfeature.DefaultFeatureGate.Set(string(SwapMetrics) + "=false")
+	usage, err = ParseResourceList(ms)
+	require.NoError(t, err)
+	swap := usage[ResourceSwap]
+	assert.Equal(t, int64(1024), swap.Value())
+	memory := usage[metrics.ResourceName(v1.ResourceMemory)]
+	assert.Equal(t, int64(2048), memory.Value())
+
+	delete(ms.MetricValues, core.MetricMemorySwapUsage.Name)
+	usage, err = ParseResourceList(ms)
+	require.NoError(t, err)
+	_, found = usage[ResourceSwap]
+	assert.False(t, found, "swap is left out for objects not reporting it")
+}
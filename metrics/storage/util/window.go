@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"time"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+const (
+	// Query parameter selecting the window the metrics are served for.
+	WindowParam = "window"
+	// Latest samples, for responsive consumers like the HPA. The default.
+	WindowFast = "fast"
+	// Samples averaged over a longer window, for reporting.
+	WindowSlow = "slow"
+
+	// Window covered by the latest samples.
+	FastWindowDuration = time.Minute
+
+	// Query parameter selecting the time, in RFC3339, of the retained batch served by GET.
+	TimeParam = "time"
+)
+
+type windowKeyType int
+
+const (
+	windowKey windowKeyType = iota
+	timeKey
+)
+
+// WithWindow returns a copy of the context which requests metrics averaged over the window.
+func WithWindow(ctx genericapirequest.Context, window time.Duration) genericapirequest.Context {
+	return genericapirequest.WithValue(ctx, windowKey, window)
+}
+
+// WindowFrom returns the window requested in the context, or zero for the latest samples.
+func WindowFrom(ctx genericapirequest.Context) time.Duration {
+	window, _ := ctx.Value(windowKey).(time.Duration)
+	return window
+}
+
+// WithTime returns a copy of the context which requests the retained batch closest to the time.
+func WithTime(ctx genericapirequest.Context, timestamp time.Time) genericapirequest.Context {
+	return genericapirequest.WithValue(ctx, timeKey, timestamp)
+}
+
+// TimeFrom returns the time requested in the context, or the zero time for the latest samples.
+func TimeFrom(ctx genericapirequest.Context) time.Time {
+	timestamp, _ := ctx.Value(timeKey).(time.Time)
+	return timestamp
+}
+
+// IsStaleFor returns true if the batch selected for the request should not be used to serve
+// metrics. Batches selected by time are served regardless of their age.
+func IsStaleFor(ctx genericapirequest.Context, metricSink *metricsink.MetricSink, batch *core.DataBatch) bool {
+	if !TimeFrom(ctx).IsZero() {
+		return batch == nil
+	}
+	return IsStale(metricSink, batch)
+}
+
+// GetDataBatch returns the batch to serve for the request and the window it covers.
+func GetDataBatch(ctx genericapirequest.Context, metricSink *metricsink.MetricSink) (*core.DataBatch, time.Duration) {
+	if timestamp := TimeFrom(ctx); !timestamp.IsZero() {
+		return metricSink.GetDataBatchAt(timestamp), FastWindowDuration
+	}
+	if window := WindowFrom(ctx); window > 0 {
+		return metricSink.GetAveragedDataBatch(window), window
+	}
+	return metricSink.GetLatestDataBatch(), FastWindowDuration
+}
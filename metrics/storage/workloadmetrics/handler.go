@@ -0,0 +1,208 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workloadmetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	"github.com/kubernetes-incubator/metrics-server/metrics/storage/util"
+	metricsutil "github.com/kubernetes-incubator/metrics-server/metrics/util"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	appslisters "k8s.io/client-go/listers/apps/v1beta2"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Path under which the workload metrics are served.
+const Path = "/workloadmetrics"
+
+// Aggregated usage of all pods controlled by a single workload.
+type WorkloadMetrics struct {
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	Timestamp metav1.Time `json:"timestamp"`
+	// Number of pods for which metrics were available.
+	Pods    int                  `json:"pods"`
+	Total   metrics.ResourceList `json:"total"`
+	Average metrics.ResourceList `json:"average"`
+}
+
+type WorkloadMetricsList struct {
+	Items []WorkloadMetrics `json:"items"`
+}
+
+type workloadKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+type handler struct {
+	metricSink       *metricsink.MetricSink
+	podLister        v1listers.PodLister
+	replicaSetLister appslisters.ReplicaSetLister
+}
+
+// NewHandler returns a handler serving PodMetrics aggregated by the owning
+// workload. Pods owned by a ReplicaSet are attributed to its Deployment.
+// The namespace can be restricted with the namespace query parameter.
+func NewHandler(metricSink *metricsink.MetricSink, podLister v1listers.PodLister,
+	replicaSetLister appslisters.ReplicaSetLister) http.Handler {
+	return &handler{
+		metricSink:       metricSink,
+		podLister:        podLister,
+		replicaSetLister: replicaSetLister,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	namespace := req.URL.Query().Get("namespace")
+	list, err := h.getWorkloadMetrics(namespace)
+	if err != nil {
+		glog.Errorf("Error while aggregating workload metrics: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		glog.Errorf("Error while encoding workload metrics: %v", err)
+	}
+}
+
+func (h *handler) getWorkloadMetrics(namespace string) (*WorkloadMetricsList, error) {
+	batch := h.metricSink.GetLatestDataBatch()
+	if batch == nil {
+		return &WorkloadMetricsList{Items: []WorkloadMetrics{}}, nil
+	}
+
+	pods, err := h.podLister.Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("Error while listing pods: %v", err)
+	}
+
+	workloads := make(map[workloadKey]*WorkloadMetrics)
+	for _, pod := range pods {
+		key, found := h.getWorkload(pod)
+		if !found {
+			continue
+		}
+		usage, found := getPodUsage(batch, pod)
+		if !found {
+			continue
+		}
+		workload, found := workloads[key]
+		if !found {
+			workload = &WorkloadMetrics{
+				Kind:      key.kind,
+				Namespace: key.namespace,
+				Name:      key.name,
+				Timestamp: metav1.NewTime(batch.Timestamp),
+				Total:     metrics.ResourceList{},
+			}
+			workloads[key] = workload
+		}
+		workload.Pods++
+		util.AddResourceList(workload.Total, usage)
+	}
+
+	res := &WorkloadMetricsList{Items: make([]WorkloadMetrics, 0, len(workloads))}
+	for _, workload := range workloads {
+		workload.Average = averageResourceList(workload.Total, workload.Pods)
+		res.Items = append(res.Items, *workload)
+	}
+	sort.Slice(res.Items, func(i, j int) bool {
+		a, b := res.Items[i], res.Items[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return res, nil
+}
+
+// getWorkload resolves the top-level controller of the pod.
+func (h *handler) getWorkload(pod *v1.Pod) (workloadKey, bool) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return workloadKey{}, false
+	}
+	key := workloadKey{kind: ref.Kind, namespace: pod.Namespace, name: ref.Name}
+	if ref.Kind != "ReplicaSet" || h.replicaSetLister == nil {
+		return key, true
+	}
+
+	rs, err := h.replicaSetLister.ReplicaSets(pod.Namespace).Get(ref.Name)
+	if err != nil {
+		glog.V(2).Infof("Unable to get ReplicaSet %s/%s owning pod %s: %v", pod.Namespace, ref.Name, pod.Name, err)
+		return key, true
+	}
+	if rsRef := metav1.GetControllerOf(rs); rsRef != nil {
+		key.kind, key.name = rsRef.Kind, rsRef.Name
+	}
+	return key, true
+}
+
+// getPodUsage returns the total usage of the containers and scraped sidecars of the pod.
+func getPodUsage(batch *core.DataBatch, pod *v1.Pod) (metrics.ResourceList, bool) {
+	total := metrics.ResourceList{}
+	for _, c := range pod.Spec.Containers {
+		ms, found := batch.MetricSets[core.PodContainerKey(pod.Namespace, pod.Name, c.Name)]
+		if !found {
+			return nil, false
+		}
+		usage, err := util.ParseResourceList(ms)
+		if err != nil {
+			return nil, false
+		}
+		util.AddResourceList(total, usage)
+	}
+	for _, c := range metricsutil.SidecarContainers(pod) {
+		if ms, found := batch.MetricSets[core.PodContainerKey(pod.Namespace, pod.Name, c.Name)]; found {
+			if usage, err := util.ParseResourceList(ms); err == nil {
+				util.AddResourceList(total, usage)
+			}
+		}
+	}
+	return total, true
+}
+
+func averageResourceList(total metrics.ResourceList, count int) metrics.ResourceList {
+	average := metrics.ResourceList{}
+	if count == 0 {
+		return average
+	}
+	for name, quantity := range total {
+		if name == metrics.ResourceName(v1.ResourceCPU.String()) {
+			average[name] = *resource.NewMilliQuantity(quantity.MilliValue()/int64(count), quantity.Format)
+		} else {
+			average[name] = *resource.NewQuantity(quantity.Value()/int64(count), quantity.Format)
+		}
+	}
+	return average
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workloadmetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/metrics-server/metrics/core"
+	metricsink "github.com/kubernetes-incubator/metrics-server/metrics/sinks/metric"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1beta2"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func controllerRef(kind, name string) []metav1.OwnerReference {
+	isController := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &isController}}
+}
+
+func testPod(name string, owners []metav1.OwnerReference) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, OwnerReferences: owners},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "c"}}},
+	}
+}
+
+func containerMetrics(cpu, mem int64) *core.MetricSet {
+	return &core.MetricSet{
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name:     {IntValue: cpu},
+			core.MetricMemoryWorkingSet.Name: {IntValue: mem},
+		},
+	}
+}
+
+func TestGetWorkloadMetrics(t *testing.T) {
+	podStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	rsStore := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+
+	require.NoError(t, rsStore.Add(&appsv1beta2.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "web-123", OwnerReferences: controllerRef("Deployment", "web")},
+	}))
+	require.NoError(t, podStore.Add(testPod("web-123-a", controllerRef("ReplicaSet", "web-123"))))
+	require.NoError(t, podStore.Add(testPod("web-123-b", controllerRef("ReplicaSet", "web-123"))))
+	db0 := testPod("db-0", controllerRef("StatefulSet", "db"))
+	db0.Spec.InitContainers = []v1.Container{{Name: "proxy"}}
+	db0.Status = v1.PodStatus{
+		Phase:                 v1.PodRunning,
+		InitContainerStatuses: []v1.ContainerStatus{{Name: "proxy", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}}},
+	}
+	require.NoError(t, podStore.Add(db0))
+	require.NoError(t, podStore.Add(testPod("standalone", nil)))
+
+	metricSink := metricsink.NewMetricSink(time.Minute, time.Minute, []string{})
+	metricSink.ExportData(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns", "web-123-a", "c"):  containerMetrics(100, 1000),
+			core.PodContainerKey("ns", "web-123-b", "c"):  containerMetrics(300, 3000),
+			core.PodContainerKey("ns", "db-0", "c"):       containerMetrics(50, 500),
+			core.PodContainerKey("ns", "db-0", "proxy"):   containerMetrics(10, 100),
+			core.PodContainerKey("ns", "standalone", "c"): containerMetrics(1, 1),
+		},
+	})
+
+	h := &handler{
+		metricSink:       metricSink,
+		podLister:        v1listers.NewPodLister(podStore),
+		replicaSetLister: appslisters.NewReplicaSetLister(rsStore),
+	}
+	list, err := h.getWorkloadMetrics("ns")
+	require.NoError(t, err)
+	require.Len(t, list.Items, 2)
+
+	web := list.Items[0]
+	assert.Equal(t, "Deployment", web.Kind)
+	assert.Equal(t, "web", web.Name)
+	assert.Equal(t, 2, web.Pods)
+	cpu, mem := web.Total["cpu"], web.Total["memory"]
+	assert.Equal(t, int64(400), cpu.MilliValue())
+	assert.Equal(t, int64(4000), mem.Value())
+	cpu, mem = web.Average["cpu"], web.Average["memory"]
+	assert.Equal(t, int64(200), cpu.MilliValue())
+	assert.Equal(t, int64(2000), mem.Value())
+
+	db := list.Items[1]
+	assert.Equal(t, "StatefulSet", db.Kind)
+	assert.Equal(t, "db", db.Name)
+	assert.Equal(t, 1, db.Pods)
+	cpu, mem = db.Total["cpu"], db.Total["memory"]
+	assert.Equal(t, int64(60), cpu.MilliValue(), "sidecar usage included")
+	assert.Equal(t, int64(600), mem.Value())
+}
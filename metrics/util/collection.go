@@ -0,0 +1,51 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "fmt"
+
+// Modes of collecting the metrics of the kubelet summaries.
+const (
+	// All metrics of the kubelet summaries are collected. The default.
+	CollectionFull = "full"
+	// Only the cpu and memory metrics served by the Metrics API are collected, which is
+	// cheaper to process and store.
+	CollectionResources = "resources"
+)
+
+// Endpoints of the kubelets the metrics are collected from.
+const (
+	// The kubelets are scraped on their Summary API. The default.
+	KubeletEndpointSummary = "summary"
+	// The kubelets are scraped on their Prometheus /metrics/resource endpoint, which only
+	// has the cpu and memory usage of the nodes and containers.
+	KubeletEndpointResource = "resource"
+)
+
+// ValidateCollectionMode checks that the mode is one of the known collection modes.
+func ValidateCollectionMode(mode string) error {
+	if mode != CollectionFull && mode != CollectionResources {
+		return fmt.Errorf("invalid collection mode %q, expected %s or %s", mode, CollectionFull, CollectionResources)
+	}
+	return nil
+}
+
+// ValidateKubeletMetricsEndpoint checks that the endpoint is one of the known kubelet endpoints.
+func ValidateKubeletMetricsEndpoint(endpoint string) error {
+	if endpoint != KubeletEndpointSummary && endpoint != KubeletEndpointResource {
+		return fmt.Errorf("invalid kubelet metrics endpoint %q, expected %s or %s", endpoint, KubeletEndpointSummary, KubeletEndpointResource)
+	}
+	return nil
+}
@@ -69,6 +69,49 @@ func NewDummySink(name string, latency time.Duration) *DummySink {
 	}
 }
 
+// DummyContainer is a container of a batch created by NewDummyBatch, with its cumulative
+// CPU usage.
+type DummyContainer struct {
+	Namespace string
+	Pod       string
+	// Not labelled if empty.
+	PodUID     string
+	Name       string
+	CreateTime time.Time
+	ScrapeTime time.Time
+	CpuUsage   int64
+}
+
+// NewDummyBatch returns a batch of the timestamp with the containers, for tests of the
+// processing of successive batches.
+func NewDummyBatch(timestamp time.Time, containers ...DummyContainer) *core.DataBatch {
+	batch := &core.DataBatch{Timestamp: timestamp, MetricSets: map[string]*core.MetricSet{}}
+	for _, container := range containers {
+		labels := map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+			core.LabelNamespaceName.Key: container.Namespace,
+			core.LabelPodName.Key:       container.Pod,
+			core.LabelContainerName.Key: container.Name,
+		}
+		if container.PodUID != "" {
+			labels[core.LabelPodId.Key] = container.PodUID
+		}
+		batch.MetricSets[core.PodContainerKey(container.Namespace, container.Pod, container.Name)] = &core.MetricSet{
+			CreateTime: container.CreateTime,
+			ScrapeTime: container.ScrapeTime,
+			Labels:     labels,
+			MetricValues: map[string]core.MetricValue{
+				core.MetricCpuUsage.MetricDescriptor.Name: {
+					ValueType:  core.ValueInt64,
+					MetricType: core.MetricCumulative,
+					IntValue:   container.CpuUsage,
+				},
+			},
+		}
+	}
+	return batch
+}
+
 type DummyMetricsSource struct {
 	latency   time.Duration
 	metricSet core.MetricSet
@@ -0,0 +1,58 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Files the memory limit of the container is read from, for cgroup v2 and v1.
+var (
+	cgroupV2MemoryLimitFile = "/sys/fs/cgroup/memory.max"
+	cgroupV1MemoryLimitFile = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
+)
+
+// cgroup v1 reports limits at or above this value when the memory is unlimited.
+const unlimitedCgroupV1Memory = 1 << 62
+
+// GetMemoryLimit returns the memory limit of the container in bytes, or zero if it is
+// unlimited or runs outside of a memory cgroup.
+func GetMemoryLimit() (int64, error) {
+	for _, file := range []string{cgroupV2MemoryLimitFile, cgroupV1MemoryLimitFile} {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		value := strings.TrimSpace(string(data))
+		if value == "max" {
+			return 0, nil
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid memory limit %q in %s: %v", value, file, err)
+		}
+		if limit >= unlimitedCgroupV1Memory {
+			return 0, nil
+		}
+		return limit, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMemoryLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memlimit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(v2, v1 string) {
+		cgroupV2MemoryLimitFile, cgroupV1MemoryLimitFile = v2, v1
+	}(cgroupV2MemoryLimitFile, cgroupV1MemoryLimitFile)
+	cgroupV2MemoryLimitFile = filepath.Join(dir, "memory.max")
+	cgroupV1MemoryLimitFile = filepath.Join(dir, "memory.limit_in_bytes")
+
+	tests := []struct {
+		name     string
+		v2, v1   string
+		expected int64
+	}{
+		{name: "no cgroup", expected: 0},
+		{name: "v2 limit", v2: "268435456\n", expected: 268435456},
+		{name: "v2 unlimited", v2: "max\n", v1: "1048576", expected: 0},
+		{name: "v1 limit", v1: "1048576\n", expected: 1048576},
+		{name: "v1 unlimited", v1: "9223372036854771712\n", expected: 0},
+	}
+	for _, test := range tests {
+		os.Remove(cgroupV2MemoryLimitFile)
+		os.Remove(cgroupV1MemoryLimitFile)
+		if test.v2 != "" {
+			require.NoError(t, ioutil.WriteFile(cgroupV2MemoryLimitFile, []byte(test.v2), 0644))
+		}
+		if test.v1 != "" {
+			require.NoError(t, ioutil.WriteFile(cgroupV1MemoryLimitFile, []byte(test.v1), 0644))
+		}
+		limit, err := GetMemoryLimit()
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, limit, test.name)
+	}
+
+	require.NoError(t, ioutil.WriteFile(cgroupV2MemoryLimitFile, []byte("lots"), 0644))
+	_, err = GetMemoryLimit()
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IP families which can be selected for serving and advertising the API.
+const (
+	AddressFamilyIPv4 = "ipv4"
+	AddressFamilyIPv6 = "ipv6"
+)
+
+// ValidateAddressFamily checks that the family is empty (any family) or one of the known families.
+func ValidateAddressFamily(family string) error {
+	switch strings.ToLower(family) {
+	case "", AddressFamilyIPv4, AddressFamilyIPv6:
+		return nil
+	}
+	return fmt.Errorf("unknown address family %q, expected %q or %q", family, AddressFamilyIPv4, AddressFamilyIPv6)
+}
+
+// IsAddressFamily returns true if the IP belongs to the family. Any IP belongs to the empty family.
+func IsAddressFamily(ip net.IP, family string) bool {
+	switch strings.ToLower(family) {
+	case AddressFamilyIPv4:
+		return ip.To4() != nil
+	case AddressFamilyIPv6:
+		return ip.To4() == nil && ip.To16() != nil
+	}
+	return ip != nil
+}
+
+// AddressFamilyOf returns the IP family of the address, or empty if it isn't an IP.
+func AddressFamilyOf(address string) string {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return AddressFamilyIPv4
+	default:
+		return AddressFamilyIPv6
+	}
+}
+
+// UnspecifiedAddress returns the address listening on all interfaces of the family.
+func UnspecifiedAddress(family string) net.IP {
+	if strings.ToLower(family) == AddressFamilyIPv6 {
+		return net.IPv6unspecified
+	}
+	return net.IPv4zero
+}
+
+// ChooseHostAddress picks the first global unicast address of the family from the addresses
+// of the host interfaces.
+func ChooseHostAddress(addrs []net.Addr, family string) (net.IP, error) {
+	for _, addr := range addrs {
+		var ip net.IP
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		}
+		if ip == nil || !ip.IsGlobalUnicast() || !IsAddressFamily(ip, family) {
+			continue
+		}
+		return ip, nil
+	}
+	if family == "" {
+		return nil, fmt.Errorf("no global unicast address found")
+	}
+	return nil, fmt.Errorf("no global unicast %s address found", family)
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAddressFamily(t *testing.T) {
+	assert.NoError(t, ValidateAddressFamily(""))
+	assert.NoError(t, ValidateAddressFamily("ipv4"))
+	assert.NoError(t, ValidateAddressFamily("IPv6"))
+	assert.Error(t, ValidateAddressFamily("ipv5"))
+}
+
+func TestAddressFamilyOf(t *testing.T) {
+	assert.Equal(t, AddressFamilyIPv4, AddressFamilyOf("10.0.0.5"))
+	assert.Equal(t, AddressFamilyIPv6, AddressFamilyOf("fd00::5"))
+	assert.Equal(t, "", AddressFamilyOf("node1.example.com"))
+	assert.Equal(t, "", AddressFamilyOf(""))
+}
+
+func TestChooseHostAddress(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("127.0.0.1")},
+		&net.IPNet{IP: net.IPv6loopback},
+		&net.IPNet{IP: net.ParseIP("fe80::1")},
+		&net.IPNet{IP: net.ParseIP("10.0.0.5")},
+		&net.IPNet{IP: net.ParseIP("fd00::5")},
+	}
+
+	ip, err := ChooseHostAddress(addrs, AddressFamilyIPv4)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", ip.String())
+
+	ip, err = ChooseHostAddress(addrs, AddressFamilyIPv6)
+	require.NoError(t, err)
+	assert.Equal(t, "fd00::5", ip.String())
+
+	ip, err = ChooseHostAddress(addrs, "")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", ip.String())
+
+	_, err = ChooseHostAddress(addrs[:4], AddressFamilyIPv6)
+	assert.Error(t, err)
+}
+
+func TestUnspecifiedAddress(t *testing.T) {
+	assert.Equal(t, "::", UnspecifiedAddress(AddressFamilyIPv6).String())
+	assert.Equal(t, "0.0.0.0", UnspecifiedAddress(AddressFamilyIPv4).String())
+	assert.Equal(t, "0.0.0.0", UnspecifiedAddress("").String())
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"k8s.io/client-go/tools/cache"
+)
+
+// notifyingStore calls the handlers with every change made to the store by the reflector,
+// so that they learn about added, updated and deleted nodes without an informer of their own.
+// The handlers are called synchronously, after the store has been changed.
+type notifyingStore struct {
+	cache.Indexer
+	handlers []cache.ResourceEventHandler
+}
+
+func (this *notifyingStore) Add(obj interface{}) error {
+	return this.Update(obj)
+}
+
+func (this *notifyingStore) Update(obj interface{}) error {
+	old, found, _ := this.Indexer.Get(obj)
+	if err := this.Indexer.Update(obj); err != nil {
+		return err
+	}
+	this.notify(old, found, obj)
+	return nil
+}
+
+func (this *notifyingStore) Delete(obj interface{}) error {
+	if err := this.Indexer.Delete(obj); err != nil {
+		return err
+	}
+	for _, handler := range this.handlers {
+		handler.OnDelete(obj)
+	}
+	return nil
+}
+
+func (this *notifyingStore) Replace(list []interface{}, resourceVersion string) error {
+	olds := map[string]interface{}{}
+	for _, old := range this.Indexer.List() {
+		if key, err := cache.MetaNamespaceKeyFunc(old); err == nil {
+			olds[key] = old
+		}
+	}
+	if err := this.Indexer.Replace(list, resourceVersion); err != nil {
+		return err
+	}
+	for _, obj := range list {
+		key, err := cache.MetaNamespaceKeyFunc(obj)
+		if err != nil {
+			continue
+		}
+		old, found := olds[key]
+		delete(olds, key)
+		this.notify(old, found, obj)
+	}
+	for key, old := range olds {
+		for _, handler := range this.handlers {
+			handler.OnDelete(cache.DeletedFinalStateUnknown{Key: key, Obj: old})
+		}
+	}
+	return nil
+}
+
+func (this *notifyingStore) notify(old interface{}, found bool, obj interface{}) {
+	for _, handler := range this.handlers {
+		if found {
+			handler.OnUpdate(old, obj)
+		} else {
+			handler.OnAdd(obj)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNotifyingStore(t *testing.T) {
+	var events []string
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { events = append(events, "add "+nodeName(obj)) },
+		UpdateFunc: func(old, obj interface{}) { events = append(events, "update "+nodeName(old)+" "+nodeName(obj)) },
+		DeleteFunc: func(obj interface{}) {
+			_, unknown := obj.(cache.DeletedFinalStateUnknown)
+			events = append(events, "delete "+nodeName(obj)+map[bool]string{true: " unknown"}[unknown])
+		},
+	}
+	store := &notifyingStore{cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}), []cache.ResourceEventHandler{handler}}
+
+	require.NoError(t, store.Add(testNode("node1")))
+	require.NoError(t, store.Update(testNode("node1")))
+	require.NoError(t, store.Replace([]interface{}{testNode("node1"), testNode("node2")}, "1"))
+	require.NoError(t, store.Replace([]interface{}{testNode("node2")}, "2"))
+	require.NoError(t, store.Delete(testNode("node2")))
+	assert.Equal(t, []string{
+		"add node1",
+		"update node1 node1",
+		"update node1 node1",
+		"add node2",
+		"update node2 node2",
+		"delete node1 unknown",
+		"delete node2",
+	}, events)
+	assert.Empty(t, store.List())
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Shard is the shard of the nodes an instance scrapes and serves, out of Count instances
+// which each have their own. The zero value holds all nodes.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// NewShard returns the shard, or an error if the index isn't one of the count shards.
+func NewShard(index, count int) (Shard, error) {
+	if count < 1 || index < 0 || index >= count {
+		return Shard{}, fmt.Errorf("shard index needs to be at least 0 and less than the shard count %d - %d", count, index)
+	}
+	return Shard{Index: index, Count: count}, nil
+}
+
+// Owns returns true if the node belongs to the shard.
+func (this Shard) Owns(node string) bool {
+	return this.Count <= 1 || ShardOf(node, this.Count) == this.Index
+}
+
+// ShardOf returns the shard, between 0 and count-1, the node belongs to. Nodes are placed
+// by jump consistent hashing of their name, so all instances agree on the placement and
+// adding a shard only moves the nodes it takes over.
+func ShardOf(node string, count int) int {
+	h := fnv.New64a()
+	h.Write([]byte(node))
+	key := h.Sum64()
+	var bucket, next int64 = -1, 0
+	for next < int64(count) {
+		bucket = next
+		key = key*2862933555777941757 + 1
+		next = int64(float64(bucket+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(bucket)
+}
+
+// InShare returns true if the node is among the share, between 0 and 1, of the nodes. Nodes
+// are picked by ShardOf, so larger shares contain the smaller ones and all instances with
+// the same share pick the same nodes.
+func InShare(node string, share float64) bool {
+	if share >= 1 {
+		return true
+	}
+	return float64(ShardOf(node, shareBuckets)) < share*shareBuckets
+}
+
+// Number of buckets nodes are placed in for picking a share of them.
+const shareBuckets = 10000
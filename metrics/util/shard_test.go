@@ -0,0 +1,77 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardOf(t *testing.T) {
+	counts := make([]int, 4)
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		node := fmt.Sprintf("node-%d", i)
+		assert.Equal(t, 0, ShardOf(node, 1))
+		shard := ShardOf(node, 4)
+		assert.Equal(t, shard, ShardOf(node, 4), "deterministic")
+		counts[shard]++
+		if grown := ShardOf(node, 5); grown != shard {
+			assert.Equal(t, 4, grown, "nodes only move to the added shard")
+			moved++
+		}
+	}
+	for shard, count := range counts {
+		assert.InDelta(t, 250, count, 60, "shard %d", shard)
+	}
+	assert.InDelta(t, 200, moved, 60)
+}
+
+func TestShard(t *testing.T) {
+	_, err := NewShard(2, 2)
+	assert.Error(t, err)
+	_, err = NewShard(-1, 2)
+	assert.Error(t, err)
+	_, err = NewShard(0, 0)
+	assert.Error(t, err)
+	assert.True(t, Shard{}.Owns("node-1"), "the zero shard holds all nodes")
+
+	picked := 0
+	for index := 0; index < 3; index++ {
+		shard, err := NewShard(index, 3)
+		assert.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			if shard.Owns(fmt.Sprintf("node-%d", i)) {
+				picked++
+			}
+		}
+	}
+	assert.Equal(t, 100, picked, "every node is in exactly one shard")
+}
+
+func TestInShare(t *testing.T) {
+	picked := 0
+	for i := 0; i < 1000; i++ {
+		node := fmt.Sprintf("node-%d", i)
+		assert.True(t, InShare(node, 1))
+		if InShare(node, 0.1) {
+			picked++
+			assert.True(t, InShare(node, 0.2), "larger shares contain the smaller ones")
+		}
+	}
+	assert.InDelta(t, 100, picked, 40)
+}
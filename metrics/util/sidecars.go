@@ -0,0 +1,39 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SidecarContainers returns the sidecar containers of the pod, i.e. its restartable init
+// containers. They're told apart from regular init containers by still running after the
+// pod was initialized, which works regardless of the API version the pod was decoded with.
+func SidecarContainers(pod *corev1.Pod) []corev1.Container {
+	if pod.Status.Phase != corev1.PodRunning || len(pod.Spec.InitContainers) == 0 {
+		return nil
+	}
+	running := make(map[string]bool, len(pod.Status.InitContainerStatuses))
+	for _, status := range pod.Status.InitContainerStatuses {
+		running[status.Name] = status.State.Running != nil
+	}
+	var sidecars []corev1.Container
+	for _, c := range pod.Spec.InitContainers {
+		if running[c.Name] {
+			sidecars = append(sidecars, c)
+		}
+	}
+	return sidecars
+}
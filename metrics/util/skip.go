@@ -0,0 +1,160 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Annotation which, set to "true" on a node, stops it from being scraped and leaves it out
+// of the listed node metrics, e.g. to silence a kubelet known to be broken. The pods running
+// on the node are left out of the listed pod metrics too, and getting one returns NotFound
+// rather than an error about the node not being scraped, as a skipped node never has usage.
+const SkipNodeAnnotation = "metrics-server.kubernetes.io/skip"
+
+// Modes of handling the control plane nodes.
+const (
+	// Control plane nodes are scraped like the other nodes.
+	ControlPlaneInclude = "include"
+	// Control plane nodes are skipped like annotated ones, for clusters whose control plane
+	// is hosted or managed externally, e.g. with node objects of masters whose kubelets
+	// can't be reached, which would be listed without usage.
+	ControlPlaneExclude = "exclude"
+)
+
+// Labels marking the control plane nodes.
+var ControlPlaneLabels = []string{"node-role.kubernetes.io/control-plane", "node-role.kubernetes.io/master"}
+
+// Whether control plane nodes are skipped. It's set on startup, before the nodes are listed.
+var excludeControlPlane = false
+
+// ValidateControlPlaneMode checks that the mode is one of the control plane modes.
+func ValidateControlPlaneMode(mode string) error {
+	if mode != ControlPlaneInclude && mode != ControlPlaneExclude {
+		return fmt.Errorf("invalid control plane mode %q, expected %s or %s", mode, ControlPlaneInclude, ControlPlaneExclude)
+	}
+	return nil
+}
+
+// SetControlPlaneMode selects whether the control plane nodes are skipped.
+func SetControlPlaneMode(mode string) error {
+	if err := ValidateControlPlaneMode(mode); err != nil {
+		return err
+	}
+	excludeControlPlane = mode == ControlPlaneExclude
+	return nil
+}
+
+// IsControlPlaneNode returns whether the node has one of the control plane labels.
+func IsControlPlaneNode(node *corev1.Node) bool {
+	for _, label := range ControlPlaneLabels {
+		if _, found := node.Labels[label]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeSkipReason returns why the node is excluded, empty if it isn't.
+func NodeSkipReason(node *corev1.Node) string {
+	if node.Annotations[SkipNodeAnnotation] == "true" {
+		return "skipped with the " + SkipNodeAnnotation + " annotation"
+	}
+	if excludeControlPlane && IsControlPlaneNode(node) {
+		return "control plane node excluded with --control_plane_nodes=" + ControlPlaneExclude
+	}
+	return ""
+}
+
+// IsNodeSkipped returns whether the node is excluded with the skip annotation, or as a
+// control plane node.
+func IsNodeSkipped(node *corev1.Node) bool {
+	return NodeSkipReason(node) != ""
+}
+
+// Names of the skipped nodes in the stores of all node listers, for leaving out the pods
+// running on them.
+var skippedNodes = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+// IsNodeNameSkipped returns whether the listed node of that name is skipped, for telling
+// whether a pod runs on a skipped node.
+func IsNodeNameSkipped(name string) bool {
+	skippedNodes.Lock()
+	defer skippedNodes.Unlock()
+	return skippedNodes.names[name]
+}
+
+// skipTrackingStore records in skippedNodes which nodes of the store are skipped.
+type skipTrackingStore struct {
+	cache.Indexer
+}
+
+func (this *skipTrackingStore) Add(obj interface{}) error {
+	updateSkippedNode(obj)
+	return this.Indexer.Add(obj)
+}
+
+func (this *skipTrackingStore) Update(obj interface{}) error {
+	updateSkippedNode(obj)
+	return this.Indexer.Update(obj)
+}
+
+func (this *skipTrackingStore) Delete(obj interface{}) error {
+	if name := nodeName(obj); name != "" {
+		forgetSkippedNode(name)
+	}
+	return this.Indexer.Delete(obj)
+}
+
+func (this *skipTrackingStore) Replace(list []interface{}, resourceVersion string) error {
+	names := make(map[string]bool, len(list))
+	for _, obj := range list {
+		names[nodeName(obj)] = true
+		updateSkippedNode(obj)
+	}
+	for _, obj := range this.Indexer.List() {
+		if name := nodeName(obj); name != "" && !names[name] {
+			forgetSkippedNode(name)
+		}
+	}
+	return this.Indexer.Replace(list, resourceVersion)
+}
+
+func updateSkippedNode(obj interface{}) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	skippedNodes.Lock()
+	defer skippedNodes.Unlock()
+	if IsNodeSkipped(node) {
+		skippedNodes.names[node.Name] = true
+	} else {
+		delete(skippedNodes.names, node.Name)
+	}
+}
+
+func forgetSkippedNode(name string) {
+	skippedNodes.Lock()
+	defer skippedNodes.Unlock()
+	delete(skippedNodes.names, name)
+}
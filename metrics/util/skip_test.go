@@ -0,0 +1,60 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNodeSkipReason(t *testing.T) {
+	annotated := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "annotated", Annotations: map[string]string{SkipNodeAnnotation: "true"}}}
+	master := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master", Labels: map[string]string{"node-role.kubernetes.io/master": ""}}}
+	worker := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker"}}
+
+	assert.Equal(t, "skipped with the metrics-server.kubernetes.io/skip annotation", NodeSkipReason(annotated))
+	assert.False(t, IsNodeSkipped(master), "control plane included by default")
+	assert.True(t, IsControlPlaneNode(master))
+
+	require.NoError(t, SetControlPlaneMode(ControlPlaneExclude))
+	defer SetControlPlaneMode(ControlPlaneInclude)
+	assert.Equal(t, "control plane node excluded with --control_plane_nodes=exclude", NodeSkipReason(master))
+	assert.False(t, IsNodeSkipped(worker))
+	assert.Error(t, SetControlPlaneMode("synthesize"))
+}
+
+func TestSkippedNodeNames(t *testing.T) {
+	store := &skipTrackingStore{cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})}
+	skipped := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "skipped", Annotations: map[string]string{SkipNodeAnnotation: "true"}}}
+	require.NoError(t, store.Replace([]interface{}{skipped, testNode("node1")}, "1"))
+	assert.True(t, IsNodeNameSkipped("skipped"))
+	assert.False(t, IsNodeNameSkipped("node1"))
+
+	require.NoError(t, store.Update(testNode("skipped")))
+	assert.False(t, IsNodeNameSkipped("skipped"), "annotation removed")
+	require.NoError(t, store.Update(skipped))
+	require.NoError(t, store.Delete(skipped))
+	assert.False(t, IsNodeNameSkipped("skipped"), "deleted")
+
+	require.NoError(t, store.Add(skipped))
+	require.NoError(t, store.Replace([]interface{}{testNode("node1")}, "2"))
+	assert.False(t, IsNodeNameSkipped("skipped"), "gone from the relist")
+}
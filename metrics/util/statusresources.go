@@ -0,0 +1,316 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Annotation the pod list watch keeps the resources of the containers reported in the pod
+// status in, as the vendored API predates the allocatedResources and resources of the
+// container statuses of in-place pod resizing.
+const ContainerStatusResourcesAnnotation = "metrics.k8s.io/container-status-resources"
+
+// Resources of a container reported in the pod status.
+type containerStatusResources struct {
+	Name string `json:"name"`
+	// Requests allocated to the container by the node.
+	AllocatedResources corev1.ResourceList `json:"allocatedResources,omitempty"`
+	// Resources the container runs with.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// ContainerResources returns the resources the container runs with. Those reported in the
+// pod status are preferred over the pod spec, which is only the desired state while the pod
+// is resized in place.
+func ContainerResources(pod *corev1.Pod, container *corev1.Container) corev1.ResourceRequirements {
+	result := container.Resources
+	value, found := pod.Annotations[ContainerStatusResourcesAnnotation]
+	if !found {
+		return result
+	}
+	var statuses []containerStatusResources
+	if err := json.Unmarshal([]byte(value), &statuses); err != nil {
+		glog.V(4).Infof("Ignoring the container status resources of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return result
+	}
+	for _, status := range statuses {
+		if status.Name != container.Name {
+			continue
+		}
+		if status.AllocatedResources != nil {
+			result.Requests = status.AllocatedResources
+		}
+		if status.Resources != nil {
+			if status.Resources.Requests != nil {
+				result.Requests = status.Resources.Requests
+			}
+			if status.Resources.Limits != nil {
+				result.Limits = status.Resources.Limits
+			}
+		}
+	}
+	return result
+}
+
+// statusResourcesSerializer decodes pods like the wrapped serializer, and keeps the resources
+// of their container statuses in an annotation.
+type statusResourcesSerializer struct {
+	runtime.NegotiatedSerializer
+}
+
+func (s statusResourcesSerializer) DecoderToVersion(decoder runtime.Decoder, gv runtime.GroupVersioner) runtime.Decoder {
+	return statusResourcesDecoder{s.NegotiatedSerializer.DecoderToVersion(decoder, gv)}
+}
+
+type statusResourcesDecoder struct {
+	runtime.Decoder
+}
+
+func (d statusResourcesDecoder) Decode(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	obj, gvk, err := d.Decoder.Decode(data, defaults, into)
+	if err != nil {
+		return obj, gvk, err
+	}
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		pods := decodeStatusResources(data, false)
+		setStatusResources(o, pods[podKey(o.Namespace, o.Name)])
+	case *corev1.PodList:
+		pods := decodeStatusResources(data, true)
+		for i := range o.Items {
+			setStatusResources(&o.Items[i], pods[podKey(o.Items[i].Namespace, o.Items[i].Name)])
+		}
+	}
+	return obj, gvk, nil
+}
+
+func podKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func setStatusResources(pod *corev1.Pod, statuses []containerStatusResources) {
+	if len(statuses) == 0 {
+		delete(pod.Annotations, ContainerStatusResourcesAnnotation)
+		return
+	}
+	value, err := json.Marshal(statuses)
+	if err != nil {
+		return
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[ContainerStatusResourcesAnnotation] = string(value)
+}
+
+// decodeStatusResources returns the container status resources of the pods encoded in the
+// data, in JSON or protobuf, by pod key. Containers without status resources are left out.
+func decodeStatusResources(data []byte, list bool) map[string][]containerStatusResources {
+	var pods []podStatusResources
+	switch {
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		pods = decodeJSONStatusResources(data, list)
+	case bytes.HasPrefix(data, protobufPrefix):
+		pods = decodeProtobufStatusResources(data[len(protobufPrefix):], list)
+	}
+	result := make(map[string][]containerStatusResources, len(pods))
+	for _, pod := range pods {
+		var statuses []containerStatusResources
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.AllocatedResources != nil || status.Resources != nil {
+				statuses = append(statuses, status)
+			}
+		}
+		if len(statuses) > 0 {
+			result[podKey(pod.Metadata.Namespace, pod.Metadata.Name)] = statuses
+		}
+	}
+	return result
+}
+
+// The fields of a pod the status resources are decoded from.
+type podStatusResources struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Status struct {
+		ContainerStatuses []containerStatusResources `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+func decodeJSONStatusResources(data []byte, list bool) []podStatusResources {
+	if !list {
+		var pod podStatusResources
+		if err := json.Unmarshal(data, &pod); err != nil {
+			return nil
+		}
+		return []podStatusResources{pod}
+	}
+	var podList struct {
+		Items []podStatusResources `json:"items"`
+	}
+	if err := json.Unmarshal(data, &podList); err != nil {
+		return nil
+	}
+	return podList.Items
+}
+
+// Magic number the protobuf encoding of the API starts with, followed by a runtime.Unknown.
+var protobufPrefix = []byte{0x6b, 0x38, 0x73, 0x00}
+
+// Field numbers in the protobuf encoding of the API.
+const (
+	unknownRawField                   = 2
+	podListItemsField                 = 2
+	podMetadataField                  = 1
+	podStatusField                    = 3
+	objectMetaNameField               = 1
+	objectMetaNamespaceField          = 3
+	podStatusContainerStatusesField   = 8
+	containerStatusNameField          = 1
+	containerStatusAllocatedField     = 10
+	containerStatusResourcesField     = 11
+	resourceRequirementsLimitsField   = 1
+	resourceRequirementsRequestsField = 2
+	mapEntryKeyField                  = 1
+	mapEntryValueField                = 2
+	quantityStringField               = 1
+)
+
+func decodeProtobufStatusResources(data []byte, list bool) []podStatusResources {
+	raw := lastProtobufField(data, unknownRawField)
+	if !list {
+		return []podStatusResources{decodeProtobufPod(raw)}
+	}
+	var result []podStatusResources
+	forEachProtobufField(raw, func(field int, value []byte) {
+		if field == podListItemsField {
+			result = append(result, decodeProtobufPod(value))
+		}
+	})
+	return result
+}
+
+func decodeProtobufPod(data []byte) podStatusResources {
+	var pod podStatusResources
+	metadata := lastProtobufField(data, podMetadataField)
+	pod.Metadata.Name = string(lastProtobufField(metadata, objectMetaNameField))
+	pod.Metadata.Namespace = string(lastProtobufField(metadata, objectMetaNamespaceField))
+	forEachProtobufField(lastProtobufField(data, podStatusField), func(field int, value []byte) {
+		if field != podStatusContainerStatusesField {
+			return
+		}
+		status := containerStatusResources{}
+		forEachProtobufField(value, func(field int, value []byte) {
+			switch field {
+			case containerStatusNameField:
+				status.Name = string(value)
+			case containerStatusAllocatedField:
+				if status.AllocatedResources == nil {
+					status.AllocatedResources = corev1.ResourceList{}
+				}
+				addProtobufResource(status.AllocatedResources, value)
+			case containerStatusResourcesField:
+				status.Resources = &corev1.ResourceRequirements{}
+				forEachProtobufField(value, func(field int, value []byte) {
+					switch field {
+					case resourceRequirementsLimitsField:
+						if status.Resources.Limits == nil {
+							status.Resources.Limits = corev1.ResourceList{}
+						}
+						addProtobufResource(status.Resources.Limits, value)
+					case resourceRequirementsRequestsField:
+						if status.Resources.Requests == nil {
+							status.Resources.Requests = corev1.ResourceList{}
+						}
+						addProtobufResource(status.Resources.Requests, value)
+					}
+				})
+			}
+		})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, status)
+	})
+	return pod
+}
+
+// addProtobufResource adds the map entry of a resource name and quantity to the list.
+func addProtobufResource(list corev1.ResourceList, entry []byte) {
+	name := string(lastProtobufField(entry, mapEntryKeyField))
+	value := string(lastProtobufField(lastProtobufField(entry, mapEntryValueField), quantityStringField))
+	if quantity, err := resource.ParseQuantity(value); err == nil {
+		list[corev1.ResourceName(name)] = quantity
+	}
+}
+
+// lastProtobufField returns the value of the last occurrence of the length-delimited field.
+func lastProtobufField(data []byte, field int) []byte {
+	var result []byte
+	forEachProtobufField(data, func(f int, value []byte) {
+		if f == field {
+			result = value
+		}
+	})
+	return result
+}
+
+// forEachProtobufField calls f with the number and value of every length-delimited field of
+// the message, stopping at the first malformed field.
+func forEachProtobufField(data []byte, f func(field int, value []byte)) {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return
+		}
+		data = data[n:]
+		field, wireType := int(key>>3), key&7
+		switch wireType {
+		case 0: // varint
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return
+			}
+			data = data[n:]
+		case 1: // 64-bit
+			if len(data) < 8 {
+				return
+			}
+			data = data[8:]
+		case 2: // length-delimited
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return
+			}
+			f(field, data[n:n+int(length)])
+			data = data[n+int(length):]
+		case 5: // 32-bit
+			if len(data) < 4 {
+				return
+			}
+			data = data[4:]
+		default:
+			return
+		}
+	}
+}
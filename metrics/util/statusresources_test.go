@@ -0,0 +1,146 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func newStatusResourcesDecoder(t *testing.T, mediaType string) runtime.Decoder {
+	s := statusResourcesSerializer{serializer.DirectCodecFactory{CodecFactory: scheme.Codecs}}
+	info, found := runtime.SerializerInfoForMediaType(s.SupportedMediaTypes(), mediaType)
+	require.True(t, found)
+	return s.DecoderToVersion(info.Serializer, corev1.SchemeGroupVersion)
+}
+
+// A pod resized in place from 250m to 500m cpu, with the spec already updated to 1 cpu.
+const resizedPodJSON = `{
+	"apiVersion": "v1",
+	"kind": "Pod",
+	"metadata": {"name": "p", "namespace": "ns"},
+	"spec": {"containers": [{"name": "c", "resources": {"requests": {"cpu": "1"}}}]},
+	"status": {"containerStatuses": [{
+		"name": "c",
+		"allocatedResources": {"cpu": "250m"},
+		"resources": {"requests": {"cpu": "500m"}, "limits": {"cpu": "2"}}
+	}]}
+}`
+
+func TestStatusResourcesJSON(t *testing.T) {
+	obj, err := runtime.Decode(newStatusResourcesDecoder(t, runtime.ContentTypeJSON), []byte(resizedPodJSON))
+	require.NoError(t, err)
+	pod := obj.(*corev1.Pod)
+	require.Contains(t, pod.Annotations, ContainerStatusResourcesAnnotation)
+
+	resources := ContainerResources(pod, &pod.Spec.Containers[0])
+	assert.Equal(t, resource.MustParse("500m"), resources.Requests[corev1.ResourceCPU], "the status resources are preferred")
+	assert.Equal(t, resource.MustParse("2"), resources.Limits[corev1.ResourceCPU])
+}
+
+func TestStatusResourcesJSONList(t *testing.T) {
+	list := `{"apiVersion": "v1", "kind": "PodList", "items": [` + resizedPodJSON + `, {
+		"metadata": {"name": "other", "namespace": "ns", "annotations": {"` + ContainerStatusResourcesAnnotation + `": "[{\"name\":\"c\",\"allocatedResources\":{\"cpu\":\"64\"}}]"}},
+		"spec": {"containers": [{"name": "c", "resources": {"requests": {"cpu": "100m"}}}]},
+		"status": {"containerStatuses": [{"name": "c"}]}
+	}]}`
+	obj, err := runtime.Decode(newStatusResourcesDecoder(t, runtime.ContentTypeJSON), []byte(list))
+	require.NoError(t, err)
+	pods := obj.(*corev1.PodList).Items
+	require.Len(t, pods, 2)
+
+	resources := ContainerResources(&pods[0], &pods[0].Spec.Containers[0])
+	assert.Equal(t, resource.MustParse("500m"), resources.Requests[corev1.ResourceCPU])
+	assert.NotContains(t, pods[1].Annotations, ContainerStatusResourcesAnnotation, "set only from the status")
+	resources = ContainerResources(&pods[1], &pods[1].Spec.Containers[0])
+	assert.Equal(t, resource.MustParse("100m"), resources.Requests[corev1.ResourceCPU], "the spec without status resources")
+}
+
+// protobufField encodes a length-delimited protobuf field.
+func protobufField(field int, value []byte) []byte {
+	key := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(key, uint64(field<<3|2))
+	length := make([]byte, binary.MaxVarintLen64)
+	m := binary.PutUvarint(length, uint64(len(value)))
+	return append(append(key[:n], length[:m]...), value...)
+}
+
+func TestStatusResourcesProtobuf(t *testing.T) {
+	entry := func(name, quantity string) []byte {
+		return append(protobufField(mapEntryKeyField, []byte(name)),
+			protobufField(mapEntryValueField, protobufField(quantityStringField, []byte(quantity)))...)
+	}
+	var status []byte
+	status = append(status, protobufField(containerStatusNameField, []byte("c"))...)
+	// A varint field before the fields of in-place resizing, the restart count.
+	status = append(status, 5<<3, 3)
+	status = append(status, protobufField(containerStatusAllocatedField, entry("cpu", "250m"))...)
+	status = append(status, protobufField(containerStatusResourcesField, append(
+		protobufField(resourceRequirementsLimitsField, entry("memory", "1Gi")),
+		protobufField(resourceRequirementsRequestsField, entry("cpu", "500m"))...))...)
+	var metadata []byte
+	metadata = append(metadata, protobufField(objectMetaNameField, []byte("p"))...)
+	metadata = append(metadata, protobufField(objectMetaNamespaceField, []byte("ns"))...)
+	pod := append(protobufField(podMetadataField, metadata),
+		protobufField(podStatusField, protobufField(podStatusContainerStatusesField, status))...)
+
+	unknown := &runtime.Unknown{TypeMeta: runtime.TypeMeta{APIVersion: "v1", Kind: "Pod"}, Raw: pod}
+	data, err := unknown.Marshal()
+	require.NoError(t, err)
+	obj, err := runtime.Decode(newStatusResourcesDecoder(t, "application/vnd.kubernetes.protobuf"), append(protobufPrefix, data...))
+	require.NoError(t, err)
+
+	decoded := obj.(*corev1.Pod)
+	assert.Equal(t, "p", decoded.Name)
+	require.Len(t, decoded.Status.ContainerStatuses, 1)
+	assert.Equal(t, int32(3), decoded.Status.ContainerStatuses[0].RestartCount)
+	resources := ContainerResources(decoded, &corev1.Container{Name: "c"})
+	assert.Equal(t, resource.MustParse("500m"), resources.Requests[corev1.ResourceCPU])
+	assert.Equal(t, resource.MustParse("1Gi"), resources.Limits[corev1.ResourceMemory])
+}
+
+func TestStatusResourcesMalformedProtobuf(t *testing.T) {
+	assert.Empty(t, decodeStatusResources(append(protobufPrefix, 0x12, 0xff), false))
+	var called bool
+	forEachProtobufField([]byte{0x0a, 0x05, 'a'}, func(int, []byte) { called = true })
+	assert.False(t, called, "truncated fields are ignored")
+}
+
+func TestContainerResourcesWithoutStatus(t *testing.T) {
+	container := corev1.Container{Name: "c", Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+	}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ContainerStatusResourcesAnnotation: "not json"}}}
+	assert.Equal(t, container.Resources, ContainerResources(pod, &container))
+}
+
+func TestTrimPodKeepsStatusResources(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		ContainerStatusResourcesAnnotation: "[]",
+		"other":                            "value",
+	}}}
+	TrimPod(pod)
+	assert.Equal(t, map[string]string{ContainerStatusResourcesAnnotation: "[]"}, pod.Annotations)
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// How long the deletion of a node is remembered, longer than any scrape takes.
+const nodeTombstoneTTL = 10 * time.Minute
+
+// Tombstones of the nodes deleted from the API server, by name, recorded by all node
+// listers. They let scrapes started before the deletion be discarded, and the API stop
+// serving the node before its points leave the stored batches.
+var nodeTombstones = struct {
+	sync.Mutex
+	nodes map[string]time.Time
+}{nodes: map[string]time.Time{}}
+
+func recordNodeTombstone(name string, now time.Time) {
+	nodeTombstones.Lock()
+	defer nodeTombstones.Unlock()
+	for node, deleted := range nodeTombstones.nodes {
+		if now.Sub(deleted) > nodeTombstoneTTL {
+			delete(nodeTombstones.nodes, node)
+		}
+	}
+	nodeTombstones.nodes[name] = now
+}
+
+func clearNodeTombstone(name string) {
+	nodeTombstones.Lock()
+	defer nodeTombstones.Unlock()
+	delete(nodeTombstones.nodes, name)
+}
+
+// IsNodeDeleted tells whether the node was deleted from the API server less than the
+// tombstone TTL ago and hasn't been created again since.
+func IsNodeDeleted(name string) bool {
+	return isNodeDeletedAt(name, time.Now())
+}
+
+func isNodeDeletedAt(name string, now time.Time) bool {
+	nodeTombstones.Lock()
+	defer nodeTombstones.Unlock()
+	deleted, found := nodeTombstones.nodes[name]
+	return found && now.Sub(deleted) <= nodeTombstoneTTL
+}
+
+// tombstoningStore records a tombstone for every node deleted from the store, whether by a
+// watch event or by a relist which no longer has it.
+type tombstoningStore struct {
+	cache.Indexer
+}
+
+func nodeName(obj interface{}) string {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if node, ok := obj.(*corev1.Node); ok {
+		return node.Name
+	}
+	return ""
+}
+
+func (this *tombstoningStore) Add(obj interface{}) error {
+	clearNodeTombstone(nodeName(obj))
+	return this.Indexer.Add(obj)
+}
+
+func (this *tombstoningStore) Update(obj interface{}) error {
+	clearNodeTombstone(nodeName(obj))
+	return this.Indexer.Update(obj)
+}
+
+func (this *tombstoningStore) Delete(obj interface{}) error {
+	if name := nodeName(obj); name != "" {
+		recordNodeTombstone(name, time.Now())
+	}
+	return this.Indexer.Delete(obj)
+}
+
+func (this *tombstoningStore) Replace(list []interface{}, resourceVersion string) error {
+	names := make(map[string]bool, len(list))
+	for _, obj := range list {
+		name := nodeName(obj)
+		names[name] = true
+		clearNodeTombstone(name)
+	}
+	now := time.Now()
+	for _, obj := range this.Indexer.List() {
+		if name := nodeName(obj); name != "" && !names[name] {
+			recordNodeTombstone(name, now)
+		}
+	}
+	return this.Indexer.Replace(list, resourceVersion)
+}
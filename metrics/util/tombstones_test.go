@@ -0,0 +1,53 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestTombstoningStore(t *testing.T) {
+	store := &tombstoningStore{cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})}
+	require.NoError(t, store.Replace([]interface{}{testNode("node1"), testNode("node2")}, "1"))
+	assert.False(t, IsNodeDeleted("node1"))
+
+	require.NoError(t, store.Delete(testNode("node1")))
+	assert.True(t, IsNodeDeleted("node1"))
+	require.NoError(t, store.Add(testNode("node1")))
+	assert.False(t, IsNodeDeleted("node1"), "created again")
+
+	require.NoError(t, store.Replace([]interface{}{testNode("node1")}, "2"))
+	assert.True(t, IsNodeDeleted("node2"), "gone after relist")
+	defer clearNodeTombstone("node2")
+
+	recordNodeTombstone("node3", time.Now().Add(-2*nodeTombstoneTTL))
+	recordNodeTombstone("node4", time.Now())
+	defer clearNodeTombstone("node4")
+	assert.False(t, IsNodeDeleted("node3"), "expired")
+}
+
+func TestNodeTombstoneTTL(t *testing.T) {
+	now := time.Now()
+	recordNodeTombstone("node1", now)
+	defer clearNodeTombstone("node1")
+	assert.True(t, isNodeDeletedAt("node1", now.Add(nodeTombstoneTTL)))
+	assert.False(t, isNodeDeletedAt("node1", now.Add(nodeTombstoneTTL+time.Second)), "expired, even if not pruned yet")
+}
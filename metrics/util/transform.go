@@ -0,0 +1,151 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Annotation written by kubectl apply with a copy of the whole object.
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// trimNode clears the fields of the node which are never read, but can make up most of
+// its size, like the images present on the node.
+func trimNode(obj runtime.Object) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	node.Status.Images = nil
+	node.Status.VolumesInUse = nil
+	node.Status.VolumesAttached = nil
+	delete(node.Annotations, lastAppliedConfigAnnotation)
+}
+
+// TrimPod keeps only the fields of the pod needed to attribute and serve its metrics:
+// the metadata without annotations but the container status resources, the node, priority
+// class and containers with their images and resources, and the phase, start time and
+// container restart counts and running states. It's applied to the pods of the lister
+// returned by GetPodLister.
+func TrimPod(obj runtime.Object) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	if value, found := pod.Annotations[ContainerStatusResourcesAnnotation]; found {
+		pod.Annotations = map[string]string{ContainerStatusResourcesAnnotation: value}
+	} else {
+		pod.Annotations = nil
+	}
+	pod.Spec = corev1.PodSpec{
+		NodeName:          pod.Spec.NodeName,
+		PriorityClassName: pod.Spec.PriorityClassName,
+		InitContainers:    trimContainers(pod.Spec.InitContainers),
+		Containers:        trimContainers(pod.Spec.Containers),
+	}
+	pod.Status = corev1.PodStatus{
+		Phase:                 pod.Status.Phase,
+		StartTime:             pod.Status.StartTime,
+		InitContainerStatuses: trimContainerStatuses(pod.Status.InitContainerStatuses),
+		ContainerStatuses:     trimContainerStatuses(pod.Status.ContainerStatuses),
+	}
+}
+
+func trimContainers(containers []corev1.Container) []corev1.Container {
+	if len(containers) == 0 {
+		return nil
+	}
+	result := make([]corev1.Container, 0, len(containers))
+	for _, c := range containers {
+		result = append(result, corev1.Container{
+			Name:      c.Name,
+			Image:     c.Image,
+			Resources: c.Resources,
+		})
+	}
+	return result
+}
+
+func trimContainerStatuses(statuses []corev1.ContainerStatus) []corev1.ContainerStatus {
+	if len(statuses) == 0 {
+		return nil
+	}
+	result := make([]corev1.ContainerStatus, 0, len(statuses))
+	for _, s := range statuses {
+		status := corev1.ContainerStatus{
+			Name:         s.Name,
+			RestartCount: s.RestartCount,
+		}
+		if s.State.Running != nil {
+			status.State.Running = &corev1.ContainerStateRunning{StartedAt: s.State.Running.StartedAt}
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// newTransformingListWatch wraps the list watch, so that the transform is applied to every
+// listed and watched object before it's cached.
+func newTransformingListWatch(lw cache.ListerWatcher, transform func(runtime.Object)) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := lw.List(options)
+			if err != nil {
+				return nil, err
+			}
+			err = meta.EachListItem(list, func(obj runtime.Object) error {
+				transform(obj)
+				return nil
+			})
+			return list, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := lw.Watch(options)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+				if event.Type != watch.Error {
+					transform(event.Object)
+				}
+				return event, true
+			}), nil
+		},
+	}
+}
+
+// withLabelSelector wraps the list watch, so that only objects matching the selector are
+// listed and watched.
+func withLabelSelector(lw *cache.ListWatch, selector labels.Selector) *cache.ListWatch {
+	if selector.Empty() {
+		return lw
+	}
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector.String()
+			return lw.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector.String()
+			return lw.Watch(options)
+		},
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testNode(name string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"zone": "a"},
+			Annotations: map[string]string{
+				lastAppliedConfigAnnotation:  "{}",
+				"metrics.k8s.io/maintenance": "true",
+			},
+		},
+		Status: corev1.NodeStatus{
+			Addresses:    []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+			Conditions:   []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			Images:       []corev1.ContainerImage{{Names: []string{"image"}, SizeBytes: 1000}},
+			VolumesInUse: []corev1.UniqueVolumeName{"volume"},
+		},
+	}
+}
+
+func checkTrimmed(t *testing.T, node *corev1.Node) {
+	assert.Empty(t, node.Status.Images)
+	assert.Empty(t, node.Status.VolumesInUse)
+	assert.NotContains(t, node.Annotations, lastAppliedConfigAnnotation)
+	assert.Equal(t, "true", node.Annotations["metrics.k8s.io/maintenance"])
+	assert.Equal(t, "a", node.Labels["zone"])
+	assert.Len(t, node.Status.Addresses, 1)
+	assert.Len(t, node.Status.Conditions, 1)
+}
+
+func TestTransformingListWatch(t *testing.T) {
+	fake := watch.NewFake()
+	lw := newTransformingListWatch(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return &corev1.NodeList{Items: []corev1.Node{*testNode("n1")}}, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return fake, nil
+		},
+	}, trimNode)
+
+	obj, err := lw.List(metav1.ListOptions{})
+	require.NoError(t, err)
+	list := obj.(*corev1.NodeList)
+	require.Len(t, list.Items, 1)
+	checkTrimmed(t, &list.Items[0])
+
+	w, err := lw.Watch(metav1.ListOptions{})
+	require.NoError(t, err)
+	defer w.Stop()
+	go fake.Add(testNode("n2"))
+	event := <-w.ResultChan()
+	assert.Equal(t, watch.Added, event.Type)
+	checkTrimmed(t, event.Object.(*corev1.Node))
+}
+
+func TestWithLabelSelector(t *testing.T) {
+	var selectors []string
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			selectors = append(selectors, options.LabelSelector)
+			return &corev1.NodeList{}, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			selectors = append(selectors, options.LabelSelector)
+			return watch.NewFake(), nil
+		},
+	}
+	assert.Equal(t, lw, withLabelSelector(lw, labels.Everything()))
+
+	selector, err := labels.Parse("kubernetes.io/os!=windows")
+	require.NoError(t, err)
+	filtered := withLabelSelector(lw, selector)
+	_, err = filtered.List(metav1.ListOptions{})
+	require.NoError(t, err)
+	_, err = filtered.Watch(metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kubernetes.io/os!=windows", "kubernetes.io/os!=windows"}, selectors)
+}
+
+func TestNodeSelectorOption(t *testing.T) {
+	uri := &url.URL{Scheme: "https", Host: "kubernetes.default", RawQuery: "useServiceAccount=true"}
+	assert.Equal(t, uri, WithNodeSelector(uri, ""))
+	selector, err := ParseNodeSelector(uri.Query())
+	require.NoError(t, err)
+	assert.True(t, selector.Empty(), "all nodes by default")
+
+	selector, err = ParseNodeSelector(WithNodeSelector(uri, "kubernetes.io/os!=windows").Query())
+	require.NoError(t, err)
+	assert.Equal(t, "kubernetes.io/os!=windows", selector.String())
+	assert.Equal(t, "useServiceAccount=true", uri.RawQuery, "the URI is copied")
+	_, err = ParseNodeSelector(url.Values{"nodeSelector": {"-zone"}})
+	assert.Error(t, err)
+}
+
+func TestTrimPod(t *testing.T) {
+	started := metav1.NewTime(time.Now())
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "pod1",
+			Namespace:       "ns1",
+			UID:             "uid",
+			Labels:          map[string]string{"app": "a"},
+			Annotations:     map[string]string{lastAppliedConfigAnnotation: "{}"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "rs"}},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:          "node1",
+			PriorityClassName: "high",
+			Volumes:           []corev1.Volume{{Name: "data"}},
+			InitContainers:    []corev1.Container{{Name: "proxy", Image: "proxy", Command: []string{"proxy"}}},
+			Containers: []corev1.Container{{
+				Name:  "c1",
+				Image: "image",
+				Env:   []corev1.EnvVar{{Name: "A", Value: "B"}},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: *resource.NewMilliQuantity(100, resource.DecimalSI)},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			StartTime:  &started,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:         "c1",
+				RestartCount: 3,
+				ImageID:      "sha256:1234",
+			}},
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "proxy",
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}},
+		},
+	}
+	TrimPod(pod)
+
+	assert.Equal(t, "pod1", pod.Name)
+	assert.Equal(t, "a", pod.Labels["app"])
+	assert.Len(t, pod.OwnerReferences, 1)
+	assert.Nil(t, pod.Annotations)
+	assert.Equal(t, "node1", pod.Spec.NodeName)
+	assert.Equal(t, "high", pod.Spec.PriorityClassName)
+	assert.Empty(t, pod.Spec.Volumes)
+	assert.Equal(t, []corev1.Container{{Name: "proxy", Image: "proxy"}}, pod.Spec.InitContainers)
+	require.Len(t, pod.Spec.Containers, 1)
+	assert.Equal(t, "image", pod.Spec.Containers[0].Image)
+	assert.Empty(t, pod.Spec.Containers[0].Env)
+	assert.Equal(t, int64(100), pod.Spec.Containers[0].Resources.Requests.Cpu().MilliValue())
+	assert.Equal(t, corev1.PodRunning, pod.Status.Phase)
+	assert.Equal(t, &started, pod.Status.StartTime, "needed to withhold young pods")
+	assert.Empty(t, pod.Status.Conditions)
+	assert.Equal(t, []corev1.ContainerStatus{{Name: "c1", RestartCount: 3}}, pod.Status.ContainerStatuses)
+	assert.Len(t, SidecarContainers(pod), 1, "sidecars are still detected")
+}
@@ -18,10 +18,15 @@ import (
 	"fmt"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	v1listers "k8s.io/client-go/listers/core/v1"
+	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"net/url"
 	"sort"
 	"strings"
 	"time"
@@ -45,12 +50,70 @@ func SetLabelSeperator(seperator string) {
 	labelSeperator = seperator
 }
 
-func GetNodeLister(kubeClient *kube_client.Clientset) (v1listers.NodeLister, *cache.Reflector, error) {
-	lw := cache.NewListWatchFromClient(kubeClient.Core().RESTClient(), "nodes", corev1.NamespaceAll, fields.Everything())
+// WithNodeSelector returns a copy of the URI setting the nodeSelector option to the label
+// selector, unless it's empty. It restricts the nodes listed by the sources and processors
+// of the URI, so that several instances can share the nodes of a cluster.
+func WithNodeSelector(uri *url.URL, selector string) *url.URL {
+	if selector == "" {
+		return uri
+	}
+	result := *uri
+	opts := result.Query()
+	opts.Set("nodeSelector", selector)
+	result.RawQuery = opts.Encode()
+	return &result
+}
+
+// ParseNodeSelector returns the label selector of the nodeSelector option, selecting all
+// nodes if it isn't set.
+func ParseNodeSelector(opts url.Values) (labels.Selector, error) {
+	parsed, err := labels.Parse(opts.Get("nodeSelector"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid nodeSelector: %v", err)
+	}
+	return parsed, nil
+}
+
+// GetNodeLister returns a lister of the nodes matching the node selector, cached without
+// the fields which are never read. The handlers are told about every node added to, updated
+// in or deleted from the cache.
+func GetNodeLister(kubeClient *kube_client.Clientset, nodeSelector labels.Selector, handlers ...cache.ResourceEventHandler) (v1listers.NodeLister, *cache.Reflector, error) {
+	lw := newTransformingListWatch(withLabelSelector(cache.NewListWatchFromClient(kubeClient.Core().RESTClient(), "nodes", corev1.NamespaceAll, fields.Everything()), nodeSelector), trimNode)
 	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
 	nodeLister := v1listers.NewNodeLister(store)
-	reflector := cache.NewReflector(lw, &corev1.Node{}, store, time.Hour)
+	reflector := cache.NewReflector(lw, &corev1.Node{}, &tombstoningStore{&skipTrackingStore{&notifyingStore{store, handlers}}}, time.Hour)
 	go reflector.Run(wait.NeverStop)
 
 	return nodeLister, reflector, nil
 }
+
+// NewPodListWatch returns a list watch of all pods, trimmed to the fields needed to
+// attribute and serve their metrics, with the resources of their container statuses.
+func NewPodListWatch(kubeConfig *restclient.Config) (cache.ListerWatcher, error) {
+	config := *kubeConfig
+	config.GroupVersion = &corev1.SchemeGroupVersion
+	config.APIPath = "/api"
+	config.NegotiatedSerializer = statusResourcesSerializer{serializer.DirectCodecFactory{CodecFactory: scheme.Codecs}}
+	if config.UserAgent == "" {
+		config.UserAgent = restclient.DefaultKubernetesUserAgent()
+	}
+	client, err := restclient.RESTClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return newTransformingListWatch(cache.NewListWatchFromClient(client, "pods", corev1.NamespaceAll, fields.Everything()), TrimPod), nil
+}
+
+// GetPodLister returns a lister of all pods, trimmed like by NewPodListWatch.
+func GetPodLister(kubeConfig *restclient.Config) (v1listers.PodLister, *cache.Reflector, error) {
+	lw, err := NewPodListWatch(kubeConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	podLister := v1listers.NewPodLister(store)
+	reflector := cache.NewReflector(lw, &corev1.Pod{}, store, time.Hour)
+	go reflector.Run(wait.NeverStop)
+
+	return podLister, reflector, nil
+}